@@ -0,0 +1,263 @@
+// Package analysis answers questions about how policies respond to their
+// input data, by evaluating deliberately varied payloads.
+package analysis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/policydata"
+)
+
+// defaultMaxEvaluations bounds a sensitivity run when PerturbConfig leaves
+// MaxEvaluations unset
+const defaultMaxEvaluations = 1000
+
+// MutationKind is the way a field was changed
+type MutationKind string
+
+const (
+	// Removed deletes the field
+	Removed MutationKind = "removed"
+	// Nulled sets the field to null
+	Nulled MutationKind = "nulled"
+	// Perturbed replaces the field with a nearby or alternative value
+	Perturbed MutationKind = "perturbed"
+)
+
+// PerturbConfig controls the values tried for each field
+type PerturbConfig struct {
+	// NumericDeltas are added to numeric fields; nil means -1 and +1
+	NumericDeltas []float64
+	// StringAlternatives lists replacement values per field path; string
+	// fields without an entry are only removed and nulled
+	StringAlternatives map[string][]string
+	// MaxEvaluations caps the evaluations a run may issue, baseline included;
+	// zero means 1000
+	MaxEvaluations int
+}
+
+// Mutation is one change applied to the baseline payload
+type Mutation struct {
+	Field string
+	Kind  MutationKind
+	// Value is the replacement for Perturbed mutations
+	Value interface{}
+}
+
+func (m Mutation) String() string {
+	if m.Kind == Perturbed {
+		return fmt.Sprintf("%s %s to %v", m.Field, m.Kind, m.Value)
+	}
+	return fmt.Sprintf("%s %s", m.Field, m.Kind)
+}
+
+// MutationResult is the outcome of evaluating one mutation
+type MutationResult struct {
+	Mutation
+	Result bool
+	Labels map[string]bool
+	// ResultFlipped reports that the rule result differs from the baseline
+	ResultFlipped bool
+	// FlippedLabels lists labels whose value differs from the baseline
+	FlippedLabels []string
+	// Err is set when the mutated payload could not be evaluated, e.g. the
+	// engine rejected a missing property; such mutations never count as flips
+	Err error
+}
+
+// Flipped reports whether the mutation changed the result or any label
+func (r MutationResult) Flipped() bool {
+	return r.ResultFlipped || len(r.FlippedLabels) > 0
+}
+
+// FieldSensitivity collects the mutations tried for one field
+type FieldSensitivity struct {
+	Field     string
+	Mutations []MutationResult
+}
+
+// Sensitive reports whether any mutation of the field changed the outcome
+func (f FieldSensitivity) Sensitive() bool {
+	for _, m := range f.Mutations {
+		if m.Flipped() {
+			return true
+		}
+	}
+	return false
+}
+
+// SensitivityReport describes which fields affect a rule's outcome
+type SensitivityReport struct {
+	Baseline *client.PolicyResponse
+	// Fields is in the order the fields were requested
+	Fields []FieldSensitivity
+}
+
+// SensitiveFields lists the fields for which some mutation changed the outcome
+func (r *SensitivityReport) SensitiveFields() []string {
+	var fields []string
+	for _, field := range r.Fields {
+		if field.Sensitive() {
+			fields = append(fields, field.Field)
+		}
+	}
+	return fields
+}
+
+// Sensitivity evaluates rule against data, then against copies of data with
+// each listed field (a dotted path such as "Order.total") removed, nulled and
+// perturbed, and reports which mutations flipped the result or any label.
+// All evaluations are issued as one batch, capped by perturb.MaxEvaluations.
+func Sensitivity(ctx context.Context, e client.Evaluator, rule string, data interface{}, fields []string, perturb PerturbConfig) (*SensitivityReport, error) {
+	base, err := policydata.Merge(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("analysis: invalid data: %w", err)
+	}
+
+	var mutations []Mutation
+	docs := []interface{}{base}
+	for _, field := range fields {
+		current, ok := policydata.Lookup(base, field)
+		if !ok {
+			return nil, fmt.Errorf("analysis: field %q not found in data", field)
+		}
+		for _, mutation := range mutationsFor(field, current, perturb) {
+			doc, err := mutate(base, mutation)
+			if err != nil {
+				return nil, err
+			}
+			mutations = append(mutations, mutation)
+			docs = append(docs, doc)
+		}
+	}
+
+	limit := perturb.MaxEvaluations
+	if limit <= 0 {
+		limit = defaultMaxEvaluations
+	}
+	if len(docs) > limit {
+		return nil, fmt.Errorf("analysis: %d evaluations needed, over the limit of %d", len(docs), limit)
+	}
+
+	results, _ := client.EvaluateBatchWith(ctx, e, rule, docs)
+	baseline := results[0].Response
+	if err := responseError(results[0]); err != nil {
+		return nil, fmt.Errorf("analysis: baseline evaluation failed: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &SensitivityReport{Baseline: baseline}
+	byField := map[string]int{}
+	for _, field := range fields {
+		if _, seen := byField[field]; !seen {
+			byField[field] = len(report.Fields)
+			report.Fields = append(report.Fields, FieldSensitivity{Field: field})
+		}
+	}
+	for i, mutation := range mutations {
+		result := compare(baseline, results[i+1])
+		result.Mutation = mutation
+		entry := &report.Fields[byField[mutation.Field]]
+		entry.Mutations = append(entry.Mutations, result)
+	}
+	return report, nil
+}
+
+// mutationsFor lists the mutations tried for a field holding current
+func mutationsFor(field string, current interface{}, perturb PerturbConfig) []Mutation {
+	mutations := []Mutation{{Field: field, Kind: Removed}, {Field: field, Kind: Nulled}}
+
+	switch value := current.(type) {
+	case bool:
+		mutations = append(mutations, Mutation{Field: field, Kind: Perturbed, Value: !value})
+	case string:
+		for _, alternative := range perturb.StringAlternatives[field] {
+			if alternative != value {
+				mutations = append(mutations, Mutation{Field: field, Kind: Perturbed, Value: alternative})
+			}
+		}
+	default:
+		if number, ok := policydata.ToFloat(current); ok {
+			deltas := perturb.NumericDeltas
+			if deltas == nil {
+				deltas = []float64{-1, 1}
+			}
+			for _, delta := range deltas {
+				mutations = append(mutations, Mutation{Field: field, Kind: Perturbed, Value: number + delta})
+			}
+		}
+	}
+	return mutations
+}
+
+// mutate returns a copy of base with the mutation applied
+func mutate(base map[string]interface{}, mutation Mutation) (map[string]interface{}, error) {
+	doc, err := policydata.Merge(base, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := strings.Split(mutation.Field, ".")
+	parent := doc
+	for _, segment := range segments[:len(segments)-1] {
+		parent = parent[segment].(map[string]interface{})
+	}
+	key := segments[len(segments)-1]
+
+	switch mutation.Kind {
+	case Removed:
+		delete(parent, key)
+	case Nulled:
+		parent[key] = nil
+	default:
+		parent[key] = mutation.Value
+	}
+	return doc, nil
+}
+
+// compare diffs a mutation's response against the baseline
+func compare(baseline *client.PolicyResponse, item client.BatchResult) MutationResult {
+	if err := responseError(item); err != nil {
+		return MutationResult{Err: err}
+	}
+	response := item.Response
+
+	result := MutationResult{
+		Result:        response.Result,
+		Labels:        response.Labels,
+		ResultFlipped: response.Result != baseline.Result,
+	}
+	labels := map[string]bool{}
+	for label := range baseline.Labels {
+		labels[label] = true
+	}
+	for label := range response.Labels {
+		labels[label] = true
+	}
+	for label := range labels {
+		if baseline.Labels[label] != response.Labels[label] {
+			result.FlippedLabels = append(result.FlippedLabels, label)
+		}
+	}
+	sort.Strings(result.FlippedLabels)
+	return result
+}
+
+// responseError reports why an item has no usable response: the engine's own
+// error message, or the item's error when the request itself failed
+func responseError(item client.BatchResult) error {
+	if item.Err != nil {
+		return item.Err
+	}
+	if item.Response.Error != nil {
+		return errors.New(*item.Response.Error)
+	}
+	return nil
+}
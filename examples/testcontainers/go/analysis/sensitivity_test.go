@@ -0,0 +1,116 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"policy-engine-testcontainer-example/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newShippingEngine serves a stand-in for the expedited shipping rule: an
+// order over 100 from a gold or platinum customer qualifies, and a missing
+// property is an engine error
+func newShippingEngine(t *testing.T, calls *int64) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(calls, 1)
+
+		var req struct {
+			Data struct {
+				Order    map[string]interface{} `json:"Order"`
+				Customer map[string]interface{} `json:"Customer"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		total, okTotal := req.Data.Order["total"].(float64)
+		level, okLevel := req.Data.Customer["membership_level"].(string)
+		if !okTotal || !okLevel {
+			message := "property not found"
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(client.PolicyResponse{Error: &message})
+			return
+		}
+
+		result := total > 100 && (level == "gold" || level == "platinum")
+		_ = json.NewEncoder(w).Encode(client.PolicyResponse{
+			Result: result,
+			Labels: map[string]bool{"priority": result && level == "platinum"},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func shippingData() map[string]interface{} {
+	return map[string]interface{}{
+		"Order":    map[string]interface{}{"total": 150, "gift_wrap": true},
+		"Customer": map[string]interface{}{"membership_level": "gold", "favourite_colour": "blue"},
+	}
+}
+
+// TestSensitivity tests that only the fields the rule reads are reported as sensitive
+func TestSensitivity(t *testing.T) {
+	var calls int64
+	engine := newShippingEngine(t, &calls)
+	c, err := client.New(engine.URL)
+	require.NoError(t, err)
+
+	report, err := Sensitivity(context.Background(), c, "rule", shippingData(),
+		[]string{"Order.total", "Customer.membership_level", "Customer.favourite_colour", "Order.gift_wrap"},
+		PerturbConfig{
+			NumericDeltas: []float64{-60, 60},
+			StringAlternatives: map[string][]string{
+				"Customer.membership_level": {"silver", "platinum"},
+				"Customer.favourite_colour": {"red"},
+			},
+		})
+	require.NoError(t, err)
+
+	assert.True(t, report.Baseline.Result)
+	assert.Equal(t, []string{"Order.total", "Customer.membership_level"}, report.SensitiveFields())
+
+	// baseline + total(2+2) + level(2+2) + colour(2+1) + gift_wrap(2+1)
+	assert.Equal(t, int64(15), atomic.LoadInt64(&calls))
+
+	total := report.Fields[0]
+	require.Len(t, total.Mutations, 4)
+	assert.Error(t, total.Mutations[0].Err, "removing total is an engine error, not a flip")
+	assert.False(t, total.Mutations[0].Flipped())
+	assert.True(t, total.Mutations[2].ResultFlipped, "150-60 no longer qualifies")
+	assert.False(t, total.Mutations[3].Flipped())
+
+	level := report.Fields[1]
+	require.Len(t, level.Mutations, 4)
+	assert.Equal(t, "Customer.membership_level perturbed to silver", level.Mutations[2].String())
+	assert.True(t, level.Mutations[2].ResultFlipped)
+	assert.False(t, level.Mutations[3].ResultFlipped)
+	assert.Equal(t, []string{"priority"}, level.Mutations[3].FlippedLabels, "platinum only changes a label")
+}
+
+// TestSensitivityLimits tests bad field paths and the evaluation cap
+func TestSensitivityLimits(t *testing.T) {
+	var calls int64
+	engine := newShippingEngine(t, &calls)
+	c, err := client.New(engine.URL)
+	require.NoError(t, err)
+
+	_, err = Sensitivity(context.Background(), c, "rule", shippingData(), []string{"Order.missing"}, PerturbConfig{})
+	assert.ErrorContains(t, err, `field "Order.missing" not found`)
+
+	_, err = Sensitivity(context.Background(), c, "rule", shippingData(), []string{"Order.total"}, PerturbConfig{MaxEvaluations: 3})
+	assert.ErrorContains(t, err, "over the limit of 3")
+	assert.Zero(t, atomic.LoadInt64(&calls))
+}
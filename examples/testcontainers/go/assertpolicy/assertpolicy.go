@@ -0,0 +1,134 @@
+// Package assertpolicy asserts on evaluations in tests. Each helper asks the
+// engine for a trace, and a failed assertion prints the trace condition by
+// condition instead of the raw response:
+//
+//	assertpolicy.True(t, c, seniorRule, data)
+//
+//	assertpolicy: senior_discount is false, want true
+//	rule senior_discount of Person: FAIL
+//	  FAIL  Person  $.Person.age  GreaterThanOrEqual  expected 65  actual 30
+//
+// The helpers work with *testing.T and *testing.B, and like testify's assert
+// they mark the test failed and carry on, returning whether they passed.
+package assertpolicy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"text/tabwriter"
+
+	"policy-engine-testcontainer-example/client"
+)
+
+// True asserts that rule's result for data is true
+func True(t testing.TB, e client.Evaluator, rule string, data interface{}) bool {
+	t.Helper()
+	return result(t, e, client.PolicyRequest{Rule: rule, Data: data, Trace: true}, true)
+}
+
+// False asserts that rule's result for data is false
+func False(t testing.TB, e client.Evaluator, rule string, data interface{}) bool {
+	t.Helper()
+	return result(t, e, client.PolicyRequest{Rule: rule, Data: data, Trace: true}, false)
+}
+
+// Label asserts that the policy made of rules, joined as EvaluateRules joins
+// them, grants label for data if want is true and denies it if not
+func Label(t testing.TB, e client.Evaluator, rules []string, data interface{}, label string, want bool) bool {
+	t.Helper()
+	response, ok := evaluate(t, e, client.PolicyRequest{Rules: rules, Data: data, Trace: true})
+	if !ok {
+		return false
+	}
+	got, present := response.Labels[label]
+	switch {
+	case !present:
+		t.Errorf("assertpolicy: label %q is not in the response, want %t\n%s", label, want, Breakdown(response))
+		return false
+	case got != want:
+		t.Errorf("assertpolicy: label %q is %t, want %t\n%s", label, got, want, Breakdown(response))
+		return false
+	}
+	return true
+}
+
+// result asserts on the result of req
+func result(t testing.TB, e client.Evaluator, req client.PolicyRequest, want bool) bool {
+	t.Helper()
+	response, ok := evaluate(t, e, req)
+	if !ok {
+		return false
+	}
+	if response.Result != want {
+		t.Errorf("assertpolicy: %s is %t, want %t\n%s", outcome(response), response.Result, want, Breakdown(response))
+		return false
+	}
+	return true
+}
+
+// evaluate sends req, failing t if the evaluation or the engine failed
+func evaluate(t testing.TB, e client.Evaluator, req client.PolicyRequest) (*client.PolicyResponse, bool) {
+	t.Helper()
+	response, err := e.Evaluate(context.Background(), req)
+	if err == nil && response.EngineError != nil {
+		err = response.EngineError
+	}
+	if err != nil {
+		t.Errorf("assertpolicy: failed to evaluate: %v", err)
+		return nil, false
+	}
+	return response, true
+}
+
+// outcome names what the response decided: the outcome of the rule its
+// result comes from, or "the result" without a trace
+func outcome(response *client.PolicyResponse) string {
+	if trace := response.ExecutionTrace; trace != nil && len(trace.Execution) > 0 && trace.Execution[0].Outcome.Value != "" {
+		return trace.Execution[0].Outcome.Value
+	}
+	return "the result"
+}
+
+// Breakdown describes response's trace condition by condition: for each
+// rule evaluated, whether each condition passed, its selector and property,
+// and the expected and actual values it compared. Referenced rules are
+// listed by name. A response without a trace says so, with its labels.
+func Breakdown(response *client.PolicyResponse) string {
+	var b strings.Builder
+	if response.ExecutionTrace == nil || len(response.ExecutionTrace.Execution) == 0 {
+		b.WriteString("the engine sent no trace")
+		if labels := response.GrantedLabels(); len(labels) > 0 {
+			fmt.Fprintf(&b, "; granted labels: %s", strings.Join(labels, ", "))
+		}
+		return b.String()
+	}
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for _, rule := range response.ExecutionTrace.Execution {
+		name := rule.Outcome.Value
+		if rule.Label != "" {
+			name = rule.Label + ". " + name
+		}
+		fmt.Fprintf(w, "rule %s of %s: %s\n", name, rule.Selector.Value, verdict(rule.Result))
+		for i := range rule.Conditions {
+			condition := &rule.Conditions[i]
+			if condition.IsReference() {
+				fmt.Fprintf(w, "  %s\t%s\t%s\t(rule reference)\n", verdict(condition.Result), condition.Selector.Value, condition.RuleName)
+				continue
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\texpected %v\tactual %v\n", verdict(condition.Result), condition.Selector.Value,
+				condition.Property.Path, condition.Operator, condition.Expected(), condition.Actual())
+		}
+	}
+	_ = w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func verdict(passed bool) string {
+	if passed {
+		return "PASS"
+	}
+	return "FAIL"
+}
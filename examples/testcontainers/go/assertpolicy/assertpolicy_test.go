@@ -0,0 +1,133 @@
+package assertpolicy
+
+import (
+	"fmt"
+	"testing"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/enginetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	seniorRule = "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+	adultRule  = "adult. A **Person** is an adult if the __age__ of the **Person** is at least 18."
+	driverRule = "driver. A **Person** can drive if the **Person** is an adult and the __driving_hours__ of the **Person** is at least 20."
+)
+
+// recorder is a testing.TB that records the failures reported to it
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+// seniorTrace is the engine's trace of seniorRule for a 30-year-old
+var seniorTrace = map[string]interface{}{"execution": []interface{}{map[string]interface{}{
+	"selector": map[string]interface{}{"value": "Person"},
+	"outcome":  map[string]interface{}{"value": "senior_discount"},
+	"result":   false,
+	"conditions": []interface{}{map[string]interface{}{
+		"selector": map[string]interface{}{"value": "Person"},
+		"property": map[string]interface{}{"value": 30, "path": "$.Person.age"},
+		"operator": "GreaterThanOrEqual",
+		"value":    map[string]interface{}{"value": 65, "type": "number"},
+		"result":   false,
+	}},
+}}}
+
+// newClient returns a client for a fake engine configured with opts
+func newClient(t *testing.T, opts ...enginetest.Option) *client.PolicyClient {
+	server := enginetest.NewFakeServer(opts...)
+	t.Cleanup(server.Close)
+	c, err := client.New(server.URL)
+	require.NoError(t, err)
+	return c
+}
+
+func person(age, hours int) map[string]interface{} {
+	return map[string]interface{}{"Person": map[string]interface{}{"age": age, "driving_hours": hours}}
+}
+
+// TestAssertions tests the helpers passing
+func TestAssertions(t *testing.T) {
+	c := newClient(t)
+	assert.True(t, True(t, c, seniorRule, person(70, 0)))
+	assert.True(t, False(t, c, seniorRule, person(30, 0)))
+	assert.True(t, Label(t, c, []string{driverRule, adultRule}, person(30, 25), "adult", true))
+	assert.True(t, Label(t, c, []string{driverRule, adultRule}, person(16, 25), "adult", false))
+}
+
+// TestAssertionFailures tests that a failed assertion reports the trace
+// condition by condition
+func TestAssertionFailures(t *testing.T) {
+	c := newClient(t, enginetest.WithStub(seniorRule, client.PolicyResponse{Result: false, Trace: seniorTrace}))
+
+	r := &recorder{}
+	assert.False(t, True(r, c, seniorRule, person(30, 0)))
+	require.Len(t, r.failures, 1)
+	assert.Equal(t, `assertpolicy: senior_discount is false, want true
+rule senior_discount of Person: FAIL
+  FAIL  Person  $.Person.age  GreaterThanOrEqual  expected 65  actual 30`, r.failures[0])
+
+	r = &recorder{}
+	assert.False(t, Label(r, newClient(t), []string{driverRule, adultRule}, person(16, 25), "adult", true))
+	assert.Equal(t, []string{`assertpolicy: label "adult" is false, want true
+the engine sent no trace`}, r.failures)
+
+	r = &recorder{}
+	assert.False(t, Label(r, newClient(t), []string{adultRule}, person(30, 0), "driver", true))
+	assert.Equal(t, []string{`assertpolicy: label "driver" is not in the response, want true
+the engine sent no trace; granted labels: adult`}, r.failures)
+
+	r = &recorder{}
+	assert.False(t, False(r, newClient(t), seniorRule, map[string]interface{}{"Person": map[string]interface{}{}}))
+	require.Len(t, r.failures, 1)
+	assert.Contains(t, r.failures[0], "assertpolicy: failed to evaluate: engine error: ")
+}
+
+// TestBreakdown tests a trace of several rules, one referencing another
+func TestBreakdown(t *testing.T) {
+	trace := &client.Trace{Execution: []client.RuleTrace{
+		{
+			Label: "driver", Selector: client.TraceName{Value: "Person"}, Outcome: client.TraceName{Value: "can drive"},
+			Conditions: []client.ConditionTrace{
+				{Selector: client.TraceName{Value: "Person"}, RuleName: "is an adult", Result: true},
+				{
+					Selector: client.TraceName{Value: "Person"}, Property: &client.PropertyTrace{Value: 5, Path: "$.Person.driving_hours"},
+					Operator: "GreaterThanOrEqual", Value: &client.TypedValue{Value: 20, Type: "number"},
+				},
+			},
+		},
+		{
+			Label: "adult", Selector: client.TraceName{Value: "Person"}, Outcome: client.TraceName{Value: "is an adult"}, Result: true,
+			Conditions: []client.ConditionTrace{{
+				Selector: client.TraceName{Value: "Person"}, Property: &client.PropertyTrace{Value: 30, Path: "$.Person.age"},
+				Operator: "GreaterThanOrEqual", Value: &client.TypedValue{Value: 18, Type: "number"}, Result: true,
+			}},
+		},
+	}}
+	assert.Equal(t, `rule driver. can drive of Person: FAIL
+  PASS  Person  is an adult             (rule reference)
+  FAIL  Person  $.Person.driving_hours  GreaterThanOrEqual  expected 20  actual 5
+rule adult. is an adult of Person: PASS
+  PASS  Person  $.Person.age  GreaterThanOrEqual  expected 18  actual 30`, Breakdown(&client.PolicyResponse{ExecutionTrace: trace}))
+}
+
+// BenchmarkTrue tests that the helpers take a *testing.B
+func BenchmarkTrue(b *testing.B) {
+	server := enginetest.NewFakeServer()
+	defer server.Close()
+	c, err := client.New(server.URL)
+	require.NoError(b, err)
+	for i := 0; i < b.N; i++ {
+		True(b, c, seniorRule, person(70, 0))
+	}
+}
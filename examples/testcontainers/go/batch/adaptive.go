@@ -0,0 +1,201 @@
+package batch
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultAdaptiveMax        = 64
+	defaultAdaptiveBackoff    = 100 * time.Millisecond
+	defaultAdaptiveMaxRetries = 10
+)
+
+// Adaptive runs items in chunks whose size follows the server's back-pressure:
+// every chunk runs all its items at once, and the next chunk is one larger
+// after a clean chunk and half the size after back-pressure (additive
+// increase, multiplicative decrease). Items rejected with back-pressure are
+// re-queued at the front and retried after the pause the server asked for.
+// The zero Adaptive starts at one item, grows to 64 and never aborts.
+type Adaptive struct {
+	// Min and Max bound the chunk size; Min defaults to 1 and Max to 64
+	Min, Max int
+	// Initial is the first chunk size; it defaults to Min
+	Initial int
+	// LatencyThreshold, when set, treats a chunk whose slowest item took
+	// longer as back-pressure too
+	LatencyThreshold time.Duration
+	// Overloaded reports whether an item's error is back-pressure, such as
+	// an HTTP 429, and how long the server asked to be left alone
+	Overloaded func(err error) (retryAfter time.Duration, ok bool)
+	// Backoff is the pause after back-pressure that asked for none; default
+	// 100ms
+	Backoff time.Duration
+	// MaxRetries is how often one item is re-queued before its back-pressure
+	// error is recorded as its result; default 10
+	MaxRetries int
+	// MaxConsecutiveFailures aborts the run after this many failures in a
+	// row, checked between chunks; zero or less never aborts
+	MaxConsecutiveFailures int
+	// Progress, when set, is called after every item completes or is
+	// re-queued, and when a back-off starts and ends
+	Progress func(Progress)
+}
+
+func (a *Adaptive) withDefaults() Adaptive {
+	cfg := *a
+	if cfg.Min <= 0 {
+		cfg.Min = 1
+	}
+	if cfg.Max <= 0 {
+		cfg.Max = defaultAdaptiveMax
+	}
+	if cfg.Max < cfg.Min {
+		cfg.Max = cfg.Min
+	}
+	if cfg.Initial < cfg.Min {
+		cfg.Initial = cfg.Min
+	}
+	if cfg.Initial > cfg.Max {
+		cfg.Initial = cfg.Max
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultAdaptiveBackoff
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaultAdaptiveMaxRetries
+	}
+	return cfg
+}
+
+// Run processes items 0 to n-1 and returns each item's error by index, with
+// the same cancellation and abort semantics as Runner.Run
+func (a *Adaptive) Run(ctx context.Context, n int, fn Func) ([]error, error) {
+	cfg := a.withDefaults()
+	errs := make([]error, n)
+	attempted := make([]bool, n)
+	retries := make([]int, n)
+
+	pending := make([]int, n)
+	for i := range pending {
+		pending[i] = i
+	}
+
+	size := cfg.Initial
+	progress := Progress{Total: n, ChunkSize: size}
+	report := func() {
+		if cfg.Progress != nil {
+			cfg.Progress(progress)
+		}
+	}
+	consecutive := 0
+
+	var runErr error
+	for len(pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			runErr = err
+			break
+		}
+		if cfg.MaxConsecutiveFailures > 0 && consecutive >= cfg.MaxConsecutiveFailures {
+			runErr = fmt.Errorf("%w: %d in a row", ErrTooManyFailures, consecutive)
+			break
+		}
+
+		chunk := pending[:min(size, len(pending))]
+		pending = pending[len(chunk):]
+
+		latencies := make([]time.Duration, len(chunk))
+		var requeue []int
+		var pause time.Duration
+		pressure := false
+
+		runner := Runner{
+			Workers: len(chunk),
+			OnResult: func(k int, err error) {
+				i := chunk[k]
+				if !attempted[i] {
+					return
+				}
+				if cfg.Overloaded != nil && err != nil && retries[i] < cfg.MaxRetries {
+					if retryAfter, ok := cfg.Overloaded(err); ok {
+						pressure = true
+						pause = max(pause, retryAfter)
+						retries[i]++
+						requeue = append(requeue, i)
+						progress.Retried++
+						report()
+						return
+					}
+				}
+
+				errs[i] = err
+				progress.Completed++
+				if err != nil {
+					progress.Failed++
+					consecutive++
+				} else {
+					consecutive = 0
+				}
+				report()
+			},
+		}
+		_, err := runner.Run(ctx, len(chunk), func(ctx context.Context, k int) error {
+			attempted[chunk[k]] = true
+			start := time.Now()
+			err := fn(ctx, chunk[k])
+			latencies[k] = time.Since(start)
+			return err
+		})
+		for _, i := range chunk {
+			if !attempted[i] {
+				// Cancelled before it started; queue it up to be marked below
+				requeue = append(requeue, i)
+			}
+		}
+		pending = append(requeue, pending...)
+		if err != nil {
+			runErr = err
+			break
+		}
+
+		if cfg.LatencyThreshold > 0 {
+			for _, latency := range latencies {
+				if latency > cfg.LatencyThreshold {
+					pressure = true
+				}
+			}
+		}
+
+		if !pressure {
+			size = min(size+1, cfg.Max)
+			progress.ChunkSize = size
+			continue
+		}
+
+		size = max(size/2, cfg.Min)
+		progress.ChunkSize = size
+		if len(requeue) == 0 {
+			// Slow but not rejected: shrink without pausing
+			continue
+		}
+		if pause <= 0 {
+			pause = cfg.Backoff
+		}
+		progress.Backoff = pause
+		report()
+		timer := time.NewTimer(pause)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		progress.Backoff = 0
+		report()
+	}
+
+	for _, i := range pending {
+		errs[i] = ErrNotAttempted
+	}
+	return errs, runErr
+}
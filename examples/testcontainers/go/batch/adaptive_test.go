@@ -0,0 +1,230 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errBusy = errors.New("busy")
+
+func busy(err error) (time.Duration, bool) {
+	return 0, errors.Is(err, errBusy)
+}
+
+// limited fails any item started while limit others are running
+type limited struct {
+	limit   int64
+	running int64
+}
+
+func (l *limited) fn(ctx context.Context, i int) error {
+	defer atomic.AddInt64(&l.running, -1)
+	if atomic.AddInt64(&l.running, 1) > l.limit {
+		return errBusy
+	}
+	time.Sleep(time.Millisecond)
+	return nil
+}
+
+// TestAdaptiveGrowsAndShrinks tests additive increase up to a limit and halving past it
+func TestAdaptiveGrowsAndShrinks(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	server := &limited{limit: 6}
+	adaptive := Adaptive{
+		Max:        10,
+		Overloaded: busy,
+		Backoff:    time.Millisecond,
+		Progress: func(p Progress) {
+			mu.Lock()
+			defer mu.Unlock()
+			if len(sizes) == 0 || sizes[len(sizes)-1] != p.ChunkSize {
+				sizes = append(sizes, p.ChunkSize)
+			}
+		},
+	}
+
+	errs, err := adaptive.Run(context.Background(), 100, server.fn)
+	require.NoError(t, err)
+	for _, itemErr := range errs {
+		assert.NoError(t, itemErr)
+	}
+
+	// 1+2+...+6 items fit, then a chunk of 7 trips the limit and halves
+	require.Greater(t, len(sizes), 8)
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 3}, sizes[:8])
+	for _, size := range sizes {
+		assert.LessOrEqual(t, size, 7)
+	}
+}
+
+// TestAdaptiveBounds tests that the chunk size stays within Min and Max
+func TestAdaptiveBounds(t *testing.T) {
+	var peak, low int64 = 0, 1 << 30
+	adaptive := Adaptive{
+		Min:        3,
+		Max:        5,
+		Overloaded: busy,
+		Backoff:    time.Millisecond,
+		Progress: func(p Progress) {
+			peak = max(peak, int64(p.ChunkSize))
+			low = min(low, int64(p.ChunkSize))
+		},
+	}
+
+	var running, concurrent int64
+	var calls int64
+	_, err := adaptive.Run(context.Background(), 60, func(ctx context.Context, i int) error {
+		now := atomic.AddInt64(&running, 1)
+		defer atomic.AddInt64(&running, -1)
+		for {
+			old := atomic.LoadInt64(&concurrent)
+			if now <= old || atomic.CompareAndSwapInt64(&concurrent, old, now) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		if atomic.AddInt64(&calls, 1) == 20 {
+			return errBusy
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), peak)
+	assert.Equal(t, int64(3), low)
+	assert.LessOrEqual(t, atomic.LoadInt64(&concurrent), int64(5))
+}
+
+// TestAdaptiveLatencyThreshold tests that slow chunks shrink without pausing or retrying
+func TestAdaptiveLatencyThreshold(t *testing.T) {
+	var retried, backoffs int
+	var sizes []int
+	adaptive := Adaptive{
+		Initial:          8,
+		LatencyThreshold: 5 * time.Millisecond,
+		Progress: func(p Progress) {
+			retried = p.Retried
+			if p.Backoff > 0 {
+				backoffs++
+			}
+			sizes = append(sizes, p.ChunkSize)
+		},
+	}
+
+	var calls int64
+	_, err := adaptive.Run(context.Background(), 12, func(ctx context.Context, i int) error {
+		atomic.AddInt64(&calls, 1)
+		if i == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), atomic.LoadInt64(&calls))
+	assert.Zero(t, retried)
+	assert.Zero(t, backoffs)
+	// The slow first chunk of 8 halves to 4, which covers the remaining items
+	assert.Equal(t, 4, sizes[len(sizes)-1])
+}
+
+// TestAdaptiveRetryAfter tests that the longest Retry-After of a chunk is the pause
+func TestAdaptiveRetryAfter(t *testing.T) {
+	var backoffs []time.Duration
+	var attempts sync.Map
+	adaptive := Adaptive{
+		Initial: 3,
+		Overloaded: func(err error) (time.Duration, bool) {
+			var retry retryAfterError
+			if errors.As(err, &retry) {
+				return time.Duration(retry), true
+			}
+			return 0, false
+		},
+		Progress: func(p Progress) {
+			if p.Backoff > 0 {
+				backoffs = append(backoffs, p.Backoff)
+			}
+		},
+	}
+
+	start := time.Now()
+	errs, err := adaptive.Run(context.Background(), 3, func(ctx context.Context, i int) error {
+		if _, again := attempts.LoadOrStore(i, true); !again && i > 0 {
+			return retryAfterError(time.Duration(i) * 20 * time.Millisecond)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+	assert.Equal(t, []time.Duration{40 * time.Millisecond}, backoffs)
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
+
+type retryAfterError time.Duration
+
+func (e retryAfterError) Error() string { return "retry after " + time.Duration(e).String() }
+
+// TestAdaptiveCancelDuringBackoff tests that cancelling mid-pause stops at once and marks the rest
+func TestAdaptiveCancelDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	adaptive := Adaptive{
+		Initial:    2,
+		Overloaded: busy,
+		Backoff:    time.Hour,
+		Progress: func(p Progress) {
+			if p.Backoff > 0 {
+				cancel()
+			}
+		},
+	}
+
+	errs, err := adaptive.Run(ctx, 5, func(ctx context.Context, i int) error {
+		if i == 1 {
+			return errBusy
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NoError(t, errs[0])
+	for _, i := range []int{1, 2, 3, 4} {
+		assert.ErrorIs(t, errs[i], ErrNotAttempted, "item %d", i)
+	}
+}
+
+// TestAdaptiveMaxRetries tests that persistent back-pressure is finally recorded as the item's error
+func TestAdaptiveMaxRetries(t *testing.T) {
+	var calls int64
+	adaptive := Adaptive{Overloaded: busy, Backoff: time.Millisecond, MaxRetries: 4}
+
+	errs, err := adaptive.Run(context.Background(), 1, func(ctx context.Context, i int) error {
+		atomic.AddInt64(&calls, 1)
+		return errBusy
+	})
+	require.NoError(t, err)
+	assert.ErrorIs(t, errs[0], errBusy)
+	assert.Equal(t, int64(5), atomic.LoadInt64(&calls))
+}
+
+// TestAdaptiveAbortsOnConsecutiveFailures tests the failure threshold between chunks
+func TestAdaptiveAbortsOnConsecutiveFailures(t *testing.T) {
+	var calls int64
+	adaptive := Adaptive{MaxConsecutiveFailures: 3}
+
+	errs, err := adaptive.Run(context.Background(), 100, func(ctx context.Context, i int) error {
+		atomic.AddInt64(&calls, 1)
+		return errors.New("engine unavailable")
+	})
+	assert.ErrorIs(t, err, ErrTooManyFailures)
+	// Chunks of 1 and 2 fail before the check between chunks aborts
+	assert.Equal(t, int64(3), atomic.LoadInt64(&calls))
+	assert.ErrorIs(t, errs[99], ErrNotAttempted)
+}
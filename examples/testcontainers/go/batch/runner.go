@@ -0,0 +1,168 @@
+// Package batch runs many independent evaluations over a bounded pool of
+// workers.
+package batch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ErrNotAttempted is the error recorded for items that were never started
+// because the run was cancelled or aborted first
+var ErrNotAttempted = errors.New("batch: item not attempted")
+
+// ErrTooManyFailures is returned when a run is aborted after
+// MaxConsecutiveFailures failed items in a row
+var ErrTooManyFailures = errors.New("batch: too many consecutive failures")
+
+// Func processes the item at index i; it should stop promptly once ctx is done
+type Func func(ctx context.Context, i int) error
+
+// Progress is a snapshot of a running batch
+type Progress struct {
+	Total     int
+	Completed int
+	Failed    int
+
+	// ChunkSize is the current chunk size of an Adaptive run
+	ChunkSize int
+	// Backoff is how long an Adaptive run is pausing after back-pressure;
+	// zero while it is running
+	Backoff time.Duration
+	// Retried counts items an Adaptive run re-queued after back-pressure
+	Retried int
+}
+
+// Runner spreads items over a fixed number of workers. Per-item errors are
+// recorded without stopping the run, unless MaxConsecutiveFailures is reached.
+// The zero Runner uses GOMAXPROCS workers and never aborts.
+type Runner struct {
+	// Workers is the number of items processed at once; zero or less means
+	// runtime.GOMAXPROCS(0)
+	Workers int
+	// MaxConsecutiveFailures aborts the run after this many failures in a row,
+	// counted in completion order; zero or less never aborts
+	MaxConsecutiveFailures int
+	// Progress, when set, is called after every item completes
+	Progress func(Progress)
+	// OnResult, when set, is called once per item in input order, as soon as
+	// the item and all items before it have completed
+	OnResult func(i int, err error)
+}
+
+type completion struct {
+	index int
+	err   error
+	// notAttempted marks items skipped after cancellation, so an item error
+	// that happens to wrap ErrNotAttempted still counts as a failure
+	notAttempted bool
+}
+
+// Run processes items 0 to n-1 and returns each item's error by index.
+// Callbacks run on the calling goroutine, one at a time. The returned error is
+// ctx's error if it was cancelled, or wraps ErrTooManyFailures if the run was
+// aborted; either way every in-flight item has finished before Run returns,
+// and items never started are recorded as ErrNotAttempted.
+func (r *Runner) Run(ctx context.Context, n int, fn Func) ([]error, error) {
+	errs := make([]error, n)
+	if n == 0 {
+		return errs, ctx.Err()
+	}
+
+	workers := r.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > n {
+		workers = n
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	next := make(chan int)
+	done := make(chan completion)
+
+	go func() {
+		defer close(next)
+		for i := 0; i < n; i++ {
+			select {
+			case next <- i:
+			case <-runCtx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if runCtx.Err() != nil {
+					done <- completion{index: i, err: ErrNotAttempted, notAttempted: true}
+					continue
+				}
+				done <- completion{index: i, err: fn(runCtx, i)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	finished := make([]bool, n)
+	delivered := 0
+	progress := Progress{Total: n}
+	consecutive := 0
+	var abortErr error
+
+	for c := range done {
+		errs[c.index] = c.err
+		finished[c.index] = true
+
+		if !c.notAttempted {
+			progress.Completed++
+			if c.err != nil {
+				progress.Failed++
+				consecutive++
+			} else {
+				consecutive = 0
+			}
+			if r.Progress != nil {
+				r.Progress(progress)
+			}
+			if r.MaxConsecutiveFailures > 0 && consecutive >= r.MaxConsecutiveFailures && abortErr == nil {
+				abortErr = fmt.Errorf("%w: %d in a row", ErrTooManyFailures, consecutive)
+				cancel()
+			}
+		}
+
+		for delivered < n && finished[delivered] {
+			if r.OnResult != nil {
+				r.OnResult(delivered, errs[delivered])
+			}
+			delivered++
+		}
+	}
+
+	for ; delivered < n; delivered++ {
+		if !finished[delivered] {
+			errs[delivered] = ErrNotAttempted
+		}
+		if r.OnResult != nil {
+			r.OnResult(delivered, errs[delivered])
+		}
+	}
+
+	if abortErr != nil {
+		return errs, abortErr
+	}
+	return errs, ctx.Err()
+}
@@ -0,0 +1,190 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+// TestRunnerOrderedResults tests that OnResult sees items in input order despite out-of-order completion
+func TestRunnerOrderedResults(t *testing.T) {
+	var order []int
+	runner := Runner{
+		Workers:  8,
+		OnResult: func(i int, err error) { order = append(order, i) },
+	}
+
+	errs, err := runner.Run(context.Background(), 100, func(ctx context.Context, i int) error {
+		// Later items finish sooner
+		time.Sleep(time.Duration(100-i) * 50 * time.Microsecond)
+		if i%10 == 3 {
+			return fmt.Errorf("item %d failed", i)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	expected := make([]int, 100)
+	for i := range expected {
+		expected[i] = i
+	}
+	assert.Equal(t, expected, order)
+	for i, itemErr := range errs {
+		if i%10 == 3 {
+			assert.EqualError(t, itemErr, fmt.Sprintf("item %d failed", i))
+		} else {
+			assert.NoError(t, itemErr)
+		}
+	}
+}
+
+// TestRunnerBoundsConcurrency tests that no more than Workers items run at once
+func TestRunnerBoundsConcurrency(t *testing.T) {
+	var running, peak int64
+	runner := Runner{Workers: 3}
+
+	_, err := runner.Run(context.Background(), 50, func(ctx context.Context, i int) error {
+		now := atomic.AddInt64(&running, 1)
+		for {
+			old := atomic.LoadInt64(&peak)
+			if now <= old || atomic.CompareAndSwapInt64(&peak, old, now) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt64(&running, -1)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), atomic.LoadInt64(&peak))
+}
+
+// TestRunnerProgress tests that progress counts every completion and failure
+func TestRunnerProgress(t *testing.T) {
+	var snapshots []Progress
+	runner := Runner{Workers: 4, Progress: func(p Progress) { snapshots = append(snapshots, p) }}
+
+	_, err := runner.Run(context.Background(), 20, func(ctx context.Context, i int) error {
+		if i < 5 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, snapshots, 20)
+	for i, p := range snapshots {
+		assert.Equal(t, i+1, p.Completed)
+		assert.Equal(t, 20, p.Total)
+	}
+	assert.Equal(t, 5, snapshots[19].Failed)
+}
+
+// TestRunnerItemErrorWrappingNotAttempted tests that an item's own error is a failure even if it wraps ErrNotAttempted
+func TestRunnerItemErrorWrappingNotAttempted(t *testing.T) {
+	var last Progress
+	runner := Runner{Workers: 2, Progress: func(p Progress) { last = p }}
+
+	errs, err := runner.Run(context.Background(), 4, func(ctx context.Context, i int) error {
+		if i == 1 {
+			return fmt.Errorf("upstream: %w", ErrNotAttempted)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Progress{Total: 4, Completed: 4, Failed: 1}, last)
+	assert.EqualError(t, errs[1], "upstream: batch: item not attempted")
+}
+
+// TestRunnerAbortsOnConsecutiveFailures tests the global failure threshold
+func TestRunnerAbortsOnConsecutiveFailures(t *testing.T) {
+	var calls int64
+	runner := Runner{Workers: 2, MaxConsecutiveFailures: 5}
+
+	errs, err := runner.Run(context.Background(), 1000, func(ctx context.Context, i int) error {
+		atomic.AddInt64(&calls, 1)
+		return errors.New("engine unavailable")
+	})
+	assert.ErrorIs(t, err, ErrTooManyFailures)
+	assert.Less(t, atomic.LoadInt64(&calls), int64(20))
+
+	require.Len(t, errs, 1000)
+	assert.ErrorIs(t, errs[999], ErrNotAttempted)
+
+	// Intermittent failures below the threshold never abort; one worker keeps
+	// completion order equal to input order
+	runner.Workers = 1
+	errs, err = runner.Run(context.Background(), 100, func(ctx context.Context, i int) error {
+		if i%4 == 0 {
+			return nil
+		}
+		return errors.New("flaky")
+	})
+	assert.NoError(t, err)
+	for _, itemErr := range errs {
+		assert.NotErrorIs(t, itemErr, ErrNotAttempted)
+	}
+}
+
+// TestRunnerCancellationDrains tests that cancelling waits for in-flight items and marks the rest
+func TestRunnerCancellationDrains(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	started := map[int]bool{}
+	finished := map[int]bool{}
+	runner := Runner{Workers: 4}
+
+	errs, err := runner.Run(ctx, 100, func(ctx context.Context, i int) error {
+		mu.Lock()
+		started[i] = true
+		if len(started) == 4 {
+			cancel()
+		}
+		mu.Unlock()
+
+		<-ctx.Done()
+		mu.Lock()
+		finished[i] = true
+		mu.Unlock()
+		return ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, started, finished, "every started item finished before Run returned")
+	for i, itemErr := range errs {
+		if started[i] {
+			assert.ErrorIs(t, itemErr, context.Canceled, "item %d", i)
+		} else {
+			assert.ErrorIs(t, itemErr, ErrNotAttempted, "item %d", i)
+		}
+	}
+}
+
+// TestRunnerEmpty tests that an empty run starts no goroutines and reports cancellation
+func TestRunnerEmpty(t *testing.T) {
+	var runner Runner
+	errs, err := runner.Run(context.Background(), 0, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, errs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errs, err = runner.Run(ctx, 3, func(ctx context.Context, i int) error { return nil })
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, errs, 3)
+}
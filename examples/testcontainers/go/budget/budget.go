@@ -0,0 +1,143 @@
+// Package budget splits one overall deadline across a sequence of dependent
+// calls, so that a slow early call leaves the later ones less time instead of
+// pushing the whole sequence past its deadline.
+package budget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBudgetExhausted is wrapped by every *ExhaustedError
+var ErrBudgetExhausted = errors.New("budget: time budget exhausted")
+
+// ExhaustedError reports the step that ran out of time: either nothing was
+// left when it was due to start, or it did not finish within its share
+type ExhaustedError struct {
+	// Step is the name the step was started with, and Index its position
+	Step  string
+	Index int
+	// Share is the time the step was given; zero when it never started
+	Share time.Duration
+	// Err is the step's own error, such as context.DeadlineExceeded; nil
+	// when it never started
+	Err error
+}
+
+func (e *ExhaustedError) Error() string {
+	step := fmt.Sprintf("step %d", e.Index)
+	if e.Step != "" {
+		step += " (" + e.Step + ")"
+	}
+	if e.Share == 0 {
+		return "time budget exhausted before " + step
+	}
+	return fmt.Sprintf("time budget exhausted in %s after its %s share", step, e.Share)
+}
+
+func (e *ExhaustedError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrBudgetExhausted}
+	}
+	return []error{ErrBudgetExhausted, e.Err}
+}
+
+// Budget hands out one context per step. Each step's share is the time
+// remaining divided over the steps still to run, in proportion to their
+// weights, so time an early step does not use passes on to later ones and the
+// last step gets whatever genuinely remains. A Budget is used from one
+// goroutine, one step at a time.
+type Budget struct {
+	ctx      context.Context
+	deadline time.Time
+	weights  []float64
+
+	// The step started last
+	next    int
+	step    string
+	share   time.Duration
+	stepCtx context.Context
+}
+
+// New starts a budget of total from now; a sooner deadline on ctx wins. Until
+// Steps or Weights says otherwise, every step may use all that remains.
+func New(ctx context.Context, total time.Duration) *Budget {
+	deadline := time.Now().Add(total)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	return &Budget{ctx: ctx, deadline: deadline}
+}
+
+// Steps splits the budget equally over n steps
+func (b *Budget) Steps(n int) *Budget {
+	weights := make([]float64, n)
+	for i := range weights {
+		weights[i] = 1
+	}
+	return b.Weights(weights...)
+}
+
+// Weights splits the budget over one step per weight, each in proportion to
+// its weight; weights of zero or less count as 1. Steps started beyond the
+// last weight may use all that remains.
+func (b *Budget) Weights(weights ...float64) *Budget {
+	b.weights = make([]float64, len(weights))
+	for i, w := range weights {
+		if w <= 0 {
+			w = 1
+		}
+		b.weights[i] = w
+	}
+	return b
+}
+
+// Remaining is the time left in the whole budget
+func (b *Budget) Remaining() time.Duration {
+	return time.Until(b.deadline)
+}
+
+// Next starts the next step, returning its context and the function that
+// releases it, which must be called once the step is done. It returns an
+// *ExhaustedError when no time is left, or ctx's error if it was cancelled.
+func (b *Budget) Next(name string) (context.Context, context.CancelFunc, error) {
+	index := b.next
+	b.next++
+	b.step, b.share, b.stepCtx = name, 0, nil
+
+	if err := b.ctx.Err(); errors.Is(err, context.Canceled) {
+		return nil, nil, err
+	}
+	remaining := b.Remaining()
+	if remaining <= 0 {
+		return nil, nil, &ExhaustedError{Step: name, Index: index}
+	}
+
+	share := remaining
+	if index < len(b.weights) {
+		rest := 0.0
+		for _, w := range b.weights[index:] {
+			rest += w
+		}
+		share = time.Duration(float64(remaining) * b.weights[index] / rest)
+	}
+	ctx, cancel := context.WithTimeout(b.ctx, share)
+	b.share, b.stepCtx = share, ctx
+	return ctx, cancel, nil
+}
+
+// Exhausted turns the error of the step started last into an *ExhaustedError
+// naming it when the step failed because its share ran out, and returns any
+// other error unchanged
+func (b *Budget) Exhausted(err error) error {
+	if err == nil || b.stepCtx == nil || !errors.Is(b.stepCtx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	var exhausted *ExhaustedError
+	if errors.As(err, &exhausted) {
+		return err
+	}
+	return &ExhaustedError{Step: b.step, Index: b.next - 1, Share: b.share, Err: err}
+}
@@ -0,0 +1,108 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// share starts the next step of b and returns how long its context has
+func share(t *testing.T, b *Budget, name string) (context.Context, time.Duration) {
+	t.Helper()
+	ctx, cancel, err := b.Next(name)
+	require.NoError(t, err)
+	t.Cleanup(cancel)
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	return ctx, time.Until(deadline)
+}
+
+// TestBudgetEqualSplit tests that unused time passes on and a slow step shrinks the ones after it
+func TestBudgetEqualSplit(t *testing.T) {
+	b := New(context.Background(), 600*time.Millisecond).Steps(3)
+
+	_, first := share(t, b, "first")
+	assert.InDelta(t, 200*time.Millisecond, first, float64(20*time.Millisecond))
+
+	// The first step is slow and uses 300ms, past its share
+	time.Sleep(300 * time.Millisecond)
+	_, second := share(t, b, "second")
+	assert.InDelta(t, 150*time.Millisecond, second, float64(20*time.Millisecond))
+
+	// The second step is quick, leaving the last step everything else
+	_, last := share(t, b, "last")
+	assert.InDelta(t, 300*time.Millisecond, last, float64(20*time.Millisecond))
+	assert.InDelta(t, b.Remaining(), last, float64(5*time.Millisecond))
+}
+
+// TestBudgetWeights tests that shares follow the weights of the steps still to run
+func TestBudgetWeights(t *testing.T) {
+	b := New(context.Background(), time.Second).Weights(3, 1, 0)
+
+	_, first := share(t, b, "a")
+	assert.InDelta(t, 600*time.Millisecond, first, float64(20*time.Millisecond))
+	_, second := share(t, b, "b")
+	assert.InDelta(t, 500*time.Millisecond, second, float64(20*time.Millisecond))
+	_, third := share(t, b, "c")
+	assert.InDelta(t, time.Second, third, float64(20*time.Millisecond))
+
+	// Steps beyond the weights get all that remains
+	_, extra := share(t, b, "d")
+	assert.InDelta(t, time.Second, extra, float64(20*time.Millisecond))
+}
+
+// TestBudgetParentDeadline tests that a sooner deadline on the parent context bounds the budget
+func TestBudgetParentDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	b := New(ctx, time.Hour).Steps(2)
+	assert.LessOrEqual(t, b.Remaining(), 100*time.Millisecond)
+	_, first := share(t, b, "first")
+	assert.InDelta(t, 50*time.Millisecond, first, float64(10*time.Millisecond))
+}
+
+// TestBudgetExhausted tests that the step that ran out of time is named
+func TestBudgetExhausted(t *testing.T) {
+	b := New(context.Background(), 100*time.Millisecond).Steps(2)
+
+	ctx, _ := share(t, b, "lookup")
+	<-ctx.Done()
+	err := b.Exhausted(ctx.Err())
+	var exhausted *ExhaustedError
+	require.ErrorAs(t, err, &exhausted)
+	assert.Equal(t, "lookup", exhausted.Step)
+	assert.Equal(t, 0, exhausted.Index)
+	assert.InDelta(t, 50*time.Millisecond, exhausted.Share, float64(10*time.Millisecond))
+	assert.ErrorIs(t, err, ErrBudgetExhausted)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Regexp(t, `^time budget exhausted in step 0 \(lookup\) after its \d+(\.\d+)?ms share$`, err.Error())
+
+	// Nothing is left for the second step to start with
+	time.Sleep(60 * time.Millisecond)
+	_, _, err = b.Next("decide")
+	require.ErrorAs(t, err, &exhausted)
+	assert.Equal(t, &ExhaustedError{Step: "decide", Index: 1}, exhausted)
+	assert.EqualError(t, err, "time budget exhausted before step 1 (decide)")
+	assert.True(t, errors.Is(err, ErrBudgetExhausted))
+}
+
+// TestBudgetOtherErrors tests that errors other than running out of time are left alone
+func TestBudgetOtherErrors(t *testing.T) {
+	failed := errors.New("failed")
+	b := New(context.Background(), time.Second)
+	_, _, err := b.Next("step")
+	require.NoError(t, err)
+	assert.Same(t, failed, b.Exhausted(failed))
+	assert.NoError(t, b.Exhausted(nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b = New(ctx, time.Second)
+	cancel()
+	_, _, err = b.Next("step")
+	assert.Same(t, context.Canceled, err)
+}
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultPollInterval is how often EvaluateAsync polls without an interval
+const defaultPollInterval = time.Second
+
+// ErrJobNotFound is a poll for a job the engine does not know, because it
+// never was or has been forgotten
+var ErrJobNotFound = errors.New("engine has no such job")
+
+// jobResponse is the body of POST /jobs
+type jobResponse struct {
+	ID string `json:"id"`
+}
+
+// SubmitEvaluation sends req to the engine's job queue, POST /jobs, and
+// returns the job's ID for PollEvaluation. The engine evaluates it in the
+// background, so an evaluation too slow for one HTTP request can still be
+// made. It needs CapabilityAsync, and so WithCapabilityProbing; without it
+// the error is a *CapabilityError. The job's data is prepared as
+// Evaluate's is, but it skips the cache, hooks and failure policy. The job
+// is sent under the decision ID ctx carries, see ContextWithDecisionID, or
+// a new one; after Shutdown it fails with ErrClientClosed.
+func (c *PolicyClient) SubmitEvaluation(ctx context.Context, req PolicyRequest) (string, error) {
+	end, err := c.inFlight.begin()
+	if err != nil {
+		return "", err
+	}
+	defer end()
+	ctx, d := startDecision(ctx)
+	jobID, err := c.submit(ctx, req)
+	return jobID, withDecisionID(err, d.id)
+}
+
+// submit is SubmitEvaluation once the decision has started
+func (c *PolicyClient) submit(ctx context.Context, req PolicyRequest) (string, error) {
+	if !c.supports(ctx, CapabilityAsync) {
+		return "", &CapabilityError{Capability: CapabilityAsync}
+	}
+	if _, err := c.checkMetadata(ctx); err != nil {
+		return "", err
+	}
+	req = req.joined()
+	if c.alwaysTrace {
+		req.Trace = true
+	}
+	data, err := c.prepareData(ctx, req.Data)
+	if err != nil {
+		return "", err
+	}
+	req.Data = data
+	if c.strictData {
+		if err := checkDataShape(req.Rule, data); err != nil {
+			return "", err
+		}
+	}
+	body, err := newRequestBody(req, c.bodyOptions(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer body.release()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/jobs", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build job request: %w", err)
+	}
+	if err := body.attach(httpReq); err != nil {
+		return "", fmt.Errorf("failed to build job request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", requestContentType)
+	httpReq.Header.Set("Accept", "application/json")
+	decisionFrom(ctx).setHeaders(httpReq.Header)
+	c.setMetadataHeaders(ctx, httpReq.Header)
+	injectTraceContext(ctx, httpReq.Header)
+
+	resp, err := c.do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("failed to submit evaluation: %w", &TransportError{Err: err})
+	}
+	defer releaseBody(ctx, resp.Body)
+	if authErr := authError(resp); authErr != nil {
+		return "", fmt.Errorf("failed to submit evaluation: %w", authErr)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		// The engine advertised jobs it does not serve
+		return "", &CapabilityError{Capability: CapabilityAsync, Err: &StatusError{StatusCode: resp.StatusCode}}
+	default:
+		return "", fmt.Errorf("failed to submit evaluation: %w", &StatusError{StatusCode: resp.StatusCode})
+	}
+	var job jobResponse
+	if err := readResponse(ctx, resp, &job, nil); err != nil {
+		return "", fmt.Errorf("failed to submit evaluation: %w", err)
+	}
+	if job.ID == "" {
+		return "", errors.New("failed to submit evaluation: the engine returned no job ID")
+	}
+	return job.ID, nil
+}
+
+// PollEvaluation asks the engine, GET /jobs/{id}, whether the job
+// SubmitEvaluation returned has finished. Until it has, it returns a nil
+// response and false. Once it has, it returns the job's response and true,
+// with the response's *EngineError as the error when the engine rejected
+// the rule or data, as Evaluate does. A poll is sent under the decision ID
+// ctx carries, if any, so passing the submission's ContextWithDecisionID
+// ties the two together; after Shutdown it fails with ErrClientClosed.
+func (c *PolicyClient) PollEvaluation(ctx context.Context, jobID string) (*PolicyResponse, bool, error) {
+	end, err := c.inFlight.begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer end()
+	response, done, err := c.poll(ctx, jobID)
+	if d := decisionFrom(ctx); d != nil {
+		err = withDecisionID(err, d.id)
+	}
+	return response, done, err
+}
+
+// poll is PollEvaluation without the in-flight guard
+func (c *PolicyClient) poll(ctx context.Context, jobID string) (*PolicyResponse, bool, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/jobs/"+url.PathEscape(jobID), nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to build poll request: %w", err)
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if c.body.compressAbove > 0 {
+		httpReq.Header.Set("Accept-Encoding", "gzip")
+	}
+	if d := decisionFrom(ctx); d != nil {
+		d.setHeaders(httpReq.Header)
+	}
+	c.setMetadataHeaders(ctx, httpReq.Header)
+	injectTraceContext(ctx, httpReq.Header)
+
+	resp, err := c.do(httpReq)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to poll job %s: %w", jobID, &TransportError{Err: err})
+	}
+	defer releaseBody(ctx, resp.Body)
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		return nil, false, nil
+	case http.StatusNotFound:
+		return nil, false, fmt.Errorf("failed to poll job %s: %w", jobID, ErrJobNotFound)
+	}
+	response, _, err := c.decodeEvaluation(ctx, resp, false)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to poll job %s: %w", jobID, err)
+	}
+	response, err = c.responseFailure(response, nil)
+	return response, true, err
+}
+
+// EvaluateAsync evaluates req as a job, polling every pollInterval, a
+// second if it is zero, until the job finishes or ctx is done. An engine
+// without CapabilityAsync is asked with Evaluate instead, counted in
+// DegradedFeatures, so callers can use it whatever the engine. The
+// submission and every poll carry one decision ID, and Shutdown waits for
+// the job as for any evaluation in flight.
+func (c *PolicyClient) EvaluateAsync(ctx context.Context, req PolicyRequest, pollInterval time.Duration) (*PolicyResponse, error) {
+	end, err := c.inFlight.begin()
+	if err != nil {
+		return nil, err
+	}
+	ctx, d := startDecision(ctx)
+	jobID, err := c.submit(ctx, req)
+	if errors.Is(err, ErrCapabilityUnsupported) {
+		end()
+		c.degradeFeature(CapabilityAsync)
+		return c.Evaluate(ctx, req)
+	}
+	defer end()
+	if err != nil {
+		return nil, withDecisionID(err, d.id)
+	}
+	response, err := c.await(ctx, jobID, pollInterval)
+	return response, withDecisionID(err, d.id)
+}
+
+// await polls the job every pollInterval until it finishes or ctx is done
+func (c *PolicyClient) await(ctx context.Context, jobID string, pollInterval time.Duration) (*PolicyResponse, error) {
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, &TransportError{Err: ctx.Err()}
+		}
+		response, done, err := c.poll(ctx, jobID)
+		if done || err != nil {
+			return response, err
+		}
+	}
+}
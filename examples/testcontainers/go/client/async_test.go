@@ -0,0 +1,165 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/enginetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAsyncClient starts a fake engine configured with opts and returns it
+// with a client that probes its capabilities
+func newAsyncClient(t *testing.T, opts ...enginetest.Option) (*enginetest.Engine, *client.PolicyClient) {
+	engine := enginetest.NewEngine(opts...)
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+	c, err := client.New(server.URL, client.WithCapabilityProbing(time.Minute))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	return engine, c
+}
+
+// withRole is data for accessRule
+func withRole(role string) interface{} {
+	return map[string]interface{}{"User": map[string]interface{}{"role": role}}
+}
+
+// TestSubmitAndPoll tests polling a job until it finishes
+func TestSubmitAndPoll(t *testing.T) {
+	_, c := newAsyncClient(t, enginetest.WithAsync(2))
+	ctx := context.Background()
+
+	jobID, err := c.SubmitEvaluation(ctx, client.PolicyRequest{Rule: accessRule, Data: withRole("admin")})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		response, done, err := c.PollEvaluation(ctx, jobID)
+		require.NoError(t, err)
+		assert.False(t, done, "poll %d", i+1)
+		assert.Nil(t, response)
+	}
+	response, done, err := c.PollEvaluation(ctx, jobID)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.True(t, response.Result)
+
+	_, _, err = c.PollEvaluation(ctx, "job-missing")
+	assert.ErrorIs(t, err, client.ErrJobNotFound)
+}
+
+// TestEvaluateAsync tests that EvaluateAsync waits for the job, and returns
+// an engine error as Evaluate does
+func TestEvaluateAsync(t *testing.T) {
+	engine, c := newAsyncClient(t, enginetest.WithAsync(3))
+	ctx := context.Background()
+
+	response, err := c.EvaluateAsync(ctx, client.PolicyRequest{Rule: accessRule, Data: withRole("guest")}, time.Millisecond)
+	require.NoError(t, err)
+	assert.False(t, response.Result)
+	assert.Equal(t, 1, engine.Jobs())
+
+	response, err = c.EvaluateAsync(ctx, client.PolicyRequest{Rule: accessRule, Data: map[string]interface{}{"User": map[string]interface{}{}}}, time.Millisecond)
+	var engineErr *client.EngineError
+	require.ErrorAs(t, err, &engineErr)
+	require.NotNil(t, response)
+	assert.Contains(t, *response.Error, "Property 'role' not found")
+	assert.Empty(t, c.DegradedFeatures())
+}
+
+// TestEvaluateAsyncContext tests that EvaluateAsync gives up when its
+// context is done
+func TestEvaluateAsyncContext(t *testing.T) {
+	_, c := newAsyncClient(t, enginetest.WithAsync(1<<30))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := c.EvaluateAsync(ctx, client.PolicyRequest{Rule: accessRule, Data: withRole("admin")}, 5*time.Millisecond)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// TestEvaluateAsyncFallback tests that an engine without jobs is evaluated
+// synchronously
+func TestEvaluateAsyncFallback(t *testing.T) {
+	engine, c := newAsyncClient(t)
+	ctx := context.Background()
+
+	_, err := c.SubmitEvaluation(ctx, client.PolicyRequest{Rule: accessRule, Data: withRole("admin")})
+	assert.ErrorIs(t, err, client.ErrCapabilityUnsupported)
+
+	response, err := c.EvaluateAsync(ctx, client.PolicyRequest{Rule: accessRule, Data: withRole("admin")}, time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, response.Result)
+	assert.Zero(t, engine.Jobs())
+	assert.Equal(t, map[client.Capability]int64{client.CapabilityAsync: 1}, c.DegradedFeatures())
+}
+
+// TestEvaluateAsyncDecisionID tests that the submission and every poll of
+// a job carry one decision ID, which the response and errors report
+func TestEvaluateAsyncDecisionID(t *testing.T) {
+	engine := enginetest.NewEngine(enginetest.WithAsync(2))
+	var mu sync.Mutex
+	var ids []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" {
+			mu.Lock()
+			ids = append(ids, r.Header.Get(client.DecisionIDHeader))
+			mu.Unlock()
+		}
+		engine.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	c, err := client.New(server.URL, client.WithCapabilityProbing(time.Minute))
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx := client.ContextWithDecisionID(context.Background(), "decision-1")
+	response, err := c.EvaluateAsync(ctx, client.PolicyRequest{Rule: accessRule, Data: withRole("admin")}, time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, "decision-1", response.DecisionID)
+	assert.Equal(t, []string{"decision-1", "decision-1", "decision-1", "decision-1"}, ids, "the submission and three polls")
+
+	_, _, err = c.PollEvaluation(ctx, "job-missing")
+	id, ok := client.DecisionIDOf(err)
+	assert.True(t, ok)
+	assert.Equal(t, "decision-1", id)
+}
+
+// TestEvaluateAsyncShutdown tests that Shutdown waits for a job being
+// polled, and that jobs are refused once the client is shut down
+func TestEvaluateAsyncShutdown(t *testing.T) {
+	engine, c := newAsyncClient(t, enginetest.WithAsync(5))
+	ctx := context.Background()
+
+	var response *client.PolicyResponse
+	var evalErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		response, evalErr = c.EvaluateAsync(ctx, client.PolicyRequest{Rule: accessRule, Data: withRole("admin")}, 5*time.Millisecond)
+	}()
+	require.Eventually(t, func() bool { return engine.Jobs() == 1 }, 5*time.Second, time.Millisecond)
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, c.Shutdown(shutdownCtx))
+	select {
+	case <-done:
+	default:
+		t.Fatal("Shutdown returned before the job finished")
+	}
+	require.NoError(t, evalErr)
+	assert.True(t, response.Result)
+
+	_, err := c.SubmitEvaluation(ctx, client.PolicyRequest{Rule: accessRule, Data: withRole("admin")})
+	assert.ErrorIs(t, err, client.ErrClientClosed)
+	_, _, err = c.PollEvaluation(ctx, "job-1")
+	assert.ErrorIs(t, err, client.ErrClientClosed)
+	_, err = c.EvaluateAsync(ctx, client.PolicyRequest{Rule: accessRule, Data: withRole("admin")}, time.Millisecond)
+	assert.ErrorIs(t, err, client.ErrClientClosed)
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// AuditRecord is one evaluation as the audit trail keeps it
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	DecisionID string    `json:"decision_id"`
+	// RuleHash identifies the rule without repeating its text
+	RuleHash string          `json:"rule_hash"`
+	Result   bool            `json:"result"`
+	Labels   map[string]bool `json:"labels,omitempty"`
+	// Degraded marks a decision the failure policy made in place of the
+	// engine, and Reason is the failure it stands in for
+	Degraded bool   `json:"degraded"`
+	Reason   string `json:"reason,omitempty"`
+	// Error is the evaluation's error, for calls that failed, and ErrorCode
+	// the code of an engine error that had one
+	Error     string        `json:"error,omitempty"`
+	ErrorCode string        `json:"error_code,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	// Rule and Data are the rule text and the data the engine evaluated it
+	// against, as it echoed them, under WithAuditPayloads
+	Rule string          `json:"rule,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+	// Shadow marks an evaluation WithShadow mirrored to this client
+	Shadow bool `json:"shadow,omitempty"`
+	// Metadata is what the caller attached with ContextWithMetadata, if it
+	// passed the client's checks
+	Metadata Metadata `json:"metadata,omitempty"`
+}
+
+// AuditSink receives an AuditRecord for every evaluation. Record is called
+// on the evaluating goroutine, so a slow sink slows evaluations; its error
+// does not fail the evaluation but is counted, see AuditFailures.
+// Implementations must be safe for concurrent use.
+type AuditSink interface {
+	Record(ctx context.Context, record AuditRecord) error
+}
+
+// WithAuditSink records every evaluation, degraded ones included, to sink
+func WithAuditSink(sink AuditSink) Option {
+	return func(c *PolicyClient) {
+		c.audit = sink
+	}
+}
+
+// WithAuditPayloads also records each evaluation's rule text and data, so a
+// decision can be replayed; by default records identify the rule by its hash
+// and leave the data out, which may be personal
+func WithAuditPayloads() Option {
+	return func(c *PolicyClient) {
+		c.auditPayloads = true
+	}
+}
+
+// RuleHash is the hash audit records identify rule by: hex SHA-256 of its
+// text
+func RuleHash(rule string) string {
+	hash := ruleKey(rule)
+	return hex.EncodeToString(hash[:])
+}
+
+// AuditFailures returns how many audit records the sink failed to take
+func (c *PolicyClient) AuditFailures() int64 {
+	return c.stats.auditFailures.Load()
+}
+
+// JSONAuditSink writes audit records to w as JSON lines
+type JSONAuditSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONAuditSink returns a sink writing to w
+func NewJSONAuditSink(w io.Writer) *JSONAuditSink {
+	return &JSONAuditSink{encoder: json.NewEncoder(w)}
+}
+
+// Record writes record as one line
+func (s *JSONAuditSink) Record(_ context.Context, record AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(record)
+}
+
+// record sends the audit record of one evaluation to the client's sink
+func (c *PolicyClient) record(ctx context.Context, rule string, id string, began time.Time, response *PolicyResponse, err error) {
+	if c.audit == nil {
+		return
+	}
+	record := AuditRecord{
+		Time:       began,
+		DecisionID: id,
+		RuleHash:   RuleHash(rule),
+		Duration:   c.now().Sub(began),
+		Shadow:     IsShadow(ctx),
+	}
+	if _, err := c.checkMetadata(ctx); err == nil {
+		record.Metadata = MetadataFromContext(ctx)
+	}
+	if response != nil {
+		record.Result = response.Result
+		record.Labels = response.Labels
+		record.Degraded = response.Degraded
+		if response.Fallback != nil {
+			record.Reason = response.Fallback.Err.Error()
+		}
+	}
+	if err != nil {
+		record.Error = err.Error()
+		var engineErr *EngineError
+		if errors.As(err, &engineErr) {
+			record.ErrorCode = engineErr.Code
+		}
+	}
+	if c.auditPayloads {
+		record.Rule = rule
+		if response != nil && response.Data != nil && !response.Degraded {
+			// A record that cannot hold the data still records the decision
+			record.Data, _ = json.Marshal(response.Data)
+		}
+	}
+	if auditErr := c.audit.Record(context.WithoutCancel(ctx), record); auditErr != nil {
+		c.stats.auditFailures.Add(1)
+	}
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Redacted stands in for the value of a sensitive header in logs
+const Redacted = "[REDACTED]"
+
+// sensitiveHeaders are the headers RedactHeaders hides the values of
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// WithAuthToken sends token as a bearer token, in an Authorization header,
+// with every request: evaluations, health checks and probes alike
+func WithAuthToken(token string) Option {
+	return WithHeader("Authorization", "Bearer "+token)
+}
+
+// WithHeader sets the header key to value on every request, replacing any
+// earlier value given for key. Middleware sees it set.
+func WithHeader(key, value string) Option {
+	return func(c *PolicyClient) {
+		if c.headers == nil {
+			c.headers = http.Header{}
+		}
+		c.headers.Set(key, value)
+	}
+}
+
+// setHeaders returns req with the client's headers set, cloned if there are
+// any
+func (c *PolicyClient) setHeaders(req *http.Request) *http.Request {
+	if len(c.headers) == 0 {
+		return req
+	}
+	req = req.Clone(req.Context())
+	for name, values := range c.headers {
+		req.Header[name] = append([]string(nil), values...)
+	}
+	return req
+}
+
+// RedactHeaders returns a copy of header with the values of credentials,
+// such as Authorization and X-Api-Key, replaced by Redacted
+func RedactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range sensitiveHeaders {
+		if _, ok := redacted[name]; ok {
+			redacted[name] = []string{Redacted}
+		}
+	}
+	return redacted
+}
+
+// AuthError is the engine, or a proxy in front of it, refusing a request
+// for missing or wrong credentials with 401 Unauthorized or 403 Forbidden.
+// Challenge is the response's WWW-Authenticate header, if any.
+type AuthError struct {
+	StatusCode int
+	Challenge  string
+}
+
+func (e *AuthError) Error() string {
+	reason := "missing or invalid credentials"
+	if e.StatusCode == http.StatusForbidden {
+		reason = "credentials not allowed"
+	}
+	if e.Challenge != "" {
+		return fmt.Sprintf("engine refused the request: %s (status %d, %s)", reason, e.StatusCode, e.Challenge)
+	}
+	return fmt.Sprintf("engine refused the request: %s (status %d)", reason, e.StatusCode)
+}
+
+// authError is the *AuthError of resp, or nil if it is not a refusal
+func authError(resp *http.Response) *AuthError {
+	if resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden {
+		return nil
+	}
+	return &AuthError{StatusCode: resp.StatusCode, Challenge: strings.TrimSpace(resp.Header.Get("WWW-Authenticate"))}
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAuthEngine is an engine behind a proxy that wants token as a bearer
+// token, recording the X-Tenant header it was sent
+func newAuthEngine(t *testing.T, token string, tenant *string) *httptest.Server {
+	engine := newFakeEngine(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*tenant = r.Header.Get("X-Tenant")
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="policy-engine"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		engine.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestAuthToken tests that the token and headers go with every request
+func TestAuthToken(t *testing.T) {
+	var tenant string
+	engine := newAuthEngine(t, "s3cret", &tenant)
+	c, err := New(engine.URL, WithAuthToken("s3cret"), WithHeader("X-Tenant", "acme"))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, c.Health(ctx))
+	response, err := c.Evaluate(ctx, PolicyRequest{Rule: "rule", Data: map[string]interface{}{}})
+	require.NoError(t, err)
+	assert.True(t, response.Result)
+	assert.Equal(t, "acme", tenant)
+}
+
+// TestAuthError tests that a refused request fails with an *AuthError, which
+// the failure policy does not stand in for
+func TestAuthError(t *testing.T) {
+	var tenant string
+	engine := newAuthEngine(t, "s3cret", &tenant)
+	ctx := context.Background()
+
+	for name, opts := range map[string][]Option{
+		"no token":    {WithFailurePolicy(FailOpen)},
+		"wrong token": {WithAuthToken("guess"), WithFailurePolicy(FailOpen)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := New(engine.URL, opts...)
+			require.NoError(t, err)
+			_, err = c.Evaluate(ctx, PolicyRequest{Rule: "rule", Data: map[string]interface{}{}})
+			var authErr *AuthError
+			require.True(t, errors.As(err, &authErr), err)
+			assert.Equal(t, http.StatusUnauthorized, authErr.StatusCode)
+			assert.Equal(t, `Bearer realm="policy-engine"`, authErr.Challenge)
+
+			err = c.Health(ctx)
+			assert.True(t, errors.As(err, &authErr), err)
+		})
+	}
+}
+
+// TestRedactHeaders tests that debug logs hide the token
+func TestRedactHeaders(t *testing.T) {
+	var tenant string
+	engine := newAuthEngine(t, "s3cret", &tenant)
+	var log bytes.Buffer
+	c, err := New(engine.URL, WithAuthToken("s3cret"), WithMiddleware(DebugLogging(&log)))
+	require.NoError(t, err)
+	require.NoError(t, c.Health(context.Background()))
+
+	assert.Contains(t, log.String(), "Authorization: "+Redacted)
+	assert.NotContains(t, log.String(), "s3cret")
+	header := http.Header{"Authorization": {"Bearer s3cret"}, "Accept": {"application/json"}}
+	assert.Equal(t, http.Header{"Authorization": {Redacted}, "Accept": {"application/json"}}, RedactHeaders(header))
+	assert.Equal(t, "Bearer s3cret", header.Get("Authorization"), "the original is left alone")
+}
@@ -0,0 +1,285 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BalancerPolicy selects how evaluations are spread over engine replicas
+type BalancerPolicy int
+
+const (
+	// Failover sends everything to the first healthy endpoint, in the order
+	// given to New and WithEndpoints
+	Failover BalancerPolicy = iota
+	// RoundRobin rotates over the healthy endpoints
+	RoundRobin
+	// P2C picks two healthy endpoints at random and uses the one with the
+	// lower cost: its latency average times its in-flight requests, inflated
+	// by its recent error rate
+	P2C
+)
+
+const (
+	// latencyDecay and errorDecay weight each new observation in the
+	// endpoint averages
+	latencyDecay = 0.3
+	errorDecay   = 0.2
+	// errorPenalty scales how strongly P2C avoids failing endpoints
+	errorPenalty = 10
+	// ejectAfter consecutive failures eject an endpoint until a health
+	// probe succeeds or the ejection cooldown passes
+	ejectAfter       = 3
+	ejectionCooldown = 10 * time.Second
+)
+
+// WithEndpoints adds engine replicas next to the base URL and balances
+// evaluations over all of them with the policy set by WithBalancer, Failover
+// by default. An endpoint failing several requests in a row is ejected until
+// a health probe (see WithHealthProbes) readmits it, or for a short cooldown
+// without probes. If every endpoint is ejected, all of them are used.
+// Health and Warmup always talk to the base URL.
+func WithEndpoints(urls ...string) Option {
+	return func(c *PolicyClient) {
+		c.replicas = append(c.replicas, urls...)
+	}
+}
+
+// WithBalancer sets how evaluations are spread over the endpoints given with
+// WithEndpoints
+func WithBalancer(policy BalancerPolicy) Option {
+	return func(c *PolicyClient) {
+		c.balancerPolicy = policy
+	}
+}
+
+// WithHealthProbes checks every endpoint's /health each interval in the
+// background, ejecting endpoints that fail and readmitting them once they
+// pass again. Close stops the probes.
+func WithHealthProbes(interval time.Duration) Option {
+	return func(c *PolicyClient) {
+		c.probeInterval = interval
+	}
+}
+
+// EndpointStats is a snapshot of how an endpoint has been doing
+type EndpointStats struct {
+	URL     string
+	Healthy bool
+	// Requests and Errors count completed evaluations; an error is a failed
+	// request or a 5xx response
+	Requests int64
+	Errors   int64
+	InFlight int64
+	// Latency is the moving average latency of completed evaluations
+	Latency time.Duration
+	// ErrorRate is the moving average share of failed evaluations
+	ErrorRate float64
+}
+
+// EndpointStats reports on every endpoint, in the order they were given; it
+// returns nil when WithEndpoints is not configured
+func (c *PolicyClient) EndpointStats() []EndpointStats {
+	if c.balancer == nil {
+		return nil
+	}
+	stats := make([]EndpointStats, len(c.balancer.endpoints))
+	for i, e := range c.balancer.endpoints {
+		stats[i] = e.stats()
+	}
+	return stats
+}
+
+type endpoint struct {
+	url      string
+	inFlight int64
+
+	mu           sync.Mutex
+	requests     int64
+	errors       int64
+	latency      float64
+	errorRate    float64
+	consecutive  int
+	ejectedUntil time.Time
+	now          func() time.Time
+}
+
+// begin counts a request against the endpoint and returns the function that
+// records its outcome
+func (e *endpoint) begin() func(failed bool) {
+	atomic.AddInt64(&e.inFlight, 1)
+	start := time.Now()
+	return func(failed bool) {
+		atomic.AddInt64(&e.inFlight, -1)
+		e.observe(time.Since(start), failed)
+	}
+}
+
+func (e *endpoint) observe(latency time.Duration, failed bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	first := e.requests == 0
+	e.requests++
+	failure := 0.0
+	if failed {
+		e.errors++
+		failure = 1
+		e.consecutive++
+		if e.consecutive >= ejectAfter {
+			e.ejectedUntil = e.now().Add(ejectionCooldown)
+		}
+	} else {
+		e.consecutive = 0
+	}
+
+	if first {
+		e.latency = float64(latency)
+		e.errorRate = failure
+		return
+	}
+	e.latency += latencyDecay * (float64(latency) - e.latency)
+	e.errorRate += errorDecay * (failure - e.errorRate)
+}
+
+// probed records a health probe: a failure ejects the endpoint until the
+// next probe, a success readmits it
+func (e *endpoint) probed(healthy bool, interval time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if healthy {
+		e.ejectedUntil = time.Time{}
+		e.consecutive = 0
+		return
+	}
+	// Stay out at least until the probe after next
+	e.ejectedUntil = e.now().Add(2 * interval)
+}
+
+func (e *endpoint) healthy() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return !e.now().Before(e.ejectedUntil)
+}
+
+func (e *endpoint) cost() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.latency * float64(atomic.LoadInt64(&e.inFlight)+1) * (1 + errorPenalty*e.errorRate)
+}
+
+func (e *endpoint) stats() EndpointStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return EndpointStats{
+		URL:       e.url,
+		Healthy:   !e.now().Before(e.ejectedUntil),
+		Requests:  e.requests,
+		Errors:    e.errors,
+		InFlight:  atomic.LoadInt64(&e.inFlight),
+		Latency:   time.Duration(e.latency),
+		ErrorRate: e.errorRate,
+	}
+}
+
+type balancer struct {
+	policy    BalancerPolicy
+	endpoints []*endpoint
+	next      uint64
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newBalancer(policy BalancerPolicy, urls []string) *balancer {
+	b := &balancer{policy: policy, rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for _, url := range urls {
+		b.endpoints = append(b.endpoints, &endpoint{url: url, now: time.Now})
+	}
+	return b
+}
+
+// pick chooses the endpoint for the next request
+func (b *balancer) pick() *endpoint {
+	candidates := make([]*endpoint, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		if e.healthy() {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		// Everything is ejected; better to try than to fail outright
+		candidates = b.endpoints
+	}
+
+	switch b.policy {
+	case RoundRobin:
+		return candidates[int(atomic.AddUint64(&b.next, 1)-1)%len(candidates)]
+	case P2C:
+		if len(candidates) == 1 {
+			return candidates[0]
+		}
+		b.mu.Lock()
+		i := b.rng.Intn(len(candidates))
+		j := b.rng.Intn(len(candidates) - 1)
+		b.mu.Unlock()
+		if j >= i {
+			j++
+		}
+		if candidates[j].cost() < candidates[i].cost() {
+			return candidates[j]
+		}
+		return candidates[i]
+	default:
+		return candidates[0]
+	}
+}
+
+// probeEndpoints health-checks every endpoint each interval until ctx ends
+func (c *PolicyClient) probeEndpoints(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, e := range c.balancer.endpoints {
+			wg.Add(1)
+			go func(e *endpoint) {
+				defer wg.Done()
+				healthy := c.probe(ctx, e.url, interval)
+				// A probe cut short by Close says nothing about the endpoint
+				if ctx.Err() == nil {
+					e.probed(healthy, interval)
+				}
+			}(e)
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe reports whether the engine at baseURL answers its health check
+// within interval
+func (c *PolicyClient) probe(ctx context.Context, baseURL string, interval time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, interval)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/health", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return false
+	}
+	releaseBody(ctx, resp.Body)
+	return resp.StatusCode == http.StatusOK
+}
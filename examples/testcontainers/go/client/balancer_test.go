@@ -0,0 +1,164 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replica is a fake engine with its own latency and failure profile
+type replica struct {
+	*httptest.Server
+	delay     time.Duration
+	failing   atomic.Bool
+	unhealthy atomic.Bool
+	requests  int64
+}
+
+func newReplica(t *testing.T, delay time.Duration) *replica {
+	t.Helper()
+
+	r := &replica{delay: delay}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/health" {
+			if r.unhealthy.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		atomic.AddInt64(&r.requests, 1)
+		time.Sleep(r.delay)
+		if r.failing.Load() {
+			http.Error(w, `{"error":"overloaded"}`, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"result":true,"rule":["rule"],"data":null}`))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *replica) Requests() int64 {
+	return atomic.LoadInt64(&r.requests)
+}
+
+func evaluateN(c *PolicyClient, n int) {
+	for i := 0; i < n; i++ {
+		_, _ = c.EvaluatePolicy(context.Background(), "rule", nil, false)
+	}
+}
+
+// TestP2CAvoidsSlowAndFailingReplicas tests that traffic skews away from a slow and a failing replica
+func TestP2CAvoidsSlowAndFailingReplicas(t *testing.T) {
+	fast := newReplica(t, 0)
+	slow := newReplica(t, 20*time.Millisecond)
+	failing := newReplica(t, 0)
+	failing.failing.Store(true)
+
+	c, err := New(fast.URL, WithEndpoints(slow.URL, failing.URL), WithBalancer(P2C))
+	require.NoError(t, err)
+	defer c.Close()
+
+	evaluateN(c, 150)
+
+	assert.Greater(t, fast.Requests(), int64(75), "the fast replica takes most of the traffic")
+	assert.Less(t, slow.Requests(), fast.Requests()/2)
+	assert.LessOrEqual(t, failing.Requests(), int64(ejectAfter), "the failing replica is ejected")
+
+	stats := c.EndpointStats()
+	require.Len(t, stats, 3)
+	assert.Equal(t, fast.URL, stats[0].URL)
+	assert.True(t, stats[0].Healthy)
+	assert.Zero(t, stats[0].Errors)
+	assert.Greater(t, stats[1].Latency, stats[0].Latency)
+	assert.False(t, stats[2].Healthy)
+	assert.Equal(t, stats[2].Requests, stats[2].Errors)
+	assert.Greater(t, stats[2].ErrorRate, 0.5)
+}
+
+// TestRoundRobin tests that healthy replicas share traffic evenly
+func TestRoundRobin(t *testing.T) {
+	replicas := []*replica{newReplica(t, 0), newReplica(t, 0), newReplica(t, 0)}
+
+	c, err := New(replicas[0].URL, WithEndpoints(replicas[1].URL, replicas[2].URL), WithBalancer(RoundRobin))
+	require.NoError(t, err)
+	defer c.Close()
+
+	evaluateN(c, 30)
+	for _, r := range replicas {
+		assert.Equal(t, int64(10), r.Requests())
+	}
+}
+
+// TestFailover tests that Failover moves off a failing primary and back once it recovers
+func TestFailover(t *testing.T) {
+	primary := newReplica(t, 0)
+	secondary := newReplica(t, 0)
+
+	c, err := New(primary.URL, WithEndpoints(secondary.URL))
+	require.NoError(t, err)
+	defer c.Close()
+
+	evaluateN(c, 5)
+	assert.Equal(t, int64(5), primary.Requests())
+
+	primary.failing.Store(true)
+	evaluateN(c, 10)
+	assert.Equal(t, int64(5+ejectAfter), primary.Requests())
+	assert.Equal(t, int64(10-ejectAfter), secondary.Requests())
+
+	// Once the cooldown is over the primary is tried again
+	primary.failing.Store(false)
+	c.balancer.endpoints[0].now = func() time.Time { return time.Now().Add(ejectionCooldown) }
+	evaluateN(c, 2)
+	assert.Equal(t, int64(5+ejectAfter+2), primary.Requests())
+}
+
+// TestHealthProbes tests that probes eject an unhealthy replica and readmit it once it recovers
+func TestHealthProbes(t *testing.T) {
+	a := newReplica(t, 0)
+	b := newReplica(t, 0)
+	b.unhealthy.Store(true)
+
+	c, err := New(a.URL, WithEndpoints(b.URL), WithBalancer(RoundRobin), WithHealthProbes(10*time.Millisecond))
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.Eventually(t, func() bool { return !c.EndpointStats()[1].Healthy }, time.Second, 5*time.Millisecond)
+	evaluateN(c, 10)
+	assert.Equal(t, int64(10), a.Requests())
+	assert.Zero(t, b.Requests())
+
+	b.unhealthy.Store(false)
+	require.Eventually(t, func() bool { return c.EndpointStats()[1].Healthy }, time.Second, 5*time.Millisecond)
+	evaluateN(c, 10)
+	assert.Equal(t, int64(5), b.Requests())
+}
+
+// TestAllEndpointsEjected tests that requests still go out when every endpoint is ejected
+func TestAllEndpointsEjected(t *testing.T) {
+	a := newReplica(t, 0)
+	a.failing.Store(true)
+
+	c, err := New(a.URL, WithEndpoints(a.URL))
+	require.NoError(t, err)
+	defer c.Close()
+
+	evaluateN(c, 2*ejectAfter+2)
+	assert.Equal(t, int64(2*ejectAfter+2), a.Requests())
+}
+
+// TestWithEndpointsRejectsInvalidURL tests endpoint validation in New
+func TestWithEndpointsRejectsInvalidURL(t *testing.T) {
+	_, err := New("http://localhost:3000", WithEndpoints("not a url"))
+	assert.Error(t, err)
+}
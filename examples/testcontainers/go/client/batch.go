@@ -0,0 +1,405 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"sync"
+	"time"
+
+	"policy-engine-testcontainer-example/batch"
+	"policy-engine-testcontainer-example/policydata"
+)
+
+type batchConfig struct {
+	deduplicate bool
+	runner      batch.Runner
+	adaptive    *batch.Adaptive
+
+	traces    traceMode
+	traceSink func(index int, raw json.RawMessage) error
+	sinkMu    sync.Mutex
+
+	checkpoint *checkpointConfig
+	sink       ResultSink
+
+	// decisions are the items' decisions from an earlier batch, when Retry
+	// evaluates them again
+	decisions []*decision
+}
+
+// withDecisions has a batch continue the given decisions, one per item
+func withDecisions(decisions []*decision) BatchOption {
+	return func(cfg *batchConfig) {
+		cfg.decisions = decisions
+	}
+}
+
+// BatchOption configures EvaluateBatch
+type BatchOption func(*batchConfig)
+
+// WithDeduplication evaluates each distinct data document once, identifying
+// duplicates by policydata.CanonicalHash, and fans the response back out to
+// every position that held it. Each position gets its own copy of the
+// response. Reader data is never deduplicated, since hashing would consume it.
+func WithDeduplication() BatchOption {
+	return func(c *batchConfig) {
+		c.deduplicate = true
+	}
+}
+
+// WithWorkers sets how many evaluations run at once; the default is
+// GOMAXPROCS
+func WithWorkers(n int) BatchOption {
+	return func(c *batchConfig) {
+		c.runner.Workers = n
+	}
+}
+
+// WithMaxConsecutiveFailures aborts the batch once n evaluations in a row have
+// failed, e.g. because the engine has gone away
+func WithMaxConsecutiveFailures(n int) BatchOption {
+	return func(c *batchConfig) {
+		c.runner.MaxConsecutiveFailures = n
+	}
+}
+
+// WithProgress calls fn after every evaluation completes, one call at a time
+func WithProgress(fn func(batch.Progress)) BatchOption {
+	return func(c *batchConfig) {
+		c.runner.Progress = fn
+	}
+}
+
+// WithBackpressure runs the batch in adaptive chunks instead of over a fixed
+// pool of workers: the chunk size grows while the engine keeps up and halves
+// when it answers 429 Too Many Requests or a chunk is slower than
+// cfg.LatencyThreshold. Rejected items are retried after the Retry-After the
+// engine asked for, or cfg.Backoff. cfg.Min and cfg.Max bound the number of
+// evaluations in flight, so WithWorkers is ignored. Unless cfg sets its own,
+// WithProgress and WithMaxConsecutiveFailures apply, and the progress reports
+// the current chunk size and back-off.
+func WithBackpressure(cfg batch.Adaptive) BatchOption {
+	return func(c *batchConfig) {
+		c.adaptive = &cfg
+	}
+}
+
+// overloaded reports whether err is the engine pushing back
+func overloaded(err error) (time.Duration, bool) {
+	var overloadedErr *OverloadedError
+	if errors.As(err, &overloadedErr) {
+		return overloadedErr.RetryAfter, true
+	}
+	return 0, false
+}
+
+// EvaluateBatch evaluates rule against each data document over a bounded pool
+// of workers. The results always hold one entry per input, in input order,
+// each with either a response or the item's error, and the returned error is
+// a *BatchError when any item failed. If ctx is cancelled or the batch is
+// aborted, in-flight evaluations finish first, items never started fail with
+// ErrNotAttempted, and the cancellation or abort is the BatchError's Cause.
+// An engine supporting CapabilityBatch is sent the items gathered into POST
+// /batch, each still evaluated as its own request.
+func (c *PolicyClient) EvaluateBatch(ctx context.Context, rule string, datas []interface{}, opts ...BatchOption) (BatchResults, error) {
+	var cfg batchConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	ctx = c.withBatchSender(ctx, &cfg)
+	return runBatch(ctx, &cfg, rule, datas, func(ctx context.Context, i int, data interface{}) (*PolicyResponse, error) {
+		return c.evaluateItem(ctx, &cfg, rule, i, data)
+	})
+}
+
+// EvaluateBatchWith is EvaluateBatch for any Evaluator. A *PolicyClient runs
+// its own EvaluateBatch; other evaluators get one Evaluate call per item, and
+// the trace options, which need a PolicyClient, are ignored.
+func EvaluateBatchWith(ctx context.Context, e Evaluator, rule string, datas []interface{}, opts ...BatchOption) (BatchResults, error) {
+	if c, ok := e.(*PolicyClient); ok {
+		return c.EvaluateBatch(ctx, rule, datas, opts...)
+	}
+
+	var cfg batchConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return runBatch(ctx, &cfg, rule, datas, func(ctx context.Context, _ int, data interface{}) (response *PolicyResponse, err error) {
+		err = callback("evaluator", func() (err error) {
+			response, err = e.Evaluate(ctx, PolicyRequest{Rule: rule, Data: data})
+			return err
+		})
+		return response, err
+	})
+}
+
+// runBatch runs a batch configured by cfg, evaluating each distinct item
+// with evaluate
+func runBatch(ctx context.Context, cfg *batchConfig, rule string, datas []interface{}, evaluate func(ctx context.Context, index int, data interface{}) (*PolicyResponse, error)) (BatchResults, error) {
+
+	// firsts[i] is the index of the first item identical to item i, and
+	// uniques the items that are actually evaluated
+	firsts := make([]int, len(datas))
+	var uniques []int
+	seen := make(map[string]int, len(datas))
+	for i, data := range datas {
+		firsts[i] = i
+		if cfg.deduplicate {
+			if hash, ok := batchKey(data); ok {
+				if first, dup := seen[hash]; dup {
+					firsts[i] = first
+					continue
+				}
+				seen[hash] = i
+			}
+		}
+		uniques = append(uniques, i)
+	}
+
+	// Each item is one decision, whichever attempt at it answers
+	decisions := make([]*decision, len(datas))
+	for i := range decisions {
+		if i < len(cfg.decisions) && cfg.decisions[i] != nil {
+			decisions[i] = cfg.decisions[i]
+		} else {
+			decisions[i] = newDecision()
+		}
+	}
+
+	results := make(BatchResults, len(datas))
+	run := func(ctx context.Context, u int) error {
+		i := uniques[u]
+		response, err := evaluate(withDecision(ctx, decisions[i]), i, datas[i])
+		results[i].Response = response
+		return withDecisionID(err, decisions[i].id)
+	}
+
+	itemErrs, runErr := cfg.run(ctx, len(uniques), run)
+	for u, err := range itemErrs {
+		results[uniques[u]].Err = err
+	}
+
+	for i := range results {
+		result := &results[i]
+		result.Index = i
+		result.rule = rule
+		result.data = datas[i]
+		result.decision = decisions[i]
+		first := firsts[i]
+		if first == i {
+			continue
+		}
+		switch err := results[first].Err; {
+		case err == nil:
+			result.Response = cloneResponse(results[first].Response)
+		case errors.Is(err, ErrNotAttempted):
+			result.Err = ErrNotAttempted
+		default:
+			result.Err = fmt.Errorf("duplicate of failed item %d: %w", first, err)
+		}
+	}
+
+	return results, results.err(runErr)
+}
+
+// run processes n items with fn over the configured runner. A panicking
+// progress callback stops the run, with its *CallbackPanicError as the cause.
+func (cfg *batchConfig) run(ctx context.Context, n int, fn batch.Func) ([]error, error) {
+	runCtx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+	protect := func(progress func(batch.Progress)) func(batch.Progress) {
+		if progress == nil {
+			return nil
+		}
+		return func(p batch.Progress) {
+			if err := callback("batch progress", func() error { progress(p); return nil }); err != nil {
+				stop(err)
+			}
+		}
+	}
+
+	var errs []error
+	var err error
+	if adaptive := cfg.adaptive; adaptive == nil {
+		runner := cfg.runner
+		runner.Progress = protect(runner.Progress)
+		errs, err = runner.Run(runCtx, n, fn)
+	} else {
+		run := *adaptive
+		if run.Overloaded == nil {
+			run.Overloaded = overloaded
+		}
+		if run.Progress == nil {
+			run.Progress = cfg.runner.Progress
+		}
+		run.Progress = protect(run.Progress)
+		if run.MaxConsecutiveFailures == 0 {
+			run.MaxConsecutiveFailures = cfg.runner.MaxConsecutiveFailures
+		}
+		errs, err = run.Run(runCtx, n, fn)
+	}
+	if err != nil && ctx.Err() == nil {
+		var panicErr *CallbackPanicError
+		if errors.As(context.Cause(runCtx), &panicErr) {
+			err = panicErr
+		}
+	}
+	return errs, err
+}
+
+// batchKey returns the canonical hash identifying duplicate batch items
+func batchKey(data interface{}) (string, bool) {
+	switch value := data.(type) {
+	case io.Reader:
+		return "", false
+	case []byte:
+		// Byte slices are raw JSON to the client, not base64 strings
+		data = json.RawMessage(value)
+	}
+
+	hash, err := policydata.CanonicalHash(data)
+	if err != nil {
+		// Let the evaluation itself report the problem
+		return "", false
+	}
+	return hash, true
+}
+
+// EvaluateStream evaluates rule against each document items yields, such as
+// the records of policydata.LoadCSV, and yields the responses in input order.
+// An error from items or from evaluating an item is yielded in that item's
+// place and the stream carries on; it ends early if ctx is cancelled. Of opts,
+// the trace options, WithCheckpoint and WithResultSink apply, with indexes
+// counting the documents yielded. A checkpoint or sink that fails ends the
+// stream with its error.
+func (c *PolicyClient) EvaluateStream(ctx context.Context, rule string, items iter.Seq2[interface{}, error], opts ...BatchOption) iter.Seq2[*PolicyResponse, error] {
+	var cfg batchConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(yield func(*PolicyResponse, error) bool) {
+		checkpoint, err := resumeCheckpoint(ctx, cfg.checkpoint)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		// Whichever way the stream ends, bar a panic, record how far it got
+		stopped := func() error {
+			if checkpoint == nil {
+				return nil
+			}
+			return checkpoint.save(context.WithoutCancel(ctx))
+		}
+
+		index := -1
+		for data, err := range items {
+			index++
+			if checkpoint != nil && index < checkpoint.current.Next {
+				continue
+			}
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				_ = stopped()
+				yield(nil, ctxErr)
+				return
+			}
+			var response *PolicyResponse
+			if err == nil {
+				response, err = c.evaluateItem(withDecision(ctx, newDecision()), &cfg, rule, index, data)
+			}
+			if cfg.sink != nil {
+				if sinkErr := cfg.sink.Write(ctx, BatchResult{Index: index, Response: response, Err: err}); sinkErr != nil {
+					_ = stopped()
+					yield(nil, sinkErr)
+					return
+				}
+			}
+			if !yield(response, err) {
+				// The consumer has the item; nobody is left to tell of a
+				// failed save
+				if checkpoint != nil {
+					checkpoint.count(index, response, err)
+				}
+				_ = stopped()
+				return
+			}
+			if checkpoint != nil {
+				if err := checkpoint.done(ctx, index, response, err); err != nil {
+					yield(nil, err)
+					return
+				}
+			}
+		}
+		if err := stopped(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// cloneResponse deep-copies a response so callers can't affect each other
+func cloneResponse(r *PolicyResponse) *PolicyResponse {
+	clone := *r
+	if r.Error != nil {
+		message := *r.Error
+		clone.Error = &message
+	}
+	if r.EngineError != nil {
+		engineErr := *r.EngineError
+		clone.EngineError = &engineErr
+	}
+	if r.SchemaSkew != nil {
+		clone.SchemaSkew = &SchemaSkewWarning{
+			Unknown: append([]string(nil), r.SchemaSkew.Unknown...),
+			Missing: append([]string(nil), r.SchemaSkew.Missing...),
+		}
+	}
+	if r.Trace != nil {
+		clone.Trace = cloneValue(r.Trace).(map[string]interface{})
+	}
+	if r.ExecutionTrace != nil {
+		clone.ExecutionTrace = r.ExecutionTrace.clone()
+	}
+	if r.Labels != nil {
+		clone.Labels = make(map[string]bool, len(r.Labels))
+		for label, value := range r.Labels {
+			clone.Labels[label] = value
+		}
+	}
+	if r.Warnings != nil {
+		clone.Warnings = append([]Warning{}, r.Warnings...)
+	}
+	clone.Rule = append([]string(nil), r.Rule...)
+	clone.Data = cloneValue(r.Data)
+	if r.Summary != nil {
+		summary := *r.Summary
+		summary.Via = append([]string(nil), r.Summary.Via...)
+		summary.Actual = cloneValue(r.Summary.Actual)
+		summary.Expected = cloneValue(r.Summary.Expected)
+		clone.Summary = &summary
+	}
+	return &clone
+}
+
+// cloneValue deep-copies decoded JSON
+func cloneValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			copied[key] = cloneValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, item := range value {
+			copied[i] = cloneValue(item)
+		}
+		return copied
+	default:
+		return v
+	}
+}
@@ -0,0 +1,340 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"policy-engine-testcontainer-example/batch"
+	"policy-engine-testcontainer-example/policydata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEvaluateBatch tests that results keep input order and failures don't stop the batch
+func TestEvaluateBatch(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	datas := []interface{}{
+		map[string]interface{}{"n": 0},
+		make(chan int),
+		map[string]interface{}{"n": 2},
+	}
+	results, err := c.EvaluateBatch(context.Background(), "rule", datas)
+
+	var unsupported *UnsupportedDataError
+	assert.True(t, errors.As(err, &unsupported))
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 3, batchErr.Total)
+	assert.Nil(t, batchErr.Cause)
+	assert.EqualError(t, err, "1 of 3 batch items failed: item 1: unsupported request data type chan int")
+
+	require.Len(t, results, 3)
+	for i, result := range results {
+		assert.Equal(t, i, result.Index)
+	}
+	assert.Nil(t, results[1].Response)
+	assert.ErrorAs(t, results[1].Err, &unsupported)
+	assert.Equal(t, map[string]interface{}{"n": json.Number("0")}, results[0].Response.Data)
+	assert.Equal(t, map[string]interface{}{"n": json.Number("2")}, results[2].Response.Data)
+
+	assert.Equal(t, []int{1}, indexes(results.Failed()))
+	assert.Equal(t, []int{0, 2}, indexes(results.Successes()))
+}
+
+func indexes(results BatchResults) []int {
+	var out []int
+	for _, result := range results {
+		out = append(out, result.Index)
+	}
+	return out
+}
+
+// TestEvaluateBatchCancelled tests that a cancelled context stops the batch
+func TestEvaluateBatchCancelled(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err := c.EvaluateBatch(ctx, "rule", []interface{}{nil, nil})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []*PolicyResponse{nil, nil}, results.Responses())
+	for _, result := range results {
+		assert.Same(t, ErrNotAttempted, result.Err)
+	}
+	assert.Empty(t, engine.Requests())
+}
+
+// TestEvaluateStreamCSV streams 100k CSV rows through the client without buffering them
+func TestEvaluateStreamCSV(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams 100k evaluations")
+	}
+
+	const rows = 100_000
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	pr, pw := io.Pipe()
+	go func() {
+		fmt.Fprintln(pw, "id,total,tier")
+		for i := 0; i < rows; i++ {
+			if i == 500 {
+				fmt.Fprintln(pw, "bad,not-a-number,gold")
+				continue
+			}
+			fmt.Fprintf(pw, "%d,%d,\"gold, legacy\"\n", i, i%200)
+		}
+		pw.Close()
+	}()
+
+	mapping := policydata.CSVMapping{Columns: []policydata.CSVColumn{
+		{Column: "total", Path: "Order.total", Type: policydata.CSVNumber},
+		{Column: "tier", Path: "Customer.membership_level"},
+	}}
+
+	var evaluated int
+	var rowErrs []*policydata.RowError
+	for response, err := range c.EvaluateStream(context.Background(), "rule", policydata.LoadCSV(pr, mapping, policydata.SkipMalformed())) {
+		var rowErr *policydata.RowError
+		if errors.As(err, &rowErr) {
+			rowErrs = append(rowErrs, rowErr)
+			continue
+		}
+		require.NoError(t, err)
+		require.True(t, response.Result)
+		evaluated++
+	}
+
+	assert.Equal(t, rows-1, evaluated)
+	require.Len(t, rowErrs, 1)
+	assert.Equal(t, 502, rowErrs[0].Line)
+	assert.Len(t, engine.Requests(), rows-1)
+}
+
+// TestEvaluateBatchDeduplication tests that 10k rows with 500 distinct payloads cost 500 requests
+func TestEvaluateBatchDeduplication(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	const rows, unique = 10_000, 500
+	datas := make([]interface{}, rows)
+	for i := range datas {
+		n := i % unique
+		if i%2 == 0 {
+			datas[i] = map[string]interface{}{"Order": map[string]interface{}{"id": n, "currency": "GBP"}}
+		} else {
+			// Same document, different key order and whitespace
+			datas[i] = json.RawMessage(fmt.Sprintf(`{ "Order": { "currency": "GBP", "id": %d } }`, n))
+		}
+	}
+
+	results, err := c.EvaluateBatch(context.Background(), "rule", datas, WithDeduplication())
+	require.NoError(t, err)
+	assert.Len(t, engine.Requests(), unique)
+	responses := results.Responses()
+
+	require.Len(t, responses, rows)
+	for i, response := range responses {
+		require.NotNil(t, response, "item %d", i)
+		order := response.Data.(map[string]interface{})["Order"].(map[string]interface{})
+		assert.Equal(t, json.Number(fmt.Sprint(i%unique)), order["id"], "item %d", i)
+	}
+
+	// Fanned-out responses are independent copies
+	responses[unique].Data.(map[string]interface{})["Order"].(map[string]interface{})["id"] = -1
+	assert.Equal(t, json.Number("0"), responses[0].Data.(map[string]interface{})["Order"].(map[string]interface{})["id"])
+}
+
+// TestEvaluateBatchDeduplicationFailures tests that duplicates of a failed item fail too
+func TestEvaluateBatchDeduplicationFailures(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL, WithMaxRequestBytes(64))
+	require.NoError(t, err)
+
+	large := map[string]interface{}{"note": strings.Repeat("x", 100)}
+	results, err := c.EvaluateBatch(context.Background(), "rule", []interface{}{large, nil, large}, WithDeduplication())
+	var tooLarge *RequestTooLargeError
+	assert.ErrorAs(t, err, &tooLarge)
+	require.Len(t, results, 3)
+	assert.Nil(t, results[0].Response)
+	assert.NotNil(t, results[1].Response)
+	assert.Nil(t, results[2].Response)
+	assert.ErrorContains(t, results[2].Err, "duplicate of failed item 0")
+	assert.ErrorAs(t, results[2].Err, &tooLarge)
+	assert.Len(t, engine.Requests(), 1)
+}
+
+// throttledEngine answers 429 to any request arriving while limit others are
+// already in flight, like a rate-limiting proxy in front of the engine
+type throttledEngine struct {
+	*httptest.Server
+
+	limit              int64
+	inFlight, rejected int64
+	served             int64
+}
+
+func newThrottledEngine(t *testing.T, limit int64, retryAfter string) *throttledEngine {
+	t.Helper()
+
+	engine := &throttledEngine{limit: limit}
+	engine.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer atomic.AddInt64(&engine.inFlight, -1)
+		if atomic.AddInt64(&engine.inFlight, 1) > atomic.LoadInt64(&engine.limit) {
+			atomic.AddInt64(&engine.rejected, 1)
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		var req PolicyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		time.Sleep(2 * time.Millisecond)
+		atomic.AddInt64(&engine.served, 1)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(PolicyResponse{Result: true, Data: req.Data})
+	}))
+	t.Cleanup(engine.Close)
+
+	return engine
+}
+
+// TestEvaluateBatchBackpressure tests that chunks settle just under a server's concurrency limit
+func TestEvaluateBatchBackpressure(t *testing.T) {
+	engine := newThrottledEngine(t, 4, "")
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	datas := make([]interface{}, 200)
+	for i := range datas {
+		datas[i] = map[string]interface{}{"n": i}
+	}
+
+	var last batch.Progress
+	peak := 0
+	results, err := c.EvaluateBatch(context.Background(), "rule", datas,
+		WithBackpressure(batch.Adaptive{Min: 1, Max: 32, Backoff: time.Millisecond}),
+		WithProgress(func(p batch.Progress) {
+			last = p
+			peak = max(peak, p.ChunkSize)
+		}))
+	require.NoError(t, err)
+
+	for i, result := range results {
+		require.NotNil(t, result.Response, "item %d", i)
+		assert.Equal(t, map[string]interface{}{"n": json.Number(fmt.Sprint(i))}, result.Response.Data)
+	}
+	rejected := int(atomic.LoadInt64(&engine.rejected))
+	assert.LessOrEqual(t, rejected, len(datas)/5, "chunks kept growing past the limit")
+	assert.Equal(t, rejected, last.Retried)
+	assert.Equal(t, batch.Progress{Total: 200, Completed: 200, ChunkSize: last.ChunkSize, Retried: rejected}, last)
+	assert.LessOrEqual(t, peak, 6, "chunk size should hover around the limit")
+	assert.Equal(t, int64(200), atomic.LoadInt64(&engine.served))
+}
+
+// TestEvaluateBatchBackpressureRetryAfter tests that a back-off waits as long as Retry-After asks
+func TestEvaluateBatchBackpressureRetryAfter(t *testing.T) {
+	engine := newThrottledEngine(t, 0, "1")
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	var backoffs []time.Duration
+	start := time.Now()
+	go func() {
+		// Let the first attempt through the second time round
+		time.Sleep(500 * time.Millisecond)
+		atomic.StoreInt64(&engine.limit, 1)
+	}()
+	results, err := c.EvaluateBatch(context.Background(), "rule", []interface{}{nil},
+		WithBackpressure(batch.Adaptive{Backoff: time.Millisecond}),
+		WithProgress(func(p batch.Progress) {
+			if p.Backoff > 0 {
+				backoffs = append(backoffs, p.Backoff)
+			}
+		}))
+	require.NoError(t, err)
+	require.NotNil(t, results[0].Response)
+
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.Equal(t, []time.Duration{time.Second}, backoffs)
+}
+
+// TestEvaluateBatchBackpressureGivesUp tests that an item rejected MaxRetries times reports the rejection
+func TestEvaluateBatchBackpressureGivesUp(t *testing.T) {
+	engine := newThrottledEngine(t, 0, "")
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	results, err := c.EvaluateBatch(context.Background(), "rule", []interface{}{nil, nil},
+		WithBackpressure(batch.Adaptive{Initial: 2, MaxRetries: 3, Backoff: time.Millisecond}))
+	var overloadedErr *OverloadedError
+	assert.ErrorAs(t, err, &overloadedErr)
+	assert.Equal(t, []int{0, 1}, indexes(results.Failed()))
+	for _, result := range results {
+		assert.ErrorAs(t, result.Err, &overloadedErr)
+	}
+	assert.Equal(t, []*PolicyResponse{nil, nil}, results.Responses())
+	assert.Equal(t, int64(8), atomic.LoadInt64(&engine.rejected))
+}
+
+// BenchmarkEvaluateBatch compares 200 evaluations against an engine taking a
+// millisecond each, made one after another and as a batch
+func BenchmarkEvaluateBatch(b *testing.B) {
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		time.Sleep(time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"result":true,"rule":["rule"],"data":{}}`)
+	}))
+	b.Cleanup(engine.Close)
+	c, err := New(engine.URL)
+	if err != nil {
+		b.Fatal(err)
+	}
+	datas := make([]interface{}, 200)
+	for i := range datas {
+		datas[i] = map[string]interface{}{"n": i}
+	}
+
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, data := range datas {
+				if _, err := c.EvaluatePolicy(context.Background(), "rule", data, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	for _, workers := range []int{8, 32} {
+		b.Run(fmt.Sprintf("batch-%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := c.EvaluateBatch(context.Background(), "rule", datas, WithWorkers(workers)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchLinger is how long a batch sender waits for more evaluations before
+// posting the ones it has
+const batchLinger = 2 * time.Millisecond
+
+// batchSenderKey carries an EvaluateBatch's batchSender to the requests its
+// items send
+type batchSenderKey struct{}
+
+// batchSender gathers the evaluation requests of one EvaluateBatch, as they
+// leave the client's middleware, and posts them to the engine's /batch
+// together. Each item is still its own evaluation to the client, retried,
+// hedged, logged and recorded as it would be sent alone.
+type batchSender struct {
+	ctx context.Context
+	// owner is the connection owner of the client whose requests are
+	// batched, so a shadow client reading the same context sends its own
+	owner *PolicyClient
+	size  int
+
+	mu      sync.Mutex
+	pending map[string][]*batchItem
+}
+
+// batchItem is one evaluation request waiting for its batch
+type batchItem struct {
+	req  *http.Request
+	body json.RawMessage
+	done chan batchResult
+}
+
+// batchResult is the answer to one batchItem: a response, an error, or
+// alone when the item must be sent by itself
+type batchResult struct {
+	resp  *http.Response
+	err   error
+	alone bool
+}
+
+// batchEnvelope is the body of POST /batch
+type batchEnvelope struct {
+	Requests []batchRequest `json:"requests"`
+}
+
+// batchRequest is one evaluation in a batchEnvelope, with the headers it
+// would have been sent with
+type batchRequest struct {
+	Header http.Header     `json:"header,omitempty"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// batchReply is the engine's answer to POST /batch, one response per
+// request in order
+type batchReply struct {
+	Responses []batchResponse `json:"responses"`
+}
+
+// batchResponse is the engine's answer to one batchRequest; a body that is
+// not JSON, such as a plain-text error, comes as a string
+type batchResponse struct {
+	Status int             `json:"status"`
+	Header http.Header     `json:"header,omitempty"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// withBatchSender has the items of an EvaluateBatch configured by cfg posted
+// together to an engine supporting CapabilityBatch, and counts them sent one
+// by one for one that does not
+func (c *PolicyClient) withBatchSender(ctx context.Context, cfg *batchConfig) context.Context {
+	if !c.supports(ctx, CapabilityBatch) {
+		c.degradeFeature(CapabilityBatch)
+		return ctx
+	}
+	size := cfg.runner.Workers
+	if cfg.adaptive != nil {
+		size = cfg.adaptive.Max
+		if size <= 0 {
+			// batch.Adaptive's default Max
+			size = 64
+		}
+	}
+	if size <= 0 {
+		size = runtime.GOMAXPROCS(0)
+	}
+	return context.WithValue(ctx, batchSenderKey{}, &batchSender{ctx: ctx, owner: c.connectionOwner(), size: size, pending: map[string][]*batchItem{}})
+}
+
+// batchRequests is the middleware gathering batched evaluations, innermost
+func (c *PolicyClient) batchRequests(next Doer) Doer {
+	return DoerFunc(func(req *http.Request) (*http.Response, error) {
+		sender, ok := req.Context().Value(batchSenderKey{}).(*batchSender)
+		if !ok || sender.owner != c.connectionOwner() || req.Method != http.MethodPost {
+			return next.Do(req)
+		}
+		return sender.send(next, req)
+	})
+}
+
+// send waits for req's place in a batch and returns its response. A request
+// whose body cannot be read again is sent alone.
+func (s *batchSender) send(next Doer, req *http.Request) (*http.Response, error) {
+	body, ok := readRequestBody(req)
+	if !ok || !json.Valid(body) {
+		return next.Do(req)
+	}
+	item := &batchItem{req: req, body: body, done: make(chan batchResult, 1)}
+	s.add(next, item)
+
+	select {
+	case result := <-item.done:
+		if result.alone {
+			return next.Do(req)
+		}
+		return result.resp, result.err
+	case <-req.Context().Done():
+		s.remove(item)
+		return nil, req.Context().Err()
+	}
+}
+
+// add queues item, posting its batch once it is full, or after batchLinger
+// if it is the batch's first item
+func (s *batchSender) add(next Doer, item *batchItem) {
+	target := item.req.URL.String()
+	s.mu.Lock()
+	s.pending[target] = append(s.pending[target], item)
+	switch n := len(s.pending[target]); {
+	case n >= s.size:
+		items := s.take(target)
+		s.mu.Unlock()
+		go s.post(next, target, items)
+		return
+	case n == 1:
+		time.AfterFunc(batchLinger, func() {
+			s.mu.Lock()
+			items := s.take(target)
+			s.mu.Unlock()
+			if len(items) > 0 {
+				s.post(next, target, items)
+			}
+		})
+	}
+	s.mu.Unlock()
+}
+
+// take removes and returns the items waiting for target; s.mu must be held
+func (s *batchSender) take(target string) []*batchItem {
+	items := s.pending[target]
+	delete(s.pending, target)
+	return items
+}
+
+// remove drops item from its batch if the batch has not been posted
+func (s *batchSender) remove(item *batchItem) {
+	target := item.req.URL.String()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := s.pending[target]
+	for i, pending := range items {
+		if pending == item {
+			s.pending[target] = append(items[:i:i], items[i+1:]...)
+			return
+		}
+	}
+}
+
+// post sends items to target's /batch and hands each its response. An
+// engine without /batch has each sent alone, and a failed batch fails each.
+func (s *batchSender) post(next Doer, target string, items []*batchItem) {
+	finish := func(result batchResult) {
+		for _, item := range items {
+			item.done <- result
+		}
+	}
+	if len(items) == 1 {
+		finish(batchResult{alone: true})
+		return
+	}
+
+	envelope := batchEnvelope{Requests: make([]batchRequest, len(items))}
+	for i, item := range items {
+		header := item.req.Header.Clone()
+		header.Del("Content-Encoding")
+		header.Del("Accept-Encoding")
+		header.Del("Content-Length")
+		envelope.Requests[i] = batchRequest{Header: header, Body: item.body}
+	}
+	encoded, err := json.Marshal(envelope)
+	if err != nil {
+		finish(batchResult{err: fmt.Errorf("failed to marshal batch: %w", err)})
+		return
+	}
+	httpReq, err := http.NewRequestWithContext(s.ctx, http.MethodPost, strings.TrimSuffix(target, "/")+"/batch", bytes.NewReader(encoded))
+	if err != nil {
+		finish(batchResult{err: fmt.Errorf("failed to build batch request: %w", err)})
+		return
+	}
+	httpReq.Header = commonHeader(items)
+	httpReq.Header.Del("Content-Encoding")
+	httpReq.Header.Del("Accept-Encoding")
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.ContentLength = int64(len(encoded))
+
+	resp, err := next.Do(httpReq)
+	if err != nil {
+		finish(batchResult{err: err})
+		return
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	switch {
+	case err != nil:
+		finish(batchResult{err: err})
+		return
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusMethodNotAllowed:
+		// The engine has dropped /batch since it was probed
+		finish(batchResult{alone: true})
+		return
+	case resp.StatusCode != http.StatusOK:
+		// The whole batch was refused, e.g. with a 429; each item reads the
+		// refusal as its own
+		for _, item := range items {
+			item.done <- batchResult{resp: itemResponse(item.req, resp.StatusCode, resp.Header, raw)}
+		}
+		return
+	}
+
+	var reply batchReply
+	if err := json.Unmarshal(raw, &reply); err != nil {
+		finish(batchResult{err: fmt.Errorf("failed to decode batch response: %w", err)})
+		return
+	}
+	if len(reply.Responses) != len(items) {
+		finish(batchResult{err: fmt.Errorf("failed to decode batch response: %d responses for %d requests", len(reply.Responses), len(items))})
+		return
+	}
+	for i, item := range items {
+		answer := reply.Responses[i]
+		body := []byte(answer.Body)
+		var text string
+		if !strings.Contains(answer.Header.Get("Content-Type"), "json") && json.Unmarshal(body, &text) == nil {
+			body = []byte(text)
+		}
+		item.done <- batchResult{resp: itemResponse(item.req, answer.Status, answer.Header, body)}
+	}
+}
+
+// commonHeader is the headers every item is sent with, such as credentials,
+// leaving each item's decision ID and the like to the item itself
+func commonHeader(items []*batchItem) http.Header {
+	header := items[0].req.Header.Clone()
+	for name, values := range header {
+		for _, item := range items[1:] {
+			if !slices.Equal(item.req.Header[name], values) {
+				delete(header, name)
+				break
+			}
+		}
+	}
+	return header
+}
+
+// itemResponse is the response req would have had sent alone
+func itemResponse(req *http.Request, status int, header http.Header, body []byte) *http.Response {
+	header = header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Del("Content-Length")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
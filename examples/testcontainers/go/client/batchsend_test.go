@@ -0,0 +1,67 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/enginetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEvaluateBatchEndpoint tests that EvaluateBatch posts its items to an
+// engine's /batch together, each answered as if sent alone, and falls back
+// to one request per item for an engine without it
+func TestEvaluateBatchEndpoint(t *testing.T) {
+	datas := make([]interface{}, 40)
+	for i := range datas {
+		role := "guest"
+		if i%3 == 0 {
+			role = "admin"
+		}
+		datas[i] = withRole(role)
+	}
+	check := func(t *testing.T, results client.BatchResults) {
+		ids := map[string]bool{}
+		for i, result := range results {
+			require.NoError(t, result.Err, "item %d", i)
+			assert.Equal(t, i%3 == 0, result.Response.Result, "item %d", i)
+			assert.Equal(t, datas[i], result.Response.Data, "item %d", i)
+			ids[result.Response.DecisionID] = true
+		}
+		assert.Len(t, ids, len(datas), "each item is its own decision")
+	}
+
+	t.Run("batched", func(t *testing.T) {
+		engine, c := newAsyncClient(t, enginetest.WithBatch())
+		results, err := c.EvaluateBatch(context.Background(), accessRule, datas, client.WithWorkers(8))
+		require.NoError(t, err)
+		check(t, results)
+		assert.Positive(t, engine.Batches())
+		assert.Less(t, engine.Batches(), len(datas))
+		assert.Empty(t, c.DegradedFeatures())
+	})
+
+	t.Run("engine errors", func(t *testing.T) {
+		engine, c := newAsyncClient(t, enginetest.WithBatch())
+		results, err := c.EvaluateBatch(context.Background(), "not a rule", datas[:8], client.WithWorkers(8))
+		var batchErr *client.BatchError
+		require.ErrorAs(t, err, &batchErr)
+		for _, result := range results {
+			var engineErr *client.EngineError
+			assert.ErrorAs(t, result.Err, &engineErr, "each item gets its own answer")
+		}
+		assert.Positive(t, engine.Batches())
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		engine, c := newAsyncClient(t, enginetest.WithCapabilities(client.CapabilityAsOf))
+		results, err := c.EvaluateBatch(context.Background(), accessRule, datas, client.WithWorkers(8))
+		require.NoError(t, err)
+		check(t, results)
+		assert.Zero(t, engine.Batches())
+		assert.Equal(t, map[client.Capability]int64{client.CapabilityBatch: 1}, c.DegradedFeatures())
+	})
+}
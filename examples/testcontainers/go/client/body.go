@@ -0,0 +1,403 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"policy-engine-testcontainer-example/policydata"
+)
+
+// defaultStreamingThreshold is the encoded size above which request bodies are
+// streamed onto the wire instead of being buffered in memory first
+const defaultStreamingThreshold = 1 << 20
+
+// bufferPool recycles the buffers small request bodies are encoded into, and
+// writerPool the bufio.Writers streamed bodies are encoded through
+var (
+	bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	writerPool = sync.Pool{New: func() interface{} { return bufio.NewWriterSize(nil, 32*1024) }}
+)
+
+// maxPooledBuffer stops one unusually large body from pinning its buffer in
+// the pool
+const maxPooledBuffer = 4 << 20
+
+// dataPlaceholder marks where the data goes in an encoded envelope. Another
+// field, such as a rule, may encode to the same bytes, so it is only looked
+// for right after the data key; see newEnvelope.
+const dataPlaceholder = `"\u0000policy-engine-data\u0000"`
+
+// dataMember is the data key followed by the placeholder. The quotes around
+// the key can't appear unescaped inside a JSON string, so this only matches
+// the request's own data member.
+const dataMember = `"data":` + dataPlaceholder
+
+// envelope is an encoded PolicyRequest with a hole where the data goes, so the
+// data can be encoded separately, possibly straight onto the wire
+type envelope struct {
+	prefix []byte
+	suffix []byte
+}
+
+// multiRuleRequest is a PolicyRequest as an engine supporting
+// CapabilityMultiRule takes its rules, one by one
+type multiRuleRequest struct {
+	Rules []string    `json:"rules"`
+	Data  interface{} `json:"data"`
+	Trace bool        `json:"trace,omitempty"`
+}
+
+// newEnvelope encodes req around the data placeholder, with its Rules as a
+// "rules" array if it still has them
+func newEnvelope(req PolicyRequest, escapeHTML bool) (envelope, error) {
+	req.Data = json.RawMessage(dataPlaceholder)
+	var wire interface{} = req
+	if len(req.Rules) > 0 {
+		wire = multiRuleRequest{Rules: req.Rules, Data: req.Data, Trace: req.Trace}
+	}
+	encoded, err := policydata.EncodeBytes(wire, policydata.EncodeConfig{EscapeHTML: escapeHTML})
+	if err != nil {
+		return envelope{}, err
+	}
+
+	i := bytes.Index(encoded, []byte(dataMember))
+	if i < 0 {
+		return envelope{}, errors.New("data placeholder missing from encoded request")
+	}
+	i += len(dataMember) - len(dataPlaceholder)
+	return envelope{prefix: encoded[:i], suffix: encoded[i+len(dataPlaceholder):]}, nil
+}
+
+// requestBody produces the encoded request as many times as needed, so a
+// retried attempt can send the whole body again after a partial write
+type requestBody struct {
+	envelope envelope
+	data     interface{}
+	// buffered holds the complete encoding when it was small enough to keep
+	buffered []byte
+	// length is the encoded size, or -1 when streaming without a counting pass
+	length int64
+	// maxBytes caps bodies whose size can only be learned while streaming
+	maxBytes int64
+	// rawTrace asks for the response's trace to be kept undecoded
+	rawTrace bool
+	// escapeHTML is bodyOptions.escapeHTML, for data encoded while streaming
+	escapeHTML bool
+	// compressed gzips the body as it is sent; it is cleared for an engine
+	// that refuses compressed requests
+	compressed atomic.Bool
+
+	// pooled is the pool buffer behind buffered. It goes back to the pool once
+	// the request is released and every reader opened over it is closed.
+	mu       sync.Mutex
+	pooled   *bytes.Buffer
+	readers  int
+	released bool
+}
+
+// bodyOptions controls how a request body is encoded
+type bodyOptions struct {
+	// streamingThreshold is the size above which bodies are streamed; zero or
+	// less always buffers
+	streamingThreshold int
+	// countLength gives streamed bodies an exact length from a counting pass,
+	// for servers that refuse chunked requests
+	countLength bool
+	// maxBytes rejects bodies larger than this before any network I/O
+	maxBytes int64
+	// escapeHTML escapes <, > and & in strings as json.Marshal does
+	escapeHTML bool
+	// canonical encodes data in policydata.CanonicalJSON form
+	canonical bool
+	// compressAbove gzips bodies whose encoding is larger than this; zero or
+	// less never does
+	compressAbove int
+}
+
+// newRequestBody encodes small requests up front and prepares large ones for
+// streaming
+func newRequestBody(req PolicyRequest, opts bodyOptions) (*requestBody, error) {
+	env, err := newEnvelope(req, opts.escapeHTML)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	return newEnvelopeBody(env, req.Data, opts)
+}
+
+// newEnvelopeBody is newRequestBody for an envelope encoded earlier, such as
+// a PreparedPolicy's
+func newEnvelopeBody(env envelope, data interface{}, opts bodyOptions) (*requestBody, error) {
+	if _, ok := data.(*readerData); ok {
+		// A reader can only be consumed once up front, so it is always
+		// streamed and its size is enforced as it goes
+		return &requestBody{envelope: env, data: data, length: -1, maxBytes: opts.maxBytes}, nil
+	}
+
+	data, err := flattenData(data, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	body := &requestBody{envelope: env, data: data, length: -1, escapeHTML: opts.escapeHTML}
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	if opts.streamingThreshold <= 0 {
+		if _, err := body.WriteTo(buf); err != nil {
+			putBuffer(buf)
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+	} else {
+		capped := &cappedWriter{w: buf, limit: opts.streamingThreshold}
+		_, err := body.WriteTo(capped)
+		switch {
+		case errors.Is(err, errCapExceeded):
+			putBuffer(buf)
+			// Too large to hold in memory; stream it when the request is sent
+			if opts.countLength || opts.maxBytes > 0 {
+				counter := &countingWriter{}
+				if _, err := body.WriteTo(counter); err != nil {
+					return nil, fmt.Errorf("failed to marshal request: %w", err)
+				}
+				body.length = counter.n
+			}
+			if err := body.decideCompression(opts); err != nil {
+				return nil, err
+			}
+			return body, body.checkSize(opts.maxBytes)
+		case err != nil:
+			putBuffer(buf)
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+	}
+
+	body.pooled = buf
+	body.buffered = buf.Bytes()
+	body.length = int64(len(body.buffered))
+	if err := body.decideCompression(opts); err != nil {
+		return nil, err
+	}
+	return body, body.checkSize(opts.maxBytes)
+}
+
+// decideCompression compresses the body if it is larger than
+// opts.compressAbove, counting a streamed body's size if it must
+func (b *requestBody) decideCompression(opts bodyOptions) error {
+	if opts.compressAbove <= 0 {
+		return nil
+	}
+	if b.length < 0 {
+		if b.streamed() && opts.streamingThreshold >= opts.compressAbove {
+			// Streamed bodies are larger than the streaming threshold
+			b.compressed.Store(true)
+			return nil
+		}
+		counter := &countingWriter{}
+		if _, err := b.WriteTo(counter); err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		b.length = counter.n
+	}
+	b.compressed.Store(b.length > int64(opts.compressAbove))
+	return nil
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// release hands the body's buffer back to the pool once the request is done
+// with it; the body must not be opened again afterwards
+func (b *requestBody) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.released = true
+	b.recycle()
+}
+
+// recycle returns the buffer once nothing can read it any more; b.mu is held
+func (b *requestBody) recycle() {
+	if b.released && b.readers == 0 && b.pooled != nil {
+		putBuffer(b.pooled)
+		b.pooled = nil
+		b.buffered = nil
+	}
+}
+
+func (b *requestBody) checkSize(maxBytes int64) error {
+	if maxBytes > 0 && b.length > maxBytes {
+		return &RequestTooLargeError{Size: b.length, Limit: maxBytes}
+	}
+	return nil
+}
+
+// replayable reports whether the body can be sent more than once at a time;
+// reader data can only be consumed by one request
+func (b *requestBody) replayable() bool {
+	_, reader := b.data.(*readerData)
+	return !reader
+}
+
+// streamed reports whether the body is encoded while it is being sent
+func (b *requestBody) streamed() bool {
+	return b.buffered == nil
+}
+
+// WriteTo writes the complete encoded request to w
+func (b *requestBody) WriteTo(w io.Writer) (int64, error) {
+	if b.buffered != nil {
+		n, err := w.Write(b.buffered)
+		return int64(n), err
+	}
+
+	counter := &countingWriter{w: w, limit: b.maxBytes}
+	bw := writerPool.Get().(*bufio.Writer)
+	bw.Reset(counter)
+	defer func() {
+		bw.Reset(nil)
+		writerPool.Put(bw)
+	}()
+
+	if _, err := bw.Write(b.envelope.prefix); err != nil {
+		return counter.n, err
+	}
+	if err := writeData(bw, b.data, b.escapeHTML); err != nil {
+		return counter.n, err
+	}
+	if _, err := bw.Write(b.envelope.suffix); err != nil {
+		return counter.n, err
+	}
+	err := bw.Flush()
+	return counter.n, err
+}
+
+// Open returns a fresh reader over the encoded request, gzipped if the body is
+// compressed; it has the signature of http.Request.GetBody so the transport
+// can replay the body
+func (b *requestBody) Open() (io.ReadCloser, error) {
+	reader, err := b.openPlain()
+	if err != nil || !b.compressed.Load() {
+		return reader, err
+	}
+	return gzipped(reader), nil
+}
+
+// openPlain returns a fresh reader over the encoded request, uncompressed
+func (b *requestBody) openPlain() (io.ReadCloser, error) {
+	b.mu.Lock()
+	if b.buffered != nil {
+		b.readers++
+		b.mu.Unlock()
+		return &bufferReader{r: bytes.NewReader(b.buffered), body: b}, nil
+	}
+	b.mu.Unlock()
+
+	// The writer goroutine exits as soon as the reader is closed, because
+	// every further write to the pipe then fails
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := b.WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// attach installs the body on an outgoing request
+func (b *requestBody) attach(req *http.Request) error {
+	reader, err := b.Open()
+	if err != nil {
+		return err
+	}
+	req.Body = reader
+	req.GetBody = b.Open
+	req.ContentLength = b.length
+	if b.compressed.Load() {
+		// The compressed size is only known once it has been sent
+		req.ContentLength = -1
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	return nil
+}
+
+// bufferReader reads a pooled body buffer and stops reading it once closed, so
+// a late read can never see a later request's bytes
+type bufferReader struct {
+	mu     sync.Mutex
+	r      *bytes.Reader
+	body   *requestBody
+	closed bool
+}
+
+func (r *bufferReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, errBodyClosed
+	}
+	return r.r.Read(p)
+}
+
+func (r *bufferReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	r.body.mu.Lock()
+	r.body.readers--
+	r.body.recycle()
+	r.body.mu.Unlock()
+	return nil
+}
+
+var errBodyClosed = errors.New("request body already closed")
+
+var errCapExceeded = errors.New("encoded size exceeds cap")
+
+// cappedWriter fails once more than limit bytes have been written
+type cappedWriter struct {
+	w     io.Writer
+	n     int
+	limit int
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	if c.n+len(p) > c.limit {
+		return 0, errCapExceeded
+	}
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// countingWriter counts bytes, forwarding them to w when set and failing with
+// a *RequestTooLargeError once more than limit bytes pass through
+type countingWriter struct {
+	w     io.Writer
+	n     int64
+	limit int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.limit > 0 && c.n+int64(len(p)) > c.limit {
+		return 0, &RequestTooLargeError{Size: c.n + int64(len(p)), Limit: c.limit}
+	}
+	if c.w == nil {
+		c.n += int64(len(p))
+		return len(p), nil
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
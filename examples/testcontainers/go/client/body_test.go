@@ -0,0 +1,487 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"policy-engine-testcontainer-example/policydata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// orderHistory builds a data document of roughly the requested encoded size
+func orderHistory(approxBytes int) map[string]interface{} {
+	var items []interface{}
+	for size := 0; size < approxBytes; size += 90 {
+		items = append(items, map[string]interface{}{
+			"sku":      fmt.Sprintf("SKU-%08d", len(items)),
+			"price":    19.99,
+			"quantity": 2,
+			"placed":   "2024-01-01T10:00:00Z",
+		})
+	}
+	return map[string]interface{}{
+		"Order": map[string]interface{}{
+			"total":   150,
+			"history": items,
+		},
+	}
+}
+
+type historyItem struct {
+	SKU      string  `json:"sku"`
+	Price    float64 `json:"price"`
+	Quantity int     `json:"quantity"`
+	Placed   string  `json:"placed"`
+}
+
+type orderRecord struct {
+	Order struct {
+		Total   int           `json:"total"`
+		History []historyItem `json:"history"`
+	}
+}
+
+// orderHistoryStruct is orderHistory as a typed struct
+func orderHistoryStruct(approxBytes int) orderRecord {
+	var record orderRecord
+	record.Order.Total = 150
+	for size := 0; size < approxBytes; size += 90 {
+		record.Order.History = append(record.Order.History, historyItem{
+			SKU:      fmt.Sprintf("SKU-%08d", len(record.Order.History)),
+			Price:    19.99,
+			Quantity: 2,
+			Placed:   "2024-01-01T10:00:00Z",
+		})
+	}
+	return record
+}
+
+// TestRequestBodyMatchesMarshal tests that streamed encoding is byte-identical
+// to encoding/json, with and without HTML escaping
+func TestRequestBodyMatchesMarshal(t *testing.T) {
+	payloads := []interface{}{
+		nil,
+		map[string]interface{}{},
+		map[string]interface{}{"b": 1, "a": []interface{}{"<tag>", nil, map[string]interface{}{"z": true, "y": 2.5}}},
+		map[string]interface{}{"floats": []interface{}{0.0, -1.5, 1e-7, 1e21, 123456789.0, 3.0e-10}, "ints": []interface{}{int64(-9), 0, 42}},
+		map[string]interface{}{"strings": []interface{}{"plain", "quote\"", "tab\t", "caf\u00e9", "bad\xff", "line\u2028sep", "<&>"}},
+		map[string]interface{}{"number": json.Number("12.50"), "raw": json.RawMessage(`{"a": 1}`)},
+		map[string]interface{}{"Person": struct {
+			Age int `json:"age"`
+		}{Age: 70}},
+		[]interface{}(nil),
+		orderHistory(10_000),
+		orderHistoryStruct(10_000),
+		[]historyItem{{SKU: "<A-1>", Price: 1}},
+	}
+
+	for i, data := range payloads {
+		req := PolicyRequest{Rule: `A **Person** gets "x" & <y>.`, Data: data, Trace: i%2 == 0}
+		escaped, err := json.Marshal(req)
+		require.NoError(t, err)
+		var unescaped bytes.Buffer
+		encoder := json.NewEncoder(&unescaped)
+		encoder.SetEscapeHTML(false)
+		require.NoError(t, encoder.Encode(req))
+
+		for _, escapeHTML := range []bool{false, true} {
+			want := strings.TrimSuffix(unescaped.String(), "\n")
+			if escapeHTML {
+				want = string(escaped)
+			}
+			for _, threshold := range []int{0, 1, defaultStreamingThreshold} {
+				body, err := newRequestBody(req, bodyOptions{streamingThreshold: threshold, escapeHTML: escapeHTML})
+				require.NoError(t, err)
+
+				var got bytes.Buffer
+				_, err = body.WriteTo(&got)
+				require.NoError(t, err)
+				assert.Equal(t, want, got.String(), "payload %d threshold %d escape %t", i, threshold, escapeHTML)
+			}
+		}
+	}
+}
+
+// TestRuleMatchingPlaceholder tests that a rule spelled like the data placeholder doesn't take the data's place
+func TestRuleMatchingPlaceholder(t *testing.T) {
+	req := PolicyRequest{Rule: "\x00policy-engine-data\x00", Data: map[string]interface{}{"age": 70}}
+	want, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	body, err := newRequestBody(req, bodyOptions{})
+	require.NoError(t, err)
+	var got bytes.Buffer
+	_, err = body.WriteTo(&got)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), got.String())
+}
+
+// marshalCounter counts how often it is encoded
+type marshalCounter struct {
+	calls *int
+}
+
+func (m marshalCounter) MarshalJSON() ([]byte, error) {
+	*m.calls++
+	return json.Marshal(orderHistoryStruct(256 * 1024))
+}
+
+// TestStructDataEncodedOnce tests that sizing and streaming a struct body marshals it only once
+func TestStructDataEncodedOnce(t *testing.T) {
+	engine := newFakeEngine(t)
+
+	c, err := New(engine.URL, WithStreamingThreshold(64*1024), WithContentLength(), WithMaxRequestBytes(1<<30))
+	require.NoError(t, err)
+
+	var calls int
+	_, err = c.EvaluatePolicy(context.Background(), "rule", marshalCounter{calls: &calls}, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	want, err := json.Marshal(PolicyRequest{Rule: "rule", Data: orderHistoryStruct(256 * 1024)})
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(engine.Bodies()[0]))
+}
+
+// TestLargeBodyIsStreamed tests that bodies over the threshold are sent chunked
+func TestLargeBodyIsStreamed(t *testing.T) {
+	engine := newFakeEngine(t)
+
+	c, err := New(engine.URL, WithStreamingThreshold(64*1024))
+	require.NoError(t, err)
+
+	data := orderHistory(512 * 1024)
+	_, err = c.EvaluatePolicy(context.Background(), "rule", data, false)
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{"age": 70}, false)
+	require.NoError(t, err)
+
+	lengths := engine.ContentLengths()
+	require.Len(t, lengths, 2)
+	assert.Equal(t, int64(-1), lengths[0], "large body should be chunked")
+	assert.Greater(t, lengths[1], int64(0), "small body should be buffered with a length")
+
+	want, err := json.Marshal(PolicyRequest{Rule: "rule", Data: data})
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(engine.Bodies()[0]))
+}
+
+// TestWithContentLength tests that streamed bodies can carry an exact length
+func TestWithContentLength(t *testing.T) {
+	engine := newFakeEngine(t)
+
+	c, err := New(engine.URL, WithStreamingThreshold(64*1024), WithContentLength())
+	require.NoError(t, err)
+
+	_, err = c.EvaluatePolicy(context.Background(), "rule", orderHistory(512*1024), false)
+	require.NoError(t, err)
+
+	bodies := engine.Bodies()
+	require.Len(t, bodies, 1)
+	assert.Equal(t, []int64{int64(len(bodies[0]))}, engine.ContentLengths())
+}
+
+// partialWriteTransport simulates a connection that dies after part of the
+// body was written, then replays the request through GetBody the way a
+// retrying transport would
+type partialWriteTransport struct {
+	next http.RoundTripper
+	// cut is how much of the first body was read before the connection died
+	cut int64
+	// replays counts the bodies obtained from GetBody
+	replays int
+}
+
+func (p *partialWriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p.cut, _ = io.CopyN(io.Discard, req.Body, 4096)
+	req.Body.Close()
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	p.replays++
+	retry := req.Clone(req.Context())
+	retry.Body = body
+	return p.next.RoundTrip(retry)
+}
+
+// TestRetryAfterPartialWrite tests that a body can be re-sent in full after an aborted write
+func TestRetryAfterPartialWrite(t *testing.T) {
+	engine := newFakeEngine(t)
+
+	for _, threshold := range []int{0, 64 * 1024} {
+		c, err := New(engine.URL, WithStreamingThreshold(threshold))
+		require.NoError(t, err)
+
+		transport := &partialWriteTransport{next: c.httpClient.Transport}
+		c.httpClient.Transport = transport
+
+		received := len(engine.Bodies())
+		data := orderHistory(256 * 1024)
+		_, err = c.EvaluatePolicy(context.Background(), "rule", data, false)
+		require.NoError(t, err)
+
+		want, err := json.Marshal(PolicyRequest{Rule: "rule", Data: data})
+		require.NoError(t, err)
+		assert.Equal(t, int64(4096), transport.cut, "the first body was cut short")
+		assert.Less(t, transport.cut, int64(len(want)))
+		assert.Equal(t, 1, transport.replays)
+
+		bodies := engine.Bodies()
+		require.Len(t, bodies, received+1, "only the replayed body reaches the engine")
+		assert.Equal(t, string(want), string(bodies[received]), "threshold %d", threshold)
+	}
+}
+
+// TestStreamedBodyWriterStopsOnClose tests that closing a streamed body mid-way ends the encoder
+func TestStreamedBodyWriterStopsOnClose(t *testing.T) {
+	body, err := newRequestBody(PolicyRequest{Rule: "rule", Data: orderHistory(2 << 20)}, bodyOptions{streamingThreshold: 1024})
+	require.NoError(t, err)
+	require.True(t, body.streamed())
+
+	reader, err := body.Open()
+	require.NoError(t, err)
+	_, err = io.CopyN(io.Discard, reader, 1024)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+
+	// The next open starts from the beginning again
+	reader, err = body.Open()
+	require.NoError(t, err)
+	defer reader.Close()
+
+	done := make(chan []byte)
+	go func() {
+		prefix := make([]byte, len(body.envelope.prefix))
+		_, _ = io.ReadFull(reader, prefix)
+		done <- prefix
+	}()
+	select {
+	case prefix := <-done:
+		assert.Equal(t, body.envelope.prefix, prefix)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out reading reopened body")
+	}
+}
+
+// TestWithMaxRequestBytes tests payloads just under, exactly at, and over the limit
+func TestWithMaxRequestBytes(t *testing.T) {
+	data := map[string]interface{}{"Order": map[string]interface{}{"note": strings.Repeat("x", 1000)}}
+	encoded, err := json.Marshal(PolicyRequest{Rule: "rule", Data: data})
+	require.NoError(t, err)
+	size := int64(len(encoded))
+
+	for _, threshold := range []int{0, 64} {
+		cases := []struct {
+			name  string
+			limit int64
+			fails bool
+		}{
+			{"just under", size + 1, false},
+			{"exactly at", size, false},
+			{"over", size - 1, true},
+		}
+
+		for _, tc := range cases {
+			t.Run(fmt.Sprintf("%s/threshold %d", tc.name, threshold), func(t *testing.T) {
+				engine := newFakeEngine(t)
+				c, err := New(engine.URL, WithMaxRequestBytes(tc.limit), WithStreamingThreshold(threshold))
+				require.NoError(t, err)
+
+				_, err = c.EvaluatePolicy(context.Background(), "rule", data, false)
+				if !tc.fails {
+					require.NoError(t, err)
+					assert.Len(t, engine.Requests(), 1)
+					return
+				}
+
+				var tooLarge *RequestTooLargeError
+				require.True(t, errors.As(err, &tooLarge), "expected RequestTooLargeError, got %v", err)
+				assert.Equal(t, size, tooLarge.Size)
+				assert.Equal(t, tc.limit, tooLarge.Limit)
+				assert.Empty(t, engine.Requests(), "nothing should reach the engine")
+			})
+		}
+	}
+}
+
+// BenchmarkRequestBody10MB compares buffering and streaming a 10MB payload,
+// held as generic maps and as a typed struct
+func BenchmarkRequestBody10MB(b *testing.B) {
+	payloads := []struct {
+		name string
+		data interface{}
+	}{
+		{"generic", orderHistory(10 << 20)},
+		{"struct", orderHistoryStruct(10 << 20)},
+	}
+
+	for _, payload := range payloads {
+		req := PolicyRequest{Rule: "rule", Data: payload.data}
+
+		b.Run(payload.name+"/marshal", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				encoded, err := json.Marshal(req)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, _ = io.Copy(io.Discard, bytes.NewReader(encoded))
+			}
+		})
+
+		b.Run(payload.name+"/streamed", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				body, err := newRequestBody(req, bodyOptions{streamingThreshold: 64 * 1024, countLength: true})
+				if err != nil {
+					b.Fatal(err)
+				}
+				reader, err := body.Open()
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, _ = io.Copy(io.Discard, reader)
+				reader.Close()
+			}
+		})
+	}
+}
+
+// TestPooledBuffersDoNotLeak tests that a small body encoded into a recycled buffer carries nothing from a larger previous one
+func TestPooledBuffersDoNotLeak(t *testing.T) {
+	large := PolicyRequest{Rule: "rule", Data: orderHistory(256 * 1024)}
+	small := PolicyRequest{Rule: "r", Data: map[string]interface{}{"age": 70}}
+	want, err := json.Marshal(small)
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		body, err := newRequestBody(large, bodyOptions{})
+		require.NoError(t, err)
+		reader, err := body.Open()
+		require.NoError(t, err)
+		_, err = io.Copy(io.Discard, reader)
+		require.NoError(t, err)
+		body.release()
+		// The buffer stays out of the pool until its last reader closes
+		require.NotNil(t, body.pooled)
+		require.NoError(t, reader.Close())
+		require.Nil(t, body.pooled)
+
+		_, err = reader.Read(make([]byte, 1))
+		assert.Error(t, err, "a closed reader must not see the recycled buffer")
+
+		body, err = newRequestBody(small, bodyOptions{})
+		require.NoError(t, err)
+		reader, err = body.Open()
+		require.NoError(t, err)
+		got, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, string(want), string(got))
+		assert.Equal(t, int64(len(want)), body.length)
+		reader.Close()
+		body.release()
+	}
+
+	// End to end, through the client, a large request followed by a small one
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+	_, err = c.Evaluate(context.Background(), large)
+	require.NoError(t, err)
+	_, err = c.Evaluate(context.Background(), small)
+	require.NoError(t, err)
+	bodies := engine.Bodies()
+	require.Len(t, bodies, 2)
+	assert.Equal(t, string(want), string(bodies[1]))
+}
+
+// TestHTMLEscaping tests that HTML-ish strings reach the engine as written unless escaping is asked for
+func TestHTMLEscaping(t *testing.T) {
+	for _, tc := range []struct {
+		opts []Option
+		want string
+	}{
+		{want: `"role":"<admin> & co"`},
+		{opts: []Option{WithHTMLEscaping()}, want: `"role":"\u003cadmin\u003e \u0026 co"`},
+	} {
+		engine := newFakeEngine(t)
+		c, err := New(engine.URL, tc.opts...)
+		require.NoError(t, err)
+
+		_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{"User": map[string]interface{}{"role": "<admin> & co"}}, false)
+		require.NoError(t, err)
+		_, err = c.EvaluatePolicy(context.Background(), "rule", struct {
+			User struct {
+				Role string `json:"role"`
+			}
+		}{User: struct {
+			Role string `json:"role"`
+		}{Role: "<admin> & co"}}, false)
+		require.NoError(t, err)
+
+		for _, body := range engine.Bodies() {
+			assert.Contains(t, string(body), tc.want)
+		}
+		assert.Equal(t, "<admin> & co", engine.Requests()[0].Data.(map[string]interface{})["User"].(map[string]interface{})["role"])
+	}
+}
+
+// TestCanonicalEncoding tests that equal data in any form goes over the wire as
+// the same bytes, the ones CanonicalHash digests
+func TestCanonicalEncoding(t *testing.T) {
+	type user struct {
+		Role  string  `json:"role"`
+		Admin bool    `json:"admin"`
+		Limit float64 `json:"limit"`
+	}
+	forms := []interface{}{
+		map[string]interface{}{"User": map[string]interface{}{"role": "<admin>", "admin": true, "limit": 10}},
+		map[string]interface{}{"User": user{Role: "<admin>", Admin: true, Limit: 10}},
+		struct{ User user }{User: user{Role: "<admin>", Admin: true, Limit: 10}},
+		json.RawMessage(`{ "User": { "limit": 10.0, "role": "\u003cadmin>", "admin": true } }`),
+		[]byte(`{"User":{"limit":1e1,"admin":true,"role":"<admin>"}}`),
+	}
+
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL, WithCanonicalEncoding(), WithHTMLEscaping(), WithStreamingThreshold(1))
+	require.NoError(t, err)
+	for run := 0; run < 5; run++ {
+		for _, data := range forms {
+			_, err := c.EvaluatePolicy(context.Background(), "rule", data, false)
+			require.NoError(t, err)
+		}
+	}
+
+	want, err := policydata.CanonicalJSON(forms[0])
+	require.NoError(t, err)
+	assert.Equal(t, `{"User":{"admin":true,"limit":10,"role":"<admin>"}}`, string(want))
+	hash, err := policydata.CanonicalHash(forms[0])
+	require.NoError(t, err)
+
+	bodies := engine.Bodies()
+	require.Len(t, bodies, 5*len(forms))
+	for i, body := range bodies {
+		assert.Equal(t, string(bodies[0]), string(body), "request %d", i)
+	}
+	var req struct {
+		Data json.RawMessage `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(bodies[0], &req))
+	assert.Equal(t, string(want), string(req.Data))
+	sum := sha256.Sum256(req.Data)
+	assert.Equal(t, hash, hex.EncodeToString(sum[:]))
+}
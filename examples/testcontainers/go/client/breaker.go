@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is the error of an evaluation the circuit breaker failed
+// without sending, see WithCircuitBreaker
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState is where WithCircuitBreaker's breaker stands
+type BreakerState int
+
+const (
+	// BreakerClosed sends every evaluation
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails evaluations with ErrCircuitOpen until the cooldown
+	// has passed
+	BreakerOpen
+	// BreakerHalfOpen sends one evaluation as a probe, failing the rest
+	// with ErrCircuitOpen until it is answered
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// WithCircuitBreaker stops sending evaluations once threshold in a row have
+// failed to reach the engine or been answered with a 5xx, so callers stop
+// piling onto an engine that has fallen over. For cooldown afterwards
+// evaluations fail at once with ErrCircuitOpen, which the failure policy
+// treats as an outage; then one is let through as a probe, and the breaker
+// closes if it succeeds and opens for another cooldown if it fails. A
+// retried evaluation counts each attempt. Clones and rule profiles share the
+// client's breaker.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *PolicyClient) {
+		c.breaker = &circuitBreaker{threshold: threshold, cooldown: cooldown}
+	}
+}
+
+// BreakerState returns where the circuit breaker stands; without
+// WithCircuitBreaker it is always BreakerClosed
+func (c *PolicyClient) BreakerState() BreakerState {
+	if c.breaker == nil {
+		return BreakerClosed
+	}
+	return c.breaker.current(c.now())
+}
+
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	// openUntil is when an open breaker lets a probe through
+	openUntil time.Time
+	// probing is set while the half-open probe is in flight
+	probing bool
+}
+
+// current is the breaker's state at now
+func (b *circuitBreaker) current(now time.Time) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && !now.Before(b.openUntil) {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// allow reports whether an evaluation may be sent at now, and whether it is
+// the half-open probe
+func (b *circuitBreaker) allow(now time.Time) (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		return false, nil
+	case BreakerOpen:
+		if now.Before(b.openUntil) {
+			return false, ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+	}
+	if b.probing {
+		return false, ErrCircuitOpen
+	}
+	b.probing = true
+	return true, nil
+}
+
+// record counts how an evaluation allow let through went; an abandoned one,
+// whose caller gave up, says nothing about the engine
+func (b *circuitBreaker) record(now time.Time, probe, failed, abandoned bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	switch {
+	case abandoned:
+	case failed && (probe || b.state == BreakerClosed):
+		b.failures++
+		if probe || b.failures >= b.threshold {
+			b.state = BreakerOpen
+			b.openUntil = now.Add(b.cooldown)
+		}
+	case !failed && (probe || b.state == BreakerClosed):
+		// Answers to evaluations sent before the breaker opened do not
+		// close it; only the probe's does
+		b.state = BreakerClosed
+		b.failures = 0
+	}
+}
+
+// guarded sends an evaluation through the circuit breaker, if there is one
+func (c *PolicyClient) guarded(ctx context.Context, send func() (*PolicyResponse, error)) (*PolicyResponse, error) {
+	if c.breaker == nil {
+		return send()
+	}
+	probe, err := c.breaker.allow(c.now())
+	if err != nil {
+		return nil, err
+	}
+	response, err := send()
+	c.breaker.record(c.now(), probe, transientFailure(response, err) != nil, ctx.Err() != nil)
+	return response, err
+}
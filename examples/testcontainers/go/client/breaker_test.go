@@ -0,0 +1,156 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// trippingEngine answers with a 503 while failing is set, and otherwise as the
+// engine would, counting the requests it is sent
+type trippingEngine struct {
+	*httptest.Server
+	failing  atomic.Bool
+	requests atomic.Int64
+	// hold, when set, is waited on before answering
+	hold chan struct{}
+}
+
+func newTrippingEngine(t *testing.T) *trippingEngine {
+	engine := &trippingEngine{}
+	engine.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		engine.requests.Add(1)
+		if engine.hold != nil {
+			<-engine.hold
+		}
+		if engine.failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"result": true}`))
+	}))
+	t.Cleanup(engine.Close)
+	return engine
+}
+
+func evaluateBreaker(c *PolicyClient) error {
+	_, err := c.Evaluate(context.Background(), PolicyRequest{Rule: "rule", Data: map[string]interface{}{}})
+	return err
+}
+
+// TestCircuitBreaker tests the breaker going closed, open, half-open and
+// closed again, and opening again when the probe fails
+func TestCircuitBreaker(t *testing.T) {
+	engine := newTrippingEngine(t)
+	clock := newTestClock()
+	c, err := New(engine.URL, WithClock(clock.Now), WithCircuitBreaker(3, 10*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, BreakerClosed, c.BreakerState())
+
+	engine.failing.Store(true)
+	for i := 0; i < 2; i++ {
+		assert.Error(t, evaluateBreaker(c))
+	}
+	assert.Equal(t, BreakerClosed, c.BreakerState(), "two failures are under the threshold")
+	assert.Error(t, evaluateBreaker(c))
+	assert.Equal(t, BreakerOpen, c.BreakerState())
+
+	err = evaluateBreaker(c)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int64(3), engine.requests.Load(), "an open breaker fails fast")
+
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, BreakerHalfOpen, c.BreakerState())
+	assert.Error(t, evaluateBreaker(c))
+	assert.Equal(t, BreakerOpen, c.BreakerState(), "a failed probe opens the breaker again")
+	assert.Equal(t, int64(4), engine.requests.Load())
+
+	clock.Advance(10 * time.Second)
+	engine.failing.Store(false)
+	require.NoError(t, evaluateBreaker(c))
+	assert.Equal(t, BreakerClosed, c.BreakerState())
+	require.NoError(t, evaluateBreaker(c))
+	assert.Equal(t, int64(6), engine.requests.Load())
+}
+
+// TestCircuitBreakerSuccessResets tests that a success resets the count of
+// failures in a row
+func TestCircuitBreakerSuccessResets(t *testing.T) {
+	engine := newTrippingEngine(t)
+	c, err := New(engine.URL, WithCircuitBreaker(2, time.Minute))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		engine.failing.Store(true)
+		assert.Error(t, evaluateBreaker(c))
+		engine.failing.Store(false)
+		require.NoError(t, evaluateBreaker(c))
+	}
+	assert.Equal(t, BreakerClosed, c.BreakerState())
+}
+
+// TestCircuitBreakerSingleProbe tests that concurrent evaluations of a
+// half-open breaker send a single probe
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	engine := newTrippingEngine(t)
+	clock := newTestClock()
+	c, err := New(engine.URL, WithClock(clock.Now), WithCircuitBreaker(1, time.Second))
+	require.NoError(t, err)
+	engine.failing.Store(true)
+	assert.Error(t, evaluateBreaker(c))
+	require.Equal(t, BreakerOpen, c.BreakerState())
+
+	engine.failing.Store(false)
+	engine.hold = make(chan struct{})
+	clock.Advance(time.Second)
+	probeDone := make(chan error, 1)
+	go func() { probeDone <- evaluateBreaker(c) }()
+	require.Eventually(t, func() bool { return engine.requests.Load() == 2 }, time.Second, time.Millisecond)
+
+	var wg sync.WaitGroup
+	var refused atomic.Int64
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errors.Is(evaluateBreaker(c), ErrCircuitOpen) {
+				refused.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(20), refused.Load())
+
+	close(engine.hold)
+	require.NoError(t, <-probeDone)
+	assert.Equal(t, BreakerClosed, c.BreakerState())
+	assert.Equal(t, int64(2), engine.requests.Load())
+}
+
+// TestCircuitBreakerFailurePolicy tests that an open breaker is an outage the
+// failure policy answers for
+func TestCircuitBreakerFailurePolicy(t *testing.T) {
+	engine := newTrippingEngine(t)
+	engine.failing.Store(true)
+	c, err := New(engine.URL, WithCircuitBreaker(1, time.Minute), WithFailurePolicy(FailOpen))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		response, err := c.Evaluate(context.Background(), PolicyRequest{Rule: "rule", Data: map[string]interface{}{}})
+		require.NoError(t, err)
+		assert.True(t, response.Degraded)
+	}
+	assert.Equal(t, int64(1), engine.requests.Load())
+	response, err := c.Evaluate(context.Background(), PolicyRequest{Rule: "rule", Data: map[string]interface{}{}})
+	require.NoError(t, err)
+	assert.ErrorIs(t, response.Fallback.Err, ErrCircuitOpen)
+}
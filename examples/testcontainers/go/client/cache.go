@@ -0,0 +1,349 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultMaxRefreshes = 4
+
+// Cache stores decisions for WithCache. Set's ttl is how long the store must
+// keep the entry, which under StaleWhileRevalidate runs past the entry's
+// Expires; a store backed by a shared server can hand it straight to the
+// server's own expiry. Implementations must be safe for concurrent use.
+type Cache interface {
+	Get(ctx context.Context, key string) (CacheEntry, bool)
+	Set(ctx context.Context, key string, entry CacheEntry, ttl time.Duration)
+}
+
+// CacheEntry is a cached decision
+type CacheEntry struct {
+	Response *PolicyResponse `json:"response"`
+	// Expires is when the entry stops being fresh
+	Expires time.Time `json:"expires"`
+}
+
+// MemoryCache is an in-process Cache, optionally bounded to its most recently
+// used entries
+type MemoryCache struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List
+	maxEntries int
+	now        func() time.Time
+}
+
+type memoryEntry struct {
+	key     string
+	entry   CacheEntry
+	removal time.Time
+}
+
+// NewMemoryCache returns an empty, unbounded MemoryCache
+func NewMemoryCache() *MemoryCache {
+	return NewLRUCache(0)
+}
+
+// NewLRUCache returns an empty MemoryCache holding at most maxEntries
+// entries, evicting the least recently used beyond that; zero or less is
+// unbounded
+func NewLRUCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{entries: map[string]*list.Element{}, order: list.New(), maxEntries: maxEntries, now: time.Now}
+}
+
+// Get returns the entry for key, unless it has outlived its ttl
+func (m *MemoryCache) Get(_ context.Context, key string) (CacheEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	elem, ok := m.entries[key]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	stored := elem.Value.(*memoryEntry)
+	if !m.now().Before(stored.removal) {
+		m.remove(elem)
+		return CacheEntry{}, false
+	}
+	m.order.MoveToFront(elem)
+	return stored.entry, true
+}
+
+// Set stores entry for ttl
+func (m *MemoryCache) Set(_ context.Context, key string, entry CacheEntry, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := &memoryEntry{key: key, entry: entry, removal: m.now().Add(ttl)}
+	if elem, ok := m.entries[key]; ok {
+		elem.Value = stored
+		m.order.MoveToFront(elem)
+		return
+	}
+	m.entries[key] = m.order.PushFront(stored)
+	for m.maxEntries > 0 && m.order.Len() > m.maxEntries {
+		m.remove(m.order.Back())
+	}
+}
+
+// Len returns how many entries the cache holds, expired ones included until
+// they are looked up or evicted
+func (m *MemoryCache) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
+
+// Clear removes every entry
+func (m *MemoryCache) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = map[string]*list.Element{}
+	m.order.Init()
+}
+
+func (m *MemoryCache) remove(elem *list.Element) {
+	m.order.Remove(elem)
+	delete(m.entries, elem.Value.(*memoryEntry).key)
+}
+
+// CacheMode sets what the cache does with an entry past its TTL
+type CacheMode struct {
+	staleTTL     time.Duration
+	maxRefreshes int
+}
+
+// StaleWhileRevalidate serves an expired entry for up to staleTTL more while
+// one background evaluation per key refreshes it, so expiring keys do not
+// all wait on the engine at once. A failed refresh leaves the entry to be
+// served stale, and retried, until staleTTL runs out. At most 4 refreshes run
+// at once; see MaxRefreshes.
+func StaleWhileRevalidate(staleTTL time.Duration) CacheMode {
+	return CacheMode{staleTTL: staleTTL, maxRefreshes: defaultMaxRefreshes}
+}
+
+// MaxRefreshes bounds the background refreshes running at once; an expired
+// entry found while all are busy is served stale without one
+func (m CacheMode) MaxRefreshes(n int) CacheMode {
+	m.maxRefreshes = n
+	return m
+}
+
+// CacheStats counts what the cache has served
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+	// Stale counts expired entries served under StaleWhileRevalidate
+	Stale int64
+	// NegativeHits counts hits on false results, which are also Hits
+	NegativeHits    int64
+	Refreshes       int64
+	RefreshFailures int64
+}
+
+type decisionCache struct {
+	store       Cache
+	ttl         time.Duration
+	negativeTTL time.Duration
+	mode        CacheMode
+
+	refreshSlots chan struct{}
+	mu           sync.Mutex
+	refreshing   map[string]bool
+	// generation is part of every key, so InvalidateCache orphans all the
+	// entries stored before it
+	generation atomic.Int64
+
+	hits, misses, stale, negativeHits atomic.Int64
+	refreshes, refreshFailures        atomic.Int64
+}
+
+// WithCache answers repeated evaluations from cache, identifying them by
+// rule and policydata.CanonicalHash of the data after the data pipeline has
+// run. Results are cached for ttl; false results only under
+// WithNegativeCaching and engine errors never. Traced evaluations and reader
+// data bypass the cache, and context data that changes per call, such as a
+// timestamp, makes every call a miss. Each call gets its own copy of the
+// response, decision ID included. Expiry follows WithClock. Clones and rule
+// profiles share the client's cache unless given a WithCache of their own.
+// See InvalidateCache to drop what is cached and SkipCache to bypass it for
+// a call.
+func WithCache(cache Cache, ttl time.Duration) Option {
+	return func(c *PolicyClient) {
+		c.cache = &decisionCache{store: cache, ttl: ttl, refreshing: map[string]bool{}}
+	}
+}
+
+// WithCacheMode sets how WithCache treats expired entries; by default they
+// are not served
+func WithCacheMode(mode CacheMode) Option {
+	return func(c *PolicyClient) {
+		c.cacheMode = mode
+	}
+}
+
+// WithNegativeCaching also caches false results, for ttl, which is typically
+// shorter than WithCache's so a newly granted permission shows up quickly
+func WithNegativeCaching(ttl time.Duration) Option {
+	return func(c *PolicyClient) {
+		c.negativeTTL = ttl
+	}
+}
+
+// CacheStats returns what WithCache has served so far
+func (c *PolicyClient) CacheStats() CacheStats {
+	if c.cache == nil {
+		return CacheStats{}
+	}
+	return CacheStats{
+		Hits:            c.cache.hits.Load(),
+		Misses:          c.cache.misses.Load(),
+		Stale:           c.cache.stale.Load(),
+		NegativeHits:    c.cache.negativeHits.Load(),
+		Refreshes:       c.cache.refreshes.Load(),
+		RefreshFailures: c.cache.refreshFailures.Load(),
+	}
+}
+
+// InvalidateCache drops every decision WithCache has stored, for the client
+// and the clones and rule profiles sharing its cache, e.g. after the data the
+// rules read has changed. Entries stay in the Cache until they expire, unless
+// it has a Clear method, as MemoryCache does, which is called.
+func (c *PolicyClient) InvalidateCache() {
+	if c.cache == nil {
+		return
+	}
+	c.cache.generation.Add(1)
+	if clearer, ok := c.cache.store.(interface{ Clear() }); ok {
+		clearer.Clear()
+	}
+}
+
+type skipCacheKey struct{}
+
+// SkipCache returns a copy of ctx under which evaluations are sent to the
+// engine without consulting WithCache, and their answers are not stored
+func SkipCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipCacheKey{}, true)
+}
+
+// skipsCache reports whether ctx came from SkipCache
+func skipsCache(ctx context.Context) bool {
+	skip, _ := ctx.Value(skipCacheKey{}).(bool)
+	return skip
+}
+
+// configureCache applies WithCacheMode and WithNegativeCaching, whichever
+// order they were given in
+func (c *PolicyClient) configureCache() {
+	if c.cache == nil {
+		return
+	}
+	c.cache.mode = c.cacheMode
+	c.cache.negativeTTL = c.negativeTTL
+	if c.cacheMode.staleTTL > 0 && c.cacheMode.maxRefreshes > 0 {
+		c.cache.refreshSlots = make(chan struct{}, c.cacheMode.maxRefreshes)
+	}
+}
+
+// cached answers req from the cache if it can, and otherwise with fetch,
+// caching the answer
+func (c *PolicyClient) cached(ctx context.Context, key string, fetch func(ctx context.Context) (*PolicyResponse, error)) (*PolicyResponse, error) {
+	dc := c.cache
+	key = strconv.FormatInt(dc.generation.Load(), 10) + ":" + key
+	now := c.now()
+	if entry, ok := dc.store.Get(ctx, key); ok && entry.Response != nil {
+		switch {
+		case now.Before(entry.Expires):
+			dc.hits.Add(1)
+			c.prometheus.cacheHit()
+			if !entry.Response.Result {
+				dc.negativeHits.Add(1)
+			}
+			return cloneResponse(entry.Response), nil
+		case dc.mode.staleTTL > 0 && now.Before(entry.Expires.Add(dc.mode.staleTTL)):
+			dc.stale.Add(1)
+			c.prometheus.cacheHit()
+			c.refresh(ctx, key, fetch)
+			return cloneResponse(entry.Response), nil
+		}
+	}
+
+	dc.misses.Add(1)
+	response, err := fetch(ctx)
+	if err == nil {
+		c.store(ctx, key, response)
+	}
+	return response, err
+}
+
+// refresh evaluates key again in the background, unless it is being
+// refreshed already or every refresh slot is taken
+func (c *PolicyClient) refresh(ctx context.Context, key string, fetch func(ctx context.Context) (*PolicyResponse, error)) {
+	dc := c.cache
+	dc.mu.Lock()
+	if dc.refreshing[key] {
+		dc.mu.Unlock()
+		return
+	}
+	select {
+	case dc.refreshSlots <- struct{}{}:
+	default:
+		dc.mu.Unlock()
+		return
+	}
+	dc.refreshing[key] = true
+	dc.mu.Unlock()
+
+	// The refresh outlives the call that found the entry stale, so it keeps
+	// the context's values and the call's time allowance but not its
+	// cancellation; closing the client cancels it instead
+	timeout := c.timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	release := func() {
+		dc.mu.Lock()
+		delete(dc.refreshing, key)
+		dc.mu.Unlock()
+		<-dc.refreshSlots
+	}
+	started := c.goBackground(ctx, func(ctx context.Context) {
+		defer release()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		dc.refreshes.Add(1)
+		response, err := fetch(ctx)
+		if err != nil || response.EngineError != nil {
+			dc.refreshFailures.Add(1)
+			return
+		}
+		c.store(ctx, key, response)
+	})
+	if !started {
+		release()
+	}
+}
+
+// store caches a response the engine answered without error
+func (c *PolicyClient) store(ctx context.Context, key string, response *PolicyResponse) {
+	dc := c.cache
+	if response == nil || response.EngineError != nil {
+		return
+	}
+	ttl := dc.ttl
+	if !response.Result {
+		ttl = dc.negativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	entry := CacheEntry{Response: cloneResponse(response), Expires: c.now().Add(ttl)}
+	dc.store.Set(ctx, key, entry, ttl+dc.mode.staleTTL)
+}
@@ -0,0 +1,256 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedEngine answers each request with the next step of its script: a
+// result, or a failure when the step is nil. Once the script runs out it
+// repeats the last step.
+type scriptedEngine struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	script   []*bool
+	requests atomic.Int64
+}
+
+func newScriptedEngine(t *testing.T, script ...*bool) *scriptedEngine {
+	t.Helper()
+
+	engine := &scriptedEngine{script: script}
+	engine.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		engine.mu.Lock()
+		step := engine.script[0]
+		if len(engine.script) > 1 {
+			engine.script = engine.script[1:]
+		}
+		engine.mu.Unlock()
+		engine.requests.Add(1)
+
+		if step == nil {
+			http.Error(w, "engine unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(PolicyResponse{Result: *step, Rule: []string{"rule"}})
+	}))
+	t.Cleanup(engine.Close)
+
+	return engine
+}
+
+func result(b bool) *bool {
+	return &b
+}
+
+// testClock is a clock tests move by hand
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func evaluateCached(t *testing.T, c *PolicyClient) (*PolicyResponse, error) {
+	t.Helper()
+	return c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{"Person": map[string]interface{}{"age": 70}}, false)
+}
+
+// TestCacheStaleWhileRevalidate tests that an expired entry is served at
+// once and replaced by a background refresh
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	engine := newScriptedEngine(t, result(true), result(true))
+	clock := newTestClock()
+	c, err := New(engine.URL, WithClock(clock.Now), WithCache(NewMemoryCache(), time.Minute),
+		WithCacheMode(StaleWhileRevalidate(time.Hour)))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		response, err := evaluateCached(t, c)
+		require.NoError(t, err)
+		assert.True(t, response.Result)
+	}
+	assert.Equal(t, int64(1), engine.requests.Load())
+
+	clock.Advance(2 * time.Minute)
+	for i := 0; i < 5; i++ {
+		response, err := evaluateCached(t, c)
+		require.NoError(t, err)
+		assert.True(t, response.Result)
+	}
+	// One refresh for the key, however many calls found it stale
+	require.Eventually(t, func() bool { return c.CacheStats().Refreshes == 1 && len(c.cache.refreshSlots) == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, int64(2), engine.requests.Load())
+
+	// The refreshed entry is fresh again
+	_, err = evaluateCached(t, c)
+	require.NoError(t, err)
+	stats := c.CacheStats()
+	assert.Equal(t, int64(3), stats.Hits)
+	assert.Equal(t, int64(1), stats.Misses)
+	assert.GreaterOrEqual(t, stats.Stale, int64(1))
+	assert.Equal(t, int64(2), engine.requests.Load())
+}
+
+// TestCacheRefreshFailure tests that a failing refresh keeps the stale entry
+// served until its hard expiry, and that the engine's failure then shows
+func TestCacheRefreshFailure(t *testing.T) {
+	engine := newScriptedEngine(t, result(true), nil)
+	clock := newTestClock()
+	c, err := New(engine.URL, WithClock(clock.Now), WithCache(NewMemoryCache(), time.Minute),
+		WithCacheMode(StaleWhileRevalidate(10*time.Minute)))
+	require.NoError(t, err)
+
+	_, err = evaluateCached(t, c)
+	require.NoError(t, err)
+
+	for i, step := range []time.Duration{2 * time.Minute, 4 * time.Minute, 4 * time.Minute} {
+		clock.Advance(step)
+		response, err := evaluateCached(t, c)
+		require.NoError(t, err)
+		assert.True(t, response.Result)
+		want := int64(i + 1)
+		require.Eventually(t, func() bool { return c.CacheStats().RefreshFailures == want }, time.Second, time.Millisecond)
+	}
+	assert.Equal(t, int64(3), c.CacheStats().RefreshFailures)
+
+	// Past ttl plus the stale window, the failure reaches the caller
+	clock.Advance(2 * time.Minute)
+	_, err = evaluateCached(t, c)
+	assert.Error(t, err)
+}
+
+// TestNegativeCaching tests that false results are cached only under
+// WithNegativeCaching, for its own TTL
+func TestNegativeCaching(t *testing.T) {
+	engine := newScriptedEngine(t, result(false))
+	clock := newTestClock()
+	c, err := New(engine.URL, WithClock(clock.Now), WithCache(NewMemoryCache(), time.Hour))
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = evaluateCached(t, c)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(2), engine.requests.Load())
+
+	engine = newScriptedEngine(t, result(false), result(true))
+	c, err = New(engine.URL, WithClock(clock.Now), WithNegativeCaching(10*time.Second), WithCache(NewMemoryCache(), time.Hour))
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		response, err := evaluateCached(t, c)
+		require.NoError(t, err)
+		assert.False(t, response.Result)
+	}
+	assert.Equal(t, int64(1), engine.requests.Load())
+	assert.Equal(t, int64(2), c.CacheStats().NegativeHits)
+
+	clock.Advance(11 * time.Second)
+	response, err := evaluateCached(t, c)
+	require.NoError(t, err)
+	assert.True(t, response.Result)
+	assert.Equal(t, int64(2), engine.requests.Load())
+}
+
+// TestCacheBypass tests that traced evaluations are never cached, that each
+// caller gets its own copy, and that clones key on their own prepared data
+func TestCacheBypass(t *testing.T) {
+	engine := newScriptedEngine(t, result(true))
+	c, err := New(engine.URL, WithCache(NewMemoryCache(), time.Hour))
+	require.NoError(t, err)
+	ctx := context.Background()
+	data := map[string]interface{}{"n": 1}
+
+	for i := 0; i < 2; i++ {
+		_, err = c.EvaluatePolicy(ctx, "rule", data, true)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(2), engine.requests.Load())
+
+	first, err := c.EvaluatePolicy(ctx, "rule", data, false)
+	require.NoError(t, err)
+	first.Labels = map[string]bool{"changed": true}
+	second, err := c.EvaluatePolicy(ctx, "rule", data, false)
+	require.NoError(t, err)
+	assert.Nil(t, second.Labels)
+	assert.Equal(t, int64(3), engine.requests.Load())
+
+	clone, err := c.Clone(WithBaseData(map[string]interface{}{"other": true}))
+	require.NoError(t, err)
+	_, err = clone.EvaluatePolicy(ctx, "rule", data, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), engine.requests.Load(), "different prepared data is a different key")
+	assert.Equal(t, int64(1), c.CacheStats().Hits)
+}
+
+// TestInvalidateCache tests that invalidating the cache and SkipCache send
+// an identical call to the engine again
+func TestInvalidateCache(t *testing.T) {
+	engine := newScriptedEngine(t, result(true))
+	store := NewMemoryCache()
+	c, err := New(engine.URL, WithCache(store, time.Hour))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = evaluateCached(t, c)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(1), engine.requests.Load(), "the second call is answered from cache")
+
+	_, err = c.EvaluatePolicy(SkipCache(context.Background()), "rule", map[string]interface{}{"Person": map[string]interface{}{"age": 70}}, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), engine.requests.Load())
+
+	c.InvalidateCache()
+	assert.Zero(t, store.Len())
+	for i := 0; i < 2; i++ {
+		_, err = evaluateCached(t, c)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(3), engine.requests.Load())
+	assert.Equal(t, int64(2), c.CacheStats().Hits)
+}
+
+// TestLRUCache tests that an LRU cache evicts its least recently used entry
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewLRUCache(2)
+	entry := CacheEntry{Response: &PolicyResponse{Result: true}}
+	cache.Set(ctx, "a", entry, time.Hour)
+	cache.Set(ctx, "b", entry, time.Hour)
+	_, ok := cache.Get(ctx, "a")
+	require.True(t, ok)
+	cache.Set(ctx, "c", entry, time.Hour)
+
+	assert.Equal(t, 2, cache.Len())
+	_, ok = cache.Get(ctx, "b")
+	assert.False(t, ok, "b was least recently used")
+	_, ok = cache.Get(ctx, "a")
+	assert.True(t, ok)
+	_, ok = cache.Get(ctx, "c")
+	assert.True(t, ok)
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// CallbackPanicError is a panic in a function the caller gave the client,
+// such as a data transform or a batch progress callback, recovered so it
+// fails the evaluation instead of the goroutine it ran on
+type CallbackPanicError struct {
+	// Callback names the kind of callback, e.g. "data transform"
+	Callback string
+	Value    interface{}
+	// Stack is the panicking goroutine's stack
+	Stack []byte
+}
+
+func (e *CallbackPanicError) Error() string {
+	return fmt.Sprintf("%s callback panicked: %v", e.Callback, e.Value)
+}
+
+// Unwrap returns the panic value if it is an error
+func (e *CallbackPanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// callback runs fn, a caller's callback or code that calls one, returning a
+// panic in it as a *CallbackPanicError
+func callback(name string, fn func() error) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = &CallbackPanicError{Callback: name, Value: value, Stack: debug.Stack()}
+		}
+	}()
+	return fn()
+}
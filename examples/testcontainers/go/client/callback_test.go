@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"policy-engine-testcontainer-example/batch"
+	"policy-engine-testcontainer-example/policydata"
+)
+
+// panicEvaluator panics on every evaluation
+type panicEvaluator struct{}
+
+func (panicEvaluator) Evaluate(context.Context, PolicyRequest) (*PolicyResponse, error) {
+	panic("evaluator exploded")
+}
+
+func (panicEvaluator) Health(context.Context) error {
+	return nil
+}
+
+var explodingTransform = policydata.TransformFunc(func(map[string]interface{}) error {
+	panic("transform exploded")
+})
+
+func assertPanic(t *testing.T, err error, callback string) {
+	t.Helper()
+	var panicErr *CallbackPanicError
+	require.ErrorAs(t, err, &panicErr)
+	assert.Equal(t, callback, panicErr.Callback)
+	assert.NotEmpty(t, panicErr.Stack)
+}
+
+// TestCallbackPanics tests that panics in caller callbacks fail the call with
+// a *CallbackPanicError instead of crashing
+func TestCallbackPanics(t *testing.T) {
+	engine := newScriptedEngine(t, result(true))
+	ctx := context.Background()
+	data := map[string]interface{}{"Person": map[string]interface{}{"age": 70}}
+
+	c, err := New(engine.URL, WithDataTransforms(explodingTransform))
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(ctx, "rule", data, false)
+	assertPanic(t, err, "data transform")
+
+	exploded := errors.New("context exploded")
+	c, err = New(engine.URL, WithContextData(func(context.Context) (map[string]interface{}, error) {
+		panic(exploded)
+	}))
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(ctx, "rule", data, false)
+	assertPanic(t, err, "context data")
+	assert.ErrorIs(t, err, exploded, "an error panic value unwraps")
+
+	c, err = New(engine.URL)
+	require.NoError(t, err)
+	_, err = c.EvaluateBatch(ctx, "rule", []interface{}{data}, TraceSink(func(int, json.RawMessage) error { panic("sink exploded") }))
+	assertPanic(t, err, "trace sink")
+
+	_, err = c.EvaluateBatch(ctx, "rule", []interface{}{data, data, data}, WithWorkers(1),
+		WithProgress(func(batch.Progress) { panic("progress exploded") }))
+	assertPanic(t, err, "batch progress")
+
+	results, err := EvaluateBatchWith(ctx, panicEvaluator{}, "rule", []interface{}{data})
+	if err == nil {
+		err = results[0].Err
+	}
+	assertPanic(t, err, "evaluator")
+
+	_, err = c.EvaluatePolicySet(ctx, []PolicyStep{{Name: "first", Rule: "rule", Data: func([]*PolicyResponse) (interface{}, error) {
+		panic("step exploded")
+	}}})
+	assertPanic(t, err, "policy set step data")
+}
@@ -0,0 +1,262 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EvaluationTimeHeader carries the evaluation time to an engine that
+// supports CapabilityAsOf, in RFC 3339
+const EvaluationTimeHeader = "X-Policy-Evaluation-Time"
+
+const defaultCapabilityTTL = 5 * time.Minute
+
+// Capability is an engine feature a client feature can use when the engine
+// advertises it, and stands in for on the client when it does not
+type Capability string
+
+const (
+	// CapabilityAsOf is the engine evaluating against the time in
+	// EvaluationTimeHeader instead of its own clock, which
+	// ContextWithEvaluationTime otherwise stands in for by rewriting rules
+	CapabilityAsOf Capability = "as_of"
+	// CapabilityAsync is the engine queueing evaluations as jobs, POST /jobs
+	// and GET /jobs/{id}, which EvaluateAsync otherwise stands in for by
+	// evaluating synchronously
+	CapabilityAsync Capability = "async"
+	// CapabilityBatch is the engine taking many evaluations in one POST
+	// /batch, which EvaluateBatch otherwise stands in for by sending one
+	// request per item
+	CapabilityBatch Capability = "batch"
+	// CapabilityWarnings is the engine reporting non-fatal notices, without
+	// which WithWarningsAsErrors has nothing to check and passes every
+	// evaluation
+	CapabilityWarnings Capability = "warnings"
+	// CapabilityMultiRule is the engine taking a policy's rules as a "rules"
+	// array, which EvaluateRules and PolicyRequest.Rules otherwise stand in
+	// for by joining them into one text with JoinRules
+	CapabilityMultiRule Capability = "multi_rule"
+)
+
+// knownCapabilities are the capabilities some client feature consults
+var knownCapabilities = []Capability{CapabilityAsOf, CapabilityAsync, CapabilityBatch, CapabilityWarnings, CapabilityMultiRule}
+
+// ErrCapabilityUnsupported is a call that needs a capability the engine
+// lacks and that the client cannot stand in for
+var ErrCapabilityUnsupported = errors.New("engine does not support the capability")
+
+// CapabilityError names the capability a call needed
+type CapabilityError struct {
+	Capability Capability
+	// Err is why the client could not stand in for it, if there is more to
+	// say
+	Err error
+}
+
+func (e *CapabilityError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("engine does not support %s: %v", e.Capability, e.Err)
+	}
+	return fmt.Sprintf("engine does not support %s", e.Capability)
+}
+
+func (e *CapabilityError) Is(target error) bool {
+	return target == ErrCapabilityUnsupported
+}
+
+func (e *CapabilityError) Unwrap() error {
+	return e.Err
+}
+
+// Capabilities is what an engine said it supports when it was probed
+type Capabilities struct {
+	// Version is the engine's version, if it gave one
+	Version  string
+	Features map[Capability]bool
+	// MaxRequestBytes is the largest request body the engine accepts, zero
+	// if it did not say
+	MaxRequestBytes int64
+	ProbedAt        time.Time
+}
+
+// Supports reports whether the engine advertised capability
+func (c Capabilities) Supports(capability Capability) bool {
+	return c.Features[capability]
+}
+
+// String is the capability matrix: the version, then each capability the
+// client knows of, or the engine advertised, with whether it is supported
+func (c Capabilities) String() string {
+	version := c.Version
+	if version == "" {
+		version = "unknown"
+	}
+	seen := map[Capability]bool{}
+	names := []string{}
+	for _, capability := range knownCapabilities {
+		seen[capability] = true
+		names = append(names, string(capability))
+	}
+	for capability := range c.Features {
+		if !seen[capability] {
+			names = append(names, string(capability))
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "engine version %s", version)
+	if c.MaxRequestBytes > 0 {
+		fmt.Fprintf(&b, ", requests up to %d bytes", c.MaxRequestBytes)
+	}
+	for _, name := range names {
+		state := "fallback"
+		if c.Features[Capability(name)] {
+			state = "supported"
+		}
+		fmt.Fprintf(&b, "\n%-12s %s", name, state)
+	}
+	return b.String()
+}
+
+// capabilityProbe caches an engine's capabilities for ttl, probing at most
+// once at a time
+type capabilityProbe struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	current *Capabilities
+
+	degradedMu sync.Mutex
+	degraded   map[Capability]*atomic.Int64
+}
+
+// WithCapabilityProbing lets client features use the engine's capabilities.
+// The engine is asked through GET /version, on the first evaluation that
+// could use one, and again once ttl has passed, five minutes if ttl is zero,
+// so an upgraded engine is picked up without a restart. An engine without
+// /version, like the current one, supports no capabilities. The request size
+// limit the engine advertises is also the one WithMaxRequestBytes sets when
+// it is not given.
+//
+// Without this option features never probe and always stand in for the
+// engine on the client, as with an engine that supports nothing.
+func WithCapabilityProbing(ttl time.Duration) Option {
+	return func(c *PolicyClient) {
+		if ttl <= 0 {
+			ttl = defaultCapabilityTTL
+		}
+		c.capabilities = &capabilityProbe{ttl: ttl, degraded: map[Capability]*atomic.Int64{}}
+	}
+}
+
+// versionResponse is the body of GET /version
+type versionResponse struct {
+	Version         string       `json:"version"`
+	Capabilities    []Capability `json:"capabilities"`
+	MaxRequestBytes int64        `json:"max_request_bytes,omitempty"`
+}
+
+// Capabilities returns the engine's capabilities, probing it if they are not
+// cached or have expired. A failed probe is not cached, so the next call
+// probes again. It probes whether or not WithCapabilityProbing is set.
+func (c *PolicyClient) Capabilities(ctx context.Context) (Capabilities, error) {
+	p := c.capabilities
+	if p == nil {
+		return c.probeCapabilities(ctx)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current != nil && c.now().Sub(p.current.ProbedAt) < p.ttl {
+		return *p.current, nil
+	}
+	probed, err := c.probeCapabilities(ctx)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	p.current = &probed
+	return probed, nil
+}
+
+// probeCapabilities asks the engine what it supports
+func (c *PolicyClient) probeCapabilities(ctx context.Context) (Capabilities, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/version", nil)
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("failed to build version request: %w", err)
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	resp, err := c.do(httpReq)
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("failed to probe capabilities: %w", &TransportError{Err: err})
+	}
+	defer releaseBody(ctx, resp.Body)
+
+	probed := Capabilities{Features: map[Capability]bool{}, ProbedAt: c.now()}
+	switch {
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusMethodNotAllowed:
+		// An engine from before /version
+		return probed, nil
+	case resp.StatusCode != http.StatusOK:
+		return Capabilities{}, fmt.Errorf("failed to probe capabilities: %w", &StatusError{StatusCode: resp.StatusCode})
+	}
+	var version versionResponse
+	if err := readResponse(ctx, resp, &version, nil); err != nil {
+		return Capabilities{}, fmt.Errorf("failed to probe capabilities: %w", err)
+	}
+	probed.Version = version.Version
+	probed.MaxRequestBytes = version.MaxRequestBytes
+	for _, capability := range version.Capabilities {
+		probed.Features[capability] = true
+	}
+	return probed, nil
+}
+
+// supports reports whether a feature may use capability. Without
+// WithCapabilityProbing, or when the probe fails, it may not; the failure is
+// left for the evaluation itself to meet.
+func (c *PolicyClient) supports(ctx context.Context, capability Capability) bool {
+	if c.capabilities == nil {
+		return false
+	}
+	capabilities, err := c.Capabilities(ctx)
+	return err == nil && capabilities.Supports(capability)
+}
+
+// degradeFeature counts a feature standing in for capability on the client
+func (c *PolicyClient) degradeFeature(capability Capability) {
+	p := c.capabilities
+	if p == nil {
+		return
+	}
+	p.degradedMu.Lock()
+	counter, ok := p.degraded[capability]
+	if !ok {
+		counter = &atomic.Int64{}
+		p.degraded[capability] = counter
+	}
+	p.degradedMu.Unlock()
+	counter.Add(1)
+}
+
+// DegradedFeatures returns how many times each capability the engine lacks
+// was stood in for on the client, under WithCapabilityProbing
+func (c *PolicyClient) DegradedFeatures() map[Capability]int64 {
+	counts := map[Capability]int64{}
+	p := c.capabilities
+	if p == nil {
+		return counts
+	}
+	p.degradedMu.Lock()
+	defer p.degradedMu.Unlock()
+	for capability, counter := range p.degraded {
+		counts[capability] = counter.Load()
+	}
+	return counts
+}
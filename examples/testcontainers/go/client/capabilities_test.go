@@ -0,0 +1,254 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// versionedEngine answers GET /version with status, capabilities and
+// maxBytes, and evaluations by echoing the rule, keeping the evaluation time
+// header each was sent with and the rules of those sent one by one
+type versionedEngine struct {
+	*httptest.Server
+	probes atomic.Int64
+
+	mu           sync.Mutex
+	status       int
+	capabilities []Capability
+	maxBytes     int64
+	times        []string
+	rules        []string
+	ruleLists    [][]string
+}
+
+func newVersionedEngine(t *testing.T, status int, capabilities ...Capability) *versionedEngine {
+	t.Helper()
+
+	engine := &versionedEngine{status: status, capabilities: capabilities}
+	engine.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		engine.mu.Lock()
+		defer engine.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/version" {
+			engine.probes.Add(1)
+			w.WriteHeader(engine.status)
+			_ = json.NewEncoder(w).Encode(versionResponse{Version: "1.5.0", Capabilities: engine.capabilities, MaxRequestBytes: engine.maxBytes})
+			return
+		}
+		var req struct {
+			PolicyRequest
+			Rules []string `json:"rules"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		engine.times = append(engine.times, r.Header.Get(EvaluationTimeHeader))
+		engine.rules = append(engine.rules, req.Rule)
+		engine.ruleLists = append(engine.ruleLists, req.Rules)
+		_ = json.NewEncoder(w).Encode(PolicyResponse{Result: true, Rule: []string{req.Rule}, Data: req.Data})
+	}))
+	t.Cleanup(engine.Close)
+	return engine
+}
+
+func (e *versionedEngine) upgrade(capabilities ...Capability) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.status = http.StatusOK
+	e.capabilities = capabilities
+}
+
+func (e *versionedEngine) lastRules() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.ruleLists[len(e.ruleLists)-1]
+}
+
+func (e *versionedEngine) last() (rule, at string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.rules[len(e.rules)-1], e.times[len(e.times)-1]
+}
+
+const adultRule = "A **Person** is an adult if the __birth_date__ of the **Person** is older than 18 years."
+
+// TestCapabilitiesCaching tests that the engine is probed once per TTL and
+// that a capability appearing after an upgrade is used once the cache expires
+func TestCapabilitiesCaching(t *testing.T) {
+	engine := newVersionedEngine(t, http.StatusOK)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	c, err := New(engine.URL, WithCapabilityProbing(time.Minute), WithClock(clock))
+	require.NoError(t, err)
+
+	at := time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)
+	ctx := ContextWithEvaluationTime(context.Background(), at)
+	for i := 0; i < 3; i++ {
+		response, err := c.EvaluatePolicy(ctx, adultRule, map[string]interface{}{}, false)
+		require.NoError(t, err)
+		assert.Equal(t, TimeTravelRewritten, response.TimeTravel)
+	}
+	assert.EqualValues(t, 1, engine.probes.Load())
+	assert.Equal(t, map[Capability]int64{CapabilityAsOf: 3}, c.DegradedFeatures())
+	rule, header := engine.last()
+	assert.Contains(t, rule, "is earlier than 2006-06-16")
+	assert.Empty(t, header)
+
+	engine.upgrade(CapabilityAsOf)
+	_, err = c.EvaluatePolicy(ctx, adultRule, map[string]interface{}{}, false)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, engine.probes.Load(), "the old answer is cached")
+
+	mu.Lock()
+	now = now.Add(time.Minute)
+	mu.Unlock()
+	response, err := c.EvaluatePolicy(ctx, adultRule, map[string]interface{}{}, false)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, engine.probes.Load())
+	assert.Equal(t, TimeTravelEngine, response.TimeTravel)
+	rule, header = engine.last()
+	assert.Equal(t, adultRule, rule, "the engine gets the rule as written")
+	assert.Equal(t, "2024-06-15T00:00:00Z", header)
+	assert.Equal(t, map[Capability]int64{CapabilityAsOf: 4}, c.DegradedFeatures())
+
+	capabilities, err := c.Capabilities(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "1.5.0", capabilities.Version)
+	assert.True(t, capabilities.Supports(CapabilityAsOf))
+	assert.Equal(t, "engine version 1.5.0\nas_of        supported\nasync        fallback\nbatch        fallback\nmulti_rule   fallback\nwarnings     fallback", capabilities.String())
+
+	// Clones share the cache
+	clone, err := c.Clone()
+	require.NoError(t, err)
+	_, err = clone.Capabilities(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, engine.probes.Load())
+}
+
+// TestCapabilitiesFallback tests each fallback an engine without a
+// capability can get: the client standing in, or a *CapabilityError
+func TestCapabilitiesFallback(t *testing.T) {
+	at := time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)
+	ctx := ContextWithEvaluationTime(context.Background(), at)
+	within := "A **Person** is recent if the __joined__ of the **Person** is within 3 days."
+
+	for name, tt := range map[string]struct {
+		status       int
+		capabilities []Capability
+		probe        bool
+		native       bool
+	}{
+		"no probing":     {status: http.StatusOK, capabilities: []Capability{CapabilityAsOf}},
+		"before version": {status: http.StatusNotFound, probe: true},
+		"probe fails":    {status: http.StatusInternalServerError, probe: true},
+		"unsupported":    {status: http.StatusOK, capabilities: []Capability{CapabilityBatch}, probe: true},
+		"supported":      {status: http.StatusOK, capabilities: []Capability{CapabilityAsOf}, probe: true, native: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			engine := newVersionedEngine(t, tt.status, tt.capabilities...)
+			var opts []Option
+			if tt.probe {
+				opts = append(opts, WithCapabilityProbing(time.Hour))
+			}
+			c, err := New(engine.URL, opts...)
+			require.NoError(t, err)
+
+			response, err := c.EvaluatePolicy(ctx, adultRule, map[string]interface{}{}, false)
+			require.NoError(t, err)
+			_, err = c.EvaluatePolicy(ctx, within, map[string]interface{}{}, false)
+			if tt.native {
+				assert.Equal(t, TimeTravelEngine, response.TimeTravel)
+				assert.NoError(t, err, "the engine can pin what the client cannot")
+				return
+			}
+			assert.Equal(t, TimeTravelRewritten, response.TimeTravel)
+			assert.ErrorIs(t, err, ErrCapabilityUnsupported)
+			assert.ErrorIs(t, err, ErrClockDependent)
+			var capErr *CapabilityError
+			require.ErrorAs(t, err, &capErr)
+			assert.Equal(t, CapabilityAsOf, capErr.Capability)
+			if !tt.probe {
+				assert.Zero(t, engine.probes.Load(), "features do not probe unless asked to")
+			}
+		})
+	}
+
+	t.Run("failed probe not cached", func(t *testing.T) {
+		engine := newVersionedEngine(t, http.StatusServiceUnavailable, CapabilityAsOf)
+		c, err := New(engine.URL, WithCapabilityProbing(time.Hour))
+		require.NoError(t, err)
+		_, err = c.Capabilities(context.Background())
+		assert.ErrorIs(t, err, ErrConnection)
+
+		engine.upgrade(CapabilityAsOf)
+		capabilities, err := c.Capabilities(context.Background())
+		require.NoError(t, err)
+		assert.True(t, capabilities.Supports(CapabilityAsOf))
+		assert.EqualValues(t, 2, engine.probes.Load())
+	})
+}
+
+// TestCapabilitiesFeatures tests the multi-rule, warnings and request size
+// features against an engine advertising them and one that does not
+func TestCapabilitiesFeatures(t *testing.T) {
+	rules := []string{`A **User** is staff if the __role__ of the **User** is equal to "staff".`, "A **User** gets access if the **User** is staff."}
+	large := map[string]interface{}{"User": map[string]interface{}{"role": strings.Repeat("x", 512)}}
+
+	t.Run("supported", func(t *testing.T) {
+		engine := newVersionedEngine(t, http.StatusOK, CapabilityMultiRule, CapabilityWarnings)
+		engine.maxBytes = 256
+		c, err := New(engine.URL, WithCapabilityProbing(time.Hour), WithWarningsAsErrors())
+		require.NoError(t, err)
+
+		_, err = c.EvaluateRules(context.Background(), rules, map[string]interface{}{}, false)
+		require.NoError(t, err)
+		rule, _ := engine.last()
+		assert.Empty(t, rule)
+		assert.Equal(t, rules, engine.lastRules(), "the rules go one by one")
+
+		_, err = c.EvaluateRules(context.Background(), rules, large, false)
+		var tooLarge *RequestTooLargeError
+		require.ErrorAs(t, err, &tooLarge)
+		assert.EqualValues(t, 256, tooLarge.Limit, "the advertised limit applies")
+		assert.Empty(t, c.DegradedFeatures())
+
+		capabilities, err := c.Capabilities(context.Background())
+		require.NoError(t, err)
+		assert.Contains(t, capabilities.String(), "engine version 1.5.0, requests up to 256 bytes\n")
+	})
+
+	t.Run("explicit limit", func(t *testing.T) {
+		engine := newVersionedEngine(t, http.StatusOK)
+		engine.maxBytes = 256
+		c, err := New(engine.URL, WithCapabilityProbing(time.Hour), WithMaxRequestBytes(1<<20))
+		require.NoError(t, err)
+		_, err = c.EvaluateRules(context.Background(), rules, large, false)
+		assert.NoError(t, err, "WithMaxRequestBytes overrides the advertised limit")
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		engine := newVersionedEngine(t, http.StatusNotFound)
+		c, err := New(engine.URL, WithCapabilityProbing(time.Hour), WithWarningsAsErrors())
+		require.NoError(t, err)
+
+		_, err = c.EvaluateRules(context.Background(), rules, large, false)
+		require.NoError(t, err)
+		rule, _ := engine.last()
+		assert.Equal(t, JoinRules(rules...), rule, "the client joins the rules")
+		assert.Nil(t, engine.lastRules())
+		assert.Equal(t, map[Capability]int64{CapabilityMultiRule: 1, CapabilityWarnings: 1}, c.DegradedFeatures())
+	})
+}
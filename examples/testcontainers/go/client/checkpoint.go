@@ -0,0 +1,302 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Checkpoint is how far a job has got: every input before Next is done, and
+// the counts are of those inputs
+type Checkpoint struct {
+	Next    int `json:"next"`
+	Granted int `json:"granted"`
+	Denied  int `json:"denied"`
+	Failed  int `json:"failed"`
+}
+
+// CheckpointStore keeps jobs' checkpoints between runs. Load reports false
+// for a job it has no checkpoint for. Implementations must be safe for
+// concurrent use.
+type CheckpointStore interface {
+	Load(ctx context.Context, job string) (Checkpoint, bool, error)
+	Save(ctx context.Context, job string, checkpoint Checkpoint) error
+}
+
+// FileCheckpointStore keeps each job's checkpoint as a JSON file in a
+// directory, replacing it atomically on every save
+type FileCheckpointStore struct {
+	dir string
+}
+
+// NewFileCheckpointStore returns a store keeping checkpoints in dir, which
+// is created if it does not exist
+func NewFileCheckpointStore(dir string) (*FileCheckpointStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+	return &FileCheckpointStore{dir: dir}, nil
+}
+
+func (s *FileCheckpointStore) path(job string) string {
+	return filepath.Join(s.dir, filepath.Base(job)+".checkpoint.json")
+}
+
+// Load reads job's checkpoint
+func (s *FileCheckpointStore) Load(_ context.Context, job string) (Checkpoint, bool, error) {
+	raw, err := os.ReadFile(s.path(job))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Checkpoint{}, false, nil
+	}
+	if err != nil {
+		return Checkpoint{}, false, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(raw, &checkpoint); err != nil {
+		return Checkpoint{}, false, fmt.Errorf("failed to decode checkpoint %s: %w", s.path(job), err)
+	}
+	return checkpoint, true, nil
+}
+
+// Save writes job's checkpoint to a temporary file and renames it into
+// place, so a crash mid-save leaves the previous checkpoint
+func (s *FileCheckpointStore) Save(_ context.Context, job string, checkpoint Checkpoint) error {
+	raw, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, filepath.Base(job)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(job)); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+type checkpointConfig struct {
+	store CheckpointStore
+	job   string
+	every int
+}
+
+// WithCheckpoint has EvaluateStream save job's progress to store after every
+// every inputs, and when the stream ends or its consumer stops. A stream
+// started again with the same job skips the inputs the checkpoint covers,
+// without evaluating them, and carries its counts on. Inputs after the last
+// checkpoint are evaluated again on resume, so a consumer of the stream sees
+// them at least once; WithResultSink delivers each exactly once.
+func WithCheckpoint(store CheckpointStore, job string, every int) BatchOption {
+	return func(c *batchConfig) {
+		if every <= 0 {
+			every = 1
+		}
+		c.checkpoint = &checkpointConfig{store: store, job: job, every: every}
+	}
+}
+
+// ResultSink receives a stream's results, in input order, as they are
+// evaluated. Under WithCheckpoint a resumed stream can write results again
+// that it wrote before it stopped; a sink must drop an index it already has.
+type ResultSink interface {
+	Write(ctx context.Context, result BatchResult) error
+}
+
+// WithResultSink has EvaluateStream write every result to sink before
+// yielding it. A sink that fails stops the stream with its error.
+func WithResultSink(sink ResultSink) BatchOption {
+	return func(c *batchConfig) {
+		c.sink = sink
+	}
+}
+
+// StoredResult is a result as FileResultSink keeps it
+type StoredResult struct {
+	Index    int             `json:"index"`
+	Response *PolicyResponse `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// FileResultSink is a ResultSink appending results to a file as JSON lines,
+// so a job's output need not be held in memory. Reopening the file carries
+// on after the results already in it, and Page reads them back.
+type FileResultSink struct {
+	mu sync.Mutex
+	f  *os.File
+	// offsets are where each result's line starts, and end where the next
+	// line will
+	offsets []int64
+	end     int64
+	next    int
+}
+
+// OpenFileResultSink opens or creates the results file at path. A line cut
+// short by a crash is discarded, so its result is written again on resume.
+func OpenFileResultSink(path string) (*FileResultSink, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open result sink: %w", err)
+	}
+	s := &FileResultSink{f: f}
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Anything after the last newline is a partial write
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to read result sink: %w", err)
+		}
+		var stored StoredResult
+		if err := json.Unmarshal(line, &stored); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to decode result %d of %s: %w", len(s.offsets), path, err)
+		}
+		s.offsets = append(s.offsets, s.end)
+		s.end += int64(len(line))
+		s.next = stored.Index + 1
+	}
+	if err := f.Truncate(s.end); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to open result sink: %w", err)
+	}
+	return s, nil
+}
+
+// Write appends result, unless its index is at or before one already
+// written
+func (s *FileResultSink) Write(_ context.Context, result BatchResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if result.Index < s.next {
+		return nil
+	}
+	stored := StoredResult{Index: result.Index, Response: result.Response}
+	if result.Err != nil {
+		stored.Error = result.Err.Error()
+	}
+	line, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to encode result %d: %w", result.Index, err)
+	}
+	line = append(line, '\n')
+	if _, err := s.f.WriteAt(line, s.end); err != nil {
+		return fmt.Errorf("failed to write result %d: %w", result.Index, err)
+	}
+	s.offsets = append(s.offsets, s.end)
+	s.end += int64(len(line))
+	s.next = result.Index + 1
+	return nil
+}
+
+// Len returns how many results the file holds
+func (s *FileResultSink) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.offsets)
+}
+
+// Page returns up to limit results starting with the offset-th, reading
+// only their lines
+func (s *FileResultSink) Page(offset, limit int) ([]StoredResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offset < 0 || limit <= 0 || offset >= len(s.offsets) {
+		return nil, nil
+	}
+	last := min(offset+limit, len(s.offsets))
+	end := s.end
+	if last < len(s.offsets) {
+		end = s.offsets[last]
+	}
+	raw := make([]byte, end-s.offsets[offset])
+	if _, err := s.f.ReadAt(raw, s.offsets[offset]); err != nil {
+		return nil, fmt.Errorf("failed to read results: %w", err)
+	}
+	page := make([]StoredResult, 0, last-offset)
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	for i := offset; i < last; i++ {
+		var stored StoredResult
+		if err := decoder.Decode(&stored); err != nil {
+			return nil, fmt.Errorf("failed to decode result %d: %w", i, err)
+		}
+		page = append(page, stored)
+	}
+	return page, nil
+}
+
+// Close closes the file
+func (s *FileResultSink) Close() error {
+	return s.f.Close()
+}
+
+// streamCheckpoint tracks a checkpointed stream's progress
+type streamCheckpoint struct {
+	cfg     *checkpointConfig
+	current Checkpoint
+	saved   int
+}
+
+// resumeCheckpoint loads the job's checkpoint, if cfg has one
+func resumeCheckpoint(ctx context.Context, cfg *checkpointConfig) (*streamCheckpoint, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	current, _, err := cfg.store.Load(ctx, cfg.job)
+	if err != nil {
+		return nil, err
+	}
+	return &streamCheckpoint{cfg: cfg, current: current, saved: current.Next}, nil
+}
+
+// done counts the result of input index, saving a checkpoint if one is due
+func (s *streamCheckpoint) done(ctx context.Context, index int, response *PolicyResponse, err error) error {
+	s.count(index, response, err)
+	if s.current.Next-s.saved >= s.cfg.every {
+		return s.save(ctx)
+	}
+	return nil
+}
+
+// count counts the result of input index
+func (s *streamCheckpoint) count(index int, response *PolicyResponse, err error) {
+	switch {
+	case err != nil:
+		s.current.Failed++
+	case response.Result:
+		s.current.Granted++
+	default:
+		s.current.Denied++
+	}
+	s.current.Next = index + 1
+}
+
+func (s *streamCheckpoint) save(ctx context.Context) error {
+	if s.current.Next == s.saved {
+		return nil
+	}
+	if err := s.cfg.store.Save(ctx, s.cfg.job, s.current); err != nil {
+		return err
+	}
+	s.saved = s.current.Next
+	return nil
+}
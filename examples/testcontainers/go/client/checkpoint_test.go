@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// errKilled stands in for the process dying mid-job
+var errKilled = errors.New("killed")
+
+func numbered(n int) func(yield func(interface{}, error) bool) {
+	return func(yield func(interface{}, error) bool) {
+		for i := 0; i < n; i++ {
+			if !yield(map[string]interface{}{"n": i}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// runJob streams 100 inputs into the job's sink, panicking with errKilled
+// after killAt results if killAt is set, to leave the checkpoint and sink as
+// a crash would
+func runJob(t *testing.T, c *PolicyClient, dir string, killAt int) (yielded int) {
+	t.Helper()
+	store, err := NewFileCheckpointStore(dir)
+	require.NoError(t, err)
+	sink, err := OpenFileResultSink(filepath.Join(dir, "results.ndjson"))
+	require.NoError(t, err)
+	defer sink.Close()
+
+	defer func() {
+		if value := recover(); value != nil && value != errKilled {
+			panic(value)
+		}
+	}()
+	for _, err := range c.EvaluateStream(context.Background(), "rule", numbered(100),
+		WithCheckpoint(store, "nightly", 10), WithResultSink(sink)) {
+		require.NoError(t, err)
+		yielded++
+		if yielded == killAt {
+			panic(errKilled)
+		}
+	}
+	return yielded
+}
+
+// TestStreamCheckpointResume tests that a job killed mid-way resumes from its
+// checkpoint and leaves every result in the sink exactly once
+func TestStreamCheckpointResume(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	runJob(t, c, dir, 37)
+	store, err := NewFileCheckpointStore(dir)
+	require.NoError(t, err)
+	checkpoint, ok, err := store.Load(context.Background(), "nightly")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, Checkpoint{Next: 30, Granted: 30}, checkpoint, "the kill came before the next checkpoint")
+
+	// Half a line, as if the kill landed mid-write
+	f, err := os.OpenFile(filepath.Join(dir, "results.ndjson"), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"index":37,"respo`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Inputs 30 to 36 are evaluated again; the sink already has them
+	assert.Equal(t, 70, runJob(t, c, dir, 0))
+	assert.Len(t, engine.Requests(), 37+70)
+
+	checkpoint, _, err = store.Load(context.Background(), "nightly")
+	require.NoError(t, err)
+	assert.Equal(t, Checkpoint{Next: 100, Granted: 100}, checkpoint)
+
+	sink, err := OpenFileResultSink(filepath.Join(dir, "results.ndjson"))
+	require.NoError(t, err)
+	defer sink.Close()
+	require.Equal(t, 100, sink.Len())
+	var indexes []int
+	for offset := 0; offset < sink.Len(); offset += 15 {
+		page, err := sink.Page(offset, 15)
+		require.NoError(t, err)
+		for _, stored := range page {
+			indexes = append(indexes, stored.Index)
+			assert.True(t, stored.Response.Result)
+		}
+	}
+	for i, index := range indexes {
+		require.Equal(t, i, index, "no gaps or duplicates")
+	}
+
+	// A finished job yields nothing more
+	assert.Zero(t, runJob(t, c, dir, 0))
+	assert.Len(t, engine.Requests(), 37+70)
+}
+
+// TestStreamCheckpointConsumerStops tests that a consumer breaking out of the
+// stream checkpoints the items it took
+func TestStreamCheckpointConsumerStops(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+	store, err := NewFileCheckpointStore(t.TempDir())
+	require.NoError(t, err)
+
+	taken := 0
+	for range c.EvaluateStream(context.Background(), "rule", numbered(100), WithCheckpoint(store, "job", 50)) {
+		if taken++; taken == 12 {
+			break
+		}
+	}
+	checkpoint, _, err := store.Load(context.Background(), "job")
+	require.NoError(t, err)
+	assert.Equal(t, Checkpoint{Next: 12, Granted: 12}, checkpoint)
+
+	sink, err := OpenFileResultSink(filepath.Join(t.TempDir(), "empty.ndjson"))
+	require.NoError(t, err)
+	defer sink.Close()
+	page, err := sink.Page(0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, page)
+}
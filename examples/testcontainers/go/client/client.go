@@ -0,0 +1,1080 @@
+// Package client provides a Go client for the Policy Engine HTTP API.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"policy-engine-testcontainer-example/policydata"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PolicyRequest represents the request payload for policy evaluation
+type PolicyRequest struct {
+	Rule string `json:"rule"`
+	// Rules are a policy's rules one by one, rules referring to each other's
+	// labels and outcomes included, sent after Rule as one text the way
+	// JoinRules joins them
+	Rules []string    `json:"-"`
+	Data  interface{} `json:"data"`
+	Trace bool        `json:"trace,omitempty"`
+}
+
+// RuleText returns the policy text the engine is sent: Rule alone, or Rule
+// and Rules joined
+func (r PolicyRequest) RuleText() string {
+	if len(r.Rules) == 0 {
+		return r.Rule
+	}
+	if r.Rule == "" {
+		return JoinRules(r.Rules...)
+	}
+	return JoinRules(append([]string{r.Rule}, r.Rules...)...)
+}
+
+// joined returns r with its Rules folded into Rule
+func (r PolicyRequest) joined() PolicyRequest {
+	r.Rule = r.RuleText()
+	r.Rules = nil
+	return r
+}
+
+// engineRulesKey carries a policy's rules, one by one, to its request body
+type engineRulesKey struct{}
+
+// engineRules are the rules of a policy whose joined text is text
+type engineRules struct {
+	text  string
+	rules []string
+}
+
+// withEngineRules has req's rules sent one by one to an engine supporting
+// CapabilityMultiRule, and counts them joined on the client for one that
+// does not
+func (c *PolicyClient) withEngineRules(ctx context.Context, req PolicyRequest) context.Context {
+	if len(req.Rules) == 0 {
+		return ctx
+	}
+	if !c.supports(ctx, CapabilityMultiRule) {
+		c.degradeFeature(CapabilityMultiRule)
+		return ctx
+	}
+	rules := req.Rules
+	if req.Rule != "" {
+		rules = append([]string{req.Rule}, rules...)
+	}
+	return context.WithValue(ctx, engineRulesKey{}, engineRules{text: req.RuleText(), rules: rules})
+}
+
+// JoinRules joins rules into one policy text, a blank line apart as the
+// engine separates them
+func JoinRules(rules ...string) string {
+	trimmed := make([]string, len(rules))
+	for i, rule := range rules {
+		trimmed[i] = strings.TrimRight(rule, "\r\n")
+	}
+	return strings.Join(trimmed, "\n\n")
+}
+
+// PolicyResponse represents the response from policy evaluation
+type PolicyResponse struct {
+	// Result is the engine's outcome when it is a boolean, and false when it
+	// is not; see DecodeResult and EvaluateInto for other outcomes
+	Result bool    `json:"result"`
+	Error  *string `json:"error,omitempty"`
+	// Trace and Data hold numbers as json.Number, spelled as the engine sent
+	// them, so large IDs and long decimals keep every digit
+	Trace  map[string]interface{} `json:"trace,omitempty"`
+	Labels map[string]bool        `json:"labels,omitempty"`
+	Rule   []string               `json:"rule"`
+	Data   interface{}            `json:"data"`
+
+	// ExecutionTrace is Trace decoded into its parts, for asserting on why
+	// a rule did or did not pass; nil without a trace, or for a trace whose
+	// shape this client does not know
+	ExecutionTrace *Trace `json:"-"`
+	// Summary is the decisive failed condition of a batch evaluated with
+	// SummarizeTraces; it is never sent by the engine itself
+	Summary *TraceSummary `json:"-"`
+	// EngineError is Error parsed, with the code and rule position when the
+	// engine sends them
+	EngineError *EngineError `json:"-"`
+	// SchemaSkew lists the fields the response has or lacks compared with
+	// what this client knows, under WithStrictDecoding; nil when they match
+	SchemaSkew *SchemaSkewWarning `json:"-"`
+	// DecisionID identifies the evaluation, and is sent to the engine as
+	// DecisionIDHeader; a coalesced response carries the ID of the request
+	// that answered it
+	DecisionID string `json:"-"`
+	// Warnings are the engine's non-fatal notices, from the body's
+	// "warnings" array and WarningHeader; empty, never nil, when it sent none
+	Warnings []Warning `json:"warnings,omitempty"`
+	// TimeTravel reports how an evaluation pinned with
+	// ContextWithEvaluationTime saw its evaluation time; empty otherwise
+	TimeTravel TimeTravel `json:"-"`
+	// Degraded is set when the failure policy answered rather than the
+	// engine, and Fallback says why
+	Degraded bool      `json:"-"`
+	Fallback *Fallback `json:"-"`
+
+	// rawTrace holds the undecoded trace while a batch shapes it
+	rawTrace json.RawMessage
+	// rawResult is the outcome as the engine sent it
+	rawResult json.RawMessage
+}
+
+// wireResponse decodes a response whose error may be a structured payload
+type wireResponse struct {
+	*PolicyResponse
+	Error json.RawMessage `json:"error,omitempty"`
+}
+
+// rawTraceResponse decodes a response without building the trace's maps
+type rawTraceResponse struct {
+	wireResponse
+	Trace  json.RawMessage `json:"trace,omitempty"`
+	Result json.RawMessage `json:"result"`
+}
+
+// PolicyClient talks to a running Policy Engine over HTTP. A client is safe
+// for concurrent use once New returns: its configuration is never changed
+// afterwards, and the state evaluations share is locked or atomic.
+type PolicyClient struct {
+	baseURL       string
+	httpClient    *http.Client
+	baseData      interface{}
+	baseCopy      map[string]interface{}
+	transforms    []policydata.Transform
+	aliases       *policydata.AliasRegistry
+	normalization *policydata.NormalizationConfig
+
+	allowDuplicateKeys bool
+	strictDecoding     strictMode
+	warningsAsErrors   bool
+	strictData         bool
+
+	injectContext   bool
+	contextData     ContextDataFunc
+	contextConflict ContextConflict
+	now             func() time.Time
+
+	body        bodyOptions
+	warmupConns int
+	hedging     hedgeConfig
+	retry       retryConfig
+	latencies   *latencyTracker
+	breaker     *circuitBreaker
+
+	metadataLimits  metadataLimits
+	metadataHeaders []string
+	capabilities    *capabilityProbe
+
+	replicas        []string
+	balancerPolicy  BalancerPolicy
+	probeInterval   time.Duration
+	balancer        *balancer
+	closeBackground context.CancelFunc
+	// background ends when the client is closed; tasks are the goroutines,
+	// such as shadow mirrors and cache refreshes, that outlive the
+	// evaluation starting them. Both are the connection owner's.
+	background context.Context
+	tasks      sync.WaitGroup
+
+	connStrategy     ConnectionStrategy
+	resolvedStrategy atomic.Int32
+	tlsConfig        *tls.Config
+	customHTTPClient *http.Client
+	recording        *recordSpec
+	// compressionRefused is set once the engine answers a compressed body
+	// with 415, so later bodies are sent uncompressed
+	compressionRefused atomic.Bool
+
+	inFlight  inFlight
+	coalescer *coalescer
+
+	cache       *decisionCache
+	cacheMode   CacheMode
+	negativeTTL time.Duration
+
+	failure   FailurePolicy
+	audit     AuditSink
+	telemetry telemetry
+
+	prometheusRegisterer prometheus.Registerer
+	prometheus           *promMetrics
+
+	headers     http.Header
+	middlewares []Middleware
+	doer        Doer
+	limiter     *RateLimiter
+	beforeHooks []BeforeHook
+	afterHooks  []AfterHook
+	// debugLogger logs each request, masking the lowercased redactFields
+	debugLogger  *slog.Logger
+	redactFields map[string]bool
+	// auditPayloads records rule text and data in audit records
+	auditPayloads bool
+	shadow        *shadowMirror
+	// stats are shared with the rule profiles' clients
+	stats *failureStats
+
+	// profileSpecs are the WithRuleProfile options and profiles the clients
+	// built from them; timeout and alwaysTrace are set on those clients
+	profileSpecs []profileSpec
+	profiles     *profiles
+	timeout      time.Duration
+	alwaysTrace  bool
+
+	// options are those New was given, so Clone can apply them again;
+	// parent is the client a clone shares its connections with
+	options []Option
+	parent  *PolicyClient
+	// closers are files FromConfig opened for the client
+	closers []io.Closer
+}
+
+// Option configures a PolicyClient
+type Option func(*PolicyClient)
+
+// WithBaseData sets a document that every request's data is deep-merged onto,
+// so callers only need to pass the fields that differ per evaluation
+func WithBaseData(base interface{}) Option {
+	return func(c *PolicyClient) {
+		c.baseData = base
+	}
+}
+
+// WithDataTransforms rewrites every outgoing data document before it is
+// encoded, e.g. to drop or hash fields that must never leave the process. The
+// caller's data is left untouched; the engine only ever sees, and echoes back
+// in the response data and trace, the transformed values.
+func WithDataTransforms(transforms ...policydata.Transform) Option {
+	return func(c *PolicyClient) {
+		c.transforms = append(c.transforms, transforms...)
+	}
+}
+
+// WithAliases renames Go-side field names in the base data and every
+// request's data to the rule property names registered in aliases. Aliases are
+// applied before key normalization, so they match the caller's own spelling.
+func WithAliases(aliases *policydata.AliasRegistry) Option {
+	return func(c *PolicyClient) {
+		c.aliases = aliases
+	}
+}
+
+// Aliases returns the registry WithAliases gave, or nil, e.g. for
+// trace.WithAliases to explain decisions in the caller's own names
+func (c *PolicyClient) Aliases() *policydata.AliasRegistry {
+	return c.aliases
+}
+
+// WithKeyNormalization rewrites the keys of the base data and every request's
+// data into one canonical style before merging, so third-party spellings like
+// MembershipLevel and membership-level reach the engine as membership_level
+func WithKeyNormalization(cfg policydata.NormalizationConfig) Option {
+	if cfg.Aliases != nil {
+		// The client reads the aliases from every request's goroutine
+		aliases := make(map[string]string, len(cfg.Aliases))
+		for from, to := range cfg.Aliases {
+			aliases[from] = to
+		}
+		cfg.Aliases = aliases
+	}
+	return func(c *PolicyClient) {
+		c.normalization = &cfg
+	}
+}
+
+// WithStreamingThreshold sets the encoded request size above which bodies are
+// encoded straight onto the wire rather than buffered first, bounding memory
+// use for very large data documents. A value of zero or less always buffers.
+func WithStreamingThreshold(bytes int) Option {
+	return func(c *PolicyClient) {
+		c.body.streamingThreshold = bytes
+	}
+}
+
+// WithContentLength makes streamed requests carry an exact Content-Length,
+// computed with an extra encoding pass, for servers that reject chunked bodies
+func WithContentLength() Option {
+	return func(c *PolicyClient) {
+		c.body.countLength = true
+	}
+}
+
+// WithMaxRequestBytes rejects requests whose encoded body exceeds n bytes with
+// a *RequestTooLargeError before anything is sent, instead of transmitting
+// megabytes only to receive the engine's 413. Without it, under
+// WithCapabilityProbing, the limit is the one the engine advertises.
+func WithMaxRequestBytes(n int64) Option {
+	return func(c *PolicyClient) {
+		c.body.maxBytes = n
+	}
+}
+
+// WithHTMLEscaping escapes <, > and & inside request strings as \u003c,
+// \u003e and \u0026, as json.Marshal does. By default the client writes them
+// as they are, so a value like "<admin>" goes over the wire in the same
+// bytes a rule's condition spells it with.
+func WithHTMLEscaping() Option {
+	return func(c *PolicyClient) {
+		c.body.escapeHTML = true
+	}
+}
+
+// WithCanonicalEncoding sends request data in policydata.CanonicalJSON form:
+// sorted keys, struct fields included, numbers in one spelling and no HTML
+// escaping. Equal data then always goes over the wire as the same bytes, the
+// bytes policydata.CanonicalHash digests, so recorded requests, hashes and
+// audit records of the same input agree. The data is encoded in memory up
+// front, bypassing streaming, except for io.Reader data, which is sent as
+// read. It takes precedence over WithHTMLEscaping for the data.
+func WithCanonicalEncoding() Option {
+	return func(c *PolicyClient) {
+		c.body.canonical = true
+	}
+}
+
+// AllowDuplicateKeys sends raw JSON data even when an object in it repeats a
+// key. By default such data is rejected with an *InvalidDataError wrapping a
+// *policydata.DuplicateKeyError, since which value the engine keeps depends on
+// its JSON parser and has changed between releases.
+func AllowDuplicateKeys() Option {
+	return func(c *PolicyClient) {
+		c.allowDuplicateKeys = true
+	}
+}
+
+// New creates a client for the engine listening at baseURL
+func New(baseURL string, opts ...Option) (*PolicyClient, error) {
+	if _, err := url.ParseRequestURI(baseURL); err != nil {
+		return nil, fmt.Errorf("invalid base URL %q: %w", baseURL, err)
+	}
+
+	c := &PolicyClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		now:        time.Now,
+
+		body:  bodyOptions{streamingThreshold: defaultStreamingThreshold},
+		stats: &failureStats{},
+	}
+	c.options = append([]Option(nil), opts...)
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.configureCache()
+	if err := c.snapshotBaseData(); err != nil {
+		return nil, err
+	}
+	if err := c.configureTelemetry(); err != nil {
+		return nil, err
+	}
+	if err := c.configurePrometheus(); err != nil {
+		return nil, err
+	}
+
+	if err := c.configureConnections(); err != nil {
+		return nil, err
+	}
+	if err := c.configureRecorder(); err != nil {
+		return nil, err
+	}
+	c.configureMiddleware()
+
+	background, stop := context.WithCancel(context.Background())
+	c.background, c.closeBackground = background, stop
+	if len(c.replicas) > 0 {
+		urls := []string{c.baseURL}
+		for _, replica := range c.replicas {
+			if _, err := url.ParseRequestURI(replica); err != nil {
+				stop()
+				return nil, fmt.Errorf("invalid endpoint URL %q: %w", replica, err)
+			}
+			urls = append(urls, strings.TrimRight(replica, "/"))
+		}
+		c.balancer = newBalancer(c.balancerPolicy, urls)
+		if c.probeInterval > 0 {
+			go c.probeEndpoints(background, c.probeInterval)
+		}
+	}
+
+	if err := c.buildProfiles(); err != nil {
+		stop()
+		return nil, err
+	}
+
+	if c.warmupConns > 0 {
+		c.startWarmup()
+	}
+
+	return c, nil
+}
+
+// snapshotBaseData copies the base data, aliased and normalized as requests
+// will be, so later changes by the caller can't leak into requests
+func (c *PolicyClient) snapshotBaseData() error {
+	if c.baseData == nil {
+		return nil
+	}
+	copied, err := policydata.Merge(c.baseData, nil)
+	if err != nil {
+		return fmt.Errorf("invalid base data: %w", err)
+	}
+	// Clones start from the copy, since their aliases and normalization may
+	// differ
+	c.baseCopy = copied
+	snapshot, err := policydata.Merge(copied, nil)
+	if err != nil {
+		return fmt.Errorf("invalid base data: %w", err)
+	}
+	if c.aliases != nil {
+		if err := c.aliases.Apply(snapshot); err != nil {
+			return fmt.Errorf("invalid base data: %w", err)
+		}
+	}
+	if c.normalization != nil {
+		if snapshot, err = policydata.Normalize(snapshot, *c.normalization); err != nil {
+			return fmt.Errorf("invalid base data: %w", err)
+		}
+	}
+	c.baseData = snapshot
+	return nil
+}
+
+// Close stops the client's background work, such as health probes, and
+// closes its idle connections. Evaluations may still be made afterwards.
+// Closing a clone leaves the connections and probes it shares alone.
+func (c *PolicyClient) Close() error {
+	if c.parent != nil {
+		return nil
+	}
+	c.closeBackground()
+	c.httpClient.CloseIdleConnections()
+	var errs []error
+	for _, closer := range c.closers {
+		errs = append(errs, closer.Close())
+	}
+	c.closers = nil
+	return errors.Join(errs...)
+}
+
+// BaseURL returns the engine address the client sends requests to; with
+// WithEndpoints it is the first of the balanced endpoints
+func (c *PolicyClient) BaseURL() string {
+	return c.baseURL
+}
+
+// Evaluate sends a policy evaluation request to the engine. When the engine
+// rejects the rule or data, the response is returned along with its
+// *EngineError.
+func (c *PolicyClient) Evaluate(ctx context.Context, req PolicyRequest) (*PolicyResponse, error) {
+	return c.evaluateRequest(ctx, req, false, "")
+}
+
+// evaluateRequest is Evaluate, leaving the trace undecoded in the response's
+// rawTrace if rawTrace is set, for the policy registered as policy if any
+func (c *PolicyClient) evaluateRequest(ctx context.Context, req PolicyRequest, rawTrace bool, policy string) (*PolicyResponse, error) {
+	end, err := c.inFlight.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+
+	ctx = c.withEngineRules(ctx, req)
+	req = req.joined()
+	ctx, d := startDecision(ctx)
+	ctx, span := c.telemetry.startSpan(ctx, req)
+	target := c.profiled(req.Rule, policy)
+	began := target.now()
+	measured := time.Now()
+	var response *PolicyResponse
+	// A hook's error is the caller's decision, not a failure to stand in for
+	if err = c.before(ctx, req); err == nil {
+		response, err = target.run(ctx, req, rawTrace)
+		response, err = target.fallBack(ctx, response, err, d.id)
+	}
+	elapsed := time.Since(measured)
+	c.telemetry.finish(ctx, span, d.id, elapsed, response, err)
+	c.prometheus.observe(elapsed, response, err)
+	target.record(ctx, req.Rule, d.id, began, response, err)
+	target.mirror(ctx, req, d.id, response, err)
+	err = withDecisionID(err, d.id)
+	c.after(ctx, req, response, err)
+	return response, err
+}
+
+// run evaluates req with this client's deadline and trace settings
+func (c *PolicyClient) run(ctx context.Context, req PolicyRequest, rawTrace bool) (*PolicyResponse, error) {
+	if _, err := c.checkMetadata(ctx); err != nil {
+		return nil, err
+	}
+	if c.alwaysTrace {
+		req.Trace = true
+	}
+	if c.warningsAsErrors && !c.supports(ctx, CapabilityWarnings) {
+		// The engine never warns, so there is nothing to fail on
+		c.degradeFeature(CapabilityWarnings)
+	}
+	ctx, record := c.withAdaptiveDeadline(ctx, req.Rule)
+	response, err := c.evaluate(ctx, req, rawTrace)
+	record(err)
+	return c.responseFailure(response, err)
+}
+
+// responseFailure returns the engine's error, or schema skew under
+// WithStrictDecodingFatal, as the call's error. They are left in the response
+// until here so that balancing, hedging and coalescing treat the response as
+// the answer it is rather than as a failed request.
+func (c *PolicyClient) responseFailure(response *PolicyResponse, err error) (*PolicyResponse, error) {
+	if err != nil {
+		return response, err
+	}
+	if response.SchemaSkew != nil && c.strictDecoding == strictFatal {
+		return response, response.SchemaSkew
+	}
+	if response.EngineError != nil {
+		return response, response.EngineError
+	}
+	if c.warningsAsErrors && len(response.Warnings) > 0 {
+		return response, &WarningsError{Warnings: response.Warnings}
+	}
+	return response, nil
+}
+
+func (c *PolicyClient) evaluate(ctx context.Context, req PolicyRequest, rawTrace bool) (*PolicyResponse, error) {
+	travel := TimeTravel("")
+	if at, ok := EvaluationTimeFromContext(ctx); ok {
+		if c.supports(ctx, CapabilityAsOf) {
+			ctx = context.WithValue(ctx, engineTimeKey{}, at)
+			travel = TimeTravelEngine
+		} else {
+			rule, rewritten, err := pinRule(req.Rule, at)
+			if err != nil {
+				return nil, &CapabilityError{Capability: CapabilityAsOf, Err: err}
+			}
+			req.Rule = rule
+			travel = TimeTravelContext
+			if rewritten {
+				travel = TimeTravelRewritten
+				c.degradeFeature(CapabilityAsOf)
+			}
+		}
+	}
+
+	data, err := c.prepareData(ctx, req.Data)
+	if err != nil {
+		return nil, err
+	}
+	req.Data = data
+	if c.strictData {
+		if err := checkDataShape(req.Rule, data); err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := c.dispatch(ctx, req, rawTrace)
+	if response != nil {
+		response.TimeTravel = travel
+	}
+	return response, err
+}
+
+// dispatch sends a request whose data has been prepared, answered from
+// cache or coalesced with identical requests if configured
+func (c *PolicyClient) dispatch(ctx context.Context, req PolicyRequest, rawTrace bool) (*PolicyResponse, error) {
+	if _, ok := ctx.Value(engineTimeKey{}).(time.Time); ok {
+		// The time is in a header, not the request the keys are made of
+		return c.encodeAndSend(ctx, req, rawTrace)
+	}
+	if c.cache != nil && !req.Trace && !skipsCache(ctx) {
+		if key, ok := coalesceKey(req, rawTrace); ok {
+			return c.cached(ctx, key, func(ctx context.Context) (*PolicyResponse, error) {
+				return c.coalesced(ctx, req, rawTrace)
+			})
+		}
+	}
+	return c.coalesced(ctx, req, rawTrace)
+}
+
+// coalesced sends a request, coalesced with identical requests if configured
+func (c *PolicyClient) coalesced(ctx context.Context, req PolicyRequest, rawTrace bool) (*PolicyResponse, error) {
+	if c.coalescer != nil {
+		if key, ok := coalesceKey(req, rawTrace); ok {
+			return c.coalescer.do(ctx, key, func(ctx context.Context) (*PolicyResponse, error) {
+				return c.encodeAndSend(ctx, req, rawTrace)
+			}, c.countCoalesced(ctx))
+		}
+	}
+	return c.encodeAndSend(ctx, req, rawTrace)
+}
+
+// encodeAndSend encodes a request whose data has been prepared and sends it
+func (c *PolicyClient) encodeAndSend(ctx context.Context, req PolicyRequest, rawTrace bool) (*PolicyResponse, error) {
+	// The rules go one by one unless the rule was rewritten on the way, as
+	// pinning an evaluation time may
+	if rules, ok := ctx.Value(engineRulesKey{}).(engineRules); ok && rules.text == req.Rule {
+		req.Rules = rules.rules
+	}
+	body, err := newRequestBody(req, c.bodyOptions(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return c.sendBody(ctx, body, rawTrace)
+}
+
+// sendBody sends an encoded request, retried and hedged if configured, and
+// releases it
+func (c *PolicyClient) sendBody(ctx context.Context, body *requestBody, rawTrace bool) (*PolicyResponse, error) {
+	defer body.release()
+	body.rawTrace = rawTrace
+
+	send := func() (*PolicyResponse, error) {
+		return c.guarded(ctx, func() (*PolicyResponse, error) {
+			if c.hedging.enabled() && body.replayable() {
+				return c.hedgedRoundTrip(ctx, body)
+			}
+			return c.roundTrip(ctx, body)
+		})
+	}
+	if c.retry.enabled() && body.replayable() {
+		return c.retried(ctx, send)
+	}
+	return send()
+}
+
+// roundTrip sends one encoded request, to an endpoint picked by the balancer
+// when there are replicas, and decodes the engine's response
+func (c *PolicyClient) roundTrip(ctx context.Context, body *requestBody) (*PolicyResponse, error) {
+	if c.balancer == nil {
+		response, _, err := c.send(ctx, c.baseURL, body)
+		return response, err
+	}
+
+	endpoint := c.balancer.pick()
+	done := endpoint.begin()
+	response, status, err := c.send(ctx, endpoint.url, body)
+	// A call the caller gave up on says nothing about the endpoint
+	if ctx.Err() == nil {
+		done(err != nil || status >= http.StatusInternalServerError)
+	} else {
+		done(false)
+	}
+	return response, err
+}
+
+// send posts the encoded request to baseURL and decodes the response,
+// returning its HTTP status alongside
+func (c *PolicyClient) send(ctx context.Context, baseURL string, body *requestBody) (*PolicyResponse, int, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to build request: %w", err)
+	}
+	if err := body.attach(httpReq); err != nil {
+		return nil, 0, fmt.Errorf("failed to build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", requestContentType)
+	httpReq.Header.Set("Accept", "application/json")
+	if c.body.compressAbove > 0 {
+		httpReq.Header.Set("Accept-Encoding", "gzip")
+	}
+	d := decisionFrom(ctx)
+	if d != nil {
+		d.setHeaders(httpReq.Header)
+	}
+	if IsShadow(ctx) {
+		httpReq.Header.Set(ShadowHeader, "true")
+	}
+	c.setMetadataHeaders(ctx, httpReq.Header)
+	injectTraceContext(ctx, httpReq.Header)
+	if at, ok := ctx.Value(engineTimeKey{}).(time.Time); ok {
+		httpReq.Header.Set(EvaluationTimeHeader, at.UTC().Format(time.RFC3339))
+	}
+
+	resp, err := c.do(httpReq)
+	if err != nil {
+		return nil, 0, &TransportError{Err: err}
+	}
+	defer releaseBody(ctx, resp.Body)
+
+	if resp.StatusCode == http.StatusUnsupportedMediaType && body.compressed.Load() {
+		// The engine cannot read gzip; send this body again as it is, and
+		// no more compressed ones
+		c.connectionOwner().compressionRefused.Store(true)
+		body.compressed.Store(false)
+		return c.send(ctx, baseURL, body)
+	}
+	return c.decodeEvaluation(ctx, resp, body.rawTrace)
+}
+
+// decodeEvaluation decodes the engine's answer to an evaluation, returning
+// its HTTP status alongside; the trace is left raw if rawTrace is set
+func (c *PolicyClient) decodeEvaluation(ctx context.Context, resp *http.Response, rawTrace bool) (*PolicyResponse, int, error) {
+	if err := gunzipResponse(resp); err != nil {
+		return nil, resp.StatusCode, &ResponseBodyError{
+			Kind:          ErrMalformedResponse,
+			StatusCode:    resp.StatusCode,
+			ContentType:   resp.Header.Get("Content-Type"),
+			ContentLength: resp.ContentLength,
+			Err:           err,
+		}
+	}
+	if authErr := authError(resp); authErr != nil {
+		return nil, resp.StatusCode, authErr
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, resp.StatusCode, &OverloadedError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), c.now()),
+		}
+	}
+
+	var policyResponse PolicyResponse
+	raw := rawTraceResponse{wireResponse: wireResponse{PolicyResponse: &policyResponse}}
+	var keep *bytes.Buffer
+	if c.strictDecoding != strictOff {
+		keep = &bytes.Buffer{}
+	}
+	if err := readResponse(ctx, resp, &raw, keep); err != nil {
+		return nil, resp.StatusCode, err
+	}
+	policyResponse.rawResult = raw.Result
+	policyResponse.Result, _ = boolOutcome(raw.Result)
+	if rawTrace {
+		policyResponse.rawTrace = raw.Trace
+	} else if err := decodeTraces(&policyResponse, raw.Trace); err != nil {
+		return nil, resp.StatusCode, &ResponseBodyError{
+			Kind:          ErrMalformedResponse,
+			StatusCode:    resp.StatusCode,
+			ContentType:   resp.Header.Get("Content-Type"),
+			ContentLength: resp.ContentLength,
+			Err:           err,
+		}
+	}
+	if d := decisionFrom(ctx); d != nil {
+		policyResponse.DecisionID = d.id
+	}
+	if keep != nil {
+		policyResponse.SchemaSkew = checkSchema(keep.Bytes())
+	}
+	policyResponse.Warnings = append(headerWarnings(resp.Header), policyResponse.Warnings...)
+	if policyResponse.Warnings == nil {
+		policyResponse.Warnings = []Warning{}
+	}
+
+	engineErr, err := parseEngineError(raw.Error, resp.StatusCode)
+	if err != nil {
+		return nil, resp.StatusCode, &ResponseBodyError{
+			Kind:          ErrMalformedResponse,
+			StatusCode:    resp.StatusCode,
+			ContentType:   resp.Header.Get("Content-Type"),
+			ContentLength: resp.ContentLength,
+			Err:           err,
+		}
+	}
+	if engineErr != nil {
+		engineErr.lines = policyResponse.Rule
+		message := engineErr.Message
+		policyResponse.Error = &message
+		policyResponse.EngineError = engineErr
+	} else if resp.StatusCode >= http.StatusBadRequest {
+		return nil, resp.StatusCode, &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	return &policyResponse, resp.StatusCode, nil
+}
+
+// decodeTraces decodes a raw trace into both the response's Trace and its
+// ExecutionTrace
+func decodeTraces(response *PolicyResponse, raw json.RawMessage) error {
+	if len(raw) == 0 || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil
+	}
+	if err := unmarshalNumbers(raw, &response.Trace); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	response.ExecutionTrace = decodeTrace(raw)
+	return nil
+}
+
+// readResponse decodes resp's body into v, telling a body that is empty, not
+// JSON at all or cut short apart from one that is merely malformed. The body
+// is also copied into keep, if given. A read that fails, including because
+// ctx is done, is a *TransportError, and v must then be thrown away.
+func readResponse(ctx context.Context, resp *http.Response, v interface{}, keep *bytes.Buffer) error {
+	counted := &countingReader{r: resp.Body}
+	body := bufio.NewReaderSize(counted, responseSnippetBytes)
+	peeked, peekErr := body.Peek(responseSnippetBytes)
+	if bytes.HasPrefix(peeked, utf8BOM) {
+		// Some gateways and Windows tooling prefix JSON with a byte order
+		// mark, which encoding/json rejects
+		_, _ = body.Discard(len(utf8BOM))
+		peeked, peekErr = body.Peek(responseSnippetBytes)
+	}
+	start := bytes.TrimLeft(peeked, " \t\r\n")
+
+	bodyErr := &ResponseBodyError{
+		StatusCode:    resp.StatusCode,
+		ContentType:   resp.Header.Get("Content-Type"),
+		ContentLength: resp.ContentLength,
+	}
+	switch {
+	case len(start) == 0 && peekErr == io.EOF:
+		bodyErr.Kind = ErrEmptyResponse
+		return bodyErr
+	case len(start) > 0 && (start[0] != '{' || !jsonContentType(bodyErr.ContentType)):
+		bodyErr.Kind = ErrUnexpectedContentType
+		bodyErr.Snippet = string(peeked)
+		return bodyErr
+	}
+
+	var src io.Reader = body
+	if keep != nil {
+		src = io.TeeReader(body, keep)
+	}
+	err := decodeResponse(src, v)
+	if err == nil {
+		return nil
+	}
+	// Cancellation shows up as whatever the connection made of it, so the
+	// context is asked first
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return &TransportError{HeadersReceived: true, BytesRead: counted.n, Err: ctxErr}
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		bodyErr.Kind = ErrTruncatedResponse
+		bodyErr.Received = counted.n
+		bodyErr.Err = err
+		return bodyErr
+	}
+	if counted.err != nil {
+		return &TransportError{HeadersReceived: true, BytesRead: counted.n, Err: counted.err}
+	}
+	bodyErr.Kind = ErrMalformedResponse
+	bodyErr.Received = counted.n
+	bodyErr.Err = err
+	return bodyErr
+}
+
+// maxDrainBytes is how much of an unread response body releaseBody reads to
+// keep the connection; a longer one costs less to redial than to read
+const maxDrainBytes = 64 << 10
+
+// releaseBody closes a response body, first draining a short remainder so the
+// connection goes back to the pool. After ctx is done the body is closed
+// straight away, which tears the connection down.
+func releaseBody(ctx context.Context, body io.ReadCloser) {
+	if ctx.Err() == nil {
+		_, _ = io.CopyN(io.Discard, body, maxDrainBytes)
+	}
+	body.Close()
+}
+
+const (
+	// requestContentType is spelled out in full for gateways that compare
+	// it, parameters and all
+	requestContentType = "application/json; charset=utf-8"
+	// responseSnippetBytes is how much of an unexpected body errors quote
+	responseSnippetBytes = 256
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// jsonContentType reports whether a response's Content-Type allows JSON,
+// ignoring case and parameters such as charset. A missing one is sniffed from
+// the body instead, and so is text/plain, which is what Go sniffs for JSON
+// from servers that leave the header out.
+func jsonContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") || mediaType == "text/plain"
+}
+
+// countingReader counts the bytes read through it and remembers the first
+// read failure
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// decodeResponse decodes a response body straight off the wire instead of
+// reading it into memory first. As with json.Unmarshal, anything but
+// whitespace after the JSON value is an error.
+func decodeResponse(body io.Reader, v interface{}) error {
+	reader := &readErrorReader{r: body}
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	err := decoder.Decode(v)
+	if err == nil {
+		if _, trailing := decoder.Token(); trailing != io.EOF {
+			err = errors.New("invalid data after top-level value")
+		}
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if reader.err != nil {
+		return fmt.Errorf("failed to read response: %w", reader.err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return nil
+}
+
+// unmarshalNumbers is json.Unmarshal keeping numbers decoded into interfaces
+// as json.Number, so integers beyond 2^53 and long decimals survive
+func unmarshalNumbers(raw []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid data after top-level value")
+	}
+	return nil
+}
+
+// readErrorReader remembers the first read failure, so a broken connection is
+// reported as such rather than as malformed JSON
+type readErrorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readErrorReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// EvaluatePolicy is a shorthand for Evaluate with a single rule
+func (c *PolicyClient) EvaluatePolicy(ctx context.Context, rule string, data interface{}, trace bool) (*PolicyResponse, error) {
+	return c.Evaluate(ctx, PolicyRequest{Rule: rule, Data: data, Trace: trace})
+}
+
+// EvaluateRules is a shorthand for Evaluate with a policy of several rules.
+// The response's Labels hold the result of each labelled rule and Result
+// that of the rule no other refers to.
+func (c *PolicyClient) EvaluateRules(ctx context.Context, rules []string, data interface{}, trace bool) (*PolicyResponse, error) {
+	return c.Evaluate(ctx, PolicyRequest{Rules: rules, Data: data, Trace: trace})
+}
+
+// Health verifies the engine is healthy. A request that fails in transit,
+// including one whose context ends first, returns a *TransportError.
+func (c *PolicyClient) Health(ctx context.Context) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/health", nil)
+	if err != nil {
+		return fmt.Errorf("failed to build health request: %w", err)
+	}
+
+	resp, err := c.do(httpReq)
+	if err != nil {
+		return fmt.Errorf("health check failed: %w", &TransportError{Err: err})
+	}
+	defer releaseBody(ctx, resp.Body)
+
+	if authErr := authError(resp); authErr != nil {
+		return fmt.Errorf("health check failed: %w", authErr)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// prepareData aliases and normalises the per-call data, merges it onto the configured base
+// data, adds the ambient context and applies the outbound transforms
+func (c *PolicyClient) prepareData(ctx context.Context, data interface{}) (interface{}, error) {
+	if raw, ok := data.(rawJSON); ok {
+		// Already prepared and encoded, by EvaluateMany
+		return raw, nil
+	}
+	_, pinned := EvaluationTimeFromContext(ctx)
+	inject := c.injectContext || pinned
+	pipeline := c.aliases != nil || c.normalization != nil || c.baseData != nil || inject || len(c.transforms) > 0
+
+	data, err := classifyData(data, pipeline, !c.allowDuplicateKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.aliases != nil && data != nil {
+		aliased, err := policydata.ApplyTransforms(data, c.aliases)
+		if err != nil {
+			return nil, fmt.Errorf("failed to alias data: %w", err)
+		}
+		data = aliased
+	}
+
+	if c.normalization != nil && data != nil {
+		normalized, err := policydata.Normalize(data, *c.normalization)
+		if err != nil {
+			return nil, fmt.Errorf("failed to normalize data: %w", err)
+		}
+		data = normalized
+	}
+
+	if c.baseData != nil {
+		merged, err := policydata.Merge(c.baseData, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge base data: %w", err)
+		}
+		data = merged
+	}
+
+	if inject {
+		if data, err = c.injectContextData(ctx, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.transforms) > 0 {
+		var transformed map[string]interface{}
+		err := callback("data transform", func() (err error) {
+			transformed, err = policydata.ApplyTransforms(data, c.transforms...)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to transform data: %w", err)
+		}
+		data = transformed
+	}
+
+	return data, nil
+}
@@ -0,0 +1,659 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"policy-engine-testcontainer-example/policydata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+// fakeEngine answers every evaluation with a successful response and records
+// both the raw request bodies and their decoded form
+type fakeEngine struct {
+	*httptest.Server
+
+	mu             sync.Mutex
+	bodies         [][]byte
+	requests       []PolicyRequest
+	contentLengths []int64
+}
+
+func newFakeEngine(t *testing.T) *fakeEngine {
+	t.Helper()
+
+	engine := &fakeEngine{}
+	engine.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req PolicyRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		engine.mu.Lock()
+		engine.bodies = append(engine.bodies, body)
+		engine.requests = append(engine.requests, req)
+		engine.contentLengths = append(engine.contentLengths, r.ContentLength)
+		engine.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(PolicyResponse{Result: true, Rule: []string{req.Rule}, Data: req.Data})
+	}))
+	t.Cleanup(engine.Close)
+
+	return engine
+}
+
+func (f *fakeEngine) Requests() []PolicyRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]PolicyRequest(nil), f.requests...)
+}
+
+func (f *fakeEngine) Bodies() [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]byte(nil), f.bodies...)
+}
+
+func (f *fakeEngine) ContentLengths() []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int64(nil), f.contentLengths...)
+}
+
+// TestEvaluate tests a plain round trip against a fake engine
+func TestEvaluate(t *testing.T) {
+	engine := newFakeEngine(t)
+
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	rule := "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+	response, err := c.EvaluatePolicy(context.Background(), rule, map[string]interface{}{"age": 70}, true)
+	require.NoError(t, err)
+
+	assert.True(t, response.Result)
+	requests := engine.Requests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, rule, requests[0].Rule)
+	assert.True(t, requests[0].Trace)
+	assert.Equal(t, map[string]interface{}{"age": float64(70)}, requests[0].Data)
+}
+
+// TestEvaluateRules tests that a policy's rules are sent as one text, after
+// Rule when both are given, and audited and hashed as that text
+func TestEvaluateRules(t *testing.T) {
+	engine := newFakeEngine(t)
+	var audit auditRecords
+	c, err := New(engine.URL, WithAuditSink(&audit))
+	require.NoError(t, err)
+
+	adult := "adult. A **Person** is an adult if the __age__ of the **Person** is at least 18.\n"
+	drive := "A **Person** can drive if the **Person** is an adult."
+	_, err = c.EvaluateRules(context.Background(), []string{adult, drive}, map[string]interface{}{}, false)
+	require.NoError(t, err)
+	_, err = c.Evaluate(context.Background(), PolicyRequest{Rule: adult, Rules: []string{drive}})
+	require.NoError(t, err)
+
+	want := "adult. A **Person** is an adult if the __age__ of the **Person** is at least 18.\n\n" + drive
+	requests := engine.Requests()
+	require.Len(t, requests, 2)
+	assert.Equal(t, want, requests[0].Rule)
+	assert.Equal(t, want, requests[1].Rule)
+	assert.NotContains(t, string(engine.Bodies()[0]), "Rules")
+	for _, record := range audit.all() {
+		assert.Equal(t, RuleHash(want), record.RuleHash)
+	}
+
+	assert.Equal(t, "one", PolicyRequest{Rule: "one"}.RuleText())
+	assert.Equal(t, "one\n\ntwo", PolicyRequest{Rules: []string{"one\r\n", "two"}}.RuleText())
+}
+
+// TestHealth tests the health endpoint succeeds against a healthy engine
+func TestHealth(t *testing.T) {
+	engine := newFakeEngine(t)
+
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+	assert.NoError(t, c.Health(context.Background()))
+}
+
+// TestContextEndsWedgedCall tests that evaluations and health checks against
+// an engine that never answers return as soon as their context ends, with a
+// *TransportError matching the context's error
+func TestContextEndsWedgedCall(t *testing.T) {
+	wedged := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Minute):
+		case <-r.Context().Done():
+		}
+	}))
+	defer wedged.Close()
+	c, err := New(wedged.URL)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	began := time.Now()
+	_, err = c.EvaluatePolicy(ctx, "rule", nil, false)
+	assert.Less(t, time.Since(began), 5*time.Second)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	var transportErr *TransportError
+	assert.ErrorAs(t, err, &transportErr)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	began = time.Now()
+	err = c.Health(ctx)
+	assert.Less(t, time.Since(began), 5*time.Second)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorAs(t, err, &transportErr)
+	assert.ErrorContains(t, err, "health check failed")
+}
+
+// TestNewRejectsInvalidURL tests that a malformed base URL fails construction
+func TestNewRejectsInvalidURL(t *testing.T) {
+	_, err := New("not a url")
+	assert.Error(t, err)
+}
+
+// TestWithBaseData tests that per-call data is merged onto the base without mutating it
+func TestWithBaseData(t *testing.T) {
+	engine := newFakeEngine(t)
+
+	base := map[string]interface{}{
+		"Customer": map[string]interface{}{"membership_level": "silver", "country": "GB"},
+		"Order":    map[string]interface{}{"total": 80},
+	}
+
+	c, err := New(engine.URL, WithBaseData(base))
+	require.NoError(t, err)
+
+	// Changing the caller's base after construction must not affect requests
+	base["Order"].(map[string]interface{})["total"] = 999
+
+	_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{
+		"Customer": map[string]interface{}{"membership_level": "gold"},
+	}, false)
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{
+		"Order": map[string]interface{}{"total": 150},
+	}, false)
+	require.NoError(t, err)
+
+	requests := engine.Requests()
+	require.Len(t, requests, 2)
+	assert.Equal(t, map[string]interface{}{
+		"Customer": map[string]interface{}{"membership_level": "gold", "country": "GB"},
+		"Order":    map[string]interface{}{"total": float64(80)},
+	}, requests[0].Data)
+	assert.Equal(t, map[string]interface{}{
+		"Customer": map[string]interface{}{"membership_level": "silver", "country": "GB"},
+		"Order":    map[string]interface{}{"total": float64(150)},
+	}, requests[1].Data)
+}
+
+// TestWithBaseDataRejectsNonObject tests that an unusable base fails construction
+func TestWithBaseDataRejectsNonObject(t *testing.T) {
+	_, err := New("http://localhost:3000", WithBaseData([]string{"not", "an", "object"}))
+	assert.Error(t, err)
+}
+
+// TestWithDataTransforms tests that configured paths never reach the wire in raw form
+func TestWithDataTransforms(t *testing.T) {
+	engine := newFakeEngine(t)
+
+	c, err := New(engine.URL, WithDataTransforms(
+		policydata.DropFields("Person.ssn"),
+		policydata.HashFields("pepper", "Person.email"),
+		policydata.BucketNumbers("Person.age", "age_band",
+			policydata.Bucket{Below: 65, Label: "under_65"},
+			policydata.Bucket{Below: math.Inf(1), Label: "65_plus"},
+		),
+	))
+	require.NoError(t, err)
+
+	data := map[string]interface{}{
+		"Person": map[string]interface{}{
+			"age":   70,
+			"ssn":   "123-45-6789",
+			"email": "pat@example.com",
+		},
+	}
+	original := map[string]interface{}{
+		"Person": map[string]interface{}{
+			"age":   70,
+			"ssn":   "123-45-6789",
+			"email": "pat@example.com",
+		},
+	}
+
+	response, err := c.EvaluatePolicy(context.Background(), "rule", data, false)
+	require.NoError(t, err)
+
+	bodies := engine.Bodies()
+	require.Len(t, bodies, 1)
+	wire := string(bodies[0])
+	for _, raw := range []string{"123-45-6789", "pat@example.com", `"age":`} {
+		assert.NotContains(t, wire, raw)
+	}
+	assert.Contains(t, wire, `"age_band":"65_plus"`)
+
+	hashed, err := policydata.HashValue("pepper", "pat@example.com")
+	require.NoError(t, err)
+	assert.Contains(t, wire, hashed)
+
+	// The echoed data reflects what the engine saw, never the originals
+	assert.Equal(t, map[string]interface{}{
+		"Person": map[string]interface{}{"age_band": "65_plus", "email": hashed},
+	}, response.Data)
+	assert.True(t, reflect.DeepEqual(original, data), "caller data was mutated")
+}
+
+// TestWithKeyNormalization tests that mixed key styles are canonicalised before merging
+func TestWithKeyNormalization(t *testing.T) {
+	engine := newFakeEngine(t)
+
+	c, err := New(engine.URL,
+		WithKeyNormalization(policydata.NormalizationConfig{Style: policydata.SnakeCase}),
+		WithBaseData(map[string]interface{}{
+			"Customer": map[string]interface{}{"membership-level": "silver", "Country": "GB"},
+		}),
+	)
+	require.NoError(t, err)
+
+	_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{
+		"Customer": map[string]interface{}{"MembershipLevel": "gold"},
+	}, false)
+	require.NoError(t, err)
+
+	requests := engine.Requests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, map[string]interface{}{
+		"Customer": map[string]interface{}{"membership_level": "gold", "country": "GB"},
+	}, requests[0].Data)
+
+	// Colliding keys fail the call before anything is sent
+	_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{
+		"Customer": map[string]interface{}{"MembershipLevel": "gold", "membership_level": "silver"},
+	}, false)
+	var collision *policydata.KeyCollisionError
+	assert.True(t, errors.As(err, &collision))
+	assert.Len(t, engine.Requests(), 1)
+}
+
+// TestWithAliases tests that Go-side names reach the engine as rule property names
+func TestWithAliases(t *testing.T) {
+	engine := newFakeEngine(t)
+
+	aliases, err := policydata.NewAliasRegistry(policydata.Aliases{
+		"Customer": {"Tier": "membership_level"},
+		"Order":    {"GrandTotal": "total"},
+	})
+	require.NoError(t, err)
+
+	type customer struct {
+		Tier    string `json:"Tier"`
+		Country string `json:"country"`
+	}
+
+	c, err := New(engine.URL,
+		WithAliases(aliases),
+		WithBaseData(map[string]interface{}{"Order": map[string]interface{}{"GrandTotal": 80}}),
+		WithKeyNormalization(policydata.NormalizationConfig{Style: policydata.SnakeCase}),
+	)
+	require.NoError(t, err)
+
+	_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{
+		"Customer": customer{Tier: "gold", Country: "GB"},
+	}, false)
+	require.NoError(t, err)
+
+	bodies := engine.Bodies()
+	require.Len(t, bodies, 1)
+	assert.JSONEq(t, `{"rule":"rule","data":{"Customer":{"membership_level":"gold","country":"GB"},"Order":{"total":80}}}`, string(bodies[0]))
+
+	// Traces name the rule property; the registry maps it back for explanations
+	assert.Equal(t, "Tier (membership_level)", aliases.DescribePath("$.Customer.membership_level"))
+}
+
+// TestDecodeResponse tests that streamed response decoding keeps json.Unmarshal's strictness
+func TestDecodeResponse(t *testing.T) {
+	for body, wantErr := range map[string]string{
+		`{"result":true,"rule":["r"]}`:        "",
+		"{\"result\":true}\n  ":               "",
+		`{"result":true}{"result":false}`:     "failed to unmarshal response",
+		`{"result":true} trailing`:            "failed to unmarshal response",
+		`{"result":tr`:                        "failed to unmarshal response: unexpected EOF",
+		``:                                    "failed to unmarshal response: unexpected EOF",
+		`{"result":"yes"}`:                    "failed to unmarshal response",
+		`{"result":true,"labels":{"a":true}}`: "",
+	} {
+		var response PolicyResponse
+		err := decodeResponse(strings.NewReader(body), &response)
+		if wantErr == "" {
+			assert.NoError(t, err, body)
+			assert.True(t, response.Result, body)
+		} else {
+			assert.ErrorContains(t, err, wantErr, body)
+		}
+	}
+
+	err := decodeResponse(io.MultiReader(strings.NewReader(`{"res`), iotest.ErrReader(io.ErrClosedPipe)), &PolicyResponse{})
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+	assert.ErrorContains(t, err, "failed to read response")
+}
+
+// TestContentTypeHeaders tests that requests spell out their content type and ask for JSON back
+func TestContentTypeHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"result":true}`)
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL)
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+	require.NoError(t, err)
+
+	header := <-headers
+	assert.Equal(t, "application/json; charset=utf-8", header.Get("Content-Type"))
+	assert.Equal(t, "application/json", header.Get("Accept"))
+}
+
+// TestTolerantResponses tests that parameterized or missing content types and byte order marks are accepted
+func TestTolerantResponses(t *testing.T) {
+	body := `{"result":true,"rule":["rule"]}`
+	bom := "\xEF\xBB\xBF"
+	for name, response := range map[string]string{
+		"charset":            rawResponse(http.StatusOK, "application/json; charset=UTF-8", len(body), body),
+		"mixed case":         rawResponse(http.StatusOK, "Application/JSON;Charset=utf-8", len(body), body),
+		"problem json":       rawResponse(http.StatusOK, "application/problem+json", len(body), body),
+		"missing":            rawResponse(http.StatusOK, "", len(body), body),
+		"missing with bom":   rawResponse(http.StatusOK, "", len(bom+body), bom+body),
+		"bom":                rawResponse(http.StatusOK, "application/json; charset=utf-8", len(bom+body), bom+body),
+		"bom and whitespace": rawResponse(http.StatusOK, "application/json", len(bom+"\n "+body), bom+"\n "+body),
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := New(rawEngine(t, response))
+			require.NoError(t, err)
+			for _, trace := range []bool{false, true} {
+				response, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, trace)
+				require.NoError(t, err)
+				assert.True(t, response.Result)
+				assert.Equal(t, []string{"rule"}, response.Rule)
+			}
+		})
+	}
+
+	// A byte order mark on its own is still an empty response
+	c, err := New(rawEngine(t, rawResponse(http.StatusOK, "application/json", 3, bom)))
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+	assert.ErrorIs(t, err, ErrEmptyResponse)
+}
+
+// TestParseRetryAfter tests both Retry-After forms and the values treated as no wait
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)
+	for header, want := range map[string]time.Duration{
+		"":                              0,
+		"3":                             3 * time.Second,
+		" 120 ":                         2 * time.Minute,
+		"0":                             0,
+		"-5":                            0,
+		"soon":                          0,
+		"Fri, 15 Mar 2024 10:30:30 GMT": 30 * time.Second,
+		"Fri, 15 Mar 2024 10:29:00 GMT": 0,
+	} {
+		assert.Equal(t, want, parseRetryAfter(header, now), "%q", header)
+	}
+}
+
+// TestOverloadedError tests that a 429 surfaces as an OverloadedError carrying Retry-After
+func TestOverloadedError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "2")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL)
+	require.NoError(t, err)
+
+	_, err = c.EvaluatePolicy(context.Background(), "rule", nil, false)
+	var overloadedErr *OverloadedError
+	require.ErrorAs(t, err, &overloadedErr)
+	assert.Equal(t, &OverloadedError{StatusCode: http.StatusTooManyRequests, RetryAfter: 2 * time.Second}, overloadedErr)
+	assert.EqualError(t, err, "engine overloaded (status 429), retry after 2s")
+}
+
+// BenchmarkEvaluateAllocs compares the client's pooled request encoding and
+// streamed response decoding with marshalling and io.ReadAll per call
+func BenchmarkEvaluateAllocs(b *testing.B) {
+	response, err := json.Marshal(PolicyResponse{
+		Result: true,
+		Trace:  map[string]interface{}{"execution": []interface{}{map[string]interface{}{"selector": "Person", "outcome": true}}},
+		Labels: map[string]bool{"senior_discount": true},
+		Rule:   []string{"rule"},
+		Data:   map[string]interface{}{"Person": map[string]interface{}{"age": 70}},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(response)
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	rule := "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+	data := orderHistory(8 * 1024)
+
+	b.Run("before/marshal-readall", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			encoded, err := json.Marshal(PolicyRequest{Rule: rule, Data: data})
+			if err != nil {
+				b.Fatal(err)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, bytes.NewReader(encoded))
+			if err != nil {
+				b.Fatal(err)
+			}
+			resp, err := c.httpClient.Do(req)
+			if err != nil {
+				b.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+			var decoded PolicyResponse
+			if err := json.Unmarshal(body, &decoded); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("after/client", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := c.EvaluatePolicy(ctx, rule, data, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// newDripEngine answers the rule "slow" with a large trace written a kilobyte
+// at a time, reporting each flushed chunk on the channel it returns, and any
+// other rule at once. A non-zero stall holds back the headers of slow answers.
+func newDripEngine(t *testing.T, stall time.Duration) (*httptest.Server, <-chan int) {
+	t.Helper()
+
+	chunks := make(chan int, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req PolicyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if req.Rule != "slow" {
+			_, _ = io.WriteString(w, `{"result":true,"rule":["rule"],"data":null}`)
+			return
+		}
+
+		select {
+		case <-time.After(stall):
+		case <-r.Context().Done():
+			return
+		}
+		_, _ = io.WriteString(w, `{"result":true,"rule":["slow"],"data":null,"trace":{"padding":"`)
+		for i := 1; i <= 100; i++ {
+			_, _ = io.WriteString(w, strings.Repeat("x", 1024))
+			w.(http.Flusher).Flush()
+			chunks <- i
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		_, _ = io.WriteString(w, `"}}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, chunks
+}
+
+// connReuse evaluates rule and reports whether it went out on an existing
+// connection
+func connReuse(t *testing.T, c *PolicyClient, rule string) bool {
+	t.Helper()
+	var reused bool
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused },
+	})
+	_, err := c.EvaluatePolicy(ctx, rule, nil, false)
+	require.NoError(t, err)
+	return reused
+}
+
+// TestCancelWhileReading tests that cancelling at each stage of a response
+// returns the context's error, no response, and leaks nothing
+func TestCancelWhileReading(t *testing.T) {
+	for name, tc := range map[string]struct {
+		stall           time.Duration
+		timeout         bool
+		afterChunks     int
+		headersReceived bool
+		wantErr         error
+	}{
+		"before headers":   {stall: time.Minute, wantErr: context.Canceled},
+		"mid-body":         {afterChunks: 3, headersReceived: true, wantErr: context.Canceled},
+		"deadline in body": {timeout: true, headersReceived: true, wantErr: context.DeadlineExceeded},
+	} {
+		t.Run(name, func(t *testing.T) {
+			leaks := goleak.IgnoreCurrent()
+			server, chunks := newDripEngine(t, tc.stall)
+			c, err := New(server.URL)
+			require.NoError(t, err)
+			require.False(t, connReuse(t, c, "fast"))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tc.timeout {
+				ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+			} else {
+				go func() {
+					if tc.afterChunks == 0 {
+						time.Sleep(20 * time.Millisecond)
+					}
+					for i := 0; i < tc.afterChunks; i++ {
+						<-chunks
+					}
+					cancel()
+				}()
+			}
+			defer cancel()
+
+			response, err := c.EvaluatePolicy(ctx, "slow", nil, true)
+			assert.Nil(t, response)
+			assert.ErrorIs(t, err, tc.wantErr)
+			var transportErr *TransportError
+			require.ErrorAs(t, err, &transportErr)
+			assert.Equal(t, tc.headersReceived, transportErr.HeadersReceived)
+			if tc.headersReceived {
+				assert.Greater(t, transportErr.BytesRead, int64(0))
+				assert.Less(t, transportErr.BytesRead, int64(100*1024))
+				assert.ErrorContains(t, err, "failed to read response after")
+			}
+
+			// The connection was torn down rather than pooled with half a
+			// response still on it
+			assert.False(t, connReuse(t, c, "fast"))
+
+			require.NoError(t, c.Close())
+			server.Close()
+			goleak.VerifyNone(t, leaks)
+		})
+	}
+}
+
+// TestCancelAfterReading tests that cancelling once the response is read
+// leaves the response and its connection intact
+func TestCancelAfterReading(t *testing.T) {
+	leaks := goleak.IgnoreCurrent()
+	server, _ := newDripEngine(t, 0)
+	c, err := New(server.URL)
+	require.NoError(t, err)
+	require.False(t, connReuse(t, c, "fast"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	response, err := c.EvaluatePolicy(ctx, "slow", nil, true)
+	cancel()
+	require.NoError(t, err)
+	assert.Len(t, response.Trace["padding"], 100*1024)
+	assert.True(t, connReuse(t, c, "fast"))
+
+	require.NoError(t, c.Close())
+	server.Close()
+	goleak.VerifyNone(t, leaks)
+}
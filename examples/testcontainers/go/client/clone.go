@@ -0,0 +1,111 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// connectionSettings are the options a clone shares with its original and so
+// cannot change
+type connectionSettings struct {
+	strategy      ConnectionStrategy
+	tlsConfig     *tls.Config
+	warmupConns   int
+	replicas      []string
+	policy        BalancerPolicy
+	probeInterval time.Duration
+	recording     *recordSpec
+	httpClient    *http.Client
+}
+
+func (c *PolicyClient) connectionSettings() connectionSettings {
+	return connectionSettings{
+		strategy:      c.connStrategy,
+		tlsConfig:     c.tlsConfig,
+		warmupConns:   c.warmupConns,
+		replicas:      c.replicas,
+		policy:        c.balancerPolicy,
+		probeInterval: c.probeInterval,
+		recording:     c.recording,
+		httpClient:    c.customHTTPClient,
+	}
+}
+
+var errCloneConnections = errors.New("connection options cannot differ from the original client")
+
+// Clone returns a client configured like this one with opts applied on top,
+// e.g. to give each tenant its own base data or context. The clone shares this
+// client's connection pool, endpoints and their health, adaptive timeouts
+// and coalesced calls, so it starts without dialling anything; options
+// concerning the connections themselves (WithConnectionStrategy,
+// WithTLSConfig, WithHTTPClient, WithWarmup, WithEndpoints, WithBalancer,
+// WithHealthProbes and WithRecorder) fail the clone. Each client counts only its own evaluations
+// for Shutdown, and closing a clone leaves the shared connections open.
+func (c *PolicyClient) Clone(opts ...Option) (*PolicyClient, error) {
+	clone, err := c.clone(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := clone.buildProfiles(); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+// clone is Clone without building the clone's rule profiles
+func (c *PolicyClient) clone(opts []Option) (*PolicyClient, error) {
+	clone := &PolicyClient{
+		baseURL:    c.baseURL,
+		httpClient: c.httpClient,
+		now:        time.Now,
+		body:       bodyOptions{streamingThreshold: defaultStreamingThreshold},
+		stats:      &failureStats{},
+
+		closeBackground: func() {},
+		parent:          c.connectionOwner(),
+	}
+	for _, opt := range c.options {
+		opt(clone)
+	}
+	// Start from this client's copy of the base data, not whatever the
+	// value given to WithBaseData has become since
+	if c.baseCopy != nil {
+		clone.baseData = c.baseCopy
+	}
+	clone.latencies = c.latencies
+	clone.breaker = c.breaker
+	clone.limiter = c.limiter
+	clone.coalescer = c.coalescer
+	clone.balancer = c.balancer
+	clone.cache = c.cache
+	clone.shadow = c.shadow
+	clone.capabilities = c.capabilities
+
+	shared := clone.connectionSettings()
+	for _, opt := range opts {
+		opt(clone)
+	}
+	if clone.cache != c.cache {
+		clone.configureCache()
+	}
+	if !reflect.DeepEqual(clone.connectionSettings(), shared) {
+		return nil, fmt.Errorf("failed to clone client: %w", errCloneConnections)
+	}
+	clone.options = append(append([]Option(nil), c.options...), opts...)
+
+	if err := clone.snapshotBaseData(); err != nil {
+		return nil, err
+	}
+	if err := clone.configureTelemetry(); err != nil {
+		return nil, err
+	}
+	if err := clone.configurePrometheus(); err != nil {
+		return nil, err
+	}
+	clone.configureMiddleware()
+	return clone, nil
+}
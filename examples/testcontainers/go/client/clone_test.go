@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestClone tests that a clone layers its own options over the original's
+func TestClone(t *testing.T) {
+	engine := newFakeEngine(t)
+	base := map[string]interface{}{"Customer": map[string]interface{}{"tier": "gold", "region": "eu"}}
+	c, err := New(engine.URL, WithBaseData(base))
+	require.NoError(t, err)
+
+	// Changing the caller's base after New reaches neither client
+	base["Customer"].(map[string]interface{})["tier"] = "changed"
+
+	tenant, err := c.Clone(WithBaseData(map[string]interface{}{"Customer": map[string]interface{}{"tier": "silver"}}))
+	require.NoError(t, err)
+	same, err := c.Clone()
+	require.NoError(t, err)
+
+	for _, client := range []*PolicyClient{c, tenant, same} {
+		_, err := client.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{"Order": map[string]interface{}{"n": 1}}, false)
+		require.NoError(t, err)
+	}
+
+	requests := engine.Requests()
+	require.Len(t, requests, 3)
+	assert.Equal(t, map[string]interface{}{"tier": "gold", "region": "eu"}, requests[0].Data.(map[string]interface{})["Customer"])
+	assert.Equal(t, map[string]interface{}{"tier": "silver"}, requests[1].Data.(map[string]interface{})["Customer"])
+	assert.Equal(t, requests[0].Data, requests[2].Data)
+}
+
+// TestCloneSharesConnections tests that a clone evaluates over the original's connections
+func TestCloneSharesConnections(t *testing.T) {
+	server, conns := newCountingEngine(t)
+	c, err := New(server.URL)
+	require.NoError(t, err)
+	require.NoError(t, c.Warmup(context.Background()))
+
+	clone, err := c.Clone(WithBaseData(map[string]interface{}{"tenant": "a"}))
+	require.NoError(t, err)
+	assert.True(t, reusedConn(t, clone))
+
+	// Closing the clone leaves the shared connections open
+	require.NoError(t, clone.Close())
+	assert.True(t, reusedConn(t, c))
+	assert.Equal(t, int64(1), *conns)
+}
+
+// TestCloneConnectionOptions tests that a clone can't change how connections are made
+func TestCloneConnectionOptions(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	for name, opt := range map[string]Option{
+		"strategy":  WithConnectionStrategy(HTTP1Pool),
+		"tls":       WithTLSConfig(&tls.Config{MinVersion: tls.VersionTLS13}),
+		"warmup":    WithWarmup(2),
+		"endpoints": WithEndpoints(engine.URL),
+		"balancer":  WithBalancer(P2C),
+		"probes":    WithHealthProbes(time.Second),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := c.Clone(opt)
+			assert.ErrorIs(t, err, errCloneConnections)
+		})
+	}
+}
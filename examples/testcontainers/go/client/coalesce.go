@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"policy-engine-testcontainer-example/policydata"
+)
+
+// WithCoalescing makes concurrent identical evaluations share one request:
+// while an evaluation is in flight, others with the same rule, trace flag and
+// data, as identified by policydata.CanonicalHash after the data pipeline has
+// run, wait for its response instead of sending their own. Every caller gets
+// its own deep copy of the response.
+//
+// A caller whose context ends stops waiting without affecting the others; the
+// shared request is only cancelled once every caller waiting on it has gone.
+// Reader data is never coalesced, since hashing would consume it.
+// CoalescedEvaluations counts the evaluations that were served this way, as
+// do the Prometheus and OpenTelemetry metrics.
+func WithCoalescing() Option {
+	return func(c *PolicyClient) {
+		c.coalescer = &coalescer{calls: map[string]*sharedCall{}}
+	}
+}
+
+// CoalescedEvaluations returns how many evaluations were answered by another
+// caller's request under WithCoalescing
+func (c *PolicyClient) CoalescedEvaluations() int64 {
+	if c.coalescer == nil {
+		return 0
+	}
+	return c.coalescer.coalesced.Load()
+}
+
+type coalescer struct {
+	mu        sync.Mutex
+	calls     map[string]*sharedCall
+	coalesced atomic.Int64
+}
+
+// sharedCall is one in-flight request and the callers waiting on it
+type sharedCall struct {
+	done     chan struct{}
+	response *PolicyResponse
+	err      error
+
+	// waiters and cancel are guarded by the coalescer's mutex
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// coalesceKey identifies a prepared request, or reports that it can't be
+// coalesced
+func coalesceKey(req PolicyRequest, rawTrace bool) (string, bool) {
+	data := req.Data
+	switch value := data.(type) {
+	case *readerData:
+		return "", false
+	case rawJSON:
+		data = json.RawMessage(value)
+	}
+	hash, err := policydata.CanonicalHash(data)
+	if err != nil {
+		// Let the evaluation itself report the problem
+		return "", false
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s\x00%t%t\x00%s", len(req.Rule), req.Rule, req.Trace, rawTrace, hash)
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// do runs fn for key, or joins the call already running it, calling joined,
+// and returns a copy of the response
+func (g *coalescer) do(ctx context.Context, key string, fn func(ctx context.Context) (*PolicyResponse, error), joined func()) (*PolicyResponse, error) {
+	g.mu.Lock()
+	call, running := g.calls[key]
+	if running {
+		call.waiters++
+		g.coalesced.Add(1)
+		joined()
+	} else {
+		// The request outlives any one caller, so it keeps the context's
+		// values but not its cancellation
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &sharedCall{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		g.calls[key] = call
+		go func() {
+			call.response, call.err = fn(callCtx)
+			g.mu.Lock()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			cancel()
+			close(call.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return nil, call.err
+		}
+		return cloneResponse(call.response), nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// Nobody is left to use the response; later callers start afresh
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			call.cancel()
+		}
+		g.mu.Unlock()
+		return nil, &TransportError{Err: ctx.Err()}
+	}
+}
+
+// countCoalesced counts in the metrics an evaluation answered by another
+// caller's request
+func (c *PolicyClient) countCoalesced(ctx context.Context) func() {
+	return func() {
+		c.prometheus.coalesce()
+		c.telemetry.coalesce(ctx)
+	}
+}
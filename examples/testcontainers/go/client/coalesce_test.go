@@ -0,0 +1,188 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowEngine answers after delay, counting requests and cancellations
+type slowEngine struct {
+	*httptest.Server
+
+	requests, cancelled int64
+}
+
+func newSlowEngine(t *testing.T, delay time.Duration) *slowEngine {
+	t.Helper()
+
+	engine := &slowEngine{}
+	engine.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req PolicyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		atomic.AddInt64(&engine.requests, 1)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			atomic.AddInt64(&engine.cancelled, 1)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(PolicyResponse{Result: true, Rule: []string{req.Rule}, Data: req.Data})
+	}))
+	t.Cleanup(engine.Close)
+
+	return engine
+}
+
+// TestCoalescing tests that 200 concurrent identical calls send exactly one request
+func TestCoalescing(t *testing.T) {
+	engine := newSlowEngine(t, 200*time.Millisecond)
+	c, err := New(engine.URL, WithCoalescing())
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	responses := make([]*PolicyResponse, 200)
+	start := make(chan struct{})
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			// Equal data built independently, in different key orders
+			data := map[string]interface{}{"user": map[string]interface{}{"id": 7, "plan": "pro"}}
+			if i%2 == 1 {
+				data = map[string]interface{}{"user": map[string]interface{}{"plan": "pro", "id": 7.0}}
+			}
+			response, err := c.EvaluatePolicy(context.Background(), "rule", data, false)
+			if assert.NoError(t, err) {
+				responses[i] = response
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&engine.requests))
+	assert.Equal(t, int64(199), c.CoalescedEvaluations())
+
+	// Every caller has its own copy
+	responses[0].Data.(map[string]interface{})["user"].(map[string]interface{})["plan"] = "changed"
+	for _, response := range responses[1:] {
+		assert.Equal(t, "pro", response.Data.(map[string]interface{})["user"].(map[string]interface{})["plan"])
+	}
+}
+
+// TestCoalescingDistinctRequests tests that differing rules, data and trace flags are sent separately
+func TestCoalescingDistinctRequests(t *testing.T) {
+	engine := newSlowEngine(t, 50*time.Millisecond)
+	c, err := New(engine.URL, WithCoalescing())
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for _, call := range []struct {
+		rule  string
+		data  interface{}
+		trace bool
+	}{
+		{"rule", map[string]interface{}{"n": 1}, false},
+		{"rule", map[string]interface{}{"n": 2}, false},
+		{"other", map[string]interface{}{"n": 1}, false},
+		{"rule", map[string]interface{}{"n": 1}, true},
+	} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.EvaluatePolicy(context.Background(), call.rule, call.data, call.trace)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(4), atomic.LoadInt64(&engine.requests))
+	assert.Zero(t, c.CoalescedEvaluations())
+}
+
+// TestCoalescingCancelledWaiter tests that a waiter leaving early doesn't cancel the shared request
+func TestCoalescingCancelledWaiter(t *testing.T) {
+	engine := newSlowEngine(t, 200*time.Millisecond)
+	c, err := New(engine.URL, WithCoalescing())
+	require.NoError(t, err)
+
+	leader := make(chan error, 1)
+	go func() {
+		_, err := c.EvaluatePolicy(context.Background(), "rule", nil, false)
+		leader <- err
+	}()
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&engine.requests) == 1 }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = c.EvaluatePolicy(ctx, "rule", nil, false)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	assert.NoError(t, <-leader)
+	assert.Zero(t, atomic.LoadInt64(&engine.cancelled))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&engine.requests))
+}
+
+// TestCoalescingLastWaiterCancels tests that the shared request is cancelled once every waiter has left
+func TestCoalescingLastWaiterCancels(t *testing.T) {
+	engine := newSlowEngine(t, time.Hour)
+	c, err := New(engine.URL, WithCoalescing())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.EvaluatePolicy(ctx, "rule", nil, false)
+			assert.ErrorIs(t, err, context.Canceled)
+		}()
+	}
+	require.Eventually(t, func() bool { return c.CoalescedEvaluations() == 2 }, time.Second, time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&engine.cancelled) == 1 }, time.Second, time.Millisecond)
+
+	// A later caller starts a fresh request rather than joining the cancelled one
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.EvaluatePolicy(ctx, "rule", nil, false)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&engine.requests))
+}
+
+// TestCoalescingReaderData tests that reader data is never coalesced
+func TestCoalescingReaderData(t *testing.T) {
+	engine := newSlowEngine(t, 50*time.Millisecond)
+	c, err := New(engine.URL, WithCoalescing())
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.EvaluatePolicy(context.Background(), "rule", strings.NewReader(`{"n":1}`), false)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(3), atomic.LoadInt64(&engine.requests))
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// defaultCompressionThreshold is the encoded size above which WithCompression
+// gzips request bodies
+const defaultCompressionThreshold = 64 << 10
+
+// WithCompression gzips request bodies whose encoding is larger than 64 KiB,
+// sending them with Content-Encoding: gzip, and asks for gzipped responses,
+// which are decompressed transparently. Smaller bodies are never compressed.
+// An engine answering a compressed request with 415 Unsupported Media Type
+// is sent it again uncompressed, once, and no further requests are
+// compressed. Data given as a reader, whose size is unknown, is not
+// compressed.
+func WithCompression() Option {
+	return WithCompressionThreshold(defaultCompressionThreshold)
+}
+
+// WithCompressionThreshold is WithCompression, compressing bodies larger than
+// bytes instead
+func WithCompressionThreshold(bytes int) Option {
+	return func(c *PolicyClient) {
+		c.body.compressAbove = bytes
+	}
+}
+
+// bodyOptions is the client's body options, without compression once the
+// engine has refused it, and under WithCapabilityProbing limited to the size
+// the engine advertises when WithMaxRequestBytes is not set
+func (c *PolicyClient) bodyOptions(ctx context.Context) bodyOptions {
+	opts := c.body
+	if c.connectionOwner().compressionRefused.Load() {
+		opts.compressAbove = 0
+	}
+	if opts.maxBytes == 0 && c.capabilities != nil {
+		if capabilities, err := c.Capabilities(ctx); err == nil {
+			opts.maxBytes = capabilities.MaxRequestBytes
+		}
+	}
+	return opts
+}
+
+// gzipped copies r to a pipe, gzipping it, and closes r when done
+func gzipped(r io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer r.Close()
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, r)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// gunzipResponse decompresses resp's body in place if it is gzipped and the
+// transport has not already done so
+func gunzipResponse(resp *http.Response) error {
+	if !strings.EqualFold(strings.TrimSpace(resp.Header.Get("Content-Encoding")), "gzip") {
+		return nil
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body = &gunzipBody{Reader: gz, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// gunzipBody reads a gzipped response body decompressed
+type gunzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gunzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
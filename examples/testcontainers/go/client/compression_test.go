@@ -0,0 +1,155 @@
+package client
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// compressedRequest is what a gzipEngine saw of one request
+type compressedRequest struct {
+	encoding string
+	size     int
+}
+
+// newGzipEngine is an engine that reads gzipped requests, recording each, and
+// gzips its answers when asked; with refuse it answers gzipped requests with
+// 415 instead
+func newGzipEngine(t *testing.T, refuse bool) (*httptest.Server, func() []compressedRequest) {
+	var mu sync.Mutex
+	var seen []compressedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.Header.Get("Content-Encoding")
+		var body io.Reader = r.Body
+		if encoding == "gzip" && !refuse {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = gz
+		}
+		raw, err := io.ReadAll(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		seen = append(seen, compressedRequest{encoding: encoding, size: len(raw)})
+		mu.Unlock()
+		if encoding == "gzip" && refuse {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		var req PolicyRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		var out io.Writer = w
+		if r.Header.Get("Accept-Encoding") == "gzip" {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			out = gz
+		}
+		_, _ = io.WriteString(out, `{"result": true}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []compressedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]compressedRequest(nil), seen...)
+	}
+}
+
+// largeData is request data whose encoding is over size bytes
+func largeData(size int) map[string]interface{} {
+	return map[string]interface{}{"blob": strings.Repeat("x", size)}
+}
+
+// TestCompression tests that bodies over the threshold are gzipped, smaller
+// ones are not, and gzipped responses are decoded
+func TestCompression(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"buffered": {WithCompressionThreshold(1 << 10)},
+		"streamed": {WithCompressionThreshold(1 << 10), WithStreamingThreshold(512)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			engine, seen := newGzipEngine(t, false)
+			c, err := New(engine.URL, opts...)
+			require.NoError(t, err)
+			ctx := context.Background()
+
+			response, err := c.Evaluate(ctx, PolicyRequest{Rule: "rule", Data: largeData(4 << 10)})
+			require.NoError(t, err)
+			assert.True(t, response.Result)
+			response, err = c.Evaluate(ctx, PolicyRequest{Rule: "rule", Data: largeData(10)})
+			require.NoError(t, err)
+			assert.True(t, response.Result)
+
+			requests := seen()
+			require.Len(t, requests, 2)
+			assert.Equal(t, "gzip", requests[0].encoding)
+			assert.Greater(t, requests[0].size, 4<<10)
+			assert.Empty(t, requests[1].encoding, "small bodies are sent as they are")
+		})
+	}
+}
+
+// TestCompressionOff tests that bodies are not compressed by default
+func TestCompressionOff(t *testing.T) {
+	engine, seen := newGzipEngine(t, false)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+	_, err = c.Evaluate(context.Background(), PolicyRequest{Rule: "rule", Data: largeData(1 << 20)})
+	require.NoError(t, err)
+	require.Len(t, seen(), 1)
+	assert.Empty(t, seen()[0].encoding)
+}
+
+// TestCompressionRefused tests that a 415 has the body sent again
+// uncompressed, once, and the client stops compressing
+func TestCompressionRefused(t *testing.T) {
+	engine, seen := newGzipEngine(t, true)
+	c, err := New(engine.URL, WithCompressionThreshold(1<<10))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		response, err := c.Evaluate(ctx, PolicyRequest{Rule: "rule", Data: largeData(4 << 10)})
+		require.NoError(t, err)
+		assert.True(t, response.Result)
+	}
+	requests := seen()
+	require.Len(t, requests, 3)
+	assert.Equal(t, "gzip", requests[0].encoding)
+	assert.Empty(t, requests[1].encoding)
+	assert.Empty(t, requests[2].encoding, "later bodies are not compressed")
+}
+
+// TestCompressedResponseMalformed tests that a response claiming gzip that is
+// not fails as malformed
+func TestCompressedResponseMalformed(t *testing.T) {
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = io.WriteString(w, `{"result": true}`)
+	}))
+	defer engine.Close()
+	c, err := New(engine.URL, WithCompression())
+	require.NoError(t, err)
+	_, err = c.Evaluate(context.Background(), PolicyRequest{Rule: "rule", Data: map[string]interface{}{}})
+	assert.ErrorIs(t, err, ErrMalformedResponse)
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"policy-engine-testcontainer-example/policydata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tenantKey struct{}
+
+// TestConcurrentUse shares one client, with every feature turned on, between
+// goroutines calling all of its methods at once. It finds nothing without
+// -race.
+func TestConcurrentUse(t *testing.T) {
+	primary, replica := newFakeEngine(t), newFakeEngine(t)
+	aliases, err := policydata.NewAliasRegistry(policydata.Aliases{"Customer": {"Tier": "membership_level"}})
+	require.NoError(t, err)
+
+	c, err := New(primary.URL,
+		WithBaseData(map[string]interface{}{"Customer": map[string]interface{}{"Region": "eu"}}),
+		WithDataTransforms(policydata.DropFields("Customer.ssn")),
+		WithAliases(aliases),
+		WithKeyNormalization(policydata.NormalizationConfig{Style: policydata.SnakeCase, Aliases: map[string]string{"Lvl": "level"}}),
+		WithContextData(func(ctx context.Context) (map[string]interface{}, error) {
+			tenant, _ := ctx.Value(tenantKey{}).(string)
+			return map[string]interface{}{"Tenant": map[string]interface{}{"id": tenant}}, nil
+		}),
+		WithHedging(20*time.Millisecond, 1),
+		WithAdaptiveTimeout(AdaptiveConfig{Percentile: 0.99, Multiplier: 3, Min: 100 * time.Millisecond, Max: time.Second, MinSamples: 5}),
+		WithEndpoints(replica.URL),
+		WithBalancer(P2C),
+		WithHealthProbes(5*time.Millisecond),
+		WithCoalescing(),
+		WithStrictDecoding(),
+		WithStreamingThreshold(256),
+		WithWarmup(2),
+	)
+	require.NoError(t, err)
+
+	data := func(g, i int) map[string]interface{} {
+		return map[string]interface{}{
+			"Customer": map[string]interface{}{"Tier": "gold", "ssn": "123", "Lvl": i % 3},
+			"Order":    map[string]interface{}{"id": fmt.Sprintf("%d-%d", g, i)},
+		}
+	}
+	prepared, err := c.Prepare("prepared")
+	require.NoError(t, err)
+
+	const goroutines, iterations = 8, 20
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			ctx := context.WithValue(context.Background(), tenantKey{}, fmt.Sprint(g))
+			tenant, err := c.Clone(WithBaseData(map[string]interface{}{"Tenant": map[string]interface{}{"plan": g}}))
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			for i := 0; i < iterations; i++ {
+				_, err := c.EvaluatePolicy(ctx, "rule", data(g, i), i%2 == 0)
+				assert.NoError(t, err)
+				_, err = tenant.EvaluatePolicy(ctx, "rule", data(g, i), false)
+				assert.NoError(t, err)
+				_, err = prepared.Evaluate(ctx, data(g, i))
+				assert.NoError(t, err)
+
+				results, err := c.EvaluateBatch(ctx, "batch", []interface{}{data(g, i), data(g, i), data(g, 0)}, WithDeduplication(), WithTraces())
+				assert.NoError(t, err)
+				assert.NoError(t, results.err(nil))
+
+				for _, err := range c.EvaluateStream(ctx, "stream", func(yield func(interface{}, error) bool) {
+					_ = yield(data(g, i), nil) && yield(data(g, i+1), nil)
+				}) {
+					assert.NoError(t, err)
+				}
+
+				_, err = c.EvaluatePolicySet(ctx, []PolicyStep{
+					{Name: "first", Rule: "first"},
+					{Name: "second", Rule: "second", Data: func(previous []*PolicyResponse) (interface{}, error) {
+						return map[string]interface{}{"previous": previous[0].Result}, nil
+					}},
+				}, WithBudget(time.Second))
+				assert.NoError(t, err)
+
+				assert.NoError(t, aliases.Register(policydata.Aliases{"Order": {fmt.Sprintf("Field%d", i): fmt.Sprintf("field_%d", i)}}))
+				_ = c.AdaptiveTimeout("rule")
+				_ = c.EndpointStats()
+				_ = c.ConnectionStrategy()
+				_ = c.CoalescedEvaluations()
+				if i%5 == 0 {
+					assert.NoError(t, c.Warmup(ctx))
+					assert.NoError(t, c.Health(ctx))
+				}
+			}
+			assert.NoError(t, tenant.Close())
+		}(g)
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, c.Shutdown(ctx))
+	assert.NotEmpty(t, primary.Requests())
+}
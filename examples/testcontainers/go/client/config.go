@@ -0,0 +1,426 @@
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a client's settings as kept in a file, e.g.
+//
+//	base_url: ${POLICY_ENGINE_URL}
+//	failure_policy: closed
+//	adaptive_timeout: {percentile: 0.99, multiplier: 3, min: 50ms, max: 2s}
+//	cache: {ttl: 30s, stale_ttl: 5m}
+//	audit: {path: /var/log/policy/audit.ndjson}
+//
+// See ConfigFromFile for how it is read and FromConfig for how it is
+// applied. Zero fields leave the client's defaults.
+type Config struct {
+	BaseURL string `yaml:"base_url"`
+	// Endpoints are engines balanced with BaseURL, see WithEndpoints
+	Endpoints []string `yaml:"endpoints,omitempty"`
+	// Balancer is "failover", "round-robin" or "p2c"
+	Balancer            string        `yaml:"balancer,omitempty"`
+	HealthProbeInterval time.Duration `yaml:"health_probe_interval,omitempty"`
+	// ConnectionStrategy is "auto", "http1-pool" or "http2-single"
+	ConnectionStrategy string         `yaml:"connection_strategy,omitempty"`
+	TLS                *TLSFileConfig `yaml:"tls,omitempty"`
+
+	AdaptiveTimeout *AdaptiveConfig `yaml:"adaptive_timeout,omitempty"`
+	Hedging         *HedgingConfig  `yaml:"hedging,omitempty"`
+	Retry           *RetryConfig    `yaml:"retry,omitempty"`
+	Cache           *CacheConfig    `yaml:"cache,omitempty"`
+	// FailurePolicy is "error", the default, "closed" or "open"
+	FailurePolicy string       `yaml:"failure_policy,omitempty"`
+	Audit         *AuditConfig `yaml:"audit,omitempty"`
+
+	// Container is read by the test harness's WithConfigFile, which starts
+	// the engine and ignores BaseURL; the client never looks at it
+	Container *ContainerConfig `yaml:"container,omitempty"`
+}
+
+// TLSFileConfig names the files of a TLS configuration
+type TLSFileConfig struct {
+	// CAFile is a PEM bundle of the authorities to trust instead of the
+	// system's
+	CAFile string `yaml:"ca_file,omitempty"`
+	// CertFile and KeyFile are a client certificate, given together
+	CertFile   string `yaml:"cert_file,omitempty"`
+	KeyFile    string `yaml:"key_file,omitempty"`
+	ServerName string `yaml:"server_name,omitempty"`
+}
+
+// HedgingConfig is WithHedging's settings
+type HedgingConfig struct {
+	Delay     time.Duration `yaml:"delay"`
+	MaxHedges int           `yaml:"max_hedges"`
+}
+
+// RetryConfig is WithRetry's settings
+type RetryConfig struct {
+	MaxRetries int           `yaml:"max_retries"`
+	BaseDelay  time.Duration `yaml:"base_delay"`
+}
+
+// CacheConfig caches decisions in memory, see WithCache
+type CacheConfig struct {
+	TTL         time.Duration `yaml:"ttl"`
+	NegativeTTL time.Duration `yaml:"negative_ttl,omitempty"`
+	// StaleTTL serves expired entries while they refresh, see
+	// StaleWhileRevalidate, with at most MaxRefreshes at once
+	StaleTTL     time.Duration `yaml:"stale_ttl,omitempty"`
+	MaxRefreshes int           `yaml:"max_refreshes,omitempty"`
+	// MaxEntries bounds the cache to its most recently used entries, see
+	// NewLRUCache
+	MaxEntries int `yaml:"max_entries,omitempty"`
+}
+
+// AuditConfig appends an audit record of every evaluation to a file as JSON
+// lines, see WithAuditSink
+type AuditConfig struct {
+	Path string `yaml:"path"`
+}
+
+// ContainerConfig is how a test starts the engine
+type ContainerConfig struct {
+	Image          string            `yaml:"image,omitempty"`
+	Env            map[string]string `yaml:"env,omitempty"`
+	Port           string            `yaml:"port,omitempty"`
+	StartupTimeout time.Duration     `yaml:"startup_timeout,omitempty"`
+}
+
+// envReference is ${NAME} or ${NAME:-default}
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// ConfigFromFile reads a Config from a YAML file, or a JSON one if path ends
+// in .json. A key Config does not have fails the read. Values may refer to
+// the environment as ${NAME}, or ${NAME:-default} for a variable that may
+// be unset or empty; any other unset variable fails the read. The
+// environment enters only through such references, so the file says which
+// of its values the environment may set, and FromConfig's explicit options
+// override both. The Config is not validated; FromConfig does that.
+func ConfigFromFile(path string) (Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read config: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		// JSON is read as YAML, after making it YAML that has no tabs
+		var doc interface{}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return Config{}, fmt.Errorf("failed to decode config %s: %w", path, err)
+		}
+		if raw, err = yaml.Marshal(doc); err != nil {
+			return Config{}, fmt.Errorf("failed to decode config %s: %w", path, err)
+		}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return Config{}, fmt.Errorf("failed to decode config %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return Config{}, nil
+	}
+	var unset []string
+	expandNode(&doc, &unset)
+	if len(unset) > 0 {
+		return Config{}, fmt.Errorf("failed to decode config %s: environment variables %s are not set", path, strings.Join(unset, ", "))
+	}
+	if raw, err = yaml.Marshal(&doc); err != nil {
+		return Config{}, fmt.Errorf("failed to decode config %s: %w", path, err)
+	}
+
+	var cfg Config
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && err != io.EOF {
+		return Config{}, fmt.Errorf("failed to decode config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// expandNode replaces the environment references in the scalars under n,
+// adding the names of unset variables to unset
+func expandNode(n *yaml.Node, unset *[]string) {
+	if n.Kind == yaml.ScalarNode && strings.Contains(n.Value, "${") {
+		n.Value = envReference.ReplaceAllStringFunc(n.Value, func(ref string) string {
+			match := envReference.FindStringSubmatch(ref)
+			if value := os.Getenv(match[1]); value != "" {
+				return value
+			}
+			if match[2] != "" {
+				return match[3]
+			}
+			if _, ok := os.LookupEnv(match[1]); !ok {
+				*unset = append(*unset, match[1])
+			}
+			return ""
+		})
+		if n.Style == 0 {
+			// Let the value be read as whatever it now looks like, e.g. a
+			// number, rather than the string the reference was
+			n.Tag = ""
+		}
+	}
+	for _, child := range n.Content {
+		expandNode(child, unset)
+	}
+}
+
+// ConfigProblem is one invalid Config value, at a path such as
+// "cache.ttl" or "endpoints[1]"
+type ConfigProblem struct {
+	Path    string
+	Message string
+}
+
+// ConfigError lists every problem Validate found
+type ConfigError struct {
+	Problems []ConfigProblem
+}
+
+func (e *ConfigError) Error() string {
+	parts := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		parts[i] = p.Path + ": " + p.Message
+	}
+	return "invalid config: " + strings.Join(parts, "; ")
+}
+
+var (
+	balancerNames = map[string]BalancerPolicy{"failover": Failover, "round-robin": RoundRobin, "p2c": P2C}
+	strategyNames = map[string]ConnectionStrategy{"auto": Auto, "http1-pool": HTTP1Pool, "http2-single": HTTP2Single}
+	failureNames  = map[string]FailurePolicy{"error": FailWithError, "closed": FailClosed, "open": FailOpen}
+)
+
+// Validate reports every invalid value in cfg as a *ConfigError, or nil
+func (cfg Config) Validate() error {
+	var problems []ConfigProblem
+	add := func(path, format string, args ...interface{}) {
+		problems = append(problems, ConfigProblem{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	checkURL := func(path, value string) {
+		if _, err := url.ParseRequestURI(value); err != nil {
+			add(path, "%q is not a URL", value)
+		}
+	}
+	oneOf := func(path, value string, names []string) {
+		for _, name := range names {
+			if value == name {
+				return
+			}
+		}
+		add(path, "%q is not one of %s", value, strings.Join(names, ", "))
+	}
+
+	if cfg.BaseURL == "" {
+		add("base_url", "is required")
+	} else {
+		checkURL("base_url", cfg.BaseURL)
+	}
+	for i, endpoint := range cfg.Endpoints {
+		checkURL(fmt.Sprintf("endpoints[%d]", i), endpoint)
+	}
+	if cfg.Balancer != "" {
+		oneOf("balancer", cfg.Balancer, sortedNames(balancerNames))
+	}
+	if cfg.HealthProbeInterval < 0 {
+		add("health_probe_interval", "must not be negative")
+	}
+	if cfg.ConnectionStrategy != "" {
+		oneOf("connection_strategy", cfg.ConnectionStrategy, sortedNames(strategyNames))
+	}
+	if t := cfg.TLS; t != nil && (t.CertFile == "") != (t.KeyFile == "") {
+		add("tls", "cert_file and key_file must be given together")
+	}
+
+	if a := cfg.AdaptiveTimeout; a != nil {
+		if a.Percentile <= 0 || a.Percentile > 1 {
+			add("adaptive_timeout.percentile", "must be above 0 and at most 1")
+		}
+		if a.Multiplier <= 0 {
+			add("adaptive_timeout.multiplier", "must be positive")
+		}
+		if a.Max > 0 && a.Min > a.Max {
+			add("adaptive_timeout", "min %s is above max %s", a.Min, a.Max)
+		}
+	}
+	if h := cfg.Hedging; h != nil {
+		if h.Delay <= 0 {
+			add("hedging.delay", "must be positive")
+		}
+		if h.MaxHedges <= 0 {
+			add("hedging.max_hedges", "must be positive")
+		}
+	}
+	if r := cfg.Retry; r != nil {
+		if r.MaxRetries <= 0 {
+			add("retry.max_retries", "must be positive")
+		}
+		if r.BaseDelay <= 0 {
+			add("retry.base_delay", "must be positive")
+		}
+	}
+	if c := cfg.Cache; c != nil {
+		if c.TTL <= 0 {
+			add("cache.ttl", "must be positive")
+		}
+		if c.NegativeTTL < 0 {
+			add("cache.negative_ttl", "must not be negative")
+		}
+		if c.StaleTTL < 0 {
+			add("cache.stale_ttl", "must not be negative")
+		}
+		if c.MaxRefreshes < 0 {
+			add("cache.max_refreshes", "must not be negative")
+		}
+		if c.MaxEntries < 0 {
+			add("cache.max_entries", "must not be negative")
+		}
+	}
+	if cfg.FailurePolicy != "" {
+		oneOf("failure_policy", cfg.FailurePolicy, sortedNames(failureNames))
+	}
+	if cfg.Audit != nil && cfg.Audit.Path == "" {
+		add("audit.path", "is required")
+	}
+	if c := cfg.Container; c != nil && c.StartupTimeout < 0 {
+		add("container.startup_timeout", "must not be negative")
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+func sortedNames[T any](names map[string]T) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// FromConfig validates cfg and creates a client from it. opts are applied
+// after the options cfg stands for, so an explicit option overrides the
+// config: the precedence is the file, then the environment its ${NAME}
+// references read, then opts. An audit file the config names is opened for
+// appending and closed with the client.
+func FromConfig(cfg Config, opts ...Option) (*PolicyClient, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	configured, audit, err := cfg.options()
+	if err != nil {
+		return nil, err
+	}
+	c, err := New(cfg.BaseURL, append(configured, opts...)...)
+	if err != nil {
+		if audit != nil {
+			audit.Close()
+		}
+		return nil, err
+	}
+	if audit != nil {
+		c.closers = append(c.closers, audit)
+	}
+	return c, nil
+}
+
+// options returns the options cfg stands for, and the audit file they write
+// to, if any
+func (cfg Config) options() ([]Option, io.Closer, error) {
+	var opts []Option
+	if len(cfg.Endpoints) > 0 {
+		opts = append(opts, WithEndpoints(cfg.Endpoints...))
+	}
+	if cfg.Balancer != "" {
+		opts = append(opts, WithBalancer(balancerNames[cfg.Balancer]))
+	}
+	if cfg.HealthProbeInterval > 0 {
+		opts = append(opts, WithHealthProbes(cfg.HealthProbeInterval))
+	}
+	if cfg.ConnectionStrategy != "" {
+		opts = append(opts, WithConnectionStrategy(strategyNames[cfg.ConnectionStrategy]))
+	}
+	if cfg.TLS != nil {
+		tlsConfig, err := cfg.TLS.load()
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, WithTLSConfig(tlsConfig))
+	}
+	if cfg.AdaptiveTimeout != nil {
+		opts = append(opts, WithAdaptiveTimeout(*cfg.AdaptiveTimeout))
+	}
+	if h := cfg.Hedging; h != nil {
+		opts = append(opts, WithHedging(h.Delay, h.MaxHedges))
+	}
+	if r := cfg.Retry; r != nil {
+		opts = append(opts, WithRetry(r.MaxRetries, r.BaseDelay))
+	}
+	if c := cfg.Cache; c != nil {
+		opts = append(opts, WithCache(NewLRUCache(c.MaxEntries), c.TTL))
+		if c.NegativeTTL > 0 {
+			opts = append(opts, WithNegativeCaching(c.NegativeTTL))
+		}
+		if c.StaleTTL > 0 {
+			mode := StaleWhileRevalidate(c.StaleTTL)
+			if c.MaxRefreshes > 0 {
+				mode = mode.MaxRefreshes(c.MaxRefreshes)
+			}
+			opts = append(opts, WithCacheMode(mode))
+		}
+	}
+	if cfg.FailurePolicy != "" {
+		opts = append(opts, WithFailurePolicy(failureNames[cfg.FailurePolicy]))
+	}
+	if cfg.Audit == nil {
+		return opts, nil, nil
+	}
+	f, err := os.OpenFile(cfg.Audit.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	return append(opts, WithAuditSink(NewJSONAuditSink(f))), f, nil
+}
+
+// load reads the files into a TLS configuration
+func (t *TLSFileConfig) load() (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: t.ServerName}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("failed to read CA file: no PEM certificates in " + t.CAFile)
+		}
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConfigFromFile tests reading minimal and full configs, in YAML and
+// JSON, with their environment references expanded
+func TestConfigFromFile(t *testing.T) {
+	cfg, err := ConfigFromFile("testdata/config/minimal.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, Config{BaseURL: "http://localhost:3000"}, cfg)
+	require.NoError(t, cfg.Validate())
+
+	t.Setenv("POLICY_ENGINE_URL", "http://engine.internal:3000")
+	t.Setenv("AUDIT_DIR", "/var/log/policy")
+	t.Setenv("ENGINE_PORT", "")
+	want := Config{
+		BaseURL:             "http://engine.internal:3000",
+		Endpoints:           []string{"http://replica.internal:3000"},
+		Balancer:            "round-robin",
+		HealthProbeInterval: 10 * time.Second,
+		ConnectionStrategy:  "http1-pool",
+		TLS:                 &TLSFileConfig{ServerName: "engine.internal"},
+		AdaptiveTimeout: &AdaptiveConfig{
+			Percentile: 0.99, Multiplier: 3, Min: 50 * time.Millisecond, Max: 2 * time.Second, MinSamples: 10,
+		},
+		Hedging:       &HedgingConfig{Delay: 100 * time.Millisecond, MaxHedges: 1},
+		Retry:         &RetryConfig{MaxRetries: 3, BaseDelay: 200 * time.Millisecond},
+		Cache:         &CacheConfig{TTL: 30 * time.Second, NegativeTTL: 5 * time.Second, StaleTTL: 5 * time.Minute, MaxRefreshes: 2},
+		FailurePolicy: "closed",
+		Audit:         &AuditConfig{Path: "/var/log/policy/audit.ndjson"},
+		Container: &ContainerConfig{
+			Image:          "policy-engine:1.4.2",
+			Port:           "3000/tcp",
+			StartupTimeout: 30 * time.Second,
+			Env:            map[string]string{"RUST_LOG": "debug"},
+		},
+	}
+	for _, file := range []string{"full.yaml", "full.json"} {
+		t.Run(file, func(t *testing.T) {
+			cfg, err := ConfigFromFile(filepath.Join("testdata/config", file))
+			require.NoError(t, err)
+			assert.Equal(t, want, cfg)
+			assert.NoError(t, cfg.Validate())
+		})
+	}
+
+	t.Setenv("POLICY_ENGINE_REPLICA", "http://other:3000")
+	cfg, err = ConfigFromFile("testdata/config/full.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://other:3000"}, cfg.Endpoints, "a set variable beats its default")
+}
+
+// TestConfigFromFileErrors tests that unknown keys and unset variables fail
+// the read
+func TestConfigFromFileErrors(t *testing.T) {
+	_, err := ConfigFromFile("testdata/config/unknown.yaml")
+	assert.ErrorContains(t, err, "field retries not found")
+
+	dir := t.TempDir()
+	unknownJSON := filepath.Join(dir, "unknown.json")
+	require.NoError(t, os.WriteFile(unknownJSON, []byte(`{"base_url": "http://x", "cache": {"ttl": "1s", "size": 10}}`), 0o644))
+	_, err = ConfigFromFile(unknownJSON)
+	assert.ErrorContains(t, err, "field size not found")
+
+	_, err = ConfigFromFile("testdata/config/full.yaml")
+	assert.ErrorContains(t, err, "environment variables POLICY_ENGINE_URL, AUDIT_DIR are not set")
+
+	_, err = ConfigFromFile(filepath.Join(dir, "missing.yaml"))
+	assert.ErrorContains(t, err, "failed to read config")
+}
+
+// TestConfigValidate tests that every problem is reported at its path
+func TestConfigValidate(t *testing.T) {
+	cfg, err := ConfigFromFile("testdata/config/invalid.yaml")
+	require.NoError(t, err)
+
+	err = cfg.Validate()
+	var configErr *ConfigError
+	require.ErrorAs(t, err, &configErr)
+	paths := map[string]string{}
+	for _, problem := range configErr.Problems {
+		paths[problem.Path] = problem.Message
+	}
+	assert.Equal(t, map[string]string{
+		"base_url":                    `"not a url" is not a URL`,
+		"endpoints[1]":                `":bad" is not a URL`,
+		"balancer":                    `"random" is not one of failover, p2c, round-robin`,
+		"connection_strategy":         `"http3" is not one of auto, http1-pool, http2-single`,
+		"tls":                         "cert_file and key_file must be given together",
+		"adaptive_timeout.percentile": "must be above 0 and at most 1",
+		"adaptive_timeout":            "min 2s is above max 1s",
+		"retry.max_retries":           "must be positive",
+		"cache.ttl":                   "must be positive",
+		"failure_policy":              `"sometimes" is not one of closed, error, open`,
+		"audit.path":                  "is required",
+	}, paths)
+	assert.Contains(t, err.Error(), "invalid config: base_url: ")
+
+	_, err = FromConfig(cfg)
+	assert.ErrorAs(t, err, &configErr, "FromConfig validates")
+}
+
+// TestFromConfig tests that the config's options apply, that explicit
+// options override them, and that the audit file is closed with the client
+func TestFromConfig(t *testing.T) {
+	engine := newFakeEngine(t)
+	dir := t.TempDir()
+	config := filepath.Join(dir, "policy.yaml")
+	require.NoError(t, os.WriteFile(config, []byte(`
+base_url: ${POLICY_ENGINE_URL}
+failure_policy: open
+cache: {ttl: 1m}
+audit: {path: `+filepath.Join(dir, "audit.ndjson")+`}
+`), 0o644))
+
+	// The environment beats what the file would say without it
+	t.Setenv("POLICY_ENGINE_URL", engine.URL)
+	cfg, err := ConfigFromFile(config)
+	require.NoError(t, err)
+	c, err := FromConfig(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, engine.URL, c.BaseURL())
+
+	for i := 0; i < 2; i++ {
+		_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+		require.NoError(t, err)
+	}
+	assert.Len(t, engine.Requests(), 1, "the second call is cached")
+	assert.Equal(t, FailOpen, c.failure)
+	require.NoError(t, c.Close())
+	audit, err := os.ReadFile(filepath.Join(dir, "audit.ndjson"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, countLines(audit))
+
+	// Explicit options beat the config
+	c, err = FromConfig(cfg, WithFailurePolicy(FailClosed), WithAuditSink(&auditRecords{}))
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, FailClosed, c.failure)
+	_, err = c.EvaluatePolicy(context.Background(), "other rule", map[string]interface{}{}, false)
+	require.NoError(t, err)
+	audit, err = os.ReadFile(filepath.Join(dir, "audit.ndjson"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, countLines(audit), "the explicit sink replaces the config's")
+
+	cfg.TLS = &TLSFileConfig{CAFile: filepath.Join(dir, "missing.pem")}
+	_, err = FromConfig(cfg)
+	assert.ErrorContains(t, err, "failed to read CA file")
+}
+
+func countLines(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if c == '\n' {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,153 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// http1PoolSize is how many idle keep-alive connections HTTP1Pool and Auto
+// keep to the engine; the transport default of two would otherwise close most
+// connections a concurrent workload opens
+const http1PoolSize = 64
+
+// ConnectionStrategy selects how requests share connections to the engine
+type ConnectionStrategy int
+
+const (
+	// Auto speaks HTTP/2 when the engine offers it during the TLS handshake
+	// and HTTP/1.1 over a keep-alive pool otherwise; Warmup records which one
+	// was picked, see PolicyClient.ConnectionStrategy
+	Auto ConnectionStrategy = iota
+	// HTTP1Pool always speaks HTTP/1.1, one request per connection at a time,
+	// over a pool of keep-alive connections
+	HTTP1Pool
+	// HTTP2Single multiplexes every request over one HTTP/2 connection. The
+	// standard library only speaks HTTP/2 over TLS, so it needs an https
+	// base URL.
+	HTTP2Single
+)
+
+func (s ConnectionStrategy) String() string {
+	switch s {
+	case Auto:
+		return "auto"
+	case HTTP1Pool:
+		return "http1-pool"
+	case HTTP2Single:
+		return "http2-single"
+	default:
+		return "unknown"
+	}
+}
+
+// errHTTP2NeedsTLS is returned by New for HTTP2Single with a plain http URL
+var errHTTP2NeedsTLS = errors.New("HTTP2Single needs an https base URL: HTTP/2 is only negotiated over TLS")
+
+// WithConnectionStrategy sets how requests share connections; the default is
+// Auto
+func WithConnectionStrategy(strategy ConnectionStrategy) Option {
+	return func(c *PolicyClient) {
+		c.connStrategy = strategy
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used to reach an https engine, for
+// example to trust a private certificate authority
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *PolicyClient) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithHTTPClient sends requests with a copy of client, keeping its timeout,
+// redirect policy and cookie jar, e.g. one whose transport dials through a
+// proxy. Evaluations, health checks and probes share it and so its
+// connection pool. A transport that is an *http.Transport is cloned before
+// WithTLSConfig and WithConnectionStrategy apply to it, leaving client's own
+// alone; any other transport is used as it is.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *PolicyClient) {
+		c.customHTTPClient = client
+	}
+}
+
+// ConnectionStrategy returns the strategy in use. For Auto it reports the
+// protocol Warmup found the engine speaking, and Auto until a warm-up has
+// completed.
+func (c *PolicyClient) ConnectionStrategy() ConnectionStrategy {
+	return ConnectionStrategy(c.connectionOwner().resolvedStrategy.Load())
+}
+
+// connectionOwner is the client whose connections this one uses: itself, or
+// the client it was cloned from
+func (c *PolicyClient) connectionOwner() *PolicyClient {
+	if c.parent != nil {
+		return c.parent
+	}
+	return c
+}
+
+// configureConnections sets the transport up for the chosen strategy
+func (c *PolicyClient) configureConnections() error {
+	c.resolvedStrategy.Store(int32(c.connStrategy))
+	if c.customHTTPClient != nil {
+		custom := *c.customHTTPClient
+		switch transport := custom.Transport.(type) {
+		case nil:
+			custom.Transport = http.DefaultTransport.(*http.Transport).Clone()
+		case *http.Transport:
+			custom.Transport = transport.Clone()
+		}
+		c.httpClient = &custom
+	}
+	transport, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok {
+		return nil
+	}
+	if c.tlsConfig != nil {
+		transport.TLSClientConfig = c.tlsConfig.Clone()
+	}
+
+	switch c.connStrategy {
+	case HTTP1Pool:
+		// A non-nil, empty map turns HTTP/2 off
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		growIdlePool(transport, http1PoolSize)
+	case HTTP2Single:
+		if !strings.HasPrefix(c.baseURL, "https://") {
+			return errHTTP2NeedsTLS
+		}
+		// HTTP/2 opens further connections only once the engine's stream
+		// limit is reached
+		transport.ForceAttemptHTTP2 = true
+	default:
+		transport.ForceAttemptHTTP2 = true
+		growIdlePool(transport, http1PoolSize)
+	}
+	return nil
+}
+
+// observeProtocol resolves Auto from a response's protocol
+func (c *PolicyClient) observeProtocol(resp *http.Response) {
+	if c.connStrategy != Auto {
+		return
+	}
+	strategy := HTTP1Pool
+	if resp.ProtoMajor == 2 {
+		strategy = HTTP2Single
+	}
+	c.connectionOwner().resolvedStrategy.Store(int32(strategy))
+}
+
+// growIdlePool lets the transport keep at least n idle connections to a host
+func growIdlePool(transport *http.Transport, n int) {
+	if transport.MaxIdleConnsPerHost < n {
+		transport.MaxIdleConnsPerHost = n
+	}
+	if transport.MaxIdleConns != 0 && transport.MaxIdleConns < n {
+		transport.MaxIdleConns = n
+	}
+}
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// protoEngine is a fake engine that records which protocol each evaluation
+// arrived over and how many connections it accepted
+type protoEngine struct {
+	*httptest.Server
+
+	conns  int64
+	mu     sync.Mutex
+	protos map[string]int
+}
+
+func newProtoEngine(t *testing.T, tlsMode string) *protoEngine {
+	t.Helper()
+
+	engine := &protoEngine{protos: map[string]int{}}
+	engine.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if r.URL.Path == "/health" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		engine.mu.Lock()
+		engine.protos[r.Proto]++
+		engine.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"result":true,"rule":["rule"],"data":null}`)
+	}))
+	engine.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&engine.conns, 1)
+		}
+	}
+	switch tlsMode {
+	case "h2":
+		engine.EnableHTTP2 = true
+		engine.StartTLS()
+	case "http/1.1":
+		engine.StartTLS()
+	default:
+		engine.Start()
+	}
+	t.Cleanup(engine.Close)
+
+	return engine
+}
+
+func (e *protoEngine) Protos() map[string]int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	protos := make(map[string]int, len(e.protos))
+	for proto, n := range e.protos {
+		protos[proto] = n
+	}
+	return protos
+}
+
+// trusting returns a TLS config trusting the engine's test certificate
+func (e *protoEngine) trusting() *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(e.Certificate())
+	return &tls.Config{RootCAs: pool}
+}
+
+// evaluateConcurrently runs n evaluations at once
+func evaluateConcurrently(t *testing.T, c *PolicyClient, n int) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.EvaluatePolicy(context.Background(), "rule", nil, false)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+}
+
+// TestAutoStrategy tests that warm-up resolves Auto from the protocol the engine negotiates
+func TestAutoStrategy(t *testing.T) {
+	for _, tc := range []struct {
+		tlsMode string
+		want    ConnectionStrategy
+		proto   string
+	}{
+		{"h2", HTTP2Single, "HTTP/2.0"},
+		{"http/1.1", HTTP1Pool, "HTTP/1.1"},
+		{"none", HTTP1Pool, "HTTP/1.1"},
+	} {
+		t.Run(tc.tlsMode, func(t *testing.T) {
+			engine := newProtoEngine(t, tc.tlsMode)
+			var opts []Option
+			if tc.tlsMode != "none" {
+				opts = append(opts, WithTLSConfig(engine.trusting()))
+			}
+			c, err := New(engine.URL, opts...)
+			require.NoError(t, err)
+			defer c.Close()
+
+			assert.Equal(t, Auto, c.ConnectionStrategy(), "unresolved before warm-up")
+			require.NoError(t, c.Warmup(context.Background()))
+			assert.Equal(t, tc.want, c.ConnectionStrategy())
+
+			evaluateConcurrently(t, c, 8)
+			assert.Equal(t, map[string]int{tc.proto: 8}, engine.Protos())
+		})
+	}
+}
+
+// TestHTTP1PoolStrategy tests that HTTP/1.1 is used even when the engine offers HTTP/2, over reused connections
+func TestHTTP1PoolStrategy(t *testing.T) {
+	engine := newProtoEngine(t, "h2")
+	c, err := New(engine.URL, WithConnectionStrategy(HTTP1Pool), WithTLSConfig(engine.trusting()))
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.Warmup(context.Background()))
+	assert.Equal(t, HTTP1Pool, c.ConnectionStrategy())
+	for i := 0; i < 3; i++ {
+		evaluateConcurrently(t, c, 16)
+	}
+
+	assert.Equal(t, map[string]int{"HTTP/1.1": 48}, engine.Protos())
+	// The pool keeps the first round's connections for the later ones
+	assert.LessOrEqual(t, atomic.LoadInt64(&engine.conns), int64(17))
+}
+
+// TestHTTP2SingleStrategy tests that concurrent evaluations are multiplexed over one connection
+func TestHTTP2SingleStrategy(t *testing.T) {
+	engine := newProtoEngine(t, "h2")
+	c, err := New(engine.URL, WithConnectionStrategy(HTTP2Single), WithTLSConfig(engine.trusting()))
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.Warmup(context.Background()))
+	evaluateConcurrently(t, c, 32)
+
+	assert.Equal(t, map[string]int{"HTTP/2.0": 32}, engine.Protos())
+	assert.Equal(t, int64(1), atomic.LoadInt64(&engine.conns))
+}
+
+// TestHTTP2SingleWithoutHTTP2 tests the engines HTTP2Single can't talk to
+func TestHTTP2SingleWithoutHTTP2(t *testing.T) {
+	plain := newProtoEngine(t, "none")
+	_, err := New(plain.URL, WithConnectionStrategy(HTTP2Single))
+	assert.ErrorIs(t, err, errHTTP2NeedsTLS)
+
+	http1 := newProtoEngine(t, "http/1.1")
+	c, err := New(http1.URL, WithConnectionStrategy(HTTP2Single), WithTLSConfig(http1.trusting()))
+	require.NoError(t, err)
+	defer c.Close()
+	assert.ErrorContains(t, c.Warmup(context.Background()), "engine answered over HTTP/1.1, not HTTP/2")
+}
+
+// TestHTTPClient tests that a given client carries evaluations and health
+// checks over one pool, and is left as it was given
+func TestHTTPClient(t *testing.T) {
+	engine := newProtoEngine(t, "http/1.1")
+	given := engine.Client()
+	transport := given.Transport.(*http.Transport)
+	idle := transport.MaxIdleConnsPerHost
+	c, err := New(engine.URL, WithHTTPClient(given), WithConnectionStrategy(HTTP1Pool))
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.Health(ctx))
+		_, err := c.EvaluatePolicy(ctx, "rule", nil, false)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&engine.conns), "health checks and evaluations share a connection")
+	assert.Same(t, transport, given.Transport)
+	assert.Equal(t, idle, transport.MaxIdleConnsPerHost, "the given transport is not tuned")
+
+	_, err = c.Clone(WithHTTPClient(http.DefaultClient))
+	assert.ErrorIs(t, err, errCloneConnections)
+}
+
+// TestTLSConfigWrongCA tests that an engine whose certificate the client does
+// not trust fails the handshake
+func TestTLSConfigWrongCA(t *testing.T) {
+	engine := newProtoEngine(t, "http/1.1")
+	c, err := New(engine.URL, WithTLSConfig(&tls.Config{RootCAs: x509.NewCertPool()}))
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Health(context.Background())
+	var unknown x509.UnknownAuthorityError
+	assert.ErrorAs(t, err, &unknown)
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"policy-engine-testcontainer-example/policydata"
+)
+
+// ContextEntity is the reserved top-level key ambient context data is sent
+// under, so rules can refer to it as **Context**
+const ContextEntity = "Context"
+
+// ContextDataFunc returns the ambient facts for an evaluation, such as the
+// environment or project, from the context the evaluation runs under
+type ContextDataFunc func(ctx context.Context) (map[string]interface{}, error)
+
+// ContextConflict selects what happens when the caller's data already holds a
+// field of the ambient context entity
+type ContextConflict int
+
+const (
+	// ContextRejectConflicts fails the call with a *ContextConflictError
+	ContextRejectConflicts ContextConflict = iota
+	// ContextPreferCaller keeps the caller's value
+	ContextPreferCaller
+	// ContextPreferAmbient replaces the caller's value with the ambient one
+	ContextPreferAmbient
+)
+
+// WithContextData merges an ambient entity into every request's data under
+// ContextEntity. The entity always carries "now", the current date from the
+// client's clock in the engine's YYYY-MM-DD form, so a rule can compare
+// against the __now__ of the **Context**; fields returned by fn are added
+// alongside it and may replace it. fn may be nil to send only the date.
+func WithContextData(fn ContextDataFunc) Option {
+	return func(c *PolicyClient) {
+		c.contextData = fn
+		c.injectContext = true
+	}
+}
+
+// WithContextConflict sets how fields supplied both by the caller and by the
+// ambient context are resolved; the default is ContextRejectConflicts
+func WithContextConflict(policy ContextConflict) Option {
+	return func(c *PolicyClient) {
+		c.contextConflict = policy
+	}
+}
+
+// WithClock replaces time.Now as the source of the ambient "now", so tests can
+// pin the evaluation date
+func WithClock(now func() time.Time) Option {
+	return func(c *PolicyClient) {
+		c.now = now
+	}
+}
+
+// ambientData builds the ambient document for one evaluation
+func (c *PolicyClient) ambientData(ctx context.Context) (map[string]interface{}, error) {
+	now, ok := EvaluationTimeFromContext(ctx)
+	if !ok {
+		now = c.now()
+	}
+	fields := map[string]interface{}{
+		"now": now.UTC().Format("2006-01-02"),
+	}
+	if c.contextData != nil {
+		var extra map[string]interface{}
+		err := callback("context data", func() (err error) {
+			extra, err = c.contextData(ctx)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range extra {
+			fields[key] = value
+		}
+	}
+
+	ambient := map[string]interface{}{ContextEntity: fields}
+	if c.normalization != nil {
+		return policydata.Normalize(ambient, *c.normalization)
+	}
+	return ambient, nil
+}
+
+// mergeContext merges the ambient document into data according to the
+// client's conflict policy
+func (c *PolicyClient) mergeContext(data interface{}, ambient map[string]interface{}) (map[string]interface{}, error) {
+	switch c.contextConflict {
+	case ContextPreferCaller:
+		return policydata.Merge(ambient, data)
+	case ContextPreferAmbient:
+		return policydata.Merge(data, ambient)
+	}
+
+	doc, err := policydata.Merge(data, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range ambient {
+		existing, ok := doc[key].(map[string]interface{})
+		if !ok {
+			if _, exists := doc[key]; exists {
+				return nil, &ContextConflictError{Key: key}
+			}
+			continue
+		}
+
+		var fields []string
+		for field := range value.(map[string]interface{}) {
+			if _, exists := existing[field]; exists {
+				fields = append(fields, field)
+			}
+		}
+		if len(fields) > 0 {
+			sort.Strings(fields)
+			return nil, &ContextConflictError{Key: key, Fields: fields}
+		}
+	}
+	return policydata.Merge(doc, ambient)
+}
+
+// injectContextData adds the ambient entity to the prepared data
+func (c *PolicyClient) injectContextData(ctx context.Context, data interface{}) (interface{}, error) {
+	ambient, err := c.ambientData(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build context data: %w", err)
+	}
+	merged, err := c.mergeContext(data, ambient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge context data: %w", err)
+	}
+	return merged, nil
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"policy-engine-testcontainer-example/policydata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type projectKey struct{}
+
+func fixedClock() time.Time {
+	return time.Date(2024, 3, 15, 23, 30, 0, 0, time.FixedZone("EST", -5*60*60))
+}
+
+// ambientProject reads the project from the request context
+func ambientProject(ctx context.Context) (map[string]interface{}, error) {
+	project, _ := ctx.Value(projectKey{}).(string)
+	return map[string]interface{}{"environment": "prod", "project_id": project}, nil
+}
+
+// TestWithContextData tests that the ambient entity is merged into every payload
+func TestWithContextData(t *testing.T) {
+	engine := newFakeEngine(t)
+
+	c, err := New(engine.URL, WithContextData(ambientProject), WithClock(fixedClock))
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), projectKey{}, "proj-1")
+	_, err = c.EvaluatePolicy(ctx, "rule", map[string]interface{}{"Person": map[string]interface{}{"age": 70}}, false)
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(ctx, "rule", nil, false)
+	require.NoError(t, err)
+
+	ambient := map[string]interface{}{
+		// The clock is read in UTC, which is already the next day here
+		"now":         "2024-03-16",
+		"environment": "prod",
+		"project_id":  "proj-1",
+	}
+	requests := engine.Requests()
+	require.Len(t, requests, 2)
+	assert.Equal(t, map[string]interface{}{
+		"Person":  map[string]interface{}{"age": float64(70)},
+		"Context": ambient,
+	}, requests[0].Data)
+	assert.Equal(t, map[string]interface{}{"Context": ambient}, requests[1].Data)
+}
+
+// TestWithContextDataClockOnly tests that a nil function still sends the injected date
+func TestWithContextDataClockOnly(t *testing.T) {
+	engine := newFakeEngine(t)
+
+	day := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c, err := New(engine.URL, WithContextData(nil), WithClock(func() time.Time { return day }))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = c.EvaluatePolicy(context.Background(), "rule", nil, false)
+		require.NoError(t, err)
+		day = day.AddDate(0, 0, 1)
+	}
+
+	requests := engine.Requests()
+	require.Len(t, requests, 2)
+	assert.Equal(t, map[string]interface{}{"Context": map[string]interface{}{"now": "2024-01-01"}}, requests[0].Data)
+	assert.Equal(t, map[string]interface{}{"Context": map[string]interface{}{"now": "2024-01-02"}}, requests[1].Data)
+}
+
+// TestWithContextConflict tests each policy for fields the caller also supplies
+func TestWithContextConflict(t *testing.T) {
+	data := map[string]interface{}{
+		"Context": map[string]interface{}{"environment": "staging", "region": "eu"},
+	}
+
+	cases := []struct {
+		name   string
+		policy ContextConflict
+		want   map[string]interface{}
+	}{
+		{"prefer caller", ContextPreferCaller, map[string]interface{}{
+			"now": "2024-03-16", "environment": "staging", "project_id": "", "region": "eu",
+		}},
+		{"prefer ambient", ContextPreferAmbient, map[string]interface{}{
+			"now": "2024-03-16", "environment": "prod", "project_id": "", "region": "eu",
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := newFakeEngine(t)
+			c, err := New(engine.URL, WithContextData(ambientProject), WithClock(fixedClock), WithContextConflict(tc.policy))
+			require.NoError(t, err)
+
+			_, err = c.EvaluatePolicy(context.Background(), "rule", data, false)
+			require.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"Context": tc.want}, engine.Requests()[0].Data)
+		})
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		engine := newFakeEngine(t)
+		c, err := New(engine.URL, WithContextData(ambientProject), WithClock(fixedClock))
+		require.NoError(t, err)
+
+		_, err = c.EvaluatePolicy(context.Background(), "rule", data, false)
+		var conflict *ContextConflictError
+		require.True(t, errors.As(err, &conflict), "expected ContextConflictError, got %v", err)
+		assert.Equal(t, "Context", conflict.Key)
+		assert.Equal(t, []string{"environment"}, conflict.Fields)
+
+		_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{"Context": "prod"}, false)
+		require.True(t, errors.As(err, &conflict))
+		assert.Empty(t, conflict.Fields)
+
+		// Fields that do not overlap merge without complaint
+		_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{
+			"Context": map[string]interface{}{"region": "eu"},
+		}, false)
+		require.NoError(t, err)
+		assert.Len(t, engine.Requests(), 1)
+	})
+}
+
+// TestWithContextDataError tests that a failing context function stops the call
+func TestWithContextDataError(t *testing.T) {
+	engine := newFakeEngine(t)
+
+	failure := errors.New("no project in context")
+	c, err := New(engine.URL, WithContextData(func(context.Context) (map[string]interface{}, error) {
+		return nil, failure
+	}))
+	require.NoError(t, err)
+
+	_, err = c.EvaluatePolicy(context.Background(), "rule", nil, false)
+	assert.ErrorIs(t, err, failure)
+	assert.Empty(t, engine.Requests())
+}
+
+// TestWithContextDataNormalized tests that ambient keys follow the configured key style
+func TestWithContextDataNormalized(t *testing.T) {
+	engine := newFakeEngine(t)
+
+	c, err := New(engine.URL,
+		WithKeyNormalization(policydata.NormalizationConfig{Style: policydata.SnakeCase}),
+		WithContextData(func(context.Context) (map[string]interface{}, error) {
+			return map[string]interface{}{"ProjectID": "proj-1"}, nil
+		}),
+		WithClock(fixedClock),
+	)
+	require.NoError(t, err)
+
+	_, err = c.EvaluatePolicy(context.Background(), "rule", nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"Context": map[string]interface{}{"now": "2024-03-16", "project_id": "proj-1"},
+	}, engine.Requests()[0].Data)
+}
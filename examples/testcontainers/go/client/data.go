@@ -0,0 +1,189 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"policy-engine-testcontainer-example/policydata"
+)
+
+// Request data may be supplied in several forms, each with a fixed encoding:
+//
+//   - nil is sent as null
+//   - maps, structs and other Go values are encoded once, exactly as
+//     json.Marshal would encode them
+//   - json.RawMessage and []byte must hold valid JSON and are embedded
+//     verbatim, whitespace included (a []byte is never base64-encoded); they
+//     are rejected if any object in them repeats a key, unless
+//     AllowDuplicateKeys is set
+//   - an io.Reader is streamed into the request as-is; it must yield a single
+//     JSON value, and the request can only be replayed if it is an io.Seeker
+//
+// Channels, functions and complex numbers have no JSON form and are rejected
+// with an *UnsupportedDataError before anything is sent.
+
+// rawJSON is request data that is already encoded and validated
+type rawJSON []byte
+
+// readerData is request data streamed from a reader
+type readerData struct {
+	r io.Reader
+
+	mu     sync.Mutex
+	start  int64
+	opened bool
+}
+
+// open returns the reader positioned at the start of the data
+func (d *readerData) open() (io.Reader, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.opened {
+		d.opened = true
+		return d.r, nil
+	}
+
+	seeker, ok := d.r.(io.Seeker)
+	if !ok {
+		return nil, errReaderNotReplayable
+	}
+	if _, err := seeker.Seek(d.start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind data reader: %w", err)
+	}
+	return d.r, nil
+}
+
+var errReaderNotReplayable = errors.New("request data reader has already been consumed and cannot be replayed")
+
+// classifyData converts caller-supplied data into the form the request body
+// writes; decode forces raw JSON and readers into generic values so the data
+// pipeline (merging, normalisation, transforms) can work on them, and
+// checkDuplicates rejects raw JSON that repeats a key
+func classifyData(data interface{}, decode, checkDuplicates bool) (interface{}, error) {
+	switch value := data.(type) {
+	case nil:
+		return nil, nil
+	case json.RawMessage:
+		return classifyRaw(value, decode, checkDuplicates)
+	case []byte:
+		return classifyRaw(value, decode, checkDuplicates)
+	case io.Reader:
+		if decode {
+			return decodeGeneric(value)
+		}
+		data := &readerData{r: value}
+		if seeker, ok := value.(io.Seeker); ok {
+			if offset, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+				data.start = offset
+			}
+		}
+		return data, nil
+	}
+
+	switch reflect.TypeOf(data).Kind() {
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return nil, &UnsupportedDataError{Type: reflect.TypeOf(data)}
+	}
+	return data, nil
+}
+
+func classifyRaw(raw []byte, decode, checkDuplicates bool) (interface{}, error) {
+	if !json.Valid(raw) {
+		return nil, &InvalidDataError{Reason: "raw data is not valid JSON"}
+	}
+	if checkDuplicates {
+		if err := policydata.CheckDuplicateKeys(raw); err != nil {
+			return nil, &InvalidDataError{Reason: "raw data repeats keys", Err: err}
+		}
+	}
+	if decode {
+		return decodeGeneric(bytes.NewReader(raw))
+	}
+	return rawJSON(raw), nil
+}
+
+// decodeGeneric decodes a single JSON value, keeping numbers as json.Number
+func decodeGeneric(r io.Reader) (interface{}, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, &InvalidDataError{Reason: "data is not valid JSON", Err: err}
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, &InvalidDataError{Reason: "data holds more than one JSON value"}
+	}
+	return value, nil
+}
+
+// flattenData encodes values policydata.Encode can't walk element by element,
+// such as structs and typed slices, once up front, so sizing and sending the
+// body reuse those bytes instead of marshalling the whole value on each pass.
+// In canonical mode everything but reader data is encoded up front.
+func flattenData(data interface{}, opts bodyOptions) (interface{}, error) {
+	if opts.canonical {
+		return canonicalData(data)
+	}
+	switch data.(type) {
+	case nil, rawJSON, *readerData, map[string]interface{}, []interface{}, string, bool, int, int64, float64:
+		return data, nil
+	}
+
+	encoded, err := policydata.EncodeBytes(data, policydata.EncodeConfig{EscapeHTML: opts.escapeHTML})
+	if err != nil {
+		return nil, dataError(err)
+	}
+	return rawJSON(encoded), nil
+}
+
+// canonicalData encodes data in policydata.CanonicalJSON form. Reader data is
+// left to stream as it is, since canonicalizing it would mean holding it all.
+func canonicalData(data interface{}) (interface{}, error) {
+	switch value := data.(type) {
+	case *readerData:
+		return data, nil
+	case rawJSON:
+		data = json.RawMessage(value)
+	}
+	encoded, err := policydata.CanonicalJSON(data)
+	if err != nil {
+		return nil, dataError(err)
+	}
+	return rawJSON(encoded), nil
+}
+
+// writeData writes the data segment of a request body
+func writeData(w *bufio.Writer, data interface{}, escapeHTML bool) error {
+	switch value := data.(type) {
+	case rawJSON:
+		_, err := w.Write(value)
+		return err
+	case *readerData:
+		r, err := value.open()
+		if err != nil {
+			return err
+		}
+		_, err = w.ReadFrom(r)
+		return err
+	default:
+		return dataError(policydata.EncodeWith(w, value, policydata.EncodeConfig{EscapeHTML: escapeHTML}))
+	}
+}
+
+// dataError reports values encoding/json has no form for as an
+// *UnsupportedDataError
+func dataError(err error) error {
+	var unsupported *json.UnsupportedTypeError
+	if errors.As(err, &unsupported) {
+		return &UnsupportedDataError{Type: unsupported.Type}
+	}
+	return err
+}
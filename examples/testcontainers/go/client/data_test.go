@@ -0,0 +1,169 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"policy-engine-testcontainer-example/policydata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// onlyReader hides any Seek method so the reader cannot be replayed
+type onlyReader struct {
+	r io.Reader
+}
+
+func (o onlyReader) Read(p []byte) (int, error) {
+	return o.r.Read(p)
+}
+
+// TestDataInputWireBytes tests the exact bytes sent for every supported input form
+func TestDataInputWireBytes(t *testing.T) {
+	type person struct {
+		Age  int    `json:"age"`
+		Name string `json:"name"`
+	}
+
+	cases := []struct {
+		name string
+		data interface{}
+		want string
+	}{
+		{"nil", nil, `null`},
+		{"map", map[string]interface{}{"Person": map[string]interface{}{"name": "Pat", "age": 70}}, `{"Person":{"age":70,"name":"Pat"}}`},
+		{"struct", map[string]interface{}{"Person": person{Age: 70, Name: "Pat"}}, `{"Person":{"age":70,"name":"Pat"}}`},
+		{"struct pointer", &person{Age: 70, Name: "Pat"}, `{"age":70,"name":"Pat"}`},
+		// Previously double-encoded; raw JSON is now embedded verbatim, whitespace included
+		{"raw message", json.RawMessage(`{"Person": {"age": 70}}`), `{"Person": {"age": 70}}`},
+		{"byte slice", []byte(`{"Person":{"age":70}}`), `{"Person":{"age":70}}`},
+		{"reader", strings.NewReader(`{"Person":{"age":70}}`), `{"Person":{"age":70}}`},
+		{"non-seekable reader", onlyReader{strings.NewReader(`{"Person": {"age": 70}}`)}, `{"Person": {"age": 70}}`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := newFakeEngine(t)
+			c, err := New(engine.URL)
+			require.NoError(t, err)
+
+			_, err = c.EvaluatePolicy(context.Background(), "rule", tc.data, false)
+			require.NoError(t, err)
+
+			bodies := engine.Bodies()
+			require.Len(t, bodies, 1)
+			assert.Equal(t, `{"rule":"rule","data":`+tc.want+`}`, string(bodies[0]))
+		})
+	}
+}
+
+// TestRawDataWithPipeline tests that raw inputs are decoded when the data pipeline needs them
+func TestRawDataWithPipeline(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL, WithBaseData(map[string]interface{}{"Customer": map[string]interface{}{"country": "GB"}}))
+	require.NoError(t, err)
+
+	inputs := []interface{}{
+		json.RawMessage(`{"Order": {"total": 150}}`),
+		[]byte(`{"Order": {"total": 150}}`),
+		strings.NewReader(`{"Order": {"total": 150}}`),
+	}
+	for _, data := range inputs {
+		_, err = c.EvaluatePolicy(context.Background(), "rule", data, false)
+		require.NoError(t, err)
+	}
+
+	for _, body := range engine.Bodies() {
+		assert.Equal(t, `{"rule":"rule","data":{"Customer":{"country":"GB"},"Order":{"total":150}}}`, string(body))
+	}
+}
+
+// TestInvalidData tests that malformed raw JSON and unsupported kinds fail before sending
+func TestInvalidData(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	for _, data := range []interface{}{json.RawMessage(`{"age": `), []byte(`not json`)} {
+		_, err = c.EvaluatePolicy(context.Background(), "rule", data, false)
+		var invalid *InvalidDataError
+		assert.True(t, errors.As(err, &invalid), "expected InvalidDataError, got %v", err)
+	}
+
+	for _, data := range []interface{}{make(chan int), func() {}, complex(1, 2), map[string]interface{}{"nested": make(chan int)}} {
+		_, err = c.EvaluatePolicy(context.Background(), "rule", data, false)
+		var unsupported *UnsupportedDataError
+		assert.True(t, errors.As(err, &unsupported), "expected UnsupportedDataError for %T, got %v", data, err)
+	}
+
+	assert.Empty(t, engine.Requests())
+}
+
+// TestDuplicateKeys tests that raw data repeating a key is rejected unless allowed
+func TestDuplicateKeys(t *testing.T) {
+	engine := newFakeEngine(t)
+	raw := `{"Person": {"age": 30, "age": 70}}`
+
+	for _, opts := range [][]Option{nil, {WithBaseData(map[string]interface{}{"Order": map[string]interface{}{}})}} {
+		c, err := New(engine.URL, opts...)
+		require.NoError(t, err)
+
+		for _, data := range []interface{}{json.RawMessage(raw), []byte(raw)} {
+			_, err = c.EvaluatePolicy(context.Background(), "rule", data, false)
+			var invalid *InvalidDataError
+			require.ErrorAs(t, err, &invalid)
+			var dupErr *policydata.DuplicateKeyError
+			require.ErrorAs(t, err, &dupErr)
+			assert.Equal(t, []string{"/Person/age"}, dupErr.Pointers)
+			assert.EqualError(t, err, "invalid request data: raw data repeats keys: policydata: duplicate keys at /Person/age")
+		}
+	}
+	assert.Empty(t, engine.Requests())
+
+	// Opting out sends the bytes as they are
+	c, err := New(engine.URL, AllowDuplicateKeys())
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(context.Background(), "rule", json.RawMessage(raw), false)
+	require.NoError(t, err)
+	bodies := engine.Bodies()
+	require.Len(t, bodies, 1)
+	assert.Equal(t, `{"rule":"rule","data":`+raw+`}`, string(bodies[0]))
+}
+
+// TestReaderReplay tests that seekable readers replay after a partial write and others refuse
+func TestReaderReplay(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+	transport := &partialWriteTransport{next: c.httpClient.Transport}
+	c.httpClient.Transport = transport
+
+	payload := `{"Order":{"note":"` + strings.Repeat("x", 10_000) + `"}}`
+	_, err = c.EvaluatePolicy(context.Background(), "rule", bytes.NewReader([]byte(payload)), false)
+	require.NoError(t, err)
+	assert.Equal(t, `{"rule":"rule","data":`+payload+`}`, string(engine.Bodies()[0]))
+
+	_, err = c.EvaluatePolicy(context.Background(), "rule", onlyReader{strings.NewReader(payload)}, false)
+	assert.ErrorIs(t, err, errReaderNotReplayable)
+}
+
+// TestReaderMaxRequestBytes tests that reader data is cut off once it crosses the limit
+func TestReaderMaxRequestBytes(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL, WithMaxRequestBytes(1024))
+	require.NoError(t, err)
+
+	payload := `{"Order":{"note":"` + strings.Repeat("x", 100_000) + `"}}`
+	_, err = c.EvaluatePolicy(context.Background(), "rule", strings.NewReader(payload), false)
+
+	var tooLarge *RequestTooLargeError
+	require.True(t, errors.As(err, &tooLarge), "expected RequestTooLargeError, got %v", err)
+	assert.Equal(t, int64(1024), tooLarge.Limit)
+	assert.Empty(t, engine.Requests())
+}
@@ -0,0 +1,246 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WithDebugLogging logs every HTTP request the client sends to logger, as
+// it goes out on the wire, so each retry or hedge is a request of its own.
+// At info level a record gives the method, URL, the headers with credentials
+// redacted (see RedactHeaders), the hash of the rule sent (see RuleHash), the
+// request body's size, and the response's status or the error, with how long
+// it took. When logger is enabled for debug level a second record holds both
+// bodies, decompressed and pretty-printed, with the fields WithRedactFields
+// names masked.
+//
+// Logging reads the request body a second time, and at debug level reads
+// the response whole before it is decoded, so it is for debugging rather
+// than for production traffic.
+func WithDebugLogging(logger *slog.Logger) Option {
+	return func(c *PolicyClient) {
+		c.debugLogger = logger
+	}
+}
+
+// WithRedactFields masks the values of the named keys, matched regardless
+// of case, wherever they appear in the bodies WithDebugLogging logs: in the
+// data document at any depth, through nested objects and arrays, in the
+// data the engine echoes back, and in trace conditions reading a property
+// of that name. Several WithRedactFields options add up. With fields to
+// redact, a body that is not JSON is logged by its size only.
+func WithRedactFields(fields ...string) Option {
+	return func(c *PolicyClient) {
+		if c.redactFields == nil {
+			c.redactFields = map[string]bool{}
+		}
+		for _, field := range fields {
+			c.redactFields[strings.ToLower(field)] = true
+		}
+	}
+}
+
+// requestLogger logs each request a Doer sends, for WithDebugLogging and
+// DebugLogging
+type requestLogger struct {
+	logger *slog.Logger
+	// redactFields are the lowercased fields masked in logged bodies
+	redactFields map[string]bool
+}
+
+// logRequests is the middleware WithDebugLogging installs, innermost
+func (c *PolicyClient) logRequests(next Doer) Doer {
+	logger := &requestLogger{logger: c.debugLogger, redactFields: c.redactFields}
+	return logger.middleware(next)
+}
+
+// middleware logs each request next sends
+func (l *requestLogger) middleware(next Doer) Doer {
+	return DoerFunc(func(req *http.Request) (*http.Response, error) {
+		ctx := req.Context()
+		requestBody, hasBody := readRequestBody(req)
+		began := time.Now()
+		resp, err := next.Do(req)
+		elapsed := time.Since(began)
+
+		attrs := []slog.Attr{
+			slog.String("method", req.Method),
+			slog.String("url", req.URL.String()),
+		}
+		summary := append(attrs[:2:2], slog.String("headers", formatHeaders(RedactHeaders(req.Header))))
+		if hasBody {
+			if rule, ok := ruleOf(requestBody); ok {
+				summary = append(summary, slog.String("rule_hash", RuleHash(rule)))
+			}
+			summary = append(summary, slog.Int("request_bytes", len(requestBody)))
+		}
+		if err != nil {
+			summary = append(summary, slog.String("error", err.Error()))
+		} else {
+			summary = append(summary, slog.Int("status", resp.StatusCode))
+		}
+		summary = append(summary, slog.Duration("duration", elapsed))
+		l.logger.LogAttrs(ctx, slog.LevelInfo, "policy engine request", summary...)
+
+		if !l.logger.Enabled(ctx, slog.LevelDebug) {
+			return resp, err
+		}
+		if hasBody {
+			attrs = append(attrs, slog.String("request_body", l.formatBody(requestBody)))
+		}
+		if err == nil {
+			responseBody := teeResponseBody(resp)
+			attrs = append(attrs, slog.String("response_body", l.formatBody(responseBody)))
+		}
+		l.logger.LogAttrs(ctx, slog.LevelDebug, "policy engine request bodies", attrs...)
+		return resp, err
+	})
+}
+
+// readRequestBody reads a copy of req's body, decompressed, leaving the body
+// itself to be sent. It reports false for a request without a body, or one
+// whose body cannot be read again.
+func readRequestBody(req *http.Request) ([]byte, bool) {
+	if req.GetBody == nil {
+		return nil, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	defer body.Close()
+	raw, err := readDecoded(body, req.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, false
+	}
+	return raw, true
+}
+
+// teeResponseBody reads resp's body to log it, decompressed, and puts back
+// a body reading the same bytes for the client to decode. Should the read
+// fail, the client's read fails too, as it would have without logging.
+func teeResponseBody(resp *http.Response) []byte {
+	raw, _ := io.ReadAll(resp.Body)
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(raw), resp.Body), resp.Body}
+	if !strings.EqualFold(strings.TrimSpace(resp.Header.Get("Content-Encoding")), "gzip") {
+		return raw
+	}
+	decoded, err := readDecoded(bytes.NewReader(raw), "gzip")
+	if err != nil {
+		return nil
+	}
+	return decoded
+}
+
+// readDecoded reads r, decompressing it if encoding is gzip
+func readDecoded(r io.Reader, encoding string) ([]byte, error) {
+	if strings.EqualFold(strings.TrimSpace(encoding), "gzip") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return io.ReadAll(r)
+}
+
+// ruleOf returns the rule text of a JSON request body, if it has one, its
+// rules joined if it has them one by one
+func ruleOf(body []byte) (string, bool) {
+	var request struct {
+		Rule  *string  `json:"rule"`
+		Rules []string `json:"rules"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return "", false
+	}
+	switch {
+	case request.Rule != nil:
+		return *request.Rule, true
+	case request.Rules != nil:
+		return JoinRules(request.Rules...), true
+	}
+	return "", false
+}
+
+// formatBody is body as a debug log shows it: JSON pretty-printed with the
+// redacted fields masked, or as it is when it is not JSON
+func (l *requestLogger) formatBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil || decoder.More() {
+		if len(l.redactFields) > 0 {
+			return fmt.Sprintf("[%d bytes, not JSON]", len(body))
+		}
+		return string(body)
+	}
+	pretty, err := json.MarshalIndent(l.redact(document), "", "  ")
+	if err != nil {
+		return fmt.Sprintf("[%d bytes]", len(body))
+	}
+	return string(pretty)
+}
+
+// redact returns a copy of v with the values of the redacted fields, at any
+// depth, replaced by Redacted. A trace condition reading a redacted
+// property has the value it read masked, in the property and in the
+// comparison's details.
+func (l *requestLogger) redact(v interface{}) interface{} {
+	if len(l.redactFields) == 0 {
+		return v
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			if l.redactFields[strings.ToLower(key)] {
+				out[key] = Redacted
+			} else {
+				out[key] = l.redact(value)
+			}
+		}
+		if property, ok := out["property"].(map[string]interface{}); ok {
+			if path, ok := property["path"].(string); ok && l.redactFields[strings.ToLower(lastPathField(path))] {
+				property["value"] = Redacted
+				if details, ok := out["evaluation_details"].(map[string]interface{}); ok {
+					if left, ok := details["left_value"].(map[string]interface{}); ok {
+						left["value"] = Redacted
+					}
+				}
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, value := range v {
+			out[i] = l.redact(value)
+		}
+		return out
+	}
+	return v
+}
+
+// lastPathField is the last field of a trace path, e.g. ssn for
+// $.Person.ssn or $.Person.ssn[0]
+func lastPathField(path string) string {
+	field := path[strings.LastIndex(path, ".")+1:]
+	if i := strings.Index(field, "["); i >= 0 {
+		field = field[:i]
+	}
+	return field
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// secrets are the values debugData hides under redacted fields
+var secrets = []string{"123-45-6789", "hunter2", "s3cr3t", "987-65-4321"}
+
+// debugData nests fields to redact in objects and arrays, one spelled in
+// capitals
+func debugData() map[string]interface{} {
+	return map[string]interface{}{
+		"Person": map[string]interface{}{
+			"name": "Ada",
+			"ssn":  "123-45-6789",
+			"accounts": []interface{}{
+				map[string]interface{}{"id": "a1", "password": "hunter2"},
+				map[string]interface{}{"id": "a2", "Password": "s3cr3t"},
+			},
+			"dependants": []interface{}{
+				[]interface{}{map[string]interface{}{"SSN": "987-65-4321"}},
+			},
+		},
+	}
+}
+
+// newRedactionEngine is an engine that answers every evaluation with its data
+// and a trace of a condition reading $.Person.ssn, gzipping either way when
+// the client does
+func newRedactionEngine(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = gz
+		}
+		var req PolicyRequest
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ssn := req.Data.(map[string]interface{})["Person"].(map[string]interface{})["ssn"]
+		response := map[string]interface{}{
+			"result": true,
+			"rule":   []string{req.Rule},
+			"data":   req.Data,
+			"trace": map[string]interface{}{"conditions": []interface{}{map[string]interface{}{
+				"selector": map[string]interface{}{"value": "Person"},
+				"property": map[string]interface{}{"value": ssn, "path": "$.Person.ssn"},
+				"operator": "EqualTo",
+				"evaluation_details": map[string]interface{}{
+					"left_value":  map[string]interface{}{"value": ssn, "type": "string"},
+					"right_value": map[string]interface{}{"value": "000-00-0000", "type": "string"},
+				},
+				"result": false,
+			}}},
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		var out io.Writer = w
+		if r.Header.Get("Accept-Encoding") == "gzip" {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			out = gz
+		}
+		_ = json.NewEncoder(out).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// logRecords decodes the JSON log lines in buf, failing t if any holds one
+// of the secrets
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(buf)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		for _, secret := range secrets {
+			assert.NotContains(t, line, secret)
+		}
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+// TestDebugLogging tests the records logged for an evaluation at debug level,
+// plain and gzipped, and that no redacted value appears in any of them
+func TestDebugLogging(t *testing.T) {
+	const rule = `A **Person** passes if the __ssn__ of the **Person** is equal to "000-00-0000".`
+	for name, opts := range map[string][]Option{
+		"plain":      nil,
+		"compressed": {WithCompressionThreshold(1)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			engine := newRedactionEngine(t)
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			c, err := New(engine.URL, append(opts, WithDebugLogging(logger), WithRedactFields("ssn", "password"))...)
+			require.NoError(t, err)
+
+			response, err := c.Evaluate(context.Background(), PolicyRequest{Rule: rule, Data: debugData(), Trace: true})
+			require.NoError(t, err)
+			assert.True(t, response.Result)
+			person := response.Data.(map[string]interface{})["Person"].(map[string]interface{})
+			assert.Equal(t, "123-45-6789", person["ssn"], "the response itself is not redacted")
+
+			records := logRecords(t, &buf)
+			require.Len(t, records, 2)
+			summary, bodies := records[0], records[1]
+			assert.Equal(t, "INFO", summary["level"])
+			assert.Equal(t, "POST", summary["method"])
+			assert.Equal(t, engine.URL, summary["url"])
+			assert.Equal(t, RuleHash(rule), summary["rule_hash"])
+			assert.Greater(t, summary["request_bytes"], 100.0)
+			assert.EqualValues(t, http.StatusOK, summary["status"])
+			assert.Contains(t, summary, "duration")
+
+			assert.Equal(t, "DEBUG", bodies["level"])
+			for _, key := range []string{"request_body", "response_body"} {
+				body := bodies[key].(string)
+				assert.Contains(t, body, "\n  ", "%s is pretty-printed", key)
+				assert.Contains(t, body, `"name": "Ada"`)
+				assert.Contains(t, body, `"id": "a2"`)
+				assert.Equal(t, 4, strings.Count(body, Redacted)-strings.Count(body, `"value": "`+Redacted), key)
+			}
+			assert.Equal(t, 2, strings.Count(bodies["response_body"].(string), `"value": "`+Redacted),
+				"the traced property and its comparison")
+			assert.Contains(t, bodies["response_body"], "000-00-0000")
+		})
+	}
+}
+
+// TestDebugLoggingInfo tests that a logger above debug level gets only the
+// summary, and bodies are not read
+func TestDebugLoggingInfo(t *testing.T) {
+	engine := newRedactionEngine(t)
+	var buf bytes.Buffer
+	c, err := New(engine.URL, WithDebugLogging(slog.New(slog.NewJSONHandler(&buf, nil))))
+	require.NoError(t, err)
+
+	response, err := c.Evaluate(context.Background(), PolicyRequest{Rule: "rule", Data: debugData()})
+	require.NoError(t, err)
+	assert.True(t, response.Result)
+	records := logRecords(t, &buf)
+	require.Len(t, records, 1)
+	assert.Equal(t, RuleHash("rule"), records[0]["rule_hash"])
+	assert.NotContains(t, records[0], "request_body")
+}
+
+// TestRedact tests masking fields at any depth, leaving the value redacted
+// unchanged
+func TestRedact(t *testing.T) {
+	c, err := New("http://engine", WithRedactFields("SSN"), WithRedactFields("password"))
+	require.NoError(t, err)
+	data := debugData()
+	logger := &requestLogger{redactFields: c.redactFields}
+	redacted := logger.redact(data).(map[string]interface{})
+
+	person := redacted["Person"].(map[string]interface{})
+	assert.Equal(t, Redacted, person["ssn"])
+	assert.Equal(t, "Ada", person["name"])
+	accounts := person["accounts"].([]interface{})
+	assert.Equal(t, map[string]interface{}{"id": "a1", "password": Redacted}, accounts[0])
+	assert.Equal(t, map[string]interface{}{"id": "a2", "Password": Redacted}, accounts[1])
+	assert.Equal(t, []interface{}{map[string]interface{}{"SSN": Redacted}}, person["dependants"].([]interface{})[0])
+	assert.Equal(t, debugData(), data)
+
+	assert.Equal(t, "ssn", lastPathField("$.Person.ssn"))
+	assert.Equal(t, "ssn", lastPathField("$.People[0].ssn[1]"))
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DecisionIDHeader carries an evaluation's decision ID to the engine
+	DecisionIDHeader = "X-Decision-ID"
+	// DecisionAttemptHeader numbers the requests sent for one decision, from
+	// 1, so hedges and retries of it can be told apart
+	DecisionAttemptHeader = "X-Decision-Attempt"
+)
+
+// crockford is the Crockford base32 alphabet ULIDs are written in
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewDecisionID returns a new ULID: 26 characters that sort by creation time
+// to the millisecond, with 80 random bits after the timestamp
+func NewDecisionID() string {
+	return newDecisionID(time.Now())
+}
+
+func newDecisionID(now time.Time) string {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(now.UnixMilli())<<16)
+	if _, err := rand.Read(id[6:]); err != nil {
+		panic(fmt.Sprintf("failed to read random bits for a decision ID: %v", err))
+	}
+
+	// 128 bits take 26 characters of 5 bits, the first holding only 3
+	hi, lo := binary.BigEndian.Uint64(id[:8]), binary.BigEndian.Uint64(id[8:])
+	var encoded [26]byte
+	for i := range encoded {
+		shift := uint(125 - 5*i)
+		var bits uint64
+		switch {
+		case shift >= 64:
+			bits = hi >> (shift - 64)
+		case shift > 59:
+			bits = lo>>shift | hi<<(64-shift)
+		default:
+			bits = lo >> shift
+		}
+		encoded[i] = crockford[bits&31]
+	}
+	return string(encoded[:])
+}
+
+// decision is one logical evaluation: its ID and the requests sent for it
+type decision struct {
+	id       string
+	attempts atomic.Int32
+}
+
+type decisionKey struct{}
+
+// ContextWithDecisionID returns a copy of ctx under which an evaluation uses
+// id as its decision ID rather than generating one. It names one evaluation:
+// batches, streams and policy sets give each of theirs a new ID.
+func ContextWithDecisionID(ctx context.Context, id string) context.Context {
+	return withDecision(ctx, &decision{id: id})
+}
+
+// DecisionIDFromContext returns the decision ID ctx carries, if any
+func DecisionIDFromContext(ctx context.Context) (string, bool) {
+	if d := decisionFrom(ctx); d != nil {
+		return d.id, true
+	}
+	return "", false
+}
+
+func decisionFrom(ctx context.Context) *decision {
+	d, _ := ctx.Value(decisionKey{}).(*decision)
+	return d
+}
+
+// startDecision returns ctx carrying the decision of an evaluation about to
+// start: the one ctx already carries, or a new one
+func startDecision(ctx context.Context) (context.Context, *decision) {
+	if d := decisionFrom(ctx); d != nil {
+		return ctx, d
+	}
+	d := newDecision()
+	return withDecision(ctx, d), d
+}
+
+func newDecision() *decision {
+	return &decision{id: NewDecisionID()}
+}
+
+// withDecision returns ctx carrying d, replacing any decision it carries
+func withDecision(ctx context.Context, d *decision) context.Context {
+	return context.WithValue(ctx, decisionKey{}, d)
+}
+
+// setHeaders numbers a request sent for d and labels it with d's ID
+func (d *decision) setHeaders(header http.Header) {
+	header.Set(DecisionIDHeader, d.id)
+	header.Set(DecisionAttemptHeader, strconv.Itoa(int(d.attempts.Add(1))))
+}
+
+// decisionError attaches a decision ID to an evaluation's error without
+// changing its message
+type decisionError struct {
+	id  string
+	err error
+}
+
+func (e *decisionError) Error() string { return e.err.Error() }
+func (e *decisionError) Unwrap() error { return e.err }
+
+// withDecisionID attaches id to err, unless err is nil or has an ID already
+func withDecisionID(err error, id string) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := DecisionIDOf(err); ok {
+		return err
+	}
+	return &decisionError{id: id, err: err}
+}
+
+// DecisionIDOf returns the decision ID of the evaluation that failed with
+// err, which a response also carries as PolicyResponse.DecisionID
+func DecisionIDOf(err error) (string, bool) {
+	var decisionErr *decisionError
+	if errors.As(err, &decisionErr) {
+		return decisionErr.id, true
+	}
+	return "", false
+}
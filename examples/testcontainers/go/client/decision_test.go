@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"policy-engine-testcontainer-example/batch"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decisionEngine records the decision headers of each request and answers
+// with the status respond picks for the nth request, from 1
+type decisionEngine struct {
+	*httptest.Server
+	mu       sync.Mutex
+	received [][2]string
+	count    int64
+}
+
+func newDecisionEngine(t *testing.T, respond func(n int64, r *http.Request) int) *decisionEngine {
+	t.Helper()
+
+	engine := &decisionEngine{}
+	engine.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		engine.mu.Lock()
+		engine.received = append(engine.received, [2]string{r.Header.Get(DecisionIDHeader), r.Header.Get(DecisionAttemptHeader)})
+		engine.mu.Unlock()
+
+		switch status := respond(atomic.AddInt64(&engine.count, 1), r); status {
+		case http.StatusOK:
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"result":true,"rule":["rule"],"data":{}}`))
+		case http.StatusBadRequest:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"result":false,"error":"Parse error: unknown","rule":["rule"],"data":{}}`))
+		default:
+			w.WriteHeader(status)
+		}
+	}))
+	t.Cleanup(engine.Close)
+	return engine
+}
+
+// Received returns each request's decision ID and attempt number, in arrival
+// order
+func (e *decisionEngine) Received() [][2]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([][2]string(nil), e.received...)
+}
+
+// TestNewDecisionID tests that decision IDs are unique ULIDs ordered by time
+func TestNewDecisionID(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		id := NewDecisionID()
+		require.Len(t, id, 26)
+		for _, r := range id {
+			require.True(t, strings.ContainsRune(crockford, r), "%q in %s", r, id)
+		}
+		assert.False(t, seen[id], "duplicate %s", id)
+		seen[id] = true
+	}
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	earlier, later := newDecisionID(now), newDecisionID(now.Add(time.Millisecond))
+	assert.Less(t, earlier, later)
+	// The canonical ULID of the Unix epoch starts with ten zeros
+	assert.True(t, strings.HasPrefix(newDecisionID(time.UnixMilli(0)), "0000000000"))
+	assert.Equal(t, "7ZZZZZZZZZ", newDecisionID(time.UnixMilli(1<<48 - 1))[:10])
+}
+
+// TestDecisionID tests that an evaluation's ID reaches the engine, the
+// response and the error
+func TestDecisionID(t *testing.T) {
+	engine := newDecisionEngine(t, func(_ int64, r *http.Request) int {
+		var req PolicyRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Rule == "bad" {
+			return http.StatusBadRequest
+		}
+		return http.StatusOK
+	})
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	response, err := c.EvaluatePolicy(context.Background(), "rule", nil, false)
+	require.NoError(t, err)
+	require.Len(t, response.DecisionID, 26)
+	assert.Equal(t, [2]string{response.DecisionID, "1"}, engine.Received()[0])
+
+	ctx := ContextWithDecisionID(context.Background(), "order-42")
+	response, err = c.EvaluatePolicy(ctx, "rule", nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, "order-42", response.DecisionID)
+	assert.Equal(t, [2]string{"order-42", "1"}, engine.Received()[1])
+
+	response, err = c.EvaluatePolicy(context.Background(), "bad", nil, false)
+	require.Error(t, err)
+	assert.EqualError(t, err, "engine error: Parse error: unknown")
+	id, found := DecisionIDOf(err)
+	require.True(t, found)
+	assert.Equal(t, response.DecisionID, id)
+	assert.Equal(t, id, engine.Received()[2][0])
+
+	// Failures before anything is sent have an ID too
+	_, err = c.EvaluatePolicy(context.Background(), "rule", make(chan int), false)
+	require.Error(t, err)
+	_, found = DecisionIDOf(err)
+	assert.True(t, found)
+}
+
+// TestDecisionIDSharedByHedges tests that a hedge is another attempt at the
+// same decision
+func TestDecisionIDSharedByHedges(t *testing.T) {
+	engine := newDecisionEngine(t, func(n int64, r *http.Request) int {
+		if n == 1 {
+			// The connection is only watched for closing once the body is read
+			_, _ = io.Copy(io.Discard, r.Body)
+			<-r.Context().Done()
+		}
+		return http.StatusOK
+	})
+	c, err := New(engine.URL, WithHedging(10*time.Millisecond, 1))
+	require.NoError(t, err)
+
+	response, err := c.EvaluatePolicy(context.Background(), "rule", nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, [][2]string{{response.DecisionID, "1"}, {response.DecisionID, "2"}}, engine.Received())
+}
+
+// TestDecisionIDSharedByBatchRetries tests that batch items each have their
+// own ID, kept when backpressure or Retry sends them again
+func TestDecisionIDSharedByBatchRetries(t *testing.T) {
+	engine := newDecisionEngine(t, func(n int64, _ *http.Request) int {
+		switch n {
+		case 1:
+			return http.StatusTooManyRequests
+		case 2:
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	})
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	results, err := c.EvaluateBatch(context.Background(), "rule", []interface{}{nil},
+		WithBackpressure(batch.Adaptive{Backoff: time.Millisecond}))
+	require.Error(t, err)
+	id, found := DecisionIDOf(results[0].Err)
+	require.True(t, found)
+
+	retried, err := results.Retry(context.Background(), c)
+	require.NoError(t, err)
+	assert.Equal(t, id, retried[0].Response.DecisionID)
+	assert.Equal(t, [][2]string{{id, "1"}, {id, "2"}, {id, "3"}}, engine.Received())
+
+	results, err = c.EvaluateBatch(context.Background(), "rule", []interface{}{nil, nil},
+		WithBackpressure(batch.Adaptive{Backoff: time.Millisecond}))
+	require.NoError(t, err)
+	assert.NotEqual(t, results[0].Response.DecisionID, results[1].Response.DecisionID)
+}
@@ -0,0 +1,439 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestTooLargeError is returned when an encoded request exceeds the limit
+// set with WithMaxRequestBytes. Nothing has been sent when it is returned,
+// except for io.Reader data, which is only measured as it streams; Size is
+// then the number of bytes encoded when the limit was crossed.
+type RequestTooLargeError struct {
+	Size  int64
+	Limit int64
+}
+
+func (e *RequestTooLargeError) Error() string {
+	return fmt.Sprintf("request body is %d bytes, over the %d byte limit", e.Size, e.Limit)
+}
+
+// UnsupportedDataError is returned for request data with no JSON form, such as
+// channels or functions
+type UnsupportedDataError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedDataError) Error() string {
+	return fmt.Sprintf("unsupported request data type %s", e.Type)
+}
+
+// InvalidDataError is returned when raw request data is not valid JSON
+type InvalidDataError struct {
+	Reason string
+	Err    error
+}
+
+func (e *InvalidDataError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("invalid request data: %s: %v", e.Reason, e.Err)
+	}
+	return "invalid request data: " + e.Reason
+}
+
+func (e *InvalidDataError) Unwrap() error {
+	return e.Err
+}
+
+// ContextConflictError is returned when the caller's data already holds fields
+// of the ambient context entity and WithContextConflict has not chosen a side.
+// Fields is empty when the caller's value under Key is not an object at all.
+type ContextConflictError struct {
+	Key    string
+	Fields []string
+}
+
+func (e *ContextConflictError) Error() string {
+	if len(e.Fields) == 0 {
+		return fmt.Sprintf("request data already has a non-object %q entity", e.Key)
+	}
+	return fmt.Sprintf("request data already sets %s of the ambient %q entity", strings.Join(e.Fields, ", "), e.Key)
+}
+
+// OverloadedError is returned when the engine, or a proxy in front of it,
+// answers 429 Too Many Requests. RetryAfter is the wait the response asked
+// for through its Retry-After header, or zero if it gave none.
+type OverloadedError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *OverloadedError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("engine overloaded (status %d), retry after %s", e.StatusCode, e.RetryAfter)
+	}
+	return fmt.Sprintf("engine overloaded (status %d)", e.StatusCode)
+}
+
+// The kinds of ResponseBodyError
+var (
+	// ErrEmptyResponse is a response with no body at all, such as from an
+	// engine that crashed mid-request
+	ErrEmptyResponse = errors.New("engine sent an empty response")
+	// ErrUnexpectedContentType is a response body that is not JSON, such as
+	// an HTML error page from a proxy in front of the engine
+	ErrUnexpectedContentType = errors.New("engine sent a response that is not JSON")
+	// ErrTruncatedResponse is a JSON response cut off before its end
+	ErrTruncatedResponse = errors.New("engine response was truncated")
+	// ErrMalformedResponse is a JSON response that does not decode as one,
+	// such as one holding a value of the wrong type
+	ErrMalformedResponse = errors.New("engine sent a malformed response")
+)
+
+// ErrConnection matches, with errors.Is, every failure to reach the engine
+// or get a whole answer from it: a *TransportError, an empty or truncated
+// response, and a gateway answering 502, 503 or 504 for an engine that is
+// not up. Such a call may succeed if sent again, unlike one the engine
+// rejected.
+var ErrConnection = errors.New("cannot reach the policy engine")
+
+// gatewayStatus reports whether status is a gateway answering for an engine
+// that is down
+func gatewayStatus(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// ResponseBodyError is a response body the client could not make sense of.
+// Kind is ErrEmptyResponse, ErrUnexpectedContentType, ErrTruncatedResponse or
+// ErrMalformedResponse, and errors.Is matches it, and ErrConnection for the
+// kinds a failed or missing engine causes.
+type ResponseBodyError struct {
+	Kind        error
+	StatusCode  int
+	ContentType string
+	// Snippet is the start of an unexpected body, at most 256 bytes
+	Snippet string
+	// Received is how many bytes of a truncated body arrived, and
+	// ContentLength how many were announced, or -1 if unknown
+	Received      int64
+	ContentLength int64
+	// Err is the read or decode failure behind a truncated body
+	Err error
+}
+
+func (e *ResponseBodyError) Error() string {
+	message := fmt.Sprintf("%v (status %d)", e.Kind, e.StatusCode)
+	switch e.Kind {
+	case ErrUnexpectedContentType:
+		contentType := e.ContentType
+		if contentType == "" {
+			contentType = "no content type"
+		}
+		return fmt.Sprintf("%s, %s: %q", message, contentType, e.Snippet)
+	case ErrTruncatedResponse:
+		if e.ContentLength >= 0 {
+			return fmt.Sprintf("%s: received %d of %d bytes", message, e.Received, e.ContentLength)
+		}
+		return fmt.Sprintf("%s: received %d bytes", message, e.Received)
+	case ErrMalformedResponse:
+		return fmt.Sprintf("%s: %v", message, e.Err)
+	}
+	return message
+}
+
+func (e *ResponseBodyError) Is(target error) bool {
+	if target != ErrConnection {
+		return false
+	}
+	switch e.Kind {
+	case ErrEmptyResponse, ErrTruncatedResponse:
+		return true
+	case ErrUnexpectedContentType:
+		return gatewayStatus(e.StatusCode)
+	}
+	return false
+}
+
+func (e *ResponseBodyError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// TransportError is a request that failed in transit: it could not be sent,
+// no response arrived, or reading the response body failed, for example
+// because the context was cancelled or its deadline passed. errors.Is matches
+// the cause, such as context.Canceled or context.DeadlineExceeded. No part of
+// the response is returned alongside it.
+type TransportError struct {
+	// HeadersReceived reports whether the engine had started to answer, and
+	// BytesRead how much of the response body had been read by then
+	HeadersReceived bool
+	BytesRead       int64
+	Err             error
+}
+
+func (e *TransportError) Error() string {
+	if !e.HeadersReceived {
+		return fmt.Sprintf("failed to send request: %v", e.Err)
+	}
+	return fmt.Sprintf("failed to read response after %d bytes: %v", e.BytesRead, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TransportError) Is(target error) bool {
+	return target == ErrConnection
+}
+
+// StatusError is a JSON response with an error status but no error field,
+// such as one from a proxy in front of the engine. errors.Is matches
+// ErrConnection for 502, 503 and 504.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("engine answered status %d without an error", e.StatusCode)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrConnection && gatewayStatus(e.StatusCode)
+}
+
+// IsRetryable reports whether a failed evaluation may succeed if sent again
+// unchanged. Empty and truncated responses are, since they come from an
+// engine or connection failing mid-request, and so is an engine asking to be
+// left alone. A body that is not JSON only is when a gateway answered for an
+// engine that is down. The engine rejecting the rule or data never is.
+func IsRetryable(err error) bool {
+	var overloaded *OverloadedError
+	if errors.As(err, &overloaded) {
+		return true
+	}
+	var bodyErr *ResponseBodyError
+	if errors.As(err, &bodyErr) {
+		switch bodyErr.Kind {
+		case ErrEmptyResponse, ErrTruncatedResponse:
+			return true
+		case ErrUnexpectedContentType:
+			return gatewayStatus(bodyErr.StatusCode)
+		}
+	}
+	return false
+}
+
+// parseRetryAfter reads a Retry-After header given either as seconds or as an
+// HTTP date; anything else, or a time already past, is no wait at all
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if wait := at.Sub(now); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// EngineError is the engine rejecting a rule or its data, such as a rule that
+// does not parse or a property the data lacks. The response that carried it
+// is returned alongside, with its Error set to Message. errors.As also finds
+// a *RuleParseError in it for a rule that does not parse, and an
+// *EvaluationError for any other rejection.
+type EngineError struct {
+	// Code classifies the error, e.g. "parse_error"; empty from engines that
+	// only send a message
+	Code    string
+	Message string
+	// StatusCode is the HTTP status the engine answered with
+	StatusCode int
+
+	position Position
+	// lines is the rule text as the response echoed it, a line apiece
+	lines []string
+}
+
+// Position locates an engine error in the rule text
+type Position struct {
+	// Rule is the index of the offending rule in the rule text, or -1 when
+	// the engine did not say
+	Rule int
+	// Line and Column count from 1
+	Line, Column int
+}
+
+func (e *EngineError) Error() string {
+	var b strings.Builder
+	b.WriteString("engine error")
+	if e.Code != "" {
+		b.WriteString(" (" + e.Code + ")")
+	}
+	if pos, ok := e.Position(); ok {
+		fmt.Fprintf(&b, " at line %d, column %d", pos.Line, pos.Column)
+	}
+	b.WriteString(": " + e.Message)
+	return b.String()
+}
+
+// Unwrap returns the error as a *RuleParseError or an *EvaluationError
+func (e *EngineError) Unwrap() error {
+	if e.Code == "parse_error" || strings.HasPrefix(e.Message, "Parse error") {
+		parseErr := &RuleParseError{Message: e.Message, RuleIndex: e.position.Rule, Line: e.position.Line, Column: e.position.Column}
+		if e.position.Line > 0 && e.position.Line <= len(e.lines) {
+			parseErr.Snippet = e.lines[e.position.Line-1]
+			if parseErr.RuleIndex < 0 {
+				parseErr.RuleIndex = ruleAt(e.lines, e.position.Line)
+			}
+		}
+		return parseErr
+	}
+	return &EvaluationError{Message: e.Message}
+}
+
+// ruleAt is the index of the rule holding line of the rule text, counting
+// the blank lines the engine separates rules by
+func ruleAt(lines []string, line int) int {
+	rule := 0
+	for i := 1; i < line-1; i++ {
+		if strings.TrimSpace(lines[i]) == "" && strings.TrimSpace(lines[i-1]) != "" {
+			rule++
+		}
+	}
+	return rule
+}
+
+// RuleParseError is a rule the engine could not parse. Line and Column count
+// from 1, and are zero when the engine did not locate the error. RuleIndex is
+// the offending rule's index in the rule text and Snippet the line the error
+// is on, when they are known; RuleIndex is -1 otherwise.
+type RuleParseError struct {
+	Message      string
+	RuleIndex    int
+	Line, Column int
+	Snippet      string
+}
+
+func (e *RuleParseError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("rule parse error at line %d, column %d: %s", e.Line, e.Column, e.Message)
+	}
+	return "rule parse error: " + e.Message
+}
+
+// FormatWithCaret is the error as a compiler would show it: the message,
+// then the offending line with a caret under the column the error is at.
+// Without a position or the line's text it is just Error.
+func (e *RuleParseError) FormatWithCaret() string {
+	if e.Line <= 0 || e.Snippet == "" {
+		return e.Error()
+	}
+	var b strings.Builder
+	b.WriteString(e.Error())
+	if e.RuleIndex >= 0 {
+		fmt.Fprintf(&b, " (rule %d)", e.RuleIndex)
+	}
+	number := strconv.Itoa(e.Line)
+	gutter := strings.Repeat(" ", len(number))
+	fmt.Fprintf(&b, "\n%s |\n%s | %s\n%s | ", gutter, number, e.Snippet, gutter)
+	// Tabs stay tabs so the caret lines up however they are shown
+	for i, r := range []rune(e.Snippet) {
+		if i >= e.Column-1 {
+			break
+		}
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteByte('^')
+	return b.String()
+}
+
+// EvaluationError is the engine failing to evaluate a rule that parsed, such
+// as one reading a property the data lacks or comparing values of different
+// types
+type EvaluationError struct {
+	Message string
+}
+
+func (e *EvaluationError) Error() string {
+	return "evaluation error: " + e.Message
+}
+
+// Position reports where in the rule text the error is, if the engine said.
+// Engines that only send a message still locate parse errors in its text.
+func (e *EngineError) Position() (Position, bool) {
+	return e.position, e.position.Line > 0
+}
+
+// engineErrorPayload is the structured error newer engines send
+type engineErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Rule    *int   `json:"rule"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+}
+
+// parsePosition finds the "--> line:column" a parse error message points at
+var parsePosition = regexp.MustCompile(`-->\s*(\d+):(\d+)`)
+
+// parseEngineError reads a response's error field, either a structured
+// payload or, from older engines, a plain message; it returns nil when the
+// response has no error
+func parseEngineError(raw json.RawMessage, statusCode int) (*EngineError, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+
+	engineErr := &EngineError{StatusCode: statusCode, position: Position{Rule: -1}}
+	if raw[0] == '"' {
+		if err := json.Unmarshal(raw, &engineErr.Message); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+		if match := parsePosition.FindStringSubmatch(engineErr.Message); match != nil {
+			engineErr.position.Line, _ = strconv.Atoi(match[1])
+			engineErr.position.Column, _ = strconv.Atoi(match[2])
+		}
+		return engineErr, nil
+	}
+
+	var payload engineErrorPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	engineErr.Code = payload.Code
+	engineErr.Message = payload.Message
+	engineErr.position.Line = payload.Line
+	engineErr.position.Column = payload.Column
+	if payload.Rule != nil {
+		engineErr.position.Rule = *payload.Rule
+	}
+	return engineErr, nil
+}
@@ -0,0 +1,462 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replayEngine answers every request with the recorded response in fixture,
+// a path under testdata
+func replayEngine(t *testing.T, fixture string, status int) *httptest.Server {
+	t.Helper()
+
+	body, err := os.ReadFile(filepath.Join("testdata", fixture))
+	require.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// TestEngineError tests structured, text-only and absent engine errors
+func TestEngineError(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixture  string
+		status   int
+		want     *EngineError
+		position Position
+		located  bool
+		message  string
+	}{
+		{
+			name:     "structured",
+			fixture:  "structured.json",
+			status:   http.StatusBadRequest,
+			want:     &EngineError{Code: "parse_error", Message: "expected comparison operator", StatusCode: http.StatusBadRequest},
+			position: Position{Rule: 1, Line: 4, Column: 46},
+			located:  true,
+			message:  "engine error (parse_error) at line 4, column 46: expected comparison operator",
+		},
+		{
+			name:     "text only parse error",
+			fixture:  "text.json",
+			status:   http.StatusBadRequest,
+			want:     &EngineError{StatusCode: http.StatusBadRequest},
+			position: Position{Rule: -1, Line: 4, Column: 46},
+			located:  true,
+		},
+		{
+			name:     "text only evaluation error",
+			fixture:  "evaluation.json",
+			status:   http.StatusBadRequest,
+			want:     &EngineError{Message: "Evaluation error: Property 'age' not found in selector 'driver'", StatusCode: http.StatusBadRequest},
+			position: Position{Rule: -1},
+			message:  "engine error: Evaluation error: Property 'age' not found in selector 'driver'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(replayEngine(t, "engine_errors/"+tt.fixture, tt.status).URL)
+			require.NoError(t, err)
+
+			response, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+			var engineErr *EngineError
+			require.ErrorAs(t, err, &engineErr)
+			require.NotNil(t, response)
+			assert.Same(t, response.EngineError, engineErr)
+
+			position, located := engineErr.Position()
+			assert.Equal(t, tt.position, position)
+			assert.Equal(t, tt.located, located)
+			assert.Equal(t, tt.want.Code, engineErr.Code)
+			assert.Equal(t, tt.want.StatusCode, engineErr.StatusCode)
+			if tt.want.Message != "" {
+				assert.Equal(t, tt.want.Message, engineErr.Message)
+			}
+			if tt.message != "" {
+				assert.EqualError(t, err, tt.message)
+			}
+
+			// The raw message stays where older callers look for it
+			require.NotNil(t, response.Error)
+			assert.Equal(t, engineErr.Message, *response.Error)
+			assert.False(t, response.Result)
+			assert.NotEmpty(t, response.Rule)
+		})
+	}
+
+	t.Run("absent", func(t *testing.T) {
+		c, err := New(replayEngine(t, "engine_errors/none.json", http.StatusOK).URL)
+		require.NoError(t, err)
+
+		response, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+		require.NoError(t, err)
+		assert.Nil(t, response.Error)
+		assert.Nil(t, response.EngineError)
+		assert.True(t, response.Result)
+	})
+}
+
+// TestEngineErrorTextPosition tests that the position of a text-only parse error is read from its message
+func TestEngineErrorTextPosition(t *testing.T) {
+	c, err := New(replayEngine(t, "engine_errors/text.json", http.StatusBadRequest).URL)
+	require.NoError(t, err)
+
+	_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, true)
+	var engineErr *EngineError
+	require.True(t, errors.As(err, &engineErr))
+	assert.Regexp(t, `^engine error at line 4, column 46: Parse error:  --> 4:46\n`, err.Error())
+}
+
+// TestEngineErrorInBatch tests that a rejected batch item keeps its response next to the error
+func TestEngineErrorInBatch(t *testing.T) {
+	c, err := New(replayEngine(t, "engine_errors/structured.json", http.StatusBadRequest).URL)
+	require.NoError(t, err)
+
+	for _, opts := range [][]BatchOption{nil, {SummarizeTraces()}} {
+		results, err := c.EvaluateBatch(context.Background(), "rule", []interface{}{map[string]interface{}{}}, opts...)
+		var batchErr *BatchError
+		require.ErrorAs(t, err, &batchErr)
+		var engineErr *EngineError
+		require.ErrorAs(t, results[0].Err, &engineErr)
+		require.NotNil(t, results[0].Response)
+		assert.Equal(t, "parse_error", results[0].Response.EngineError.Code)
+	}
+}
+
+// TestParseEngineErrorMalformed tests that an error field of the wrong shape fails the call
+func TestParseEngineErrorMalformed(t *testing.T) {
+	_, err := parseEngineError([]byte(`42`), http.StatusBadRequest)
+	assert.ErrorContains(t, err, "failed to unmarshal response")
+
+	engineErr, err := parseEngineError([]byte(` null `), http.StatusOK)
+	assert.NoError(t, err)
+	assert.Nil(t, engineErr)
+}
+
+// rawEngine answers every request with response written straight to the
+// connection, which it then closes, so a body can be cut short of its
+// Content-Length
+func rawEngine(t *testing.T, response string) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				_, _ = io.Copy(io.Discard, req.Body)
+				_, _ = io.WriteString(conn, response)
+			}()
+		}
+	}()
+
+	return "http://" + listener.Addr().String()
+}
+
+// rawResponse builds an HTTP/1.1 response announcing contentLength bytes of
+// body, or none when negative, and closing the connection after it
+func rawResponse(status int, contentType string, contentLength int, body string) string {
+	head := fmt.Sprintf("HTTP/1.1 %d %s\r\nConnection: close\r\n", status, http.StatusText(status))
+	if contentType != "" {
+		head += "Content-Type: " + contentType + "\r\n"
+	}
+	if contentLength >= 0 {
+		head += fmt.Sprintf("Content-Length: %d\r\n", contentLength)
+	}
+	return head + "\r\n" + body
+}
+
+// TestResponseBodyErrors tests that empty, non-JSON and truncated bodies are told apart and classified for retrying
+func TestResponseBodyErrors(t *testing.T) {
+	html := "<html><head><title>502 Bad Gateway</title></head><body>" + strings.Repeat("nginx ", 100) + "</body></html>"
+	complete := `{"result":true,"rule":["rule"],"data":{}}`
+
+	tests := []struct {
+		name      string
+		response  string
+		kind      error
+		message   string
+		retryable bool
+	}{
+		{
+			name:      "empty",
+			response:  rawResponse(http.StatusOK, "application/json", 0, ""),
+			kind:      ErrEmptyResponse,
+			message:   "engine sent an empty response (status 200)",
+			retryable: true,
+		},
+		{
+			name:      "empty without length",
+			response:  rawResponse(http.StatusOK, "application/json", -1, "  \n"),
+			kind:      ErrEmptyResponse,
+			retryable: true,
+		},
+		{
+			name:      "gateway page",
+			response:  rawResponse(http.StatusBadGateway, "text/html", len(html), html),
+			kind:      ErrUnexpectedContentType,
+			message:   fmt.Sprintf("engine sent a response that is not JSON (status 502), text/html: %q", html[:256]),
+			retryable: true,
+		},
+		{
+			name:     "page served as success",
+			response: rawResponse(http.StatusOK, "text/html; charset=utf-8", len(html), html),
+			kind:     ErrUnexpectedContentType,
+		},
+		{
+			name:     "JSON with an HTML content type",
+			response: rawResponse(http.StatusOK, "text/html", len(complete), complete),
+			kind:     ErrUnexpectedContentType,
+		},
+		{
+			name:     "plain text",
+			response: rawResponse(http.StatusOK, "", 12, "upstream bye"),
+			kind:     ErrUnexpectedContentType,
+			message:  `engine sent a response that is not JSON (status 200), no content type: "upstream bye"`,
+		},
+		{
+			name:      "truncated",
+			response:  rawResponse(http.StatusOK, "application/json", len(complete), complete[:20]),
+			kind:      ErrTruncatedResponse,
+			message:   fmt.Sprintf("engine response was truncated (status 200): received 20 of %d bytes", len(complete)),
+			retryable: true,
+		},
+		{
+			name:      "truncated without length",
+			response:  rawResponse(http.StatusOK, "application/json", -1, complete[:20]),
+			kind:      ErrTruncatedResponse,
+			message:   "engine response was truncated (status 200): received 20 bytes",
+			retryable: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(rawEngine(t, tt.response))
+			require.NoError(t, err)
+
+			response, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+			assert.Nil(t, response)
+			assert.ErrorIs(t, err, tt.kind)
+			var bodyErr *ResponseBodyError
+			require.ErrorAs(t, err, &bodyErr)
+			if tt.message != "" {
+				assert.EqualError(t, err, tt.message)
+			}
+			if tt.kind == ErrTruncatedResponse {
+				assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+			}
+			assert.Equal(t, tt.retryable, IsRetryable(err))
+		})
+	}
+
+	// A complete body still decodes, whatever text/plain Go sniffed for it
+	for _, contentType := range []string{"application/json", "text/plain; charset=utf-8", ""} {
+		c, err := New(rawEngine(t, rawResponse(http.StatusOK, contentType, len(complete), complete)))
+		require.NoError(t, err)
+		response, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+		require.NoError(t, err, contentType)
+		assert.True(t, response.Result)
+	}
+}
+
+// TestIsRetryable tests the classification of errors the body checks do not produce
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, IsRetryable(fmt.Errorf("item 1: %w", &OverloadedError{StatusCode: http.StatusTooManyRequests})))
+	assert.False(t, IsRetryable(&EngineError{Message: "Parse error", StatusCode: http.StatusBadRequest}))
+	assert.False(t, IsRetryable(&UnsupportedDataError{}))
+	assert.False(t, IsRetryable(nil))
+}
+
+// TestTypedErrors tests that canned engine failures are told apart with
+// errors.As and errors.Is rather than by their text
+func TestTypedErrors(t *testing.T) {
+	evaluate := func(t *testing.T, url string) error {
+		t.Helper()
+		c, err := New(url)
+		require.NoError(t, err)
+		_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+		return err
+	}
+
+	t.Run("structured parse error", func(t *testing.T) {
+		err := evaluate(t, replayEngine(t, "engine_errors/structured.json", http.StatusBadRequest).URL)
+		var parseErr *RuleParseError
+		require.ErrorAs(t, err, &parseErr)
+		assert.Equal(t, RuleParseError{
+			Message:   "expected comparison operator",
+			RuleIndex: 1,
+			Line:      4,
+			Column:    46,
+			Snippet:   "A **driver** passes the test if __age__ of **driver** is bigger than 16.",
+		}, *parseErr)
+		assert.NotErrorIs(t, err, ErrConnection)
+	})
+
+	t.Run("text only parse error", func(t *testing.T) {
+		err := evaluate(t, replayEngine(t, "engine_errors/text.json", http.StatusBadRequest).URL)
+		var parseErr *RuleParseError
+		require.ErrorAs(t, err, &parseErr)
+		assert.Equal(t, 4, parseErr.Line)
+		assert.Equal(t, 46, parseErr.Column)
+		assert.Equal(t, 1, parseErr.RuleIndex, "found from the blank lines between rules")
+		var evalErr *EvaluationError
+		assert.False(t, errors.As(err, &evalErr))
+	})
+
+	t.Run("evaluation error", func(t *testing.T) {
+		err := evaluate(t, replayEngine(t, "engine_errors/evaluation.json", http.StatusBadRequest).URL)
+		var evalErr *EvaluationError
+		require.ErrorAs(t, err, &evalErr)
+		assert.Equal(t, "Evaluation error: Property 'age' not found in selector 'driver'", evalErr.Message)
+		var parseErr *RuleParseError
+		assert.False(t, errors.As(err, &parseErr))
+	})
+
+	t.Run("error status without an error", func(t *testing.T) {
+		err := evaluate(t, rawEngine(t, rawResponse(http.StatusNotFound, "application/json", 2, "{}")))
+		var statusErr *StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+		assert.NotErrorIs(t, err, ErrConnection)
+
+		err = evaluate(t, rawEngine(t, rawResponse(http.StatusServiceUnavailable, "application/json", 2, "{}")))
+		assert.ErrorIs(t, err, ErrConnection)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		err := evaluate(t, rawEngine(t, rawResponse(http.StatusOK, "application/json", 24, `{"result":true,"rule":7}`)))
+		assert.ErrorIs(t, err, ErrMalformedResponse)
+		assert.ErrorContains(t, err, "failed to unmarshal response")
+		assert.NotErrorIs(t, err, ErrConnection)
+	})
+
+	t.Run("connection", func(t *testing.T) {
+		for name, url := range map[string]string{
+			"refused":   closedURL(t),
+			"empty":     rawEngine(t, rawResponse(http.StatusOK, "application/json", 0, "")),
+			"truncated": rawEngine(t, rawResponse(http.StatusOK, "application/json", 40, `{"result":tr`)),
+			"gateway":   rawEngine(t, rawResponse(http.StatusBadGateway, "text/html", 6, "<html>")),
+		} {
+			err := evaluate(t, url)
+			assert.ErrorIs(t, err, ErrConnection, name)
+			var parseErr *RuleParseError
+			assert.False(t, errors.As(err, &parseErr), name)
+		}
+	})
+}
+
+// closedURL returns the URL of a port nothing listens on
+func closedURL(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	url := "http://" + listener.Addr().String()
+	require.NoError(t, listener.Close())
+	return url
+}
+
+// TestRuleParseErrorPositions tests that parse errors in a policy of several
+// rules are located in the right rule, line and column, and shown with a
+// caret under the column
+func TestRuleParseErrorPositions(t *testing.T) {
+	rules := []string{
+		"A **driver** gets a licence if the **driver** passes the test\nand the **driver** is adult.",
+		"A **driver** passes the test if the __score__ of the **driver** is over 80.",
+		"A **driver** is adult if the __age__ of the **driver** is at least 18.",
+	}
+	text := JoinRules(rules...)
+	lines := strings.Split(text, "\n")
+	tests := []struct {
+		name    string
+		error   string
+		rule    int
+		line    int
+		column  int
+		snippet string
+		caret   string
+	}{
+		{
+			name:    "first rule, second line",
+			error:   `{"code": "parse_error", "message": "expected outcome", "line": 2, "column": 20}`,
+			rule:    0,
+			line:    2,
+			column:  20,
+			snippet: "and the **driver** is adult.",
+			caret:   "rule parse error at line 2, column 20: expected outcome (rule 0)\n  |\n2 | and the **driver** is adult.\n  |                    ^",
+		},
+		{
+			name:    "second rule",
+			error:   `"Parse error:  --> 4:67\n  |\n4 | ...\n  = expected comparison_operator"`,
+			rule:    1,
+			line:    4,
+			column:  67,
+			snippet: rules[1],
+		},
+		{
+			name:    "third rule, numbered by the engine",
+			error:   `{"code": "parse_error", "message": "expected a number", "rule": 2, "line": 6, "column": 1}`,
+			rule:    2,
+			line:    6,
+			column:  1,
+			snippet: rules[2],
+			caret:   "rule parse error at line 6, column 1: expected a number (rule 2)\n  |\n6 | " + rules[2] + "\n  | ^",
+		},
+		{
+			name:  "no position",
+			error: `"Parse error: No global rule found"`,
+			rule:  -1,
+			caret: "rule parse error: Parse error: No global rule found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := json.Marshal(lines)
+			require.NoError(t, err)
+			c, err := New(rawEngine(t, rawResponse(http.StatusBadRequest, "application/json", -1,
+				`{"result": false, "error": `+tt.error+`, "rule": `+string(rule)+`, "data": {}}`)))
+			require.NoError(t, err)
+			_, err = c.EvaluateRules(context.Background(), rules, map[string]interface{}{}, false)
+
+			var parseErr *RuleParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, tt.rule, parseErr.RuleIndex)
+			assert.Equal(t, tt.line, parseErr.Line)
+			assert.Equal(t, tt.column, parseErr.Column)
+			assert.Equal(t, tt.snippet, parseErr.Snippet)
+			if tt.caret != "" {
+				assert.Equal(t, tt.caret, parseErr.FormatWithCaret())
+			}
+		})
+	}
+}
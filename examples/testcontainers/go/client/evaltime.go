@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// TimeTravel reports how an evaluation pinned with ContextWithEvaluationTime
+// was made to see its evaluation time
+type TimeTravel string
+
+const (
+	// TimeTravelContext means only the ambient date was pinned: the data's
+	// **Context** carried the evaluation time as "now", and the rule had no
+	// condition reading the engine's clock
+	TimeTravelContext TimeTravel = "context"
+	// TimeTravelRewritten means the ambient date was pinned and the rule's
+	// relative date conditions were rewritten to absolute dates as of the
+	// evaluation time
+	TimeTravelRewritten TimeTravel = "rewritten"
+	// TimeTravelEngine means the engine was given the evaluation time and
+	// evaluated against it, see CapabilityAsOf
+	TimeTravelEngine TimeTravel = "engine"
+)
+
+// ErrClockDependent reports a rule condition that reads the engine's clock in
+// a way no absolute date can replace, so the evaluation cannot be pinned. It
+// comes wrapped in a *CapabilityError for CapabilityAsOf.
+var ErrClockDependent = errors.New("condition depends on the engine's clock")
+
+type evaluationTimeKey struct{}
+
+// ContextWithEvaluationTime pins the evaluations made with the returned
+// context to at, so policies with date conditions can be tested on any day.
+// The engine has no as-of parameter and always compares against its own
+// clock, so the client does it instead: the ambient **Context** entity is
+// added, as WithContextData adds it, with at as its "now", and conditions
+// such as "is older than 18 years" are rewritten to "is earlier than" the
+// date that many years before at. The response's TimeTravel reports which
+// was needed and its Rule holds the rule as sent. A rule whose clock-reading
+// conditions cannot be rewritten, such as "is within", fails with
+// ErrClockDependent. Under WithCapabilityProbing, an engine supporting
+// CapabilityAsOf is sent at in EvaluationTimeHeader instead and the rule is
+// left alone.
+func ContextWithEvaluationTime(ctx context.Context, at time.Time) context.Context {
+	return context.WithValue(ctx, evaluationTimeKey{}, at)
+}
+
+type engineTimeKey struct{}
+
+// EvaluationTimeFromContext returns the evaluation time ctx pins, if any
+func EvaluationTimeFromContext(ctx context.Context) (time.Time, bool) {
+	at, ok := ctx.Value(evaluationTimeKey{}).(time.Time)
+	return at, ok
+}
+
+// clockCondition matches the operators the engine evaluates against its own
+// clock, with the duration literal that follows them if there is one
+var clockCondition = regexp.MustCompile(`\bis (older than|younger than|within)(?: (\d+(?:\.\d+)?) (centuries|century|decades|decade|years|year|months|month|weeks|week|days|day|hours|hour|minutes|minute|seconds|second)\b)?`)
+
+// unitSeconds are the engine's lengths of each duration unit
+var unitSeconds = map[string]float64{
+	"second": 1, "seconds": 1,
+	"minute": 60, "minutes": 60,
+	"hour": 3600, "hours": 3600,
+	"day": 86400, "days": 86400,
+	"week": 604800, "weeks": 604800,
+	"month": 2629746, "months": 2629746,
+	"year": 31556952, "years": 31556952,
+	"decade": 315569520, "decades": 315569520,
+	"century": 3155695200, "centuries": 3155695200,
+}
+
+// pinRule rewrites rule's relative date conditions to absolute ones as of
+// at, reporting whether it found any
+func pinRule(rule string, at time.Time) (string, bool, error) {
+	asOf := at.UTC().Truncate(24 * time.Hour)
+	var failure error
+	rewritten := false
+	pinned := clockCondition.ReplaceAllStringFunc(rule, func(condition string) string {
+		match := clockCondition.FindStringSubmatch(condition)
+		operator, amount, unit := match[1], match[2], match[3]
+		if operator == "within" || amount == "" {
+			if failure == nil {
+				failure = fmt.Errorf("failed to pin evaluation time: %q: %w", condition, ErrClockDependent)
+			}
+			return condition
+		}
+		n, _ := strconv.ParseFloat(amount, 64)
+		days := n * unitSeconds[unit] / 86400
+		rewritten = true
+
+		// The engine counts whole days between the date and today and
+		// compares them with the duration's fractional days
+		if operator == "older than" {
+			return "is earlier than " + asOf.AddDate(0, 0, -int(math.Floor(days))).Format("2006-01-02")
+		}
+		return "is later than " + asOf.AddDate(0, 0, -int(math.Ceil(days))).Format("2006-01-02")
+	})
+	if failure != nil {
+		return "", false, failure
+	}
+	return pinned, rewritten, nil
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPinRule tests rewriting relative date conditions to the dates the
+// engine would compare against on the pinned day
+func TestPinRule(t *testing.T) {
+	at := time.Date(2024, 6, 15, 23, 30, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		rule, want string
+	}{
+		// 18 years are 6574.365 days, so the engine wants 6575 whole days
+		{"the __birth_date__ of the **Person** is older than 18 years.", "the __birth_date__ of the **Person** is earlier than 2006-06-16."},
+		{"the __birth_date__ of the **Person** is younger than 18 years.", "the __birth_date__ of the **Person** is later than 2006-06-15."},
+		{"the __opened__ of the **Account** is older than 30 days.", "the __opened__ of the **Account** is earlier than 2024-05-16."},
+		{"the __opened__ of the **Account** is younger than 1 day.", "the __opened__ of the **Account** is later than 2024-06-14."},
+	} {
+		pinned, rewritten, err := pinRule(tc.rule, at)
+		require.NoError(t, err, tc.rule)
+		assert.True(t, rewritten, tc.rule)
+		assert.Equal(t, tc.want, pinned)
+	}
+
+	rule := "the __total__ of the **Order** is greater than 100."
+	pinned, rewritten, err := pinRule(rule, at)
+	require.NoError(t, err)
+	assert.False(t, rewritten)
+	assert.Equal(t, rule, pinned)
+
+	for _, rule := range []string{
+		"the __shipped__ of the **Order** is within 3 days.",
+		"the __birth_date__ of the **Person** is older than the __minimum__ of the **Policy**.",
+	} {
+		_, _, err := pinRule(rule, at)
+		assert.True(t, errors.Is(err, ErrClockDependent), "%s: got %v", rule, err)
+	}
+}
+
+// TestEvaluationTime tests what a pinned evaluation sends, on each way of
+// evaluating
+func TestEvaluationTime(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL, WithClock(func() time.Time { return time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC) }))
+	require.NoError(t, err)
+
+	const adult = "An **Applicant** is an adult if the __birth_date__ of the **Applicant** is older than 18 years."
+	const pinnedAdult = "An **Applicant** is an adult if the __birth_date__ of the **Applicant** is earlier than 2006-06-16."
+	ctx := ContextWithEvaluationTime(context.Background(), time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC))
+	data := map[string]interface{}{"Applicant": map[string]interface{}{"birth_date": "2006-06-14"}}
+
+	response, err := c.Evaluate(ctx, PolicyRequest{Rule: adult, Data: data})
+	require.NoError(t, err)
+	assert.Equal(t, TimeTravelRewritten, response.TimeTravel)
+	prepared, err := c.Prepare(adult)
+	require.NoError(t, err)
+	response, err = prepared.Evaluate(ctx, data)
+	require.NoError(t, err)
+	assert.Equal(t, TimeTravelRewritten, response.TimeTravel)
+
+	response, err = c.Evaluate(ctx, PolicyRequest{Rule: "A **Person** gets x if the __age__ of the **Person** is at least 1.", Data: data})
+	require.NoError(t, err)
+	assert.Equal(t, TimeTravelContext, response.TimeTravel)
+
+	// Unpinned evaluations are left alone
+	response, err = c.Evaluate(context.Background(), PolicyRequest{Rule: adult, Data: data})
+	require.NoError(t, err)
+	assert.Empty(t, response.TimeTravel)
+
+	requests := engine.Requests()
+	require.Len(t, requests, 4)
+	for _, req := range requests[:2] {
+		assert.Equal(t, pinnedAdult, req.Rule)
+		assert.Equal(t, map[string]interface{}{
+			"Applicant": map[string]interface{}{"birth_date": "2006-06-14"},
+			"Context":   map[string]interface{}{"now": "2024-06-15"},
+		}, req.Data)
+	}
+	assert.Equal(t, adult, requests[3].Rule)
+	assert.Equal(t, data, requests[3].Data)
+
+	_, err = c.Evaluate(ctx, PolicyRequest{Rule: "An **Order** ships if the __placed__ of the **Order** is within 3 days.", Data: data})
+	assert.ErrorIs(t, err, ErrClockDependent)
+	assert.Len(t, engine.Requests(), 4)
+}
@@ -0,0 +1,15 @@
+package client
+
+import "context"
+
+// Evaluator is anything that evaluates policies: PolicyClient over HTTP, or
+// the in-memory evaluatortest.Mock. Code that only evaluates can accept an
+// Evaluator, so tests swap the engine for a mock by changing a constructor.
+// Implementations return the engine's rejection of a rule or its data as an
+// *EngineError, and a cancelled call as an error matching the context's.
+type Evaluator interface {
+	Evaluate(ctx context.Context, req PolicyRequest) (*PolicyResponse, error)
+	Health(ctx context.Context) error
+}
+
+var _ Evaluator = (*PolicyClient)(nil)
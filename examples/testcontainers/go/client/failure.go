@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// FailurePolicy is what an evaluation answers when the engine cannot: it is
+// unreachable, times out, fails with a 5xx, or a callback panics. The zero
+// value, FailWithError, returns the error as it always has; the others
+// answer with a degraded response instead. The engine rejecting the rule or
+// data, the caller's own data errors and the caller's context ending are
+// errors whatever the policy.
+type FailurePolicy struct {
+	decision *PolicyResponse
+}
+
+var (
+	// FailWithError returns the failure to the caller
+	FailWithError = FailurePolicy{}
+	// FailClosed answers with a denial
+	FailClosed = FailWithDecision(PolicyResponse{Result: false})
+	// FailOpen answers with a grant
+	FailOpen = FailWithDecision(PolicyResponse{Result: true})
+)
+
+// FailWithDecision answers with decision's Result and Labels, e.g. to deny
+// overall but keep a read-only label granted
+func FailWithDecision(decision PolicyResponse) FailurePolicy {
+	return FailurePolicy{decision: &PolicyResponse{Result: decision.Result, Labels: decision.Labels}}
+}
+
+// WithFailurePolicy sets the client's failure policy. A rule profile can set
+// its own through its Options, and a call through ContextWithFailurePolicy;
+// the call's beats the profile's, which beats the client's.
+func WithFailurePolicy(policy FailurePolicy) Option {
+	return func(c *PolicyClient) {
+		c.failure = policy
+	}
+}
+
+type failurePolicyKey struct{}
+
+// ContextWithFailurePolicy returns a copy of ctx under which evaluations
+// follow policy, whatever the client or its profiles say
+func ContextWithFailurePolicy(ctx context.Context, policy FailurePolicy) context.Context {
+	return context.WithValue(ctx, failurePolicyKey{}, policy)
+}
+
+// FallbackDecision is the result a degraded response carries
+type FallbackDecision bool
+
+const (
+	FallbackDeny  FallbackDecision = false
+	FallbackAllow FallbackDecision = true
+)
+
+// Fallback says why a response is degraded rather than the engine's
+type Fallback struct {
+	Decision FallbackDecision
+	// Err is the failure the decision stands in for
+	Err error
+}
+
+// WithFallbackDecision is WithFailurePolicy with FailClosed for FallbackDeny
+// and FailOpen for FallbackAllow
+func WithFallbackDecision(decision FallbackDecision) Option {
+	if decision == FallbackAllow {
+		return WithFailurePolicy(FailOpen)
+	}
+	return WithFailurePolicy(FailClosed)
+}
+
+// failureStats counts what a client and its rule profiles have answered in
+// place of the engine, and the hedges they have sent it
+type failureStats struct {
+	degraded      atomic.Int64
+	auditFailures atomic.Int64
+	hedges        atomic.Int64
+}
+
+// FallbackDecisions returns how many degraded responses the failure policy
+// has answered with
+func (c *PolicyClient) FallbackDecisions() int64 {
+	return c.stats.degraded.Load()
+}
+
+// fallBack replaces err with a degraded response, if the failure policy in
+// force gives one and err is an outage rather than a problem with the
+// request
+func (c *PolicyClient) fallBack(ctx context.Context, response *PolicyResponse, err error, id string) (*PolicyResponse, error) {
+	if err == nil || ctx.Err() != nil || !isOutage(err) {
+		return response, err
+	}
+	policy := c.failure
+	if fromCtx, ok := ctx.Value(failurePolicyKey{}).(FailurePolicy); ok {
+		policy = fromCtx
+	}
+	if policy.decision == nil {
+		return response, err
+	}
+	c.stats.degraded.Add(1)
+	degraded := cloneResponse(policy.decision)
+	degraded.DecisionID = id
+	degraded.Degraded = true
+	degraded.Fallback = &Fallback{Decision: FallbackDecision(degraded.Result), Err: err}
+	return degraded, nil
+}
+
+// isOutage reports whether err is the engine, the way to it or a callback
+// failing, rather than the engine rejecting the request
+func isOutage(err error) bool {
+	var (
+		panicErr     *CallbackPanicError
+		transportErr *TransportError
+		engineErr    *EngineError
+		bodyErr      *ResponseBodyError
+		statusErr    *StatusError
+	)
+	switch {
+	case errors.As(err, &panicErr), errors.As(err, &transportErr), IsRetryable(err):
+		return true
+	case errors.Is(err, ErrConnection), errors.Is(err, ErrCircuitOpen):
+		return true
+	case errors.As(err, &engineErr):
+		return engineErr.StatusCode >= 500
+	case errors.As(err, &bodyErr):
+		return bodyErr.StatusCode >= 500
+	case errors.As(err, &statusErr):
+		return statusErr.StatusCode >= 500
+	case errors.Is(err, context.DeadlineExceeded):
+		// The client's own timeout, since the caller's context is still live
+		return true
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFallbackDecision tests that outages and panics are answered with the
+// fallback decision, and that rejected requests and cancelled calls are not
+func TestFallbackDecision(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]interface{}{"n": 1}
+
+	engine := newScriptedEngine(t, nil)
+	c, err := New(engine.URL, WithFallbackDecision(FallbackAllow))
+	require.NoError(t, err)
+	response, err := c.EvaluatePolicy(ctx, "rule", data, false)
+	require.NoError(t, err)
+	assert.True(t, response.Result)
+	assert.True(t, response.Degraded)
+	require.NotNil(t, response.Fallback)
+	assert.Equal(t, FallbackAllow, response.Fallback.Decision)
+	assert.Error(t, response.Fallback.Err)
+	assert.NotEmpty(t, response.DecisionID)
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	c, err = New(unreachable.URL, WithFallbackDecision(FallbackDeny))
+	require.NoError(t, err)
+	response, err = c.EvaluatePolicy(ctx, "rule", data, false)
+	require.NoError(t, err)
+	assert.False(t, response.Result)
+	var transportErr *TransportError
+	assert.ErrorAs(t, response.Fallback.Err, &transportErr)
+
+	c, err = New(unreachable.URL, WithFallbackDecision(FallbackDeny),
+		WithDataTransforms(explodingTransform))
+	require.NoError(t, err)
+	response, err = c.EvaluatePolicy(ctx, "rule", data, false)
+	require.NoError(t, err)
+	assertPanic(t, response.Fallback.Err, "data transform")
+	assert.Equal(t, int64(1), c.FallbackDecisions())
+
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"result":false,"rule":[],"error":"parse error"}`))
+	}))
+	defer rejecting.Close()
+	c, err = New(rejecting.URL, WithFallbackDecision(FallbackAllow))
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(ctx, "rule", data, false)
+	var engineErr *EngineError
+	assert.ErrorAs(t, err, &engineErr, "a rejected request is not an outage")
+
+	c, err = New(unreachable.URL, WithFallbackDecision(FallbackAllow))
+	require.NoError(t, err)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = c.EvaluatePolicy(cancelled, "rule", data, false)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, c.FallbackDecisions())
+}
+
+// TestFailurePolicyPrecedence tests that a call's failure policy beats its
+// rule profile's, which beats the client's
+func TestFailurePolicyPrecedence(t *testing.T) {
+	engine := newScriptedEngine(t, nil)
+	c, err := New(engine.URL, WithFailurePolicy(FailClosed),
+		WithRuleProfile(SelectRule("profiled"), CallProfile{Options: []Option{WithFailurePolicy(FailOpen)}}))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	response, err := c.EvaluatePolicy(ctx, "plain", nil, false)
+	require.NoError(t, err)
+	assert.True(t, response.Degraded)
+	assert.False(t, response.Result, "the client's policy")
+
+	response, err = c.EvaluatePolicy(ctx, "profiled", nil, false)
+	require.NoError(t, err)
+	assert.True(t, response.Result, "the profile's policy")
+
+	readOnly := FailWithDecision(PolicyResponse{Result: false, Labels: map[string]bool{"read": true}})
+	response, err = c.EvaluatePolicy(ContextWithFailurePolicy(ctx, readOnly), "profiled", nil, false)
+	require.NoError(t, err)
+	assert.False(t, response.Result, "the call's policy")
+	assert.Equal(t, map[string]bool{"read": true}, response.Labels)
+	response.Labels["write"] = true
+
+	_, err = c.EvaluatePolicy(ContextWithFailurePolicy(ctx, FailWithError), "profiled", nil, false)
+	assert.Error(t, err, "a call can ask for the error back")
+
+	response, err = c.EvaluatePolicy(ContextWithFailurePolicy(ctx, readOnly), "plain", nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"read": true}, response.Labels, "each degraded response is a copy")
+	assert.Equal(t, int64(4), c.FallbackDecisions(), "profiles count towards their client")
+}
+
+// failingAuditSink refuses every record
+type failingAuditSink struct{}
+
+func (failingAuditSink) Record(context.Context, AuditRecord) error {
+	return errors.New("audit store down")
+}
+
+// TestAuditDegradedDecisions tests that the audit trail tells degraded
+// decisions from the engine's
+func TestAuditDegradedDecisions(t *testing.T) {
+	engine := newScriptedEngine(t, result(true), nil)
+	var trail bytes.Buffer
+	c, err := New(engine.URL, WithFailurePolicy(FailClosed), WithAuditSink(NewJSONAuditSink(&trail)))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	genuine, err := c.EvaluatePolicy(ctx, "rule", nil, false)
+	require.NoError(t, err)
+	degraded, err := c.EvaluatePolicy(ctx, "rule", nil, false)
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(ContextWithFailurePolicy(ctx, FailWithError), "rule", nil, false)
+	require.Error(t, err)
+
+	lines := strings.Split(strings.TrimSpace(trail.String()), "\n")
+	require.Len(t, lines, 3)
+	var records []AuditRecord
+	for _, line := range lines {
+		var record AuditRecord
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	assert.Equal(t, genuine.DecisionID, records[0].DecisionID)
+	assert.True(t, records[0].Result)
+	assert.False(t, records[0].Degraded)
+	assert.Empty(t, records[0].Reason)
+
+	assert.Equal(t, degraded.DecisionID, records[1].DecisionID)
+	assert.False(t, records[1].Result)
+	assert.True(t, records[1].Degraded)
+	assert.Contains(t, records[1].Reason, "500")
+	assert.Contains(t, lines[1], `"degraded":true`)
+
+	assert.False(t, records[2].Degraded)
+	assert.NotEmpty(t, records[2].Error)
+	assert.Equal(t, records[0].RuleHash, records[2].RuleHash)
+
+	c, err = New(engine.URL, WithAuditSink(failingAuditSink{}))
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(ctx, "rule", nil, false)
+	assert.Error(t, err, "the engine's failure, not the sink's")
+	assert.Equal(t, int64(1), c.AuditFailures())
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+type hedgeConfig struct {
+	delay     time.Duration
+	maxHedges int
+}
+
+func (h hedgeConfig) enabled() bool {
+	return h.delay > 0 && h.maxHedges > 0
+}
+
+// WithHedging sends an identical copy of an evaluation when no response has
+// arrived within delay, up to maxHedges extra copies spaced delay apart. The
+// first response to arrive is returned and the other requests are cancelled.
+// Evaluations have no side effects on the engine, so the duplicates are
+// harmless; reader data can only be sent once and is never hedged.
+//
+// Hedging is not retrying: a failed request does not trigger a hedge, and the
+// call fails with the first error once every request sent has failed.
+// HedgedRequests counts the hedges sent, apart from the evaluations, as do
+// the Prometheus and OpenTelemetry metrics, so the extra load on the engine
+// can be watched.
+func WithHedging(delay time.Duration, maxHedges int) Option {
+	return func(c *PolicyClient) {
+		c.hedging = hedgeConfig{delay: delay, maxHedges: maxHedges}
+	}
+}
+
+// HedgedRequests returns how many hedges WithHedging has sent, beyond each
+// evaluation's first request
+func (c *PolicyClient) HedgedRequests() int64 {
+	return c.stats.hedges.Load()
+}
+
+// hedgedRoundTrip races the original request against hedges sent after each
+// delay with no response
+func (c *PolicyClient) hedgedRoundTrip(ctx context.Context, body *requestBody) (*PolicyResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	// Cancelling on return stops the requests that lost
+	defer cancel()
+
+	type result struct {
+		response *PolicyResponse
+		err      error
+	}
+	// Buffered so losing requests never block once nobody is listening
+	results := make(chan result, 1+c.hedging.maxHedges)
+	send := func() {
+		go func() {
+			response, err := c.roundTrip(ctx, body)
+			results <- result{response: response, err: err}
+		}()
+	}
+
+	send()
+	inFlight, hedges := 1, 0
+	timer := time.NewTimer(c.hedging.delay)
+	defer timer.Stop()
+
+	var firstErr error
+	for {
+		select {
+		case r := <-results:
+			inFlight--
+			if r.err == nil {
+				return r.response, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if inFlight == 0 {
+				return nil, firstErr
+			}
+		case <-timer.C:
+			send()
+			inFlight++
+			hedges++
+			c.stats.hedges.Add(1)
+			c.prometheus.hedge()
+			c.telemetry.hedge(ctx)
+			if hedges < c.hedging.maxHedges {
+				timer.Reset(c.hedging.delay)
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// stallingEngine stalls every stallEvery-th request it receives for stall, or
+// until the client gives up on it, and answers the rest at once
+type stallingEngine struct {
+	*httptest.Server
+	stallEvery int64
+	stall      time.Duration
+
+	requests  int64
+	stalled   int64
+	cancelled int64
+}
+
+func newStallingEngine(t *testing.T, stallEvery int64, stall time.Duration) *stallingEngine {
+	t.Helper()
+
+	engine := &stallingEngine{stallEvery: stallEvery, stall: stall}
+	engine.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Consuming the body lets the server notice the client going away
+		_, _ = io.Copy(io.Discard, r.Body)
+		n := atomic.AddInt64(&engine.requests, 1)
+		if engine.stallEvery > 0 && n%engine.stallEvery == 0 {
+			atomic.AddInt64(&engine.stalled, 1)
+			select {
+			case <-time.After(engine.stall):
+			case <-r.Context().Done():
+				atomic.AddInt64(&engine.cancelled, 1)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"result":true,"rule":["rule"],"data":null}`))
+	}))
+	t.Cleanup(engine.Close)
+	return engine
+}
+
+// p99 runs n sequential evaluations and returns the 99th percentile latency
+func p99(t *testing.T, c *PolicyClient, n int) time.Duration {
+	latencies := make([]time.Duration, n)
+	for i := range latencies {
+		start := time.Now()
+		response, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{"age": 70}, false)
+		require.NoError(t, err)
+		require.True(t, response.Result)
+		latencies[i] = time.Since(start)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies[(n*99+99)/100-1]
+}
+
+// TestHedgingImprovesTailLatency tests that hedges cut the tail caused by stalled requests and cancel the losers
+func TestHedgingImprovesTailLatency(t *testing.T) {
+	const stall = 250 * time.Millisecond
+
+	engine := newStallingEngine(t, 4, stall)
+	plain, err := New(engine.URL)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, p99(t, plain, 20), stall)
+
+	engine = newStallingEngine(t, 4, stall)
+	hedged, err := New(engine.URL, WithHedging(10*time.Millisecond, 1))
+	require.NoError(t, err)
+	assert.Less(t, p99(t, hedged, 20), stall/2)
+
+	// Every stalled request lost to its hedge and was cancelled, not left running
+	assert.Greater(t, atomic.LoadInt64(&engine.stalled), int64(0))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&engine.cancelled) == atomic.LoadInt64(&engine.stalled)
+	}, time.Second, 5*time.Millisecond)
+}
+
+// TestHedgingLimit tests that no more than maxHedges extra requests are sent
+func TestHedgingLimit(t *testing.T) {
+	engine := newStallingEngine(t, 1, 100*time.Millisecond)
+	c, err := New(engine.URL, WithHedging(10*time.Millisecond, 2))
+	require.NoError(t, err)
+
+	_, err = c.EvaluatePolicy(context.Background(), "rule", nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), atomic.LoadInt64(&engine.requests))
+	assert.Equal(t, int64(2), c.HedgedRequests())
+}
+
+// TestHedgingIsNotRetrying tests that a failure neither triggers a hedge nor waits for one
+func TestHedgingIsNotRetrying(t *testing.T) {
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL, WithHedging(time.Second, 3))
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = c.EvaluatePolicy(context.Background(), "rule", nil, false)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&requests))
+}
+
+// TestHedgingSkipsReaderData tests that data that can only be read once is never hedged
+func TestHedgingSkipsReaderData(t *testing.T) {
+	engine := newStallingEngine(t, 1, 50*time.Millisecond)
+	c, err := New(engine.URL, WithHedging(5*time.Millisecond, 2))
+	require.NoError(t, err)
+
+	_, err = c.EvaluatePolicy(context.Background(), "rule", strings.NewReader(`{"age":70}`), false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&engine.requests))
+}
+
+// TestHedgingMetrics tests that hedges and coalesced evaluations are counted
+// apart from evaluations in Prometheus and OpenTelemetry
+func TestHedgingMetrics(t *testing.T) {
+	engine := newStallingEngine(t, 1, 100*time.Millisecond)
+	reg := prometheus.NewRegistry()
+	metrics := sdkmetric.NewManualReader()
+	c, err := New(engine.URL, WithHedging(10*time.Millisecond, 2), WithCoalescing(),
+		WithPrometheus(reg), WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(metrics))))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{"age": 70}, false)
+			assert.NoError(t, err)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	require.Equal(t, int64(2), c.CoalescedEvaluations())
+	require.Equal(t, int64(2), c.HedgedRequests())
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP policy_coalesced_evaluations_total Policy evaluations answered by an identical evaluation's request.
+# TYPE policy_coalesced_evaluations_total counter
+policy_coalesced_evaluations_total 2
+# HELP policy_hedged_requests_total Hedged copies of policy evaluations sent while the first request was slow.
+# TYPE policy_hedged_requests_total counter
+policy_hedged_requests_total 2
+`), PrometheusHedges, PrometheusCoalesced))
+
+	var collected metricdata.ResourceMetrics
+	require.NoError(t, metrics.Collect(context.Background(), &collected))
+	counted := map[string]int64{}
+	for _, m := range collected.ScopeMetrics[0].Metrics {
+		if sum, ok := m.Data.(metricdata.Sum[int64]); ok {
+			for _, point := range sum.DataPoints {
+				counted[m.Name] += point.Value
+			}
+		}
+	}
+	assert.Equal(t, map[string]int64{MetricHedges: 2, MetricCoalesced: 2}, counted)
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Label returns whether the policy granted the label name, and whether the
+// response has the label at all
+func (r *PolicyResponse) Label(name string) (granted, ok bool) {
+	if r == nil {
+		return false, false
+	}
+	granted, ok = r.Labels[name]
+	return granted, ok
+}
+
+// GrantedLabels returns the labels the policy granted, sorted
+func (r *PolicyResponse) GrantedLabels() []string {
+	granted := []string{}
+	if r == nil {
+		return granted
+	}
+	for name, ok := range r.Labels {
+		if ok {
+			granted = append(granted, name)
+		}
+	}
+	sort.Strings(granted)
+	return granted
+}
+
+// LabelError is a label RequireLabel found missing or not granted
+type LabelError struct {
+	Label string
+	// Present is whether the response had the label at all, and Labels are
+	// the ones it had, sorted
+	Present bool
+	Labels  []string
+	// Summary is the failed condition of the label's rule, when the
+	// response has a trace recording one
+	Summary *TraceSummary
+}
+
+func (e *LabelError) Error() string {
+	if !e.Present {
+		labels := "none"
+		if len(e.Labels) > 0 {
+			labels = strings.Join(e.Labels, ", ")
+		}
+		return fmt.Sprintf("label %q is missing from the response (labels: %s)", e.Label, labels)
+	}
+	if e.Summary == nil {
+		return fmt.Sprintf("label %q was not granted", e.Label)
+	}
+	return fmt.Sprintf("label %q was not granted: %s", e.Label, e.Summary)
+}
+
+// RequireLabel returns nil if the policy granted the label name, and a
+// *LabelError saying why not otherwise, with the label's failed condition
+// when the evaluation asked for a trace:
+//
+//	assert.NoError(t, response.RequireLabel("senior_discount"))
+func (r *PolicyResponse) RequireLabel(name string) error {
+	granted, ok := r.Label(name)
+	if granted {
+		return nil
+	}
+	labelErr := &LabelError{Label: name, Present: ok, Labels: []string{}}
+	if r == nil {
+		return labelErr
+	}
+	for label := range r.Labels {
+		labelErr.Labels = append(labelErr.Labels, label)
+	}
+	sort.Strings(labelErr.Labels)
+	if ok && r.ExecutionTrace != nil {
+		for i, rule := range r.ExecutionTrace.Execution {
+			if rule.Label == name {
+				labelErr.Summary = r.ExecutionTrace.summarize(i)
+				break
+			}
+		}
+	}
+	return labelErr
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLabel tests the accessors on nil, missing, false and granted labels
+func TestLabel(t *testing.T) {
+	var none *PolicyResponse
+	granted, ok := none.Label("adult")
+	assert.False(t, granted)
+	assert.False(t, ok)
+	assert.Equal(t, []string{}, none.GrantedLabels())
+
+	response := &PolicyResponse{}
+	_, ok = response.Label("adult")
+	assert.False(t, ok, "nil Labels")
+	assert.Equal(t, []string{}, response.GrantedLabels())
+
+	response.Labels = map[string]bool{"vip": true, "adult": true, "senior": false}
+	granted, ok = response.Label("senior")
+	assert.False(t, granted)
+	assert.True(t, ok)
+	granted, ok = response.Label("adult")
+	assert.True(t, granted)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"adult", "vip"}, response.GrantedLabels())
+}
+
+// TestRequireLabel tests the errors for missing and refused labels, with the
+// refused label's failed condition when there is a trace
+func TestRequireLabel(t *testing.T) {
+	var labelErr *LabelError
+
+	err := (*PolicyResponse)(nil).RequireLabel("adult")
+	require.ErrorAs(t, err, &labelErr)
+	assert.False(t, labelErr.Present)
+	assert.EqualError(t, err, `label "adult" is missing from the response (labels: none)`)
+
+	response := &PolicyResponse{Labels: map[string]bool{"vip": true, "senior_discount": false}}
+	assert.NoError(t, response.RequireLabel("vip"))
+	assert.EqualError(t, response.RequireLabel("adult"), `label "adult" is missing from the response (labels: senior_discount, vip)`)
+	assert.EqualError(t, response.RequireLabel("senior_discount"), `label "senior_discount" was not granted`)
+
+	trace := engineTrace(40, 1)
+	trace["execution"].([]interface{})[1].(map[string]interface{})["label"] = "senior_discount"
+	raw, err := json.Marshal(trace)
+	require.NoError(t, err)
+	response.ExecutionTrace = decodeTrace(raw)
+	require.NotNil(t, response.ExecutionTrace)
+
+	err = response.RequireLabel("senior_discount")
+	require.ErrorAs(t, err, &labelErr)
+	assert.True(t, labelErr.Present)
+	require.NotNil(t, labelErr.Summary)
+	assert.Equal(t, "senior", labelErr.Summary.Rule)
+	assert.Empty(t, labelErr.Summary.Via, "the label's own rule, not the one referring to it")
+	assert.EqualError(t, err, `label "senior_discount" was not granted: senior failed: $.Person.age is 40, GreaterThanOrEqual 65 expected`)
+}
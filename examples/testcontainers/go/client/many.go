@@ -0,0 +1,226 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"policy-engine-testcontainer-example/policydata"
+)
+
+// NamedRule is one of the rules EvaluateMany evaluates, under the name its
+// result is keyed by. The name is also the policy name SelectPolicy profiles
+// match.
+type NamedRule struct {
+	Name string
+	Rule string
+}
+
+// EvaluateMany evaluates every rule against the same data document and
+// returns the responses keyed by rule name. The engine takes one rule per
+// request, so the rules fan out over the batch workers, but the data is
+// prepared and encoded once per client configuration, and every request sends
+// those same bytes. Context data is built once too, so every rule sees the
+// same request time. Reader data is read fully up front.
+//
+// BatchOptions other than the trace options apply as they do to
+// EvaluateBatch. The returned error is a *ManyError when any rule failed;
+// rules the engine rejected keep their response alongside the error.
+func (c *PolicyClient) EvaluateMany(ctx context.Context, rules []NamedRule, data interface{}, opts ...BatchOption) (map[string]*PolicyResponse, error) {
+	seen := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		switch {
+		case rule.Name == "":
+			return nil, fmt.Errorf("rule %d has no name", i)
+		case seen[rule.Name]:
+			return nil, fmt.Errorf("rule name %q is used more than once", rule.Name)
+		}
+		seen[rule.Name] = true
+	}
+
+	var cfg batchConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if r, ok := data.(io.Reader); ok {
+		read, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read data: %w", err)
+		}
+		data = json.RawMessage(read)
+	}
+
+	// Rules run on the client their profile selects, each of which may
+	// prepare and encode data its own way
+	targets := make([]*PolicyClient, len(rules))
+	encoded := map[*PolicyClient]interface{}{}
+	prepareErrs := map[*PolicyClient]error{}
+	for i, rule := range rules {
+		target := c.profiled(rule.Rule, rule.Name)
+		targets[i] = target
+		if _, done := encoded[target]; done {
+			continue
+		}
+		if _, failed := prepareErrs[target]; failed {
+			continue
+		}
+		raw, err := target.encodeOnce(ctx, data)
+		if err != nil {
+			prepareErrs[target] = err
+			continue
+		}
+		encoded[target] = raw
+	}
+
+	responses := make([]*PolicyResponse, len(rules))
+	ruleErrs, runErr := cfg.run(ctx, len(rules), func(ctx context.Context, i int) error {
+		if err := prepareErrs[targets[i]]; err != nil {
+			return err
+		}
+		req := PolicyRequest{Rule: rules[i].Rule, Data: encoded[targets[i]]}
+		response, err := c.evaluateRequest(ctx, req, false, rules[i].Name)
+		responses[i] = response
+		return err
+	})
+
+	results := make(map[string]*PolicyResponse, len(rules))
+	manyErr := &ManyError{Total: len(rules), Errs: map[string]error{}, Cause: runErr}
+	for i, rule := range rules {
+		if responses[i] != nil {
+			results[rule.Name] = responses[i]
+		}
+		if ruleErrs[i] != nil {
+			manyErr.Errs[rule.Name] = ruleErrs[i]
+		}
+	}
+	if len(manyErr.Errs) == 0 && runErr == nil {
+		return results, nil
+	}
+	return results, manyErr
+}
+
+// encodeOnce prepares data as an evaluation on c would and encodes it, so
+// each request can send the bytes as they are
+func (c *PolicyClient) encodeOnce(ctx context.Context, data interface{}) (rawJSON, error) {
+	prepared, err := c.prepareData(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	if c.body.canonical {
+		prepared, err = canonicalData(prepared)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if raw, ok := prepared.(rawJSON); ok {
+		return raw, nil
+	}
+	encoded, err := policydata.EncodeBytes(prepared, policydata.EncodeConfig{EscapeHTML: c.body.escapeHTML})
+	if err != nil {
+		return nil, dataError(err)
+	}
+	return rawJSON(encoded), nil
+}
+
+// ManyError is returned by EvaluateMany when any rule failed. Errs holds each
+// failed rule's error by name; errors.Is and errors.As see through to them
+// and to Cause.
+type ManyError struct {
+	Total int
+	Errs  map[string]error
+	// Cause is why the run stopped early: ctx's error, or an error wrapping
+	// batch.ErrTooManyFailures. Rules never started fail with
+	// ErrNotAttempted.
+	Cause error
+}
+
+func (e *ManyError) Error() string {
+	names := e.names()
+	msg := fmt.Sprintf("%d of %d rules failed", len(names), e.Total)
+	if e.Cause != nil {
+		msg = fmt.Sprintf("evaluation stopped early: %v; %s", e.Cause, msg)
+	}
+	if len(names) > 0 {
+		msg += fmt.Sprintf(": rule %q: %v", names[0], e.Errs[names[0]])
+	}
+	if len(names) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(names)-1)
+	}
+	return msg
+}
+
+func (e *ManyError) Unwrap() []error {
+	var errs []error
+	for _, name := range e.names() {
+		errs = append(errs, e.Errs[name])
+	}
+	if e.Cause != nil {
+		errs = append(errs, e.Cause)
+	}
+	return errs
+}
+
+// names returns the failed rules' names in order
+func (e *ManyError) names() []string {
+	names := make([]string, 0, len(e.Errs))
+	for name := range e.Errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LabelVerdict is one label's verdict across several responses
+type LabelVerdict struct {
+	// Granted is true if any rule granted the label
+	Granted bool
+	// GrantedBy and DeniedBy name the rules that granted and denied it, sorted
+	GrantedBy []string
+	DeniedBy  []string
+}
+
+// Conflicting reports whether rules disagree about the label
+func (v LabelVerdict) Conflicting() bool {
+	return len(v.GrantedBy) > 0 && len(v.DeniedBy) > 0
+}
+
+func (v LabelVerdict) String() string {
+	if !v.Granted {
+		return "denied by " + strings.Join(v.DeniedBy, ", ")
+	}
+	return "granted by " + strings.Join(v.GrantedBy, ", ")
+}
+
+// MergeLabels combines the labels of EvaluateMany's responses. A label is
+// granted if any rule granted it, so the outcome depends only on which rules
+// answered, not on the order they are listed or answered in; LabelVerdict
+// records who said what for callers that need a stricter rule. Nil responses
+// are skipped.
+func MergeLabels(responses map[string]*PolicyResponse) map[string]LabelVerdict {
+	merged := map[string]LabelVerdict{}
+	for name, response := range responses {
+		if response == nil {
+			continue
+		}
+		for label, granted := range response.Labels {
+			verdict := merged[label]
+			if granted {
+				verdict.Granted = true
+				verdict.GrantedBy = append(verdict.GrantedBy, name)
+			} else {
+				verdict.DeniedBy = append(verdict.DeniedBy, name)
+			}
+			merged[label] = verdict
+		}
+	}
+	for label, verdict := range merged {
+		sort.Strings(verdict.GrantedBy)
+		sort.Strings(verdict.DeniedBy)
+		merged[label] = verdict
+	}
+	return merged
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEvaluateMany tests that every rule gets the same prepared data, keyed
+// by name, with each profile preparing it its own way
+func TestEvaluateMany(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL,
+		WithBaseData(map[string]interface{}{"Tenant": "acme"}),
+		WithRuleProfile(SelectPolicy("tagged"), CallProfile{Options: profileTag("tagged")}),
+	)
+	require.NoError(t, err)
+
+	rules := []NamedRule{{Name: "a", Rule: "rule a"}, {Name: "b", Rule: "rule b"}, {Name: "tagged", Rule: "rule c"}}
+	responses, err := c.EvaluateMany(context.Background(), rules, strings.NewReader(`{"Person":{"age":70}}`), WithWorkers(2))
+	require.NoError(t, err)
+	require.Len(t, responses, 3)
+	for _, rule := range rules {
+		assert.Equal(t, []string{rule.Rule}, responses[rule.Name].Rule)
+	}
+
+	bodies := map[string]string{}
+	for i, req := range engine.Requests() {
+		bodies[req.Rule] = string(engine.Bodies()[i])
+	}
+	assert.Equal(t, strings.Replace(bodies["rule a"], "rule a", "rule b", 1), bodies["rule b"])
+	assert.Equal(t, map[string]interface{}{"Tenant": "acme", "Person": map[string]interface{}{"age": json.Number("70")}}, responses["a"].Data)
+	assert.Equal(t, "tagged", responses["tagged"].Data.(map[string]interface{})["Profile"])
+}
+
+// TestEvaluateManyErrors tests per-rule errors and name validation
+func TestEvaluateManyErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req PolicyRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasPrefix(req.Rule, "bad") {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"result":false,"rule":[],"error":{"code":"parse_error","message":"Parse error"}}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(PolicyResponse{Result: true, Rule: []string{req.Rule}})
+	}))
+	t.Cleanup(server.Close)
+	c, err := New(server.URL)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	responses, err := c.EvaluateMany(ctx, []NamedRule{{Name: "ok", Rule: "fine"}, {Name: "x", Rule: "bad x"}, {Name: "y", Rule: "bad y"}}, nil)
+	var manyErr *ManyError
+	require.True(t, errors.As(err, &manyErr), "got %v", err)
+	assert.Equal(t, 3, manyErr.Total)
+	assert.Len(t, manyErr.Errs, 2)
+	var engineErr *EngineError
+	assert.True(t, errors.As(manyErr.Errs["x"], &engineErr))
+	assert.ErrorContains(t, err, `2 of 3 rules failed: rule "x": `)
+	assert.ErrorContains(t, err, "(and 1 more)")
+	assert.True(t, responses["ok"].Result)
+	assert.NotNil(t, responses["x"])
+
+	_, err = c.EvaluateMany(ctx, []NamedRule{{Name: "a", Rule: "r"}, {Name: "a", Rule: "s"}}, nil)
+	assert.EqualError(t, err, `rule name "a" is used more than once`)
+	_, err = c.EvaluateMany(ctx, []NamedRule{{Rule: "r"}}, nil)
+	assert.EqualError(t, err, "rule 0 has no name")
+	_, err = c.EvaluateMany(ctx, []NamedRule{{Name: "a", Rule: "r"}}, []byte("{"))
+	var invalid *InvalidDataError
+	assert.True(t, errors.As(err, &invalid), "got %v", err)
+}
+
+// TestMergeLabels tests that a label any rule grants is granted, whatever
+// order the rules are in
+func TestMergeLabels(t *testing.T) {
+	responses := map[string]*PolicyResponse{
+		"discounts": {Labels: map[string]bool{"vip": true, "senior": false}},
+		"loyalty":   {Labels: map[string]bool{"vip": false}},
+		"seniors":   {Labels: map[string]bool{"senior": false}},
+		"sales":     {Labels: map[string]bool{"vip": true}},
+		"failed":    nil,
+	}
+	want := map[string]LabelVerdict{
+		"vip":    {Granted: true, GrantedBy: []string{"discounts", "sales"}, DeniedBy: []string{"loyalty"}},
+		"senior": {DeniedBy: []string{"discounts", "seniors"}},
+	}
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, want, MergeLabels(responses))
+	}
+
+	merged := MergeLabels(responses)
+	assert.True(t, merged["vip"].Conflicting())
+	assert.False(t, merged["senior"].Conflicting())
+	assert.Equal(t, "granted by discounts, sales", merged["vip"].String())
+	assert.Equal(t, "denied by discounts, seniors", merged["senior"].String())
+}
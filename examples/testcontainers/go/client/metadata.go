@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// MetadataHeaderPrefix starts the header each metadata key forwarded with
+// WithMetadataHeaders is sent under
+const MetadataHeaderPrefix = "X-Policy-Meta-"
+
+const (
+	defaultMaxMetadataKeys  = 16
+	defaultMaxMetadataBytes = 2048
+)
+
+// Metadata is what a caller says about an evaluation rather than what it is
+// evaluated on: who asked, from which request path, for what reason. It goes
+// to the audit record, and to the engine only as headers allowed by
+// WithMetadataHeaders, never into the request data, so rules cannot depend on
+// it.
+type Metadata map[string]string
+
+type metadataKey struct{}
+
+// ContextWithMetadata returns a copy of ctx whose evaluations carry md, on top
+// of any metadata ctx carries already; a key in both takes md's value. Keys
+// are letters, digits, '-', '_' and '.', and values must not hold control
+// characters. The evaluation fails with a *MetadataError if they do not, or if
+// the metadata is over the client's limits, see WithMetadataLimits.
+func ContextWithMetadata(ctx context.Context, md Metadata) context.Context {
+	merged := MetadataFromContext(ctx)
+	if merged == nil {
+		merged = make(Metadata, len(md))
+	}
+	for key, value := range md {
+		merged[key] = value
+	}
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+// MetadataFromContext returns a copy of the metadata ctx carries, or nil
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(metadataKey{}).(Metadata)
+	if md == nil {
+		return nil
+	}
+	copied := make(Metadata, len(md))
+	for key, value := range md {
+		copied[key] = value
+	}
+	return copied
+}
+
+// MetadataError is an evaluation's metadata failing the client's checks. Key
+// is the offending key for an invalid key or value, and empty when the
+// metadata as a whole is over a limit.
+type MetadataError struct {
+	Key    string
+	Reason string
+}
+
+func (e *MetadataError) Error() string {
+	if e.Key == "" {
+		return "invalid metadata: " + e.Reason
+	}
+	return fmt.Sprintf("invalid metadata %q: %s", e.Key, e.Reason)
+}
+
+type metadataLimits struct {
+	keys  int
+	bytes int
+}
+
+// WithMetadataLimits bounds the metadata an evaluation may carry to maxKeys
+// keys and maxBytes bytes of keys and values together, 16 and 2048 by
+// default; zero keeps a default
+func WithMetadataLimits(maxKeys, maxBytes int) Option {
+	return func(c *PolicyClient) {
+		c.metadataLimits = metadataLimits{keys: maxKeys, bytes: maxBytes}
+	}
+}
+
+// WithMetadataHeaders forwards the named metadata keys to the engine, each
+// under MetadataHeaderPrefix and the key, for its own logs. Keys not named
+// are never sent.
+func WithMetadataHeaders(keys ...string) Option {
+	return func(c *PolicyClient) {
+		c.metadataHeaders = append([]string(nil), keys...)
+	}
+}
+
+// checkMetadata returns ctx's metadata, or the *MetadataError it fails with
+func (c *PolicyClient) checkMetadata(ctx context.Context) (Metadata, error) {
+	md, _ := ctx.Value(metadataKey{}).(Metadata)
+	if len(md) == 0 {
+		return nil, nil
+	}
+	maxKeys, maxBytes := c.metadataLimits.keys, c.metadataLimits.bytes
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxMetadataKeys
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxMetadataBytes
+	}
+	if len(md) > maxKeys {
+		return nil, &MetadataError{Reason: fmt.Sprintf("%d keys, over the limit of %d", len(md), maxKeys)}
+	}
+
+	keys := make([]string, 0, len(md))
+	for key := range md {
+		keys = append(keys, key)
+	}
+	// Sorted so the same metadata always fails on the same key
+	sort.Strings(keys)
+	size := 0
+	for _, key := range keys {
+		if reason := invalidMetadataKey(key); reason != "" {
+			return nil, &MetadataError{Key: key, Reason: reason}
+		}
+		for _, r := range md[key] {
+			if r < 0x20 || r == 0x7f {
+				return nil, &MetadataError{Key: key, Reason: "value holds a control character"}
+			}
+		}
+		size += len(key) + len(md[key])
+	}
+	if size > maxBytes {
+		return nil, &MetadataError{Reason: fmt.Sprintf("%d bytes, over the limit of %d", size, maxBytes)}
+	}
+	return md, nil
+}
+
+// invalidMetadataKey says what is wrong with key, if anything
+func invalidMetadataKey(key string) string {
+	if key == "" {
+		return "key is empty"
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return fmt.Sprintf("key holds %q", r)
+		}
+	}
+	return ""
+}
+
+// setMetadataHeaders adds the forwarded keys of ctx's metadata to header
+func (c *PolicyClient) setMetadataHeaders(ctx context.Context, header http.Header) {
+	if len(c.metadataHeaders) == 0 {
+		return
+	}
+	md, _ := ctx.Value(metadataKey{}).(Metadata)
+	for _, key := range c.metadataHeaders {
+		if value, ok := md[key]; ok {
+			header.Set(MetadataHeaderPrefix+key, value)
+		}
+	}
+}
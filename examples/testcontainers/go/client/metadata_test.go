@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMetadataPropagation tests that metadata reaches the audit record and
+// the allowed headers, and never the request data
+func TestMetadataPropagation(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		headers []http.Header
+		bodies  []string
+	)
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		headers = append(headers, r.Header.Clone())
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"result":true,"rule":["rule"],"data":{}}`))
+	}))
+	t.Cleanup(engine.Close)
+
+	var audit auditRecords
+	c, err := New(engine.URL, WithAuditSink(&audit), WithMetadataHeaders("user_id", "request.path"))
+	require.NoError(t, err)
+
+	ctx := ContextWithMetadata(context.Background(), Metadata{"user_id": "u-1", "reason": "checkout"})
+	ctx = ContextWithMetadata(ctx, Metadata{"request.path": "/orders", "reason": "refund"})
+	_, err = c.EvaluatePolicy(ctx, "rule", map[string]interface{}{"order": 1}, false)
+	require.NoError(t, err)
+
+	records := audit.all()
+	require.Len(t, records, 1)
+	assert.Equal(t, Metadata{"user_id": "u-1", "request.path": "/orders", "reason": "refund"}, records[0].Metadata)
+	encoded, err := json.Marshal(records[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), `"metadata":{"reason":"refund","request.path":"/orders","user_id":"u-1"}`)
+
+	require.Len(t, headers, 1)
+	assert.Equal(t, "u-1", headers[0].Get(MetadataHeaderPrefix+"user_id"))
+	assert.Equal(t, "/orders", headers[0].Get(MetadataHeaderPrefix+"request.path"))
+	assert.Empty(t, headers[0].Get(MetadataHeaderPrefix+"reason"), "only allowed keys are forwarded")
+	for _, value := range []string{"u-1", "refund", "/orders"} {
+		assert.NotContains(t, bodies[0], value)
+	}
+
+	// The caller's map is not the context's
+	md := Metadata{"user_id": "u-2"}
+	ctx = ContextWithMetadata(context.Background(), md)
+	md["user_id"] = "changed"
+	assert.Equal(t, Metadata{"user_id": "u-2"}, MetadataFromContext(ctx))
+	assert.Nil(t, MetadataFromContext(context.Background()))
+}
+
+// TestMetadataLimits tests that metadata over the limits or with bad keys or
+// values fails the call before anything is sent
+func TestMetadataLimits(t *testing.T) {
+	engine := newFakeEngine(t)
+	var audit auditRecords
+	c, err := New(engine.URL, WithAuditSink(&audit), WithMetadataLimits(3, 32))
+	require.NoError(t, err)
+
+	for name, tt := range map[string]struct {
+		md   Metadata
+		want MetadataError
+	}{
+		"keys":    {Metadata{"a": "1", "b": "2", "c": "3", "d": "4"}, MetadataError{Reason: "4 keys, over the limit of 3"}},
+		"bytes":   {Metadata{"note": strings.Repeat("x", 29)}, MetadataError{Reason: "33 bytes, over the limit of 32"}},
+		"key":     {Metadata{"user id": "u-1"}, MetadataError{Key: "user id", Reason: `key holds ' '`}},
+		"empty":   {Metadata{"": "u-1"}, MetadataError{Key: "", Reason: "key is empty"}},
+		"control": {Metadata{"path": "/a\r\nX-Admin: 1"}, MetadataError{Key: "path", Reason: "value holds a control character"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := ContextWithMetadata(context.Background(), tt.md)
+			_, err := c.EvaluatePolicy(ctx, "rule", map[string]interface{}{}, false)
+			var mdErr *MetadataError
+			require.ErrorAs(t, err, &mdErr)
+			assert.Equal(t, tt.want, *mdErr)
+		})
+	}
+	assert.Empty(t, engine.Requests())
+	for _, record := range audit.all() {
+		assert.Nil(t, record.Metadata, "metadata failing the checks is not recorded")
+		assert.Contains(t, record.Error, "invalid metadata")
+	}
+
+	ctx := ContextWithMetadata(context.Background(), Metadata{"note": strings.Repeat("x", 28)})
+	_, err = c.EvaluatePolicy(ctx, "rule", map[string]interface{}{}, false)
+	assert.NoError(t, err, "exactly at the limit")
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Doer sends an HTTP request, as *http.Client does
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// DoerFunc is a function that is a Doer
+type DoerFunc func(req *http.Request) (*http.Response, error)
+
+// Do calls f(req)
+func (f DoerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Middleware wraps the Doer that sends the client's requests. It may change
+// the request, look at the response, or answer with an error without
+// calling next.
+type Middleware func(next Doer) Doer
+
+// WithMiddleware wraps every HTTP request the client sends, evaluations,
+// health checks and probes alike, in middlewares. The first is outermost, so
+// it sees the request first and the response last; several WithMiddleware
+// options add up in order. A retried or hedged evaluation goes through the
+// chain once per attempt.
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(c *PolicyClient) {
+		c.middlewares = append(c.middlewares, middlewares...)
+	}
+}
+
+// BeforeHook is called with every request before it is evaluated; an error
+// fails the evaluation without sending it
+type BeforeHook func(ctx context.Context, req PolicyRequest) error
+
+// AfterHook is called with every request once it has been evaluated, with
+// the response and error the caller gets
+type AfterHook func(ctx context.Context, req PolicyRequest, response *PolicyResponse, err error)
+
+// WithBeforeHook calls hook before every evaluation, in the order given
+func WithBeforeHook(hook BeforeHook) Option {
+	return func(c *PolicyClient) {
+		c.beforeHooks = append(c.beforeHooks, hook)
+	}
+}
+
+// WithAfterHook calls hook after every evaluation, in the order given
+func WithAfterHook(hook AfterHook) Option {
+	return func(c *PolicyClient) {
+		c.afterHooks = append(c.afterHooks, hook)
+	}
+}
+
+// configureMiddleware builds the chain requests are sent through
+func (c *PolicyClient) configureMiddleware() {
+	var doer Doer = c.batchRequests(c.httpClient)
+	if c.debugLogger != nil {
+		doer = c.logRequests(doer)
+	}
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		doer = c.middlewares[i](doer)
+	}
+	c.doer = doer
+}
+
+// do sends req, with the client's headers, through the client's middleware,
+// once the rate limiter lets it
+func (c *PolicyClient) do(req *http.Request) (*http.Response, error) {
+	if c.limiter != nil {
+		if err := c.limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	req = c.setHeaders(req)
+	if c.doer == nil {
+		return c.httpClient.Do(req)
+	}
+	return c.doer.Do(req)
+}
+
+// before runs the before hooks, stopping at the first error
+func (c *PolicyClient) before(ctx context.Context, req PolicyRequest) error {
+	for _, hook := range c.beforeHooks {
+		if err := hook(ctx, req); err != nil {
+			return fmt.Errorf("before hook failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// after runs the after hooks
+func (c *PolicyClient) after(ctx context.Context, req PolicyRequest, response *PolicyResponse, err error) {
+	for _, hook := range c.afterHooks {
+		hook(ctx, req, response, err)
+	}
+}
+
+// DebugLogging is a middleware writing a line to w for each request, the
+// record WithDebugLogging logs at info level in slog's text format: its
+// method, URL and headers, with credentials redacted, see RedactHeaders, and
+// the response's status or the error, with how long it took. Bodies are not
+// logged.
+func DebugLogging(w io.Writer) Middleware {
+	logger := &requestLogger{logger: slog.New(slog.NewTextHandler(w, nil))}
+	return logger.middleware
+}
+
+// StaticHeaders is a middleware setting header on every request, replacing
+// any value the client set, e.g. for an API key
+func StaticHeaders(header http.Header) Middleware {
+	header = header.Clone()
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			for name, values := range header {
+				req.Header[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+			}
+			return next.Do(req)
+		})
+	}
+}
+
+// formatHeaders is header on one line, sorted by name
+func formatHeaders(header http.Header) string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make([]string, len(names))
+	for i, name := range names {
+		fields[i] = name + ": " + strings.Join(header[name], ", ")
+	}
+	return "[" + strings.Join(fields, "; ") + "]"
+}
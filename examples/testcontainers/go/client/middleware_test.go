@@ -0,0 +1,138 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingMiddleware appends name's entry and exit to calls
+func recordingMiddleware(name string, calls *[]string) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			*calls = append(*calls, name+" in")
+			resp, err := next.Do(req)
+			*calls = append(*calls, name+" out")
+			return resp, err
+		})
+	}
+}
+
+// TestMiddlewareOrder tests that the first middleware is outermost, across
+// options, and that hooks run around the chain
+func TestMiddlewareOrder(t *testing.T) {
+	engine := newFakeEngine(t)
+	var calls []string
+	c, err := New(engine.URL,
+		WithMiddleware(recordingMiddleware("a", &calls), recordingMiddleware("b", &calls)),
+		WithMiddleware(recordingMiddleware("c", &calls)),
+		WithBeforeHook(func(_ context.Context, req PolicyRequest) error {
+			calls = append(calls, "before "+req.Rule)
+			return nil
+		}),
+		WithAfterHook(func(_ context.Context, req PolicyRequest, response *PolicyResponse, err error) {
+			assert.NoError(t, err)
+			assert.True(t, response.Result)
+			calls = append(calls, "after "+req.Rule)
+		}))
+	require.NoError(t, err)
+
+	_, err = c.Evaluate(context.Background(), PolicyRequest{Rule: "rule", Data: map[string]interface{}{}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"before rule", "a in", "b in", "c in", "c out", "b out", "a out", "after rule"}, calls)
+
+	calls = nil
+	require.NoError(t, c.Health(context.Background()))
+	assert.Equal(t, []string{"a in", "b in", "c in", "c out", "b out", "a out"}, calls, "health checks go through the chain too")
+}
+
+// TestMiddlewareRetries tests that every attempt of a retried evaluation goes
+// through the chain
+func TestMiddlewareRetries(t *testing.T) {
+	var attempts atomic.Int32
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"result": true}`))
+	}))
+	defer engine.Close()
+
+	var calls []string
+	c, err := New(engine.URL, WithRetry(3, time.Millisecond), WithMiddleware(recordingMiddleware("m", &calls)))
+	require.NoError(t, err)
+	_, err = c.Evaluate(context.Background(), PolicyRequest{Rule: "rule", Data: map[string]interface{}{}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"m in", "m out", "m in", "m out", "m in", "m out"}, calls)
+}
+
+// TestMiddlewareShortCircuit tests that a middleware or before hook failing
+// the request keeps it from the engine
+func TestMiddlewareShortCircuit(t *testing.T) {
+	errDenied := errors.New("no credentials")
+	engine := newFakeEngine(t)
+	deny := func(Doer) Doer {
+		return DoerFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errDenied
+		})
+	}
+	c, err := New(engine.URL, WithMiddleware(deny))
+	require.NoError(t, err)
+	_, err = c.Evaluate(context.Background(), PolicyRequest{Rule: "rule", Data: map[string]interface{}{}})
+	assert.ErrorIs(t, err, errDenied)
+
+	var afterErr error
+	c, err = New(engine.URL,
+		WithBeforeHook(func(context.Context, PolicyRequest) error { return errDenied }),
+		WithAfterHook(func(_ context.Context, _ PolicyRequest, _ *PolicyResponse, err error) { afterErr = err }))
+	require.NoError(t, err)
+	_, err = c.Evaluate(context.Background(), PolicyRequest{Rule: "rule", Data: map[string]interface{}{}})
+	assert.ErrorIs(t, err, errDenied)
+	assert.ErrorContains(t, err, "before hook failed")
+	assert.ErrorIs(t, afterErr, errDenied)
+	assert.Empty(t, engine.Requests())
+}
+
+// TestBuiltinMiddleware tests the debug logging and static header
+// middlewares
+func TestBuiltinMiddleware(t *testing.T) {
+	var apiKey string
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey = r.Header.Get("X-Api-Key")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer engine.Close()
+
+	var log bytes.Buffer
+	c, err := New(engine.URL, WithMiddleware(DebugLogging(&log), StaticHeaders(http.Header{"x-api-key": {"secret"}})))
+	require.NoError(t, err)
+	require.NoError(t, c.Health(context.Background()))
+	assert.Equal(t, "secret", apiKey)
+	assert.Contains(t, log.String(), "level=INFO msg=\"policy engine request\" method=GET url="+engine.URL+"/health headers=")
+	assert.Contains(t, log.String(), " status=200 duration=")
+	assert.NotContains(t, log.String(), "secret")
+}
+
+// TestMiddlewareClone tests that a clone keeps its original's middleware and
+// adds its own
+func TestMiddlewareClone(t *testing.T) {
+	engine := newFakeEngine(t)
+	var calls []string
+	c, err := New(engine.URL, WithMiddleware(recordingMiddleware("a", &calls)))
+	require.NoError(t, err)
+	clone, err := c.Clone(WithMiddleware(recordingMiddleware("b", &calls)))
+	require.NoError(t, err)
+
+	require.NoError(t, clone.Health(context.Background()))
+	assert.Equal(t, []string{"a in", "b in", "b out", "a out"}, calls)
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// maxOutcomeSnippet is how much of a non-boolean outcome BoolResult's error
+// quotes
+const maxOutcomeSnippet = 64
+
+// DecodeOption configures how DecodeResult and EvaluateInto decode an outcome
+type DecodeOption func(*decodeConfig)
+
+type decodeConfig struct {
+	disallowUnknown bool
+}
+
+// DisallowUnknownFields fails decoding an object outcome that has a field
+// the target type lacks, instead of ignoring it
+func DisallowUnknownFields() DecodeOption {
+	return func(c *decodeConfig) {
+		c.disallowUnknown = true
+	}
+}
+
+// BoolResult returns the outcome if it is a boolean, and an error naming what
+// it is otherwise. A response the failure policy answered, or one from a
+// mock, has Result as its outcome.
+func (r *PolicyResponse) BoolResult() (bool, error) {
+	if len(r.rawResult) == 0 {
+		return r.Result, nil
+	}
+	result, ok := boolOutcome(r.rawResult)
+	if !ok {
+		snippet := string(r.rawResult)
+		if len(snippet) > maxOutcomeSnippet {
+			snippet = snippet[:maxOutcomeSnippet] + "..."
+		}
+		return false, fmt.Errorf("result is not a boolean: %s", snippet)
+	}
+	return result, nil
+}
+
+// DecodeResult decodes the outcome into v, as json.Unmarshal would, except
+// that numbers decoded into an interface{} are json.Number
+func (r *PolicyResponse) DecodeResult(v interface{}, opts ...DecodeOption) error {
+	var cfg decodeConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	raw := r.rawResult
+	if len(raw) == 0 {
+		raw = []byte(strconv.FormatBool(r.Result))
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if cfg.disallowUnknown {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("failed to decode result into %T: %w", v, err)
+	}
+	return nil
+}
+
+// EvaluateInto evaluates rule against data and decodes the outcome into a T,
+// for rules whose outcome is a value, such as a computed discount, rather
+// than a boolean. The response is returned alongside; an engine error is
+// returned as the error, with the zero T.
+func EvaluateInto[T any](ctx context.Context, e Evaluator, rule string, data interface{}, opts ...DecodeOption) (T, *PolicyResponse, error) {
+	var outcome T
+	response, err := e.Evaluate(ctx, PolicyRequest{Rule: rule, Data: data})
+	if err != nil {
+		return outcome, response, err
+	}
+	if response.EngineError != nil {
+		return outcome, response, response.EngineError
+	}
+	if err := response.DecodeResult(&outcome, opts...); err != nil {
+		return outcome, response, err
+	}
+	return outcome, response, nil
+}
+
+// boolOutcome reads a boolean outcome; null or a missing one is false
+func boolOutcome(raw json.RawMessage) (result, ok bool) {
+	switch string(bytes.TrimSpace(raw)) {
+	case "true":
+		return true, true
+	case "false", "null", "":
+		return false, true
+	}
+	return false, false
+}
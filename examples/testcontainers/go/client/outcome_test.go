@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newOutcomeEngine is an engine answering every evaluation with result
+func newOutcomeEngine(t *testing.T, result string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"result": ` + result + `, "rule": ["rule"], "data": {}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+type discount struct {
+	Percent float64 `json:"percent"`
+	Reason  string  `json:"reason"`
+}
+
+// TestEvaluateIntoBool tests that a boolean outcome decodes and still sets
+// Result
+func TestEvaluateIntoBool(t *testing.T) {
+	c, err := New(newOutcomeEngine(t, "true").URL)
+	require.NoError(t, err)
+	outcome, response, err := EvaluateInto[bool](context.Background(), c, "rule", map[string]interface{}{})
+	require.NoError(t, err)
+	assert.True(t, outcome)
+	assert.True(t, response.Result)
+	result, err := response.BoolResult()
+	require.NoError(t, err)
+	assert.True(t, result)
+}
+
+// TestEvaluateIntoObject tests that an object outcome decodes into a struct
+// and is not mistaken for a boolean
+func TestEvaluateIntoObject(t *testing.T) {
+	c, err := New(newOutcomeEngine(t, `{"percent": 12.5, "reason": "loyalty"}`).URL)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	outcome, response, err := EvaluateInto[discount](ctx, c, "rule", map[string]interface{}{})
+	require.NoError(t, err)
+	assert.Equal(t, discount{Percent: 12.5, Reason: "loyalty"}, outcome)
+	assert.False(t, response.Result)
+	_, err = response.BoolResult()
+	assert.ErrorContains(t, err, `result is not a boolean: {"percent"`)
+
+	var fields map[string]interface{}
+	require.NoError(t, response.DecodeResult(&fields))
+	assert.Equal(t, "loyalty", fields["reason"])
+}
+
+// TestEvaluateIntoMismatch tests that an outcome that does not fit the type
+// fails naming the field
+func TestEvaluateIntoMismatch(t *testing.T) {
+	ctx := context.Background()
+
+	c, err := New(newOutcomeEngine(t, `{"percent": "twelve", "reason": "loyalty"}`).URL)
+	require.NoError(t, err)
+	_, _, err = EvaluateInto[discount](ctx, c, "rule", map[string]interface{}{})
+	assert.ErrorContains(t, err, "failed to decode result into *client.discount")
+	assert.ErrorContains(t, err, "percent")
+
+	c, err = New(newOutcomeEngine(t, `{"percent": 12.5, "reason": "loyalty", "tier": "gold"}`).URL)
+	require.NoError(t, err)
+	_, _, err = EvaluateInto[discount](ctx, c, "rule", map[string]interface{}{})
+	require.NoError(t, err, "unknown fields are ignored by default")
+	_, _, err = EvaluateInto[discount](ctx, c, "rule", map[string]interface{}{}, DisallowUnknownFields())
+	assert.ErrorContains(t, err, `unknown field "tier"`)
+}
+
+// TestBoolResultFallback tests that a response the failure policy made up
+// answers BoolResult from Result
+func TestBoolResultFallback(t *testing.T) {
+	response := &PolicyResponse{Result: true}
+	result, err := response.BoolResult()
+	require.NoError(t, err)
+	assert.True(t, result)
+	var outcome bool
+	require.NoError(t, response.DecodeResult(&outcome))
+	assert.True(t, outcome)
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"policy-engine-testcontainer-example/budget"
+)
+
+// PolicyStep is one evaluation in a policy set
+type PolicyStep struct {
+	// Name identifies the step in errors, and selects its profile for
+	// SelectPolicy
+	Name string
+	Rule string
+	// Data builds the step's data from the responses of the steps before
+	// it, e.g. to feed a label one rule decided into the next; nil sends an
+	// empty object
+	Data func(previous []*PolicyResponse) (interface{}, error)
+	// Weight is the step's share of the budget set with WithBudget, relative
+	// to the other steps; zero counts as 1
+	Weight float64
+}
+
+type policySetConfig struct {
+	budget time.Duration
+}
+
+// PolicySetOption configures EvaluatePolicySet
+type PolicySetOption func(*policySetConfig)
+
+// WithBudget bounds the whole policy set by total. Each step's deadline is
+// the time remaining split over the steps still to run by their weights, so a
+// slow step shrinks the deadlines after it and the last step gets whatever
+// remains. A step that runs out of time fails the set with a
+// *budget.ExhaustedError naming it.
+func WithBudget(total time.Duration) PolicySetOption {
+	return func(c *policySetConfig) {
+		c.budget = total
+	}
+}
+
+// EvaluatePolicySet evaluates steps one after another, each seeing the
+// responses of the steps before it. It stops at the first step that fails and
+// returns the responses of the steps that succeeded.
+func (c *PolicyClient) EvaluatePolicySet(ctx context.Context, steps []PolicyStep, opts ...PolicySetOption) ([]*PolicyResponse, error) {
+	var cfg policySetConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	var b *budget.Budget
+	if cfg.budget > 0 {
+		weights := make([]float64, len(steps))
+		for i, step := range steps {
+			weights[i] = step.Weight
+		}
+		b = budget.New(ctx, cfg.budget).Weights(weights...)
+	}
+
+	responses := make([]*PolicyResponse, 0, len(steps))
+	for i, step := range steps {
+		response, err := c.evaluateStep(ctx, b, step, responses)
+		if err != nil {
+			var exhausted *budget.ExhaustedError
+			if errors.As(err, &exhausted) {
+				return responses, err
+			}
+			return responses, fmt.Errorf("failed to evaluate step %d (%s): %w", i, step.Name, err)
+		}
+		responses = append(responses, response)
+	}
+	return responses, nil
+}
+
+// evaluateStep runs one step within its share of b, if there is a budget
+func (c *PolicyClient) evaluateStep(ctx context.Context, b *budget.Budget, step PolicyStep, previous []*PolicyResponse) (*PolicyResponse, error) {
+	// Each step is an evaluation of its own
+	ctx = withDecision(ctx, newDecision())
+	var data interface{} = map[string]interface{}{}
+	if step.Data != nil {
+		err := callback("policy set step data", func() (err error) {
+			data, err = step.Data(previous)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to build data: %w", err)
+		}
+	}
+	if b == nil {
+		return c.evaluateRequest(ctx, PolicyRequest{Rule: step.Rule, Data: data}, false, step.Name)
+	}
+
+	stepCtx, cancel, err := b.Next(step.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	response, err := c.evaluateRequest(stepCtx, PolicyRequest{Rule: step.Rule, Data: data}, false, step.Name)
+	return response, b.Exhausted(err)
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"policy-engine-testcontainer-example/budget"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSleepyEngine answers every rule after the delay its text names, e.g.
+// "sleep 50ms", labelling the response with the rule
+func newSleepyEngine(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req PolicyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if delay, err := time.ParseDuration(strings.TrimPrefix(req.Rule, "sleep ")); err == nil {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(PolicyResponse{Result: true, Labels: map[string]bool{req.Rule: true}, Rule: []string{req.Rule}, Data: req.Data})
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// TestEvaluatePolicySet tests that each step sees the responses before it
+func TestEvaluatePolicySet(t *testing.T) {
+	c, err := New(newSleepyEngine(t).URL)
+	require.NoError(t, err)
+
+	responses, err := c.EvaluatePolicySet(context.Background(), []PolicyStep{
+		{Name: "first", Rule: "sleep 0s"},
+		{Name: "second", Rule: "sleep 1ms", Data: func(previous []*PolicyResponse) (interface{}, error) {
+			return map[string]interface{}{"first": previous[0].Labels["sleep 0s"]}, nil
+		}},
+	}, WithBudget(time.Second))
+	require.NoError(t, err)
+	require.Len(t, responses, 2)
+	assert.Equal(t, map[string]interface{}{}, responses[0].Data)
+	assert.Equal(t, map[string]interface{}{"first": true}, responses[1].Data)
+}
+
+// TestEvaluatePolicySetBudget tests that a slow early step shrinks the deadline of the next one and the starved step is named
+func TestEvaluatePolicySetBudget(t *testing.T) {
+	c, err := New(newSleepyEngine(t).URL)
+	require.NoError(t, err)
+
+	// The first step gets 200ms of 400ms and uses 150ms, leaving 125ms for
+	// the second, which needs 150ms. Without the budget all three would fit
+	// the client's own timeout.
+	start := time.Now()
+	responses, err := c.EvaluatePolicySet(context.Background(), []PolicyStep{
+		{Name: "risk", Rule: "sleep 150ms", Weight: 2},
+		{Name: "limits", Rule: "sleep 150ms"},
+		{Name: "decision", Rule: "sleep 0s"},
+	}, WithBudget(400*time.Millisecond))
+	elapsed := time.Since(start)
+
+	var exhausted *budget.ExhaustedError
+	require.ErrorAs(t, err, &exhausted)
+	assert.Equal(t, "limits", exhausted.Step)
+	assert.Equal(t, 1, exhausted.Index)
+	assert.InDelta(t, 125*time.Millisecond, exhausted.Share, float64(30*time.Millisecond))
+	assert.ErrorIs(t, err, budget.ErrBudgetExhausted)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Len(t, responses, 1)
+	assert.Less(t, elapsed, 400*time.Millisecond)
+
+	// Without a budget the set just runs
+	responses, err = c.EvaluatePolicySet(context.Background(), []PolicyStep{
+		{Name: "risk", Rule: "sleep 150ms"},
+		{Name: "limits", Rule: "sleep 150ms"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, responses, 2)
+}
+
+// TestEvaluatePolicySetStepError tests that other failures name the step too
+func TestEvaluatePolicySetStepError(t *testing.T) {
+	c, err := New(newSleepyEngine(t).URL)
+	require.NoError(t, err)
+
+	responses, err := c.EvaluatePolicySet(context.Background(), []PolicyStep{
+		{Name: "first", Rule: "sleep 0s"},
+		{Name: "second", Rule: "sleep 0s", Data: func([]*PolicyResponse) (interface{}, error) {
+			return make(chan int), nil
+		}},
+	}, WithBudget(time.Second))
+	assert.EqualError(t, err, "failed to evaluate step 1 (second): unsupported request data type chan int")
+	assert.Len(t, responses, 1)
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newEchoEngine is an engine answering with the data it was sent, byte for
+// byte, and a trace reading the account ID from it
+func newEchoEngine(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Data json.RawMessage `json:"data"`
+		}
+		var account struct {
+			Account struct {
+				ID json.RawMessage `json:"id"`
+			}
+		}
+		if json.Unmarshal(body, &req) != nil || json.Unmarshal(req.Data, &account) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"result": true, "rule": ["rule"], "data": `+string(req.Data)+`,
+			"trace": {"execution": [{"selector": {"value": "Account"}, "outcome": {"value": "ok"}, "result": true,
+				"conditions": [{"selector": {"value": "Account"}, "property": {"value": `+string(account.Account.ID)+`, "path": "$.Account.id"},
+					"operator": "EqualTo", "value": {"value": 1, "type": "number"}, "result": true}]}]}}`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestNumericPrecision tests that integers beyond 2^53 and long decimals
+// reach the engine and come back from it with every digit
+func TestNumericPrecision(t *testing.T) {
+	c, err := New(newEchoEngine(t).URL)
+	require.NoError(t, err)
+	balance, ok := new(big.Int).SetString("123456789012345678901234567890", 10)
+	require.True(t, ok)
+	data := map[string]interface{}{
+		"Account": map[string]interface{}{
+			"id":      int64(9007199254740993),
+			"rate":    json.Number("0.12345678901234567891"),
+			"balance": balance,
+		},
+	}
+
+	response, err := c.EvaluatePolicy(context.Background(), "rule", data, true)
+	require.NoError(t, err)
+	account := response.Data.(map[string]interface{})["Account"].(map[string]interface{})
+	assert.Equal(t, json.Number("9007199254740993"), account["id"])
+	assert.Equal(t, json.Number("0.12345678901234567891"), account["rate"])
+	assert.Equal(t, json.Number("123456789012345678901234567890"), account["balance"])
+
+	id, ok := response.ExecutionTrace.Number("Account.id")
+	require.True(t, ok)
+	assert.Equal(t, json.Number("9007199254740993"), id)
+	value, err := id.Int64()
+	require.NoError(t, err)
+	assert.Equal(t, int64(9007199254740993), value)
+	assert.Equal(t, "9007199254740993", response.Trace["execution"].([]interface{})[0].(map[string]interface{})["conditions"].([]interface{})[0].(map[string]interface{})["property"].(map[string]interface{})["value"].(json.Number).String())
+
+	_, ok = response.ExecutionTrace.Number("Account.missing")
+	assert.False(t, ok)
+}
+
+// TestNumericPrecisionBaseData tests that numbers survive the data pipeline:
+// base data merging and canonical encoding
+func TestNumericPrecisionBaseData(t *testing.T) {
+	base := map[string]interface{}{"Account": map[string]interface{}{"id": int64(9007199254740993)}}
+	for name, opts := range map[string][]Option{
+		"merged":    {WithBaseData(base)},
+		"canonical": {WithBaseData(base), WithCanonicalEncoding()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := New(newEchoEngine(t).URL, opts...)
+			require.NoError(t, err)
+			response, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{"rate": json.Number("0.12345678901234567891")}, false)
+			require.NoError(t, err)
+			data := response.Data.(map[string]interface{})
+			assert.Equal(t, json.Number("9007199254740993"), data["Account"].(map[string]interface{})["id"])
+			assert.Equal(t, json.Number("0.12345678901234567891"), data["rate"])
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// PreparedPolicy evaluates one rule over and over without encoding it again.
+// The rule and trace flag are encoded once by Prepare; each evaluation only
+// encodes its data and splices it in, producing the same bytes Evaluate would.
+// A PreparedPolicy is safe for concurrent use.
+type PreparedPolicy struct {
+	// owner counts the evaluations for Shutdown; client, which may be one of
+	// owner's rule profiles, runs them
+	owner    *PolicyClient
+	client   *PolicyClient
+	rule     string
+	trace    bool
+	envelope envelope
+	key      ruleHash
+}
+
+type prepareConfig struct {
+	trace bool
+	name  string
+}
+
+// PrepareOption configures Prepare
+type PrepareOption func(*prepareConfig)
+
+// WithPreparedTrace asks for a trace with every evaluation of the prepared
+// policy
+func WithPreparedTrace() PrepareOption {
+	return func(c *prepareConfig) {
+		c.trace = true
+	}
+}
+
+// WithPolicyName registers the prepared policy as name, selecting the
+// profile given for SelectPolicy(name)
+func WithPolicyName(name string) PrepareOption {
+	return func(c *prepareConfig) {
+		c.name = name
+	}
+}
+
+// Prepare encodes the parts of a request to rule that never change. Base
+// data, transforms and context data still apply to every evaluation, since
+// they are merged into the data itself. The rule's profile, if it has one,
+// is resolved here once.
+func (c *PolicyClient) Prepare(rule string, opts ...PrepareOption) (*PreparedPolicy, error) {
+	var cfg prepareConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	target := c.profiled(rule, cfg.name)
+	trace := cfg.trace || target.alwaysTrace
+	env, err := newEnvelope(PolicyRequest{Rule: rule, Trace: trace}, target.body.escapeHTML)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	p := &PreparedPolicy{owner: c, client: target, rule: rule, trace: trace, envelope: env}
+	if target.latencies != nil {
+		p.key = ruleKey(rule)
+	}
+	return p, nil
+}
+
+// Rule returns the rule the policy evaluates
+func (p *PreparedPolicy) Rule() string {
+	return p.rule
+}
+
+// Evaluate evaluates the prepared rule against data, like Evaluate with a
+// PolicyRequest for the same rule and trace flag
+func (p *PreparedPolicy) Evaluate(ctx context.Context, data interface{}) (*PolicyResponse, error) {
+	end, err := p.owner.inFlight.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+
+	c := p.client
+	ctx, d := startDecision(ctx)
+	record := func(error) {}
+	switch {
+	case c.timeout > 0:
+		ctx, record = c.withFixedDeadline(ctx)
+	case c.latencies != nil:
+		ctx, record = c.withRuleDeadline(ctx, p.key)
+	}
+	response, err := p.evaluate(ctx, data)
+	record(err)
+	response, err = c.responseFailure(response, err)
+	return response, withDecisionID(err, d.id)
+}
+
+func (p *PreparedPolicy) evaluate(ctx context.Context, data interface{}) (*PolicyResponse, error) {
+	c := p.client
+	// A pinned evaluation time may rewrite the rule, so the envelope
+	// encoded for it cannot be reused
+	if _, ok := EvaluationTimeFromContext(ctx); ok {
+		return c.evaluate(ctx, PolicyRequest{Rule: p.rule, Data: data, Trace: p.trace}, false)
+	}
+	data, err := c.prepareData(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	send := func(ctx context.Context) (*PolicyResponse, error) {
+		body, err := newEnvelopeBody(p.envelope, data, c.bodyOptions(ctx))
+		if err != nil {
+			return nil, err
+		}
+		return c.sendBody(ctx, body, false)
+	}
+	if c.coalescer != nil {
+		if key, ok := coalesceKey(PolicyRequest{Rule: p.rule, Data: data, Trace: p.trace}, false); ok {
+			return c.coalescer.do(ctx, key, send, c.countCoalesced(ctx))
+		}
+	}
+	return send(ctx)
+}
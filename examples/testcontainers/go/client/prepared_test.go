@@ -0,0 +1,183 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// largeRule is a rule of about 4KB, the size that makes re-encoding it
+// per request show up in profiles
+var largeRule = func() string {
+	var b strings.Builder
+	b.WriteString("# Eligibility\n\nA **Person** gets eligible if all of the following are true:\n")
+	for i := 0; b.Len() < 4096; i++ {
+		b.WriteString("  - the __score_")
+		b.WriteString(strings.Repeat("x", i%7))
+		b.WriteString("__ of the **Person** is greater than or equal to 10 \"quoted\" <tag> & more\n")
+	}
+	return b.String()
+}()
+
+// TestPreparedPolicyMatchesEvaluate tests that prepared requests are byte-identical to the naive path
+func TestPreparedPolicyMatchesEvaluate(t *testing.T) {
+	datas := []interface{}{
+		map[string]interface{}{"Person": map[string]interface{}{"age": 70, "name": "Ada"}},
+		orderHistoryStruct(3),
+		[]byte(`{"raw": [1, 2, 3]}`),
+		nil,
+		orderHistory(4000),
+	}
+
+	for _, trace := range []bool{false, true} {
+		for _, threshold := range []int{0, 1024} {
+			engine := newFakeEngine(t)
+			c, err := New(engine.URL, WithStreamingThreshold(threshold), WithBaseData(map[string]interface{}{"Tenant": "acme"}))
+			require.NoError(t, err)
+
+			var opts []PrepareOption
+			if trace {
+				opts = append(opts, WithPreparedTrace())
+			}
+			prepared, err := c.Prepare(largeRule, opts...)
+			require.NoError(t, err)
+			assert.Equal(t, largeRule, prepared.Rule())
+
+			for _, data := range datas {
+				// The same decision ID keeps the responses comparable
+				ctx := ContextWithDecisionID(context.Background(), NewDecisionID())
+				naive, err := c.EvaluatePolicy(ctx, largeRule, data, trace)
+				require.NoError(t, err)
+				fast, err := prepared.Evaluate(ctx, data)
+				require.NoError(t, err)
+				assert.Equal(t, naive, fast)
+			}
+
+			bodies := engine.Bodies()
+			require.Len(t, bodies, 2*len(datas))
+			for i := 0; i < len(bodies); i += 2 {
+				assert.True(t, bytes.Equal(bodies[i], bodies[i+1]), "trace %v, threshold %d, item %d:\n%s\n%s",
+					trace, threshold, i/2, bodies[i], bodies[i+1])
+			}
+		}
+	}
+}
+
+// TestPreparedPolicyConcurrent tests that one prepared policy serves concurrent evaluations
+func TestPreparedPolicyConcurrent(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL, WithAdaptiveTimeout(AdaptiveConfig{}))
+	require.NoError(t, err)
+	prepared, err := c.Prepare("rule")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			response, err := prepared.Evaluate(context.Background(), map[string]interface{}{"n": i})
+			if assert.NoError(t, err) {
+				assert.Equal(t, map[string]interface{}{"n": json.Number(fmt.Sprint(i))}, response.Data)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, req := range engine.Requests() {
+		assert.Equal(t, "rule", req.Rule)
+	}
+	assert.Len(t, engine.Requests(), 16)
+}
+
+// TestPreparedPolicyRejectsBadData tests that data errors surface as they do from Evaluate
+func TestPreparedPolicyRejectsBadData(t *testing.T) {
+	c, err := New("http://127.0.0.1:1")
+	require.NoError(t, err)
+	prepared, err := c.Prepare("rule")
+	require.NoError(t, err)
+
+	_, err = prepared.Evaluate(context.Background(), make(chan int))
+	var unsupported *UnsupportedDataError
+	assert.ErrorAs(t, err, &unsupported)
+}
+
+// BenchmarkPreparedEncoding compares encoding a request for a 4KB rule from
+// scratch with splicing the data into a prepared envelope
+func BenchmarkPreparedEncoding(b *testing.B) {
+	data := map[string]interface{}{"Person": map[string]interface{}{"age": 70, "name": "Ada"}}
+
+	b.Run("naive", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			body, err := newRequestBody(PolicyRequest{Rule: largeRule, Data: data}, bodyOptions{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			body.release()
+		}
+	})
+
+	env, err := newEnvelope(PolicyRequest{Rule: largeRule}, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("prepared", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			body, err := newEnvelopeBody(env, data, bodyOptions{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			body.release()
+		}
+	})
+}
+
+// BenchmarkPreparedEvaluate compares whole evaluations of a 4KB rule against a
+// fake engine with and without preparing it
+func BenchmarkPreparedEvaluate(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"result":true,"rule":["rule"],"data":{"age":70}}`)
+	}))
+	defer server.Close()
+	c, err := New(server.URL)
+	if err != nil {
+		b.Fatal(err)
+	}
+	prepared, err := c.Prepare(largeRule)
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := map[string]interface{}{"age": 70}
+	ctx := context.Background()
+
+	b.Run("naive", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := c.EvaluatePolicy(ctx, largeRule, data, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("prepared", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := prepared.Evaluate(ctx, data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RuleSelector picks the evaluations a CallProfile applies to; see
+// SelectRule, SelectPolicy and SelectLabel
+type RuleSelector struct {
+	kind  selectorKind
+	hash  ruleHash
+	value string
+}
+
+// selectorKind orders selectors from least to most specific
+type selectorKind int
+
+const (
+	selectLabel selectorKind = iota
+	selectPolicy
+	selectRule
+)
+
+// SelectRule selects the evaluations of exactly rule, told apart by a hash
+// of its text
+func SelectRule(rule string) RuleSelector {
+	return RuleSelector{kind: selectRule, hash: ruleKey(rule)}
+}
+
+// SelectPolicy selects the evaluations of the policy registered as name: a
+// PreparedPolicy prepared WithPolicyName(name), or a PolicyStep of that Name
+func SelectPolicy(name string) RuleSelector {
+	return RuleSelector{kind: selectPolicy, value: name}
+}
+
+// SelectLabel selects the rules carrying label, the name a rule is given
+// ahead of it as in "senior.discount. A **Person** gets ..." and under which
+// the engine reports its result in PolicyResponse.Labels
+func SelectLabel(label string) RuleSelector {
+	return RuleSelector{kind: selectLabel, value: label}
+}
+
+// CallProfile overrides the client's configuration for the evaluations a
+// RuleSelector picks
+type CallProfile struct {
+	// Timeout bounds each evaluation in place of WithAdaptiveTimeout; zero
+	// keeps the client's deadline. A sooner deadline on the caller's context
+	// still applies.
+	Timeout time.Duration
+	// Trace asks for a trace as though every request set Trace
+	Trace bool
+	// Options are applied on top of the client's own, as Clone applies them,
+	// e.g. WithHedging to hedge only these evaluations or WithCoalescing to
+	// coalesce them. Connection options fail New.
+	Options []Option
+}
+
+// WithRuleProfile applies profile to the evaluations selector picks. When
+// several profiles match, a SelectRule profile beats a SelectPolicy one,
+// which beats a SelectLabel one; between selectors of one kind, the profile
+// given last wins. Profiles are resolved once per rule for a PreparedPolicy
+// and by map lookups for other evaluations.
+func WithRuleProfile(selector RuleSelector, profile CallProfile) Option {
+	return func(c *PolicyClient) {
+		c.profileSpecs = append(c.profileSpecs, profileSpec{selector: selector, profile: profile})
+	}
+}
+
+type profileSpec struct {
+	selector RuleSelector
+	profile  CallProfile
+}
+
+// profiledClient is the client a profile's evaluations run on, and the
+// order its profile was given in
+type profiledClient struct {
+	client *PolicyClient
+	order  int
+}
+
+// profiles index the profiled clients by what selects them
+type profiles struct {
+	byRule   map[ruleHash]profiledClient
+	byPolicy map[string]profiledClient
+	byLabel  map[string]profiledClient
+}
+
+// buildProfiles builds a client for each profile WithRuleProfile gave
+func (c *PolicyClient) buildProfiles() error {
+	if len(c.profileSpecs) == 0 {
+		return nil
+	}
+	p := &profiles{
+		byRule:   map[ruleHash]profiledClient{},
+		byPolicy: map[string]profiledClient{},
+		byLabel:  map[string]profiledClient{},
+	}
+	for order, spec := range c.profileSpecs {
+		profiled, err := c.clone(spec.profile.Options)
+		if err != nil {
+			return fmt.Errorf("invalid rule profile: %w", err)
+		}
+		profiled.profileSpecs = nil
+		profiled.stats = c.stats
+		if spec.profile.Timeout > 0 {
+			profiled.latencies = nil
+			profiled.timeout = spec.profile.Timeout
+		}
+		profiled.alwaysTrace = spec.profile.Trace
+
+		entry := profiledClient{client: profiled, order: order}
+		switch spec.selector.kind {
+		case selectRule:
+			p.byRule[spec.selector.hash] = entry
+		case selectPolicy:
+			p.byPolicy[spec.selector.value] = entry
+		default:
+			p.byLabel[spec.selector.value] = entry
+		}
+	}
+	c.profiles = p
+	return nil
+}
+
+// profiled returns the client evaluations of rule, registered as policy if
+// that is not empty, run on: one built for a matching profile, or c
+func (c *PolicyClient) profiled(rule, policy string) *PolicyClient {
+	p := c.profiles
+	if p == nil {
+		return c
+	}
+	if len(p.byRule) > 0 {
+		if entry, ok := p.byRule[ruleKey(rule)]; ok {
+			return entry.client
+		}
+	}
+	if entry, ok := p.byPolicy[policy]; ok && policy != "" {
+		return entry.client
+	}
+	if len(p.byLabel) > 0 {
+		best := profiledClient{client: c, order: -1}
+		for _, label := range ruleLabels(rule) {
+			if entry, ok := p.byLabel[label]; ok && entry.order > best.order {
+				best = entry
+			}
+		}
+		return best.client
+	}
+	return c
+}
+
+// ruleLabels lists the labels of rule's rules: a dotted name ending in ". "
+// at the start of a line, ahead of the rule's first object
+func ruleLabels(rule string) []string {
+	var labels []string
+	for _, line := range strings.Split(rule, "\n") {
+		line = strings.TrimSpace(line)
+		label, rest, ok := strings.Cut(line, ". ")
+		if !ok || !isLabel(label) {
+			continue
+		}
+		rest = strings.TrimSpace(rest)
+		for _, article := range []string{"A ", "An ", "The "} {
+			rest = strings.TrimPrefix(rest, article)
+		}
+		if strings.HasPrefix(rest, "**") {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+func isLabel(s string) bool {
+	if s == "" || s[0] == '.' {
+		return false
+	}
+	for _, r := range s {
+		if r != '.' && r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// withFixedDeadline applies a profile's timeout to ctx
+func (c *PolicyClient) withFixedDeadline(ctx context.Context) (context.Context, func(error)) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	return ctx, func(error) { cancel() }
+}
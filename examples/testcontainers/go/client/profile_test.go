@@ -0,0 +1,160 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	seniorRule = "senior.discount. A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+	vipRule    = seniorRule + "\nvip. A **Person** gets vip if the __spend__ of the **Person** is greater than 1000."
+)
+
+// profileTag gives a profile base data naming it, so the engine shows which
+// profile an evaluation ran under
+func profileTag(name string) []Option {
+	return []Option{WithBaseData(map[string]interface{}{"Profile": name})}
+}
+
+// TestRuleProfilePrecedence tests which profile an evaluation gets when
+// several match
+func TestRuleProfilePrecedence(t *testing.T) {
+	engine := newFakeEngine(t)
+	exact := seniorRule + " "
+	c, err := New(engine.URL,
+		WithRuleProfile(SelectLabel("senior.discount"), CallProfile{Options: profileTag("label")}),
+		WithRuleProfile(SelectLabel("vip"), CallProfile{Options: profileTag("later label")}),
+		WithRuleProfile(SelectPolicy("seniors"), CallProfile{Options: profileTag("policy")}),
+		WithRuleProfile(SelectRule(exact), CallProfile{Options: profileTag("rule"), Trace: true}),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = c.EvaluatePolicy(ctx, seniorRule, nil, false)
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(ctx, vipRule, nil, false)
+	require.NoError(t, err)
+	prepared, err := c.Prepare(seniorRule, WithPolicyName("seniors"))
+	require.NoError(t, err)
+	_, err = prepared.Evaluate(ctx, nil)
+	require.NoError(t, err)
+	prepared, err = c.Prepare(exact, WithPolicyName("seniors"))
+	require.NoError(t, err)
+	_, err = prepared.Evaluate(ctx, nil)
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicySet(ctx, []PolicyStep{{Name: "seniors", Rule: "A **Person** gets other if ..."}})
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(ctx, "A **Person** gets other if ...", nil, false)
+	require.NoError(t, err)
+
+	var got []interface{}
+	var traced []bool
+	for _, req := range engine.Requests() {
+		data, _ := req.Data.(map[string]interface{})
+		got = append(got, data["Profile"])
+		traced = append(traced, req.Trace)
+	}
+	assert.Equal(t, []interface{}{"label", "later label", "policy", "rule", "policy", nil}, got)
+	assert.Equal(t, []bool{false, false, false, true, false, false}, traced)
+}
+
+// TestRuleProfileMixedWorkload tests two rules getting different hedging,
+// coalescing and timeouts from their profiles against one engine
+func TestRuleProfileMixedWorkload(t *testing.T) {
+	const (
+		fraudRule = "fraud.check. A **Payment** gets fraud_check if the __amount__ of the **Payment** is greater than 1000."
+		uiRule    = "A **User** gets beta_banner if the __cohort__ of the **User** is equal to \"beta\"."
+		slowRule  = "A **Report** gets export if the __rows__ of the **Report** is less than 100."
+	)
+	var mu sync.Mutex
+	requests := map[string]int{}
+	var stalled atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req PolicyRequest
+		_ = json.Unmarshal(body, &req)
+		mu.Lock()
+		requests[req.Rule]++
+		first := requests[req.Rule] == 1
+		mu.Unlock()
+
+		// The first fraud check stalls until hedged; UI and export requests
+		// are always slow
+		switch {
+		case req.Rule == fraudRule && first && stalled.CompareAndSwap(false, true):
+			<-r.Context().Done()
+			return
+		case req.Rule == uiRule:
+			time.Sleep(50 * time.Millisecond)
+		case req.Rule == slowRule:
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"result":true,"rule":["rule"],"data":{}}`))
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL,
+		WithRuleProfile(SelectLabel("fraud.check"), CallProfile{Timeout: 5 * time.Second, Options: []Option{WithHedging(10*time.Millisecond, 1)}}),
+		WithRuleProfile(SelectRule(uiRule), CallProfile{Options: []Option{WithCoalescing()}}),
+		WithRuleProfile(SelectRule(slowRule), CallProfile{Timeout: 20 * time.Millisecond}),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = c.EvaluatePolicy(ctx, fraudRule, nil, false)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.EvaluatePolicy(ctx, uiRule, map[string]interface{}{"User": map[string]interface{}{"cohort": "beta"}}, false)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	_, err = c.EvaluatePolicy(ctx, slowRule, nil, false)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The fraud check was hedged and the UI calls coalesced, not the other
+	// way round
+	assert.Equal(t, 2, requests[fraudRule])
+	assert.Equal(t, 1, requests[uiRule])
+	assert.Equal(t, 1, requests[slowRule])
+}
+
+// TestRuleProfileRejectsConnectionOptions tests that a profile cannot change
+// the connections it shares with the client
+func TestRuleProfileRejectsConnectionOptions(t *testing.T) {
+	_, err := New("http://localhost:1", WithRuleProfile(SelectLabel("x"), CallProfile{Options: []Option{WithWarmup(1)}}))
+	assert.True(t, errors.Is(err, errCloneConnections), "got %v", err)
+}
+
+// TestRuleLabels tests reading the labels a rule gives
+func TestRuleLabels(t *testing.T) {
+	assert.Equal(t, []string{"senior.discount"}, ruleLabels(seniorRule))
+	assert.Equal(t, []string{"senior.discount", "vip"}, ruleLabels(vipRule))
+	assert.Equal(t, []string{"driver.test"}, ruleLabels("A **driver** gets a licence\n  if §driver.test is valid.\n\n  driver.test. A **driver** passes the age test\n  if __age__ of **driver** is at least 18."))
+	assert.Empty(t, ruleLabels("A **Person** gets senior_discount if the __age__ of the **Person** is greater than 65."))
+	assert.Empty(t, ruleLabels("no labels here"))
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The client's Prometheus metrics. Their names and labels are stable: add
+// to them rather than change them, as dashboards and alerts select on them.
+const (
+	// PrometheusEvaluations counts evaluations by result, "true", "false"
+	// or "error", and error_type, as AttributeErrorType classifies the
+	// error and empty on success
+	PrometheusEvaluations = "policy_evaluations_total"
+	// PrometheusDuration is a histogram of evaluation latency in seconds
+	PrometheusDuration = "policy_evaluation_duration_seconds"
+	// PrometheusCacheHits counts evaluations answered from the cache
+	PrometheusCacheHits = "policy_cache_hits_total"
+	// PrometheusRetries counts attempts made after an evaluation's first
+	PrometheusRetries = "policy_retry_attempts_total"
+	// PrometheusHedges counts the hedges WithHedging sends
+	PrometheusHedges = "policy_hedged_requests_total"
+	// PrometheusCoalesced counts evaluations WithCoalescing answered with
+	// another caller's request
+	PrometheusCoalesced = "policy_coalesced_evaluations_total"
+)
+
+// WithPrometheus registers the client's metrics with reg and keeps them up
+// to date: policy_evaluations_total, policy_evaluation_duration_seconds,
+// policy_cache_hits_total, policy_retry_attempts_total,
+// policy_hedged_requests_total and policy_coalesced_evaluations_total. Clients
+// registering with the same registry, such as clones and rule profiles,
+// share the metrics already registered there rather than failing. Without
+// it the client exports nothing to Prometheus.
+func WithPrometheus(reg prometheus.Registerer) Option {
+	return func(c *PolicyClient) {
+		c.prometheusRegisterer = reg
+	}
+}
+
+// promMetrics are the collectors WithPrometheus registers; a nil
+// *promMetrics records nothing
+type promMetrics struct {
+	evaluations *prometheus.CounterVec
+	duration    prometheus.Histogram
+	cacheHits   prometheus.Counter
+	retries     prometheus.Counter
+	hedges      prometheus.Counter
+	coalesced   prometheus.Counter
+}
+
+// configurePrometheus registers the client's collectors, reusing those
+// already registered under the same names
+func (c *PolicyClient) configurePrometheus() error {
+	c.prometheus = nil
+	if c.prometheusRegisterer == nil {
+		return nil
+	}
+	m := &promMetrics{
+		evaluations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: PrometheusEvaluations,
+			Help: `Policy evaluations by result ("true", "false" or "error") and error_type (empty on success).`,
+		}, []string{"result", "error_type"}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    PrometheusDuration,
+			Help:    "Latency of policy evaluations in seconds, cache hits included.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: PrometheusCacheHits,
+			Help: "Policy evaluations answered from the client's cache, fresh or stale.",
+		}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: PrometheusRetries,
+			Help: "Attempts made to evaluate a policy after the first failed.",
+		}),
+		hedges: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: PrometheusHedges,
+			Help: "Hedged copies of policy evaluations sent while the first request was slow.",
+		}),
+		coalesced: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: PrometheusCoalesced,
+			Help: "Policy evaluations answered by an identical evaluation's request.",
+		}),
+	}
+	var err error
+	if m.evaluations, err = register(c.prometheusRegisterer, m.evaluations); err != nil {
+		return err
+	}
+	if m.duration, err = register(c.prometheusRegisterer, m.duration); err != nil {
+		return err
+	}
+	if m.cacheHits, err = register(c.prometheusRegisterer, m.cacheHits); err != nil {
+		return err
+	}
+	if m.retries, err = register(c.prometheusRegisterer, m.retries); err != nil {
+		return err
+	}
+	if m.hedges, err = register(c.prometheusRegisterer, m.hedges); err != nil {
+		return err
+	}
+	if m.coalesced, err = register(c.prometheusRegisterer, m.coalesced); err != nil {
+		return err
+	}
+	c.prometheus = m
+	return nil
+}
+
+// register registers collector with reg, or returns the collector of the
+// same kind already registered in its place
+func register[C prometheus.Collector](reg prometheus.Registerer, collector C) (C, error) {
+	err := reg.Register(collector)
+	if err == nil {
+		return collector, nil
+	}
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(C); ok {
+			return existing, nil
+		}
+	}
+	return collector, fmt.Errorf("failed to register Prometheus metrics: %w", err)
+}
+
+// observe records an evaluation that took elapsed
+func (m *promMetrics) observe(elapsed time.Duration, response *PolicyResponse, err error) {
+	if m == nil {
+		return
+	}
+	result, kind := "error", ""
+	switch {
+	case err != nil:
+		kind = errorType(err)
+	case response != nil:
+		result = strconv.FormatBool(response.Result)
+	}
+	m.evaluations.WithLabelValues(result, kind).Inc()
+	m.duration.Observe(elapsed.Seconds())
+}
+
+// cacheHit counts an evaluation answered from the cache
+func (m *promMetrics) cacheHit() {
+	if m != nil {
+		m.cacheHits.Inc()
+	}
+}
+
+// retry counts an attempt after the first
+func (m *promMetrics) retry() {
+	if m != nil {
+		m.retries.Inc()
+	}
+}
+
+// hedge counts a hedged request
+func (m *promMetrics) hedge() {
+	if m != nil {
+		m.hedges.Inc()
+	}
+}
+
+// coalesce counts an evaluation answered by another's request
+func (m *promMetrics) coalesce() {
+	if m != nil {
+		m.coalesced.Inc()
+	}
+}
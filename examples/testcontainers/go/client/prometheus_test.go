@@ -0,0 +1,83 @@
+package client_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/enginetest"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The test is external so that it can use the fake engine, which imports
+// the client
+
+const accessRule = `A **User** gets access if the __role__ of the **User** is equal to "admin".`
+
+// TestPrometheus tests the metrics a registry scrapes after evaluations that
+// pass, fail, hit the cache and error
+func TestPrometheus(t *testing.T) {
+	server := enginetest.NewFakeServer()
+	defer server.Close()
+	reg := prometheus.NewRegistry()
+	c, err := client.New(server.URL, client.WithPrometheus(reg), client.WithCache(client.NewMemoryCache(), time.Minute))
+	require.NoError(t, err)
+	defer c.Close()
+	ctx := context.Background()
+
+	role := func(role string) interface{} {
+		return map[string]interface{}{"User": map[string]interface{}{"role": role}}
+	}
+	for _, data := range []interface{}{role("admin"), role("admin"), role("guest"), map[string]interface{}{"User": map[string]interface{}{}}} {
+		_, _ = c.Evaluate(ctx, client.PolicyRequest{Rule: accessRule, Data: data})
+	}
+
+	// A second client on the registry shares its metrics
+	down := enginetest.NewFakeServer()
+	down.Close()
+	retrying, err := client.New(down.URL, client.WithPrometheus(reg), client.WithRetry(2, time.Millisecond))
+	require.NoError(t, err, "registering twice is not an error")
+	defer retrying.Close()
+	_, err = retrying.Evaluate(ctx, client.PolicyRequest{Rule: accessRule, Data: role("admin")})
+	require.Error(t, err)
+
+	expected := `
+# HELP policy_cache_hits_total Policy evaluations answered from the client's cache, fresh or stale.
+# TYPE policy_cache_hits_total counter
+policy_cache_hits_total 1
+# HELP policy_evaluations_total Policy evaluations by result ("true", "false" or "error") and error_type (empty on success).
+# TYPE policy_evaluations_total counter
+policy_evaluations_total{error_type="",result="false"} 1
+policy_evaluations_total{error_type="",result="true"} 2
+policy_evaluations_total{error_type="engine_error",result="error"} 1
+policy_evaluations_total{error_type="transport",result="error"} 1
+# HELP policy_retry_attempts_total Attempts made to evaluate a policy after the first failed.
+# TYPE policy_retry_attempts_total counter
+policy_retry_attempts_total 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		client.PrometheusEvaluations, client.PrometheusCacheHits, client.PrometheusRetries))
+
+	count, err := testutil.GatherAndCount(reg, client.PrometheusDuration)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "the histogram has no labels")
+
+	cloned, err := c.Clone()
+	require.NoError(t, err, "a clone registers the same metrics")
+	_, _ = cloned.Evaluate(ctx, client.PolicyRequest{Rule: accessRule, Data: role("owner")})
+	want := `
+# HELP policy_evaluations_total Policy evaluations by result ("true", "false" or "error") and error_type (empty on success).
+# TYPE policy_evaluations_total counter
+policy_evaluations_total{error_type="",result="false"} 2
+policy_evaluations_total{error_type="",result="true"} 2
+policy_evaluations_total{error_type="engine_error",result="error"} 1
+policy_evaluations_total{error_type="transport",result="error"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(want), client.PrometheusEvaluations))
+}
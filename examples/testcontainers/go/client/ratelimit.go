@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// WithRateLimit sends at most rps requests a second to the engine, with
+// bursts of up to burst, by a token bucket every request waits on: each
+// evaluation attempt, retries and hedges included, health checks and
+// probes. A request over the limit waits for its turn, or until its context
+// is done, rather than failing. The limiter is shared by the client's
+// clones and rule profiles and by EvaluateBatch's workers, so the limit
+// holds for the client as a whole; RateLimiter returns it for adjusting at
+// runtime.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(c *PolicyClient) {
+		c.limiter = NewRateLimiter(rps, burst)
+	}
+}
+
+// RateLimiter returns the limiter WithRateLimit installed, or nil without it
+func (c *PolicyClient) RateLimiter() *RateLimiter {
+	return c.limiter
+}
+
+// RateLimiter is a token bucket holding up to Burst tokens, refilled at
+// Limit a second. A RateLimiter is safe for concurrent use.
+type RateLimiter struct {
+	mu     sync.Mutex
+	limit  float64
+	burst  int
+	tokens float64
+	last   time.Time
+
+	now func() time.Time
+	// wait blocks until the time given or until ctx is done
+	wait func(ctx context.Context, until time.Time) error
+}
+
+// NewRateLimiter returns a limiter allowing rps requests a second, with
+// bursts of up to burst, starting full. An rps of zero or less does not
+// limit; a burst below one is one.
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+	l := &RateLimiter{now: time.Now, wait: waitUntil}
+	l.limit, l.burst = rps, max(burst, 1)
+	l.tokens = float64(l.burst)
+	return l
+}
+
+// Limit returns the requests allowed a second
+func (l *RateLimiter) Limit() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// Burst returns the most requests allowed at once
+func (l *RateLimiter) Burst() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.burst
+}
+
+// SetLimit changes the requests allowed a second from now on; zero or less
+// lifts the limit. Requests already waiting keep their turn.
+func (l *RateLimiter) SetLimit(rps float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(l.now())
+	l.limit = rps
+}
+
+// SetBurst changes the most requests allowed at once, at least one
+func (l *RateLimiter) SetBurst(burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(l.now())
+	l.burst = max(burst, 1)
+	l.tokens = math.Min(l.tokens, float64(l.burst))
+}
+
+// Wait takes a token, waiting for one if the bucket is empty. It returns
+// ctx's error if ctx is done first, and the token is then given back.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	if l.limit <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := l.now()
+	l.refill(now)
+	// Tokens go negative for the requests queued behind the bucket, so each
+	// waits its turn after those before it
+	l.tokens--
+	var until time.Time
+	if l.tokens < 0 {
+		until = now.Add(time.Duration(-l.tokens / l.limit * float64(time.Second)))
+	}
+	l.mu.Unlock()
+
+	if until.IsZero() {
+		return nil
+	}
+	if err := l.wait(ctx, until); err != nil {
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// refill adds the tokens earned since the last refill
+func (l *RateLimiter) refill(now time.Time) {
+	if elapsed := now.Sub(l.last); elapsed > 0 && !l.last.IsZero() && l.limit > 0 {
+		l.tokens = math.Min(float64(l.burst), l.tokens+elapsed.Seconds()*l.limit)
+	}
+	if now.After(l.last) {
+		l.last = now
+	}
+}
+
+// waitUntil sleeps until until, or until ctx is done
+func waitUntil(ctx context.Context, until time.Time) error {
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
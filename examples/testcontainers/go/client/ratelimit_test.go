@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is simulated time for a RateLimiter: waiting moves it on to the
+// time waited for, at once
+type fakeClock struct {
+	mu      sync.Mutex
+	t       time.Time
+	elapsed time.Duration
+}
+
+func (f *fakeClock) now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.t.Add(f.elapsed)
+}
+
+func (f *fakeClock) waitUntil(ctx context.Context, until time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d := until.Sub(f.t); d > f.elapsed {
+		f.elapsed = d
+	}
+	return nil
+}
+
+func (f *fakeClock) since() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.elapsed
+}
+
+// rateLimited returns a client of url limited to rps with a burst of one,
+// whose limiter runs on a fake clock
+func rateLimited(t *testing.T, url string, rps float64, opts ...Option) (*PolicyClient, *fakeClock) {
+	c, err := New(url, append([]Option{WithRateLimit(rps, 1)}, opts...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c.RateLimiter().now, c.RateLimiter().wait = clock.now, clock.waitUntil
+	return c, clock
+}
+
+// TestRateLimit tests that ten evaluations at two a second take four and a
+// half seconds, the first going straight out
+func TestRateLimit(t *testing.T) {
+	engine, _ := newCountingEngine(t)
+	c, clock := rateLimited(t, engine.URL, 2)
+
+	for i := 0; i < 10; i++ {
+		_, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 4500*time.Millisecond, clock.since())
+}
+
+// TestRateLimitBatch tests that a batch's workers share the client's limit
+// rather than each having their own
+func TestRateLimitBatch(t *testing.T) {
+	engine, _ := newCountingEngine(t)
+	c, clock := rateLimited(t, engine.URL, 2)
+
+	datas := make([]interface{}, 10)
+	for i := range datas {
+		datas[i] = map[string]interface{}{"n": i}
+	}
+	_, err := c.EvaluateBatch(context.Background(), "rule", datas, WithWorkers(5))
+	require.NoError(t, err)
+	assert.Equal(t, 4500*time.Millisecond, clock.since())
+
+	clone, err := c.Clone()
+	require.NoError(t, err)
+	assert.Same(t, c.RateLimiter(), clone.RateLimiter(), "clones share the limiter")
+}
+
+// TestRateLimitRetries tests that every attempt of a retried evaluation
+// waits for a token
+func TestRateLimitRetries(t *testing.T) {
+	engine := newFailingEngine(t, 2, jsonError(http.StatusServiceUnavailable, `{"error":"busy"}`))
+	c, clock := rateLimited(t, engine.URL, 4, WithRetry(2, time.Millisecond))
+
+	_, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, engine.attempts.Load())
+	assert.Equal(t, 500*time.Millisecond, clock.since(), "the two retries waited a quarter second each")
+}
+
+// TestRateLimiter tests adjusting a limiter at runtime, and that a wait cut
+// short by its context gives its token back
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(1, 2)
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l.now, l.wait = clock.now, clock.waitUntil
+	ctx := context.Background()
+
+	require.NoError(t, l.Wait(ctx))
+	require.NoError(t, l.Wait(ctx))
+	assert.Zero(t, clock.since(), "a burst of two goes straight out")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, l.Wait(cancelled), context.Canceled)
+	require.NoError(t, l.Wait(ctx))
+	assert.Equal(t, time.Second, clock.since(), "the cancelled wait gave its turn back")
+
+	l.SetLimit(10)
+	l.SetBurst(1)
+	assert.Equal(t, 10.0, l.Limit())
+	assert.Equal(t, 1, l.Burst())
+	require.NoError(t, l.Wait(ctx))
+	assert.Equal(t, 1100*time.Millisecond, clock.since())
+
+	l.SetLimit(0)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, l.Wait(ctx))
+	}
+	assert.Equal(t, 1100*time.Millisecond, clock.since(), "a limit of zero lifts it")
+}
@@ -0,0 +1,355 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RecordMode sets whether WithRecorder talks to the engine or replays what it
+// recorded
+type RecordMode int
+
+const (
+	// RecordOnce replays the cassette if it exists and records a new one if
+	// not, so the first run, with an engine, writes what later runs replay
+	RecordOnce RecordMode = iota
+	// RecordAll sends every request to the engine and records it, replacing
+	// the cassette's earlier interactions
+	RecordAll
+	// ReplayOnly never reaches the engine: every request must be in the
+	// cassette
+	ReplayOnly
+)
+
+func (m RecordMode) String() string {
+	switch m {
+	case RecordOnce:
+		return "record-once"
+	case RecordAll:
+		return "record-all"
+	case ReplayOnly:
+		return "replay-only"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrNoInteraction is a request replayed from a cassette that holds no
+// interaction for it
+var ErrNoInteraction = errors.New("no recorded interaction")
+
+// NoInteractionError names the request a cassette had no interaction for
+type NoInteractionError struct {
+	Cassette string
+	Key      InteractionKey
+}
+
+func (e *NoInteractionError) Error() string {
+	return fmt.Sprintf("no recorded interaction for %s in %s; record it again with RecordAll", e.Key, e.Cassette)
+}
+
+func (e *NoInteractionError) Is(target error) bool {
+	return target == ErrNoInteraction
+}
+
+// InteractionKey identifies a recorded request: its method and path and, for
+// an evaluation, the rule, the hash of its data and whether it asked for a
+// trace. Data is hashed canonicalized, so maps built in any order match; the
+// engine's address is not part of the key, so a cassette recorded against a
+// container on one port replays against any base URL.
+type InteractionKey struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Rule   string `json:"rule,omitempty"`
+	// DataHash is the hex SHA-256 of the data's canonical JSON
+	DataHash string `json:"data_hash,omitempty"`
+	Trace    bool   `json:"trace,omitempty"`
+}
+
+func (k InteractionKey) String() string {
+	if k.Rule == "" && k.DataHash == "" {
+		return k.Method + " " + k.Path
+	}
+	rule := k.Rule
+	if len(rule) > 60 {
+		rule = rule[:57] + "..."
+	}
+	return fmt.Sprintf("%s %s rule %q data %s", k.Method, k.Path, rule, k.DataHash)
+}
+
+// Interaction is a recorded request and the engine's response
+type Interaction struct {
+	Request  InteractionKey   `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedResponse is a response as a cassette keeps it
+type RecordedResponse struct {
+	Status int `json:"status"`
+	// Header holds the response's headers, less those that change from one
+	// response to the next, such as Date
+	Header http.Header `json:"header,omitempty"`
+	// Body is the response's body when it is JSON, with its keys sorted, and
+	// Text the body when it is not
+	Body json.RawMessage `json:"body,omitempty"`
+	Text string          `json:"text,omitempty"`
+}
+
+// Cassette is the file WithRecorder records to, sorted by request so it diffs
+// cleanly
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// volatileHeaders differ between responses to the same request and are left
+// out of cassettes
+var volatileHeaders = []string{"Date", "Age", "Expires", "Last-Modified", "Content-Length", DecisionIDHeader}
+
+// recordSpec is what WithRecorder was given
+type recordSpec struct {
+	path string
+	mode RecordMode
+}
+
+// WithRecorder records the client's requests and the engine's responses to
+// the cassette at path, or replays them from it, by mode; see RecordMode. A
+// replayed request the cassette has no interaction for fails with a
+// *NoInteractionError, which is ErrNoInteraction, without reaching the
+// engine. Health checks and capability probes are recorded like
+// evaluations.
+//
+// Cassettes are JSON, written after each recorded interaction, with requests
+// sorted and volatile headers and decision IDs left out, so recording the
+// same requests twice writes the same file. Data holding the time, such as
+// the ambient context's now, is keyed differently each run; pin it with
+// WithClock.
+func WithRecorder(path string, mode RecordMode) Option {
+	return func(c *PolicyClient) {
+		c.recording = &recordSpec{path: path, mode: mode}
+	}
+}
+
+// configureRecorder puts the recorder in front of the transport
+func (c *PolicyClient) configureRecorder() error {
+	if c.recording == nil {
+		return nil
+	}
+	r, err := newRecorder(*c.recording, c.httpClient.Transport)
+	if err != nil {
+		return err
+	}
+	c.httpClient.Transport = r
+	return nil
+}
+
+// recorder is an http.RoundTripper that records to, or replays from, a
+// cassette
+type recorder struct {
+	path   string
+	replay bool
+	next   http.RoundTripper
+
+	mu           sync.Mutex
+	interactions map[InteractionKey]Interaction
+}
+
+func newRecorder(spec recordSpec, next http.RoundTripper) (*recorder, error) {
+	r := &recorder{path: spec.path, next: next, interactions: map[InteractionKey]Interaction{}}
+	switch spec.mode {
+	case RecordAll:
+		return r, nil
+	case RecordOnce, ReplayOnly:
+	default:
+		return nil, fmt.Errorf("invalid record mode %d", spec.mode)
+	}
+
+	raw, err := os.ReadFile(spec.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && spec.mode == RecordOnce:
+		return r, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(raw, &cassette); err != nil {
+		return nil, fmt.Errorf("failed to read cassette %s: %w", spec.path, err)
+	}
+	for _, interaction := range cassette.Interactions {
+		r.interactions[interaction.Request] = interaction
+	}
+	r.replay = true
+	return r, nil
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, err := interactionKey(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.replay {
+		r.mu.Lock()
+		interaction, ok := r.interactions[key]
+		r.mu.Unlock()
+		if !ok {
+			return nil, &NoInteractionError{Cassette: r.path, Key: key}
+		}
+		return interaction.Response.response(req)
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	recorded := RecordedResponse{Status: resp.StatusCode, Header: resp.Header.Clone()}
+	if canonical, err := canonicalJSON(body); err == nil && len(bytes.TrimSpace(body)) > 0 {
+		recorded.Body = canonical
+	} else {
+		recorded.Text = string(body)
+	}
+	for _, name := range volatileHeaders {
+		recorded.Header.Del(name)
+	}
+	if len(recorded.Header) == 0 {
+		recorded.Header = nil
+	}
+	if err := r.record(Interaction{Request: key, Response: recorded}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// record adds interaction and rewrites the cassette
+func (r *recorder) record(interaction Interaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions[interaction.Request] = interaction
+
+	cassette := Cassette{Interactions: make([]Interaction, 0, len(r.interactions))}
+	for _, i := range r.interactions {
+		cassette.Interactions = append(cassette.Interactions, i)
+	}
+	sort.Slice(cassette.Interactions, func(i, j int) bool {
+		return cassette.Interactions[i].Request.less(cassette.Interactions[j].Request)
+	})
+	encoded, err := json.MarshalIndent(cassette, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	if dir := filepath.Dir(r.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to write cassette: %w", err)
+		}
+	}
+	if err := os.WriteFile(r.path, append(encoded, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+func (k InteractionKey) less(other InteractionKey) bool {
+	if k.Path != other.Path {
+		return k.Path < other.Path
+	}
+	if k.Method != other.Method {
+		return k.Method < other.Method
+	}
+	if k.Rule != other.Rule {
+		return k.Rule < other.Rule
+	}
+	if k.DataHash != other.DataHash {
+		return k.DataHash < other.DataHash
+	}
+	return !k.Trace && other.Trace
+}
+
+// interactionKey is the key req is recorded under, reading and restoring its
+// body
+func interactionKey(req *http.Request) (InteractionKey, error) {
+	key := InteractionKey{Method: req.Method, Path: req.URL.Path}
+	if key.Path == "" {
+		key.Path = "/"
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return key, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return key, fmt.Errorf("failed to read request for the recorder: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	var evaluation struct {
+		Rule  string          `json:"rule"`
+		Data  json.RawMessage `json:"data"`
+		Trace bool            `json:"trace"`
+	}
+	if err := json.Unmarshal(body, &evaluation); err != nil {
+		// Not an evaluation: key it by the whole body
+		sum := sha256.Sum256(body)
+		key.DataHash = hex.EncodeToString(sum[:])
+		return key, nil
+	}
+	data, err := canonicalJSON(evaluation.Data)
+	if err != nil {
+		return key, fmt.Errorf("failed to read request for the recorder: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	key.Rule, key.DataHash, key.Trace = evaluation.Rule, hex.EncodeToString(sum[:]), evaluation.Trace
+	return key, nil
+}
+
+// canonicalJSON is raw re-encoded with sorted keys and no insignificant space
+func canonicalJSON(raw json.RawMessage) ([]byte, error) {
+	if len(raw) == 0 {
+		return []byte("null"), nil
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// response is the recorded response, answering req
+func (r RecordedResponse) response(req *http.Request) (*http.Response, error) {
+	body := []byte(r.Body)
+	if len(body) == 0 {
+		body = []byte(r.Text)
+	}
+	header := r.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, strings.TrimSpace(http.StatusText(r.Status))),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// offline is a base URL nothing listens on, for clients that must not reach
+// an engine
+const offline = "http://127.0.0.1:1"
+
+// TestRecorderReplays tests that interactions recorded against an engine are
+// replayed without one
+func TestRecorderReplays(t *testing.T) {
+	const rule = "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+	ctx := context.Background()
+	engine := newFakeEngine(t)
+	cassette := filepath.Join(t.TempDir(), "cassettes", "senior.json")
+
+	recording, err := New(engine.URL, WithRecorder(cassette, RecordOnce))
+	require.NoError(t, err)
+	require.NoError(t, recording.Health(ctx))
+	recorded, err := recording.EvaluatePolicy(ctx, rule, map[string]interface{}{"Person": map[string]interface{}{"age": 70, "name": "Ada"}}, false)
+	require.NoError(t, err)
+	require.Len(t, engine.Requests(), 1)
+
+	for _, mode := range []RecordMode{RecordOnce, ReplayOnly} {
+		replaying, err := New(offline, WithRecorder(cassette, mode))
+		require.NoError(t, err)
+		require.NoError(t, replaying.Health(ctx), mode)
+		// The same data, built in another order
+		replayed, err := replaying.EvaluatePolicy(ctx, rule, map[string]interface{}{"Person": map[string]interface{}{"name": "Ada", "age": 70}}, false)
+		require.NoError(t, err, mode)
+		assert.Equal(t, recorded.Result, replayed.Result, mode)
+		assert.Equal(t, recorded.Rule, replayed.Rule, mode)
+		assert.Equal(t, recorded.Data, replayed.Data, mode)
+	}
+	assert.Len(t, engine.Requests(), 1, "replays never reach the engine")
+}
+
+// TestRecorderNoInteraction tests that a request the cassette lacks fails
+// clearly instead of reaching the engine
+func TestRecorderNoInteraction(t *testing.T) {
+	ctx := context.Background()
+	engine := newFakeEngine(t)
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+
+	recording, err := New(engine.URL, WithRecorder(cassette, RecordAll))
+	require.NoError(t, err)
+	_, err = recording.EvaluatePolicy(ctx, "rule", map[string]interface{}{"age": 70}, false)
+	require.NoError(t, err)
+
+	replaying, err := New(engine.URL, WithRecorder(cassette, ReplayOnly))
+	require.NoError(t, err)
+	_, err = replaying.EvaluatePolicy(ctx, "rule", map[string]interface{}{"age": 71}, false)
+	require.ErrorIs(t, err, ErrNoInteraction)
+	var missing *NoInteractionError
+	require.True(t, errors.As(err, &missing))
+	assert.Equal(t, "rule", missing.Key.Rule)
+	assert.Contains(t, err.Error(), `no recorded interaction for POST / rule "rule" data `)
+	assert.Len(t, engine.Requests(), 1)
+
+	_, err = New(offline, WithRecorder(filepath.Join(t.TempDir(), "missing.json"), ReplayOnly))
+	assert.ErrorContains(t, err, "failed to read cassette")
+}
+
+// TestRecorderDeterministic tests that recording the same requests in any
+// order writes the same cassette
+func TestRecorderDeterministic(t *testing.T) {
+	ctx := context.Background()
+	engine := newFakeEngine(t)
+	record := func(rules ...string) []byte {
+		cassette := filepath.Join(t.TempDir(), "cassette.json")
+		c, err := New(engine.URL, WithRecorder(cassette, RecordAll))
+		require.NoError(t, err)
+		for _, rule := range rules {
+			_, err := c.EvaluatePolicy(ctx, rule, map[string]interface{}{"b": 1, "a": 2}, false)
+			require.NoError(t, err)
+		}
+		raw, err := os.ReadFile(cassette)
+		require.NoError(t, err)
+		return raw
+	}
+
+	first := record("one", "two")
+	assert.Equal(t, string(first), string(record("two", "one")))
+	assert.NotContains(t, string(first), "Date")
+	assert.NotContains(t, string(first), DecisionIDHeader)
+}
+
+// TestRecorderClone tests that a clone cannot record elsewhere than its
+// original
+func TestRecorderClone(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL, WithRecorder(filepath.Join(t.TempDir(), "cassette.json"), RecordAll))
+	require.NoError(t, err)
+
+	_, err = c.Clone(WithBaseData(map[string]interface{}{"tenant": "a"}))
+	assert.NoError(t, err)
+	_, err = c.Clone(WithRecorder(filepath.Join(t.TempDir(), "other.json"), RecordAll))
+	assert.ErrorIs(t, err, errCloneConnections)
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"policy-engine-testcontainer-example/batch"
+)
+
+// ErrNotAttempted is the error of batch items that were never evaluated
+// because the batch was cancelled or aborted first
+var ErrNotAttempted = batch.ErrNotAttempted
+
+// BatchResult is the outcome of one batch item: a response, or the error that
+// kept the item from getting one. An item the engine rejected has both, the
+// error being its *EngineError.
+type BatchResult struct {
+	// Index is the item's position in the batch's input
+	Index    int
+	Response *PolicyResponse
+	Err      error
+
+	// rule and data let Retry evaluate the item again, as the same decision
+	rule     string
+	data     interface{}
+	decision *decision
+}
+
+// BatchResults are the outcomes of a batch, in input order
+type BatchResults []BatchResult
+
+// Failed returns the results that have an error, including items never
+// attempted
+func (r BatchResults) Failed() BatchResults {
+	return r.filter(func(result BatchResult) bool { return result.Err != nil })
+}
+
+// Successes returns the results that have a response
+func (r BatchResults) Successes() BatchResults {
+	return r.filter(func(result BatchResult) bool { return result.Err == nil })
+}
+
+// Responses returns every result's response, nil for failed items, in order
+func (r BatchResults) Responses() []*PolicyResponse {
+	responses := make([]*PolicyResponse, len(r))
+	for i, result := range r {
+		responses[i] = result.Response
+	}
+	return responses
+}
+
+func (r BatchResults) filter(keep func(BatchResult) bool) BatchResults {
+	var kept BatchResults
+	for _, result := range r {
+		if keep(result) {
+			kept = append(kept, result)
+		}
+	}
+	return kept
+}
+
+// Retry evaluates the failed items again as one batch with opts and returns
+// a copy of r with their new outcomes, keeping every result's Index and
+// decision ID. As with EvaluateBatch, the error is a *BatchError if any item
+// still failed.
+func (r BatchResults) Retry(ctx context.Context, e Evaluator, opts ...BatchOption) (BatchResults, error) {
+	retried := append(BatchResults(nil), r...)
+
+	// Items of one batch share a rule, but results can be combined
+	byRule := map[string][]int{}
+	var rules []string
+	for i, result := range r {
+		if result.Err == nil {
+			continue
+		}
+		if _, seen := byRule[result.rule]; !seen {
+			rules = append(rules, result.rule)
+		}
+		byRule[result.rule] = append(byRule[result.rule], i)
+	}
+
+	var runErr error
+	for _, rule := range rules {
+		positions := byRule[rule]
+		datas := make([]interface{}, len(positions))
+		decisions := make([]*decision, len(positions))
+		for k, i := range positions {
+			datas[k] = r[i].data
+			decisions[k] = r[i].decision
+		}
+		results, err := EvaluateBatchWith(ctx, e, rule, datas, append(opts[:len(opts):len(opts)], withDecisions(decisions))...)
+		for k, i := range positions {
+			retried[i].Response = results[k].Response
+			retried[i].Err = results[k].Err
+		}
+		var batchErr *BatchError
+		if errors.As(err, &batchErr) && batchErr.Cause != nil && runErr == nil {
+			runErr = batchErr.Cause
+		}
+	}
+	return retried, retried.err(runErr)
+}
+
+// err summarizes the failures in r, or returns nil if there were none
+func (r BatchResults) err(cause error) error {
+	var errs []error
+	for _, result := range r {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("item %d: %w", result.Index, result.Err))
+		}
+	}
+	if len(errs) == 0 && cause == nil {
+		return nil
+	}
+	return &BatchError{Total: len(r), Errs: errs, Cause: cause}
+}
+
+// BatchError is returned by EvaluateBatch when any item failed. Each of Errs
+// is one item's error, prefixed with its index; errors.Is and errors.As see
+// through to them and to Cause.
+type BatchError struct {
+	Total int
+	Errs  []error
+	// Cause is why the batch stopped early: ctx's error, or an error wrapping
+	// batch.ErrTooManyFailures. It is nil if every item was attempted.
+	Cause error
+}
+
+func (e *BatchError) Error() string {
+	msg := fmt.Sprintf("%d of %d batch items failed", len(e.Errs), e.Total)
+	if e.Cause != nil {
+		msg = fmt.Sprintf("batch stopped early: %v; %s", e.Cause, msg)
+	}
+	if len(e.Errs) > 0 {
+		msg += ": " + e.Errs[0].Error()
+	}
+	if len(e.Errs) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(e.Errs)-1)
+	}
+	return msg
+}
+
+func (e *BatchError) Unwrap() []error {
+	if e.Cause == nil {
+		return e.Errs
+	}
+	return append(append([]error(nil), e.Errs...), e.Cause)
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"policy-engine-testcontainer-example/batch"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyEngine fails the first request for every document whose n is odd
+type flakyEngine struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	seen map[float64]int
+}
+
+func newFlakyEngine(t *testing.T) *flakyEngine {
+	t.Helper()
+
+	engine := &flakyEngine{seen: map[float64]int{}}
+	engine.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Rule string             `json:"rule"`
+			Data map[string]float64 `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n := req.Data["n"]
+		engine.mu.Lock()
+		engine.seen[n]++
+		attempt := engine.seen[n]
+		engine.mu.Unlock()
+
+		if int(n)%2 == 1 && attempt == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(PolicyResponse{Result: true, Rule: []string{req.Rule}, Data: req.Data})
+	}))
+	t.Cleanup(engine.Close)
+
+	return engine
+}
+
+func (e *flakyEngine) attempts(n int) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.seen[float64(n)]
+}
+
+// TestBatchResultsRetry tests that Retry re-runs only the failed items and keeps their indexes
+func TestBatchResultsRetry(t *testing.T) {
+	engine := newFlakyEngine(t)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	datas := make([]interface{}, 10)
+	for i := range datas {
+		datas[i] = map[string]interface{}{"n": i}
+	}
+
+	results, err := c.EvaluateBatch(context.Background(), "rule", datas)
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Len(t, batchErr.Errs, 5)
+	assert.Equal(t, []int{1, 3, 5, 7, 9}, indexes(results.Failed()))
+	assert.Equal(t, []int{0, 2, 4, 6, 8}, indexes(results.Successes()))
+
+	retried, err := results.Retry(context.Background(), c)
+	require.NoError(t, err)
+	require.Len(t, retried, 10)
+	for i, result := range retried {
+		assert.Equal(t, i, result.Index)
+		require.NoError(t, result.Err, "item %d", i)
+		assert.Equal(t, map[string]interface{}{"n": json.Number(fmt.Sprint(i))}, result.Response.Data)
+		want := 1
+		if i%2 == 1 {
+			want = 2
+		}
+		assert.Equal(t, want, engine.attempts(i), "item %d", i)
+	}
+
+	// The original results are left as they were
+	assert.Len(t, results.Failed(), 5)
+
+	// Retrying a subset works on the same indexes
+	again, err := results.Failed()[:2].Retry(context.Background(), c)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 3}, indexes(again.Successes()))
+}
+
+// TestEvaluateBatchCancelledMidway pins down what each item holds when the batch is cancelled part-way
+func TestEvaluateBatchCancelledMidway(t *testing.T) {
+	engine := newFakeEngine(t)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var completed int64
+	datas := make([]interface{}, 100)
+	for i := range datas {
+		datas[i] = map[string]interface{}{"n": i}
+	}
+	results, err := c.EvaluateBatch(ctx, "rule", datas, WithWorkers(1), WithProgress(func(p batch.Progress) {
+		if atomic.AddInt64(&completed, 1) == 10 {
+			cancel()
+		}
+	}))
+
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.ErrorIs(t, batchErr.Cause, context.Canceled)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, err, ErrNotAttempted)
+	assert.Equal(t, 100, batchErr.Total)
+
+	// One worker: items 0-9 completed, the one picked up as the run was
+	// cancelled may have failed with the cancellation, and the rest never ran
+	for i, result := range results[:10] {
+		assert.NoError(t, result.Err, "item %d", i)
+		assert.NotNil(t, result.Response, "item %d", i)
+	}
+	notAttempted := 0
+	for i, result := range results[10:] {
+		assert.Nil(t, result.Response, "item %d", i+10)
+		if errors.Is(result.Err, ErrNotAttempted) {
+			assert.Same(t, ErrNotAttempted, result.Err, "item %d", i+10)
+			notAttempted++
+		} else {
+			assert.ErrorIs(t, result.Err, context.Canceled, "item %d", i+10)
+		}
+	}
+	assert.GreaterOrEqual(t, notAttempted, 89)
+	assert.Len(t, batchErr.Errs, 90)
+	// Only the items that were attempted can have reached the engine
+	assert.LessOrEqual(t, len(engine.Requests()), 100-notAttempted)
+}
+
+// TestBatchError tests the summary message and what errors.Is sees
+func TestBatchError(t *testing.T) {
+	denied := errors.New("denied")
+	err := &BatchError{
+		Total: 5,
+		Errs:  []error{fmt.Errorf("item 1: %w", denied), fmt.Errorf("item 4: %w", ErrNotAttempted)},
+		Cause: context.Canceled,
+	}
+	assert.EqualError(t, err, "batch stopped early: context canceled; 2 of 5 batch items failed: item 1: denied (and 1 more)")
+	assert.ErrorIs(t, err, denied)
+	assert.ErrorIs(t, err, ErrNotAttempted)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	assert.EqualError(t, &BatchError{Total: 3, Errs: err.Errs[:1]}, "1 of 3 batch items failed: item 1: denied")
+	assert.Nil(t, BatchResults{{Index: 0}}.err(nil))
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// maxRetryDelay caps the wait between attempts, however many have failed
+const maxRetryDelay = 10 * time.Second
+
+type retryConfig struct {
+	maxRetries int
+	base       time.Duration
+	// jitter returns a random fraction in [0, 1)
+	jitter func() float64
+}
+
+func (r retryConfig) enabled() bool {
+	return r.maxRetries > 0 && r.base > 0
+}
+
+// WithRetry sends an evaluation again, up to maxRetries times, when the
+// engine cannot be reached or answers with a 5xx, such as a container that
+// is still warming up after its health check passed. The wait before the
+// nth retry is base doubled n-1 times, capped at ten seconds, of which a
+// random half is kept so that callers failing together do not retry
+// together. A 4xx, including 429 and the engine rejecting the rule as
+// unparseable, is an answer and is never retried; neither is reader data,
+// which can only be sent once. Once every attempt has failed the call's
+// error is a *RetryError with the number of attempts made.
+//
+// Each attempt goes through the balancer, when there are replicas, and is
+// hedged, when WithHedging is set, and all of them share the call's deadline.
+func WithRetry(maxRetries int, base time.Duration) Option {
+	return func(c *PolicyClient) {
+		c.retry = retryConfig{maxRetries: maxRetries, base: base, jitter: rand.Float64}
+	}
+}
+
+// RetryError is the last failure of an evaluation WithRetry gave up on
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("gave up after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// retried calls send until it succeeds, fails in a way a retry
+// will not fix, or the retries run out
+func (c *PolicyClient) retried(ctx context.Context, send func() (*PolicyResponse, error)) (*PolicyResponse, error) {
+	for attempt := 1; ; attempt++ {
+		response, err := send()
+		failure := transientFailure(response, err)
+		if failure == nil || ctx.Err() != nil {
+			return response, err
+		}
+		if attempt > c.retry.maxRetries {
+			return response, &RetryError{Attempts: attempt, Err: failure}
+		}
+
+		timer := time.NewTimer(c.retry.delay(attempt))
+		select {
+		case <-timer.C:
+			c.prometheus.retry()
+		case <-ctx.Done():
+			timer.Stop()
+			return response, err
+		}
+	}
+}
+
+// delay is how long to wait before the given retry, counting from one
+func (r retryConfig) delay(retry int) time.Duration {
+	d := r.base
+	for i := 1; i < retry && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	d = min(d, maxRetryDelay)
+	return d/2 + time.Duration(r.jitter()*float64(d/2))
+}
+
+// transientFailure returns the failure a retry might not meet: the engine
+// unreachable, cut off or answering a 5xx, whether as an error or as the
+// response's engine error. A rule the engine cannot parse fails the same way
+// every time, whatever status it comes with.
+func transientFailure(response *PolicyResponse, err error) error {
+	if err == nil {
+		if response == nil || response.EngineError == nil {
+			return nil
+		}
+		err = response.EngineError
+	}
+	var (
+		parseErr  *RuleParseError
+		engineErr *EngineError
+		bodyErr   *ResponseBodyError
+		statusErr *StatusError
+	)
+	switch {
+	case errors.As(err, &parseErr):
+		return nil
+	case errors.Is(err, ErrConnection):
+		return err
+	case errors.As(err, &engineErr):
+		return retryableStatus(err, engineErr.StatusCode)
+	case errors.As(err, &bodyErr):
+		return retryableStatus(err, bodyErr.StatusCode)
+	case errors.As(err, &statusErr):
+		return retryableStatus(err, statusErr.StatusCode)
+	}
+	return nil
+}
+
+func retryableStatus(err error, status int) error {
+	if status >= http.StatusInternalServerError {
+		return err
+	}
+	return nil
+}
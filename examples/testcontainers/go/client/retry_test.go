@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingEngine fails the first failures requests with fail and answers the
+// rest as newFakeEngine does, counting every request it is sent
+type failingEngine struct {
+	*httptest.Server
+	attempts atomic.Int64
+}
+
+func newFailingEngine(t *testing.T, failures int64, fail http.HandlerFunc) *failingEngine {
+	t.Helper()
+
+	engine := &failingEngine{}
+	engine.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if engine.attempts.Add(1) <= failures {
+			fail(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"result":true,"rule":["rule"],"data":{}}`))
+	}))
+	t.Cleanup(engine.Close)
+	return engine
+}
+
+// jsonError answers with the engine's error body at status
+func jsonError(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+// dropConnection closes the connection without answering
+func dropConnection(w http.ResponseWriter, r *http.Request) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+// TestRetryTransient tests that connection failures and 5xx answers are
+// retried until the engine answers, and that the call gives up with the
+// number of attempts once the retries run out
+func TestRetryTransient(t *testing.T) {
+	for name, fail := range map[string]http.HandlerFunc{
+		"dropped":      dropConnection,
+		"unavailable":  jsonError(http.StatusServiceUnavailable, `{}`),
+		"engine error": jsonError(http.StatusInternalServerError, `{"result":false,"rule":[],"error":"internal error"}`),
+		"gateway":      func(w http.ResponseWriter, r *http.Request) { http.Error(w, "<html>", http.StatusBadGateway) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			engine := newFailingEngine(t, 2, fail)
+			c, err := New(engine.URL, WithRetry(2, time.Millisecond))
+			require.NoError(t, err)
+
+			response, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+			require.NoError(t, err)
+			assert.True(t, response.Result)
+			assert.EqualValues(t, 3, engine.attempts.Load())
+
+			engine.attempts.Store(-10)
+			_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+			var retryErr *RetryError
+			require.ErrorAs(t, err, &retryErr)
+			assert.Equal(t, 3, retryErr.Attempts)
+			assert.True(t, isOutage(err), "a failure policy still applies")
+			assert.EqualValues(t, -7, engine.attempts.Load())
+		})
+	}
+}
+
+// TestRetryNotRetried tests that 4xx answers, parse errors whatever their
+// status, and reader data are sent once
+func TestRetryNotRetried(t *testing.T) {
+	for name, fail := range map[string]http.HandlerFunc{
+		"bad request":     jsonError(http.StatusBadRequest, `{"result":false,"rule":[],"error":"invalid data"}`),
+		"parse error":     jsonError(http.StatusBadRequest, `{"result":false,"rule":[],"error":{"code":"parse_error","message":"Parse error"}}`),
+		"parse error 500": jsonError(http.StatusInternalServerError, `{"result":false,"rule":[],"error":"Parse error at line 1"}`),
+		"not found":       jsonError(http.StatusNotFound, `{}`),
+		"overloaded":      jsonError(http.StatusTooManyRequests, `{}`),
+	} {
+		t.Run(name, func(t *testing.T) {
+			engine := newFailingEngine(t, 1, fail)
+			c, err := New(engine.URL, WithRetry(3, time.Millisecond))
+			require.NoError(t, err)
+
+			_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+			require.Error(t, err)
+			var retryErr *RetryError
+			assert.False(t, errors.As(err, &retryErr))
+			assert.EqualValues(t, 1, engine.attempts.Load())
+		})
+	}
+
+	t.Run("reader", func(t *testing.T) {
+		engine := newFailingEngine(t, 1, jsonError(http.StatusServiceUnavailable, `{}`))
+		c, err := New(engine.URL, WithRetry(3, time.Millisecond))
+		require.NoError(t, err)
+
+		_, err = c.EvaluatePolicy(context.Background(), "rule", strings.NewReader(`{}`), false)
+		require.Error(t, err)
+		assert.EqualValues(t, 1, engine.attempts.Load())
+	})
+}
+
+// TestRetryContext tests that a call stops waiting to retry once its
+// context ends
+func TestRetryContext(t *testing.T) {
+	engine := newFailingEngine(t, 100, jsonError(http.StatusServiceUnavailable, `{}`))
+	c, err := New(engine.URL, WithRetry(5, time.Hour))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	began := time.Now()
+	_, err = c.EvaluatePolicy(ctx, "rule", map[string]interface{}{}, false)
+	var statusErr *StatusError
+	assert.ErrorAs(t, err, &statusErr)
+	assert.Less(t, time.Since(began), 5*time.Second)
+	assert.EqualValues(t, 1, engine.attempts.Load())
+}
+
+// TestRetryDelay tests that the wait doubles from base up to the cap and
+// keeps a random half of it
+func TestRetryDelay(t *testing.T) {
+	for _, tt := range []struct {
+		jitter float64
+		retry  int
+		want   time.Duration
+	}{
+		{0, 1, 50 * time.Millisecond},
+		{0.5, 1, 75 * time.Millisecond},
+		{0, 2, 100 * time.Millisecond},
+		{0, 3, 200 * time.Millisecond},
+		{0.999, 3, 399800 * time.Microsecond},
+		{0, 20, maxRetryDelay / 2},
+		{0, 200, maxRetryDelay / 2},
+	} {
+		r := retryConfig{maxRetries: 1, base: 100 * time.Millisecond, jitter: func() float64 { return tt.jitter }}
+		assert.Equal(t, tt.want, r.delay(tt.retry), "retry %d, jitter %v", tt.retry, tt.jitter)
+	}
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type strictMode int
+
+const (
+	strictOff strictMode = iota
+	strictWarn
+	strictFatal
+)
+
+// WithStrictDecoding checks every response against the fields this client
+// knows. A response with fields it does not know, such as one a newer engine
+// added, or without ones it relies on, such as one the engine renamed, still
+// decodes as usual but carries a *SchemaSkewWarning in its SchemaSkew. The
+// check needs the whole body, so responses are buffered rather than decoded
+// as they stream in.
+func WithStrictDecoding() Option {
+	return func(c *PolicyClient) {
+		c.strictDecoding = strictWarn
+	}
+}
+
+// WithStrictDecodingFatal is WithStrictDecoding that fails the call with the
+// *SchemaSkewWarning, for contract tests that should stop at the first sign
+// of the engine and client drifting apart
+func WithStrictDecodingFatal() Option {
+	return func(c *PolicyClient) {
+		c.strictDecoding = strictFatal
+	}
+}
+
+// SchemaSkewWarning lists how a response differs from the schema this client
+// was built against. Fields are named by their JSON path, e.g. error.hint.
+type SchemaSkewWarning struct {
+	// Unknown are fields the response has that the client ignores
+	Unknown []string
+	// Missing are fields the engine always sends that the response lacks
+	Missing []string
+}
+
+func (w *SchemaSkewWarning) Error() string {
+	var parts []string
+	if len(w.Unknown) > 0 {
+		parts = append(parts, "unknown fields "+strings.Join(w.Unknown, ", "))
+	}
+	if len(w.Missing) > 0 {
+		parts = append(parts, "missing fields "+strings.Join(w.Missing, ", "))
+	}
+	return "response schema skew: " + strings.Join(parts, "; ")
+}
+
+var (
+	responseFields   = jsonFields(reflect.TypeOf(PolicyResponse{}))
+	errorFields      = jsonFields(reflect.TypeOf(engineErrorPayload{}))
+	requiredResponse = []string{"result", "rule", "data"}
+	requiredError    = []string{"message"}
+)
+
+// jsonFields returns the JSON names of a struct's encoded fields
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = true
+	}
+	return fields
+}
+
+// checkSchema compares a response body with the fields the client knows,
+// returning nil when they match. The body has already decoded, so it is a
+// JSON object.
+func checkSchema(body []byte) *SchemaSkewWarning {
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil
+	}
+
+	var warning SchemaSkewWarning
+	compareFields(&warning, "", response, responseFields, requiredResponse)
+	if raw, ok := response["error"]; ok {
+		var payload map[string]json.RawMessage
+		// A plain message is the older, but still known, form
+		if json.Unmarshal(raw, &payload) == nil && payload != nil {
+			compareFields(&warning, "error.", payload, errorFields, requiredError)
+		}
+	}
+	if len(warning.Unknown) == 0 && len(warning.Missing) == 0 {
+		return nil
+	}
+	sort.Strings(warning.Unknown)
+	sort.Strings(warning.Missing)
+	return &warning
+}
+
+func compareFields(warning *SchemaSkewWarning, prefix string, object map[string]json.RawMessage, known map[string]bool, required []string) {
+	for name := range object {
+		if !known[name] {
+			warning.Unknown = append(warning.Unknown, prefix+name)
+		}
+	}
+	for _, name := range required {
+		if _, ok := object[name]; !ok {
+			warning.Missing = append(warning.Missing, prefix+name)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStrictDecoding tests that extra, missing and renamed fields are reported on the response
+func TestStrictDecoding(t *testing.T) {
+	tests := []struct {
+		fixture string
+		status  int
+		want    *SchemaSkewWarning
+	}{
+		{fixture: "current.json", status: http.StatusOK},
+		{fixture: "extra.json", status: http.StatusOK, want: &SchemaSkewWarning{Unknown: []string{"decision_id", "engine_version"}}},
+		{fixture: "missing.json", status: http.StatusOK, want: &SchemaSkewWarning{Missing: []string{"data", "rule"}}},
+		{fixture: "renamed.json", status: http.StatusOK, want: &SchemaSkewWarning{Unknown: []string{"outcome"}, Missing: []string{"result"}}},
+		{fixture: "error_extra.json", status: http.StatusBadRequest, want: &SchemaSkewWarning{Unknown: []string{"error.hint"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			server := replayEngine(t, "schema/"+tt.fixture, tt.status)
+
+			for _, trace := range []bool{false, true} {
+				c, err := New(server.URL, WithStrictDecoding())
+				require.NoError(t, err)
+				response, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, trace)
+				if tt.status == http.StatusOK {
+					require.NoError(t, err)
+				} else {
+					var engineErr *EngineError
+					require.ErrorAs(t, err, &engineErr)
+				}
+				assert.Equal(t, tt.want, response.SchemaSkew)
+
+				// Lenient decoding still reads what it knows
+				if tt.fixture == "renamed.json" {
+					assert.False(t, response.Result)
+					assert.Equal(t, map[string]bool{"licence": true}, response.Labels)
+				}
+
+				// Without the option nothing is checked
+				c, err = New(server.URL)
+				require.NoError(t, err)
+				response, _ = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, trace)
+				assert.Nil(t, response.SchemaSkew)
+			}
+		})
+	}
+}
+
+// TestStrictDecodingFatal tests that fatal mode fails the call with the skew
+func TestStrictDecodingFatal(t *testing.T) {
+	c, err := New(replayEngine(t, "schema/renamed.json", http.StatusOK).URL, WithStrictDecodingFatal())
+	require.NoError(t, err)
+
+	response, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+	var skew *SchemaSkewWarning
+	require.ErrorAs(t, err, &skew)
+	assert.EqualError(t, err, "response schema skew: unknown fields outcome; missing fields result")
+	assert.Same(t, response.SchemaSkew, skew)
+
+	// The skew wins over an engine error, which may itself be misread
+	c, err = New(replayEngine(t, "schema/error_extra.json", http.StatusBadRequest).URL, WithStrictDecodingFatal())
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+	assert.EqualError(t, err, "response schema skew: unknown fields error.hint")
+
+	c, err = New(replayEngine(t, "schema/current.json", http.StatusOK).URL, WithStrictDecodingFatal())
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+	assert.NoError(t, err)
+}
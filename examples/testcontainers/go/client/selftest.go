@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"policy-engine-testcontainer-example/batch"
+)
+
+const (
+	defaultSelfTestParallelism = 4
+	defaultSelfTestDeadline    = 10 * time.Second
+)
+
+// ErrSelfTestFailed is returned by SelfTest when a case did not pass
+var ErrSelfTestFailed = errors.New("self-test failed")
+
+// SelfTestCase is one critical policy and a canary payload with the result it
+// must give
+type SelfTestCase struct {
+	Name string      `yaml:"name"`
+	Rule string      `yaml:"rule"`
+	Data interface{} `yaml:"data"`
+	Want bool        `yaml:"want"`
+	// Labels, if set, must each have the given result in the response
+	Labels map[string]bool `yaml:"labels,omitempty"`
+}
+
+// SelfTestSuite bundles the cases SelfTest runs
+type SelfTestSuite struct {
+	Cases []SelfTestCase `yaml:"cases"`
+	// Parallelism bounds the cases run at once; zero means 4
+	Parallelism int `yaml:"parallelism,omitempty"`
+	// Deadline bounds the whole run, however many cases remain; zero means
+	// 10 seconds
+	Deadline time.Duration `yaml:"deadline,omitempty"`
+}
+
+// LoadSelfTestSuite reads a suite written in YAML, e.g.
+//
+//	deadline: 5s
+//	cases:
+//	  - name: senior
+//	    rule: A **Person** gets senior_discount if ...
+//	    data: {Person: {age: 70}}
+//	    want: true
+func LoadSelfTestSuite(r io.Reader) (SelfTestSuite, error) {
+	var suite SelfTestSuite
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&suite); err != nil {
+		return SelfTestSuite{}, fmt.Errorf("failed to decode self-test suite: %w", err)
+	}
+	for i, tc := range suite.Cases {
+		if tc.Name == "" || tc.Rule == "" {
+			return SelfTestSuite{}, fmt.Errorf("failed to decode self-test suite: case %d needs a name and a rule", i)
+		}
+	}
+	return suite, nil
+}
+
+// SelfTestResult is the outcome of one case
+type SelfTestResult struct {
+	Name     string
+	Passed   bool
+	Duration time.Duration
+	// Response is the engine's answer, if it gave one
+	Response *PolicyResponse
+	// Err says why the case failed: the evaluation's error, or the result or
+	// label that differed
+	Err error
+}
+
+// SelfTestReport is the outcome of a SelfTest run, with a result per case in
+// suite order
+type SelfTestReport struct {
+	Results  []SelfTestResult
+	Duration time.Duration
+}
+
+// Passed reports whether every case passed
+func (r *SelfTestReport) Passed() bool {
+	return len(r.Failed()) == 0
+}
+
+// Failed returns the results of the cases that did not pass
+func (r *SelfTestReport) Failed() []SelfTestResult {
+	var failed []SelfTestResult
+	for _, result := range r.Results {
+		if !result.Passed {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// SelfTest checks that the engine parses and correctly evaluates the
+// suite's critical policies, e.g. after a deploy and before taking traffic.
+// Cases run in parallel up to the suite's Parallelism, and the run stops at
+// its Deadline, failing the cases still running or not yet started. The
+// report is always returned; the error wraps ErrSelfTestFailed when a case
+// failed, and also context.DeadlineExceeded when the deadline cut the run
+// short.
+func (c *PolicyClient) SelfTest(ctx context.Context, suite SelfTestSuite) (*SelfTestReport, error) {
+	parallelism := suite.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultSelfTestParallelism
+	}
+	deadline := suite.Deadline
+	if deadline <= 0 {
+		deadline = defaultSelfTestDeadline
+	}
+	ctx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+
+	start := time.Now()
+	report := &SelfTestReport{Results: make([]SelfTestResult, len(suite.Cases))}
+	runner := batch.Runner{Workers: parallelism}
+	errs, runErr := runner.Run(ctx, len(suite.Cases), func(ctx context.Context, i int) error {
+		tc := suite.Cases[i]
+		began := time.Now()
+		response, err := c.Evaluate(ctx, PolicyRequest{Rule: tc.Rule, Data: tc.Data})
+		if err == nil {
+			err = tc.check(response)
+		}
+		report.Results[i] = SelfTestResult{Name: tc.Name, Duration: time.Since(began), Response: response}
+		return err
+	})
+	report.Duration = time.Since(start)
+
+	var failed []string
+	for i, err := range errs {
+		result := &report.Results[i]
+		result.Name = suite.Cases[i].Name
+		result.Err = err
+		result.Passed = err == nil
+		if err != nil {
+			failed = append(failed, result.Name)
+		}
+	}
+	if len(failed) == 0 {
+		return report, nil
+	}
+	err := fmt.Errorf("%w: %s", ErrSelfTestFailed, strings.Join(failed, ", "))
+	if runErr != nil {
+		err = fmt.Errorf("%w: did not finish within %s: %w", ErrSelfTestFailed, deadline, runErr)
+	}
+	return report, err
+}
+
+// check compares a response with the case's expectations
+func (tc SelfTestCase) check(response *PolicyResponse) error {
+	if response.Result != tc.Want {
+		return fmt.Errorf("result is %t, want %t", response.Result, tc.Want)
+	}
+	for label, want := range tc.Labels {
+		got, ok := response.Labels[label]
+		if !ok {
+			return fmt.Errorf("label %q is not in the response", label)
+		}
+		if got != want {
+			return fmt.Errorf("label %q is %t, want %t", label, got, want)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const selfTestYAML = `
+parallelism: 2
+deadline: 2s
+cases:
+  - name: senior
+    rule: A **Person** gets discount if they are a senior
+    data: {age: 70}
+    want: true
+  - name: adult
+    rule: A **Person** gets discount if they are a senior
+    data: {age: 30}
+    want: false
+`
+
+// TestLoadSelfTestSuite tests reading a suite from YAML
+func TestLoadSelfTestSuite(t *testing.T) {
+	suite, err := LoadSelfTestSuite(strings.NewReader(selfTestYAML))
+	require.NoError(t, err)
+	assert.Equal(t, 2, suite.Parallelism)
+	assert.Equal(t, 2*time.Second, suite.Deadline)
+	require.Len(t, suite.Cases, 2)
+	assert.Equal(t, SelfTestCase{Name: "senior", Rule: "A **Person** gets discount if they are a senior", Data: map[string]interface{}{"age": 70}, Want: true}, suite.Cases[0])
+
+	_, err = LoadSelfTestSuite(strings.NewReader("cases:\n  - name: x\n"))
+	assert.ErrorContains(t, err, "case 0 needs a name and a rule")
+	_, err = LoadSelfTestSuite(strings.NewReader("cases: []\ntimeout: 1s\n"))
+	assert.ErrorContains(t, err, "failed to decode self-test suite")
+}
+
+// TestSelfTest tests passing and failing suites
+func TestSelfTest(t *testing.T) {
+	c, err := New(newTracingEngine(t, 0).URL)
+	require.NoError(t, err)
+	suite, err := LoadSelfTestSuite(strings.NewReader(selfTestYAML))
+	require.NoError(t, err)
+
+	report, err := c.SelfTest(context.Background(), suite)
+	require.NoError(t, err)
+	assert.True(t, report.Passed())
+	require.Len(t, report.Results, 2)
+	for _, result := range report.Results {
+		assert.True(t, result.Passed, result.Name)
+		assert.Positive(t, result.Duration)
+	}
+
+	// The adult case regresses, and a label is missing from the senior one
+	suite.Cases[1].Want = true
+	suite.Cases[0].Labels = map[string]bool{"discount": true}
+	report, err = c.SelfTest(context.Background(), suite)
+	assert.True(t, errors.Is(err, ErrSelfTestFailed), "got %v", err)
+	assert.EqualError(t, err, "self-test failed: senior, adult")
+	assert.False(t, report.Passed())
+	assert.EqualError(t, report.Results[0].Err, `label "discount" is not in the response`)
+	assert.EqualError(t, report.Results[1].Err, "result is false, want true")
+	assert.NotNil(t, report.Results[1].Response)
+	assert.Len(t, report.Failed(), 2)
+}
+
+// TestSelfTestDeadline tests that the deadline fails the cases it cuts off
+func TestSelfTestDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	c, err := New(server.URL)
+	require.NoError(t, err)
+
+	cases := make([]SelfTestCase, 5)
+	for i := range cases {
+		cases[i] = SelfTestCase{Name: "slow", Rule: "rule", Data: map[string]interface{}{}}
+	}
+	started := time.Now()
+	report, err := c.SelfTest(context.Background(), SelfTestSuite{Cases: cases, Parallelism: 2, Deadline: 50 * time.Millisecond})
+	assert.Less(t, time.Since(started), time.Second)
+	assert.ErrorIs(t, err, ErrSelfTestFailed)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Len(t, report.Failed(), 5)
+	assert.ErrorIs(t, report.Results[0].Err, context.DeadlineExceeded)
+	assert.ErrorIs(t, report.Results[4].Err, ErrNotAttempted)
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// ShadowHeader marks a request mirrored by WithShadow, so the shadow
+// engine's own audit can leave it out
+const ShadowHeader = "X-Policy-Shadow"
+
+const (
+	// defaultShadowSlots is how many mirrored evaluations may be outstanding
+	// before more are dropped
+	defaultShadowSlots = 64
+	// shadowTimeout bounds a mirrored evaluation, which outlives its call
+	shadowTimeout = 30 * time.Second
+)
+
+// ShadowComparison is one evaluation as the primary engine and the shadow
+// answered it. The respdiff package's ShadowSink compares the two.
+type ShadowComparison struct {
+	DecisionID string
+	Request    PolicyRequest
+
+	Primary    *PolicyResponse
+	PrimaryErr error
+	Shadow     *PolicyResponse
+	ShadowErr  error
+	// ShadowDuration is how long the shadow took to answer
+	ShadowDuration time.Duration
+}
+
+// shadowMirror sends sampled evaluations to the shadow, at most
+// cap(slots) at a time
+type shadowMirror struct {
+	target  Evaluator
+	rate    float64
+	sink    func(ShadowComparison)
+	sample  func() float64
+	slots   chan struct{}
+	dropped atomic.Int64
+}
+
+// WithShadow mirrors a sampleRate fraction of evaluations, from 0 to 1, to
+// shadow, e.g. a client for the next engine release, and hands each pair of
+// answers to sink. Mirroring happens after the call returns, on its own
+// goroutine, and never delays or fails it: when too many mirrored
+// evaluations are outstanding the new one is dropped and counted, see
+// ShadowDropped. Mirrored requests carry ShadowHeader and the call's
+// decision ID. Degraded responses and io.Reader data are not mirrored.
+// Shutdown waits for the mirrored evaluations outstanding; Close cancels
+// them, and sink is not called for those.
+//
+// sink is called from the mirroring goroutines, concurrently, and must not
+// keep the comparison's request data, which the caller may reuse.
+func WithShadow(shadow Evaluator, sampleRate float64, sink func(ShadowComparison)) Option {
+	return func(c *PolicyClient) {
+		c.shadow = &shadowMirror{
+			target: shadow,
+			rate:   sampleRate,
+			sink:   sink,
+			sample: rand.Float64,
+			slots:  make(chan struct{}, defaultShadowSlots),
+		}
+	}
+}
+
+// ShadowDropped returns how many sampled evaluations were not mirrored
+// because the shadow was too far behind
+func (c *PolicyClient) ShadowDropped() int64 {
+	if c.shadow == nil {
+		return 0
+	}
+	return c.shadow.dropped.Load()
+}
+
+type shadowKey struct{}
+
+// IsShadow reports whether ctx is that of an evaluation mirrored by
+// WithShadow, for Evaluators other than PolicyClient to mark it their own way
+func IsShadow(ctx context.Context) bool {
+	shadow, _ := ctx.Value(shadowKey{}).(bool)
+	return shadow
+}
+
+// mirror sends req to the shadow, if it is sampled and there is room
+func (c *PolicyClient) mirror(ctx context.Context, req PolicyRequest, id string, response *PolicyResponse, err error) {
+	m := c.shadow
+	if m == nil || IsShadow(ctx) || (response != nil && response.Degraded) || c.closed() {
+		return
+	}
+	if _, ok := req.Data.(io.Reader); ok {
+		return
+	}
+	if m.rate <= 0 || (m.rate < 1 && m.sample() >= m.rate) {
+		return
+	}
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		m.dropped.Add(1)
+		return
+	}
+
+	comparison := ShadowComparison{DecisionID: id, Request: req, PrimaryErr: err}
+	if response != nil {
+		// The caller owns the response it was given
+		comparison.Primary = cloneResponse(response)
+	}
+	started := c.goBackground(ctx, func(ctx context.Context) {
+		defer func() { <-m.slots }()
+		shadowCtx, cancel := context.WithTimeout(ctx, shadowTimeout)
+		defer cancel()
+		shadowCtx = context.WithValue(ContextWithDecisionID(shadowCtx, id), shadowKey{}, true)
+
+		began := time.Now()
+		comparison.ShadowErr = callback("shadow evaluator", func() (err error) {
+			comparison.Shadow, err = m.target.Evaluate(shadowCtx, req)
+			return err
+		})
+		comparison.ShadowDuration = time.Since(began)
+		if c.closed() {
+			// Cut short by Close, the comparison says nothing about the shadow
+			return
+		}
+		// A sink that panics must not take the process down with it
+		_ = callback("shadow sink", func() error {
+			m.sink(comparison)
+			return nil
+		})
+	})
+	if !started {
+		<-m.slots
+	}
+}
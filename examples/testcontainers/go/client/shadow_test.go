@@ -0,0 +1,247 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stalledEvaluator answers once release is closed
+type stalledEvaluator struct {
+	release chan struct{}
+	calls   atomic.Int64
+}
+
+func (e *stalledEvaluator) Evaluate(ctx context.Context, req PolicyRequest) (*PolicyResponse, error) {
+	e.calls.Add(1)
+	select {
+	case <-e.release:
+		return &PolicyResponse{Result: true}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (e *stalledEvaluator) Health(context.Context) error { return nil }
+
+// comparisons collects what a shadow sink is given
+type comparisons struct {
+	mu     sync.Mutex
+	got    []ShadowComparison
+	notify chan struct{}
+}
+
+func newComparisons() *comparisons {
+	return &comparisons{notify: make(chan struct{}, 1000)}
+}
+
+func (c *comparisons) sink(comparison ShadowComparison) {
+	c.mu.Lock()
+	c.got = append(c.got, comparison)
+	c.mu.Unlock()
+	c.notify <- struct{}{}
+}
+
+// wait returns the first n comparisons once they have arrived
+func (c *comparisons) wait(t *testing.T, n int) []ShadowComparison {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-c.notify:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d comparisons arrived", i, n)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]ShadowComparison(nil), c.got...)
+}
+
+// TestShadowMirrors tests that a mirrored request is marked as shadow traffic
+// under the call's decision ID and its answer reaches the sink
+func TestShadowMirrors(t *testing.T) {
+	primary := newFakeEngine(t)
+	var headers sync.Map
+	shadowEngine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers.Store(r.Header.Get(DecisionIDHeader), r.Header.Get(ShadowHeader))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(PolicyResponse{Result: false, Labels: map[string]bool{"adult": false}})
+	}))
+	t.Cleanup(shadowEngine.Close)
+
+	var audit auditRecords
+	shadow, err := New(shadowEngine.URL, WithAuditSink(&audit))
+	require.NoError(t, err)
+	got := newComparisons()
+	c, err := New(primary.URL, WithShadow(shadow, 1, got.sink))
+	require.NoError(t, err)
+
+	ctx := ContextWithDecisionID(context.Background(), "decision-1")
+	response, err := c.EvaluatePolicy(ctx, "rule", map[string]interface{}{"age": 20}, false)
+	require.NoError(t, err)
+	assert.True(t, response.Result)
+
+	comparison := got.wait(t, 1)[0]
+	assert.Equal(t, "decision-1", comparison.DecisionID)
+	assert.True(t, comparison.Primary.Result)
+	assert.False(t, comparison.Shadow.Result)
+	assert.NoError(t, comparison.ShadowErr)
+	assert.Equal(t, "rule", comparison.Request.Rule)
+	marked, ok := headers.Load("decision-1")
+	require.True(t, ok)
+	assert.Equal(t, "true", marked)
+	assert.Len(t, primary.Requests(), 1, "the primary engine sees no shadow traffic")
+
+	records := audit.all()
+	require.Len(t, records, 1)
+	assert.True(t, records[0].Shadow)
+}
+
+// auditRecords is an AuditSink keeping what it is given
+type auditRecords struct {
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func (a *auditRecords) Record(_ context.Context, record AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records = append(a.records, record)
+	return nil
+}
+
+func (a *auditRecords) all() []AuditRecord {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]AuditRecord(nil), a.records...)
+}
+
+// TestShadowSlowOrDown tests that a stalled or unreachable shadow neither
+// delays nor fails the primary calls, and that mirrors beyond the limit are
+// dropped and counted
+func TestShadowSlowOrDown(t *testing.T) {
+	primary := newFakeEngine(t)
+
+	t.Run("slow", func(t *testing.T) {
+		stalled := &stalledEvaluator{release: make(chan struct{})}
+		got := newComparisons()
+		c, err := New(primary.URL, WithShadow(stalled, 1, got.sink))
+		require.NoError(t, err)
+
+		began := time.Now()
+		for i := 0; i < defaultShadowSlots+36; i++ {
+			_, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+			require.NoError(t, err)
+		}
+		assert.Less(t, time.Since(began), 2*time.Second)
+		assert.EqualValues(t, 36, c.ShadowDropped())
+
+		close(stalled.release)
+		assert.Len(t, got.wait(t, defaultShadowSlots), defaultShadowSlots)
+		assert.EqualValues(t, defaultShadowSlots, stalled.calls.Load())
+	})
+
+	t.Run("down", func(t *testing.T) {
+		shadow, err := New(closedURL(t))
+		require.NoError(t, err)
+		got := newComparisons()
+		c, err := New(primary.URL, WithShadow(shadow, 1, got.sink))
+		require.NoError(t, err)
+
+		response, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+		require.NoError(t, err)
+		assert.True(t, response.Result)
+		assert.ErrorIs(t, got.wait(t, 1)[0].ShadowErr, ErrConnection)
+	})
+
+	t.Run("panicking", func(t *testing.T) {
+		got := newComparisons()
+		c, err := New(primary.URL, WithShadow(panicEvaluator{}, 1, got.sink))
+		require.NoError(t, err)
+
+		_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+		require.NoError(t, err)
+		var panicErr *CallbackPanicError
+		assert.ErrorAs(t, got.wait(t, 1)[0].ShadowErr, &panicErr)
+	})
+}
+
+// TestShadowShutdown tests that Shutdown waits for outstanding mirrors, and
+// that Close cancels them without calling the sink and stops mirroring
+func TestShadowShutdown(t *testing.T) {
+	primary := newFakeEngine(t)
+
+	t.Run("shutdown", func(t *testing.T) {
+		stalled := &stalledEvaluator{release: make(chan struct{})}
+		got := newComparisons()
+		c, err := New(primary.URL, WithShadow(stalled, 1, got.sink))
+		require.NoError(t, err)
+		_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return stalled.calls.Load() == 1 }, time.Second, time.Millisecond)
+
+		shutdown := make(chan error, 1)
+		go func() { shutdown <- c.Shutdown(context.Background()) }()
+		select {
+		case <-shutdown:
+			t.Fatal("Shutdown returned with a mirror outstanding")
+		case <-time.After(50 * time.Millisecond):
+		}
+		close(stalled.release)
+		require.NoError(t, <-shutdown)
+		assert.Len(t, got.got, 1, "the sink was called before Shutdown returned")
+	})
+
+	t.Run("close", func(t *testing.T) {
+		stalled := &stalledEvaluator{release: make(chan struct{})}
+		got := newComparisons()
+		c, err := New(primary.URL, WithShadow(stalled, 1, got.sink))
+		require.NoError(t, err)
+		_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return stalled.calls.Load() == 1 }, time.Second, time.Millisecond)
+
+		require.NoError(t, c.Close())
+		c.connectionOwner().tasks.Wait()
+		_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, stalled.calls.Load(), "nothing is mirrored once closed")
+		assert.Empty(t, got.got)
+	})
+}
+
+// TestShadowSampling tests that only the sampled fraction is mirrored
+func TestShadowSampling(t *testing.T) {
+	primary := newFakeEngine(t)
+	shadow := newFakeEngine(t)
+	shadowClient, err := New(shadow.URL)
+	require.NoError(t, err)
+
+	for _, rate := range []float64{0, 0.3, 1} {
+		got := newComparisons()
+		c, err := New(primary.URL, WithShadow(shadowClient, rate, got.sink))
+		require.NoError(t, err)
+		calls := 0
+		c.shadow.sample = func() float64 {
+			calls++
+			return float64(calls%10) / 10
+		}
+
+		before := len(shadow.Requests())
+		for i := 0; i < 100; i++ {
+			_, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+			require.NoError(t, err)
+		}
+		want := int(rate * 100)
+		got.wait(t, want)
+		assert.Len(t, shadow.Requests(), before+want, "rate %v", rate)
+	}
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrClientClosed is returned by evaluations started after Shutdown
+var ErrClientClosed = errors.New("client: shut down")
+
+// inFlight counts running evaluations so Shutdown can wait for them
+type inFlight struct {
+	mu      sync.Mutex
+	closed  bool
+	running int
+	// idle is closed once the client is shut down and nothing is running
+	idle chan struct{}
+}
+
+// begin admits an evaluation, returning the function that ends it
+func (f *inFlight) begin() (func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, ErrClientClosed
+	}
+	f.running++
+	return f.end, nil
+}
+
+func (f *inFlight) end() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.running--
+	if f.closed && f.running == 0 {
+		close(f.idle)
+	}
+}
+
+// close stops admitting evaluations and returns a channel closed once the
+// running ones have finished
+func (f *inFlight) close() <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		f.idle = make(chan struct{})
+		if f.running == 0 {
+			close(f.idle)
+		}
+	}
+	return f.idle
+}
+
+func (f *inFlight) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.running
+}
+
+// Shutdown stops the client accepting evaluations, which then fail with
+// ErrClientClosed, and waits for the ones in flight to finish, and then for
+// the shadow mirrors and cache refreshes they started, before calling Close.
+// If ctx ends first, Shutdown still calls Close, which cancels the background
+// work, and returns ctx's error; the evaluations still in flight run to
+// completion on their own. Calling Shutdown again waits again.
+func (c *PolicyClient) Shutdown(ctx context.Context) error {
+	idle := c.inFlight.close()
+
+	var err error
+	select {
+	case <-idle:
+		tasks := make(chan struct{})
+		go func() {
+			c.connectionOwner().tasks.Wait()
+			close(tasks)
+		}()
+		select {
+		case <-tasks:
+		case <-ctx.Done():
+			err = fmt.Errorf("shutdown with background work running: %w", ctx.Err())
+		}
+	case <-ctx.Done():
+		err = fmt.Errorf("shutdown with %d evaluations in flight: %w", c.inFlight.count(), ctx.Err())
+	}
+	// Audit records are written as each evaluation finishes, and metrics and
+	// traces go to the registry and providers the caller owns, so nothing
+	// else is left to flush
+	_ = c.Close()
+	return err
+}
+
+// goBackground runs task on its own goroutine, tracked for Shutdown, under
+// a copy of ctx that keeps its values but is cancelled when the client is
+// closed rather than when ctx is. It reports false, running nothing, once
+// the client is closed.
+func (c *PolicyClient) goBackground(ctx context.Context, task func(ctx context.Context)) bool {
+	if c.closed() {
+		return false
+	}
+	owner := c.connectionOwner()
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(owner.background, cancel)
+	owner.tasks.Add(1)
+	go func() {
+		defer owner.tasks.Done()
+		defer cancel()
+		defer stop()
+		task(ctx)
+	}()
+	return true
+}
+
+// closed reports whether the client has been closed
+func (c *PolicyClient) closed() bool {
+	return c.connectionOwner().background.Err() != nil
+}
+
+// ShutdownHook returns a function that shuts the client down, allowing in-flight
+// evaluations up to timeout, for server shutdown hooks such as
+// http.Server.RegisterOnShutdown
+func (c *PolicyClient) ShutdownHook(timeout time.Duration) func() {
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		_ = c.Shutdown(ctx)
+	}
+}
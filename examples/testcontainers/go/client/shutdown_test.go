@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatedEngine holds every evaluation until release is closed
+type gatedEngine struct {
+	*httptest.Server
+
+	arrived  chan struct{}
+	release  chan struct{}
+	requests int64
+}
+
+func newGatedEngine(t *testing.T) *gatedEngine {
+	t.Helper()
+
+	engine := &gatedEngine{arrived: make(chan struct{}, 16), release: make(chan struct{})}
+	engine.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		atomic.AddInt64(&engine.requests, 1)
+		engine.arrived <- struct{}{}
+		<-engine.release
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"result":true,"rule":["rule"],"data":null}`)
+	}))
+	t.Cleanup(engine.Close)
+
+	return engine
+}
+
+// TestShutdownDrainsInFlight tests that in-flight evaluations complete before Shutdown returns
+func TestShutdownDrainsInFlight(t *testing.T) {
+	engine := newGatedEngine(t)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	type result struct {
+		response *PolicyResponse
+		err      error
+	}
+	results := make(chan result, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			response, err := c.EvaluatePolicy(context.Background(), "rule", nil, false)
+			results <- result{response, err}
+		}()
+		<-engine.arrived
+	}
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- c.Shutdown(context.Background()) }()
+
+	select {
+	case <-shutdown:
+		t.Fatal("Shutdown returned with evaluations in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(engine.release)
+
+	require.NoError(t, <-shutdown)
+	for i := 0; i < 3; i++ {
+		r := <-results
+		require.NoError(t, r.err)
+		assert.True(t, r.response.Result)
+	}
+}
+
+// TestShutdownWaitsForRefresh tests that Shutdown waits for a cache refresh
+// an evaluation started in the background
+func TestShutdownWaitsForRefresh(t *testing.T) {
+	engine := newGatedEngine(t)
+	clock := newTestClock()
+	c, err := New(engine.URL, WithClock(clock.Now), WithCache(NewMemoryCache(), time.Minute),
+		WithCacheMode(StaleWhileRevalidate(time.Hour)))
+	require.NoError(t, err)
+
+	first := make(chan error, 1)
+	go func() { _, err := evaluateCached(t, c); first <- err }()
+	<-engine.arrived
+	engine.release <- struct{}{}
+	require.NoError(t, <-first)
+
+	clock.Advance(2 * time.Minute)
+	_, err = evaluateCached(t, c)
+	require.NoError(t, err, "the stale entry is served at once")
+	<-engine.arrived
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- c.Shutdown(context.Background()) }()
+	select {
+	case <-shutdown:
+		t.Fatal("Shutdown returned with a refresh running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	engine.release <- struct{}{}
+	require.NoError(t, <-shutdown)
+	assert.Equal(t, int64(1), c.CacheStats().Refreshes)
+	assert.Zero(t, c.CacheStats().RefreshFailures)
+}
+
+// TestShutdownDeadline tests that Shutdown gives up on evaluations outliving its context
+func TestShutdownDeadline(t *testing.T) {
+	engine := newGatedEngine(t)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.EvaluatePolicy(context.Background(), "rule", nil, false)
+		done <- err
+	}()
+	<-engine.arrived
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = c.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.EqualError(t, err, "shutdown with 1 evaluations in flight: context deadline exceeded")
+
+	// The straggler still finishes on its own
+	close(engine.release)
+	assert.NoError(t, <-done)
+}
+
+// TestShutdownRejectsNewEvaluations tests that every way of evaluating fails once shut down
+func TestShutdownRejectsNewEvaluations(t *testing.T) {
+	engine := newGatedEngine(t)
+	close(engine.release)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+	prepared, err := c.Prepare("rule")
+	require.NoError(t, err)
+
+	require.NoError(t, c.Shutdown(context.Background()))
+	require.NoError(t, c.Shutdown(context.Background()), "shutting down twice is harmless")
+
+	_, err = c.EvaluatePolicy(context.Background(), "rule", nil, false)
+	assert.ErrorIs(t, err, ErrClientClosed)
+	_, err = prepared.Evaluate(context.Background(), nil)
+	assert.ErrorIs(t, err, ErrClientClosed)
+	results, err := c.EvaluateBatch(context.Background(), "rule", []interface{}{nil, nil})
+	assert.ErrorIs(t, err, ErrClientClosed)
+	assert.Equal(t, []*PolicyResponse{nil, nil}, results.Responses())
+
+	assert.Zero(t, atomic.LoadInt64(&engine.requests))
+}
+
+// TestShutdownHook tests the hook for http.Server.RegisterOnShutdown
+func TestShutdownHook(t *testing.T) {
+	engine := newGatedEngine(t)
+	close(engine.release)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	server := &http.Server{}
+	server.RegisterOnShutdown(c.ShutdownHook(time.Second))
+	require.NoError(t, server.Shutdown(context.Background()))
+
+	require.Eventually(t, func() bool {
+		_, err := c.EvaluatePolicy(context.Background(), "rule", nil, false)
+		return errors.Is(err, ErrClientClosed)
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"policy-engine-testcontainer-example/selectors"
+)
+
+// WithStrictData checks each evaluation's data against the selectors of its
+// rule before sending it, and fails the call with a *DataShapeError when an
+// object or property the rule reads is missing or holds the wrong type,
+// instead of letting the engine answer false. The data is checked as it
+// would be sent, after base data, aliases and transforms; data streamed from
+// an io.Reader is not checked.
+func WithStrictData() Option {
+	return func(c *PolicyClient) {
+		c.strictData = true
+	}
+}
+
+// DataShapeError is returned under WithStrictData for data that does not
+// have the shape the rule reads
+type DataShapeError struct {
+	Issues []selectors.DataIssue
+}
+
+func (e *DataShapeError) Error() string {
+	if len(e.Issues) == 1 {
+		return "data does not match the rule: " + e.Issues[0].String()
+	}
+	return fmt.Sprintf("data does not match the rule: %s, and %d more issues", e.Issues[0], len(e.Issues)-1)
+}
+
+// checkDataShape returns the *DataShapeError for prepared data, if any
+func checkDataShape(rule string, data interface{}) error {
+	switch d := data.(type) {
+	case *readerData:
+		return nil
+	case rawJSON:
+		data = json.RawMessage(d)
+	}
+	if issues := selectors.CheckData(rule, data); len(issues) > 0 {
+		return &DataShapeError{Issues: issues}
+	}
+	return nil
+}
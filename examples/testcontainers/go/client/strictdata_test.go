@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"policy-engine-testcontainer-example/selectors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStrictData tests that data of the wrong shape fails before it is sent,
+// instead of evaluating to false
+func TestStrictData(t *testing.T) {
+	const rule = "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+	ctx := context.Background()
+	engine := newFakeEngine(t)
+
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(ctx, rule, map[string]interface{}{"age": 70}, false)
+	require.NoError(t, err, "the flat data of TestPolicyEngineConnection goes through unchecked")
+	require.Len(t, engine.Requests(), 1)
+
+	strict, err := New(engine.URL, WithStrictData())
+	require.NoError(t, err)
+	_, err = strict.EvaluatePolicy(ctx, rule, map[string]interface{}{"age": 70}, false)
+	var shapeErr *DataShapeError
+	require.True(t, errors.As(err, &shapeErr), "got %v", err)
+	assert.Equal(t, selectors.DataMissingObject, shapeErr.Issues[0].Kind)
+	assert.EqualError(t, err, "data does not match the rule: the data has no Person object (age is at the top level; the rule reads Person.age)")
+	assert.Len(t, engine.Requests(), 1, "nothing is sent")
+
+	_, err = strict.EvaluatePolicy(ctx, rule, map[string]interface{}{"Person": map[string]interface{}{"age": "70"}}, false)
+	assert.EqualError(t, err, "data does not match the rule: Person.age is a string, but the rule compares it as a number")
+
+	for _, data := range []interface{}{
+		map[string]interface{}{"Person": map[string]interface{}{"age": 70}},
+		json.RawMessage(`{"Person": {"age": 70}}`),
+		strings.NewReader(`{"age": 70}`),
+	} {
+		_, err = strict.EvaluatePolicy(ctx, rule, data, false)
+		assert.NoError(t, err, "%T", data)
+	}
+	assert.Len(t, engine.Requests(), 4, "readers are not checked")
+
+	// The data is checked after base data fills it in
+	based, err := New(engine.URL, WithStrictData(), WithBaseData(map[string]interface{}{"Person": map[string]interface{}{"age": 70}}))
+	require.NoError(t, err)
+	_, err = based.EvaluatePolicy(ctx, rule, map[string]interface{}{}, false)
+	assert.NoError(t, err)
+
+	_, err = strict.EvaluatePolicy(ctx, "A **Order** gets x if the __total__ of the **Order** is at least 1 and the __level__ of the **Customer** is in [1].", map[string]interface{}{}, false)
+	assert.EqualError(t, err, "data does not match the rule: the data has no Order object, and 1 more issues")
+}
@@ -0,0 +1,198 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+// instrumentationName names the client's tracer and meter
+const instrumentationName = "policy-engine-testcontainer-example/client"
+
+// EvaluateSpanName is the name of the span around each evaluation
+const EvaluateSpanName = "policy.evaluate"
+
+// The attributes of evaluation spans and metrics
+const (
+	AttributeRuleHash   = attribute.Key("policy.rule_hash")
+	AttributeTrace      = attribute.Key("policy.trace")
+	AttributeResult     = attribute.Key("policy.result")
+	AttributeLabelCount = attribute.Key("policy.label_count")
+	AttributeDecisionID = attribute.Key("policy.decision_id")
+	AttributeDegraded   = attribute.Key("policy.degraded")
+	// AttributeErrorType classifies a failed evaluation: an engine error's
+	// code, "engine_error" for one without, "transport", "timeout" or
+	// "other"
+	AttributeErrorType = attribute.Key("error.type")
+)
+
+// The client's metrics
+const (
+	// MetricDuration is a histogram of evaluation latency in seconds
+	MetricDuration = "policy.evaluate.duration"
+	// MetricErrors counts failed evaluations
+	MetricErrors = "policy.evaluate.errors"
+	// MetricHedges counts the hedges WithHedging sends
+	MetricHedges = "policy.evaluate.hedges"
+	// MetricCoalesced counts evaluations WithCoalescing answered with
+	// another caller's request
+	MetricCoalesced = "policy.evaluate.coalesced"
+)
+
+// WithTracerProvider creates a span named policy.evaluate for every
+// evaluation, with the rule's hash, the trace flag, the result and the
+// number of labels as attributes. Requests carry the span in a W3C
+// traceparent header, so the engine can correlate its own traces. Without
+// it the client traces nothing.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *PolicyClient) {
+		c.telemetry.tracerProvider = provider
+	}
+}
+
+// WithMeterProvider records the latency of every evaluation in the
+// policy.evaluate.duration histogram and counts failures in
+// policy.evaluate.errors, hedges in policy.evaluate.hedges and coalesced
+// evaluations in policy.evaluate.coalesced. Without it the client records
+// nothing.
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(c *PolicyClient) {
+		c.telemetry.meterProvider = provider
+	}
+}
+
+// telemetry is the client's tracer and instruments, no-ops unless
+// configured
+type telemetry struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+
+	tracer    trace.Tracer
+	duration  metric.Float64Histogram
+	errors    metric.Int64Counter
+	hedges    metric.Int64Counter
+	coalesced metric.Int64Counter
+}
+
+// configureTelemetry creates the tracer and instruments
+func (c *PolicyClient) configureTelemetry() error {
+	t := &c.telemetry
+	if t.tracerProvider == nil {
+		t.tracerProvider = tracenoop.NewTracerProvider()
+	}
+	if t.meterProvider == nil {
+		t.meterProvider = metricnoop.NewMeterProvider()
+	}
+	t.tracer = t.tracerProvider.Tracer(instrumentationName)
+	meter := t.meterProvider.Meter(instrumentationName)
+
+	var err error
+	t.duration, err = meter.Float64Histogram(MetricDuration,
+		metric.WithDescription("Latency of policy evaluations"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return fmt.Errorf("failed to create %s histogram: %w", MetricDuration, err)
+	}
+	t.errors, err = meter.Int64Counter(MetricErrors,
+		metric.WithDescription("Failed policy evaluations"),
+		metric.WithUnit("{evaluation}"))
+	if err != nil {
+		return fmt.Errorf("failed to create %s counter: %w", MetricErrors, err)
+	}
+	t.hedges, err = meter.Int64Counter(MetricHedges,
+		metric.WithDescription("Hedged copies of policy evaluations"),
+		metric.WithUnit("{request}"))
+	if err != nil {
+		return fmt.Errorf("failed to create %s counter: %w", MetricHedges, err)
+	}
+	t.coalesced, err = meter.Int64Counter(MetricCoalesced,
+		metric.WithDescription("Policy evaluations answered by an identical evaluation's request"),
+		metric.WithUnit("{evaluation}"))
+	if err != nil {
+		return fmt.Errorf("failed to create %s counter: %w", MetricCoalesced, err)
+	}
+	return nil
+}
+
+// hedge counts a hedged request
+func (t *telemetry) hedge(ctx context.Context) {
+	t.hedges.Add(ctx, 1)
+}
+
+// coalesce counts an evaluation answered by another's request
+func (t *telemetry) coalesce(ctx context.Context) {
+	t.coalesced.Add(ctx, 1)
+}
+
+// startSpan starts the span around an evaluation of req
+func (t *telemetry) startSpan(ctx context.Context, req PolicyRequest) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, EvaluateSpanName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			AttributeRuleHash.String(RuleHash(req.Rule)),
+			AttributeTrace.Bool(req.Trace),
+		))
+}
+
+// finish ends span and records the evaluation's latency, and its failure if
+// it failed
+func (t *telemetry) finish(ctx context.Context, span trace.Span, id string, elapsed time.Duration, response *PolicyResponse, err error) {
+	var attrs []attribute.KeyValue
+	if response != nil {
+		attrs = append(attrs,
+			AttributeResult.Bool(response.Result),
+			AttributeLabelCount.Int(len(response.Labels)),
+			AttributeDegraded.Bool(response.Degraded))
+	}
+	span.SetAttributes(append(attrs, AttributeDecisionID.String(id))...)
+
+	// The rule's hash stays off the metrics, whose every attribute value is
+	// a time series
+	measured := attrs
+	if err != nil {
+		kind := errorType(err)
+		measured = append(measured, AttributeErrorType.String(kind))
+		span.SetAttributes(AttributeErrorType.String(kind))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		t.errors.Add(ctx, 1, metric.WithAttributes(AttributeErrorType.String(kind)))
+	}
+	t.duration.Record(ctx, elapsed.Seconds(), metric.WithAttributes(measured...))
+	span.End()
+}
+
+// errorType is the error.type attribute of err
+func errorType(err error) string {
+	var engineErr *EngineError
+	var transportErr *TransportError
+	switch {
+	case errors.As(err, &engineErr):
+		if engineErr.Code != "" {
+			return engineErr.Code
+		}
+		return "engine_error"
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &transportErr):
+		return "transport"
+	default:
+		return "other"
+	}
+}
+
+// injectTraceContext adds the W3C traceparent header of ctx's span to header
+func injectTraceContext(ctx context.Context, header http.Header) {
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(header))
+}
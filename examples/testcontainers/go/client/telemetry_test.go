@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestTelemetry tests that evaluations are traced and measured, and carry
+// their span to the engine
+func TestTelemetry(t *testing.T) {
+	var mu sync.Mutex
+	var traceparents []string
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		mu.Unlock()
+		var req PolicyRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		if req.Rule == "broken" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": false, "error": "Parse error: broken"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": true, "rule": []string{req.Rule}, "labels": map[string]bool{"adult": true, "resident": false}})
+	}))
+	defer engine.Close()
+
+	spans := tracetest.NewSpanRecorder()
+	metrics := sdkmetric.NewManualReader()
+	c, err := New(engine.URL,
+		WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))),
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(metrics))))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = c.Evaluate(ctx, PolicyRequest{Rule: "rule", Data: map[string]interface{}{}, Trace: true})
+	require.NoError(t, err)
+	_, err = c.Evaluate(ctx, PolicyRequest{Rule: "broken", Data: map[string]interface{}{}})
+	require.Error(t, err)
+
+	ended := spans.Ended()
+	require.Len(t, ended, 2)
+	passed, failed := ended[0], ended[1]
+	assert.Equal(t, EvaluateSpanName, passed.Name())
+	attrs := attribute.NewSet(passed.Attributes()...)
+	for key, want := range map[attribute.Key]attribute.Value{
+		AttributeRuleHash:   attribute.StringValue(RuleHash("rule")),
+		AttributeTrace:      attribute.BoolValue(true),
+		AttributeResult:     attribute.BoolValue(true),
+		AttributeLabelCount: attribute.IntValue(2),
+	} {
+		got, ok := attrs.Value(key)
+		assert.True(t, ok, key)
+		assert.Equal(t, want, got, key)
+	}
+	assert.Equal(t, codes.Error, failed.Status().Code)
+	failedAttrs := attribute.NewSet(failed.Attributes()...)
+	errType, _ := failedAttrs.Value(AttributeErrorType)
+	assert.Equal(t, "engine_error", errType.AsString())
+
+	require.Len(t, traceparents, 2)
+	for i, span := range ended {
+		assert.True(t, strings.Contains(traceparents[i], span.SpanContext().TraceID().String()), traceparents[i])
+	}
+
+	var collected metricdata.ResourceMetrics
+	require.NoError(t, metrics.Collect(ctx, &collected))
+	require.Len(t, collected.ScopeMetrics, 1)
+	byName := map[string]metricdata.Aggregation{}
+	for _, m := range collected.ScopeMetrics[0].Metrics {
+		byName[m.Name] = m.Data
+	}
+	histogram, ok := byName[MetricDuration].(metricdata.Histogram[float64])
+	require.True(t, ok, byName)
+	var count uint64
+	for _, point := range histogram.DataPoints {
+		count += point.Count
+		_, hashed := point.Attributes.Value(AttributeRuleHash)
+		assert.False(t, hashed, "rule hashes stay off metrics")
+	}
+	assert.Equal(t, uint64(2), count)
+	errorsCounted, ok := byName[MetricErrors].(metricdata.Sum[int64])
+	require.True(t, ok, byName)
+	require.Len(t, errorsCounted.DataPoints, 1)
+	assert.Equal(t, int64(1), errorsCounted.DataPoints[0].Value)
+}
+
+// TestTelemetryDefault tests that a client without providers sends no trace
+// context
+func TestTelemetryDefault(t *testing.T) {
+	var traceparent string
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"result": true}`))
+	}))
+	defer engine.Close()
+
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+	_, err = c.Evaluate(context.Background(), PolicyRequest{Rule: "rule", Data: map[string]interface{}{}})
+	require.NoError(t, err)
+	assert.Empty(t, traceparent)
+}
@@ -0,0 +1,190 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AdaptiveConfig sets how WithAdaptiveTimeout derives each call's deadline
+type AdaptiveConfig struct {
+	// Percentile of recent latencies the deadline is based on, e.g. 0.99
+	Percentile float64 `yaml:"percentile"`
+	// Multiplier scales the percentile latency into the deadline, e.g. 3
+	Multiplier float64 `yaml:"multiplier"`
+	// Min and Max bound the deadline; Max is also used until a rule has
+	// MinSamples observations, and zero leaves those calls without one
+	Min time.Duration `yaml:"min,omitempty"`
+	Max time.Duration `yaml:"max,omitempty"`
+	// Window is how many recent latencies are kept per rule; default 100
+	Window int `yaml:"window,omitempty"`
+	// MinSamples is how many latencies a rule needs before its deadline
+	// adapts; default 20
+	MinSamples int `yaml:"min_samples,omitempty"`
+	// MaxRules bounds how many rules are tracked at once, evicting the least
+	// recently used; default 1000
+	MaxRules int `yaml:"max_rules,omitempty"`
+}
+
+const (
+	defaultAdaptiveWindow     = 100
+	defaultAdaptiveMinSamples = 20
+	defaultAdaptiveMaxRules   = 1000
+)
+
+// WithAdaptiveTimeout gives every evaluation a deadline of Multiplier times
+// the Percentile latency recently observed for the same rule, clamped to
+// [Min, Max]. Rules are told apart by a hash of their text. A call that hits
+// its deadline is recorded at the deadline, so the deadline grows again when
+// the engine slows down as a whole. A deadline already on the caller's
+// context still applies if it is sooner.
+func WithAdaptiveTimeout(cfg AdaptiveConfig) Option {
+	return func(c *PolicyClient) {
+		if cfg.Window <= 0 {
+			cfg.Window = defaultAdaptiveWindow
+		}
+		if cfg.MinSamples <= 0 {
+			cfg.MinSamples = defaultAdaptiveMinSamples
+		}
+		if cfg.MinSamples > cfg.Window {
+			cfg.MinSamples = cfg.Window
+		}
+		if cfg.MaxRules <= 0 {
+			cfg.MaxRules = defaultAdaptiveMaxRules
+		}
+		c.latencies = newLatencyTracker(cfg)
+	}
+}
+
+// AdaptiveTimeout returns the deadline the next evaluation of rule would get,
+// or zero if WithAdaptiveTimeout is not configured
+func (c *PolicyClient) AdaptiveTimeout(rule string) time.Duration {
+	if c.latencies == nil {
+		return 0
+	}
+	return c.latencies.timeout(ruleKey(rule))
+}
+
+// withAdaptiveDeadline applies the rule's current deadline to ctx, or a
+// profile's fixed timeout, and returns a function recording how the call went
+func (c *PolicyClient) withAdaptiveDeadline(ctx context.Context, rule string) (context.Context, func(error)) {
+	if c.timeout > 0 {
+		return c.withFixedDeadline(ctx)
+	}
+	if c.latencies == nil {
+		return ctx, func(error) {}
+	}
+	return c.withRuleDeadline(ctx, ruleKey(rule))
+}
+
+// withRuleDeadline is withAdaptiveDeadline for an already hashed rule
+func (c *PolicyClient) withRuleDeadline(ctx context.Context, key ruleHash) (context.Context, func(error)) {
+	timeout := c.latencies.timeout(key)
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		// Without a Max there is no deadline until the rule has samples
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	start := time.Now()
+	return ctx, func(err error) {
+		elapsed := time.Since(start)
+		timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+		cancel()
+		switch {
+		case err == nil:
+			c.latencies.observe(key, elapsed)
+		case timedOut:
+			c.latencies.observe(key, timeout)
+		}
+	}
+}
+
+type ruleHash [sha256.Size]byte
+
+func ruleKey(rule string) ruleHash {
+	return sha256.Sum256([]byte(rule))
+}
+
+// latencyTracker keeps a bounded sliding window of latencies for a bounded,
+// least recently used set of rules
+type latencyTracker struct {
+	cfg AdaptiveConfig
+
+	mu    sync.Mutex
+	rules map[ruleHash]*list.Element
+	lru   *list.List
+}
+
+// ruleLatencies is a ring of a rule's most recent latencies
+type ruleLatencies struct {
+	key     ruleHash
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func newLatencyTracker(cfg AdaptiveConfig) *latencyTracker {
+	return &latencyTracker{cfg: cfg, rules: map[ruleHash]*list.Element{}, lru: list.New()}
+}
+
+func (t *latencyTracker) observe(key ruleHash, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	element, ok := t.rules[key]
+	if !ok {
+		if t.lru.Len() >= t.cfg.MaxRules {
+			oldest := t.lru.Back()
+			t.lru.Remove(oldest)
+			delete(t.rules, oldest.Value.(*ruleLatencies).key)
+		}
+		element = t.lru.PushFront(&ruleLatencies{key: key, samples: make([]time.Duration, 0, t.cfg.Window)})
+		t.rules[key] = element
+	}
+	t.lru.MoveToFront(element)
+
+	r := element.Value.(*ruleLatencies)
+	if !r.full {
+		r.samples = append(r.samples, latency)
+		r.full = len(r.samples) == t.cfg.Window
+		return
+	}
+	r.samples[r.next] = latency
+	r.next = (r.next + 1) % t.cfg.Window
+}
+
+func (t *latencyTracker) timeout(key ruleHash) time.Duration {
+	t.mu.Lock()
+	var sorted []time.Duration
+	if element, ok := t.rules[key]; ok {
+		sorted = append(sorted, element.Value.(*ruleLatencies).samples...)
+	}
+	t.mu.Unlock()
+
+	if len(sorted) < t.cfg.MinSamples {
+		return t.cfg.Max
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	// Nearest rank
+	index := int(math.Ceil(t.cfg.Percentile*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+
+	timeout := time.Duration(float64(sorted[index]) * t.cfg.Multiplier)
+	if timeout < t.cfg.Min {
+		timeout = t.cfg.Min
+	}
+	if t.cfg.Max > 0 && timeout > t.cfg.Max {
+		timeout = t.cfg.Max
+	}
+	return timeout
+}
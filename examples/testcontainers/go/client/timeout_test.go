@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testAdaptiveConfig() AdaptiveConfig {
+	return AdaptiveConfig{
+		Percentile: 0.99,
+		Multiplier: 3,
+		Min:        5 * time.Millisecond,
+		Max:        2 * time.Second,
+		Window:     50,
+		MinSamples: 10,
+		MaxRules:   3,
+	}
+}
+
+// TestLatencyTrackerAdapts tests that the deadline follows a latency shift within one window
+func TestLatencyTrackerAdapts(t *testing.T) {
+	tracker := newLatencyTracker(testAdaptiveConfig())
+	key := ruleKey("rule")
+
+	assert.Equal(t, 2*time.Second, tracker.timeout(key), "Max until there are enough samples")
+	for i := 0; i < 9; i++ {
+		tracker.observe(key, 10*time.Millisecond)
+	}
+	assert.Equal(t, 2*time.Second, tracker.timeout(key))
+	tracker.observe(key, 10*time.Millisecond)
+	assert.Equal(t, 30*time.Millisecond, tracker.timeout(key))
+
+	// The engine slows down; within one window the deadline follows
+	for i := 0; i < 50; i++ {
+		tracker.observe(key, 100*time.Millisecond)
+	}
+	assert.Equal(t, 300*time.Millisecond, tracker.timeout(key))
+
+	// And it comes back down once the slow samples have left the window
+	for i := 0; i < 50; i++ {
+		tracker.observe(key, time.Millisecond)
+	}
+	assert.Equal(t, 5*time.Millisecond, tracker.timeout(key), "clamped to Min")
+
+	for i := 0; i < 50; i++ {
+		tracker.observe(key, time.Second)
+	}
+	assert.Equal(t, 2*time.Second, tracker.timeout(key), "clamped to Max")
+}
+
+// TestLatencyTrackerBounded tests that only MaxRules rules are kept, least recently used first out
+func TestLatencyTrackerBounded(t *testing.T) {
+	tracker := newLatencyTracker(testAdaptiveConfig())
+	for rule := 0; rule < 10; rule++ {
+		for i := 0; i < 10; i++ {
+			tracker.observe(ruleKey(fmt.Sprint(rule)), 10*time.Millisecond)
+		}
+		// Keep rule 0 in use
+		tracker.observe(ruleKey("0"), 10*time.Millisecond)
+	}
+
+	assert.Len(t, tracker.rules, 3)
+	assert.Equal(t, 3, tracker.lru.Len())
+	assert.Equal(t, 30*time.Millisecond, tracker.timeout(ruleKey("0")))
+	assert.Equal(t, 30*time.Millisecond, tracker.timeout(ruleKey("9")))
+	assert.Equal(t, 2*time.Second, tracker.timeout(ruleKey("1")), "evicted rules start over")
+}
+
+// TestLatencyTrackerConcurrent tests the tracker under concurrent use; run with -race
+func TestLatencyTrackerConcurrent(t *testing.T) {
+	tracker := newLatencyTracker(testAdaptiveConfig())
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := ruleKey(fmt.Sprint(i % 5))
+				tracker.observe(key, time.Duration(g+1)*time.Millisecond)
+				_ = tracker.timeout(key)
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, tracker.lru.Len(), 3)
+}
+
+// TestWithAdaptiveTimeout tests that calls get the adapted deadline and that timeouts feed back into it
+func TestWithAdaptiveTimeout(t *testing.T) {
+	var delay int64 // nanoseconds
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Duration(atomic.LoadInt64(&delay))):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"result":true,"rule":["rule"],"data":null}`))
+	}))
+	defer server.Close()
+
+	cfg := testAdaptiveConfig()
+	cfg.Min = 20 * time.Millisecond
+	c, err := New(server.URL, WithAdaptiveTimeout(cfg))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	assert.Equal(t, 2*time.Second, c.AdaptiveTimeout("rule"))
+	for i := 0; i < 10; i++ {
+		_, err := c.EvaluatePolicy(ctx, "rule", nil, false)
+		require.NoError(t, err)
+	}
+	timeout := c.AdaptiveTimeout("rule")
+	assert.Less(t, timeout, 200*time.Millisecond)
+	assert.Equal(t, 2*time.Second, c.AdaptiveTimeout("another rule"))
+
+	// A stall well past the adapted deadline is cut off at the deadline
+	atomic.StoreInt64(&delay, int64(time.Second))
+	start := time.Now()
+	_, err = c.EvaluatePolicy(ctx, "rule", nil, false)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
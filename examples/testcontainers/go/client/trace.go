@@ -0,0 +1,391 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// TraceSummary is the condition that decided a failed evaluation, as recovered
+// from its trace. Following failed rule references, it is the first failed
+// comparison of the innermost rule that was evaluated.
+type TraceSummary struct {
+	// Rule is the outcome of the rule holding the condition, and Via the
+	// outcomes of the rules that referenced it, outermost first
+	Rule string
+	Via  []string
+	// Selector and Property name what was compared, Operator how, e.g.
+	// GreaterThanOrEqual; Actual is the data's value and Expected the rule's
+	Selector string
+	Property string
+	Operator string
+	Actual   interface{}
+	Expected interface{}
+	// Reference is set instead of the comparison fields when the condition is
+	// a reference to a rule the trace holds no evaluation of
+	Reference string
+}
+
+// String describes the failed condition, e.g. "driver -> adult failed:
+// $.Person.age is 16, GreaterThanOrEqual 18 expected"
+func (s *TraceSummary) String() string {
+	rule := s.Rule
+	if len(s.Via) > 0 {
+		rule = strings.Join(s.Via, " -> ") + " -> " + s.Rule
+	}
+	if s.Reference != "" {
+		return fmt.Sprintf("%s failed: %s does not meet %q", rule, s.Selector, s.Reference)
+	}
+	return fmt.Sprintf("%s failed: %s is %v, %s %v expected", rule, s.Property, s.Actual, s.Operator, s.Expected)
+}
+
+// Trace is an evaluation's trace: what the engine compared, and how each
+// comparison came out. Keys the engine adds in other releases are ignored.
+type Trace struct {
+	// Execution has an entry for every rule evaluated; the first is the rule
+	// the evaluation's result comes from
+	Execution []RuleTrace `json:"execution"`
+}
+
+// RuleTrace is the evaluation of one rule, e.g. "A **Person** gets discount
+// if ...", whose Outcome is "discount"
+type RuleTrace struct {
+	Label      string           `json:"label,omitempty"`
+	Selector   TraceName        `json:"selector"`
+	Outcome    TraceName        `json:"outcome"`
+	Conditions []ConditionTrace `json:"conditions"`
+	Result     bool             `json:"result"`
+}
+
+// TraceName is a selector or outcome, and where in the rule text it is
+type TraceName struct {
+	Value string         `json:"value"`
+	Pos   *TracePosition `json:"pos,omitempty"`
+}
+
+// TracePosition is a span of one line of the rule text, counting lines from 1
+// and the span's bytes from 0
+type TracePosition struct {
+	Line  int `json:"line"`
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// ConditionTrace is one condition of a rule: a comparison of a property of
+// the data with a value from the rule, or, when Property is nil, a reference
+// to another rule by name
+type ConditionTrace struct {
+	Selector TraceName `json:"selector"`
+
+	// Property is the data's property, with the value read from the data;
+	// Operator is the comparison, e.g. GreaterThanOrEqual, and Value the
+	// rule's side of it
+	Property          *PropertyTrace    `json:"property,omitempty"`
+	Operator          string            `json:"operator,omitempty"`
+	Value             *TypedValue       `json:"value,omitempty"`
+	EvaluationDetails *ComparisonDetail `json:"evaluation_details,omitempty"`
+
+	// RuleName is the referenced rule and ReferencedRuleOutcome the outcome
+	// it was traced under, if it was evaluated
+	RuleName              string `json:"rule_name,omitempty"`
+	ReferencedRuleOutcome string `json:"referenced_rule_outcome,omitempty"`
+
+	Result bool `json:"result"`
+}
+
+// PropertyTrace is a property of the data as a comparison read it
+type PropertyTrace struct {
+	Value interface{} `json:"value"`
+	Path  string      `json:"path"`
+}
+
+// TypedValue is a value with the type the engine compared it as, e.g.
+// "number" or "date"
+type TypedValue struct {
+	Value interface{}    `json:"value"`
+	Type  string         `json:"type"`
+	Pos   *TracePosition `json:"pos,omitempty"`
+}
+
+// ComparisonDetail is both sides of a comparison as the engine resolved them
+type ComparisonDetail struct {
+	LeftValue        TypedValue `json:"left_value"`
+	RightValue       TypedValue `json:"right_value"`
+	ComparisonResult bool       `json:"comparison_result"`
+}
+
+// IsReference reports whether the condition references another rule
+func (c *ConditionTrace) IsReference() bool {
+	return c.Property == nil
+}
+
+// Actual is the data's side of a comparison, as the engine resolved it
+func (c *ConditionTrace) Actual() interface{} {
+	switch {
+	case c.EvaluationDetails != nil:
+		return c.EvaluationDetails.LeftValue.Value
+	case c.Property != nil:
+		return c.Property.Value
+	}
+	return nil
+}
+
+// Expected is the rule's side of a comparison
+func (c *ConditionTrace) Expected() interface{} {
+	if c.Value == nil {
+		return nil
+	}
+	return c.Value.Value
+}
+
+// FailedCondition is a condition that evaluated false, with the rule holding
+// it
+type FailedCondition struct {
+	Rule *RuleTrace
+	*ConditionTrace
+}
+
+// FailedConditions lists every condition of every traced rule that evaluated
+// false, in trace order
+func (t *Trace) FailedConditions() []FailedCondition {
+	if t == nil {
+		return nil
+	}
+	var failed []FailedCondition
+	for r := range t.Execution {
+		rule := &t.Execution[r]
+		for c := range rule.Conditions {
+			if !rule.Conditions[c].Result {
+				failed = append(failed, FailedCondition{Rule: rule, ConditionTrace: &rule.Conditions[c]})
+			}
+		}
+	}
+	return failed
+}
+
+// Number returns the value of the data's property at path, e.g.
+// "Person.age" or "$.Person.age", as the first condition that read it saw
+// it, if it was a number. The number is as the engine wrote it, so an ID
+// beyond 2^53 or a long decimal keeps every digit.
+func (t *Trace) Number(path string) (json.Number, bool) {
+	if t == nil {
+		return "", false
+	}
+	path = strings.TrimPrefix(path, "$.")
+	for _, rule := range t.Execution {
+		for _, condition := range rule.Conditions {
+			if condition.Property == nil || strings.TrimPrefix(condition.Property.Path, "$.") != path {
+				continue
+			}
+			number, ok := condition.Property.Value.(json.Number)
+			return number, ok
+		}
+	}
+	return "", false
+}
+
+// decodeTrace decodes a raw trace, returning nil for one that does not have
+// the shape Trace expects
+func decodeTrace(raw json.RawMessage) *Trace {
+	var trace Trace
+	if err := unmarshalNumbers(raw, &trace); err != nil {
+		return nil
+	}
+	return &trace
+}
+
+// clone deep-copies the trace
+func (t *Trace) clone() *Trace {
+	clone := &Trace{Execution: make([]RuleTrace, len(t.Execution))}
+	for r, rule := range t.Execution {
+		rule.Selector.Pos = clonePosition(rule.Selector.Pos)
+		rule.Outcome.Pos = clonePosition(rule.Outcome.Pos)
+		rule.Conditions = append([]ConditionTrace(nil), rule.Conditions...)
+		for i := range rule.Conditions {
+			condition := &rule.Conditions[i]
+			condition.Selector.Pos = clonePosition(condition.Selector.Pos)
+			if condition.Property != nil {
+				condition.Property = &PropertyTrace{Value: cloneValue(condition.Property.Value), Path: condition.Property.Path}
+			}
+			if condition.Value != nil {
+				value := cloneTypedValue(*condition.Value)
+				condition.Value = &value
+			}
+			if condition.EvaluationDetails != nil {
+				condition.EvaluationDetails = &ComparisonDetail{
+					LeftValue:        cloneTypedValue(condition.EvaluationDetails.LeftValue),
+					RightValue:       cloneTypedValue(condition.EvaluationDetails.RightValue),
+					ComparisonResult: condition.EvaluationDetails.ComparisonResult,
+				}
+			}
+		}
+		clone.Execution[r] = rule
+	}
+	return clone
+}
+
+func clonePosition(p *TracePosition) *TracePosition {
+	if p == nil {
+		return nil
+	}
+	copied := *p
+	return &copied
+}
+
+func cloneTypedValue(v TypedValue) TypedValue {
+	return TypedValue{Value: cloneValue(v.Value), Type: v.Type, Pos: clonePosition(v.Pos)}
+}
+
+// SummarizeTrace finds the decisive failed condition in a raw trace, as sent
+// by the engine when a request asks for one. It returns nil if the evaluation
+// passed or the trace records no failed condition.
+func SummarizeTrace(raw json.RawMessage) (*TraceSummary, error) {
+	var trace Trace
+	if err := unmarshalNumbers(raw, &trace); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal trace: %w", err)
+	}
+	// The first rule is the one the evaluation's result comes from
+	return trace.summarize(0), nil
+}
+
+// summarize finds the decisive failed condition of the rule at start,
+// following the rule references it failed on
+func (t *Trace) summarize(start int) *TraceSummary {
+	if start >= len(t.Execution) || t.Execution[start].Result {
+		return nil
+	}
+
+	rules := make(map[string]int, len(t.Execution))
+	for i, rule := range t.Execution {
+		if _, seen := rules[rule.Outcome.Value]; !seen {
+			rules[rule.Outcome.Value] = i
+		}
+	}
+
+	summary := &TraceSummary{}
+	visited := map[int]bool{}
+	for i := start; ; {
+		visited[i] = true
+		rule := t.Execution[i]
+		summary.Rule = rule.Outcome.Value
+
+		var failed *ConditionTrace
+		for c := range rule.Conditions {
+			if !rule.Conditions[c].Result {
+				failed = &rule.Conditions[c]
+				break
+			}
+		}
+		if failed == nil {
+			return nil
+		}
+
+		if failed.IsReference() {
+			// A rule reference: follow it if the referenced rule was traced
+			next, traced := rules[failed.ReferencedRuleOutcome]
+			if failed.ReferencedRuleOutcome == "" || !traced || visited[next] {
+				summary.Selector = failed.Selector.Value
+				summary.Reference = failed.RuleName
+				return summary
+			}
+			summary.Via = append(summary.Via, summary.Rule)
+			i = next
+			continue
+		}
+
+		summary.Selector = failed.Selector.Value
+		summary.Property = failed.Property.Path
+		summary.Operator = failed.Operator
+		summary.Actual = failed.Actual()
+		summary.Expected = failed.Expected()
+		return summary
+	}
+}
+
+// traceMode selects what a batch keeps of each item's trace
+type traceMode int
+
+const (
+	tracesOff traceMode = iota
+	tracesKeep
+	tracesDiscard
+	tracesSummarize
+)
+
+// WithTraces asks for a trace with every evaluation of the batch and keeps it
+// in the response. Full traces of large batches add up quickly; see
+// DiscardTraces, SummarizeTraces and TraceSink for bounded alternatives.
+func WithTraces() BatchOption {
+	return func(c *batchConfig) {
+		if c.traces == tracesOff {
+			c.traces = tracesKeep
+		}
+	}
+}
+
+// DiscardTraces asks for traces but drops each one as soon as it arrives, so
+// only a TraceSink sees them. The trace is never decoded into a map.
+func DiscardTraces() BatchOption {
+	return func(c *batchConfig) {
+		c.traces = tracesDiscard
+	}
+}
+
+// SummarizeTraces asks for traces and keeps only the decisive failed
+// condition of each, in the response's Summary; Trace is left nil
+func SummarizeTraces() BatchOption {
+	return func(c *batchConfig) {
+		c.traces = tracesSummarize
+	}
+}
+
+// TraceSink asks for traces and hands each one, undecoded, to sink instead of
+// keeping it in the response, unless SummarizeTraces is also given. Calls are
+// made one at a time, in completion order, with the item's index. An error
+// from sink fails that item. Duplicates removed by WithDeduplication are not
+// evaluated, so the sink only sees the first of each.
+func TraceSink(sink func(index int, raw json.RawMessage) error) BatchOption {
+	return func(c *batchConfig) {
+		c.traceSink = sink
+		if c.traces == tracesOff || c.traces == tracesKeep {
+			c.traces = tracesDiscard
+		}
+	}
+}
+
+// evaluateItem evaluates one batch item with the trace handling cfg asks for
+func (c *PolicyClient) evaluateItem(ctx context.Context, cfg *batchConfig, rule string, index int, data interface{}) (*PolicyResponse, error) {
+	req := PolicyRequest{Rule: rule, Data: data, Trace: cfg.traces != tracesOff}
+	if cfg.traces == tracesOff || cfg.traces == tracesKeep {
+		return c.Evaluate(ctx, req)
+	}
+
+	response, err := c.evaluateRequest(ctx, req, true, "")
+	if response == nil {
+		return nil, err
+	}
+	raw := response.rawTrace
+	response.rawTrace = nil
+	if err != nil {
+		// The engine rejected the item; its trace goes nowhere
+		return response, err
+	}
+
+	if cfg.traceSink != nil {
+		err := callback("trace sink", func() error {
+			cfg.sinkMu.Lock()
+			defer cfg.sinkMu.Unlock()
+			return cfg.traceSink(index, raw)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to write trace: %w", err)
+		}
+	}
+	if cfg.traces == tracesSummarize && len(raw) > 0 {
+		if response.Summary, err = SummarizeTrace(raw); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,376 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// engineTrace builds a trace shaped like the engine's for "A **Person** gets
+// discount if they are a senior", where senior compares the age against 65.
+// padding adds passing conditions so traces have a realistic size.
+func engineTrace(age float64, padding int) map[string]interface{} {
+	senior := age >= 65
+	conditions := []interface{}{}
+	for i := 0; i < padding; i++ {
+		conditions = append(conditions, map[string]interface{}{
+			"selector":           map[string]interface{}{"value": "Person"},
+			"property":           map[string]interface{}{"value": fmt.Sprintf("member-%d", i), "path": "$.Person.id"},
+			"operator":           "IsNotEmpty",
+			"value":              map[string]interface{}{"value": nil, "type": "string"},
+			"evaluation_details": nil,
+			"result":             true,
+		})
+	}
+	conditions = append(conditions, map[string]interface{}{
+		"selector": map[string]interface{}{"value": "Person"},
+		"property": map[string]interface{}{"value": age, "path": "$.Person.age"},
+		"operator": "GreaterThanOrEqual",
+		"value":    map[string]interface{}{"value": 65, "type": "number"},
+		"evaluation_details": map[string]interface{}{
+			"left_value":        map[string]interface{}{"value": age, "type": "number"},
+			"right_value":       map[string]interface{}{"value": 65, "type": "number"},
+			"comparison_result": senior,
+		},
+		"result": senior,
+	})
+
+	return map[string]interface{}{
+		"execution": []interface{}{
+			map[string]interface{}{
+				"selector": map[string]interface{}{"value": "Person"},
+				"outcome":  map[string]interface{}{"value": "discount"},
+				"conditions": []interface{}{map[string]interface{}{
+					"selector":                map[string]interface{}{"value": "Person"},
+					"rule_name":               "senior",
+					"referenced_rule_outcome": "senior",
+					"result":                  senior,
+				}},
+				"result": senior,
+			},
+			map[string]interface{}{
+				"selector":   map[string]interface{}{"value": "Person"},
+				"outcome":    map[string]interface{}{"value": "senior"},
+				"conditions": conditions,
+				"result":     senior,
+			},
+		},
+	}
+}
+
+// newTracingEngine answers like the engine would for the rule engineTrace
+// describes, given data of the form {"age": n}
+func newTracingEngine(t testing.TB, padding int) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Data  map[string]float64 `json:"data"`
+			Trace bool               `json:"trace"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response := map[string]interface{}{
+			"result": req.Data["age"] >= 65,
+			"rule":   []string{"rule"},
+			"data":   req.Data,
+		}
+		if req.Trace {
+			response["trace"] = engineTrace(req.Data["age"], padding)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func batchResponses(results BatchResults, err error) ([]*PolicyResponse, error) {
+	return results.Responses(), err
+}
+
+// TestSummarizeTrace tests that the summary follows rule references to the failed comparison
+func TestSummarizeTrace(t *testing.T) {
+	raw, err := json.Marshal(engineTrace(40, 3))
+	require.NoError(t, err)
+
+	summary, err := SummarizeTrace(raw)
+	require.NoError(t, err)
+	assert.Equal(t, &TraceSummary{
+		Rule:     "senior",
+		Via:      []string{"discount"},
+		Selector: "Person",
+		Property: "$.Person.age",
+		Operator: "GreaterThanOrEqual",
+		Actual:   json.Number("40"),
+		Expected: json.Number("65"),
+	}, summary)
+	assert.Equal(t, "discount -> senior failed: $.Person.age is 40, GreaterThanOrEqual 65 expected", summary.String())
+
+	raw, err = json.Marshal(engineTrace(70, 3))
+	require.NoError(t, err)
+	summary, err = SummarizeTrace(raw)
+	require.NoError(t, err)
+	assert.Nil(t, summary, "a passing evaluation has no decisive failure")
+
+	// A reference to a rule the trace never evaluated is itself decisive
+	summary, err = SummarizeTrace(json.RawMessage(`{"execution":[{"outcome":{"value":"main"},"result":false,
+		"conditions":[{"selector":{"value":"Person"},"rule_name":"missing rule","result":false}]}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, &TraceSummary{Rule: "main", Selector: "Person", Reference: "missing rule"}, summary)
+	assert.Equal(t, `main failed: Person does not meet "missing rule"`, summary.String())
+
+	_, err = SummarizeTrace(json.RawMessage(`{"execution":{}}`))
+	assert.ErrorContains(t, err, "failed to unmarshal trace")
+}
+
+// TestEvaluateBatchTraceModes tests what each trace option leaves in the responses
+func TestEvaluateBatchTraceModes(t *testing.T) {
+	engine := newTracingEngine(t, 2)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	datas := []interface{}{
+		map[string]interface{}{"age": 70},
+		map[string]interface{}{"age": 30},
+	}
+
+	responses, err := batchResponses(c.EvaluateBatch(context.Background(), "rule", datas))
+	require.NoError(t, err)
+	assert.Nil(t, responses[1].Trace, "traces are off by default")
+
+	responses, err = batchResponses(c.EvaluateBatch(context.Background(), "rule", datas, WithTraces()))
+	require.NoError(t, err)
+	assert.Len(t, responses[1].Trace["execution"], 2)
+	assert.Nil(t, responses[1].Summary)
+
+	responses, err = batchResponses(c.EvaluateBatch(context.Background(), "rule", datas, WithTraces(), DiscardTraces()))
+	require.NoError(t, err)
+	assert.Nil(t, responses[1].Trace)
+	assert.False(t, responses[1].Result)
+
+	responses, err = batchResponses(c.EvaluateBatch(context.Background(), "rule", datas, SummarizeTraces()))
+	require.NoError(t, err)
+	assert.Nil(t, responses[0].Trace)
+	assert.Nil(t, responses[0].Summary)
+	assert.Nil(t, responses[1].Trace)
+	require.NotNil(t, responses[1].Summary)
+	assert.Equal(t, "$.Person.age", responses[1].Summary.Property)
+	assert.Equal(t, json.Number("30"), responses[1].Summary.Actual)
+}
+
+// TestEvaluateBatchTraceSink tests that every trace reaches the sink and none is retained
+func TestEvaluateBatchTraceSink(t *testing.T) {
+	engine := newTracingEngine(t, 0)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	datas := make([]interface{}, 20)
+	for i := range datas {
+		datas[i] = map[string]interface{}{"age": 60 + i}
+	}
+
+	var mu sync.Mutex
+	sunk := map[int]json.RawMessage{}
+	responses, err := batchResponses(c.EvaluateBatch(context.Background(), "rule", datas, WithWorkers(4), SummarizeTraces(),
+		TraceSink(func(index int, raw json.RawMessage) error {
+			mu.Lock()
+			defer mu.Unlock()
+			sunk[index] = raw
+			return nil
+		})))
+	require.NoError(t, err)
+
+	require.Len(t, sunk, 20)
+	for i, response := range responses {
+		assert.Nil(t, response.Trace)
+		var trace map[string]interface{}
+		require.NoError(t, json.Unmarshal(sunk[i], &trace), "item %d", i)
+		assert.Equal(t, engineTrace(float64(60+i), 0)["execution"].([]interface{})[0].(map[string]interface{})["result"],
+			trace["execution"].([]interface{})[0].(map[string]interface{})["result"], "item %d", i)
+		// SummarizeTraces still applies alongside the sink
+		assert.Equal(t, i < 5, response.Summary != nil, "item %d", i)
+	}
+
+	full := errors.New("disk full")
+	responses, err = batchResponses(c.EvaluateBatch(context.Background(), "rule", datas[:2], TraceSink(func(index int, raw json.RawMessage) error {
+		if index == 1 {
+			return full
+		}
+		return nil
+	})))
+	assert.ErrorIs(t, err, full)
+	assert.Contains(t, err.Error(), "item 1: failed to write trace")
+	assert.NotNil(t, responses[0])
+	assert.Nil(t, responses[1])
+}
+
+// TestEvaluateStreamTraceSink tests that stream indexes count the documents yielded
+func TestEvaluateStreamTraceSink(t *testing.T) {
+	engine := newTracingEngine(t, 0)
+	c, err := New(engine.URL)
+	require.NoError(t, err)
+
+	items := func(yield func(interface{}, error) bool) {
+		for _, age := range []int{30, 70, 40} {
+			if !yield(map[string]interface{}{"age": age}, nil) {
+				return
+			}
+		}
+	}
+
+	var indexes []int
+	var summaries []*TraceSummary
+	for response, err := range c.EvaluateStream(context.Background(), "rule", items, SummarizeTraces(),
+		TraceSink(func(index int, raw json.RawMessage) error {
+			indexes = append(indexes, index)
+			return nil
+		})) {
+		require.NoError(t, err)
+		assert.Nil(t, response.Trace)
+		summaries = append(summaries, response.Summary)
+	}
+	assert.Equal(t, []int{0, 1, 2}, indexes)
+	require.Len(t, summaries, 3)
+	assert.NotNil(t, summaries[0])
+	assert.Nil(t, summaries[1])
+	assert.Equal(t, json.Number("40"), summaries[2].Actual)
+}
+
+// BenchmarkEvaluateBatchTraceMemory compares the heap a 10k-item traced batch
+// still holds once it has returned, for each way of handling the traces
+func BenchmarkEvaluateBatchTraceMemory(b *testing.B) {
+	engine := newTracingEngine(b, 20)
+	c, err := New(engine.URL)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	datas := make([]interface{}, 10000)
+	for i := range datas {
+		datas[i] = map[string]interface{}{"age": 30 + i%60}
+	}
+
+	for _, mode := range []struct {
+		name string
+		opts []BatchOption
+	}{
+		{"keep", []BatchOption{WithTraces()}},
+		{"discard", []BatchOption{DiscardTraces()}},
+		{"summarize", []BatchOption{SummarizeTraces()}},
+		{"sink", []BatchOption{TraceSink(func(index int, raw json.RawMessage) error { return nil })}},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			var retained uint64
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				responses, err := c.EvaluateBatch(context.Background(), "rule", datas, mode.opts...)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				runtime.KeepAlive(responses)
+				if after.HeapAlloc > before.HeapAlloc {
+					retained += after.HeapAlloc - before.HeapAlloc
+				}
+			}
+			b.ReportMetric(float64(retained)/float64(b.N)/(1<<20), "retained-MiB")
+		})
+	}
+}
+
+// TestTraceFailedConditions tests that a trace decodes into its parts, unknown
+// keys and all, and that only the failed condition of a rule is reported
+func TestTraceFailedConditions(t *testing.T) {
+	traced := `{"result":false,"rule":["rule"],"data":{},"trace":{"engine_version":"9.9","execution":[{
+		"label":"eligibility",
+		"selector":{"value":"driver","pos":{"line":1,"start":2,"end":8}},
+		"outcome":{"value":"eligible","pos":{"line":1,"start":15,"end":23}},
+		"elapsed_us":41,
+		"conditions":[
+			{"selector":{"value":"driver"},"property":{"value":21,"path":"$.driver.age"},"operator":"GreaterThanOrEqual",
+			 "value":{"value":18,"type":"number","pos":{"line":2,"start":40,"end":42}},
+			 "evaluation_details":{"left_value":{"value":21,"type":"number"},"right_value":{"value":18,"type":"number"},"comparison_result":true},
+			 "result":true},
+			{"selector":{"value":"driver"},"property":{"value":"2025-01-10","path":"$.driver.licence_date"},"operator":"OlderThan",
+			 "value":{"value":"2 years","type":"duration"},
+			 "evaluation_details":{"left_value":{"value":"2025-01-10","type":"date"},"right_value":{"value":"2 years","type":"duration"},"comparison_result":false},
+			 "weight":3,
+			 "result":false},
+			{"selector":{"value":"driver"},"property":{"value":"GB","path":"$.driver.country"},"operator":"In",
+			 "value":{"value":["GB","IE"],"type":"list"},"evaluation_details":null,"result":true}
+		],
+		"result":false}]}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(traced))
+	}))
+	t.Cleanup(server.Close)
+	c, err := New(server.URL)
+	require.NoError(t, err)
+
+	response, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, true)
+	require.NoError(t, err)
+	trace := response.ExecutionTrace
+	require.NotNil(t, trace)
+	assert.NotNil(t, response.Trace, "the untyped trace stays for older callers")
+	require.Len(t, trace.Execution, 1)
+	rule := trace.Execution[0]
+	assert.Equal(t, "eligibility", rule.Label)
+	assert.Equal(t, "eligible", rule.Outcome.Value)
+	assert.Equal(t, &TracePosition{Line: 1, Start: 2, End: 8}, rule.Selector.Pos)
+	require.Len(t, rule.Conditions, 3)
+	assert.Equal(t, json.Number("21"), rule.Conditions[0].Actual())
+	assert.Equal(t, &TracePosition{Line: 2, Start: 40, End: 42}, rule.Conditions[0].Value.Pos)
+	assert.Equal(t, []interface{}{"GB", "IE"}, rule.Conditions[2].Expected())
+
+	failed := trace.FailedConditions()
+	require.Len(t, failed, 1)
+	assert.Equal(t, "eligible", failed[0].Rule.Outcome.Value)
+	assert.False(t, failed[0].IsReference())
+	assert.Equal(t, "$.driver.licence_date", failed[0].Property.Path)
+	assert.Equal(t, "OlderThan", failed[0].Operator)
+	assert.Equal(t, "2025-01-10", failed[0].Actual())
+	assert.Equal(t, "2 years", failed[0].Expected())
+	assert.Equal(t, "date", failed[0].EvaluationDetails.LeftValue.Type)
+
+	// A copy can be changed without touching the response it came from
+	copied := cloneResponse(response)
+	copied.ExecutionTrace.Execution[0].Conditions[1].Property.Path = "changed"
+	assert.Equal(t, "$.driver.licence_date", failed[0].Property.Path)
+
+	// The reference to a failed rule is itself a failed condition
+	referenced, err := json.Marshal(engineTrace(40, 2))
+	require.NoError(t, err)
+	var decoded Trace
+	require.NoError(t, json.Unmarshal(referenced, &decoded))
+	failed = decoded.FailedConditions()
+	require.Len(t, failed, 2)
+	assert.True(t, failed[0].IsReference())
+	assert.Equal(t, "senior", failed[0].RuleName)
+	assert.Equal(t, "$.Person.age", failed[1].Property.Path)
+
+	// A trace of another shape is left untyped rather than failing the call
+	traced = `{"result":true,"rule":["rule"],"data":{},"trace":{"execution":"elsewhere"}}`
+	response, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, true)
+	require.NoError(t, err)
+	assert.Nil(t, response.ExecutionTrace)
+	assert.Equal(t, "elsewhere", response.Trace["execution"])
+}
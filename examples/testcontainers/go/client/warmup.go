@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// warmupTimeout bounds the background warm-up started by New
+const warmupTimeout = 10 * time.Second
+
+// WithWarmup makes New open n idle connections to the engine in the
+// background, so the first evaluations don't pay for DNS, TCP and TLS set-up.
+// The transport keeps at least n idle connections to the engine. A failed
+// warm-up never fails New; the connections are simply dialled on first use.
+func WithWarmup(n int) Option {
+	return func(c *PolicyClient) {
+		c.warmupConns = n
+	}
+}
+
+// Warmup opens the connections configured by WithWarmup, or one if it was not
+// given, and returns once they are idle in the transport's pool. It issues
+// health requests concurrently, holding every response open until all have
+// arrived so each one occupies its own connection; over HTTP/2 they share
+// one. With the Auto connection strategy, Warmup records which protocol the
+// engine picked.
+func (c *PolicyClient) Warmup(ctx context.Context) error {
+	n := c.warmupConns
+	if n <= 0 {
+		n = 1
+	}
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	results := make(chan result, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/health", nil)
+			if err != nil {
+				results <- result{err: err}
+				return
+			}
+			resp, err := c.do(req)
+			results <- result{resp: resp, err: err}
+		}()
+	}
+
+	var errs []error
+	responses := make([]*http.Response, 0, n)
+	for i := 0; i < n; i++ {
+		r := <-results
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		responses = append(responses, r.resp)
+	}
+	for _, resp := range responses {
+		// Reading to EOF hands the connection back to the idle pool
+		releaseBody(ctx, resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			errs = append(errs, fmt.Errorf("health check returned status %d", resp.StatusCode))
+		}
+	}
+	if len(responses) > 0 {
+		c.observeProtocol(responses[0])
+		if c.connStrategy == HTTP2Single && responses[0].ProtoMajor != 2 {
+			errs = append(errs, fmt.Errorf("engine answered over %s, not HTTP/2", responses[0].Proto))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("warm-up failed: %w", err)
+	}
+	return nil
+}
+
+// startWarmup sizes the idle pool for the warm-up connections and opens them
+// in the background
+func (c *PolicyClient) startWarmup() {
+	if transport, ok := c.httpClient.Transport.(*http.Transport); ok {
+		growIdlePool(transport, c.warmupConns)
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+		defer cancel()
+		// There is no metrics hook to report a failure to yet; evaluations
+		// dial their own connections instead
+		_ = c.Warmup(ctx)
+	}()
+}
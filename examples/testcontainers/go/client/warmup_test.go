@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCountingEngine answers health checks and evaluations, counting the
+// connections clients open to it
+func newCountingEngine(t *testing.T) (*httptest.Server, *int64) {
+	t.Helper()
+
+	var conns int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"result":true,"rule":["rule"],"data":null}`))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+	return server, &conns
+}
+
+// reusedConn evaluates once and reports whether the request went out on an
+// existing connection
+func reusedConn(t *testing.T, c *PolicyClient) bool {
+	var reused bool
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused },
+	})
+	_, err := c.EvaluatePolicy(ctx, "rule", map[string]interface{}{"age": 70}, false)
+	require.NoError(t, err)
+	return reused
+}
+
+// TestWarmup tests that an evaluation after Warmup reuses the warmed connection
+func TestWarmup(t *testing.T) {
+	server, conns := newCountingEngine(t)
+
+	c, err := New(server.URL)
+	require.NoError(t, err)
+	require.NoError(t, c.Warmup(context.Background()))
+	assert.Equal(t, int64(1), atomic.LoadInt64(conns))
+
+	assert.True(t, reusedConn(t, c), "the first evaluation should reuse the warm connection")
+	assert.Equal(t, int64(1), atomic.LoadInt64(conns))
+}
+
+// TestWithWarmup tests that New opens the requested idle connections in the background
+func TestWithWarmup(t *testing.T) {
+	server, conns := newCountingEngine(t)
+
+	c, err := New(server.URL, WithWarmup(4))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(conns) == 4 }, 5*time.Second, 10*time.Millisecond)
+
+	// A blocking warm-up on top finds the connections already idle
+	require.NoError(t, c.Warmup(context.Background()))
+
+	var wg sync.WaitGroup
+	var fresh int64
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !reusedConn(t, c) {
+				atomic.AddInt64(&fresh, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Zero(t, atomic.LoadInt64(&fresh))
+	assert.Equal(t, int64(4), atomic.LoadInt64(conns))
+}
+
+// TestWarmupFailure tests that a failed warm-up is reported by Warmup but never fails New
+func TestWarmupFailure(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	c, err := New(url, WithWarmup(2))
+	require.NoError(t, err)
+
+	err = c.Warmup(context.Background())
+	assert.ErrorContains(t, err, "warm-up failed")
+
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+	c, err = New(unhealthy.URL)
+	require.NoError(t, err)
+	assert.ErrorContains(t, c.Warmup(context.Background()), "status 503")
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// WarningHeader carries one engine warning per header line, as a code
+// followed by parameters, e.g.
+//
+//	X-Engine-Warning: deprecated_operator; message="use \"is at least\""; line=1; column=42
+//
+// The rule, line and column parameters locate the warning and may be left out.
+const WarningHeader = "X-Engine-Warning"
+
+// Warning is a non-fatal notice the engine attached to a response, such as a
+// deprecated operator phrasing or a value it coerced
+type Warning struct {
+	Code    string
+	Message string
+	// RulePosition locates the warning in the rule text; Line and Column are
+	// zero and Rule -1 when the engine did not say
+	RulePosition Position
+}
+
+func (w Warning) String() string {
+	var b strings.Builder
+	b.WriteString("warning")
+	if w.Code != "" {
+		b.WriteString(" (" + w.Code + ")")
+	}
+	if w.RulePosition.Line > 0 {
+		fmt.Fprintf(&b, " at line %d, column %d", w.RulePosition.Line, w.RulePosition.Column)
+	}
+	b.WriteString(": " + w.Message)
+	return b.String()
+}
+
+// warningPayload is a warning as the engine writes it in the body's
+// "warnings" array, shaped like its structured errors
+type warningPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Rule    *int   `json:"rule,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+}
+
+// UnmarshalJSON decodes a warning from the engine's "warnings" array, which
+// may also hold plain messages
+func (w *Warning) UnmarshalJSON(raw []byte) error {
+	*w = Warning{RulePosition: Position{Rule: -1}}
+	if len(raw) > 0 && raw[0] == '"' {
+		return json.Unmarshal(raw, &w.Message)
+	}
+	var payload warningPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return err
+	}
+	w.Code, w.Message = payload.Code, payload.Message
+	w.RulePosition.Line, w.RulePosition.Column = payload.Line, payload.Column
+	if payload.Rule != nil {
+		w.RulePosition.Rule = *payload.Rule
+	}
+	return nil
+}
+
+// MarshalJSON encodes the warning as the engine does
+func (w Warning) MarshalJSON() ([]byte, error) {
+	payload := warningPayload{Code: w.Code, Message: w.Message, Line: w.RulePosition.Line, Column: w.RulePosition.Column}
+	if w.RulePosition.Rule >= 0 {
+		rule := w.RulePosition.Rule
+		payload.Rule = &rule
+	}
+	return json.Marshal(payload)
+}
+
+// headerWarnings parses the WarningHeader lines of a response; malformed
+// lines are kept whole as the message
+func headerWarnings(header http.Header) []Warning {
+	var warnings []Warning
+	for _, line := range header.Values(WarningHeader) {
+		warning := Warning{RulePosition: Position{Rule: -1}}
+		code, params, err := mime.ParseMediaType(line)
+		if err != nil {
+			warning.Message = strings.TrimSpace(line)
+			warnings = append(warnings, warning)
+			continue
+		}
+		warning.Code = code
+		warning.Message = params["message"]
+		warning.RulePosition.Line, _ = strconv.Atoi(params["line"])
+		warning.RulePosition.Column, _ = strconv.Atoi(params["column"])
+		if rule, err := strconv.Atoi(params["rule"]); err == nil {
+			warning.RulePosition.Rule = rule
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings
+}
+
+// WithWarningsAsErrors fails every evaluation whose response carries a
+// warning with a *WarningsError, for CI jobs that should catch deprecated
+// phrasing before it stops parsing. The response is still returned. An engine
+// that does not report warnings, as WithCapabilityProbing learns, passes
+// every evaluation, and each is counted as a degraded CapabilityWarnings.
+func WithWarningsAsErrors() Option {
+	return func(c *PolicyClient) {
+		c.warningsAsErrors = true
+	}
+}
+
+// WarningsError is returned under WithWarningsAsErrors for a response with
+// warnings
+type WarningsError struct {
+	Warnings []Warning
+}
+
+func (e *WarningsError) Error() string {
+	if len(e.Warnings) == 1 {
+		return "engine " + e.Warnings[0].String()
+	}
+	return fmt.Sprintf("engine sent %d warnings, first %s", len(e.Warnings), e.Warnings[0])
+}
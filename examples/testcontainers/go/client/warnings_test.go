@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	deprecatedWarning = Warning{
+		Code:         "deprecated_operator",
+		Message:      `"is at least" is deprecated, use "is greater than or equal to"`,
+		RulePosition: Position{Rule: 0, Line: 1, Column: 50},
+	}
+	coercedMessage = "age was coerced from a string to a number"
+)
+
+// warningEngine answers with the body fixture, sending each line of the
+// header fixture, if any, as a WarningHeader
+func warningEngine(t *testing.T, bodyFixture, headerFixture string) *httptest.Server {
+	t.Helper()
+
+	body, err := os.ReadFile(filepath.Join("testdata", bodyFixture))
+	require.NoError(t, err)
+	var headers []string
+	if headerFixture != "" {
+		raw, err := os.ReadFile(filepath.Join("testdata", headerFixture))
+		require.NoError(t, err)
+		headers = strings.Split(strings.TrimSpace(string(raw)), "\n")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, header := range headers {
+			w.Header().Add(WarningHeader, header)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// TestWarnings tests decoding warnings from the body and from headers
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		name          string
+		body, headers string
+		want          []Warning
+	}{
+		{name: "none", body: "schema/current.json", want: []Warning{}},
+		{name: "body", body: "warnings/body.json", want: []Warning{
+			deprecatedWarning,
+			{Message: coercedMessage, RulePosition: Position{Rule: -1}},
+		}},
+		{name: "headers", body: "schema/current.json", headers: "warnings/headers.txt", want: []Warning{
+			deprecatedWarning,
+			{Code: "coerced_type", Message: coercedMessage, RulePosition: Position{Rule: -1}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := warningEngine(t, tt.body, tt.headers)
+			for _, trace := range []bool{false, true} {
+				c, err := New(server.URL)
+				require.NoError(t, err)
+				response, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, trace)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, response.Warnings)
+				assert.NotNil(t, cloneResponse(response).Warnings)
+			}
+		})
+	}
+}
+
+// TestWarningsAsErrors tests that strict mode fails only responses with
+// warnings, and still returns them
+func TestWarningsAsErrors(t *testing.T) {
+	c, err := New(warningEngine(t, "schema/current.json", "").URL, WithWarningsAsErrors())
+	require.NoError(t, err)
+	_, err = c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+	assert.NoError(t, err)
+
+	for _, server := range []*httptest.Server{
+		warningEngine(t, "warnings/body.json", ""),
+		warningEngine(t, "schema/current.json", "warnings/headers.txt"),
+	} {
+		c, err := New(server.URL, WithWarningsAsErrors())
+		require.NoError(t, err)
+		response, err := c.EvaluatePolicy(context.Background(), "rule", map[string]interface{}{}, false)
+		var warningsErr *WarningsError
+		require.True(t, errors.As(err, &warningsErr), "got %v", err)
+		assert.Equal(t, response.Warnings, warningsErr.Warnings)
+		assert.True(t, response.Result)
+		assert.EqualError(t, err, `engine sent 2 warnings, first warning (deprecated_operator) at line 1, column 50: "is at least" is deprecated, use "is greater than or equal to"`)
+	}
+}
+
+// TestWarningRoundTrip tests that warnings encode the way they decode
+func TestWarningRoundTrip(t *testing.T) {
+	for _, warning := range []Warning{deprecatedWarning, {Code: "x", Message: "y", RulePosition: Position{Rule: -1}}} {
+		encoded, err := warning.MarshalJSON()
+		require.NoError(t, err)
+		var decoded Warning
+		require.NoError(t, decoded.UnmarshalJSON(encoded))
+		assert.Equal(t, warning, decoded)
+	}
+	assert.Equal(t, []Warning{{Message: "raw line", RulePosition: Position{Rule: -1}}}, headerWarnings(http.Header{WarningHeader: {"raw line"}}))
+}
@@ -0,0 +1,743 @@
+// Command policyctl evaluates and checks rules from the shell, without
+// writing a Go test:
+//
+//	policyctl eval --url http://localhost:3000 --rule-file discount.rule --data data.json --trace
+//	policyctl eval --rule-file discount.rule --data-file customers.csv --mapping mapping.yaml
+//	policyctl validate --rule-file discount.rule
+//	policyctl health --url http://localhost:3000
+//	policyctl capabilities
+//	policyctl scaffold --rule-file discount.rule > data.json
+//	policyctl search --dir rules --property Customer.membership_level
+//	policyctl replay --audit audit.ndjson --rules-dir rules
+//	policyctl simulate --old-rule-file old.rule --new-rule-file new.rule --inputs customers.ndjson
+//
+// Output is JSON, or with --pretty meant for people: eval then explains the
+// result from its trace. The exit code is 0 when the result is true, 1 when
+// it is false, and 2 on an error, so scripts can branch on it; validate
+// exits 1 when the rule has problems, health when the engine is not
+// healthy, search when nothing matches, replay when a decision replays
+// differently and simulate when the new rules change a result.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/policydata"
+	"policy-engine-testcontainer-example/policyset"
+	"policy-engine-testcontainer-example/replay"
+	"policy-engine-testcontainer-example/respdiff"
+	"policy-engine-testcontainer-example/rulecheck"
+	"policy-engine-testcontainer-example/selectors"
+	"policy-engine-testcontainer-example/simulate"
+	"policy-engine-testcontainer-example/trace"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Exit codes
+const (
+	exitTrue  = 0
+	exitFalse = 1
+	exitError = 2
+)
+
+// defaultURL is the engine policyctl talks to without --url or
+// POLICY_ENGINE_URL
+const defaultURL = "http://localhost:3000"
+
+const usage = `usage: policyctl <command> [flags]
+
+commands:
+  eval          evaluate a rule against JSON data, or each record of a file
+  validate      check a rule's text without an engine
+  health        check that the engine is up
+  capabilities  print what the engine supports
+  scaffold      print the data shape a rule reads
+  search        find the rules in a directory reading a property, using an
+                operator, comparing against a value or containing text
+  replay        evaluate recorded decisions again and compare them
+  simulate      compare the decisions of two rule sets over many inputs
+
+Run policyctl <command> -h for a command's flags.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command args name and returns its exit code
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitError
+	}
+	cmd := &command{stdin: stdin, stdout: stdout, stderr: stderr}
+	switch args[0] {
+	case "eval":
+		return cmd.eval(args[1:])
+	case "validate":
+		return cmd.validate(args[1:])
+	case "health":
+		return cmd.health(args[1:])
+	case "capabilities":
+		return cmd.capabilities(args[1:])
+	case "scaffold":
+		return cmd.scaffold(args[1:])
+	case "search":
+		return cmd.search(args[1:])
+	case "replay":
+		return cmd.replay(args[1:])
+	case "simulate":
+		return cmd.simulate(args[1:])
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return exitTrue
+	}
+	fmt.Fprintf(stderr, "policyctl: unknown command %q\n\n%s", args[0], usage)
+	return exitError
+}
+
+// command holds what every command reads and writes, and the flags they
+// share
+type command struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+
+	url      string
+	rule     string
+	ruleFile string
+	pretty   bool
+	timeout  time.Duration
+}
+
+// flags returns a flag set for the command name with the shared flags the
+// command takes
+func (c *command) flags(name string, engine, rule bool) *flag.FlagSet {
+	fs := flag.NewFlagSet("policyctl "+name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	if engine {
+		url := defaultURL
+		if env := os.Getenv("POLICY_ENGINE_URL"); env != "" {
+			url = env
+		}
+		fs.StringVar(&c.url, "url", url, "engine base URL; defaults to $POLICY_ENGINE_URL")
+		fs.DurationVar(&c.timeout, "timeout", 10*time.Second, "how long to wait for the engine")
+	}
+	if rule {
+		fs.StringVar(&c.rule, "rule", "", "rule text")
+		fs.StringVar(&c.ruleFile, "rule-file", "", "file holding the rule text")
+	}
+	fs.BoolVar(&c.pretty, "pretty", false, "print for people instead of as JSON")
+	return fs
+}
+
+// parse parses args into fs, reporting a usage error
+func (c *command) parse(fs *flag.FlagSet, args []string) bool {
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(c.stderr, "%s: unexpected arguments: %s\n", fs.Name(), strings.Join(fs.Args(), " "))
+		return false
+	}
+	return true
+}
+
+// ruleText returns the rule given by --rule or --rule-file
+func (c *command) ruleText() (string, error) {
+	switch {
+	case c.rule != "" && c.ruleFile != "":
+		return "", errors.New("give one of --rule and --rule-file")
+	case c.rule != "":
+		return c.rule, nil
+	case c.ruleFile != "":
+		return readRule(c.ruleFile)
+	}
+	return "", errors.New("a rule is needed: give --rule or --rule-file")
+}
+
+// readRule reads the rule text in the file name
+func readRule(name string) (string, error) {
+	raw, err := os.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to read rule: %w", err)
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
+
+// client returns a client for --url
+func (c *command) client() (*client.PolicyClient, error) {
+	return client.New(c.url)
+}
+
+// context bounds a call to the engine by --timeout
+func (c *command) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), c.timeout)
+}
+
+// fail reports err and returns exitError
+func (c *command) fail(err error) int {
+	fmt.Fprintf(c.stderr, "policyctl: %v\n", err)
+	return exitError
+}
+
+// write prints v as JSON, indented with --pretty
+func (c *command) write(v interface{}) error {
+	encoder := json.NewEncoder(c.stdout)
+	if c.pretty {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder.Encode(v)
+}
+
+// eval evaluates a rule against --data, read from a file or, as "-", from
+// stdin, or against each record of --data-file
+func (c *command) eval(args []string) int {
+	fs := c.flags("eval", true, true)
+	dataFile := fs.String("data", "", "JSON data file, or - for stdin; defaults to {}")
+	recordsFile := fs.String("data-file", "", "NDJSON file, or with --mapping CSV file, of records to evaluate one by one, or - for stdin")
+	mapping := fs.String("mapping", "", "YAML file mapping the CSV columns of --data-file to data paths")
+	traced := fs.Bool("trace", false, "ask the engine for a trace")
+	if !c.parse(fs, args) {
+		return exitError
+	}
+	rule, err := c.ruleText()
+	if err != nil {
+		return c.fail(err)
+	}
+	switch {
+	case *dataFile != "" && *recordsFile != "":
+		return c.fail(errors.New("give one of --data and --data-file"))
+	case *mapping != "" && *recordsFile == "":
+		return c.fail(errors.New("--mapping needs --data-file"))
+	}
+	var records simulate.InputSource
+	if *recordsFile != "" {
+		var closeFile func()
+		if records, closeFile, err = c.records(*recordsFile, *mapping); err != nil {
+			return c.fail(err)
+		}
+		defer closeFile()
+	} else {
+		data, err := c.readData(*dataFile)
+		if err != nil {
+			return c.fail(err)
+		}
+		records = func(yield func(interface{}, error) bool) { yield(data, nil) }
+	}
+	pc, err := c.client()
+	if err != nil {
+		return c.fail(err)
+	}
+	defer pc.Close()
+
+	// The explanation is made from the trace
+	ctx, cancel := c.context()
+	defer cancel()
+	req := client.PolicyRequest{Rule: rule, Trace: *traced || c.pretty}
+	code, n := exitTrue, 0
+	for data, err := range records {
+		n++
+		if err != nil {
+			code = c.fail(err)
+			continue
+		}
+		req.Data = data
+		response, err := pc.Evaluate(ctx, req)
+		if err != nil && (response == nil || response.EngineError == nil) {
+			if *recordsFile == "" {
+				return c.fail(err)
+			}
+			code = c.fail(fmt.Errorf("record %d: %w", n, err))
+			continue
+		}
+		if c.pretty && *recordsFile != "" {
+			fmt.Fprintf(c.stdout, "record %d: ", n)
+		}
+		code = max(code, c.printResponse(response))
+	}
+	return code
+}
+
+// yellow and reset color the warnings --pretty prints
+const (
+	yellow = "\x1b[33m"
+	reset  = "\x1b[0m"
+)
+
+// printResponse prints an evaluation's response, with --pretty explained
+// from its trace and followed by its warnings, and returns the exit code for
+// it
+func (c *command) printResponse(response *client.PolicyResponse) int {
+	if c.pretty {
+		fmt.Fprintf(c.stdout, "result: %t\n%s\n", response.Result, trace.Explain(nil, response))
+		for _, warning := range response.Warnings {
+			fmt.Fprintf(c.stdout, "%s%s%s\n", yellow, warning, reset)
+		}
+	} else if err := c.write(response); err != nil {
+		return c.fail(err)
+	}
+	switch {
+	case response.EngineError != nil:
+		return exitError
+	case response.Result:
+		return exitTrue
+	}
+	return exitFalse
+}
+
+// records returns the records in name, or stdin for "-", read as CSV with
+// the YAML column mapping in mapping when there is one and as NDJSON
+// otherwise, and a func closing the file. A malformed record is yielded as
+// its error and the records after it still are.
+func (c *command) records(name, mapping string) (simulate.InputSource, func(), error) {
+	var csvMapping *policydata.CSVMapping
+	if mapping != "" {
+		raw, err := os.ReadFile(mapping)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read mapping: %w", err)
+		}
+		csvMapping = &policydata.CSVMapping{}
+		if err := yaml.Unmarshal(raw, csvMapping); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode mapping: %w", err)
+		}
+	}
+	r, closeFile := c.stdin, func() {}
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read data: %w", err)
+		}
+		r, closeFile = f, func() { f.Close() }
+	}
+	if csvMapping != nil {
+		return policydata.LoadCSV(r, *csvMapping, policydata.SkipMalformed()), closeFile, nil
+	}
+	return policydata.LoadNDJSON(r, policydata.SkipMalformed()), closeFile, nil
+}
+
+// readData reads the JSON data named by name, keeping numbers as written
+func (c *command) readData(name string) (interface{}, error) {
+	var raw []byte
+	var err error
+	switch name {
+	case "":
+		return map[string]interface{}{}, nil
+	case "-":
+		raw, err = io.ReadAll(c.stdin)
+	default:
+		raw, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to decode data: %w", err)
+	}
+	return data, nil
+}
+
+// issue is a rulecheck.ValidationIssue as validate prints it
+type issue struct {
+	Kind    rulecheck.IssueKind `json:"kind"`
+	Offset  int                 `json:"offset"`
+	Message string              `json:"message"`
+}
+
+// validate checks a rule's text with rulecheck
+func (c *command) validate(args []string) int {
+	fs := c.flags("validate", false, true)
+	if !c.parse(fs, args) {
+		return exitError
+	}
+	rule, err := c.ruleText()
+	if err != nil {
+		return c.fail(err)
+	}
+
+	found := rulecheck.ValidateRule(rule)
+	if c.pretty {
+		if len(found) == 0 {
+			fmt.Fprintln(c.stdout, "the rule is valid")
+		}
+		for _, i := range found {
+			fmt.Fprintln(c.stdout, i)
+		}
+	} else {
+		issues := make([]issue, len(found))
+		for n, i := range found {
+			issues[n] = issue{Kind: i.Kind, Offset: i.Offset, Message: i.Message}
+		}
+		if err := c.write(struct {
+			Valid  bool    `json:"valid"`
+			Issues []issue `json:"issues"`
+		}{len(issues) == 0, issues}); err != nil {
+			return c.fail(err)
+		}
+	}
+	if len(found) > 0 {
+		return exitFalse
+	}
+	return exitTrue
+}
+
+// health checks the engine's health endpoint
+func (c *command) health(args []string) int {
+	fs := c.flags("health", true, false)
+	if !c.parse(fs, args) {
+		return exitError
+	}
+	pc, err := c.client()
+	if err != nil {
+		return c.fail(err)
+	}
+	defer pc.Close()
+
+	ctx, cancel := c.context()
+	defer cancel()
+	err = pc.Health(ctx)
+	switch {
+	case c.pretty && err == nil:
+		fmt.Fprintf(c.stdout, "%s is healthy\n", c.url)
+	case c.pretty:
+		fmt.Fprintf(c.stdout, "%s is not healthy: %v\n", c.url, err)
+	default:
+		status := struct {
+			Healthy bool   `json:"healthy"`
+			Error   string `json:"error,omitempty"`
+		}{Healthy: err == nil}
+		if err != nil {
+			status.Error = err.Error()
+		}
+		if err := c.write(status); err != nil {
+			return c.fail(err)
+		}
+	}
+	if err != nil {
+		return exitFalse
+	}
+	return exitTrue
+}
+
+// capabilities prints what the engine said it supports on GET /version,
+// with pretty the matrix client.Capabilities prints
+func (c *command) capabilities(args []string) int {
+	fs := c.flags("capabilities", true, false)
+	if !c.parse(fs, args) {
+		return exitError
+	}
+	pc, err := c.client()
+	if err != nil {
+		return c.fail(err)
+	}
+	defer pc.Close()
+
+	ctx, cancel := c.context()
+	defer cancel()
+	capabilities, err := pc.Capabilities(ctx)
+	if err != nil {
+		return c.fail(err)
+	}
+	if c.pretty {
+		fmt.Fprintln(c.stdout, capabilities)
+	} else if err := c.write(struct {
+		Version      string                     `json:"version,omitempty"`
+		Capabilities map[client.Capability]bool `json:"capabilities"`
+	}{capabilities.Version, capabilities.Features}); err != nil {
+		return c.fail(err)
+	}
+	return exitTrue
+}
+
+// scaffold prints the data shape a rule reads, as selectors.ScaffoldData
+// builds it
+func (c *command) scaffold(args []string) int {
+	fs := c.flags("scaffold", false, true)
+	if !c.parse(fs, args) {
+		return exitError
+	}
+	rule, err := c.ruleText()
+	if err != nil {
+		return c.fail(err)
+	}
+	data, err := selectors.ScaffoldData(rule)
+	if err != nil {
+		return c.fail(err)
+	}
+	if err := c.write(data); err != nil {
+		return c.fail(err)
+	}
+	return exitTrue
+}
+
+// search finds rules in the corpus under --dir by what they read, compare
+// with or contain, as policyset.Corpus does
+func (c *command) search(args []string) int {
+	fs := c.flags("search", false, false)
+	dir := fs.String("dir", ".", "directory holding the rules, searched at any depth")
+	pattern := fs.String("pattern", "*.rule", "name pattern of the rule files")
+	property := fs.String("property", "", "find conditions reading a property, as Entity.property or property of any entity")
+	operator := fs.String("operator", "", `find conditions comparing with an operator, e.g. "is at least" or ">="`)
+	value := fs.String("literal", "", `find conditions comparing against a value, as JSON: 65, true or "Gold"`)
+	text := fs.String("text", "", "find rules containing text")
+	if !c.parse(fs, args) {
+		return exitError
+	}
+	given := 0
+	for _, query := range []string{*property, *operator, *value, *text} {
+		if query != "" {
+			given++
+		}
+	}
+	if given != 1 {
+		return c.fail(errors.New("give one of --property, --operator, --literal and --text"))
+	}
+	corpus, err := policyset.LoadCorpus(os.DirFS(*dir), *pattern)
+	if err != nil {
+		return c.fail(err)
+	}
+
+	var matches []policyset.Match
+	switch {
+	case *property != "":
+		entity, name, ok := strings.Cut(*property, ".")
+		if !ok {
+			entity, name = "", entity
+		}
+		matches = corpus.ByProperty(entity, name)
+	case *operator != "":
+		matches = corpus.ByOperator(*operator)
+	case *value != "":
+		var literal interface{}
+		if err := json.Unmarshal([]byte(*value), &literal); err != nil {
+			// A bare word is the string it spells
+			literal = *value
+		}
+		matches = corpus.ByLiteral(literal)
+	default:
+		matches = corpus.Search(*text)
+	}
+
+	if c.pretty {
+		for _, match := range matches {
+			found := match.Condition
+			if found == "" {
+				found = match.Rule
+			}
+			fmt.Fprintf(c.stdout, "%s:%d: %s\n", filepath.Join(*dir, match.File), match.Line, found)
+		}
+	} else {
+		if matches == nil {
+			matches = []policyset.Match{}
+		}
+		if err := c.write(matches); err != nil {
+			return c.fail(err)
+		}
+	}
+	if len(matches) == 0 {
+		return exitFalse
+	}
+	return exitTrue
+}
+
+// replay evaluates the decisions of an audit file, or a single record,
+// again and compares them with what was recorded, as the replay package
+// does. Rules the records do not carry are looked up by hash in --rules-dir.
+func (c *command) replay(args []string) int {
+	fs := c.flags("replay", true, false)
+	recordFile := fs.String("record", "", "JSON file holding one audit record")
+	auditFile := fs.String("audit", "", "NDJSON audit file, as client.JSONAuditSink writes it, or - for stdin")
+	rulesDir := fs.String("rules-dir", "", "directory of the rules records name by hash only")
+	pattern := fs.String("pattern", "*.rule", "name pattern of the rule files in --rules-dir")
+	now := fs.Bool("current-time", false, "evaluate at the current time instead of each record's")
+	if !c.parse(fs, args) {
+		return exitError
+	}
+	if (*recordFile == "") == (*auditFile == "") {
+		return c.fail(errors.New("give one of --record and --audit"))
+	}
+	var opts []replay.Option
+	if *rulesDir != "" {
+		corpus, err := policyset.LoadCorpus(os.DirFS(*rulesDir), *pattern)
+		if err != nil {
+			return c.fail(err)
+		}
+		opts = append(opts, replay.WithResolver(corpus))
+	}
+	if *now {
+		opts = append(opts, replay.WithCurrentTime())
+	}
+	pc, err := c.client()
+	if err != nil {
+		return c.fail(err)
+	}
+	defer pc.Close()
+	ctx, cancel := c.context()
+	defer cancel()
+
+	if *recordFile != "" {
+		raw, err := os.ReadFile(*recordFile)
+		if err != nil {
+			return c.fail(fmt.Errorf("failed to read record: %w", err))
+		}
+		var record replay.DecisionRecord
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return c.fail(fmt.Errorf("failed to decode record: %w", err))
+		}
+		result, err := replay.FromRecord(ctx, pc, record, opts...)
+		if err != nil {
+			return c.fail(err)
+		}
+		c.printReplay(result)
+		if !result.Match {
+			return exitFalse
+		}
+		return exitTrue
+	}
+
+	var r io.Reader = c.stdin
+	if *auditFile != "-" {
+		f, err := os.Open(*auditFile)
+		if err != nil {
+			return c.fail(fmt.Errorf("failed to read audit records: %w", err))
+		}
+		defer f.Close()
+		r = f
+	}
+	summary, err := replay.FromNDJSON(ctx, pc, r, opts...)
+	if err != nil {
+		return c.fail(err)
+	}
+	for _, err := range summary.Errors {
+		fmt.Fprintf(c.stderr, "policyctl: %v\n", err)
+	}
+	if c.pretty {
+		fmt.Fprintln(c.stdout, summary)
+		for _, result := range summary.Mismatches {
+			c.printReplay(result)
+		}
+	} else if err := c.write(struct {
+		*replay.Summary
+		Mismatches []replayed `json:"mismatches"`
+	}{summary, replayedAll(summary.Mismatches)}); err != nil {
+		return c.fail(err)
+	}
+	switch {
+	case summary.Unresolved > 0 || summary.Failed > 0:
+		return exitError
+	case summary.Mismatched > 0:
+		return exitFalse
+	}
+	return exitTrue
+}
+
+// replayed is a replay.ReplayResult as replay prints it
+type replayed struct {
+	DecisionID string         `json:"decision_id"`
+	RuleHash   string         `json:"rule_hash"`
+	Match      bool           `json:"match"`
+	Diff       *respdiff.Diff `json:"diff"`
+}
+
+// replayedAll is results as replay prints them
+func replayedAll(results []*replay.ReplayResult) []replayed {
+	out := make([]replayed, len(results))
+	for i, result := range results {
+		out[i] = replayed{result.Record.DecisionID, result.Record.RuleHash, result.Match, result.Diff}
+	}
+	return out
+}
+
+// printReplay prints one replayed decision, with --pretty as its diff
+func (c *command) printReplay(result *replay.ReplayResult) {
+	if !c.pretty {
+		if err := c.write(replayedAll([]*replay.ReplayResult{result})[0]); err != nil {
+			c.fail(err)
+		}
+		return
+	}
+	if result.Match {
+		fmt.Fprintf(c.stdout, "decision %s replayed the same\n", result.Record.DecisionID)
+		return
+	}
+	fmt.Fprintf(c.stdout, "decision %s replayed differently:\n%s\n", result.Record.DecisionID, result.Diff)
+}
+
+// simulate compares the decisions of the rules in --old-rule-file and
+// --new-rule-file over the records of --inputs, as simulate.Compare does
+func (c *command) simulate(args []string) int {
+	fs := c.flags("simulate", true, false)
+	oldFile := fs.String("old-rule-file", "", "file holding the current rule")
+	newFile := fs.String("new-rule-file", "", "file holding the proposed rule")
+	inputs := fs.String("inputs", "", "NDJSON file, or with --mapping CSV file, of inputs, or - for stdin")
+	mapping := fs.String("mapping", "", "YAML file mapping the CSV columns of --inputs to data paths")
+	concurrency := fs.Int("concurrency", 0, "how many inputs to evaluate at once; zero for the default")
+	samples := fs.Int("samples", 0, "how many flipped inputs to show; zero for the default")
+	if !c.parse(fs, args) {
+		return exitError
+	}
+	if *oldFile == "" || *newFile == "" || *inputs == "" {
+		return c.fail(errors.New("--old-rule-file, --new-rule-file and --inputs are needed"))
+	}
+	var rules [2][]string
+	for i, name := range []string{*oldFile, *newFile} {
+		rule, err := readRule(name)
+		if err != nil {
+			return c.fail(err)
+		}
+		rules[i] = []string{rule}
+	}
+	records, closeFile, err := c.records(*inputs, *mapping)
+	if err != nil {
+		return c.fail(err)
+	}
+	defer closeFile()
+	var opts []simulate.Option
+	if *concurrency > 0 {
+		opts = append(opts, simulate.WithConcurrency(*concurrency))
+	}
+	if *samples > 0 {
+		opts = append(opts, simulate.WithSamples(*samples))
+	}
+	pc, err := c.client()
+	if err != nil {
+		return c.fail(err)
+	}
+	defer pc.Close()
+
+	ctx, cancel := c.context()
+	defer cancel()
+	report, err := simulate.Compare(ctx, pc, rules[0], rules[1], records, opts...)
+	if err != nil {
+		return c.fail(err)
+	}
+	for _, err := range report.Errors {
+		fmt.Fprintf(c.stderr, "policyctl: %v\n", err)
+	}
+	if c.pretty {
+		fmt.Fprintln(c.stdout, report)
+	} else if err := c.write(report); err != nil {
+		return c.fail(err)
+	}
+	switch {
+	case report.InputErrors > 0 || report.EvalErrors > 0:
+		return exitError
+	case report.Flipped() > 0:
+		return exitFalse
+	}
+	return exitTrue
+}
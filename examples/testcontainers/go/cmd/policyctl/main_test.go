@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/enginetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const seniorRule = "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+
+// policyctl runs the command line args with stdin and returns its exit code
+// and output
+func policyctl(stdin string, args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(stdin), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+// writeFile writes content to name in a temporary directory and returns its
+// path
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// TestEval tests the exit codes and JSON output of eval
+func TestEval(t *testing.T) {
+	server := enginetest.NewFakeServer()
+	defer server.Close()
+	ruleFile := writeFile(t, "discount.rule", seniorRule+"\n")
+
+	for name, tt := range map[string]struct {
+		data string
+		code int
+	}{
+		"true":  {`{"Person": {"age": 70}}`, exitTrue},
+		"false": {`{"Person": {"age": 30}}`, exitFalse},
+		"error": {`{"Person": {}}`, exitError},
+	} {
+		t.Run(name, func(t *testing.T) {
+			code, stdout, stderr := policyctl("", "eval", "--url", server.URL, "--rule-file", ruleFile, "--data", writeFile(t, "data.json", tt.data))
+			assert.Equal(t, tt.code, code, stderr)
+			var response client.PolicyResponse
+			require.NoError(t, json.Unmarshal([]byte(stdout), &response), stdout)
+			assert.Equal(t, tt.code == exitTrue, response.Result)
+			assert.Equal(t, tt.code == exitError, response.Error != nil)
+		})
+	}
+
+	code, stdout, _ := policyctl(`{"Person": {"age": 65}}`, "eval", "--url", server.URL, "--rule", seniorRule, "--data", "-")
+	assert.Equal(t, exitTrue, code, "data can come from stdin")
+	assert.Contains(t, stdout, `"result":true`)
+}
+
+// TestEvalPretty tests that --pretty explains the result from its trace
+func TestEvalPretty(t *testing.T) {
+	trace := map[string]interface{}{"execution": []interface{}{map[string]interface{}{
+		"selector": map[string]interface{}{"value": "Person"},
+		"outcome":  map[string]interface{}{"value": "senior_discount"},
+		"result":   false,
+		"conditions": []interface{}{map[string]interface{}{
+			"selector": map[string]interface{}{"value": "Person"},
+			"property": map[string]interface{}{"value": 30, "path": "$.Person.age"},
+			"operator": "GreaterThanOrEqual",
+			"value":    map[string]interface{}{"value": 65, "type": "number"},
+			"result":   false,
+		}},
+	}}}
+	server := enginetest.NewFakeServer(enginetest.WithStub(seniorRule, client.PolicyResponse{Trace: trace}))
+	defer server.Close()
+
+	code, stdout, _ := policyctl(`{"Person": {"age": 30}}`, "eval", "--url", server.URL, "--rule", seniorRule, "--data", "-", "--pretty")
+	assert.Equal(t, exitFalse, code)
+	assert.Equal(t, `result: false
+senior_discount was NOT granted because age of Person (30) is not greater than or equal to 65.
+  [fail] age of Person (30) is not greater than or equal to 65
+`, stdout)
+}
+
+// TestEvalErrors tests the errors eval exits 2 for
+func TestEvalErrors(t *testing.T) {
+	server := enginetest.NewFakeServer()
+	url := server.URL
+	server.Close()
+
+	for name, tt := range map[string]struct {
+		args []string
+		want string
+	}{
+		"no rule":     {[]string{"eval"}, "a rule is needed"},
+		"two rules":   {[]string{"eval", "--rule", seniorRule, "--rule-file", "x.rule"}, "give one of --rule and --rule-file"},
+		"bad data":    {[]string{"eval", "--rule", seniorRule, "--data", "-"}, "failed to decode data"},
+		"no engine":   {[]string{"eval", "--url", url, "--rule", seniorRule}, "policyctl: "},
+		"bad flag":    {[]string{"eval", "--colour"}, "flag provided but not defined"},
+		"extra args":  {[]string{"eval", "--rule", seniorRule, "data.json"}, "unexpected arguments: data.json"},
+		"no command":  {nil, "usage: policyctl"},
+		"bad command": {[]string{"evaluate"}, `unknown command "evaluate"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			code, _, stderr := policyctl("not json", tt.args...)
+			assert.Equal(t, exitError, code)
+			assert.Contains(t, stderr, tt.want)
+		})
+	}
+}
+
+// TestValidate tests validate on a valid rule and on one with problems
+func TestValidate(t *testing.T) {
+	code, stdout, _ := policyctl("", "validate", "--rule", seniorRule)
+	assert.Equal(t, exitTrue, code)
+	assert.JSONEq(t, `{"valid": true, "issues": []}`, stdout)
+
+	code, stdout, _ = policyctl("", "validate", "--rule", "A **Person** gets senior_discount if the __age__ of the **Person** is greater then 65.")
+	assert.Equal(t, exitFalse, code)
+	var report struct {
+		Valid  bool `json:"valid"`
+		Issues []struct {
+			Kind    string `json:"kind"`
+			Message string `json:"message"`
+		} `json:"issues"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(stdout), &report))
+	assert.False(t, report.Valid)
+	require.NotEmpty(t, report.Issues)
+	assert.Contains(t, report.Issues[0].Message, "is greater than")
+
+	code, stdout, _ = policyctl("", "validate", "--rule", seniorRule, "--pretty")
+	assert.Equal(t, exitTrue, code)
+	assert.Equal(t, "the rule is valid\n", stdout)
+}
+
+// TestHealth tests health against an engine that is up and one that is not
+func TestHealth(t *testing.T) {
+	server := enginetest.NewFakeServer()
+	defer server.Close()
+	code, stdout, _ := policyctl("", "health", "--url", server.URL)
+	assert.Equal(t, exitTrue, code)
+	assert.JSONEq(t, `{"healthy": true}`, stdout)
+
+	down := enginetest.NewFakeServer()
+	down.Close()
+	code, stdout, _ = policyctl("", "health", "--url", down.URL, "--pretty")
+	assert.Equal(t, exitFalse, code)
+	assert.Contains(t, stdout, down.URL+" is not healthy: ")
+}
+
+// TestScaffold tests that scaffold prints the data shape a rule reads
+func TestScaffold(t *testing.T) {
+	code, stdout, _ := policyctl("", "scaffold", "--rule-file", writeFile(t, "discount.rule", seniorRule))
+	assert.Equal(t, exitTrue, code)
+	assert.JSONEq(t, `{"Person": {"age": 0}}`, stdout)
+
+	code, _, stderr := policyctl("", "scaffold", "--rule", "not a rule")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "policyctl: ")
+}
+
+// TestEvalDataFile tests evaluating each record of an NDJSON file and of a
+// CSV file through a mapping, and the exit code for the worst of them
+func TestEvalDataFile(t *testing.T) {
+	server := enginetest.NewFakeServer()
+	defer server.Close()
+
+	code, stdout, stderr := policyctl("{\"Person\": {\"age\": 70}}\n\n{\"Person\": {\"age\": 30}}\n", "eval", "--url", server.URL, "--rule", seniorRule, "--data-file", "-")
+	assert.Equal(t, exitFalse, code, stderr)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"result":true`)
+	assert.Contains(t, lines[1], `"result":false`)
+
+	mapping := writeFile(t, "mapping.yaml", "columns:\n  - column: age\n    path: Person.age\n    type: number\n")
+	csv := writeFile(t, "people.csv", "name,age\nAda,70\nBob,old\nCy,66\n")
+	code, stdout, stderr = policyctl("", "eval", "--url", server.URL, "--rule", seniorRule, "--data-file", csv, "--mapping", mapping, "--pretty")
+	assert.Equal(t, exitError, code, "a malformed row is an error")
+	assert.Contains(t, stderr, "line 3")
+	assert.Equal(t, 2, strings.Count(stdout, "result: true"), "the rows after it are evaluated")
+	assert.Contains(t, stdout, "record 3: result: true")
+
+	for name, tt := range map[string]struct {
+		args []string
+		want string
+	}{
+		"both":       {[]string{"--data", "x.json", "--data-file", "x.ndjson"}, "give one of --data and --data-file"},
+		"no file":    {[]string{"--mapping", mapping}, "--mapping needs --data-file"},
+		"bad map":    {[]string{"--data-file", csv, "--mapping", csv}, "failed to decode mapping"},
+		"no records": {[]string{"--data-file", "missing.ndjson"}, "failed to read data"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			code, _, stderr := policyctl("", append([]string{"eval", "--url", server.URL, "--rule", seniorRule}, tt.args...)...)
+			assert.Equal(t, exitError, code)
+			assert.Contains(t, stderr, tt.want)
+		})
+	}
+}
+
+// TestEvalWarnings tests that --pretty prints the engine's warnings in
+// yellow after the explanation
+func TestEvalWarnings(t *testing.T) {
+	server := enginetest.NewFakeServer(enginetest.WithStub(seniorRule, client.PolicyResponse{
+		Result:   true,
+		Warnings: []client.Warning{{Code: "deprecated_operator", Message: "use is at least", RulePosition: client.Position{Rule: -1}}},
+	}))
+	defer server.Close()
+
+	code, stdout, _ := policyctl("", "eval", "--url", server.URL, "--rule", seniorRule, "--pretty")
+	assert.Equal(t, exitTrue, code)
+	assert.Contains(t, stdout, yellow+"warning (deprecated_operator): use is at least"+reset+"\n")
+}
+
+// TestCapabilities tests the capabilities of an engine with the job
+// endpoints and one without /version
+func TestCapabilities(t *testing.T) {
+	async := enginetest.NewFakeServer(enginetest.WithAsync(0))
+	defer async.Close()
+	code, stdout, stderr := policyctl("", "capabilities", "--url", async.URL)
+	assert.Equal(t, exitTrue, code, stderr)
+	assert.JSONEq(t, `{"version": "enginetest", "capabilities": {"async": true}}`, stdout)
+
+	plain := enginetest.NewFakeServer()
+	defer plain.Close()
+	code, stdout, _ = policyctl("", "capabilities", "--url", plain.URL, "--pretty")
+	assert.Equal(t, exitTrue, code)
+	assert.Contains(t, stdout, "engine version unknown\n")
+	assert.Contains(t, stdout, "async        fallback\n")
+}
+
+// writeRules writes the rule files policyset.LoadCorpus reads to a temporary
+// directory and returns it
+func writeRules(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, text := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(text), 0o600))
+	}
+	return dir
+}
+
+// TestSearch tests each kind of search, and the exit code when nothing
+// matches
+func TestSearch(t *testing.T) {
+	dir := writeRules(t, map[string]string{
+		"senior.rule": seniorRule + "\n",
+		"gold.rule":   `A **Customer** gets gold if the __membership_level__ of the **Customer** is equal to "Gold".` + "\n",
+		"notes.txt":   "not a rule",
+	})
+
+	for name, tt := range map[string]struct {
+		args []string
+		file string
+	}{
+		"property":        {[]string{"--property", "Person.age"}, "senior.rule"},
+		"any entity":      {[]string{"--property", "membership_level"}, "gold.rule"},
+		"operator":        {[]string{"--operator", ">="}, "senior.rule"},
+		"number":          {[]string{"--literal", "65"}, "senior.rule"},
+		"quoted string":   {[]string{"--literal", `"Gold"`}, "gold.rule"},
+		"bare string":     {[]string{"--literal", "Gold"}, "gold.rule"},
+		"text":            {[]string{"--text", "gets GOLD"}, "gold.rule"},
+		"synonymous text": {[]string{"--text", "is at least 65"}, "senior.rule"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			code, stdout, stderr := policyctl("", append([]string{"search", "--dir", dir}, tt.args...)...)
+			assert.Equal(t, exitTrue, code, stderr)
+			var matches []struct {
+				File string `json:"file"`
+				Line int    `json:"line"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(stdout), &matches), stdout)
+			require.Len(t, matches, 1)
+			assert.Equal(t, tt.file, matches[0].File)
+			assert.Equal(t, 1, matches[0].Line)
+		})
+	}
+
+	code, stdout, _ := policyctl("", "search", "--dir", dir, "--property", "Person.age", "--pretty")
+	assert.Equal(t, exitTrue, code)
+	assert.Equal(t, filepath.Join(dir, "senior.rule")+":1: the __age__ of the **Person** is greater than or equal to 65\n", stdout)
+
+	code, stdout, _ = policyctl("", "search", "--dir", dir, "--literal", "false")
+	assert.Equal(t, exitFalse, code)
+	assert.Equal(t, "[]\n", stdout)
+
+	code, _, stderr := policyctl("", "search", "--dir", dir, "--text", "gold", "--literal", "65")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "give one of --property, --operator, --literal and --text")
+}
+
+// auditRecord is an audit line for seniorRule, as client.JSONAuditSink
+// writes it under client.WithAuditPayloads
+func auditRecord(t *testing.T, id string, age int, result bool) string {
+	line, err := json.Marshal(client.AuditRecord{
+		DecisionID: id,
+		RuleHash:   client.RuleHash(seniorRule),
+		Result:     result,
+		Data:       json.RawMessage(`{"Person": {"age": ` + strconv.Itoa(age) + `}}`),
+	})
+	require.NoError(t, err)
+	return string(line)
+}
+
+// TestReplay tests replaying a record and an audit file, resolving the rule
+// from a rules directory
+func TestReplay(t *testing.T) {
+	server := enginetest.NewFakeServer()
+	defer server.Close()
+	// Records name the rule by the hash of the file, so it holds the rule alone
+	rules := writeRules(t, map[string]string{"senior.rule": seniorRule})
+
+	record := writeFile(t, "record.json", auditRecord(t, "d1", 70, true))
+	code, stdout, stderr := policyctl("", "replay", "--url", server.URL, "--rules-dir", rules, "--record", record)
+	assert.Equal(t, exitTrue, code, stderr)
+	assert.JSONEq(t, `{"decision_id": "d1", "rule_hash": "`+client.RuleHash(seniorRule)+`", "match": true, "diff": {}}`, stdout)
+
+	code, _, stderr = policyctl("", "replay", "--url", server.URL, "--record", record)
+	assert.Equal(t, exitError, code, "the record does not carry its rule")
+	assert.Contains(t, stderr, "d1")
+
+	audit := strings.Join([]string{auditRecord(t, "d1", 70, true), auditRecord(t, "d2", 70, false), "not a record"}, "\n")
+	code, stdout, stderr = policyctl(audit, "replay", "--url", server.URL, "--rules-dir", rules, "--audit", "-")
+	assert.Equal(t, exitError, code, "a line is not a record")
+	assert.Contains(t, stderr, "line 3")
+	var summary struct {
+		Records    int `json:"records"`
+		Matched    int `json:"matched"`
+		Mismatched int `json:"mismatched"`
+		Failed     int `json:"failed"`
+		Mismatches []struct {
+			DecisionID string `json:"decision_id"`
+		} `json:"mismatches"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(stdout), &summary), stdout)
+	assert.Equal(t, 3, summary.Records)
+	assert.Equal(t, 1, summary.Matched)
+	assert.Equal(t, 1, summary.Mismatched)
+	assert.Equal(t, 1, summary.Failed)
+	require.Len(t, summary.Mismatches, 1)
+	assert.Equal(t, "d2", summary.Mismatches[0].DecisionID)
+
+	code, stdout, _ = policyctl(auditRecord(t, "d2", 70, false), "replay", "--url", server.URL, "--rules-dir", rules, "--audit", "-", "--pretty")
+	assert.Equal(t, exitFalse, code)
+	assert.Contains(t, stdout, "1 records: 0 matched, 1 mismatched, 0 unresolved, 0 failed\n")
+	assert.Contains(t, stdout, "decision d2 replayed differently:\n")
+}
+
+// TestSimulate tests comparing two rules over NDJSON inputs
+func TestSimulate(t *testing.T) {
+	server := enginetest.NewFakeServer()
+	defer server.Close()
+	oldRule := writeFile(t, "old.rule", seniorRule)
+	newRule := writeFile(t, "new.rule", strings.Replace(seniorRule, "65", "60", 1))
+	inputs := "{\"Person\": {\"age\": 62}}\n{\"Person\": {\"age\": 70}}\n{\"Person\": {\"age\": 30}}\n"
+
+	code, stdout, stderr := policyctl(inputs, "simulate", "--url", server.URL, "--old-rule-file", oldRule, "--new-rule-file", newRule, "--inputs", "-")
+	assert.Equal(t, exitFalse, code, stderr)
+	var report struct {
+		Inputs  int `json:"inputs"`
+		Granted int `json:"granted"`
+		Revoked int `json:"revoked"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(stdout), &report), stdout)
+	assert.Equal(t, 3, report.Inputs)
+	assert.Equal(t, 1, report.Granted)
+	assert.Equal(t, 0, report.Revoked)
+
+	code, stdout, _ = policyctl(inputs, "simulate", "--url", server.URL, "--old-rule-file", oldRule, "--new-rule-file", oldRule, "--inputs", "-", "--pretty")
+	assert.Equal(t, exitTrue, code)
+	assert.Contains(t, stdout, "3 inputs, 3 evaluated: 0 flipped (0 granted, 0 revoked)")
+
+	code, _, stderr = policyctl("", "simulate", "--old-rule-file", oldRule, "--inputs", "-")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "--old-rule-file, --new-rule-file and --inputs are needed")
+}
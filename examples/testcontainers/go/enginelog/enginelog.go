@@ -0,0 +1,258 @@
+// Package enginelog parses the Policy Engine's container output into typed
+// entries, so tests can assert on warnings and notices that only appear in
+// the logs.
+//
+// Two formats are understood, line by line and in any mix: JSON lines as
+// written by tracing-subscriber's JSON layer, and its plain text layout,
+//
+//	2024-05-01T10:00:00.123456Z  WARN engine::parser: deprecated phrase rule="..."
+//
+// Any other line, such as the engine's own startup message, becomes an entry
+// at LevelNone holding the line as its message.
+package enginelog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Level is an entry's severity
+type Level int
+
+const (
+	// LevelNone marks a line that carries no level
+	LevelNone Level = iota
+	LevelTrace
+	LevelDebug
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[string]Level{
+	"TRACE": LevelTrace,
+	"DEBUG": LevelDebug,
+	"INFO":  LevelInfo,
+	"WARN":  LevelWarn,
+	"ERROR": LevelError,
+}
+
+func (l Level) String() string {
+	switch l {
+	case LevelTrace:
+		return "TRACE"
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return "NONE"
+	}
+}
+
+// Entry is one log line
+type Entry struct {
+	// Time is zero when the line has no timestamp
+	Time    time.Time
+	Level   Level
+	Target  string
+	Message string
+	// Fields are the structured fields besides the message, with values as
+	// written: strings unquoted, anything else in its JSON or text form
+	Fields map[string]string
+	// Line is the line as read, without ANSI colours
+	Line string
+}
+
+// maxLineBytes bounds one log line; longer lines fail Parse
+const maxLineBytes = 1 << 20
+
+// Parse reads every line of r into an entry, skipping blank lines
+func Parse(r io.Reader) ([]Entry, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLineBytes)
+	var entries []Entry
+	for scanner.Scan() {
+		line := ansi.ReplaceAllString(scanner.Text(), "")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entries = append(entries, ParseLine(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("enginelog: failed to read logs: %w", err)
+	}
+	return entries, nil
+}
+
+// ansi matches the colour codes tracing-subscriber writes to a terminal
+var ansi = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// ParseLine parses one line in either format
+func ParseLine(line string) Entry {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "{") {
+		if entry, ok := parseJSON(trimmed); ok {
+			entry.Line = line
+			return entry
+		}
+	}
+	if entry, ok := parseText(trimmed); ok {
+		entry.Line = line
+		return entry
+	}
+	return Entry{Message: trimmed, Line: line}
+}
+
+// jsonLine is tracing-subscriber's JSON layout; with flattened events the
+// fields sit at the top level instead
+type jsonLine struct {
+	Timestamp string                     `json:"timestamp"`
+	Level     string                     `json:"level"`
+	Target    string                     `json:"target"`
+	Fields    map[string]json.RawMessage `json:"fields"`
+}
+
+func parseJSON(line string) (Entry, bool) {
+	var decoded jsonLine
+	if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+		return Entry{}, false
+	}
+	level, ok := levelNames[strings.ToUpper(decoded.Level)]
+	if !ok {
+		return Entry{}, false
+	}
+
+	fields := decoded.Fields
+	if fields == nil {
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			return Entry{}, false
+		}
+		for _, key := range []string{"timestamp", "level", "target", "span", "spans"} {
+			delete(fields, key)
+		}
+	}
+
+	entry := Entry{Level: level, Target: decoded.Target, Fields: map[string]string{}}
+	entry.Time, _ = time.Parse(time.RFC3339Nano, decoded.Timestamp)
+	for key, raw := range fields {
+		value := string(raw)
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			value = s
+		}
+		if key == "message" {
+			entry.Message = value
+			continue
+		}
+		entry.Fields[key] = value
+	}
+	return entry, true
+}
+
+// textLine is the plain layout: timestamp, level, then "target: message"
+// followed by key=value fields
+var textLine = regexp.MustCompile(`^(\S+)\s+(TRACE|DEBUG|INFO|WARN|ERROR)\s+(?:([\w:]+):\s)?(.*)$`)
+
+func parseText(line string) (Entry, bool) {
+	match := textLine.FindStringSubmatch(line)
+	if match == nil {
+		return Entry{}, false
+	}
+	at, err := time.Parse(time.RFC3339Nano, match[1])
+	if err != nil {
+		return Entry{}, false
+	}
+	message, fields := splitFields(match[4])
+	return Entry{Time: at, Level: levelNames[match[2]], Target: match[3], Message: message, Fields: fields}, true
+}
+
+// splitFields separates the trailing key=value fields from a plain message
+func splitFields(text string) (string, map[string]string) {
+	fields := map[string]string{}
+	tokens := tokenize(text)
+	first := len(tokens)
+	for first > 0 {
+		key, _, ok := strings.Cut(tokens[first-1], "=")
+		if !ok || !isIdentifier(key) {
+			break
+		}
+		first--
+	}
+	for _, token := range tokens[first:] {
+		key, value, _ := strings.Cut(token, "=")
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		fields[key] = value
+	}
+	return strings.Join(tokens[:first], " "), fields
+}
+
+// tokenize splits on spaces outside double quotes
+func tokenize(text string) []string {
+	var tokens []string
+	var current bytes.Buffer
+	quoted, escaped := false, false
+	for _, r := range text {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quoted:
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r != '_' && r != '.' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Matching returns the entries at level whose message contains substr
+func Matching(entries []Entry, level Level, substr string) []Entry {
+	var matched []Entry
+	for _, entry := range entries {
+		if entry.Level == level && strings.Contains(entry.Message, substr) {
+			matched = append(matched, entry)
+		}
+	}
+	return matched
+}
+
+// Contains reports whether an entry at level has a message containing
+// substr
+func Contains(entries []Entry, level Level, substr string) bool {
+	return len(Matching(entries, level, substr)) > 0
+}
@@ -0,0 +1,91 @@
+package enginelog
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseFixture(t *testing.T, name string) []Entry {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	require.NoError(t, err)
+	defer f.Close()
+	entries, err := Parse(f)
+	require.NoError(t, err)
+	return entries
+}
+
+// TestParsePrintln tests the engine's current output, which is printed
+// without a level
+func TestParsePrintln(t *testing.T) {
+	entries := parseFixture(t, "println.log")
+	require.Len(t, entries, 1)
+	assert.Equal(t, Entry{Level: LevelNone, Message: "Listening on http://0.0.0.0:3000", Line: "Listening on http://0.0.0.0:3000"}, entries[0])
+	assert.True(t, Contains(entries, LevelNone, "Listening on"))
+	assert.False(t, Contains(entries, LevelInfo, "Listening on"))
+}
+
+// TestParseTracing tests the plain and JSON layouts of tracing-subscriber,
+// mixed in one log
+func TestParseTracing(t *testing.T) {
+	entries := parseFixture(t, "tracing.log")
+	require.Len(t, entries, 6)
+
+	var levels []Level
+	for _, entry := range entries {
+		levels = append(levels, entry.Level)
+	}
+	assert.Equal(t, []Level{LevelInfo, LevelWarn, LevelWarn, LevelError, LevelInfo, LevelNone}, levels)
+
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), entries[0].Time)
+	assert.Equal(t, "engine", entries[0].Target)
+	assert.Equal(t, "listening", entries[0].Message)
+	assert.Equal(t, map[string]string{"addr": "0.0.0.0:3000"}, entries[0].Fields)
+
+	// The same warning in both layouts parses the same
+	text, jsonLine := entries[1], entries[2]
+	assert.Equal(t, `deprecated phrase, use "is greater than or equal to"`, text.Message)
+	assert.Equal(t, text.Message, jsonLine.Message)
+	assert.Equal(t, "engine::parser", text.Target)
+	assert.Equal(t, text.Target, jsonLine.Target)
+	assert.Equal(t, map[string]string{"phrase": "is at least", "line": "1"}, text.Fields)
+	assert.Equal(t, text.Fields, jsonLine.Fields)
+	assert.Equal(t, 1250*time.Millisecond, jsonLine.Time.Sub(text.Time))
+
+	// Flattened JSON events keep their fields at the top level
+	assert.Equal(t, "failed to evaluate", entries[3].Message)
+	assert.Equal(t, map[string]string{"code": "evaluation_error"}, entries[3].Fields)
+
+	// Colours are stripped
+	assert.Equal(t, "request served", entries[4].Message)
+	assert.False(t, strings.Contains(entries[4].Line, "\x1b"))
+
+	assert.Len(t, Matching(entries, LevelWarn, "deprecated"), 2)
+	assert.True(t, Contains(entries, LevelError, "failed"))
+	assert.False(t, Contains(entries, LevelWarn, "failed"))
+}
+
+// TestParseLineFallsBack tests that lines resembling a format but not
+// matching it are kept whole
+func TestParseLineFallsBack(t *testing.T) {
+	for _, line := range []string{
+		"{not json",
+		`{"message":"no level"}`,
+		"yesterday  WARN engine: not a timestamp",
+	} {
+		entry := ParseLine(line)
+		assert.Equal(t, LevelNone, entry.Level, line)
+		assert.Equal(t, line, entry.Message, line)
+	}
+}
+
+// TestParseLongLine tests that a line past the limit fails Parse
+func TestParseLongLine(t *testing.T) {
+	_, err := Parse(strings.NewReader(strings.Repeat("x", maxLineBytes+1)))
+	assert.ErrorContains(t, err, "enginelog: failed to read logs")
+}
@@ -0,0 +1,89 @@
+package enginetest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"policy-engine-testcontainer-example/client"
+)
+
+// WithAsync serves the job endpoints of client.CapabilityAsync, and
+// advertises it on GET /version: POST /jobs queues an evaluation and
+// answers its ID, and GET /jobs/{id} answers 202 while the job is pending
+// and then the evaluation as POST / would. A job stays pending for its
+// first pendingPolls polls, so tests can watch it finish. Without it the
+// fake has neither, like the current engine.
+func WithAsync(pendingPolls int) Option {
+	return func(e *Engine) {
+		e.async = &jobQueue{pendingPolls: pendingPolls, jobs: map[string]*job{}}
+		e.advertise(client.CapabilityAsync)
+	}
+}
+
+// jobQueue holds the jobs submitted to the fake
+type jobQueue struct {
+	pendingPolls int
+
+	mu   sync.Mutex
+	next int
+	jobs map[string]*job
+}
+
+// job is a submitted evaluation, decided when it was submitted
+type job struct {
+	polls    int
+	status   int
+	response client.PolicyResponse
+}
+
+// Jobs returns how many jobs have been submitted under WithAsync
+func (e *Engine) Jobs() int {
+	if e.async == nil {
+		return 0
+	}
+	e.async.mu.Lock()
+	defer e.async.mu.Unlock()
+	return e.async.next
+}
+
+// serveAsync answers r if it is for one of the job endpoints,
+// reporting whether it was
+func (e *Engine) serveAsync(w http.ResponseWriter, r *http.Request) bool {
+	q := e.async
+	switch {
+	case r.URL.Path == "/jobs" && r.Method == http.MethodPost:
+		status, response, err := e.evaluate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return true
+		}
+		q.mu.Lock()
+		q.next++
+		id := "job-" + strconv.Itoa(q.next)
+		q.jobs[id] = &job{status: status, response: response}
+		q.mu.Unlock()
+		writeJSON(w, http.StatusAccepted, map[string]string{"id": id, "status": "pending"})
+	case strings.HasPrefix(r.URL.Path, "/jobs/") && r.Method == http.MethodGet:
+		id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+		q.mu.Lock()
+		j, ok := q.jobs[id]
+		pending := ok && j.polls < q.pendingPolls
+		if ok {
+			j.polls++
+		}
+		q.mu.Unlock()
+		switch {
+		case !ok:
+			http.NotFound(w, r)
+		case pending:
+			writeJSON(w, http.StatusAccepted, map[string]string{"id": id, "status": "pending"})
+		default:
+			writeJSON(w, j.status, j.response)
+		}
+	default:
+		return false
+	}
+	return true
+}
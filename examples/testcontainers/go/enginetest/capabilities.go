@@ -0,0 +1,131 @@
+package enginetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"policy-engine-testcontainer-example/client"
+)
+
+// WithCapabilities advertises capabilities on GET /version, besides those
+// the fake's other options serve. The fake takes a policy's rules as a
+// "rules" array whether or not client.CapabilityMultiRule is advertised, as
+// it reports warnings only when stubbed to, so advertising either is up to
+// the test.
+func WithCapabilities(capabilities ...client.Capability) Option {
+	return func(e *Engine) {
+		e.advertise(capabilities...)
+	}
+}
+
+// WithBatch serves POST /batch, client.CapabilityBatch, and advertises it on
+// GET /version. Each request in a batch is answered as it would be sent
+// alone.
+func WithBatch() Option {
+	return func(e *Engine) {
+		e.batch = true
+		e.advertise(client.CapabilityBatch)
+	}
+}
+
+// WithMaxRequestBytes answers evaluations declaring a body larger than n
+// bytes with 413 Request Entity Too Large, and advertises the limit on GET
+// /version
+func WithMaxRequestBytes(n int64) Option {
+	return func(e *Engine) {
+		e.maxRequestBytes = n
+	}
+}
+
+// advertise adds capabilities to those GET /version lists
+func (e *Engine) advertise(capabilities ...client.Capability) {
+	for _, capability := range capabilities {
+		if !e.advertised(capability) {
+			e.capabilities = append(e.capabilities, capability)
+		}
+	}
+}
+
+// advertised reports whether GET /version lists capability
+func (e *Engine) advertised(capability client.Capability) bool {
+	for _, advertised := range e.capabilities {
+		if advertised == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Batches returns how many batches POST /batch has answered under WithBatch
+func (e *Engine) Batches() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.batches
+}
+
+// serveCapabilities answers r if it is for GET /version or POST /batch and
+// the fake serves it, reporting whether it was. A fake advertising nothing
+// has no /version, like the current engine.
+func (e *Engine) serveCapabilities(w http.ResponseWriter, r *http.Request) bool {
+	switch {
+	case r.URL.Path == "/version" && r.Method == http.MethodGet && (len(e.capabilities) > 0 || e.maxRequestBytes > 0):
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"version":           "enginetest",
+			"capabilities":      e.capabilities,
+			"max_request_bytes": e.maxRequestBytes,
+		})
+	case r.URL.Path == "/batch" && r.Method == http.MethodPost && e.batch:
+		e.serveBatch(w, r)
+	default:
+		return false
+	}
+	return true
+}
+
+// batchRequest and batchResponse are one evaluation in the bodies of POST
+// /batch; a body that is not JSON is sent as a string
+type (
+	batchRequest struct {
+		Header http.Header     `json:"header"`
+		Body   json.RawMessage `json:"body"`
+	}
+	batchResponse struct {
+		Status int             `json:"status"`
+		Header http.Header     `json:"header"`
+		Body   json.RawMessage `json:"body"`
+	}
+)
+
+// serveBatch answers each request of a batch as ServeHTTP answers POST /
+func (e *Engine) serveBatch(w http.ResponseWriter, r *http.Request) {
+	var batch struct {
+		Requests []batchRequest `json:"requests"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode batch: %v", err), http.StatusBadRequest)
+		return
+	}
+	e.mu.Lock()
+	e.batches++
+	e.mu.Unlock()
+
+	responses := make([]batchResponse, len(batch.Requests))
+	for i, item := range batch.Requests {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(item.Body)).WithContext(r.Context())
+		for name, values := range item.Header {
+			req.Header[name] = values
+		}
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, req)
+		body := bytes.TrimSpace(recorder.Body.Bytes())
+		if !json.Valid(body) {
+			// An error the fake answers in plain text
+			body, _ = json.Marshal(string(body))
+		}
+		responses[i] = batchResponse{Status: recorder.Code, Header: recorder.Header(), Body: body}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"responses": responses})
+}
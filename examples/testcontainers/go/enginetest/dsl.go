@@ -0,0 +1,657 @@
+package enginetest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	operatorPhrase = `is greater than or equal to|is less than or equal to|is not the same as|` +
+		`is exactly equal to|is not equal to|is greater than|is the same as|is earlier than|` +
+		`is younger than|is no more than|is less than|is equal to|is later than|is older than|` +
+		`is not empty|is at least|is within|is not in|is empty|contains|is in`
+	propertyList = `((?:__(?:[^_]|_[^_])+__ (?:of|in) (?:the )?)+)\*\*([^*]+)\*\*`
+
+	header     = regexp.MustCompile(`^(?:.+?\. )?An? \*\*`)
+	ruleParts  = regexp.MustCompile(`^(?:(.+?)\. )?An? \*\*([^*]+)\*\* (.+?) if (.+)\.$`)
+	labelRef   = regexp.MustCompile(`^[§$]([A-Za-z0-9.]+)(?: .+)?$`)
+	comparison = regexp.MustCompile(`^(?:the )?(?:(number|length) of (?:the )?)?` + propertyList + ` (` + operatorPhrase + `)(?: (.+))?$`)
+	ruleRef    = regexp.MustCompile(`^(?:the )?\*\*([^*]+)\*\* (.+)$`)
+	accessOnly = regexp.MustCompile(`^(?:the )?` + propertyList + `$`)
+	property   = regexp.MustCompile(`__((?:[^_]|_[^_])+)__`)
+	space      = regexp.MustCompile(`\s+`)
+
+	dateLiteral     = regexp.MustCompile(`^(?:date\()?([0-9]{4}-[0-9]{2}-[0-9]{2})\)?$`)
+	durationLiteral = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?) (centur(?:y|ies)|decades?|years?|months?|weeks?|days?|hours?|minutes?|seconds?)$`)
+	numberLiteral   = regexp.MustCompile(`^[0-9]+(?:\.[0-9]+)?$`)
+)
+
+// outcomeVerbs are the verbs the grammar takes before an outcome, longest
+// first so "qualifies for" is not read as a verb-less outcome
+var outcomeVerbs = func() []string {
+	verbs := strings.Fields(`gets passes is has receives meets satisfies achieves attains earns gains
+		obtains secures acquires deserves merits warrants requires needs completes fulfills demonstrates
+		shows proves establishes maintains holds possesses displays exhibits presents provides supplies
+		delivers submits confirms validates verifies supports justifies ensures guarantees undergoes
+		experiences encounters faces enjoys suffers lacks misses fails reaches`)
+	verbs = append(verbs, "qualifies for", "benefits from", "succeeds in", "excels at", "arrives at", "comes to")
+	sort.Slice(verbs, func(i, j int) bool { return len(verbs[i]) > len(verbs[j]) })
+	return verbs
+}()
+
+// referencePrefixes are stripped from a reference before it is matched
+// against outcomes, as the engine does
+var referencePrefixes = []string{"passes the", "passes", "has the", "has", "is", "gets the", "gets"}
+
+// errUnsupported is a rule the engine parses but the fake does not
+var errUnsupported = errors.New("not supported by the fake engine")
+
+// ruleSet is a parsed policy
+type ruleSet struct {
+	rules []*rule
+}
+
+type rule struct {
+	label   string
+	object  string
+	verb    string
+	outcome string
+	// conditions and operators alternate: operators[i] joins conditions[i]
+	// to conditions[i+1]
+	conditions []condition
+	operators  []string
+}
+
+// condition is one condition; exactly one of its parts is set
+type condition struct {
+	label     string
+	reference *reference
+	compare   *compare
+}
+
+type reference struct {
+	object, name string
+}
+
+type compare struct {
+	count    string
+	left     access
+	operator string
+	right    operand
+}
+
+// access is a property of an object: "the __city__ of the __address__ of the
+// **Customer**" is object Customer, path address.city
+type access struct {
+	object string
+	path   []string
+}
+
+func (a access) String() string {
+	return a.object + "." + strings.Join(a.path, ".")
+}
+
+// operand is what a property is compared with: a literal or another property
+type operand struct {
+	value    interface{}
+	property *access
+}
+
+// parse reads text into rules. It leaves syntax errors to rulecheck and
+// fails for what the fake does not support.
+func parse(text string) (*ruleSet, error) {
+	set := &ruleSet{}
+	for _, source := range splitRules(text) {
+		m := ruleParts.FindStringSubmatch(source)
+		if m == nil {
+			return nil, fmt.Errorf("rule %q: %w", source, errUnsupported)
+		}
+		r := &rule{label: m[1], object: m[2]}
+		r.verb, r.outcome = splitOutcome(m[3])
+		parts, operators := splitConditions(m[4])
+		r.operators = operators
+		for _, part := range parts {
+			c, err := parseCondition(part)
+			if err != nil {
+				return nil, err
+			}
+			r.conditions = append(r.conditions, c)
+		}
+		set.rules = append(set.rules, r)
+	}
+	// The engine reads a reference to no rule as a property of the object,
+	// or as free text that holds
+	for _, r := range set.rules {
+		for _, c := range r.conditions {
+			if c.reference != nil && set.resolve(c) == nil {
+				return nil, fmt.Errorf("reference %q to no rule: %w", "the **"+c.reference.object+"** "+c.reference.name, errUnsupported)
+			}
+		}
+	}
+	return set, nil
+}
+
+// splitRules splits text into its rules, each on one line with its spaces
+// collapsed; comment lines are dropped
+func splitRules(text string) []string {
+	var rules []string
+	var current []string
+	flush := func() {
+		if len(current) > 0 {
+			rules = append(rules, strings.Join(current, " "))
+			current = nil
+		}
+	}
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flush()
+		case strings.HasPrefix(trimmed, "#"):
+		default:
+			if header.MatchString(trimmed) {
+				flush()
+			}
+			current = append(current, space.ReplaceAllString(trimmed, " "))
+		}
+	}
+	flush()
+	return rules
+}
+
+func splitOutcome(text string) (verb, outcome string) {
+	for _, v := range outcomeVerbs {
+		if strings.HasPrefix(text, v+" ") {
+			return v, strings.TrimPrefix(text, v+" ")
+		}
+	}
+	return "", text
+}
+
+// splitConditions splits the conditions of a rule at its "and" and "or",
+// outside strings and lists, and not at the "or" of "is greater than or
+// equal to"
+func splitConditions(text string) (parts, operators []string) {
+	quoted, depth, start := false, 0, 0
+	for i := 0; i < len(text); i++ {
+		switch ch := text[i]; {
+		case ch == '"':
+			quoted = !quoted
+		case quoted:
+		case ch == '[':
+			depth++
+		case ch == ']':
+			depth--
+		case depth == 0 && ch == ' ':
+			for _, word := range []string{"and", "or"} {
+				rest := text[i+1:]
+				if !strings.HasPrefix(rest, word+" ") {
+					continue
+				}
+				if word == "or" && strings.HasSuffix(text[:i], "than") && strings.HasPrefix(rest, "or equal to") {
+					continue
+				}
+				parts = append(parts, text[start:i])
+				operators = append(operators, word)
+				start = i + len(word) + 2
+				i = start - 1
+				break
+			}
+		}
+	}
+	return append(parts, text[start:]), operators
+}
+
+func parseCondition(text string) (condition, error) {
+	if m := labelRef.FindStringSubmatch(text); m != nil {
+		return condition{label: m[1]}, nil
+	}
+	if m := comparison.FindStringSubmatch(text); m != nil {
+		c := &compare{count: m[1], left: newAccess(m[2], m[3]), operator: m[4]}
+		if m[5] != "" {
+			right, err := parseOperand(m[5])
+			if err != nil {
+				return condition{}, err
+			}
+			c.right = right
+		}
+		return condition{compare: c}, nil
+	}
+	if m := ruleRef.FindStringSubmatch(text); m != nil {
+		return condition{reference: &reference{object: m[1], name: m[2]}}, nil
+	}
+	return condition{}, fmt.Errorf("condition %q: %w", text, errUnsupported)
+}
+
+// newAccess reads the properties of an access, written outermost first,
+// into a path from the object
+func newAccess(properties, object string) access {
+	found := property.FindAllStringSubmatch(properties, -1)
+	path := make([]string, len(found))
+	for i, p := range found {
+		path[len(found)-1-i] = p[1]
+	}
+	return access{object: object, path: path}
+}
+
+func parseOperand(text string) (operand, error) {
+	if m := accessOnly.FindStringSubmatch(text); m != nil {
+		a := newAccess(m[1], m[2])
+		return operand{property: &a}, nil
+	}
+	if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+		var items []interface{}
+		for _, item := range splitList(text[1 : len(text)-1]) {
+			value, err := parseLiteral(strings.TrimSpace(item))
+			if err != nil {
+				return operand{}, err
+			}
+			items = append(items, value)
+		}
+		return operand{value: items}, nil
+	}
+	value, err := parseLiteral(text)
+	return operand{value: value}, err
+}
+
+// splitList splits the inside of a list at the commas outside strings
+func splitList(list string) []string {
+	var items []string
+	quoted, start := false, 0
+	for i := 0; i < len(list); i++ {
+		switch list[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				items = append(items, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, list[start:])
+}
+
+// duration is a duration literal, kept in its unit so years are calendar
+// years
+type duration struct {
+	amount float64
+	unit   string
+}
+
+func parseLiteral(text string) (interface{}, error) {
+	switch {
+	case len(text) >= 2 && strings.HasPrefix(text, `"`) && strings.HasSuffix(text, `"`):
+		return text[1 : len(text)-1], nil
+	case dateLiteral.MatchString(text):
+		return time.Parse("2006-01-02", dateLiteral.FindStringSubmatch(text)[1])
+	case durationLiteral.MatchString(text):
+		m := durationLiteral.FindStringSubmatch(text)
+		amount, _ := strconv.ParseFloat(m[1], 64)
+		return duration{amount: amount, unit: strings.TrimSuffix(strings.Replace(m[2], "ies", "y", 1), "s")}, nil
+	case numberLiteral.MatchString(text):
+		return strconv.ParseFloat(text, 64)
+	case text == "true" || text == "false":
+		return text == "true", nil
+	}
+	// A bare word is a string
+	return text, nil
+}
+
+// evaluation is one request's evaluation of a rule set
+type evaluation struct {
+	set  *ruleSet
+	data map[string]interface{}
+	now  time.Time
+
+	results    map[*rule]bool
+	evaluating map[*rule]bool
+}
+
+// global is the rule no other rule refers to, whose result is the answer
+func (s *ruleSet) global() (*rule, error) {
+	if len(s.rules) == 1 {
+		return s.rules[0], nil
+	}
+	referenced := map[*rule]bool{}
+	for _, r := range s.rules {
+		for _, c := range r.conditions {
+			if target := s.resolve(c); target != nil {
+				referenced[target] = true
+			}
+		}
+	}
+	var globals []*rule
+	var names []string
+	for _, r := range s.rules {
+		if !referenced[r] {
+			globals = append(globals, r)
+			names = append(names, fmt.Sprintf("'%s'", r.outcome))
+		}
+	}
+	switch len(globals) {
+	case 1:
+		return globals[0], nil
+	case 0:
+		return nil, errors.New("Parse error: No global rule found")
+	}
+	return nil, fmt.Errorf("Parse error: Multiple global rules found: %s. There should be only one golden rule that is not referenced by other rules.", strings.Join(names, ", "))
+}
+
+// resolve is the rule a condition refers to, if it is a reference
+func (s *ruleSet) resolve(c condition) *rule {
+	switch {
+	case c.label != "":
+		for _, r := range s.rules {
+			if r.label == c.label {
+				return r
+			}
+		}
+	case c.reference != nil:
+		name := strings.ToLower(c.reference.name)
+		for _, r := range s.rules {
+			if r.label == c.reference.name || strings.ToLower(r.outcome) == name || strings.ToLower(r.verb+" "+r.outcome) == name {
+				return r
+			}
+		}
+		cleaned := name
+		for _, prefix := range referencePrefixes {
+			if strings.HasPrefix(cleaned, prefix+" ") {
+				cleaned = strings.TrimPrefix(cleaned, prefix+" ")
+				break
+			}
+		}
+		for _, r := range s.rules {
+			outcome := strings.ToLower(r.outcome)
+			if outcome == cleaned || strings.Contains(outcome, cleaned) || strings.Contains(cleaned, outcome) {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+// evaluate decides r, and with and binding tighter than or, as the engine
+// does; every condition is evaluated, so an error in any fails the rule
+func (e *evaluation) evaluate(r *rule) (bool, error) {
+	if result, ok := e.results[r]; ok {
+		return result, nil
+	}
+	if e.evaluating[r] {
+		return false, fmt.Errorf("Evaluation error: circular reference to '%s'", r.outcome)
+	}
+	e.evaluating[r] = true
+	defer delete(e.evaluating, r)
+
+	results := make([]bool, len(r.conditions))
+	for i, c := range r.conditions {
+		result, err := e.condition(c)
+		if err != nil {
+			return false, err
+		}
+		results[i] = result
+	}
+	// Collapse the ands, then or what remains
+	groups := []bool{results[0]}
+	for i, op := range r.operators {
+		if op == "and" {
+			groups[len(groups)-1] = groups[len(groups)-1] && results[i+1]
+		} else {
+			groups = append(groups, results[i+1])
+		}
+	}
+	result := false
+	for _, g := range groups {
+		result = result || g
+	}
+	e.results[r] = result
+	return result, nil
+}
+
+func (e *evaluation) condition(c condition) (bool, error) {
+	if c.compare == nil {
+		target := e.set.resolve(c)
+		if target == nil {
+			// Only a label can be left unresolved by parse, and fails
+			return false, nil
+		}
+		return e.evaluate(target)
+	}
+
+	cmp := c.compare
+	left, found, err := e.lookup(cmp.left)
+	if err != nil || !found {
+		return false, err
+	}
+	switch cmp.count {
+	case "number":
+		items, ok := left.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("Evaluation error: the number of %s needs a list, got %v", cmp.left, left)
+		}
+		left = float64(len(items))
+	case "length":
+		switch v := left.(type) {
+		case string:
+			left = float64(utf8.RuneCountInString(v))
+		case []interface{}:
+			left = float64(len(v))
+		default:
+			return false, fmt.Errorf("Evaluation error: the length of %s needs a string or a list, got %v", cmp.left, left)
+		}
+	}
+	right := cmp.right.value
+	if cmp.right.property != nil {
+		if right, found, err = e.lookup(*cmp.right.property); err != nil || !found {
+			return false, err
+		}
+	}
+	return e.compare(cmp.operator, left, right)
+}
+
+// lookup finds an access in the data. A missing object is no error, and
+// fails the condition, but a missing property of an object that is there
+// is, as with the engine.
+func (e *evaluation) lookup(a access) (interface{}, bool, error) {
+	var current interface{} = e.data
+	for _, name := range strings.Split(a.object, ".") {
+		value, ok := field(current, name)
+		if !ok {
+			return nil, false, nil
+		}
+		current = value
+	}
+	for _, name := range a.path {
+		value, ok := field(current, name)
+		if !ok {
+			return nil, false, fmt.Errorf("Evaluation error: Property '%s' not found in selector '%s'", name, a.object)
+		}
+		current = value
+	}
+	return current, true, nil
+}
+
+// field is an object's field, matched exactly or else without regard to case
+func field(object interface{}, name string) (interface{}, bool) {
+	fields, ok := object.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	if value, ok := fields[name]; ok {
+		return value, true
+	}
+	for key, value := range fields {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func (e *evaluation) compare(operator string, left, right interface{}) (bool, error) {
+	switch operator {
+	case "is greater than or equal to", "is at least":
+		return ordered(left, right, func(c int) bool { return c >= 0 })
+	case "is greater than":
+		return ordered(left, right, func(c int) bool { return c > 0 })
+	case "is less than or equal to", "is no more than":
+		return ordered(left, right, func(c int) bool { return c <= 0 })
+	case "is less than":
+		return ordered(left, right, func(c int) bool { return c < 0 })
+	case "is equal to", "is exactly equal to", "is the same as":
+		return equal(left, right), nil
+	case "is not equal to", "is not the same as":
+		return !equal(left, right), nil
+	case "is later than", "is earlier than":
+		l, lok := asDate(left)
+		r, rok := asDate(right)
+		if !lok || !rok {
+			return false, fmt.Errorf("Evaluation error: %s requires date values, got %v and %v", operator, left, right)
+		}
+		if operator == "is later than" {
+			return l.After(r), nil
+		}
+		return l.Before(r), nil
+	case "is older than", "is younger than", "is within":
+		return e.aged(operator, left, right)
+	case "contains":
+		switch l := left.(type) {
+		case []interface{}:
+			for _, item := range l {
+				if equal(item, right) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case string:
+			r, ok := right.(string)
+			return ok && strings.Contains(l, r), nil
+		}
+		return false, fmt.Errorf("Evaluation error: contains needs a list or a string, got %v", left)
+	case "is in", "is not in":
+		items, ok := right.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("Evaluation error: %s needs a list, got %v", operator, right)
+		}
+		in := false
+		for _, item := range items {
+			in = in || equal(left, item)
+		}
+		return in == (operator == "is in"), nil
+	case "is empty", "is not empty":
+		return empty(left) == (operator == "is empty"), nil
+	}
+	return false, fmt.Errorf("Parse error: operator %q: %w", operator, errUnsupported)
+}
+
+// aged compares the date left with now less the duration right
+func (e *evaluation) aged(operator string, left, right interface{}) (bool, error) {
+	date, ok := asDate(left)
+	d, isDuration := right.(duration)
+	if !ok || !isDuration {
+		return false, fmt.Errorf("Evaluation error: %s requires a date and a duration, got %v and %v", operator, left, right)
+	}
+	today := time.Date(e.now.Year(), e.now.Month(), e.now.Day(), 0, 0, 0, 0, time.UTC)
+	since := d.after(date)
+	switch operator {
+	case "is older than":
+		return !since.After(today), nil
+	case "is younger than":
+		return since.After(today), nil
+	}
+	return !since.Before(today) && !date.After(today), nil
+}
+
+// after is date plus the duration
+func (d duration) after(date time.Time) time.Time {
+	whole := int(d.amount)
+	switch d.unit {
+	case "century":
+		return date.AddDate(100*whole, 0, 0)
+	case "decade":
+		return date.AddDate(10*whole, 0, 0)
+	case "year":
+		return date.AddDate(whole, 0, 0)
+	case "month":
+		return date.AddDate(0, whole, 0)
+	case "week":
+		return date.AddDate(0, 0, 7*whole)
+	case "day":
+		return date.AddDate(0, 0, whole)
+	case "hour":
+		return date.Add(time.Duration(d.amount * float64(time.Hour)))
+	case "minute":
+		return date.Add(time.Duration(d.amount * float64(time.Minute)))
+	}
+	return date.Add(time.Duration(d.amount * float64(time.Second)))
+}
+
+// ordered compares two numbers, or two dates
+func ordered(left, right interface{}, holds func(int) bool) (bool, error) {
+	if l, ok := left.(float64); ok {
+		if r, ok := right.(float64); ok {
+			switch {
+			case l < r:
+				return holds(-1), nil
+			case l > r:
+				return holds(1), nil
+			}
+			return holds(0), nil
+		}
+	}
+	if l, ok := asDate(left); ok {
+		if r, ok := asDate(right); ok {
+			return holds(l.Compare(r)), nil
+		}
+	}
+	return false, fmt.Errorf("Type error: cannot compare %v with %v", left, right)
+}
+
+func equal(left, right interface{}) bool {
+	if l, ok := left.(float64); ok {
+		r, ok := right.(float64)
+		return ok && math.Abs(l-r) < 1e-9
+	}
+	if l, ok := asDate(left); ok {
+		if r, ok := asDate(right); ok {
+			return l.Equal(r)
+		}
+	}
+	return left == right
+}
+
+// asDate reads a date value or a JSON string holding one
+func asDate(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for _, layout := range []string{"2006-01-02", "2006-01-02T15:04:05", time.RFC3339Nano} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func empty(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
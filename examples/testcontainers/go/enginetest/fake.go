@@ -0,0 +1,215 @@
+// Package enginetest provides a fake policy engine that runs in the test
+// process, for unit tests that cannot start the engine's container. It serves
+// the engine's HTTP API, so a client.PolicyClient works against it unchanged,
+// and evaluates a subset of the rule language in Go: comparisons of numbers,
+// strings and dates, is in and is not in, contains, is empty, the number and
+// length of a property, durations, labels and references to other rules, and
+// conditions joined with and and or. A reference must name a rule: the fake
+// does not read one as a property or as free text, as the engine can. A rule
+// outside the subset is answered with a parse error naming it; stub its
+// response instead.
+//
+// Responses carry no trace. WithAsync adds the job endpoints the client's
+// EvaluateAsync uses and WithBatch the batch endpoint of EvaluateBatch; with
+// either, or with WithCapabilities, the fake advertises what it serves on
+// GET /version for the client's WithCapabilityProbing.
+package enginetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/rulecheck"
+)
+
+// Engine is the fake engine, an http.Handler serving POST /, GET /health and
+// the endpoints its options add.
+// An Engine is safe for concurrent use.
+type Engine struct {
+	mu    sync.Mutex
+	stubs map[string]client.PolicyResponse
+	now   func() time.Time
+
+	// async is set by WithAsync
+	async *jobQueue
+	// batch is set by WithBatch, and batches counts the batches answered
+	batch   bool
+	batches int
+	// capabilities are those GET /version advertises, with maxRequestBytes
+	capabilities    []client.Capability
+	maxRequestBytes int64
+}
+
+// Option configures an Engine
+type Option func(*Engine)
+
+// WithStub answers rule with response instead of evaluating it; see
+// Engine.Stub
+func WithStub(rule string, response client.PolicyResponse) Option {
+	return func(e *Engine) {
+		e.stubs[rule] = response
+	}
+}
+
+// WithClock sets the clock durations are measured against, for rules such as
+// "is older than 18 years"; the default is time.Now. A request carrying
+// client.EvaluationTimeHeader is measured against that time instead.
+func WithClock(now func() time.Time) Option {
+	return func(e *Engine) {
+		e.now = now
+	}
+}
+
+// NewEngine returns an Engine configured with opts
+func NewEngine(opts ...Option) *Engine {
+	e := &Engine{stubs: map[string]client.PolicyResponse{}, now: time.Now}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// NewFakeServer starts a server for an Engine configured with opts. Close it
+// when done, and point a client at its URL.
+func NewFakeServer(opts ...Option) *httptest.Server {
+	return httptest.NewServer(NewEngine(opts...))
+}
+
+// Stub answers rule, matched exactly, with response from now on, replacing
+// any earlier stub. A response whose Error is set is sent with status 400, as
+// the engine sends its errors. Rule and Data default to the request's.
+func (e *Engine) Stub(rule string, response client.PolicyResponse) *Engine {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stubs[rule] = response
+	return e
+}
+
+// ServeHTTP answers POST / and GET /health the way the engine does
+func (e *Engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/health" && r.Method == http.MethodGet:
+		w.WriteHeader(http.StatusOK)
+		return
+	case e.serveCapabilities(w, r):
+		return
+	case e.async != nil && e.serveAsync(w, r):
+		return
+	case r.URL.Path != "/":
+		http.NotFound(w, r)
+		return
+	case r.Method != http.MethodPost:
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	case e.maxRequestBytes > 0 && r.ContentLength > e.maxRequestBytes:
+		http.Error(w, "request entity too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	status, response, err := e.evaluate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, status, response)
+}
+
+// evaluate answers the evaluation r asks for, with the status the engine
+// sends it with; the error is a request that cannot be decoded. The policy
+// is its rule, or its rules sent one by one as a "rules" array.
+func (e *Engine) evaluate(r *http.Request) (int, client.PolicyResponse, error) {
+	var wire struct {
+		client.PolicyRequest
+		Rules []string `json:"rules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&wire); err != nil {
+		return 0, client.PolicyResponse{}, fmt.Errorf("failed to decode request: %v", err)
+	}
+	req := wire.PolicyRequest
+	req.Rules = wire.Rules
+	var data map[string]interface{}
+	if raw, err := json.Marshal(req.Data); err == nil {
+		_ = json.Unmarshal(raw, &data)
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+
+	now := e.now()
+	if at, err := time.Parse(time.RFC3339, r.Header.Get(client.EvaluationTimeHeader)); err == nil {
+		now = at
+	}
+	response := e.answer(req.RuleText(), data, now)
+	if response.Rule == nil {
+		response.Rule = strings.Split(req.RuleText(), "\n")
+	}
+	if response.Data == nil {
+		response.Data = data
+	}
+
+	if response.Error != nil {
+		return http.StatusBadRequest, response, nil
+	}
+	return http.StatusOK, response, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// answer is the response to rule for data: its stub, or its evaluation
+func (e *Engine) answer(rule string, data map[string]interface{}, now time.Time) client.PolicyResponse {
+	e.mu.Lock()
+	stub, stubbed := e.stubs[rule]
+	e.mu.Unlock()
+	if stubbed {
+		return stub
+	}
+
+	result, labels, err := decide(rule, data, now)
+	if err != nil {
+		message := err.Error()
+		return client.PolicyResponse{Error: &message, Labels: labels}
+	}
+	return client.PolicyResponse{Result: result, Labels: labels}
+}
+
+// decide evaluates text for data at the time now, returning the global
+// rule's result and the result of each labelled rule evaluated. Its errors
+// read as the engine's do, starting "Parse error:", "Evaluation error:" or
+// "Type error:".
+func decide(text string, data map[string]interface{}, now time.Time) (bool, map[string]bool, error) {
+	if issues := rulecheck.ValidateRule(text); len(issues) > 0 {
+		return false, nil, fmt.Errorf("Parse error: %s", issues[0].Message)
+	}
+	set, err := parse(text)
+	if err != nil {
+		return false, nil, fmt.Errorf("Parse error: %v", err)
+	}
+	global, err := set.global()
+	if err != nil {
+		return false, nil, err
+	}
+
+	e := &evaluation{set: set, data: data, now: now.UTC(), results: map[*rule]bool{}, evaluating: map[*rule]bool{}}
+	result, err := e.evaluate(global)
+	var labels map[string]bool
+	for _, r := range set.rules {
+		if evaluated, ok := e.results[r]; ok && r.label != "" {
+			if labels == nil {
+				labels = map[string]bool{}
+			}
+			labels[r.label] = evaluated
+		}
+	}
+	return result, labels, err
+}
@@ -0,0 +1,213 @@
+package enginetest
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/evaluatortest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFakeConformance tests the client against the fake engine
+func TestFakeConformance(t *testing.T) {
+	evaluatortest.Conformance(t, func(t *testing.T) client.Evaluator {
+		server := NewFakeServer()
+		t.Cleanup(server.Close)
+		c, err := client.New(server.URL)
+		require.NoError(t, err)
+		return c
+	})
+}
+
+// TestDecide tests the rules the fake evaluates, with the engine's results
+func TestDecide(t *testing.T) {
+	now := time.Date(2026, 6, 15, 12, 0, 0, 0, time.UTC)
+	for name, tt := range map[string]struct {
+		rule string
+		data map[string]interface{}
+		want bool
+	}{
+		"greater or equal": {
+			evaluatortest.SeniorRule,
+			map[string]interface{}{"Person": map[string]interface{}{"age": 65.0}},
+			true,
+		},
+		"is in": {
+			`An **Order** gets expedited_shipping if the __total__ of the **Order** is greater than 100 and the __membership_level__ of the **Customer** is in ["gold", "platinum"].`,
+			map[string]interface{}{"Order": map[string]interface{}{"total": 150.0}, "Customer": map[string]interface{}{"membership_level": "gold"}},
+			true,
+		},
+		"is not in": {
+			`A **Customer** gets x if the __country__ of the **Customer** is not in ["NL", "BE"].`,
+			map[string]interface{}{"Customer": map[string]interface{}{"country": "NL"}},
+			false,
+		},
+		"string equality": {
+			`A **User** gets access if the __role__ of the **User** is equal to "admin".`,
+			map[string]interface{}{"User": map[string]interface{}{"role": "admin"}},
+			true,
+		},
+		"and binds tighter than or": {
+			`A **User** gets access if the __a__ of the **User** is equal to true or the __b__ of the **User** is equal to true and the __c__ of the **User** is equal to true.`,
+			map[string]interface{}{"User": map[string]interface{}{"a": true, "b": false, "c": false}},
+			true,
+		},
+		"and of a failed or": {
+			`A **User** gets access if the __a__ of the **User** is equal to true and the __b__ of the **User** is equal to true or the __c__ of the **User** is equal to true.`,
+			map[string]interface{}{"User": map[string]interface{}{"a": true, "b": false, "c": false}},
+			false,
+		},
+		"greater than or equal is one operator": {
+			"A **Person** gets x if the __age__ of the **Person** is greater than or equal to 18 and the __age__ of the **Person** is less than 65.",
+			map[string]interface{}{"Person": map[string]interface{}{"age": 18.0}},
+			true,
+		},
+		"property on both sides": {
+			"An **Order** gets x if the __total__ of the **Order** is greater than the __limit__ of the **Customer**.",
+			map[string]interface{}{"Order": map[string]interface{}{"total": 150.0}, "Customer": map[string]interface{}{"limit": 200.0}},
+			false,
+		},
+		"nested property": {
+			`A **Customer** gets x if the __city__ of the __address__ of the **Customer** is equal to "Paris".`,
+			map[string]interface{}{"Customer": map[string]interface{}{"address": map[string]interface{}{"city": "Paris"}}},
+			true,
+		},
+		"number of": {
+			"An **Order** gets bulk_discount if the number of __items__ of the **Order** is at least 2.",
+			map[string]interface{}{"Order": map[string]interface{}{"items": []interface{}{"a", "b"}}},
+			true,
+		},
+		"contains": {
+			`A **User** gets x if the __roles__ of the **User** contains "admin".`,
+			map[string]interface{}{"User": map[string]interface{}{"roles": []interface{}{"dev", "admin"}}},
+			true,
+		},
+		"older than": {
+			"A **Person** gets x if the __birth_date__ of the **Person** is older than 18 years.",
+			map[string]interface{}{"Person": map[string]interface{}{"birth_date": "2008-06-15"}},
+			true,
+		},
+		"younger than": {
+			"A **Person** gets x if the __birth_date__ of the **Person** is older than 18 years.",
+			map[string]interface{}{"Person": map[string]interface{}{"birth_date": "2008-06-16"}},
+			false,
+		},
+		"later than": {
+			"A **Customer** gets x if the __joined__ of the **Customer.account** is later than 2024-01-01.",
+			map[string]interface{}{"Customer": map[string]interface{}{"account": map[string]interface{}{"joined": "2025-03-01"}}},
+			true,
+		},
+		"case-insensitive property": {
+			evaluatortest.SeniorRule,
+			map[string]interface{}{"Person": map[string]interface{}{"Age": 70.0}},
+			true,
+		},
+		"missing object": {
+			evaluatortest.SeniorRule,
+			map[string]interface{}{"age": 70.0},
+			false,
+		},
+		"reference": {
+			"A **Person** can vote if the **Person** is an adult and the __country__ of the **Person** is equal to \"NL\".\n\n" +
+				"A **Person** is an adult if the __age__ of the **Person** is at least 18.",
+			map[string]interface{}{"Person": map[string]interface{}{"age": 20.0, "country": "NL"}},
+			true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, _, err := decide(tt.rule, tt.data, now)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestDecideLabels tests that labelled rules report their results
+func TestDecideLabels(t *testing.T) {
+	rule := "adult. A **Person** is an adult if the __age__ of the **Person** is at least 18.\n\n" +
+		"A **Person** can vote if §adult is valid and the __country__ of the **Person** is equal to \"NL\"."
+	got, labels, err := decide(rule, map[string]interface{}{"Person": map[string]interface{}{"age": 16.0, "country": "NL"}}, time.Now())
+	require.NoError(t, err)
+	assert.False(t, got)
+	assert.Equal(t, map[string]bool{"adult": false}, labels)
+}
+
+// TestDecideErrors tests the errors the fake answers with
+func TestDecideErrors(t *testing.T) {
+	for name, tt := range map[string]struct {
+		rule string
+		data map[string]interface{}
+		want string
+	}{
+		"invalid": {evaluatortest.InvalidRule, nil, "Parse error: "},
+		"missing property": {
+			evaluatortest.SeniorRule,
+			map[string]interface{}{"Person": map[string]interface{}{}},
+			"Evaluation error: Property 'age' not found in selector 'Person'",
+		},
+		"two global rules": {
+			"A **Person** gets a if the __age__ of the **Person** is at least 18.\n\nA **Person** gets b if the __age__ of the **Person** is at least 21.",
+			nil,
+			"Parse error: Multiple global rules found: 'a', 'b'.",
+		},
+		"ordering a string": {
+			evaluatortest.SeniorRule,
+			map[string]interface{}{"Person": map[string]interface{}{"age": "old"}},
+			"Type error: ",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := decide(tt.rule, tt.data, time.Now())
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
+
+// TestStub tests that a stubbed rule is answered with its response
+func TestStub(t *testing.T) {
+	message := "Evaluation error: down for maintenance"
+	engine := NewEngine(WithStub(evaluatortest.InvalidRule, client.PolicyResponse{Result: true}))
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	c, err := client.New(server.URL)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	response, err := c.Evaluate(ctx, client.PolicyRequest{Rule: evaluatortest.InvalidRule, Data: map[string]interface{}{}})
+	require.NoError(t, err)
+	assert.True(t, response.Result)
+	assert.Equal(t, []string{evaluatortest.InvalidRule}, response.Rule)
+
+	engine.Stub(evaluatortest.SeniorRule, client.PolicyResponse{Error: &message})
+	_, err = c.Evaluate(ctx, client.PolicyRequest{Rule: evaluatortest.SeniorRule, Data: map[string]interface{}{}})
+	var engineErr *client.EngineError
+	require.True(t, errors.As(err, &engineErr))
+	assert.Equal(t, message, engineErr.Message)
+	assert.Equal(t, 400, engineErr.StatusCode)
+}
+
+// TestUnsupported tests that a rule the fake cannot evaluate says so
+func TestUnsupported(t *testing.T) {
+	_, _, err := decide("A **Person** gets x if the **Person** has passed the eye test.", nil, time.Now())
+	assert.ErrorContains(t, err, "not supported by the fake engine")
+}
+
+// TestClock tests that durations are measured against WithClock
+func TestClock(t *testing.T) {
+	rule := "A **Person** gets x if the __birth_date__ of the **Person** is older than 18 years."
+	data := map[string]interface{}{"Person": map[string]interface{}{"birth_date": "2000-01-01"}}
+	server := NewFakeServer(WithClock(func() time.Time { return time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC) }))
+	defer server.Close()
+	c, err := client.New(server.URL)
+	require.NoError(t, err)
+
+	response, err := c.Evaluate(context.Background(), client.PolicyRequest{Rule: rule, Data: data})
+	require.NoError(t, err)
+	assert.False(t, response.Result)
+}
@@ -0,0 +1,145 @@
+package evaluatortest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/policydata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// SeniorRule gives a Person aged 65 or over senior_discount
+	SeniorRule = "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+	// InvalidRule does not parse: "is bigger than" is not a comparison
+	InvalidRule = "A **Person** gets senior_discount if the __age__ of the **Person** is bigger than 65."
+)
+
+// Senior decides SeniorRule the way the engine does, for registering with a
+// Mock
+func Senior(data map[string]interface{}) (bool, error) {
+	value, ok := policydata.Lookup(data, "Person.age")
+	if !ok {
+		return false, errors.New("Property 'age' not found in selector 'Person'")
+	}
+	age, ok := value.(float64)
+	if !ok {
+		return false, fmt.Errorf("Property 'age' of 'Person' is not a number: %v", value)
+	}
+	return age >= 65, nil
+}
+
+// Conformance checks the behaviour every client.Evaluator shares, against
+// one evaluator built with newEvaluator. The evaluator must know SeniorRule,
+// as the engine does; a Mock needs Handle(SeniorRule, Senior).
+func Conformance(t *testing.T, newEvaluator func(t *testing.T) client.Evaluator) {
+	e := newEvaluator(t)
+	ctx := context.Background()
+	person := func(age int) map[string]interface{} {
+		return map[string]interface{}{"Person": map[string]interface{}{"age": age}}
+	}
+
+	t.Run("health", func(t *testing.T) {
+		assert.NoError(t, e.Health(ctx))
+	})
+
+	t.Run("decides", func(t *testing.T) {
+		for age, want := range map[int]bool{70: true, 65: true, 40: false} {
+			response, err := e.Evaluate(ctx, client.PolicyRequest{Rule: SeniorRule, Data: person(age)})
+			require.NoError(t, err, "age %d", age)
+			assert.Equal(t, want, response.Result, "age %d", age)
+			assert.NotEmpty(t, response.Rule)
+		}
+	})
+
+	t.Run("accepts structs", func(t *testing.T) {
+		type person struct {
+			Age int `json:"age"`
+		}
+		response, err := e.Evaluate(ctx, client.PolicyRequest{Rule: SeniorRule, Data: struct{ Person person }{Person: person{Age: 80}}})
+		require.NoError(t, err)
+		assert.True(t, response.Result)
+	})
+
+	t.Run("rejects invalid rules", func(t *testing.T) {
+		_, err := e.Evaluate(ctx, client.PolicyRequest{Rule: InvalidRule, Data: person(70)})
+		var engineErr *client.EngineError
+		require.ErrorAs(t, err, &engineErr)
+		assert.NotEmpty(t, engineErr.Message)
+	})
+
+	t.Run("rejects missing data", func(t *testing.T) {
+		_, err := e.Evaluate(ctx, client.PolicyRequest{Rule: SeniorRule, Data: map[string]interface{}{"Person": map[string]interface{}{}}})
+		var engineErr *client.EngineError
+		assert.ErrorAs(t, err, &engineErr)
+	})
+
+	t.Run("identifies decisions", func(t *testing.T) {
+		first, err := e.Evaluate(ctx, client.PolicyRequest{Rule: SeniorRule, Data: person(70)})
+		require.NoError(t, err)
+		second, err := e.Evaluate(ctx, client.PolicyRequest{Rule: SeniorRule, Data: person(70)})
+		require.NoError(t, err)
+		assert.NotEmpty(t, first.DecisionID)
+		assert.NotEqual(t, first.DecisionID, second.DecisionID)
+
+		id := client.NewDecisionID()
+		given, err := e.Evaluate(client.ContextWithDecisionID(ctx, id), client.PolicyRequest{Rule: SeniorRule, Data: person(70)})
+		require.NoError(t, err)
+		assert.Equal(t, id, given.DecisionID)
+	})
+
+	t.Run("reports no warnings", func(t *testing.T) {
+		response, err := e.Evaluate(ctx, client.PolicyRequest{Rule: SeniorRule, Data: person(70)})
+		require.NoError(t, err)
+		assert.NotNil(t, response.Warnings)
+		assert.Empty(t, response.Warnings)
+	})
+
+	t.Run("honours cancellation", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		response, err := e.Evaluate(cancelled, client.PolicyRequest{Rule: SeniorRule, Data: person(70)})
+		assert.Nil(t, response)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("concurrent use", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(age int) {
+				defer wg.Done()
+				response, err := e.Evaluate(ctx, client.PolicyRequest{Rule: SeniorRule, Data: person(age)})
+				if assert.NoError(t, err, "age %d", age) {
+					assert.Equal(t, age >= 65, response.Result, "age %d", age)
+				}
+			}(50 + i*2)
+		}
+		wg.Wait()
+	})
+
+	t.Run("batches", func(t *testing.T) {
+		results, err := client.EvaluateBatchWith(ctx, e, SeniorRule, []interface{}{person(70), person(40)})
+		require.NoError(t, err)
+		assert.Equal(t, []int{0}, indexes(results, true))
+		assert.Equal(t, []int{1}, indexes(results, false))
+		assert.NotEqual(t, results[0].Response.DecisionID, results[1].Response.DecisionID)
+	})
+}
+
+// indexes lists the items of results whose outcome was want
+func indexes(results client.BatchResults, want bool) []int {
+	var out []int
+	for _, result := range results {
+		if result.Response != nil && result.Response.Result == want {
+			out = append(out, result.Index)
+		}
+	}
+	return out
+}
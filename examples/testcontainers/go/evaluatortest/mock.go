@@ -0,0 +1,160 @@
+// Package evaluatortest provides an in-memory client.Evaluator for tests, and
+// Conformance, the behaviour every Evaluator implementation is checked
+// against.
+package evaluatortest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"policy-engine-testcontainer-example/client"
+)
+
+// Decision answers a rule for one data document, as the engine sees it
+// after JSON encoding, so numbers are float64. An error is reported as an
+// engine evaluation error.
+type Decision func(data map[string]interface{}) (bool, error)
+
+// Mock is an in-memory client.Evaluator that answers each registered rule
+// with its Decision and rejects any other rule as the engine rejects one it
+// cannot parse. It is also an http.Handler serving the engine's API, so an
+// HTTP client can be pointed at it through an httptest.Server. A Mock is safe
+// for concurrent use.
+type Mock struct {
+	mu          sync.Mutex
+	rules       map[string]Decision
+	requests    []client.PolicyRequest
+	decisionIDs []string
+}
+
+// NewMock returns a Mock that knows no rules
+func NewMock() *Mock {
+	return &Mock{rules: map[string]Decision{}}
+}
+
+// Handle registers decide as the answer to rule, replacing any earlier one
+func (m *Mock) Handle(rule string, decide Decision) *Mock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rules[rule] = decide
+	return m
+}
+
+// Requests returns the requests evaluated so far, in arrival order
+func (m *Mock) Requests() []client.PolicyRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]client.PolicyRequest(nil), m.requests...)
+}
+
+// DecisionIDs returns the decision ID of each request Requests returns
+func (m *Mock) DecisionIDs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.decisionIDs...)
+}
+
+// Evaluate answers req from the registered rules. Like PolicyClient, it
+// returns the response alongside an *client.EngineError when the rule is
+// unknown or its Decision fails. The response's decision ID is the one ctx
+// carries, or a new one.
+func (m *Mock) Evaluate(ctx context.Context, req client.PolicyRequest) (*client.PolicyResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	data, err := decode(req.Data)
+	if err != nil {
+		return nil, fmt.Errorf("evaluatortest: invalid data: %w", err)
+	}
+
+	decisionID, ok := client.DecisionIDFromContext(ctx)
+	if !ok {
+		decisionID = client.NewDecisionID()
+	}
+
+	m.mu.Lock()
+	m.requests = append(m.requests, req)
+	m.decisionIDs = append(m.decisionIDs, decisionID)
+	decide, ok := m.rules[req.RuleText()]
+	m.mu.Unlock()
+
+	response := &client.PolicyResponse{Rule: strings.Split(req.RuleText(), "\n"), Data: data, DecisionID: decisionID, Warnings: []client.Warning{}}
+	if !ok {
+		return engineError(response, "parse_error", "Parse error: rule not registered with the mock")
+	}
+	result, err := decide(data)
+	if err != nil {
+		return engineError(response, "evaluation_error", "Evaluation error: "+err.Error())
+	}
+	response.Result = result
+	return response, nil
+}
+
+// decode turns data into what the engine would see: its JSON encoding,
+// decoded, with numbers as float64. Byte slices are raw JSON, as they are to
+// PolicyClient.
+func decode(data interface{}) (map[string]interface{}, error) {
+	if raw, ok := data.([]byte); ok {
+		data = json.RawMessage(raw)
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, err
+	}
+	if decoded == nil {
+		decoded = map[string]interface{}{}
+	}
+	return decoded, nil
+}
+
+func engineError(response *client.PolicyResponse, code, message string) (*client.PolicyResponse, error) {
+	response.Error = &message
+	response.EngineError = &client.EngineError{Code: code, Message: message, StatusCode: http.StatusBadRequest}
+	return response, response.EngineError
+}
+
+// Health always succeeds
+func (m *Mock) Health(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// ServeHTTP answers POST / and GET /health the way the engine does, taking
+// each evaluation's decision ID from its client.DecisionIDHeader
+func (m *Mock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/health" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var req client.PolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	if decisionID := r.Header.Get(client.DecisionIDHeader); decisionID != "" {
+		ctx = client.ContextWithDecisionID(ctx, decisionID)
+	}
+	response, err := m.Evaluate(ctx, req)
+	var engineErr *client.EngineError
+	switch {
+	case errors.As(err, &engineErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(engineErr.StatusCode)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	default:
+		w.Header().Set("Content-Type", "application/json")
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
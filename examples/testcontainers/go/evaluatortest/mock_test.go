@@ -0,0 +1,71 @@
+package evaluatortest
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"policy-engine-testcontainer-example/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMockConformance tests that the Mock behaves like every other Evaluator
+func TestMockConformance(t *testing.T) {
+	Conformance(t, func(t *testing.T) client.Evaluator {
+		return NewMock().Handle(SeniorRule, Senior)
+	})
+}
+
+// TestClientConformance tests the HTTP client against the Mock's engine API
+func TestClientConformance(t *testing.T) {
+	Conformance(t, func(t *testing.T) client.Evaluator {
+		server := httptest.NewServer(NewMock().Handle(SeniorRule, Senior))
+		t.Cleanup(server.Close)
+		c, err := client.New(server.URL)
+		require.NoError(t, err)
+		return c
+	})
+}
+
+// TestMockRequests tests that the Mock records what it was asked
+func TestMockRequests(t *testing.T) {
+	mock := NewMock().Handle("rule", func(data map[string]interface{}) (bool, error) {
+		return data["ok"] == true, nil
+	})
+
+	response, err := mock.Evaluate(context.Background(), client.PolicyRequest{Rule: "rule", Data: map[string]interface{}{"ok": true}})
+	require.NoError(t, err)
+	assert.True(t, response.Result)
+	assert.Equal(t, []string{"rule"}, response.Rule)
+	assert.Equal(t, map[string]interface{}{"ok": true}, response.Data)
+
+	_, err = mock.Evaluate(context.Background(), client.PolicyRequest{Rule: "other"})
+	var engineErr *client.EngineError
+	require.ErrorAs(t, err, &engineErr)
+	assert.Equal(t, "parse_error", engineErr.Code)
+
+	require.Len(t, mock.Requests(), 2)
+	assert.Equal(t, "other", mock.Requests()[1].Rule)
+}
+
+// TestDecisionIDEndToEnd tests that one decision ID names an evaluation in the
+// client's request, the engine and the client's response or error
+func TestDecisionIDEndToEnd(t *testing.T) {
+	mock := NewMock().Handle(SeniorRule, Senior)
+	server := httptest.NewServer(mock)
+	defer server.Close()
+	c, err := client.New(server.URL)
+	require.NoError(t, err)
+
+	response, err := c.Evaluate(context.Background(), client.PolicyRequest{Rule: SeniorRule, Data: map[string]interface{}{"Person": map[string]interface{}{"age": 70}}})
+	require.NoError(t, err)
+	_, err = c.Evaluate(context.Background(), client.PolicyRequest{Rule: InvalidRule})
+	require.Error(t, err)
+	failed, ok := client.DecisionIDOf(err)
+	require.True(t, ok)
+
+	assert.Equal(t, []string{response.DecisionID, failed}, mock.DecisionIDs())
+	assert.NotEqual(t, response.DecisionID, failed)
+}
@@ -2,15 +2,41 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
-	"encoding/json"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"policy-engine-testcontainer-example/analysis"
+	"policy-engine-testcontainer-example/assertpolicy"
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/enginelog"
+	"policy-engine-testcontainer-example/enginetest"
+	"policy-engine-testcontainer-example/evaluatortest"
+	"policy-engine-testcontainer-example/policybench"
+	"policy-engine-testcontainer-example/policydata"
+	"policy-engine-testcontainer-example/rulebuilder"
+	"policy-engine-testcontainer-example/scenario"
+	"policy-engine-testcontainer-example/selectors"
+	"policy-engine-testcontainer-example/simulate"
+
+	"github.com/docker/go-connections/nat"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 )
@@ -18,51 +44,433 @@ import (
 // PolicyEngineContainer wraps the testcontainer for the Policy Engine
 type PolicyEngineContainer struct {
 	testcontainers.Container
+	*client.PolicyClient
 	BaseURL string
+
+	logs *logCollector
+	// proxy fronts the engine under WithAuthProxy, wanting authToken, and
+	// WithTLS; probeClient is how WaitForReady reaches it
+	proxy         *httptest.Server
+	authToken     string
+	probeClient   *http.Client
+	terminateOnce sync.Once
+	terminateErr  error
 }
 
-// PolicyRequest represents the request payload for policy evaluation
-type PolicyRequest struct {
-	Rule  string      `json:"rule"`
-	Data  interface{} `json:"data"`
-	Trace bool        `json:"trace,omitempty"`
+// logCollector keeps everything the container writes, as a
+// testcontainers.LogConsumer
+type logCollector struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
 }
 
-// PolicyResponse represents the response from policy evaluation
-type PolicyResponse struct {
-	Result bool                   `json:"result"`
-	Error  *string                `json:"error,omitempty"`
-	Trace  map[string]interface{} `json:"trace,omitempty"`
-	Labels map[string]bool        `json:"labels,omitempty"`
-	Rule   []string               `json:"rule"`
-	Data   interface{}            `json:"data"`
+func (l *logCollector) Accept(log testcontainers.Log) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf.Write(log.Content)
 }
 
-// setupPolicyEngine creates and starts a Policy Engine testcontainer
-func setupPolicyEngine(ctx context.Context) (*PolicyEngineContainer, error) {
-	req := testcontainers.ContainerRequest{
-		Image:        "policy-engine:latest",
-		ExposedPorts: []string{"3000/tcp"},
-		Env: map[string]string{
+func (l *logCollector) snapshot() []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return bytes.Clone(l.buf.Bytes())
+}
+
+// lines is what has been collected, line by line
+func (l *logCollector) lines() []string {
+	text := strings.TrimRight(string(l.snapshot()), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// cleanupTimeout bounds terminating a container, so a wedged Docker daemon
+// can't hang the test binary
+var cleanupTimeout = 30 * time.Second
+
+// containerStarter starts a container like testcontainers.GenericContainer,
+// which tests replace with a fake
+type containerStarter func(context.Context, testcontainers.GenericContainerRequest) (testcontainers.Container, error)
+
+// SetupOption configures NewPolicyEngineContainer
+type SetupOption func(*setupConfig)
+
+type setupConfig struct {
+	image          string
+	env            map[string]string
+	port           string
+	startupTimeout time.Duration
+	selfTest       *client.SelfTestSuite
+	// clientConfig is the config file's client settings, and configErr why
+	// the file could not be used
+	clientConfig *client.Config
+	configErr    error
+	logConsumers []testcontainers.LogConsumer
+	probe        []ProbeOption
+	authToken    string
+	// tlsCert and tlsKey are WithTLS's PEM certificate and key
+	tlsCert, tlsKey []byte
+}
+
+// defaultSetup is the engine image, environment and port setup uses unless
+// told otherwise
+func defaultSetup() setupConfig {
+	return setupConfig{
+		image: "policy-engine:latest",
+		env: map[string]string{
 			"FF_ENV_ID":     "test-env",
 			"FF_AGENT_ID":   "test-agent",
 			"FF_PROJECT_ID": "test-project",
 		},
-		WaitingFor: wait.ForHTTP("/health").
-			WithPort("3000/tcp").
-			WithStartupTimeout(60 * time.Second),
+		port:           "3000/tcp",
+		startupTimeout: 60 * time.Second,
+	}
+}
+
+// WithImage runs image, e.g. a pinned "policy-engine:1.4.2", instead of
+// policy-engine:latest
+func WithImage(image string) SetupOption {
+	return func(c *setupConfig) {
+		c.image = image
+	}
+}
+
+// WithEnv adds env to the container's environment, replacing any default
+// of the same name. Several WithEnv options add up.
+func WithEnv(env map[string]string) SetupOption {
+	return func(c *setupConfig) {
+		for name, value := range env {
+			c.env[name] = value
+		}
+	}
+}
+
+// WithExposedPort sets the port the engine listens on inside the container,
+// e.g. "8080/tcp"; the default is 3000/tcp
+func WithExposedPort(port string) SetupOption {
+	return func(c *setupConfig) {
+		c.port = port
+	}
+}
+
+// WithStartupTimeout bounds how long the engine has to become healthy; the
+// default is 60 seconds
+func WithStartupTimeout(timeout time.Duration) SetupOption {
+	return func(c *setupConfig) {
+		c.startupTimeout = timeout
+	}
+}
+
+// WithSelfTest runs suite against the engine once it is healthy, failing
+// setup unless every case passes
+func WithSelfTest(suite client.SelfTestSuite) SetupOption {
+	return func(c *setupConfig) {
+		c.selfTest = &suite
+	}
+}
+
+// WithReadinessProbe configures how setup waits for the engine once its
+// port is mapped, see WaitForReady; options add up
+func WithReadinessProbe(opts ...ProbeOption) SetupOption {
+	return func(c *setupConfig) {
+		c.probe = append(c.probe, opts...)
+	}
+}
+
+// logPrefix starts each line of the engine's output printed into test output
+const logPrefix = "[policy-engine] "
+
+// WithLogConsumer buffers the engine's output for t, each line prefixed with
+// [policy-engine], and prints it when t ends, only if t has failed. Output is
+// followed from before the health check, so an engine that never became
+// healthy shows why.
+func WithLogConsumer(t testing.TB) SetupOption {
+	return withLogConsumer(t)
+}
+
+func withLogConsumer(t testingT) SetupOption {
+	return func(c *setupConfig) {
+		buffer := &logCollector{}
+		c.logConsumers = append(c.logConsumers, buffer)
+		t.Cleanup(func() {
+			lines := buffer.lines()
+			if !t.Failed() || len(lines) == 0 {
+				return
+			}
+			for i, line := range lines {
+				lines[i] = logPrefix + line
+			}
+			t.Logf("policy engine output:\n%s", strings.Join(lines, "\n"))
+		})
+	}
+}
+
+// WithAuthProxy puts the engine behind a proxy that answers 401 to any
+// request without token as its bearer token, as the hosted engine's auth
+// proxy does. The engine image has no authentication of its own to switch
+// on, so the proxy runs in the test process; BaseURL is the proxy's, and the
+// container's client and readiness probe send the token.
+func WithAuthProxy(token string) SetupOption {
+	return func(c *setupConfig) {
+		c.authToken = token
+	}
+}
+
+// WithTLS serves the engine over HTTPS with the PEM certificate and key,
+// such as those of enginetest.SelfSignedCert. The engine image only speaks
+// plain HTTP, so TLS is terminated by a proxy in the test process, as it is
+// in front of the hosted engine; BaseURL is the proxy's https address, and
+// the container's client trusts certPEM as its only authority.
+func WithTLS(certPEM, keyPEM []byte) SetupOption {
+	return func(c *setupConfig) {
+		c.tlsCert, c.tlsKey = certPEM, keyPEM
+	}
+}
+
+// WithConfigFile reads the config file at path, see client.ConfigFromFile.
+// Its container section sets the image, environment, port and startup
+// timeout, and the rest configures the client, apart from its base URL,
+// which is the container's. Options apply in order, so one given after
+// WithConfigFile overrides the file and one given before it is overridden;
+// WithEnv adds to the file's environment either way.
+func WithConfigFile(path string) SetupOption {
+	return func(c *setupConfig) {
+		cfg, err := client.ConfigFromFile(path)
+		if err != nil {
+			c.configErr = err
+			return
+		}
+		if container := cfg.Container; container != nil {
+			if container.Image != "" {
+				c.image = container.Image
+			}
+			for name, value := range container.Env {
+				c.env[name] = value
+			}
+			if container.Port != "" {
+				c.port = container.Port
+			}
+			if container.StartupTimeout > 0 {
+				c.startupTimeout = container.StartupTimeout
+			}
+		}
+		c.clientConfig = &cfg
+	}
+}
+
+// NewPolicyEngineContainer creates and starts a Policy Engine
+// testcontainer. Options are validated before Docker is asked for anything.
+func NewPolicyEngineContainer(ctx context.Context, opts ...SetupOption) (*PolicyEngineContainer, error) {
+	return startPolicyEngine(ctx, testcontainers.GenericContainer, opts...)
+}
+
+// setupPolicyEngine is NewPolicyEngineContainer, under the name the tests
+// have always used
+func setupPolicyEngine(ctx context.Context, opts ...SetupOption) (*PolicyEngineContainer, error) {
+	return NewPolicyEngineContainer(ctx, opts...)
+}
+
+// failureLogLines is how much of the engine's output SetupPolicyEngineT
+// prints for a failed test
+const failureLogLines = 50
+
+// testingT is the part of testing.TB SetupPolicyEngineT uses
+type testingT interface {
+	Helper()
+	Cleanup(func())
+	Failed() bool
+	Logf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+// SetupPolicyEngineT starts a Policy Engine container for t, failing t if it
+// cannot, and terminates it when t and its subtests are done. A container
+// that started but failed a later setup step, such as mapping its port, is
+// terminated before t fails. When t has failed, the last lines the engine
+// wrote are logged before the container goes.
+func SetupPolicyEngineT(t testing.TB, opts ...SetupOption) *PolicyEngineContainer {
+	t.Helper()
+	return setupPolicyEngineT(t, testcontainers.GenericContainer, opts...)
+}
+
+func setupPolicyEngineT(t testingT, start containerStarter, opts ...SetupOption) *PolicyEngineContainer {
+	t.Helper()
+	pe, err := startPolicyEngine(context.Background(), start, opts...)
+	if err != nil {
+		t.Fatalf("failed to set up policy engine: %v", err)
+		return nil
+	}
+	t.Cleanup(func() {
+		if t.Failed() {
+			if lines := pe.logTail(failureLogLines); len(lines) > 0 {
+				t.Logf("last %d lines of policy engine output:\n%s", len(lines), strings.Join(lines, "\n"))
+			}
+		}
+		if err := pe.Terminate(context.Background()); err != nil {
+			t.Logf("failed to terminate container: %v", err)
+		}
+	})
+	return pe
+}
+
+// sharedHealthTimeout bounds the health check SharedPolicyEngine makes before
+// handing the shared container out
+const sharedHealthTimeout = 5 * time.Second
+
+// sharedEngine is the container SharedPolicyEngine hands out
+var sharedEngine = &enginePool{}
+
+// SharedPolicyEngine returns the container shared by every test in the
+// package, starting it with opts on the first call; later calls get the same
+// container whatever options they pass. It is checked healthy before it is
+// returned, and replaced with a new one if it is not. It is safe to call from
+// parallel tests. Tests must not terminate it: TestMain does, through
+// TerminateSharedPolicyEngine, once every test has run.
+func SharedPolicyEngine(ctx context.Context, opts ...SetupOption) (*PolicyEngineContainer, error) {
+	return sharedEngine.get(ctx, func(ctx context.Context) (*PolicyEngineContainer, error) {
+		return NewPolicyEngineContainer(ctx, opts...)
+	})
+}
+
+// TerminateSharedPolicyEngine terminates the shared container, if one was
+// started; a later SharedPolicyEngine starts another
+func TerminateSharedPolicyEngine() error {
+	return sharedEngine.close()
+}
+
+// enginePool holds one container, started on first use and restarted when it
+// stops answering its health check
+type enginePool struct {
+	mu     sync.Mutex
+	pe     *PolicyEngineContainer
+	starts int
+}
+
+func (p *enginePool) get(ctx context.Context, start func(context.Context) (*PolicyEngineContainer, error)) (*PolicyEngineContainer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pe != nil {
+		healthCtx, cancel := context.WithTimeout(ctx, sharedHealthTimeout)
+		err := p.pe.HealthCheck(healthCtx)
+		cancel()
+		if err == nil {
+			return p.pe, nil
+		}
+		// A container that stopped answering is replaced; whatever went
+		// wrong with it, there is nothing left to hand out
+		_ = p.pe.Terminate(ctx)
+		p.pe = nil
+	}
+
+	pe, err := start(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start shared policy engine: %w", err)
+	}
+	p.pe = pe
+	p.starts++
+	return pe, nil
+}
+
+func (p *enginePool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pe := p.pe
+	p.pe = nil
+	return pe.Terminate(context.Background())
+}
+
+// TestMain terminates the shared container once the package's tests are done
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if err := TerminateSharedPolicyEngine(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to terminate shared policy engine: %v\n", err)
+	}
+	os.Exit(code)
+}
+
+// validate reports the first option that cannot start a container
+func (c *setupConfig) validate() (nat.Port, error) {
+	if c.configErr != nil {
+		return "", fmt.Errorf("invalid setup: %w", c.configErr)
+	}
+	if c.clientConfig != nil {
+		// The base URL is only known once the container is up
+		cfg := *c.clientConfig
+		cfg.BaseURL = "http://localhost"
+		if err := cfg.Validate(); err != nil {
+			return "", fmt.Errorf("invalid setup: %w", err)
+		}
+	}
+	if strings.TrimSpace(c.image) == "" {
+		return "", errors.New("invalid setup: empty image")
+	}
+	if c.tlsCert != nil || c.tlsKey != nil {
+		if _, err := tls.X509KeyPair(c.tlsCert, c.tlsKey); err != nil {
+			return "", fmt.Errorf("invalid setup: TLS certificate: %w", err)
+		}
+	}
+	proto, port := nat.SplitProtoPort(c.port)
+	if _, err := nat.ParsePort(port); err != nil || port == "" {
+		return "", fmt.Errorf("invalid setup: exposed port %q is not a port", c.port)
+	}
+	if c.startupTimeout <= 0 {
+		return "", fmt.Errorf("invalid setup: startup timeout %s is not positive", c.startupTimeout)
+	}
+	return nat.NewPort(proto, port)
+}
+
+// startPolicyEngine is NewPolicyEngineContainer with the starter given. Once
+// a container exists, any failure terminates it before returning, with the
+// termination error joined to the setup error.
+func startPolicyEngine(ctx context.Context, start containerStarter, opts ...SetupOption) (_ *PolicyEngineContainer, err error) {
+	cfg := defaultSetup()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	port, err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	logs := &logCollector{}
+	// Output is followed from before the health check, so an engine that
+	// never becomes healthy has said why
+	following := &followingStrategy{
+		next: wait.ForHTTP("/health").
+			WithPort(port).
+			WithStartupTimeout(cfg.startupTimeout),
+		consumers: append([]testcontainers.LogConsumer{logs}, cfg.logConsumers...),
+	}
+	req := testcontainers.ContainerRequest{
+		Image:        cfg.image,
+		ExposedPorts: []string{string(port)},
+		Env:          cfg.env,
+		WaitingFor:   following,
 	}
 
-	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+	// A container can come back alongside an error, e.g. when it was created
+	// but never became healthy, and must be terminated all the same
+	container, err := start(ctx, testcontainers.GenericContainerRequest{
 		ContainerRequest: req,
 		Started:          true,
 	})
+	pe := &PolicyEngineContainer{Container: container, logs: logs}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, pe.Close())
+		}
+	}()
 	if err != nil {
 		return nil, fmt.Errorf("failed to start policy engine container: %w", err)
 	}
+	// A container started without the wait strategy is followed from here
+	if err := following.follow(ctx, container); err != nil {
+		return nil, err
+	}
 
 	// Get the mapped port
-	mappedPort, err := container.MappedPort(ctx, "3000")
+	mappedPort, err := container.MappedPort(ctx, port)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get mapped port: %w", err)
 	}
@@ -74,199 +482,1722 @@ func setupPolicyEngine(ctx context.Context) (*PolicyEngineContainer, error) {
 	}
 
 	baseURL := fmt.Sprintf("http://%s:%s", host, mappedPort.Port())
-
-	return &PolicyEngineContainer{
-		Container: container,
-		BaseURL:   baseURL,
-	}, nil
-}
-
-// EvaluatePolicy sends a policy evaluation request to the container
-func (pe *PolicyEngineContainer) EvaluatePolicy(ctx context.Context, rule string, data interface{}, trace bool) (*PolicyResponse, error) {
-	request := PolicyRequest{
-		Rule:  rule,
-		Data:  data,
-		Trace: trace,
+	clientOpts := []client.Option{client.WithStrictDecodingFatal()}
+	if cfg.authToken != "" || cfg.tlsCert != nil {
+		if pe.proxy, err = newEngineProxy(baseURL, cfg); err != nil {
+			return nil, err
+		}
+		baseURL = pe.proxy.URL
 	}
-
-	requestBody, err := json.Marshal(request)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	if cfg.authToken != "" {
+		pe.authToken = cfg.authToken
+		clientOpts = append(clientOpts, client.WithAuthToken(cfg.authToken))
 	}
-
-	resp, err := http.Post(pe.BaseURL, "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+	if cfg.tlsCert != nil {
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(cfg.tlsCert)
+		trusting := &tls.Config{RootCAs: roots}
+		pe.probeClient = &http.Client{Transport: &http.Transport{TLSClientConfig: trusting}}
+		clientOpts = append(clientOpts, client.WithTLSConfig(trusting))
 	}
-	defer resp.Body.Close()
 
-	responseBody, err := io.ReadAll(resp.Body)
+	// The tests double as the client's contract with the engine, so any skew
+	// between the response schema and the client fails them
+	var policyClient *client.PolicyClient
+	if cfg.clientConfig != nil {
+		clientConfig := *cfg.clientConfig
+		clientConfig.BaseURL = baseURL
+		policyClient, err = client.FromConfig(clientConfig, clientOpts...)
+	} else {
+		policyClient, err = client.New(baseURL, clientOpts...)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, fmt.Errorf("failed to create policy client: %w", err)
 	}
 
-	var policyResponse PolicyResponse
-	if err := json.Unmarshal(responseBody, &policyResponse); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	pe.PolicyClient = policyClient
+	pe.BaseURL = baseURL
+
+	if err := pe.WaitForReady(ctx, cfg.probe...); err != nil {
+		return nil, fmt.Errorf("failed to wait for policy engine: %w", err)
 	}
 
-	return &policyResponse, nil
+	if cfg.selfTest != nil {
+		if _, err := policyClient.SelfTest(ctx, *cfg.selfTest); err != nil {
+			return nil, fmt.Errorf("policy engine failed its self-test: %w", err)
+		}
+	}
+	return pe, nil
 }
 
-// HealthCheck verifies the container is healthy
-func (pe *PolicyEngineContainer) HealthCheck(ctx context.Context) error {
-	resp, err := http.Get(pe.BaseURL + "/health")
+// newEngineProxy serves target, to requests bearing cfg's auth token if it
+// has one, over TLS with cfg's certificate if it has one
+func newEngineProxy(target string, cfg setupConfig) (*httptest.Server, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start engine proxy: %w", err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.authToken != "" && r.Header.Get("Authorization") != "Bearer "+cfg.authToken {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="policy-engine"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	if cfg.tlsCert == nil {
+		server.Start()
+		return server, nil
+	}
+	cert, err := tls.X509KeyPair(cfg.tlsCert, cfg.tlsKey)
 	if err != nil {
-		return fmt.Errorf("health check failed: %w", err)
+		return nil, fmt.Errorf("failed to start engine proxy: %w", err)
 	}
-	defer resp.Body.Close()
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	return server, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+// followingStrategy follows a container's output with its consumers, then
+// waits for it with next
+type followingStrategy struct {
+	next      wait.Strategy
+	consumers []testcontainers.LogConsumer
+	following bool
+}
+
+func (s *followingStrategy) WaitUntilReady(ctx context.Context, target wait.StrategyTarget) error {
+	if container, ok := target.(testcontainers.Container); ok {
+		if err := s.follow(ctx, container); err != nil {
+			return err
+		}
 	}
+	return s.next.WaitUntilReady(ctx, target)
+}
 
+// follow starts following container's output, once
+func (s *followingStrategy) follow(ctx context.Context, container testcontainers.Container) error {
+	if s.following {
+		return nil
+	}
+	s.following = true
+	for _, consumer := range s.consumers {
+		container.FollowOutput(consumer)
+	}
+	if err := container.StartLogProducer(ctx); err != nil {
+		return fmt.Errorf("failed to follow container logs: %w", err)
+	}
 	return nil
 }
 
-// TestPolicyEngineConnection tests basic connectivity to the Policy Engine
-func TestPolicyEngineConnection(t *testing.T) {
-	ctx := context.Background()
-
-	// Start Policy Engine container
-	pe, err := setupPolicyEngine(ctx)
-	assert.NoError(t, err)
-	defer func() {
-		if pe != nil {
-			if err := pe.Terminate(ctx); err != nil {
-				t.Logf("failed to terminate container: %v", err)
-			}
+// Terminate closes the client and removes the container, giving up after
+// cleanupTimeout. Only the first call does anything, and it is safe on a nil
+// or partly set up wrapper.
+func (pe *PolicyEngineContainer) Terminate(ctx context.Context) error {
+	if pe == nil {
+		return nil
+	}
+	pe.terminateOnce.Do(func() {
+		if pe.PolicyClient != nil {
+			_ = pe.PolicyClient.Close()
 		}
-	}()
-	assert.NotNil(t, pe)
+		if pe.proxy != nil {
+			pe.proxy.Close()
+		}
+		if pe.Container == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, cleanupTimeout)
+		defer cancel()
+		if err := pe.Container.Terminate(ctx); err != nil {
+			pe.terminateErr = fmt.Errorf("failed to terminate container: %w", err)
+		}
+	})
+	return pe.terminateErr
+}
 
-	// Verify health check
-	err = pe.HealthCheck(ctx)
-	assert.NoError(t, err)
+// Close is Terminate without a caller's context
+func (pe *PolicyEngineContainer) Close() error {
+	return pe.Terminate(context.Background())
+}
 
-	// Test basic policy evaluation
-	data := map[string]interface{}{
-		"age": 70,
+// Logs parses what the engine has written so far. Lines arrive shortly after
+// they are written, so a test looking for one should poll.
+func (pe *PolicyEngineContainer) Logs(ctx context.Context) ([]enginelog.Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if pe.logs == nil {
+		return nil, nil
 	}
+	return enginelog.Parse(bytes.NewReader(pe.logs.snapshot()))
+}
 
-	rule := "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+// logTail is the last n lines the engine has written
+func (pe *PolicyEngineContainer) logTail(n int) []string {
+	if pe.logs == nil {
+		return nil
+	}
+	lines := pe.logs.lines()
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
 
-	response, err := pe.EvaluatePolicy(ctx, rule, data, true)
-	assert.NoError(t, err)
-	assert.NotNil(t, response)
+// HealthCheck verifies the container is healthy
+func (pe *PolicyEngineContainer) HealthCheck(ctx context.Context) error {
+	return pe.PolicyClient.Health(ctx)
+}
 
-	// The result should be false because the data structure doesn't match exactly
-	// (we need "Person": {"age": 70} for this rule to work)
-	t.Logf("Policy evaluation result: %+v", response)
+// ProbeOption configures WaitForReady
+type ProbeOption func(*probeConfig)
+
+type probeConfig struct {
+	interval time.Duration
+	timeout  time.Duration
+	warmUp   *client.PolicyRequest
 }
 
-// TestSeniorDiscountPolicy tests the senior discount policy with proper data structure
-func TestSeniorDiscountPolicy(t *testing.T) {
-	ctx := context.Background()
+// defaultProbe polls every 100 milliseconds for up to 10 seconds, with no
+// warm-up evaluation
+func defaultProbe() probeConfig {
+	return probeConfig{interval: 100 * time.Millisecond, timeout: 10 * time.Second}
+}
 
-	pe, err := setupPolicyEngine(ctx)
-	assert.NoError(t, err)
-	defer func() {
-		if pe != nil {
-			if err := pe.Terminate(ctx); err != nil {
-				t.Logf("failed to terminate container: %v", err)
-			}
-		}
-	}()
-	assert.NotNil(t, pe)
+// WithProbeInterval sets how long WaitForReady waits between attempts; the
+// default is 100 milliseconds
+func WithProbeInterval(interval time.Duration) ProbeOption {
+	return func(c *probeConfig) {
+		c.interval = interval
+	}
+}
 
-	// Test senior gets discount
-	data := map[string]interface{}{
-		"age": 70,
+// WithProbeTimeout bounds how long WaitForReady keeps trying; the default is
+// 10 seconds
+func WithProbeTimeout(timeout time.Duration) ProbeOption {
+	return func(c *probeConfig) {
+		c.timeout = timeout
 	}
+}
 
-	rule := "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+// WithWarmUpRule has WaitForReady evaluate rule against data once the engine
+// is healthy, so the engine is only ready once its evaluator answers too.
+// Any answer without an error will do.
+func WithWarmUpRule(rule string, data interface{}) ProbeOption {
+	return func(c *probeConfig) {
+		c.warmUp = &client.PolicyRequest{Rule: rule, Data: data}
+	}
+}
 
-	response, err := pe.EvaluatePolicy(ctx, rule, data, false)
-	assert.NoError(t, err)
-	assert.NotNil(t, response)
+// ReadinessError is an engine WaitForReady gave up on, with what its last
+// attempt saw
+type ReadinessError struct {
+	Elapsed  time.Duration
+	Attempts int
+	// StatusCode and Body are the last health check's response, when there
+	// was one
+	StatusCode int
+	Body       string
+	// Err is why the last attempt failed
+	Err error
+}
 
-	t.Logf("Senior discount policy result: %+v", response)
+func (e *ReadinessError) Error() string {
+	msg := fmt.Sprintf("policy engine not ready after %s (%d attempts)", e.Elapsed.Round(time.Millisecond), e.Attempts)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(": last status %d", e.StatusCode)
+		if e.Body != "" {
+			msg += fmt.Sprintf(" %q", e.Body)
+		}
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
 }
 
-// TestExpeditedShippingPolicy tests a more complex policy with nested data
-func TestExpeditedShippingPolicy(t *testing.T) {
-	ctx := context.Background()
+func (e *ReadinessError) Unwrap() error {
+	return e.Err
+}
 
-	pe, err := setupPolicyEngine(ctx)
-	assert.NoError(t, err)
-	defer func() {
-		if pe != nil {
-			if err := pe.Terminate(ctx); err != nil {
-				t.Logf("failed to terminate container: %v", err)
+// maxProbeBody is as much of a health check's body as a ReadinessError keeps
+const maxProbeBody = 512
+
+// WaitForReady polls the engine's health check until it answers 200 and,
+// with WithWarmUpRule, the warm-up evaluation succeeds. A restarted or loaded
+// engine can briefly answer 503 after the wait strategy passed; setup waits
+// for it with the defaults. On timeout it returns a *ReadinessError holding
+// the last status and body.
+func (pe *PolicyEngineContainer) WaitForReady(ctx context.Context, opts ...ProbeOption) error {
+	cfg := defaultProbe()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.interval <= 0 || cfg.timeout <= 0 {
+		return fmt.Errorf("invalid probe: interval %s and timeout %s must be positive", cfg.interval, cfg.timeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+
+	started := time.Now()
+	ticker := time.NewTicker(cfg.interval)
+	defer ticker.Stop()
+	last := &ReadinessError{}
+	for {
+		last.Attempts++
+		status, body, err := pe.probe(ctx, cfg)
+		if err == nil {
+			return nil
+		}
+		// An attempt cut short by the deadline says less than the one before
+		if ctx.Err() == nil || last.Err == nil {
+			last.StatusCode, last.Body, last.Err = status, body, err
+		}
+		select {
+		case <-ctx.Done():
+			last.Elapsed = time.Since(started)
+			if !errors.Is(last.Err, ctx.Err()) {
+				last.Err = errors.Join(last.Err, ctx.Err())
 			}
+			return last
+		case <-ticker.C:
 		}
-	}()
-	assert.NotNil(t, pe)
+	}
+}
 
-	// Test expedited shipping policy
-	data := map[string]interface{}{
-		"total": 150.0,
-		"Customer": map[string]interface{}{
-			"membership_level": "gold",
-		},
+// probe makes one attempt, returning the health check's status and body
+func (pe *PolicyEngineContainer) probe(ctx context.Context, cfg probeConfig) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pe.BaseURL+"/health", nil)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to build health request: %w", err)
+	}
+	if pe.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+pe.authToken)
+	}
+	probeClient := pe.probeClient
+	if probeClient == nil {
+		probeClient = http.DefaultClient
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("health check failed: %w", err)
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+	resp.Body.Close()
+	body := strings.TrimSpace(string(raw))
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, body, fmt.Errorf("health check returned status %d", resp.StatusCode)
 	}
 
-	rule := `An **Order** gets expedited_shipping if the __total__ of the **Order** is greater than 100 and the __membership_level__ of the **Customer** is in ["gold", "platinum"].`
+	if cfg.warmUp == nil {
+		return resp.StatusCode, body, nil
+	}
+	if _, err := pe.PolicyClient.Evaluate(ctx, *cfg.warmUp); err != nil {
+		return resp.StatusCode, body, fmt.Errorf("warm-up evaluation failed: %w", err)
+	}
+	return resp.StatusCode, body, nil
+}
 
-	response, err := pe.EvaluatePolicy(ctx, rule, data, true)
-	assert.NoError(t, err)
-	assert.NotNil(t, response)
+// fakeContainer stands in for a started container, failing where it is told
+// to and counting terminations
+type fakeContainer struct {
+	testcontainers.Container
+	portErr, hostErr error
+	logErr           error
+	host             string
+	terminateErr     error
+	// wedged makes Terminate wait out its context, like a stuck daemon
+	wedged       bool
+	terminations int
+	// mapped is the port last asked for, and port the one answered, by
+	// default 49153/tcp
+	mapped nat.Port
+	port   nat.Port
+	// consumers are what FollowOutput was given, and producing whether
+	// StartLogProducer was called
+	consumers []testcontainers.LogConsumer
+	producing bool
+}
 
-	t.Logf("Expedited shipping policy result: %+v", response)
+func (f *fakeContainer) MappedPort(_ context.Context, port nat.Port) (nat.Port, error) {
+	f.mapped = port
+	if f.port != "" {
+		return f.port, f.portErr
+	}
+	return "49153/tcp", f.portErr
 }
 
-// TestMultiplePolicies demonstrates testing multiple policies in sequence
-func TestMultiplePolicies(t *testing.T) {
-	ctx := context.Background()
+func (f *fakeContainer) Host(context.Context) (string, error) {
+	return f.host, f.hostErr
+}
 
-	pe, err := setupPolicyEngine(ctx)
-	assert.NoError(t, err)
-	defer func() {
-		if pe != nil {
-			if err := pe.Terminate(ctx); err != nil {
-				t.Logf("failed to terminate container: %v", err)
-			}
-		}
-	}()
-	assert.NotNil(t, pe)
+func (f *fakeContainer) FollowOutput(consumer testcontainers.LogConsumer) {
+	f.consumers = append(f.consumers, consumer)
+}
 
-	testCases := []struct {
-		name string
-		rule string
-		data interface{}
-		want bool
-	}{
-		{
-			name: "Young person no discount",
-			rule: "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65.",
-			data: map[string]interface{}{"age": 30},
-			want: false,
-		},
-		{
-			name: "Access granted for admin",
-			rule: "A **User** gets access if the __role__ of the **User** is equal to \"admin\".",
-			data: map[string]interface{}{"role": "admin"},
-			want: false, // Will be false due to data structure mismatch, but test runs
-		},
+func (f *fakeContainer) StartLogProducer(context.Context) error {
+	f.producing = true
+	return f.logErr
+}
+
+// log hands line to the container's consumers, as its log producer would
+func (f *fakeContainer) log(line string) {
+	for _, consumer := range f.consumers {
+		consumer.Accept(testcontainers.Log{LogType: testcontainers.StdoutLog, Content: []byte(line + "\n")})
 	}
+}
 
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			response, err := pe.EvaluatePolicy(ctx, tc.rule, tc.data, false)
+// readyContainer is a fakeContainer whose mapped port is a fake engine's, so
+// setup finds it ready
+func readyContainer(t *testing.T) *fakeContainer {
+	server := enginetest.NewFakeServer()
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	return &fakeContainer{host: u.Hostname(), port: nat.Port(u.Port() + "/tcp")}
+}
+
+func (f *fakeContainer) Terminate(ctx context.Context) error {
+	f.terminations++
+	if f.wedged {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return f.terminateErr
+}
+
+// testEngine is an engine a test runs against: the container, or the fake
+type testEngine struct {
+	*client.PolicyClient
+	BaseURL string
+}
+
+// forEachEngine runs test against the in-process fake engine, then against
+// the shared container, so the assertions that hold for one hold for the
+// other. The fake needs no Docker; run only it with -run 'TestName/fake'.
+func forEachEngine(t *testing.T, test func(t *testing.T, pe *testEngine)) {
+	t.Run("fake", func(t *testing.T) {
+		server := enginetest.NewFakeServer()
+		defer server.Close()
+		policyClient, err := client.New(server.URL)
+		require.NoError(t, err)
+		test(t, &testEngine{PolicyClient: policyClient, BaseURL: server.URL})
+	})
+	t.Run("container", func(t *testing.T) {
+		pe, err := SharedPolicyEngine(context.Background())
+		require.NoError(t, err)
+		test(t, &testEngine{PolicyClient: pe.PolicyClient, BaseURL: pe.BaseURL})
+	})
+}
+
+// TestSetupCleansUp tests that a container is terminated exactly once whichever setup stage fails
+func TestSetupCleansUp(t *testing.T) {
+	defer func(timeout time.Duration) { cleanupTimeout = timeout }(cleanupTimeout)
+	cleanupTimeout = 50 * time.Millisecond
+
+	errStart := errors.New("wait strategy timed out")
+	errDocker := errors.New("docker desktop went away")
+	errTerminate := errors.New("container already gone")
+	for name, tc := range map[string]struct {
+		container *fakeContainer
+		startErr  error
+		opts      []SetupOption
+		wantErrs  []error
+	}{
+		"created but not started": {container: &fakeContainer{}, startErr: errStart, wantErrs: []error{errStart}},
+		"log producer":            {container: &fakeContainer{logErr: errDocker}, wantErrs: []error{errDocker}},
+		"mapped port":             {container: &fakeContainer{portErr: errDocker}, wantErrs: []error{errDocker}},
+		"host":                    {container: &fakeContainer{hostErr: errDocker}, wantErrs: []error{errDocker}},
+		"client":                  {container: &fakeContainer{host: "bad host"}},
+		"self-test": {
+			// The fake engine cannot parse the case's rule, so it cannot pass
+			container: readyContainer(t),
+			opts:      []SetupOption{WithSelfTest(client.SelfTestSuite{Cases: []client.SelfTestCase{{Name: "unreachable", Rule: "rule"}}, Deadline: time.Second})},
+			wantErrs:  []error{client.ErrSelfTestFailed},
+		},
+		"termination fails too": {container: &fakeContainer{hostErr: errDocker, terminateErr: errTerminate}, wantErrs: []error{errDocker, errTerminate}},
+		"wedged daemon":         {container: &fakeContainer{hostErr: errDocker, wedged: true}, wantErrs: []error{errDocker, context.DeadlineExceeded}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			pe, err := startPolicyEngine(context.Background(), func(context.Context, testcontainers.GenericContainerRequest) (testcontainers.Container, error) {
+				return tc.container, tc.startErr
+			}, tc.opts...)
+			assert.Nil(t, pe)
+			require.Error(t, err)
+			for _, want := range tc.wantErrs {
+				assert.ErrorIs(t, err, want)
+			}
+			assert.Equal(t, 1, tc.container.terminations)
+		})
+	}
+
+	t.Run("nothing started", func(t *testing.T) {
+		pe, err := startPolicyEngine(context.Background(), func(context.Context, testcontainers.GenericContainerRequest) (testcontainers.Container, error) {
+			return nil, errDocker
+		})
+		assert.Nil(t, pe)
+		assert.ErrorIs(t, err, errDocker)
+	})
+}
+
+// recordingT is a testingT that records what SetupPolicyEngineT does with it
+type recordingT struct {
+	failed   bool
+	fatal    string
+	logs     []string
+	cleanups []func()
+}
+
+func (r *recordingT) Helper()          {}
+func (r *recordingT) Failed() bool     { return r.failed }
+func (r *recordingT) Cleanup(f func()) { r.cleanups = append(r.cleanups, f) }
+
+func (r *recordingT) Logf(format string, args ...interface{}) {
+	r.logs = append(r.logs, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingT) Fatalf(format string, args ...interface{}) {
+	r.failed = true
+	r.fatal = fmt.Sprintf(format, args...)
+}
+
+// finish runs the cleanups, last registered first, as testing does
+func (r *recordingT) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+// TestSetupPolicyEngineT tests that the helper fails the test on a partial
+// setup, terminating what started, and dumps the engine's output for a
+// failed test
+func TestSetupPolicyEngineT(t *testing.T) {
+	errDocker := errors.New("docker desktop went away")
+	started := func(container *fakeContainer) containerStarter {
+		return func(context.Context, testcontainers.GenericContainerRequest) (testcontainers.Container, error) {
+			return container, nil
+		}
+	}
+
+	t.Run("port mapping fails", func(t *testing.T) {
+		container := &fakeContainer{portErr: errDocker}
+		rt := &recordingT{}
+		assert.Nil(t, setupPolicyEngineT(rt, started(container)))
+		assert.True(t, rt.failed)
+		assert.Contains(t, rt.fatal, "failed to get mapped port: docker desktop went away")
+		assert.Equal(t, 1, container.terminations)
+		assert.Empty(t, rt.cleanups)
+	})
+
+	t.Run("passing test", func(t *testing.T) {
+		container := readyContainer(t)
+		rt := &recordingT{}
+		pe := setupPolicyEngineT(rt, started(container))
+		require.NotNil(t, pe)
+		pe.logs.Accept(testcontainers.Log{Content: []byte("Listening on 0.0.0.0:3000\n")})
+		assert.Equal(t, 0, container.terminations)
+
+		rt.finish()
+		assert.Equal(t, 1, container.terminations)
+		assert.Empty(t, rt.logs)
+	})
+
+	t.Run("failed test", func(t *testing.T) {
+		container := readyContainer(t)
+		rt := &recordingT{}
+		pe := setupPolicyEngineT(rt, started(container))
+		require.NotNil(t, pe)
+		for i := 0; i < failureLogLines+10; i++ {
+			pe.logs.Accept(testcontainers.Log{Content: []byte(fmt.Sprintf("line %d\n", i))})
+		}
+
+		rt.failed = true
+		rt.finish()
+		assert.Equal(t, 1, container.terminations)
+		require.Len(t, rt.logs, 1)
+		assert.True(t, strings.HasPrefix(rt.logs[0], fmt.Sprintf("last %d lines of policy engine output:\nline 10\n", failureLogLines)), rt.logs[0])
+		assert.True(t, strings.HasSuffix(rt.logs[0], fmt.Sprintf("line %d", failureLogLines+9)))
+	})
+}
+
+// TestWithLogConsumer tests that the engine's output is printed, prefixed,
+// only into a failed test
+func TestWithLogConsumer(t *testing.T) {
+	run := func(failed bool) *recordingT {
+		container := readyContainer(t)
+		rt := &recordingT{}
+		pe := setupPolicyEngineT(rt, func(context.Context, testcontainers.GenericContainerRequest) (testcontainers.Container, error) {
+			return container, nil
+		}, withLogConsumer(rt))
+		require.NotNil(t, pe)
+		container.log("Listening on 0.0.0.0:3000")
+		container.log("evaluated rule")
+		rt.failed = failed
+		rt.finish()
+		return rt
+	}
+
+	assert.Empty(t, run(false).logs)
+	failed := run(true)
+	require.NotEmpty(t, failed.logs)
+	assert.Equal(t, "policy engine output:\n[policy-engine] Listening on 0.0.0.0:3000\n[policy-engine] evaluated rule", failed.logs[len(failed.logs)-1])
+}
+
+// startingStrategy is a wait strategy that records whether its container's
+// output was being followed when it was asked to wait
+type startingStrategy struct {
+	container *fakeContainer
+	following bool
+}
+
+func (s *startingStrategy) WaitUntilReady(context.Context, wait.StrategyTarget) error {
+	s.following = s.container.producing
+	return errors.New("never became healthy")
+}
+
+// TestFollowingStrategy tests that output is followed before the wait
+// strategy runs, once, so a container that never becomes healthy is logged
+func TestFollowingStrategy(t *testing.T) {
+	container := &fakeContainer{}
+	next := &startingStrategy{container: container}
+	buffer := &logCollector{}
+	following := &followingStrategy{next: next, consumers: []testcontainers.LogConsumer{buffer}}
+
+	assert.ErrorContains(t, following.WaitUntilReady(context.Background(), container), "never became healthy")
+	assert.True(t, next.following)
+	require.NoError(t, following.follow(context.Background(), container))
+	assert.Len(t, container.consumers, 1)
+
+	container.log("thread 'main' panicked")
+	assert.Equal(t, []string{"thread 'main' panicked"}, buffer.lines())
+}
+
+// TestWaitForReady tests that WaitForReady rides out a briefly unhealthy
+// engine, and says what it last saw when the engine never recovers
+func TestWaitForReady(t *testing.T) {
+	ctx := context.Background()
+	var unhealthy atomic.Int32
+	engine := enginetest.NewEngine()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" && unhealthy.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "evaluator starting")
+			return
+		}
+		engine.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	policyClient, err := client.New(server.URL)
+	require.NoError(t, err)
+	pe := &PolicyEngineContainer{PolicyClient: policyClient, BaseURL: server.URL}
+	fast := WithProbeInterval(time.Millisecond)
+
+	unhealthy.Store(3)
+	assert.NoError(t, pe.WaitForReady(ctx, fast))
+	assert.NoError(t, pe.WaitForReady(ctx, fast, WithWarmUpRule(evaluatortest.SeniorRule, map[string]interface{}{"Person": map[string]interface{}{"age": 70}})))
+
+	unhealthy.Store(1 << 30)
+	err = pe.WaitForReady(ctx, fast, WithProbeTimeout(50*time.Millisecond))
+	var notReady *ReadinessError
+	require.True(t, errors.As(err, &notReady), err)
+	assert.Greater(t, notReady.Attempts, 1)
+	assert.Equal(t, http.StatusServiceUnavailable, notReady.StatusCode)
+	assert.Equal(t, "evaluator starting", notReady.Body)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), `last status 503 "evaluator starting"`)
+
+	unhealthy.Store(0)
+	err = pe.WaitForReady(ctx, fast, WithProbeTimeout(50*time.Millisecond), WithWarmUpRule(evaluatortest.InvalidRule, map[string]interface{}{}))
+	var engineErr *client.EngineError
+	assert.True(t, errors.As(err, &engineErr), err)
+	assert.Contains(t, err.Error(), "warm-up evaluation failed")
+
+	assert.ErrorContains(t, pe.WaitForReady(ctx, WithProbeInterval(0)), "invalid probe")
+}
+
+// TestAuthProxy tests that an engine set up behind the auth proxy answers
+// its client, which sends the token, and refuses one that does not
+func TestAuthProxy(t *testing.T) {
+	pe, err := startPolicyEngine(context.Background(), func(context.Context, testcontainers.GenericContainerRequest) (testcontainers.Container, error) {
+		return readyContainer(t), nil
+	}, WithAuthProxy("s3cret"))
+	require.NoError(t, err)
+	defer pe.Close()
+	assertAuthProxy(t, pe)
+}
+
+// assertAuthProxy asserts that pe answers its own client and refuses one
+// without the token
+func assertAuthProxy(t *testing.T, pe *PolicyEngineContainer) {
+	ctx := context.Background()
+	require.NoError(t, pe.Health(ctx))
+	response, err := pe.Evaluate(ctx, client.PolicyRequest{Rule: evaluatortest.SeniorRule, Data: map[string]interface{}{"Person": map[string]interface{}{"age": 70}}})
+	require.NoError(t, err)
+	assert.True(t, response.Result)
+
+	anonymous, err := client.New(pe.BaseURL)
+	require.NoError(t, err)
+	defer anonymous.Close()
+	_, err = anonymous.Evaluate(ctx, client.PolicyRequest{Rule: evaluatortest.SeniorRule, Data: map[string]interface{}{}})
+	var authErr *client.AuthError
+	require.True(t, errors.As(err, &authErr), err)
+	assert.Equal(t, http.StatusUnauthorized, authErr.StatusCode)
+	assert.True(t, errors.As(anonymous.Health(ctx), &authErr))
+}
+
+// TestTLSProxy tests that an engine set up with WithTLS answers over HTTPS to
+// its client and fails verification against another authority
+func TestTLSProxy(t *testing.T) {
+	certPEM, keyPEM, err := enginetest.SelfSignedCert()
+	require.NoError(t, err)
+	pe, err := startPolicyEngine(context.Background(), func(context.Context, testcontainers.GenericContainerRequest) (testcontainers.Container, error) {
+		return readyContainer(t), nil
+	}, WithTLS(certPEM, keyPEM))
+	require.NoError(t, err)
+	defer pe.Close()
+	assertTLS(t, pe)
+}
+
+// assertTLS asserts that pe answers its own client over HTTPS and that a
+// client trusting another authority cannot reach it
+func assertTLS(t *testing.T, pe *PolicyEngineContainer) {
+	ctx := context.Background()
+	require.True(t, strings.HasPrefix(pe.BaseURL, "https://"), pe.BaseURL)
+	require.NoError(t, pe.Health(ctx))
+	response, err := pe.Evaluate(ctx, client.PolicyRequest{Rule: evaluatortest.SeniorRule, Data: map[string]interface{}{"Person": map[string]interface{}{"age": 70}}})
+	require.NoError(t, err)
+	assert.True(t, response.Result)
+
+	otherCA, _, err := enginetest.SelfSignedCert()
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(otherCA))
+	untrusting, err := client.New(pe.BaseURL, client.WithTLSConfig(&tls.Config{RootCAs: roots}))
+	require.NoError(t, err)
+	defer untrusting.Close()
+	_, err = untrusting.Evaluate(ctx, client.PolicyRequest{Rule: evaluatortest.SeniorRule, Data: map[string]interface{}{}})
+	var unknown x509.UnknownAuthorityError
+	assert.ErrorAs(t, err, &unknown)
+}
+
+// TestEnginePool tests that the pool starts one container for concurrent
+// callers and replaces it once it stops answering its health check
+func TestEnginePool(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer engine.Close()
+
+	var containers []*fakeContainer
+	var mu sync.Mutex
+	start := func(context.Context) (*PolicyEngineContainer, error) {
+		policyClient, err := client.New(engine.URL)
+		if err != nil {
+			return nil, err
+		}
+		container := &fakeContainer{}
+		mu.Lock()
+		containers = append(containers, container)
+		mu.Unlock()
+		return &PolicyEngineContainer{Container: container, PolicyClient: policyClient, BaseURL: engine.URL}, nil
+	}
+
+	pool := &enginePool{}
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	got := make([]*PolicyEngineContainer, 20)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pe, err := pool.get(ctx, start)
 			assert.NoError(t, err)
-			assert.NotNil(t, response)
+			got[i] = pe
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 1, pool.starts)
+	for _, pe := range got {
+		assert.Same(t, got[0], pe)
+	}
+
+	healthy.Store(false)
+	replaced, err := pool.get(ctx, start)
+	require.NoError(t, err)
+	assert.NotSame(t, got[0], replaced)
+	assert.Equal(t, 2, pool.starts)
+	assert.Equal(t, 1, containers[0].terminations, "the unhealthy container is terminated")
+
+	_, err = pool.get(ctx, func(context.Context) (*PolicyEngineContainer, error) { return nil, errors.New("no docker") })
+	assert.ErrorContains(t, err, "failed to start shared policy engine: no docker")
+
+	healthy.Store(true)
+	_, err = pool.get(ctx, start)
+	require.NoError(t, err)
+	require.NoError(t, pool.close())
+	assert.Equal(t, 1, containers[2].terminations)
+	assert.NoError(t, pool.close(), "closing an empty pool does nothing")
+}
+
+// TestTerminateIdempotent tests that Terminate and Close act once and tolerate partial wrappers
+func TestTerminateIdempotent(t *testing.T) {
+	container := readyContainer(t)
+	pe, err := startPolicyEngine(context.Background(), func(context.Context, testcontainers.GenericContainerRequest) (testcontainers.Container, error) {
+		return container, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "http://"+container.host+":"+container.port.Port(), pe.BaseURL)
+
+	assert.NoError(t, pe.Terminate(context.Background()))
+	assert.NoError(t, pe.Terminate(context.Background()))
+	assert.NoError(t, pe.Close())
+	assert.Equal(t, 1, container.terminations)
+
+	var missing *PolicyEngineContainer
+	assert.NoError(t, missing.Terminate(context.Background()))
+	assert.NoError(t, (&PolicyEngineContainer{}).Close())
+}
+
+// TestSetupOptions tests that options shape the container request over the
+// defaults, and that bad ones fail before anything is started
+func TestSetupOptions(t *testing.T) {
+	var req testcontainers.GenericContainerRequest
+	container := readyContainer(t)
+	start := func(_ context.Context, r testcontainers.GenericContainerRequest) (testcontainers.Container, error) {
+		req = r
+		return container, nil
+	}
+
+	pe, err := startPolicyEngine(context.Background(), start)
+	require.NoError(t, err)
+	require.NoError(t, pe.Close())
+	assert.Equal(t, "policy-engine:latest", req.Image)
+	assert.Equal(t, []string{"3000/tcp"}, req.ExposedPorts)
+	assert.Equal(t, "test-env", req.Env["FF_ENV_ID"])
+	assert.Equal(t, nat.Port("3000/tcp"), container.mapped)
+
+	container = readyContainer(t)
+	pe, err = startPolicyEngine(context.Background(), start,
+		WithImage("policy-engine:1.4.2"),
+		WithEnv(map[string]string{"RUST_LOG": "debug"}),
+		WithEnv(map[string]string{"FF_ENV_ID": "ci"}),
+		WithExposedPort("8080"),
+		WithStartupTimeout(5*time.Second))
+	require.NoError(t, err)
+	require.NoError(t, pe.Close())
+	assert.Equal(t, "policy-engine:1.4.2", req.Image)
+	assert.Equal(t, []string{"8080/tcp"}, req.ExposedPorts)
+	assert.Equal(t, map[string]string{
+		"FF_ENV_ID":     "ci",
+		"FF_AGENT_ID":   "test-agent",
+		"FF_PROJECT_ID": "test-project",
+		"RUST_LOG":      "debug",
+	}, req.Env)
+	assert.Equal(t, nat.Port("8080/tcp"), container.mapped)
+	assert.Equal(t, 5*time.Second, *req.WaitingFor.(*followingStrategy).next.(*wait.HTTPStrategy).Timeout())
 
-			t.Logf("Test case '%s' result: %+v", tc.name, response)
+	for name, tc := range map[string]struct {
+		opt     SetupOption
+		wantErr string
+	}{
+		"empty image":     {opt: WithImage(" "), wantErr: "invalid setup: empty image"},
+		"bad port":        {opt: WithExposedPort("http"), wantErr: `invalid setup: exposed port "http" is not a port`},
+		"zero timeout":    {opt: WithStartupTimeout(0), wantErr: "invalid setup: startup timeout 0s is not positive"},
+		"bad certificate": {opt: WithTLS([]byte("not a certificate"), nil), wantErr: "invalid setup: TLS certificate: tls: failed to find any PEM data in certificate input"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := startPolicyEngine(context.Background(), func(context.Context, testcontainers.GenericContainerRequest) (testcontainers.Container, error) {
+				t.Fatal("started a container")
+				return nil, nil
+			}, tc.opt)
+			assert.EqualError(t, err, tc.wantErr)
 		})
 	}
-}
\ No newline at end of file
+}
+
+// TestSetupConfigFile tests that a config file sets up the container, that
+// options after it override it and options before it do not, and that a bad
+// file fails before anything is started
+func TestSetupConfigFile(t *testing.T) {
+	var req testcontainers.GenericContainerRequest
+	container := readyContainer(t)
+	start := func(_ context.Context, r testcontainers.GenericContainerRequest) (testcontainers.Container, error) {
+		req = r
+		return container, nil
+	}
+	dir := t.TempDir()
+	write := func(name, text string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(text), 0o644))
+		return path
+	}
+	config := write("engine.yaml", `
+failure_policy: closed
+cache: {ttl: 30s}
+container:
+  image: ${ENGINE_IMAGE:-policy-engine:1.5.0}
+  port: "8080"
+  startup_timeout: 10s
+  env: {RUST_LOG: debug}
+`)
+
+	pe, err := startPolicyEngine(context.Background(), start, WithImage("policy-engine:1.4.2"), WithConfigFile(config))
+	require.NoError(t, err)
+	require.NoError(t, pe.Close())
+	assert.Equal(t, "policy-engine:1.5.0", req.Image, "the file overrides options before it")
+	assert.Equal(t, []string{"8080/tcp"}, req.ExposedPorts)
+	assert.Equal(t, "debug", req.Env["RUST_LOG"])
+	assert.Equal(t, "test-env", req.Env["FF_ENV_ID"])
+	assert.Equal(t, 10*time.Second, *req.WaitingFor.(*followingStrategy).next.(*wait.HTTPStrategy).Timeout())
+	assert.Equal(t, "http://"+container.host+":"+container.port.Port(), pe.BaseURL, "the container's address beats any in the file")
+
+	t.Setenv("ENGINE_IMAGE", "policy-engine:nightly")
+	pe, err = startPolicyEngine(context.Background(), start, WithConfigFile(config), WithStartupTimeout(time.Minute))
+	require.NoError(t, err)
+	require.NoError(t, pe.Close())
+	assert.Equal(t, "policy-engine:nightly", req.Image, "the environment overrides the file's default")
+	assert.Equal(t, time.Minute, *req.WaitingFor.(*followingStrategy).next.(*wait.HTTPStrategy).Timeout(), "options after the file override it")
+
+	for name, tc := range map[string]struct {
+		path    string
+		wantErr string
+	}{
+		"missing":        {path: filepath.Join(dir, "missing.yaml"), wantErr: "invalid setup: failed to read config"},
+		"unknown key":    {path: write("unknown.yaml", "container: {image: x, tag: y}\n"), wantErr: "field tag not found"},
+		"invalid client": {path: write("invalid.yaml", "failure_policy: sometimes\n"), wantErr: `invalid setup: invalid config: failure_policy: "sometimes" is not one of`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := startPolicyEngine(context.Background(), func(context.Context, testcontainers.GenericContainerRequest) (testcontainers.Container, error) {
+				t.Fatal("started a container")
+				return nil, nil
+			}, WithConfigFile(tc.path))
+			assert.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
+
+// TestEngineLogs tests that the container's output is collected and parsed
+func TestEngineLogs(t *testing.T) {
+	ctx := context.Background()
+	pe := SetupPolicyEngineT(t)
+
+	// The engine announces itself with a plain line, before it is healthy
+	assert.Eventually(t, func() bool {
+		entries, err := pe.Logs(ctx)
+		return err == nil && enginelog.Contains(entries, enginelog.LevelNone, "Listening on")
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+// TestContainerAuthProxy tests the container behind the auth proxy: 401
+// without the token, 200 with it
+func TestContainerAuthProxy(t *testing.T) {
+	assertAuthProxy(t, SetupPolicyEngineT(t, WithAuthProxy("s3cret")))
+}
+
+// TestContainerTLS tests HTTPS evaluation against the container, and a
+// client trusting the wrong authority failing verification
+func TestContainerTLS(t *testing.T) {
+	certPEM, keyPEM, err := enginetest.SelfSignedCert()
+	require.NoError(t, err)
+	assertTLS(t, SetupPolicyEngineT(t, WithTLS(certPEM, keyPEM)))
+}
+
+// TestEngineLogConsumer tests that the engine's startup banner, written before
+// it is healthy, reaches a failed test's output
+func TestEngineLogConsumer(t *testing.T) {
+	rt := &recordingT{}
+	pe := SetupPolicyEngineT(t, withLogConsumer(rt))
+	require.NoError(t, pe.Terminate(context.Background()))
+
+	rt.failed = true
+	rt.finish()
+	require.Len(t, rt.logs, 1)
+	assert.Contains(t, rt.logs[0], logPrefix+"Listening on")
+}
+
+// TestPolicyEngineConnection tests basic connectivity to the Policy Engine
+func TestPolicyEngineConnection(t *testing.T) {
+	ctx := context.Background()
+
+	// Start Policy Engine container
+	pe := SetupPolicyEngineT(t)
+
+	// Verify health check
+	err := pe.Health(ctx)
+	assert.NoError(t, err)
+
+	// Test basic policy evaluation
+	data := map[string]interface{}{
+		"age": 70,
+	}
+
+	rule := "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+
+	response, err := pe.Evaluate(ctx, client.PolicyRequest{Rule: rule, Data: data, Trace: true})
+	assert.NoError(t, err)
+	assert.NotNil(t, response)
+
+	// The result should be false because the data structure doesn't match exactly
+	// (we need "Person": {"age": 70} for this rule to work)
+	t.Logf("Policy evaluation result: %+v", response)
+}
+
+// TestStrictDataShape tests that the flat data of TestPolicyEngineConnection,
+// which the engine quietly answers false for, fails loudly under
+// WithStrictData, while the nested shape the rule reads still evaluates
+func TestStrictDataShape(t *testing.T) {
+	ctx := context.Background()
+
+	forEachEngine(t, func(t *testing.T, pe *testEngine) {
+
+		strict, err := client.New(pe.BaseURL, client.WithStrictData())
+		require.NoError(t, err)
+		rule := "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+
+		_, err = strict.EvaluatePolicy(ctx, rule, map[string]interface{}{"age": 70}, false)
+		var shapeErr *client.DataShapeError
+		require.ErrorAs(t, err, &shapeErr)
+		assert.Contains(t, err.Error(), "the data has no Person object")
+
+		response, err := strict.EvaluatePolicy(ctx, rule, map[string]interface{}{"Person": map[string]interface{}{"age": 70}}, false)
+		require.NoError(t, err)
+		assert.True(t, response.Result)
+	})
+}
+
+// TestRecordedPolicies tests that decisions recorded against an engine replay
+// the same with nothing listening at the client's base URL
+func TestRecordedPolicies(t *testing.T) {
+	ctx := context.Background()
+
+	forEachEngine(t, func(t *testing.T, pe *testEngine) {
+		cassette := filepath.Join(t.TempDir(), "policies.json")
+		rule := "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+		ages := []int{40, 65, 70}
+
+		recording, err := client.New(pe.BaseURL, client.WithRecorder(cassette, client.RecordOnce))
+		require.NoError(t, err)
+		var recorded []bool
+		for _, age := range ages {
+			response, err := recording.EvaluatePolicy(ctx, rule, map[string]interface{}{"Person": map[string]interface{}{"age": age}}, false)
+			require.NoError(t, err)
+			recorded = append(recorded, response.Result)
+		}
+		assert.Equal(t, []bool{false, true, true}, recorded)
+
+		// Port 1 refuses connections, so anything not replayed fails
+		replaying, err := client.New("http://127.0.0.1:1", client.WithRecorder(cassette, client.ReplayOnly))
+		require.NoError(t, err)
+		for i, age := range ages {
+			response, err := replaying.EvaluatePolicy(ctx, rule, map[string]interface{}{"Person": map[string]interface{}{"age": age}}, false)
+			require.NoError(t, err)
+			assert.Equal(t, recorded[i], response.Result, "age %d", age)
+		}
+		_, err = replaying.EvaluatePolicy(ctx, rule, map[string]interface{}{"Person": map[string]interface{}{"age": 99}}, false)
+		assert.ErrorIs(t, err, client.ErrNoInteraction)
+	})
+}
+
+// TestSharedPolicyEngineParallel tests 50 parallel evaluations through the
+// shared container
+func TestSharedPolicyEngineParallel(t *testing.T) {
+	rule := "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+	for i := 0; i < 50; i++ {
+		age := 40 + i
+		t.Run(fmt.Sprintf("age %d", age), func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			pe, err := SharedPolicyEngine(ctx)
+			require.NoError(t, err)
+
+			response, err := pe.EvaluatePolicy(ctx, rule, map[string]interface{}{"Person": map[string]interface{}{"age": age}}, false)
+			require.NoError(t, err)
+			assert.Equal(t, age >= 65, response.Result)
+		})
+	}
+}
+
+// TestEngineConformance runs the shared Evaluator suite against the container,
+// the reference the other evaluators are held to
+func TestEngineConformance(t *testing.T) {
+	evaluatortest.Conformance(t, func(t *testing.T) client.Evaluator {
+		pe := SetupPolicyEngineT(t)
+		return pe
+	})
+}
+
+// TestSeniorDiscountPolicy tests the senior discount policy with proper data structure
+func TestSeniorDiscountPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	forEachEngine(t, func(t *testing.T, pe *testEngine) {
+
+		// Test senior gets discount
+		data := map[string]interface{}{
+			"age": 70,
+		}
+
+		rule := "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+
+		response, err := pe.Evaluate(ctx, client.PolicyRequest{Rule: rule, Data: data})
+		assert.NoError(t, err)
+		assert.NotNil(t, response)
+
+		t.Logf("Senior discount policy result: %+v", response)
+	})
+}
+
+// TestSeniorDiscountMarshalled tests that the data the flat map above gets
+// wrong evaluates as intended once built from a tagged struct
+func TestSeniorDiscountMarshalled(t *testing.T) {
+	ctx := context.Background()
+
+	forEachEngine(t, func(t *testing.T, pe *testEngine) {
+
+		type customer struct {
+			Name  string     `policy:"Person.name"`
+			Age   int        `policy:"Person.age"`
+			Email *string    `policy:"Person.email"`
+			Since *time.Time `policy:"Person.member_since,date"`
+		}
+		rule := "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+
+		flat, err := pe.Evaluate(ctx, client.PolicyRequest{Rule: rule, Data: map[string]interface{}{"age": 70}})
+		require.NoError(t, err)
+		assert.False(t, flat.Result, "the flat map has no **Person**")
+
+		for _, tc := range []struct {
+			age  int
+			want bool
+		}{
+			{age: 70, want: true},
+			{age: 65, want: true},
+			{age: 64, want: false},
+		} {
+			data, err := policydata.Marshal(customer{Name: "Ada", Age: tc.age})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"Person": map[string]interface{}{"name": "Ada", "age": tc.age}}, data,
+				"nil optionals are left out")
+
+			response, err := pe.Evaluate(ctx, client.PolicyRequest{Rule: rule, Data: data})
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, response.Result, "age %d", tc.age)
+		}
+	})
+}
+
+// TestMultiRulePolicy tests a policy whose second rule depends on the label
+// the first grants, through assertpolicy, which prints the trace of a label
+// that comes out wrong
+func TestMultiRulePolicy(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, pe *testEngine) {
+
+		rules := []string{
+			"driver. A **Person** can drive if the **Person** is an adult and the __driving_hours__ of the **Person** is at least 20.",
+			"adult. A **Person** is an adult if the __age__ of the **Person** is at least 18.",
+		}
+		testCases := []struct {
+			name         string
+			age, hours   int
+			adult, drive bool
+		}{
+			{name: "Adult with hours", age: 30, hours: 25, adult: true, drive: true},
+			{name: "Adult without hours", age: 30, hours: 5, adult: true, drive: false},
+			{name: "Minor with hours", age: 16, hours: 25, adult: false, drive: false},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				data := map[string]interface{}{"Person": map[string]interface{}{"age": tc.age, "driving_hours": tc.hours}}
+				assertpolicy.Label(t, pe, rules, data, "adult", tc.adult)
+				assertpolicy.Label(t, pe, rules, data, "driver", tc.drive)
+			})
+		}
+	})
+}
+
+// TestRuleBuilderPolicies tests that rules written with rulebuilder parse
+// and decide as their hand-written text does
+func TestRuleBuilderPolicies(t *testing.T) {
+	ctx := context.Background()
+
+	forEachEngine(t, func(t *testing.T, pe *testEngine) {
+
+		senior := rulebuilder.Object("Person").Gets("senior_discount").
+			If(rulebuilder.Property("age").Of("Person").GreaterOrEqual(65)).MustBuild()
+		for age, want := range map[int]bool{64: false, 65: true} {
+			response, err := pe.EvaluatePolicy(ctx, senior, map[string]interface{}{"Person": map[string]interface{}{"age": age}}, false)
+			require.NoError(t, err)
+			assert.Equal(t, want, response.Result, "age %d", age)
+		}
+
+		rules, err := rulebuilder.Build(
+			rulebuilder.Object("Person").Labelled("driver").Outcome("can", "drive").
+				If(rulebuilder.Reference("Person", "is an adult")).
+				And(rulebuilder.Property("driving_hours").Of("Person").AtLeast(20)),
+			rulebuilder.Object("Person").Labelled("adult").Is("an adult").
+				If(rulebuilder.Property("age").Of("Person").AtLeast(18)),
+		)
+		require.NoError(t, err)
+		response, err := pe.EvaluateRules(ctx, rules, map[string]interface{}{"Person": map[string]interface{}{"age": 30, "driving_hours": 25}}, false)
+		require.NoError(t, err)
+		assert.True(t, response.Result)
+		assert.Equal(t, map[string]bool{"adult": true, "driver": true}, response.Labels)
+
+		shipping := rulebuilder.Object("Order").Gets("expedited_shipping").
+			If(rulebuilder.Property("total").Of("Order").Greater(100)).
+			And(rulebuilder.Property("membership_level").Of("Customer").In("gold", "platinum")).MustBuild()
+		response, err = pe.EvaluatePolicy(ctx, shipping, map[string]interface{}{
+			"Order":    map[string]interface{}{"total": 150.0},
+			"Customer": map[string]interface{}{"membership_level": "gold"},
+		}, false)
+		require.NoError(t, err)
+		assert.True(t, response.Result)
+	})
+}
+
+// TestScaffoldedData tests that scaffolded data has the shape the engine
+// reads, so each rule evaluates, to false, without errors
+func TestScaffoldedData(t *testing.T) {
+	ctx := context.Background()
+
+	forEachEngine(t, func(t *testing.T, pe *testEngine) {
+
+		for _, rule := range []string{
+			"A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65.",
+			`A **User** gets access if the __role__ of the **User** is equal to "admin".`,
+			`An **Order** gets expedited_shipping if the __total__ of the **Order** is greater than 100 and the __membership_level__ of the **Customer** is in ["gold", "platinum"].`,
+		} {
+			data, err := selectors.ScaffoldData(rule)
+			require.NoError(t, err)
+			response, err := pe.EvaluatePolicy(ctx, rule, data, false)
+			require.NoError(t, err, rule)
+			assert.False(t, response.Result, rule)
+		}
+	})
+}
+
+// TestExpeditedShippingPolicy tests a more complex policy with nested data
+func TestExpeditedShippingPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	forEachEngine(t, func(t *testing.T, pe *testEngine) {
+
+		// Test expedited shipping policy
+		data := map[string]interface{}{
+			"total": 150.0,
+			"Customer": map[string]interface{}{
+				"membership_level": "gold",
+			},
+		}
+
+		rule := `An **Order** gets expedited_shipping if the __total__ of the **Order** is greater than 100 and the __membership_level__ of the **Customer** is in ["gold", "platinum"].`
+
+		response, err := pe.Evaluate(ctx, client.PolicyRequest{Rule: rule, Data: data, Trace: true})
+		assert.NoError(t, err)
+		assert.NotNil(t, response)
+
+		t.Logf("Expedited shipping policy result: %+v", response)
+	})
+}
+
+// TestMultiplePolicies demonstrates testing multiple policies in sequence
+func TestMultiplePolicies(t *testing.T) {
+	ctx := context.Background()
+
+	forEachEngine(t, func(t *testing.T, pe *testEngine) {
+
+		testCases := []struct {
+			name string
+			rule string
+			data interface{}
+			want bool
+		}{
+			{
+				name: "Young person no discount",
+				rule: "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65.",
+				data: map[string]interface{}{"age": 30},
+				want: false,
+			},
+			{
+				name: "Access granted for admin",
+				rule: "A **User** gets access if the __role__ of the **User** is equal to \"admin\".",
+				data: map[string]interface{}{"role": "admin"},
+				want: false, // Will be false due to data structure mismatch, but test runs
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				response, err := pe.Evaluate(ctx, client.PolicyRequest{Rule: tc.rule, Data: tc.data})
+				assert.NoError(t, err)
+				assert.NotNil(t, response)
+
+				t.Logf("Test case '%s' result: %+v", tc.name, response)
+			})
+		}
+	})
+}
+
+// TestNormalizedKeysPolicy tests that third-party key spellings satisfy the rule once normalized
+func TestNormalizedKeysPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	forEachEngine(t, func(t *testing.T, pe *testEngine) {
+
+		policyClient, err := client.New(pe.BaseURL, client.WithKeyNormalization(policydata.NormalizationConfig{
+			Style: policydata.SnakeCase,
+		}))
+		assert.NoError(t, err)
+
+		data := map[string]interface{}{
+			"Order": map[string]interface{}{
+				"Total": 150.0,
+			},
+			"Customer": map[string]interface{}{
+				"MembershipLevel": "gold",
+			},
+		}
+
+		rule := `An **Order** gets expedited_shipping if the __total__ of the **Order** is greater than 100 and the __membership_level__ of the **Customer** is in ["gold", "platinum"].`
+
+		response, err := policyClient.EvaluatePolicy(ctx, rule, data, false)
+		assert.NoError(t, err)
+		assert.NotNil(t, response)
+		assert.True(t, response.Result)
+
+		t.Logf("Normalized keys policy result: %+v", response)
+	})
+}
+
+// TestAmbientContextPolicy tests a rule that compares against the injected __now__ of the **Context**
+func TestAmbientContextPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	pe := SetupPolicyEngineT(t)
+
+	// Pin the clock so the result does not depend on the day the test runs
+	policyClient, err := client.New(pe.BaseURL,
+		client.WithContextData(func(context.Context) (map[string]interface{}, error) {
+			return map[string]interface{}{"environment": "prod", "project_id": "test-project"}, nil
+		}),
+		client.WithClock(func() time.Time {
+			return time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+		}),
+	)
+	assert.NoError(t, err)
+
+	rule := `A **Context** gets summer_sale if the __now__ of the **Context** is later than date(2025-05-31) and the __environment__ of the **Context** is equal to "prod".`
+
+	response, err := policyClient.EvaluatePolicy(ctx, rule, nil, true)
+	assert.NoError(t, err)
+	assert.NotNil(t, response)
+	assert.True(t, response.Result)
+
+	t.Logf("Ambient context policy result: %+v", response)
+}
+
+// TestShippingPolicySensitivity tests which payload fields the expedited shipping rule depends on
+func TestShippingPolicySensitivity(t *testing.T) {
+	ctx := context.Background()
+
+	forEachEngine(t, func(t *testing.T, pe *testEngine) {
+
+		data := map[string]interface{}{
+			"Order": map[string]interface{}{
+				"total": 150.0,
+			},
+			"Customer": map[string]interface{}{
+				"membership_level": "gold",
+				"favourite_colour": "blue",
+			},
+		}
+
+		rule := `An **Order** gets expedited_shipping if the __total__ of the **Order** is greater than 100 and the __membership_level__ of the **Customer** is in ["gold", "platinum"].`
+
+		report, err := analysis.Sensitivity(ctx, pe, rule, data,
+			[]string{"Order.total", "Customer.membership_level", "Customer.favourite_colour"},
+			analysis.PerturbConfig{
+				NumericDeltas: []float64{-100},
+				StringAlternatives: map[string][]string{
+					"Customer.membership_level": {"silver"},
+					"Customer.favourite_colour": {"red"},
+				},
+			})
+		assert.NoError(t, err)
+		assert.NotNil(t, report)
+		assert.Equal(t, []string{"Order.total", "Customer.membership_level"}, report.SensitiveFields())
+
+		t.Logf("Sensitive fields: %v", report.SensitiveFields())
+	})
+}
+
+// TestCollectionPolicy tests a rule counting the items of a marshalled collection
+func TestCollectionPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	forEachEngine(t, func(t *testing.T, pe *testEngine) {
+
+		type lineItem struct {
+			SKU   string  `policy:"sku"`
+			Price float64 `policy:"price"`
+		}
+		type basket struct {
+			Items []lineItem `policy:"Order.items[]"`
+		}
+
+		rule := "An **Order** gets bulk_discount if the number of __items__ of the **Order** is at least 2."
+
+		testCases := []struct {
+			name  string
+			items []lineItem
+			want  bool
+		}{
+			{name: "Empty basket", items: nil, want: false},
+			{name: "Single item", items: []lineItem{{SKU: "A-1", Price: 20}}, want: false},
+			{name: "Two items", items: []lineItem{{SKU: "A-1", Price: 20}, {SKU: "B-2", Price: 5}}, want: true},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				data, err := policydata.Marshal(basket{Items: tc.items})
+				assert.NoError(t, err)
+				assert.Empty(t, policydata.ValidateElements(data, "Order.items", "sku", "price"))
+
+				response, err := pe.Evaluate(ctx, client.PolicyRequest{Rule: rule, Data: data})
+				assert.NoError(t, err)
+				assert.NotNil(t, response)
+				assert.Equal(t, tc.want, response.Result)
+
+				t.Logf("Test case '%s' result: %+v", tc.name, response)
+			})
+		}
+	})
+}
+
+// TestBirthdayBoundary tests a rule comparing a birth date with the engine's
+// clock on the days around the 18th birthday, by pinning the evaluation time
+func TestBirthdayBoundary(t *testing.T) {
+	ctx := context.Background()
+
+	pe := SetupPolicyEngineT(t)
+
+	rule := "An **Applicant** is an adult if the __birth_date__ of the **Applicant** is older than 18 years."
+	data := map[string]interface{}{"Applicant": map[string]interface{}{"birth_date": "2006-06-15"}}
+
+	testCases := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{name: "Day before", at: time.Date(2024, 6, 14, 12, 0, 0, 0, time.UTC), want: false},
+		{name: "Birthday", at: time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC), want: true},
+		{name: "Day after", at: time.Date(2024, 6, 16, 12, 0, 0, 0, time.UTC), want: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := pe.Evaluate(client.ContextWithEvaluationTime(ctx, tc.at), client.PolicyRequest{Rule: rule, Data: data})
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, response.Result)
+			assert.Equal(t, client.TimeTravelRewritten, response.TimeTravel)
+		})
+	}
+}
+
+// TestContainerSelfTest tests a container that must pass a self-test of the
+// example rules before setup returns
+func TestContainerSelfTest(t *testing.T) {
+	ctx := context.Background()
+
+	suite, err := client.LoadSelfTestSuite(strings.NewReader(`
+deadline: 10s
+cases:
+  - name: senior discount
+    rule: A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65.
+    data: {Person: {age: 70}}
+    want: true
+  - name: no senior discount
+    rule: A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65.
+    data: {Person: {age: 30}}
+    want: false
+  - name: bulk discount
+    rule: An **Order** gets bulk_discount if the number of __items__ of the **Order** is at least 2.
+    data: {Order: {items: [{sku: A-1}, {sku: B-2}]}}
+    want: true
+`))
+	require.NoError(t, err)
+
+	pe := SetupPolicyEngineT(t, WithSelfTest(suite))
+
+	report, err := pe.SelfTest(ctx, suite)
+	require.NoError(t, err)
+	assert.Len(t, report.Results, 3)
+}
+
+// TestApplicationScenario tests a three-step application, each step deciding
+// from the one before it
+func TestApplicationScenario(t *testing.T) {
+	pe := SetupPolicyEngineT(t)
+
+	const (
+		screenRule  = "adult. A **Person** passes screening if the __age__ of the **Person** is at least 18."
+		approveRule = "fast_track. A **Person** gets fast_track if the __screened__ of the **Person** is equal to 1 and the __income__ of the **Person** is greater than 50000."
+		notifyRule  = "welcome. A **Person** gets a welcome pack if the __fast_tracked__ of the **Person** is equal to 1."
+	)
+	flag := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+
+	scenario.New().
+		Step("screen", screenRule, scenario.Data(map[string]interface{}{"Person": map[string]interface{}{"age": 30}})).
+		ExpectLabel("screen", "adult", true).
+		Step("approve", approveRule, func(prev scenario.StepResult) interface{} {
+			return map[string]interface{}{"Person": map[string]interface{}{"screened": flag(prev.Response.Result), "income": 60000}}
+		}).
+		ExpectLabel("approve", "fast_track", true).
+		Step("notify", notifyRule, func(prev scenario.StepResult) interface{} {
+			fastTracked, _ := prev.Label("fast_track")
+			return map[string]interface{}{"Person": map[string]interface{}{"fast_tracked": flag(fastTracked)}}
+		}, scenario.WithTimeout(5*time.Second)).
+		ExpectResult("notify", true).
+		Run(t, pe)
+}
+
+// TestSimulateThresholdChange replays 1,000 recorded orders through a raised
+// free-shipping threshold and checks exactly the orders in between flip
+func TestSimulateThresholdChange(t *testing.T) {
+	ctx := context.Background()
+
+	pe := SetupPolicyEngineT(t)
+
+	rule := func(threshold int) []string {
+		return []string{fmt.Sprintf("shipping. A **Order** gets free_shipping if the __total__ of the **Order** is greater than or equal to %d.", threshold)}
+	}
+	var recorded strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&recorded, `{"Order":{"id":"order-%d","total":%d}}`+"\n", i, i%200)
+		if i%250 == 0 {
+			recorded.WriteString("{truncated\n")
+		}
+	}
+	inputs := policydata.LoadNDJSON(strings.NewReader(recorded.String()), policydata.SkipMalformed())
+
+	report, err := simulate.Compare(ctx, pe, rule(100), rule(120), inputs, simulate.WithAnonymizer(policydata.HashFields("salt", "Order.id")))
+	require.NoError(t, err)
+	t.Logf("simulation:\n%s", report)
+
+	// Totals 100 to 119 come round five times in 1,000 orders
+	assert.Equal(t, 1004, report.Inputs)
+	assert.Equal(t, 4, report.InputErrors)
+	assert.Equal(t, 1000, report.Evaluated)
+	assert.Equal(t, 100, report.Revoked)
+	assert.Equal(t, 0, report.Granted)
+	assert.Equal(t, simulate.LabelDelta{Revoked: 100}, report.Labels["shipping"])
+	require.NotEmpty(t, report.Examples)
+	assert.NotContains(t, fmt.Sprint(report.Examples[0].Data), "order-")
+}
+
+// BenchmarkEvaluateBatchWorkers measures batch throughput against the container
+// for a range of worker counts
+func BenchmarkEvaluateBatchWorkers(b *testing.B) {
+	ctx := context.Background()
+
+	pe := SetupPolicyEngineT(b)
+
+	rule := "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+	datas := make([]interface{}, 200)
+	for i := range datas {
+		datas[i] = map[string]interface{}{
+			"Person": map[string]interface{}{"age": 40 + i%50},
+		}
+	}
+
+	for _, workers := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := client.EvaluateBatchWith(ctx, pe, rule, datas, client.WithWorkers(workers)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N*len(datas))/b.Elapsed().Seconds(), "evals/s")
+		})
+	}
+}
+
+// countingProxy forwards requests to target, counting the request body bytes
+// it is sent; gzipped bodies are decompressed before they are forwarded, since
+// the engine cannot read them
+type countingProxy struct {
+	*httptest.Server
+	bytes int64
+}
+
+func newCountingProxy(b *testing.B, target string) *countingProxy {
+	b.Helper()
+
+	u, err := url.Parse(target)
+	if err != nil {
+		b.Fatal(err)
+	}
+	proxy := &countingProxy{}
+	reverse := httputil.NewSingleHostReverseProxy(u)
+	proxy.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		atomic.AddInt64(&proxy.bytes, int64(len(body)))
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(bytes.NewReader(body))
+			if err == nil {
+				body, err = io.ReadAll(gz)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Header.Del("Content-Encoding")
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		reverse.ServeHTTP(w, r)
+	}))
+	b.Cleanup(proxy.Close)
+	return proxy
+}
+
+// BenchmarkEvaluateMany compares EvaluateMany with looping EvaluatePolicy
+// over 30 rules and a large user snapshot, reporting the request bytes sent
+// per run of all the rules
+func BenchmarkEvaluateMany(b *testing.B) {
+	ctx := context.Background()
+
+	pe := SetupPolicyEngineT(b)
+
+	rules := make([]client.NamedRule, 30)
+	for i := range rules {
+		rules[i] = client.NamedRule{
+			Name: fmt.Sprintf("rule%d", i),
+			Rule: fmt.Sprintf("A **Person** gets rule%d if the __age__ of the **Person** is greater than or equal to %d.", i, 20+i),
+		}
+	}
+	history := make([]interface{}, 500)
+	for i := range history {
+		history[i] = map[string]interface{}{"order": i, "total": float64(i) * 1.5, "note": "regular order"}
+	}
+	data := map[string]interface{}{"Person": map[string]interface{}{"age": 35, "history": history}}
+
+	proxy := newCountingProxy(b, pe.BaseURL)
+	c, err := client.New(proxy.URL)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("loop", func(b *testing.B) {
+		atomic.StoreInt64(&proxy.bytes, 0)
+		for i := 0; i < b.N; i++ {
+			for _, rule := range rules {
+				if _, err := c.EvaluatePolicy(ctx, rule.Rule, data, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		b.ReportMetric(float64(atomic.LoadInt64(&proxy.bytes))/float64(b.N), "wire-bytes/op")
+	})
+	b.Run("many", func(b *testing.B) {
+		atomic.StoreInt64(&proxy.bytes, 0)
+		for i := 0; i < b.N; i++ {
+			if _, err := c.EvaluateMany(ctx, rules, data); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(atomic.LoadInt64(&proxy.bytes))/float64(b.N), "wire-bytes/op")
+	})
+}
+
+// BenchmarkCompression compares evaluating a 5 MB data payload with and
+// without WithCompression against the container, reporting the request bytes
+// sent per evaluation. The engine cannot read gzip, so requests go through a
+// proxy that decompresses them.
+func BenchmarkCompression(b *testing.B) {
+	ctx := context.Background()
+
+	pe := SetupPolicyEngineT(b)
+
+	rule := "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+	history := make([]interface{}, 0, 50000)
+	for size := 0; size < 5<<20; size += 100 {
+		i := len(history)
+		history = append(history, map[string]interface{}{"order": fmt.Sprintf("order-%06d", i), "total": float64(i%997) * 1.5, "note": "regular order, shipped"})
+	}
+	data := map[string]interface{}{"Person": map[string]interface{}{"age": 70, "history": history}}
+
+	proxy := newCountingProxy(b, pe.BaseURL)
+	for _, variant := range []struct {
+		name string
+		opts []client.Option
+	}{
+		{"plain", nil},
+		{"gzip", []client.Option{client.WithCompression()}},
+	} {
+		c, err := client.New(proxy.URL, variant.opts...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(variant.name, func(b *testing.B) {
+			atomic.StoreInt64(&proxy.bytes, 0)
+			for i := 0; i < b.N; i++ {
+				if _, err := c.EvaluatePolicy(ctx, rule, data, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&proxy.bytes))/float64(b.N), "wire-bytes/op")
+		})
+	}
+}
+
+// BenchmarkConnectionStrategies compares the client's connection strategies
+// against the container. It is skipped unless POLICY_BENCH_CONNECTIONS is
+// set, since it is a report to read rather than a number to track; the report
+// is logged, with each strategy's throughput as a metric.
+func BenchmarkConnectionStrategies(b *testing.B) {
+	if os.Getenv("POLICY_BENCH_CONNECTIONS") == "" {
+		b.Skip("set POLICY_BENCH_CONNECTIONS=1 to compare connection strategies")
+	}
+	ctx := context.Background()
+
+	pe := SetupPolicyEngineT(b)
+
+	rule := "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+	report, err := policybench.ConnectionComparison{
+		BaseURL:     pe.BaseURL,
+		Concurrency: 32,
+		Requests:    5000,
+		Workload: func(ctx context.Context, c *client.PolicyClient) error {
+			_, err := c.EvaluatePolicy(ctx, rule, map[string]interface{}{"Person": map[string]interface{}{"age": 70}}, false)
+			return err
+		},
+	}.Run(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Logf("connection strategies:\n%s", report)
+	for _, result := range report {
+		if result.Err == nil {
+			b.ReportMetric(result.Throughput, "req/s-"+result.Strategy.String())
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Package policybench compares client configurations against a running
+// Policy Engine.
+package policybench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"policy-engine-testcontainer-example/client"
+)
+
+// Workload is one operation of a benchmark, typically a single evaluation
+type Workload func(ctx context.Context, c *client.PolicyClient) error
+
+// ConnectionComparison runs the same workload under each connection strategy
+// with a fresh client, so the strategies can be compared side by side
+type ConnectionComparison struct {
+	BaseURL string
+	// Options are applied to every client before the strategy, e.g.
+	// client.WithTLSConfig for an https engine
+	Options []client.Option
+	// Strategies default to HTTP1Pool, HTTP2Single and Auto
+	Strategies []client.ConnectionStrategy
+	// Concurrency is how many operations run at once; default 16
+	Concurrency int
+	// Requests is how many operations run per strategy; default 1000
+	Requests int
+	Workload Workload
+}
+
+// StrategyResult is how one strategy did
+type StrategyResult struct {
+	Strategy client.ConnectionStrategy
+	// Resolved is the strategy Auto settled on, or Strategy itself
+	Resolved   client.ConnectionStrategy
+	Requests   int
+	Errors     int
+	Elapsed    time.Duration
+	Throughput float64
+	P50        time.Duration
+	P99        time.Duration
+	// Err is set when the client could not be set up for the strategy, for
+	// example HTTP2Single against an engine without HTTP/2
+	Err error
+}
+
+// Report is the result of a comparison, one entry per strategy
+type Report []StrategyResult
+
+// String formats the report as a table
+func (r Report) String() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "strategy\tresolved\trequests\terrors\treq/s\tp50\tp99")
+	for _, result := range r {
+		if result.Err != nil {
+			// An untabbed last cell doesn't widen the columns
+			fmt.Fprintf(w, "%s\tunavailable: %v\n", result.Strategy, result.Err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.0f\t%s\t%s\n", result.Strategy, result.Resolved,
+			result.Requests, result.Errors, result.Throughput,
+			result.P50.Round(time.Microsecond), result.P99.Round(time.Microsecond))
+	}
+	w.Flush()
+	return b.String()
+}
+
+// Run measures every strategy in turn. It only fails if ctx is cancelled or
+// the comparison is incomplete; a strategy that can't be used is reported in
+// its result instead.
+func (cc ConnectionComparison) Run(ctx context.Context) (Report, error) {
+	if cc.Workload == nil {
+		return nil, errors.New("policybench: no workload")
+	}
+	strategies := cc.Strategies
+	if len(strategies) == 0 {
+		strategies = []client.ConnectionStrategy{client.HTTP1Pool, client.HTTP2Single, client.Auto}
+	}
+	concurrency := cc.Concurrency
+	if concurrency <= 0 {
+		concurrency = 16
+	}
+	requests := cc.Requests
+	if requests <= 0 {
+		requests = 1000
+	}
+
+	report := make(Report, 0, len(strategies))
+	for _, strategy := range strategies {
+		result := cc.measure(ctx, strategy, concurrency, requests)
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report = append(report, result)
+	}
+	return report, nil
+}
+
+func (cc ConnectionComparison) measure(ctx context.Context, strategy client.ConnectionStrategy, concurrency, requests int) StrategyResult {
+	result := StrategyResult{Strategy: strategy}
+
+	opts := append(append([]client.Option(nil), cc.Options...), client.WithConnectionStrategy(strategy))
+	c, err := client.New(cc.BaseURL, opts...)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer c.Close()
+	if err := c.Warmup(ctx); err != nil {
+		result.Err = err
+		return result
+	}
+	result.Resolved = c.ConnectionStrategy()
+
+	latencies := make([]time.Duration, requests)
+	errs := make([]bool, requests)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				began := time.Now()
+				errs[i] = cc.Workload(ctx, c) != nil
+				latencies[i] = time.Since(began)
+			}
+		}()
+	}
+	sent := 0
+feed:
+	for ; sent < requests; sent++ {
+		select {
+		case next <- sent:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	result.Elapsed = time.Since(start)
+
+	latencies = latencies[:sent]
+	for _, failed := range errs[:sent] {
+		if failed {
+			result.Errors++
+		}
+	}
+	result.Requests = sent
+	if result.Elapsed > 0 {
+		result.Throughput = float64(sent) / result.Elapsed.Seconds()
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	result.P50 = percentile(latencies, 0.50)
+	result.P99 = percentile(latencies, 0.99)
+	return result
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
@@ -0,0 +1,129 @@
+package policybench
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"policy-engine-testcontainer-example/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEngine(t *testing.T, http2 bool) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if r.URL.Path == "/health" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"result":true,"rule":["rule"],"data":null}`)
+	}))
+	server.EnableHTTP2 = http2
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func trusting(server *httptest.Server) client.Option {
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	return client.WithTLSConfig(&tls.Config{RootCAs: pool})
+}
+
+func evaluate(ctx context.Context, c *client.PolicyClient) error {
+	_, err := c.EvaluatePolicy(ctx, "rule", map[string]interface{}{"age": 70}, false)
+	return err
+}
+
+// TestConnectionComparison tests a side-by-side run against an engine speaking HTTP/2
+func TestConnectionComparison(t *testing.T) {
+	engine := newEngine(t, true)
+
+	report, err := ConnectionComparison{
+		BaseURL:     engine.URL,
+		Options:     []client.Option{trusting(engine)},
+		Concurrency: 4,
+		Requests:    100,
+		Workload:    evaluate,
+	}.Run(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, report, 3)
+	for i, want := range []client.ConnectionStrategy{client.HTTP1Pool, client.HTTP2Single, client.Auto} {
+		result := report[i]
+		require.NoError(t, result.Err, "%s", want)
+		assert.Equal(t, want, result.Strategy)
+		assert.Equal(t, 100, result.Requests)
+		assert.Zero(t, result.Errors)
+		assert.Positive(t, result.Throughput)
+		assert.LessOrEqual(t, result.P50, result.P99)
+	}
+	assert.Equal(t, client.HTTP2Single, report[2].Resolved, "Auto picks HTTP/2 when offered")
+
+	table := report.String()
+	assert.Regexp(t, `^strategy +resolved +requests +errors +req/s +p50 +p99\n`, table)
+	assert.Regexp(t, `\nauto +http2-single +100 +0 `, table)
+}
+
+// TestConnectionComparisonUnsupported tests that HTTP2Single against an HTTP/1.1 engine is reported, not fatal
+func TestConnectionComparisonUnsupported(t *testing.T) {
+	engine := newEngine(t, false)
+
+	report, err := ConnectionComparison{
+		BaseURL:    engine.URL,
+		Options:    []client.Option{trusting(engine)},
+		Strategies: []client.ConnectionStrategy{client.HTTP2Single, client.Auto},
+		Requests:   10,
+		Workload:   evaluate,
+	}.Run(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, report, 2)
+	assert.ErrorContains(t, report[0].Err, "not HTTP/2")
+	assert.Contains(t, report.String(), "http2-single  unavailable: warm-up failed")
+	assert.Equal(t, client.HTTP1Pool, report[1].Resolved)
+	assert.Zero(t, report[1].Errors)
+}
+
+// TestConnectionComparisonErrors tests workload failures and a missing workload
+func TestConnectionComparisonErrors(t *testing.T) {
+	engine := newEngine(t, true)
+
+	failing := errors.New("denied")
+	report, err := ConnectionComparison{
+		BaseURL:    engine.URL,
+		Options:    []client.Option{trusting(engine)},
+		Strategies: []client.ConnectionStrategy{client.Auto},
+		Requests:   20,
+		Workload:   func(ctx context.Context, c *client.PolicyClient) error { return failing },
+	}.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 20, report[0].Errors)
+
+	_, err = ConnectionComparison{BaseURL: engine.URL}.Run(context.Background())
+	assert.EqualError(t, err, "policybench: no workload")
+}
+
+// TestPercentile tests nearest-rank percentiles
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+	assert.Equal(t, 50*time.Millisecond, percentile(sorted, 0.50))
+	assert.Equal(t, 99*time.Millisecond, percentile(sorted, 0.99))
+	assert.Equal(t, time.Millisecond, percentile(sorted[:1], 0.99))
+	assert.Zero(t, percentile(nil, 0.5))
+}
@@ -0,0 +1,229 @@
+package policydata
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Aliases maps, per entity, the names fields encode to on the Go side (their
+// JSON keys) to the property names rule authors use, e.g.
+// Aliases{"Customer": {"Tier": "membership_level"}}
+type Aliases map[string]map[string]string
+
+// AliasConflictError is returned when an alias contradicts one already
+// registered: a field given two rule names, or two fields given one rule name
+type AliasConflictError struct {
+	Entity   string
+	GoName   string
+	RuleName string
+	// Existing is the name the other side is already registered with
+	Existing string
+}
+
+func (e *AliasConflictError) Error() string {
+	return fmt.Sprintf("policydata: alias %s.%s -> %s conflicts with %s", e.Entity, e.GoName, e.RuleName, e.Existing)
+}
+
+// AliasRegistry translates between Go-side field names and rule property
+// names in both directions: on encode so the engine sees the rule vocabulary,
+// and back again when interpreting traces. It implements Transform, so it can
+// be passed to ApplyTransforms or a client directly.
+//
+// A registry is safe for concurrent use, including Register while clients
+// apply it: lookups read an immutable snapshot that Register replaces whole.
+type AliasRegistry struct {
+	// mu serializes Register; readers never take it
+	mu      sync.Mutex
+	current atomic.Pointer[aliasIndex]
+}
+
+// aliasIndex is one immutable snapshot of a registry's aliases
+type aliasIndex struct {
+	toRule map[string]map[string]string
+	toGo   map[string]map[string]string
+}
+
+var emptyAliasIndex = &aliasIndex{}
+
+// index returns the current snapshot
+func (r *AliasRegistry) index() *aliasIndex {
+	if index := r.current.Load(); index != nil {
+		return index
+	}
+	return emptyAliasIndex
+}
+
+// with returns a copy of the index with the aliases added
+func (x *aliasIndex) with(aliases []alias) *aliasIndex {
+	next := &aliasIndex{toRule: copyIndex(x.toRule), toGo: copyIndex(x.toGo)}
+	for _, a := range aliases {
+		if next.toRule[a.entity] == nil {
+			next.toRule[a.entity] = map[string]string{}
+			next.toGo[a.entity] = map[string]string{}
+		}
+		next.toRule[a.entity][a.goName] = a.ruleName
+		next.toGo[a.entity][a.ruleName] = a.goName
+	}
+	return next
+}
+
+func copyIndex(index map[string]map[string]string) map[string]map[string]string {
+	copied := make(map[string]map[string]string, len(index))
+	for entity, names := range index {
+		copied[entity] = make(map[string]string, len(names))
+		for from, to := range names {
+			copied[entity][from] = to
+		}
+	}
+	return copied
+}
+
+type alias struct{ entity, goName, ruleName string }
+
+// NewAliasRegistry builds a registry from the given alias sets, failing with
+// an *AliasConflictError if any two definitions disagree
+func NewAliasRegistry(sets ...Aliases) (*AliasRegistry, error) {
+	r := &AliasRegistry{}
+	for _, set := range sets {
+		if err := r.Register(set); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register adds aliases to the registry. Repeating an identical definition is
+// allowed; a contradicting one fails with an *AliasConflictError and leaves
+// the registry unchanged.
+func (r *AliasRegistry) Register(aliases Aliases) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	index := r.index()
+
+	// Validate everything before changing anything, in a stable order so the
+	// reported conflict doesn't depend on map iteration
+	var pending []alias
+	for _, entity := range sortedKeys(aliases) {
+		fields := aliases[entity]
+		for _, goName := range sortedKeys(fields) {
+			pending = append(pending, alias{entity, goName, fields[goName]})
+		}
+	}
+
+	staged := map[string]string{}
+	for _, a := range pending {
+		if existing, ok := lookup(index.toRule, a.entity, a.goName); ok && existing != a.ruleName {
+			return &AliasConflictError{Entity: a.entity, GoName: a.goName, RuleName: a.ruleName, Existing: a.entity + "." + a.goName + " -> " + existing}
+		}
+		if existing, ok := lookup(index.toGo, a.entity, a.ruleName); ok && existing != a.goName {
+			return &AliasConflictError{Entity: a.entity, GoName: a.goName, RuleName: a.ruleName, Existing: a.entity + "." + existing + " -> " + a.ruleName}
+		}
+
+		key := a.entity + "\x00" + a.ruleName
+		if other, ok := staged[key]; ok && other != a.goName {
+			return &AliasConflictError{Entity: a.entity, GoName: a.goName, RuleName: a.ruleName, Existing: a.entity + "." + other + " -> " + a.ruleName}
+		}
+		staged[key] = a.goName
+	}
+
+	r.current.Store(index.with(pending))
+	return nil
+}
+
+func lookup(index map[string]map[string]string, entity, name string) (string, bool) {
+	value, ok := index[entity][name]
+	return value, ok
+}
+
+// RuleName returns the rule property name for a Go-side field of entity
+func (r *AliasRegistry) RuleName(entity, goName string) (string, bool) {
+	return lookup(r.index().toRule, entity, goName)
+}
+
+// GoName returns the Go-side field name behind a rule property of entity
+func (r *AliasRegistry) GoName(entity, ruleName string) (string, bool) {
+	return lookup(r.index().toGo, entity, ruleName)
+}
+
+// Describe names a rule property for people reading explanations, e.g.
+// "Tier (membership_level)"; unaliased properties are returned unchanged
+func (r *AliasRegistry) Describe(entity, ruleName string) string {
+	if goName, ok := r.GoName(entity, ruleName); ok {
+		return fmt.Sprintf("%s (%s)", goName, ruleName)
+	}
+	return ruleName
+}
+
+// DescribePath is Describe for the "$.Entity.property" paths found in engine
+// traces; unaliased paths and paths of any other shape are returned unchanged
+func (r *AliasRegistry) DescribePath(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "$."), ".")
+	if !strings.HasPrefix(path, "$.") || len(segments) != 2 {
+		return path
+	}
+	if _, ok := r.GoName(segments[0], segments[1]); !ok {
+		return path
+	}
+	return r.Describe(segments[0], segments[1])
+}
+
+// Apply renames aliased fields of each entity in doc to their rule names. An
+// entity holding an array has every element renamed. A field present under
+// both its Go name and its rule name is an error rather than a silent overwrite.
+func (r *AliasRegistry) Apply(doc map[string]interface{}) error {
+	for entity, fields := range r.index().toRule {
+		value, ok := doc[entity]
+		if !ok {
+			continue
+		}
+		if err := renameFields(entity, value, fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renameFields(entity string, node interface{}, fields map[string]string) error {
+	switch value := node.(type) {
+	case []interface{}:
+		for _, item := range value {
+			if err := renameFields(entity, item, fields); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		// Collect every move before applying any, so aliases that reuse each
+		// other's names (A -> B, B -> C) don't chain
+		moved := map[string]interface{}{}
+		for goName, ruleName := range fields {
+			if field, ok := value[goName]; ok {
+				moved[ruleName] = field
+			}
+		}
+		for _, ruleName := range sortedKeys(moved) {
+			_, exists := value[ruleName]
+			if _, renamed := fields[ruleName]; exists && !renamed {
+				return fmt.Errorf("policydata: %s has both %q and a field aliased to it", entity, ruleName)
+			}
+		}
+		for goName := range fields {
+			delete(value, goName)
+		}
+		for ruleName, field := range moved {
+			value[ruleName] = field
+		}
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,126 @@
+package policydata
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAliasRegistryConflicts tests that contradicting definitions fail at registration
+func TestAliasRegistryConflicts(t *testing.T) {
+	base := Aliases{"Customer": {"Tier": "membership_level"}}
+
+	_, err := NewAliasRegistry(base, Aliases{"Customer": {"Tier": "membership_level"}})
+	assert.NoError(t, err, "repeating a definition is fine")
+
+	// The same Go name may map differently on another entity
+	_, err = NewAliasRegistry(base, Aliases{"Account": {"Tier": "plan"}})
+	assert.NoError(t, err)
+
+	conflicts := []Aliases{
+		{"Customer": {"Tier": "level"}},
+		{"Customer": {"Level": "membership_level"}},
+	}
+	for _, conflict := range conflicts {
+		_, err := NewAliasRegistry(base, conflict)
+		var aliasErr *AliasConflictError
+		require.True(t, errors.As(err, &aliasErr), "expected AliasConflictError for %v, got %v", conflict, err)
+		assert.Equal(t, "Customer", aliasErr.Entity)
+	}
+
+	// Two fields claiming one rule name within a single set
+	_, err = NewAliasRegistry(Aliases{"Customer": {"Tier": "membership_level", "Level": "membership_level"}})
+	var aliasErr *AliasConflictError
+	assert.True(t, errors.As(err, &aliasErr))
+
+	// A failed registration leaves the registry as it was
+	registry, err := NewAliasRegistry(base)
+	require.NoError(t, err)
+	assert.Error(t, registry.Register(Aliases{"Customer": {"Region": "country", "Tier": "level"}}))
+	_, ok := registry.RuleName("Customer", "Region")
+	assert.False(t, ok)
+}
+
+// TestAliasRegistryApply tests the encode direction over objects and arrays
+func TestAliasRegistryApply(t *testing.T) {
+	registry, err := NewAliasRegistry(Aliases{
+		"Customer": {"Tier": "membership_level"},
+		"Order":    {"Amount": "total", "Total": "gross"},
+	})
+	require.NoError(t, err)
+
+	doc, err := ApplyTransforms(map[string]interface{}{
+		"Customer": map[string]interface{}{"Tier": "gold", "Country": "GB"},
+		"Order": []interface{}{
+			map[string]interface{}{"Amount": 10, "Total": 12},
+			map[string]interface{}{"Amount": 20},
+		},
+		"Tier": "top-level fields are not entity properties",
+	}, registry)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{
+		"Customer": map[string]interface{}{"membership_level": "gold", "Country": "GB"},
+		"Order": []interface{}{
+			// Renames don't chain: Amount becomes total, Total becomes gross
+			map[string]interface{}{"total": 10, "gross": 12},
+			map[string]interface{}{"total": 20},
+		},
+		"Tier": "top-level fields are not entity properties",
+	}, doc)
+
+	_, err = ApplyTransforms(map[string]interface{}{
+		"Customer": map[string]interface{}{"Tier": "gold", "membership_level": "silver"},
+	}, registry)
+	assert.Error(t, err)
+}
+
+// TestAliasRegistryConcurrentRegister tests that Register can run while documents are renamed
+func TestAliasRegistryConcurrentRegister(t *testing.T) {
+	registry, err := NewAliasRegistry(Aliases{"Customer": {"Tier": "membership_level"}})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				assert.NoError(t, registry.Register(Aliases{"Order": {fmt.Sprintf("F%d_%d", g, i): fmt.Sprintf("f%d_%d", g, i)}}))
+			}
+		}(g)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				doc := map[string]interface{}{"Customer": map[string]interface{}{"Tier": "gold"}, "Order": map[string]interface{}{"F0_0": 1}}
+				assert.NoError(t, registry.Apply(doc))
+				assert.Equal(t, "gold", doc["Customer"].(map[string]interface{})["membership_level"])
+			}
+		}()
+	}
+	wg.Wait()
+
+	name, ok := registry.RuleName("Order", "F3_49")
+	assert.True(t, ok)
+	assert.Equal(t, "f3_49", name)
+}
+
+// TestAliasRegistryDescribe tests the trace direction
+func TestAliasRegistryDescribe(t *testing.T) {
+	registry, err := NewAliasRegistry(Aliases{"Customer": {"Tier": "membership_level"}})
+	require.NoError(t, err)
+
+	goName, ok := registry.GoName("Customer", "membership_level")
+	assert.True(t, ok)
+	assert.Equal(t, "Tier", goName)
+
+	assert.Equal(t, "Tier (membership_level)", registry.Describe("Customer", "membership_level"))
+	assert.Equal(t, "country", registry.Describe("Customer", "country"))
+	assert.Equal(t, "Tier (membership_level)", registry.DescribePath("$.Customer.membership_level"))
+	assert.Equal(t, "$.Order.total", registry.DescribePath("$.Order.total"))
+	assert.Equal(t, "not a path", registry.DescribePath("not a path"))
+}
@@ -0,0 +1,244 @@
+package policydata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DuplicateKeyError reports object keys that appear more than once in the
+// same object of a JSON document. Which of the values a parser keeps is up to
+// the parser, so a document like {"age": 30, "age": 70} means different
+// things to different readers.
+type DuplicateKeyError struct {
+	// Pointers are the JSON pointers (RFC 6901) of every repeated key, once
+	// each, in document order
+	Pointers []string
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("policydata: duplicate keys at %s", strings.Join(e.Pointers, ", "))
+}
+
+// errInvalidJSON is what CheckDuplicateKeys reports for malformed input
+var errInvalidJSON = errors.New("policydata: invalid JSON")
+
+// CheckDuplicateKeys scans raw JSON for keys repeated within one object, at
+// any nesting level, and returns a *DuplicateKeyError listing them. Keys are
+// compared after unescaping, so "a" and "\u0061" are the same key. The scan
+// does not decode values, so it is cheap even for large documents.
+func CheckDuplicateKeys(raw []byte) error {
+	if !json.Valid(raw) {
+		return errInvalidJSON
+	}
+
+	s := duplicateScanner{raw: raw}
+	s.value()
+	if len(s.duplicates) > 0 {
+		return &DuplicateKeyError{Pointers: s.duplicates}
+	}
+	return nil
+}
+
+// pathToken is an object key, or an array index unless index is negative
+type pathToken struct {
+	key   []byte
+	index int
+}
+
+// duplicateScanner walks JSON already known to be valid, keeping the path
+// to the current value for pointers
+type duplicateScanner struct {
+	raw        []byte
+	pos        int
+	path       []pathToken
+	seen       []keySet
+	duplicates []string
+	// reported stops a key repeated three times being listed twice
+	reported map[string]bool
+}
+
+func (s *duplicateScanner) skipSpace() {
+	for s.pos < len(s.raw) {
+		switch s.raw[s.pos] {
+		case ' ', '\t', '\r', '\n':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (s *duplicateScanner) value() {
+	s.skipSpace()
+	switch s.raw[s.pos] {
+	case '{':
+		s.object()
+	case '[':
+		s.array()
+	case '"':
+		s.string()
+	default:
+		// A number or literal runs until the next delimiter
+		for s.pos < len(s.raw) {
+			switch s.raw[s.pos] {
+			case ',', ']', '}', ' ', '\t', '\r', '\n':
+				return
+			}
+			s.pos++
+		}
+	}
+}
+
+func (s *duplicateScanner) object() {
+	s.pos++ // {
+	depth := len(s.path)
+	for len(s.seen) <= depth {
+		s.seen = append(s.seen, keySet{})
+	}
+	s.seen[depth].reset()
+	for {
+		s.skipSpace()
+		if s.raw[s.pos] == '}' {
+			s.pos++
+			return
+		}
+		if s.raw[s.pos] == ',' {
+			s.pos++
+			s.skipSpace()
+		}
+
+		key := s.key()
+		s.path = append(s.path, pathToken{key: key, index: -1})
+		if s.seen[depth].add(key) {
+			s.report()
+		}
+
+		s.skipSpace()
+		s.pos++ // :
+		s.value()
+		s.path = s.path[:len(s.path)-1]
+	}
+}
+
+func (s *duplicateScanner) array() {
+	s.pos++ // [
+	for i := 0; ; i++ {
+		s.skipSpace()
+		if s.raw[s.pos] == ']' {
+			s.pos++
+			return
+		}
+		if s.raw[s.pos] == ',' {
+			s.pos++
+		}
+		s.path = append(s.path, pathToken{index: i})
+		s.value()
+		s.path = s.path[:len(s.path)-1]
+	}
+}
+
+// string skips a string, returning its raw bytes quotes included and whether
+// it holds escapes
+func (s *duplicateScanner) string() ([]byte, bool) {
+	start := s.pos
+	s.pos++ // "
+	escaped := false
+	for {
+		switch s.raw[s.pos] {
+		case '\\':
+			escaped = true
+			s.pos += 2
+		case '"':
+			s.pos++
+			return s.raw[start:s.pos], escaped
+		default:
+			s.pos++
+		}
+	}
+}
+
+// key reads an object key, unescaped; it points into the document unless it
+// held escapes
+func (s *duplicateScanner) key() []byte {
+	quoted, escaped := s.string()
+	if !escaped {
+		return quoted[1 : len(quoted)-1]
+	}
+	var key string
+	// Cannot fail: the document is valid
+	_ = json.Unmarshal(quoted, &key)
+	return []byte(key)
+}
+
+// keySetIndexAt is the key count beyond which a keySet indexes its keys;
+// below it a linear scan is cheaper than hashing
+const keySetIndexAt = 16
+
+// keySet holds the keys seen so far in one object. The scanner keeps one per
+// nesting depth and reuses it for every object at that depth, so most
+// objects are checked without allocating.
+type keySet struct {
+	keys  [][]byte
+	index map[string]struct{}
+}
+
+func (k *keySet) reset() {
+	k.keys = k.keys[:0]
+	if len(k.index) > 0 {
+		clear(k.index)
+	}
+}
+
+// add records key and reports whether it was already there
+func (k *keySet) add(key []byte) bool {
+	if len(k.keys) >= keySetIndexAt {
+		if _, dup := k.index[string(key)]; dup {
+			return true
+		}
+		k.index[string(key)] = struct{}{}
+		k.keys = append(k.keys, key)
+		return false
+	}
+
+	for _, seen := range k.keys {
+		if bytes.Equal(seen, key) {
+			return true
+		}
+	}
+	k.keys = append(k.keys, key)
+	if len(k.keys) == keySetIndexAt {
+		if k.index == nil {
+			k.index = make(map[string]struct{}, 2*keySetIndexAt)
+		}
+		for _, seen := range k.keys {
+			k.index[string(seen)] = struct{}{}
+		}
+	}
+	return false
+}
+
+// pointerEscaper escapes a JSON pointer reference token
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func (s *duplicateScanner) report() {
+	var pointer strings.Builder
+	for _, token := range s.path {
+		pointer.WriteByte('/')
+		if token.index >= 0 {
+			pointer.WriteString(strconv.Itoa(token.index))
+		} else {
+			pointer.WriteString(pointerEscaper.Replace(string(token.key)))
+		}
+	}
+	if s.reported == nil {
+		s.reported = map[string]bool{}
+	}
+	if !s.reported[pointer.String()] {
+		s.reported[pointer.String()] = true
+		s.duplicates = append(s.duplicates, pointer.String())
+	}
+}
@@ -0,0 +1,104 @@
+package policydata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCheckDuplicateKeys tests duplicates at every nesting level and the pointers reported for them
+func TestCheckDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		pointers []string
+	}{
+		{name: "none", raw: `{"age": 30, "Person": {"age": 30}, "list": [{"age": 1}, {"age": 2}]}`},
+		{name: "scalar", raw: `"age"`},
+		{name: "empty", raw: ` { } `},
+		{name: "top level", raw: `{"age": 30, "age": 70}`, pointers: []string{"/age"}},
+		{name: "nested", raw: `{"Person": {"address": {"city": "Leeds", "city": "York"}}}`, pointers: []string{"/Person/address/city"}},
+		{
+			name:     "arrays of objects",
+			raw:      `{"Order": {"items": [{"sku": "a"}, {"sku": "b", "qty": 1, "sku": "c"}, [{"x": 1, "x": 2}]]}}`,
+			pointers: []string{"/Order/items/1/sku", "/Order/items/2/0/x"},
+		},
+		{name: "repeated three times", raw: `{"a": 1, "a": 2, "a": 3}`, pointers: []string{"/a"}},
+		{name: "escaped spelling", raw: `{"age": 1, "\u0061ge": 2}`, pointers: []string{"/age"}},
+		{name: "pointer escaping", raw: `{"a/b": {"~c": 1, "~c": 2}, "a/b": 3}`, pointers: []string{"/a~1b/~0c", "/a~1b"}},
+		{name: "duplicate object values", raw: `{"p": {"a": 1}, "p": {"a": 1, "a": 1}}`, pointers: []string{"/p", "/p/a"}},
+		{name: "strings holding braces", raw: `{"a": "{\"b\": 1, \"b\": 2}", "c": "\\"}`},
+		{name: "whitespace", raw: "{\n  \"a\" : [ 1 , 2 ] ,\n  \"a\" : true\n}", pointers: []string{"/a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckDuplicateKeys([]byte(tt.raw))
+			if tt.pointers == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var dupErr *DuplicateKeyError
+			require.ErrorAs(t, err, &dupErr)
+			assert.Equal(t, tt.pointers, dupErr.Pointers)
+		})
+	}
+
+	err := CheckDuplicateKeys([]byte(`{"a": 1, "a": 2, "b": {"c": 1, "c": 1}}`))
+	assert.EqualError(t, err, "policydata: duplicate keys at /a, /b/c")
+
+	assert.EqualError(t, CheckDuplicateKeys([]byte(`{"a": 1,`)), "policydata: invalid JSON")
+	assert.Error(t, CheckDuplicateKeys(nil))
+}
+
+// largeDocument builds about size bytes of order history, repeating a key in
+// its last order
+func largeDocument(size int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"Customer": {"name": "Ada", "tags": ["a", "b"]}, "Orders": [`)
+	for i := 0; buf.Len() < size; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `{"id": %d, "total": %d.5, "note": "order \"%d\"", "items": [{"sku": "s%d", "qty": 2}]}`, i, i, i, i)
+	}
+	buf.WriteString(`, {"id": -1, "id": -2}]}`)
+	return buf.Bytes()
+}
+
+// TestCheckDuplicateKeysLarge tests a multi-megabyte document is scanned quickly and its one duplicate found
+func TestCheckDuplicateKeysLarge(t *testing.T) {
+	raw := largeDocument(8 << 20)
+	require.True(t, json.Valid(raw))
+
+	start := time.Now()
+	err := CheckDuplicateKeys(raw)
+	elapsed := time.Since(start)
+
+	var dupErr *DuplicateKeyError
+	require.ErrorAs(t, err, &dupErr)
+	require.Len(t, dupErr.Pointers, 1)
+	assert.Regexp(t, `^/Orders/\d+/id$`, dupErr.Pointers[0])
+
+	// Decoding the same document takes a good deal longer; the scan should
+	// not be the slow part of sending raw data
+	decodeStart := time.Now()
+	var decoded interface{}
+	require.NoError(t, json.Unmarshal(raw, &decoded))
+	assert.Less(t, elapsed, time.Since(decodeStart))
+}
+
+// BenchmarkCheckDuplicateKeys measures scanning throughput on an 8MB document
+func BenchmarkCheckDuplicateKeys(b *testing.B) {
+	raw := largeDocument(8 << 20)
+	b.SetBytes(int64(len(raw)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = CheckDuplicateKeys(raw)
+	}
+}
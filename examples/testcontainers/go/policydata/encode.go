@@ -0,0 +1,214 @@
+package policydata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// streamEncoder writes JSON one element at a time; see Encode
+type streamEncoder struct {
+	w          *bufio.Writer
+	escapeHTML bool
+	scratch    []byte
+	// keys holds one reusable key slice per nesting depth
+	keys  [][]string
+	depth int
+	// leaf holds values encoding/json encodes, see marshal
+	leaf bytes.Buffer
+}
+
+// EncodeConfig controls how EncodeWith writes JSON
+type EncodeConfig struct {
+	// EscapeHTML writes <, > and & inside strings as \u003c, \u003e and
+	// \u0026, as json.Marshal does so the JSON can be embedded in HTML.
+	// Anything comparing or hashing the encoded bytes sees the escapes.
+	EscapeHTML bool
+}
+
+// Encode writes data to w as JSON, descending into generic maps and slices one
+// element at a time so no buffer ever holds more than a single leaf value.
+// Keys are sorted and strings escaped exactly as json.Marshal does, so the
+// output is byte-for-byte identical to marshaling data in one go. A
+// *bufio.Writer is written to directly and left for the caller to flush.
+func Encode(w io.Writer, data interface{}) error {
+	return EncodeWith(w, data, EncodeConfig{EscapeHTML: true})
+}
+
+// EncodeWith is Encode with control over escaping; with cfg.EscapeHTML off its
+// output matches a json.Encoder with SetEscapeHTML(false), less the newline
+func EncodeWith(w io.Writer, data interface{}, cfg EncodeConfig) error {
+	if bw, ok := w.(*bufio.Writer); ok {
+		return (&streamEncoder{w: bw, escapeHTML: cfg.EscapeHTML}).encode(data)
+	}
+
+	bw := bufio.NewWriterSize(w, 32*1024)
+	if err := (&streamEncoder{w: bw, escapeHTML: cfg.EscapeHTML}).encode(data); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// EncodeBytes is EncodeWith into memory, for values small enough to hold
+func EncodeBytes(data interface{}, cfg EncodeConfig) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := EncodeWith(&buf, data, cfg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EstimateSize returns the number of bytes data occupies once encoded, without
+// holding the encoding in memory, so callers can make early decisions about
+// payloads that would exceed the engine's request limit
+func EstimateSize(data interface{}) (int64, error) {
+	counter := &byteCounter{}
+	if err := Encode(counter, data); err != nil {
+		return 0, err
+	}
+	return counter.n, nil
+}
+
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func (e *streamEncoder) encode(v interface{}) error {
+	switch value := v.(type) {
+	case nil:
+		_, err := e.w.WriteString("null")
+		return err
+	case map[string]interface{}:
+		if value == nil {
+			_, err := e.w.WriteString("null")
+			return err
+		}
+		return e.encodeMap(value)
+	case []interface{}:
+		if value == nil {
+			_, err := e.w.WriteString("null")
+			return err
+		}
+		e.w.WriteByte('[')
+		for i, item := range value {
+			if i > 0 {
+				e.w.WriteByte(',')
+			}
+			if err := e.encode(item); err != nil {
+				return err
+			}
+		}
+		return e.w.WriteByte(']')
+	case string:
+		return e.encodeString(value)
+	case bool:
+		e.scratch = strconv.AppendBool(e.scratch[:0], value)
+	case int:
+		e.scratch = strconv.AppendInt(e.scratch[:0], int64(value), 10)
+	case int64:
+		e.scratch = strconv.AppendInt(e.scratch[:0], value, 10)
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return e.marshal(value)
+		}
+		e.scratch = appendFloat(e.scratch[:0], value)
+	default:
+		return e.marshal(value)
+	}
+
+	_, err := e.w.Write(e.scratch)
+	return err
+}
+
+func (e *streamEncoder) encodeMap(value map[string]interface{}) error {
+	if e.depth == len(e.keys) {
+		e.keys = append(e.keys, nil)
+	}
+	keys := e.keys[e.depth][:0]
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	e.keys[e.depth] = keys
+
+	e.depth++
+	defer func() { e.depth-- }()
+
+	e.w.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			e.w.WriteByte(',')
+		}
+		if err := e.encodeString(key); err != nil {
+			return err
+		}
+		e.w.WriteByte(':')
+		if err := e.encode(value[key]); err != nil {
+			return err
+		}
+	}
+	return e.w.WriteByte('}')
+}
+
+// encodeString writes plain ASCII strings directly and leaves anything that
+// needs escaping to encoding/json so the escaping rules always match
+func (e *streamEncoder) encodeString(s string) error {
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if b < 0x20 || b >= utf8.RuneSelf || b == '"' || b == '\\' || (e.escapeHTML && (b == '<' || b == '>' || b == '&')) {
+			return e.marshal(s)
+		}
+	}
+
+	e.w.WriteByte('"')
+	e.w.WriteString(s)
+	return e.w.WriteByte('"')
+}
+
+func (e *streamEncoder) marshal(v interface{}) error {
+	if e.escapeHTML {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = e.w.Write(encoded)
+		return err
+	}
+
+	e.leaf.Reset()
+	encoder := json.NewEncoder(&e.leaf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return err
+	}
+	_, err := e.w.Write(bytes.TrimSuffix(e.leaf.Bytes(), []byte("\n")))
+	return err
+}
+
+// appendFloat formats f the way encoding/json does
+func appendFloat(b []byte, f float64) []byte {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, f, format, -1, 64)
+	if format == 'e' {
+		// Clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
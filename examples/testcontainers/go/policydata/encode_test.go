@@ -0,0 +1,68 @@
+package policydata
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEncodeMatchesMarshal tests that streamed output is byte-identical to json.Marshal
+func TestEncodeMatchesMarshal(t *testing.T) {
+	payloads := []interface{}{
+		nil,
+		map[string]interface{}{"b": 1, "a": []interface{}{"<tag>", nil, true}},
+		map[string]interface{}{"floats": []interface{}{0.0, -1.5, 1e-7, 1e21, 3.0e-10}, "int": int64(-9)},
+		map[string]interface{}{"strings": []interface{}{"quote\"", "tab\t", "caf\u00e9", "bad\xff", "<&>"}},
+		map[string]interface{}{"Person": struct {
+			Age int `json:"age"`
+		}{Age: 70}},
+	}
+
+	for i, data := range payloads {
+		want, err := json.Marshal(data)
+		require.NoError(t, err)
+
+		var got bytes.Buffer
+		require.NoError(t, Encode(&got, data))
+		assert.Equal(t, string(want), got.String(), "payload %d", i)
+	}
+}
+
+// TestEncodeWithoutHTMLEscaping tests that EncodeWith matches an encoder with HTML escaping off
+func TestEncodeWithoutHTMLEscaping(t *testing.T) {
+	data := map[string]interface{}{
+		"role":  "<admin>",
+		"terms": []interface{}{"a & b", json.RawMessage(`"<raw>"`)},
+		"Person": struct {
+			Note string `json:"note"`
+		}{Note: "x > y"},
+	}
+
+	var want bytes.Buffer
+	encoder := json.NewEncoder(&want)
+	encoder.SetEscapeHTML(false)
+	require.NoError(t, encoder.Encode(data))
+
+	got, err := EncodeBytes(data, EncodeConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, strings.TrimSuffix(want.String(), "\n"), string(got))
+	assert.Contains(t, string(got), `"<admin>"`)
+}
+
+// TestEstimateSize tests that the estimate is the exact encoded size
+func TestEstimateSize(t *testing.T) {
+	data := customerSnapshot()
+	encoded, err := json.Marshal(data)
+	require.NoError(t, err)
+
+	size, err := EstimateSize(data)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(encoded)), size)
+
+	_, err = EstimateSize(map[string]interface{}{"bad": make(chan int)})
+	assert.Error(t, err)
+}
@@ -0,0 +1,204 @@
+package policydata
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CanonicalHash returns a hex SHA-256 digest of data that depends only on its
+// JSON value: object key order, whitespace, and number spelling (1, 1.0 and
+// 1e0 are the same number) do not change it. It is meant for stable input
+// hashes in audit records, cache keys and duplicate detection.
+//
+// The canonical form is compact JSON with object keys sorted bytewise, strings
+// escaped without HTML escaping, integral numbers within the int64 range
+// written as plain digits and other numbers in shortest round-trip form, or
+// with all their significant digits when that form is a different number. It
+// is fixed by this package rather than by encoding/json, so digests stay stable
+// across Go versions.
+func CanonicalHash(data interface{}) (string, error) {
+	encoded, err := CanonicalJSON(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CanonicalJSON returns the canonical encoding CanonicalHash digests
+func CanonicalJSON(data interface{}) ([]byte, error) {
+	value, err := normalize(data)
+	if err != nil {
+		return nil, fmt.Errorf("policydata: cannot canonicalize data: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, value); err != nil {
+		return nil, fmt.Errorf("policydata: cannot canonicalize data: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		number, err := canonicalNumber(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(number)
+	case string:
+		writeCanonicalString(buf, v)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, key)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unexpected decoded type %T", value)
+	}
+	return nil
+}
+
+// canonicalNumber spells every integral number that fits an int64 as plain
+// digits, whichever way it was written, so 1, 1.0 and 1e0 agree and so do
+// 9007199254740993 and 9007199254740993.0. Every other number is written in
+// the shortest float64 form, unless that form is a different number, as for
+// a 20-digit decimal, which is written with all its significant digits.
+func canonicalNumber(n json.Number) (string, error) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return strconv.FormatInt(i, 10), nil
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return "", err
+	}
+	// Check integrality on the exact decimal rather than the rounded float;
+	// the range check keeps huge exponents away from big.Rat
+	if f != 0 && math.Abs(f) <= 1<<63 {
+		if r, ok := new(big.Rat).SetString(string(n)); ok && r.IsInt() && r.Num().IsInt64() {
+			return r.Num().String(), nil
+		}
+	}
+	if f == 0 {
+		return "0", nil
+	}
+	shortest := strconv.FormatFloat(f, 'g', -1, 64)
+	if !math.IsInf(f, 0) {
+		exact, ok := new(big.Rat).SetString(string(n))
+		if rounded, _ := new(big.Rat).SetString(shortest); ok && exact.Cmp(rounded) != 0 {
+			return exactDecimal(string(n)), nil
+		}
+	}
+	return shortest, nil
+}
+
+// exactDecimal writes the valid JSON number n with its significant digits
+// and nothing else, in e notation below 1e-6 and from 1e21, as the shortest
+// float64 form is, and plainly in between
+func exactDecimal(n string) string {
+	sign := ""
+	if n[0] == '-' {
+		sign, n = "-", n[1:]
+	}
+	exp := 0
+	if i := strings.IndexAny(n, "eE"); i >= 0 {
+		exp, _ = strconv.Atoi(strings.TrimPrefix(n[i+1:], "+"))
+		n = n[:i]
+	}
+	digits := n
+	if i := strings.IndexByte(n, '.'); i >= 0 {
+		digits = n[:i] + n[i+1:]
+		exp -= len(n) - i - 1
+	}
+	trimmed := strings.TrimRight(digits, "0")
+	exp += len(digits) - len(trimmed)
+	digits = strings.TrimLeft(trimmed, "0")
+
+	// The number is digits × 10^exp, and point is where its decimal point
+	// goes in digits
+	point := len(digits) + exp
+	switch {
+	case exp >= 0 && point <= 21:
+		return sign + digits + strings.Repeat("0", exp)
+	case exp < 0 && point > 0:
+		return sign + digits[:point] + "." + digits[point:]
+	case exp < 0 && point > -6:
+		return sign + "0." + strings.Repeat("0", -point) + digits
+	}
+	mantissa := digits[:1]
+	if len(digits) > 1 {
+		mantissa += "." + digits[1:]
+	}
+	e := point - 1
+	esign := "+"
+	if e < 0 {
+		esign, e = "-", -e
+	}
+	return fmt.Sprintf("%s%se%s%02d", sign, mantissa, esign, e)
+}
+
+// writeCanonicalString writes s as a JSON string, escaping only what JSON
+// requires. Invalid UTF-8 is replaced with U+FFFD, as encoding/json does.
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	const hexDigits = "0123456789abcdef"
+
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case r < 0x20:
+			buf.WriteString(`\u00`)
+			buf.WriteByte(hexDigits[r>>4])
+			buf.WriteByte(hexDigits[r&0xF])
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+}
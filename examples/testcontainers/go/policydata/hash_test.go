@@ -0,0 +1,88 @@
+package policydata
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCanonicalHashGolden pins the canonical form and digest so they can't drift between Go versions
+func TestCanonicalHashGolden(t *testing.T) {
+	cases := []struct {
+		data      interface{}
+		canonical string
+		hash      string
+	}{
+		{nil, `null`, "74234e98afe7498fb5daf1f36ac2d78acc339464f950703b8c019892f982b90b"},
+		{map[string]interface{}{}, `{}`, "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"},
+		{
+			map[string]interface{}{"Person": map[string]interface{}{"age": 70, "name": "Pat"}},
+			`{"Person":{"age":70,"name":"Pat"}}`,
+			"8d6733e7ab870eb22f8332a970275c62fcf24a65f2c8906afa8e8dd02e70ee21",
+		},
+		{
+			json.RawMessage(`{"Order": {"total": 150.0, "items": [1, 2.5, "x<y>&"]}, "Customer": {"membership_level": "gold"}}`),
+			`{"Customer":{"membership_level":"gold"},"Order":{"items":[1,2.5,"x<y>&"],"total":150}}`,
+			"6b0b560bd5e7836a5d057d2555315455ba818855de7d4e4ebf93c4c814bef612",
+		},
+		{
+			[]interface{}{true, false, nil, "café", "tab\tquote\"", 1e21, -0.000001, int64(9007199254740993)},
+			`[true,false,null,"café","tab\tquote\"",1e+21,-1e-06,9007199254740993]`,
+			"ddde0b2dba255afb3eca119aef2ce578c2755e2fa1217838ed32068883719ad7",
+		},
+		// Integers past 2^53 are digits whether or not the input had a fraction
+		// part, up to the int64 range
+		{json.RawMessage(`9007199254740992`), `9007199254740992`, "c681da39d7273a6a24c15c9cac3a75526ff2ecf8ba4ee60346a0c70c8163bdb2"},
+		{json.RawMessage(`9007199254740992.0`), `9007199254740992`, "c681da39d7273a6a24c15c9cac3a75526ff2ecf8ba4ee60346a0c70c8163bdb2"},
+		{json.RawMessage(`9007199254740993.0`), `9007199254740993`, "a1c367c29158357e62a3ff5d3e800fb7698a22396439dbc0a9d4929322afd35d"},
+		{json.RawMessage(`9.3e18`), `9.3e+18`, "7ff419e6dec4a3c43107dba910e7ca6c996f13c9d34ac04985537599b4ede435"},
+		{json.RawMessage(`-0.0`), `0`, "5feceb66ffc86f38d952786c6d696c79c2dbc239dd4e91b46729d73a27fb57e9"},
+		// Numbers no float64 holds exactly keep their significant digits
+		{json.RawMessage(`0.12345678901234567890`), `0.1234567890123456789`, "17f039b71a4edc4dee9ebc1279d933002871c05c3b944a5a6c9b9e33b16722a2"},
+		{json.RawMessage(`12345678901234567890`), `12345678901234567890`, "6ed645ef0e1abea1bf1e4e935ff04f9e18d39812387f63cda3415b46240f0405"},
+		{json.RawMessage(`123456789012345678901234567890`), `1.2345678901234567890123456789e+29`, "00f5922c4f60c750371b854311951ca1be41b66e960c31cb17b2ec9a5ccb743f"},
+		{json.RawMessage(`-0.0000001234567890123456789`), `-1.234567890123456789e-07`, "14c00ea804ff0ca80a98f6e5b06c551f024ac307d41c7aa9e4902b578d7c9ffb"},
+	}
+
+	for _, tc := range cases {
+		canonical, err := CanonicalJSON(tc.data)
+		require.NoError(t, err)
+		assert.Equal(t, tc.canonical, string(canonical))
+
+		hash, err := CanonicalHash(tc.data)
+		require.NoError(t, err)
+		assert.Equal(t, tc.hash, hash, tc.canonical)
+	}
+}
+
+// TestCanonicalHashEquivalence tests that representation differences don't change the hash
+func TestCanonicalHashEquivalence(t *testing.T) {
+	type person struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+
+	equivalent := []interface{}{
+		map[string]interface{}{"Person": map[string]interface{}{"age": 70, "name": "Pat"}},
+		map[string]interface{}{"Person": person{Name: "Pat", Age: 70}},
+		json.RawMessage("{\n  \"Person\": { \"name\": \"Pat\", \"age\": 70.0 }\n}"),
+		json.RawMessage(`{"Person":{"age":7e1,"name":"Pat"}}`),
+	}
+
+	want, err := CanonicalHash(equivalent[0])
+	require.NoError(t, err)
+	for _, data := range equivalent[1:] {
+		got, err := CanonicalHash(data)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "%v", data)
+	}
+
+	different, err := CanonicalHash(map[string]interface{}{"Person": map[string]interface{}{"age": "70", "name": "Pat"}})
+	require.NoError(t, err)
+	assert.NotEqual(t, want, different, "a string and a number must not collide")
+
+	_, err = CanonicalHash(make(chan int))
+	assert.Error(t, err)
+}
@@ -0,0 +1,321 @@
+package policydata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// utf8BOM is stripped from the start of loaded files; spreadsheet exports
+// often begin with one
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// RowError reports a record that could not be loaded. Line is the 1-based line
+// the record starts on in the input.
+type RowError struct {
+	Line   int
+	Column string
+	Err    error
+}
+
+func (e *RowError) Error() string {
+	if e.Column != "" {
+		return fmt.Sprintf("line %d: column %q: %v", e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+type loadConfig struct {
+	skipMalformed bool
+}
+
+// LoadOption configures LoadNDJSON and LoadCSV
+type LoadOption func(*loadConfig)
+
+// SkipMalformed keeps loading after a malformed record. The record's
+// *RowError is still yielded, so it can be reported, but it no longer ends the
+// sequence; by default the first malformed record is fatal.
+func SkipMalformed() LoadOption {
+	return func(c *loadConfig) {
+		c.skipMalformed = true
+	}
+}
+
+func newLoadConfig(opts []LoadOption) loadConfig {
+	var cfg loadConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// LoadNDJSON yields one data document per line of newline-delimited JSON.
+// Blank lines are ignored and numbers are kept as json.Number. Records are
+// read lazily, so arbitrarily large files can be streamed into an evaluation.
+func LoadNDJSON(r io.Reader, opts ...LoadOption) iter.Seq2[interface{}, error] {
+	cfg := newLoadConfig(opts)
+
+	return func(yield func(interface{}, error) bool) {
+		reader := bufio.NewReader(r)
+		for line := 1; ; line++ {
+			text, readErr := reader.ReadBytes('\n')
+			if readErr != nil && readErr != io.EOF {
+				yield(nil, fmt.Errorf("policydata: reading line %d: %w", line, readErr))
+				return
+			}
+			if line == 1 {
+				text = bytes.TrimPrefix(text, utf8BOM)
+			}
+
+			if text = bytes.TrimSpace(text); len(text) > 0 {
+				value, err := decodeLine(text)
+				if err != nil {
+					if !yield(nil, &RowError{Line: line, Err: err}) || !cfg.skipMalformed {
+						return
+					}
+				} else if !yield(value, nil) {
+					return
+				}
+			}
+
+			if readErr == io.EOF {
+				return
+			}
+		}
+	}
+}
+
+func decodeLine(text []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(text))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("more than one JSON value on the line")
+	}
+	return value, nil
+}
+
+// CSVType selects how a CSV cell is coerced before it is placed in the data
+type CSVType string
+
+const (
+	// CSVString keeps the cell as a string
+	CSVString CSVType = "string"
+	// CSVNumber parses the cell as a number
+	CSVNumber CSVType = "number"
+	// CSVBool parses the cell with strconv.ParseBool
+	CSVBool CSVType = "bool"
+	// CSVDate parses the cell with the column's Layout and sends it in the
+	// engine's YYYY-MM-DD date form
+	CSVDate CSVType = "date"
+)
+
+// CSVColumn maps one CSV column onto a dotted entity.property path
+type CSVColumn struct {
+	// Column is the header name of the column
+	Column string `yaml:"column"`
+	// Path is where the value goes, e.g. "Order.total"
+	Path string `yaml:"path"`
+	// Type is the coercion applied to the cell; empty means CSVString
+	Type CSVType `yaml:"type"`
+	// Layout is the time layout for CSVDate cells; empty means "2006-01-02"
+	Layout string `yaml:"layout"`
+	// Optional leaves the property out for empty cells instead of failing
+	// coercion (or, for strings, sending "")
+	Optional bool `yaml:"optional"`
+}
+
+// CSVMapping describes how the rows of a CSV file become data documents.
+// Columns not listed are ignored.
+type CSVMapping struct {
+	Columns []CSVColumn `yaml:"columns"`
+	// Comma is the field delimiter; zero means ','
+	Comma rune `yaml:"comma"`
+}
+
+// LoadCSV yields one data document per CSV record after the header row,
+// placing each mapped cell at its path with the column's type coercion.
+// Quoted fields, embedded commas and a leading BOM are handled; mismatched
+// field counts and coercion failures are reported as *RowError.
+func LoadCSV(r io.Reader, mapping CSVMapping, opts ...LoadOption) iter.Seq2[interface{}, error] {
+	cfg := newLoadConfig(opts)
+
+	return func(yield func(interface{}, error) bool) {
+		reader := csv.NewReader(skipBOM(r))
+		reader.ReuseRecord = true
+		if mapping.Comma != 0 {
+			reader.Comma = mapping.Comma
+		}
+
+		header, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("missing header row")
+			}
+			yield(nil, fmt.Errorf("policydata: reading CSV header: %w", err))
+			return
+		}
+
+		columns, err := resolveColumns(header, mapping)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return
+			}
+
+			var doc map[string]interface{}
+			if err == nil {
+				line, _ := reader.FieldPos(0)
+				doc, err = buildRow(record, columns, line)
+			} else {
+				var parseErr *csv.ParseError
+				if !errors.As(err, &parseErr) {
+					yield(nil, fmt.Errorf("policydata: reading CSV: %w", err))
+					return
+				}
+				err = &RowError{Line: parseErr.StartLine, Err: parseErr.Err}
+			}
+
+			if err != nil {
+				if !yield(nil, err) || !cfg.skipMalformed {
+					return
+				}
+				continue
+			}
+			if !yield(doc, nil) {
+				return
+			}
+		}
+	}
+}
+
+// resolvedColumn is a mapped column with its position in the header
+type resolvedColumn struct {
+	CSVColumn
+	index    int
+	segments []string
+}
+
+func resolveColumns(header []string, mapping CSVMapping) ([]resolvedColumn, error) {
+	positions := make(map[string]int, len(header))
+	for i, name := range header {
+		positions[strings.TrimSpace(name)] = i
+	}
+
+	columns := make([]resolvedColumn, 0, len(mapping.Columns))
+	for _, column := range mapping.Columns {
+		index, ok := positions[column.Column]
+		if !ok {
+			return nil, fmt.Errorf("policydata: mapped column %q is not in the CSV header", column.Column)
+		}
+		if column.Path == "" {
+			return nil, fmt.Errorf("policydata: mapped column %q has no path", column.Column)
+		}
+		switch column.Type {
+		case "", CSVString, CSVNumber, CSVBool, CSVDate:
+		default:
+			return nil, fmt.Errorf("policydata: mapped column %q has unknown type %q", column.Column, column.Type)
+		}
+		columns = append(columns, resolvedColumn{CSVColumn: column, index: index, segments: splitPath(column.Path)})
+	}
+	return columns, nil
+}
+
+func buildRow(record []string, columns []resolvedColumn, line int) (map[string]interface{}, error) {
+	doc := map[string]interface{}{}
+	for _, column := range columns {
+		cell := record[column.index]
+		if cell == "" && column.Optional {
+			continue
+		}
+
+		value, err := coerceCell(cell, column.CSVColumn)
+		if err != nil {
+			return nil, &RowError{Line: line, Column: column.Column, Err: err}
+		}
+		if err := setPath(doc, column.segments, value); err != nil {
+			return nil, &RowError{Line: line, Column: column.Column, Err: err}
+		}
+	}
+	return doc, nil
+}
+
+func coerceCell(cell string, column CSVColumn) (interface{}, error) {
+	switch column.Type {
+	case CSVNumber:
+		trimmed := strings.TrimSpace(cell)
+		if _, err := strconv.ParseFloat(trimmed, 64); err != nil {
+			return nil, fmt.Errorf("%q is not a number", cell)
+		}
+		return json.Number(trimmed), nil
+	case CSVBool:
+		value, err := strconv.ParseBool(strings.TrimSpace(cell))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", cell)
+		}
+		return value, nil
+	case CSVDate:
+		layout := column.Layout
+		if layout == "" {
+			layout = "2006-01-02"
+		}
+		date, err := time.Parse(layout, strings.TrimSpace(cell))
+		if err != nil {
+			return nil, fmt.Errorf("%q does not match date layout %q", cell, layout)
+		}
+		return date.Format("2006-01-02"), nil
+	default:
+		return cell, nil
+	}
+}
+
+// setPath stores value at the dotted path, creating intermediate objects
+func setPath(doc map[string]interface{}, segments []string, value interface{}) error {
+	node := doc
+	for i, segment := range segments[:len(segments)-1] {
+		child, exists := node[segment]
+		if !exists {
+			next := map[string]interface{}{}
+			node[segment] = next
+			node = next
+			continue
+		}
+		next, ok := child.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("path %s already holds a value", strings.Join(segments[:i+1], "."))
+		}
+		node = next
+	}
+	node[segments[len(segments)-1]] = value
+	return nil
+}
+
+// skipBOM drops a leading UTF-8 byte order mark
+func skipBOM(r io.Reader) io.Reader {
+	reader := bufio.NewReader(r)
+	if prefix, err := reader.Peek(len(utf8BOM)); err == nil && bytes.Equal(prefix, utf8BOM) {
+		_, _ = reader.Discard(len(utf8BOM))
+	}
+	return reader
+}
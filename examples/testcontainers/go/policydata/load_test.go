@@ -0,0 +1,138 @@
+package policydata
+
+import (
+	"encoding/json"
+	"errors"
+	"iter"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collect drains a loader, splitting documents from errors
+func collect(seq iter.Seq2[interface{}, error]) ([]interface{}, []error) {
+	var docs []interface{}
+	var errs []error
+	for doc, err := range seq {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	return docs, errs
+}
+
+var orderMapping = CSVMapping{Columns: []CSVColumn{
+	{Column: "order_total", Path: "Order.total", Type: CSVNumber},
+	{Column: "tier", Path: "Customer.membership_level"},
+	{Column: "express", Path: "Order.express", Type: CSVBool, Optional: true},
+	{Column: "placed", Path: "Order.placed", Type: CSVDate, Layout: "02/01/2006"},
+}}
+
+// TestLoadNDJSON tests that each line becomes a document and blank lines are ignored
+func TestLoadNDJSON(t *testing.T) {
+	input := "\ufeff{\"Order\": {\"total\": 150.50}}\n\n{\"Order\": {\"total\": 80}}\r\n{\"Order\": {\"total\": 20}}"
+
+	docs, errs := collect(LoadNDJSON(strings.NewReader(input)))
+	require.Empty(t, errs)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"Order": map[string]interface{}{"total": json.Number("150.50")}},
+		map[string]interface{}{"Order": map[string]interface{}{"total": json.Number("80")}},
+		map[string]interface{}{"Order": map[string]interface{}{"total": json.Number("20")}},
+	}, docs)
+}
+
+// TestLoadNDJSONMalformed tests line numbers and the skip and fatal modes
+func TestLoadNDJSONMalformed(t *testing.T) {
+	input := "{\"a\": 1}\n{\"a\": \n{\"a\": 3}\n{\"a\": 4} {\"a\": 5}\n"
+
+	docs, errs := collect(LoadNDJSON(strings.NewReader(input)))
+	assert.Len(t, docs, 1)
+	require.Len(t, errs, 1)
+	var rowErr *RowError
+	require.True(t, errors.As(errs[0], &rowErr))
+	assert.Equal(t, 2, rowErr.Line)
+
+	docs, errs = collect(LoadNDJSON(strings.NewReader(input), SkipMalformed()))
+	assert.Len(t, docs, 2)
+	require.Len(t, errs, 2)
+	assert.True(t, errors.As(errs[1], &rowErr))
+	assert.Equal(t, 4, rowErr.Line)
+}
+
+// TestLoadCSV tests coercion, quoted commas, BOMs and optional cells
+func TestLoadCSV(t *testing.T) {
+	input := "\ufefforder_total,tier,express,placed,ignored\n" +
+		"150.50,gold,true,31/01/2024,x\n" +
+		"80,\"silver, legacy\",,01/02/2024,\"quoted \"\"value\"\"\"\n"
+
+	docs, errs := collect(LoadCSV(strings.NewReader(input), orderMapping))
+	require.Empty(t, errs)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"Order":    map[string]interface{}{"total": json.Number("150.50"), "express": true, "placed": "2024-01-31"},
+			"Customer": map[string]interface{}{"membership_level": "gold"},
+		},
+		map[string]interface{}{
+			"Order":    map[string]interface{}{"total": json.Number("80"), "placed": "2024-02-01"},
+			"Customer": map[string]interface{}{"membership_level": "silver, legacy"},
+		},
+	}, docs)
+}
+
+// TestLoadCSVCoercionFailures tests that bad cells are reported by line and column
+func TestLoadCSVCoercionFailures(t *testing.T) {
+	input := "order_total,tier,express,placed\n" +
+		"lots,gold,true,31/01/2024\n" +
+		"10,gold,maybe,31/01/2024\n" +
+		"10,gold,true,2024-01-31\n" +
+		"\"multi\nline\",gold,true,31/01/2024\n" +
+		"10,gold\n" +
+		"20,gold,false,01/01/2024\n"
+
+	docs, errs := collect(LoadCSV(strings.NewReader(input), orderMapping, SkipMalformed()))
+	assert.Len(t, docs, 1)
+
+	want := []struct {
+		line   int
+		column string
+	}{
+		{2, "order_total"},
+		{3, "express"},
+		{4, "placed"},
+		{5, "order_total"},
+		{7, ""},
+	}
+	require.Len(t, errs, len(want))
+	for i, w := range want {
+		var rowErr *RowError
+		require.True(t, errors.As(errs[i], &rowErr), "error %d: %v", i, errs[i])
+		assert.Equal(t, w.line, rowErr.Line, "error %d", i)
+		assert.Equal(t, w.column, rowErr.Column, "error %d", i)
+	}
+
+	// Without SkipMalformed the first bad row ends the load
+	docs, errs = collect(LoadCSV(strings.NewReader(input), orderMapping))
+	assert.Empty(t, docs)
+	assert.Len(t, errs, 1)
+}
+
+// TestLoadCSVMappingErrors tests that mappings that don't fit the header fail up front
+func TestLoadCSVMappingErrors(t *testing.T) {
+	mappings := []CSVMapping{
+		{Columns: []CSVColumn{{Column: "missing", Path: "Order.total"}}},
+		{Columns: []CSVColumn{{Column: "total"}}},
+		{Columns: []CSVColumn{{Column: "total", Path: "Order.total", Type: "money"}}},
+	}
+	for _, mapping := range mappings {
+		docs, errs := collect(LoadCSV(strings.NewReader("total\n10\n"), mapping, SkipMalformed()))
+		assert.Empty(t, docs)
+		assert.Len(t, errs, 1)
+	}
+
+	_, errs := collect(LoadCSV(strings.NewReader(""), orderMapping))
+	assert.Len(t, errs, 1)
+}
@@ -0,0 +1,279 @@
+package policydata
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Marshal converts a tagged struct into the object-keyed document the engine
+// expects. Fields are placed with `policy:"Entity.property"` tags:
+//
+//	type Application struct {
+//		Age   int         `policy:"Person.age"`
+//		Items []LineItem  `policy:"Order.items[]"`
+//		Notes *string     `policy:"Order.notes"`
+//	}
+//
+// A trailing [] marks a collection: each element becomes a property map of its
+// own, built from the element's relative policy tags (`policy:"price"`), or
+// from its JSON encoding when the element type has no policy tags. Empty and
+// nil collections are sent as [] so counting conditions see zero items.
+//
+// Untagged struct fields are descended into, so tags on nested and embedded
+// structs use absolute paths too; other untagged fields are ignored, as are
+// fields tagged "-". Nil pointers and interfaces are omitted, and the
+// omitempty option omits zero values. time.Time values are sent as RFC3339,
+// or as the engine's YYYY-MM-DD date with the date option
+// (`policy:"Person.birth_date,date"`). Any other value is kept as it is.
+func Marshal(v interface{}) (map[string]interface{}, error) {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil, fmt.Errorf("policydata: cannot marshal nil %T", v)
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("policydata: Marshal expects a struct, got %T", v)
+	}
+
+	doc := map[string]interface{}{}
+	if err := marshalStruct(doc, value, ""); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// policyTag is a parsed policy struct tag
+type policyTag struct {
+	path       string
+	collection bool
+	omitEmpty  bool
+	date       bool
+}
+
+func parsePolicyTag(tag string) policyTag {
+	parts := strings.Split(tag, ",")
+	parsed := policyTag{path: parts[0]}
+	if strings.HasSuffix(parsed.path, "[]") {
+		parsed.path = strings.TrimSuffix(parsed.path, "[]")
+		parsed.collection = true
+	}
+	for _, option := range parts[1:] {
+		switch option {
+		case "omitempty":
+			parsed.omitEmpty = true
+		case "date":
+			parsed.date = true
+		}
+	}
+	return parsed
+}
+
+// marshalStruct places the tagged fields of value into doc; where names the
+// element being marshalled in errors
+func marshalStruct(doc map[string]interface{}, value reflect.Value, where string) error {
+	typ := value.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag, tagged := field.Tag.Lookup("policy")
+		if tag == "-" {
+			continue
+		}
+		fieldValue := value.Field(i)
+		if !tagged {
+			// Descend into untagged structs so their tags count too
+			if inner, ok := indirectStruct(fieldValue); ok && !isTime(inner) {
+				if err := marshalStruct(doc, inner, where); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		parsed := parsePolicyTag(tag)
+		if parsed.path == "" {
+			return fmt.Errorf("policydata: field %s has an empty policy path", field.Name)
+		}
+		encoded, keep, err := marshalField(fieldValue, parsed, where+parsed.path)
+		if err != nil {
+			return err
+		}
+		if !keep {
+			continue
+		}
+		if _, exists := Lookup(doc, parsed.path); exists {
+			return fmt.Errorf("policydata: %s is tagged on more than one field", where+parsed.path)
+		}
+		if err := setPath(doc, splitPath(parsed.path), encoded); err != nil {
+			return fmt.Errorf("policydata: field %s: %w", field.Name, err)
+		}
+	}
+	return nil
+}
+
+// marshalField encodes one tagged field, reporting whether it should be kept
+func marshalField(value reflect.Value, tag policyTag, where string) (interface{}, bool, error) {
+	if (value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface) && value.IsNil() {
+		if tag.collection {
+			return []interface{}{}, !tag.omitEmpty, nil
+		}
+		return nil, false, nil
+	}
+	if tag.omitEmpty && value.IsZero() {
+		return nil, false, nil
+	}
+
+	if tag.collection {
+		return marshalCollection(value, where)
+	}
+
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		value = value.Elem()
+	}
+	if isTime(value) {
+		t := value.Interface().(time.Time)
+		if tag.date {
+			return t.Format("2006-01-02"), true, nil
+		}
+		return t.Format(time.RFC3339), true, nil
+	}
+	return value.Interface(), true, nil
+}
+
+// marshalCollection turns a slice or array into an array of property maps
+func marshalCollection(value reflect.Value, where string) (interface{}, bool, error) {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return nil, false, fmt.Errorf("policydata: %s[] is tagged on a %s, not a slice", where, value.Type())
+	}
+
+	elements := make([]interface{}, value.Len())
+	for i := range elements {
+		element, err := marshalElement(value.Index(i), fmt.Sprintf("%s[%d].", where, i))
+		if err != nil {
+			return nil, false, err
+		}
+		elements[i] = element
+	}
+	return elements, true, nil
+}
+
+// marshalElement encodes one collection element on its own
+func marshalElement(value reflect.Value, where string) (interface{}, error) {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil, nil
+		}
+		value = value.Elem()
+	}
+
+	if value.Kind() == reflect.Struct && !isTime(value) && hasPolicyTags(value.Type()) {
+		element := map[string]interface{}{}
+		if err := marshalStruct(element, value, where); err != nil {
+			return nil, err
+		}
+		return element, nil
+	}
+
+	normalized, err := normalize(value.Interface())
+	if err != nil {
+		return nil, fmt.Errorf("policydata: %s: %w", strings.TrimSuffix(where, "."), err)
+	}
+	return normalized, nil
+}
+
+// hasPolicyTags reports whether typ or any untagged struct inside it carries
+// policy tags. Struct types already being inspected are skipped, so
+// self-referential types such as linked lists terminate.
+func hasPolicyTags(typ reflect.Type) bool {
+	return hasPolicyTagsVisiting(typ, map[reflect.Type]bool{})
+}
+
+func hasPolicyTagsVisiting(typ reflect.Type, visiting map[reflect.Type]bool) bool {
+	if visiting[typ] {
+		return false
+	}
+	visiting[typ] = true
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if _, ok := field.Tag.Lookup("policy"); ok {
+			return true
+		}
+		inner := field.Type
+		for inner.Kind() == reflect.Pointer {
+			inner = inner.Elem()
+		}
+		if inner.Kind() == reflect.Struct && inner != timeType && hasPolicyTagsVisiting(inner, visiting) {
+			return true
+		}
+	}
+	return false
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func isTime(value reflect.Value) bool {
+	return value.Type() == timeType
+}
+
+// indirectStruct follows pointers to a struct value
+func indirectStruct(value reflect.Value) (reflect.Value, bool) {
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return reflect.Value{}, false
+		}
+		value = value.Elem()
+	}
+	return value, value.Kind() == reflect.Struct
+}
+
+// ElementIssue is a problem with one element of a collection, addressed by
+// index, e.g. "Order.items[3].price missing"
+type ElementIssue struct {
+	Path    string
+	Problem string
+}
+
+func (i ElementIssue) String() string {
+	return i.Path + " " + i.Problem
+}
+
+// ValidateElements checks that the collection at path (e.g. "Order.items") is
+// an array whose every element is an object holding each of the properties
+// a rule reads inside it. Issues are reported in element order.
+func ValidateElements(doc map[string]interface{}, path string, properties ...string) []ElementIssue {
+	value, ok := Lookup(doc, path)
+	if !ok {
+		return []ElementIssue{{Path: path, Problem: "missing"}}
+	}
+	elements, ok := value.([]interface{})
+	if !ok {
+		return []ElementIssue{{Path: path, Problem: fmt.Sprintf("is %T, not an array", value)}}
+	}
+
+	var issues []ElementIssue
+	for i, element := range elements {
+		elementPath := fmt.Sprintf("%s[%d]", path, i)
+		object, ok := element.(map[string]interface{})
+		if !ok {
+			issues = append(issues, ElementIssue{Path: elementPath, Problem: "is not an object"})
+			continue
+		}
+		for _, property := range properties {
+			if _, ok := Lookup(object, property); !ok {
+				issues = append(issues, ElementIssue{Path: elementPath + "." + property, Problem: "missing"})
+			}
+		}
+	}
+	return issues
+}
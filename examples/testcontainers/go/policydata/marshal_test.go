@@ -0,0 +1,188 @@
+package policydata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type lineItem struct {
+	SKU   string  `policy:"sku"`
+	Price float64 `policy:"price"`
+	Gift  *bool   `policy:"gift"`
+}
+
+type plainItem struct {
+	SKU   string  `json:"sku"`
+	Price float64 `json:"price"`
+}
+
+type shippingAddress struct {
+	Country string `policy:"Order.country"`
+}
+
+type order struct {
+	Total    float64         `policy:"Order.total"`
+	Items    []lineItem      `policy:"Order.items[]"`
+	Level    string          `policy:"Customer.membership_level"`
+	Nickname string          `policy:"Customer.nickname,omitempty"`
+	Coupon   *string         `policy:"Order.coupon"`
+	Placed   time.Time       `policy:"Order.placed"`
+	Birthday time.Time       `policy:"Customer.birth_date,date"`
+	Address  shippingAddress // untagged: its own tags are absolute
+	Internal string          `policy:"-"`
+	Ignored  string
+}
+
+// TestMarshalCollections tests that tagged slices become arrays of property maps
+func TestMarshalCollections(t *testing.T) {
+	yes := true
+	doc, err := Marshal(order{
+		Total: 150,
+		Items: []lineItem{
+			{SKU: "A-1", Price: 20},
+			{SKU: "B-2", Price: 130, Gift: &yes},
+		},
+		Level:    "gold",
+		Placed:   time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC),
+		Birthday: time.Date(1950, 1, 2, 0, 0, 0, 0, time.UTC),
+		Address:  shippingAddress{Country: "GB"},
+		Internal: "never sent",
+		Ignored:  "never sent",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{
+		"Order": map[string]interface{}{
+			"total":   150.0,
+			"country": "GB",
+			"placed":  "2024-03-15T10:30:00Z",
+			"items": []interface{}{
+				map[string]interface{}{"sku": "A-1", "price": 20.0},
+				map[string]interface{}{"sku": "B-2", "price": 130.0, "gift": true},
+			},
+		},
+		"Customer": map[string]interface{}{
+			"membership_level": "gold",
+			"birth_date":       "1950-01-02",
+		},
+	}, doc)
+}
+
+// TestMarshalEmptyCollections tests that nil and empty slices are sent as empty arrays
+func TestMarshalEmptyCollections(t *testing.T) {
+	for _, items := range [][]lineItem{nil, {}} {
+		doc, err := Marshal(&order{Items: items})
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{}, doc["Order"].(map[string]interface{})["items"])
+	}
+
+	type optional struct {
+		Items []lineItem `policy:"Order.items[],omitempty"`
+	}
+	doc, err := Marshal(optional{})
+	require.NoError(t, err)
+	assert.Empty(t, doc)
+}
+
+// TestMarshalHeterogeneousElements tests elements of differing shapes within one collection
+func TestMarshalHeterogeneousElements(t *testing.T) {
+	type basket struct {
+		Items []interface{} `policy:"Order.items[]"`
+	}
+
+	doc, err := Marshal(basket{Items: []interface{}{
+		lineItem{SKU: "A-1", Price: 20},
+		&plainItem{SKU: "B-2", Price: 5},
+		map[string]interface{}{"sku": "C-3"},
+		nil,
+	}})
+	require.NoError(t, err)
+
+	items := doc["Order"].(map[string]interface{})["items"].([]interface{})
+	require.Len(t, items, 4)
+	assert.Equal(t, map[string]interface{}{"sku": "A-1", "price": 20.0}, items[0])
+	assert.Equal(t, "B-2", items[1].(map[string]interface{})["sku"])
+	assert.Equal(t, map[string]interface{}{"sku": "C-3"}, items[2])
+	assert.Nil(t, items[3])
+
+	issues := ValidateElements(doc, "Order.items", "sku", "price")
+	assert.Equal(t, []string{"Order.items[2].price missing", "Order.items[3] is not an object"}, issueStrings(issues))
+}
+
+// TestMarshalSelfReferentialElements tests that element types referring to
+// themselves are inspected without recursing forever
+func TestMarshalSelfReferentialElements(t *testing.T) {
+	type node struct {
+		Name string `json:"name"`
+		Next *node
+	}
+	type tree struct {
+		Label    string `policy:"label"`
+		Children []tree
+		Parent   *tree
+	}
+	type order struct {
+		Nodes []node `policy:"Order.items[]"`
+		Trees []tree `policy:"Order.trees[]"`
+	}
+
+	doc, err := Marshal(order{
+		Nodes: []node{{Name: "A-1"}},
+		Trees: []tree{{Label: "root"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"name": "A-1", "Next": nil}}, doc["Order"].(map[string]interface{})["items"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"label": "root"}}, doc["Order"].(map[string]interface{})["trees"])
+}
+
+// TestMarshalErrors tests the inputs Marshal refuses
+func TestMarshalErrors(t *testing.T) {
+	type duplicate struct {
+		A int `policy:"Person.age"`
+		B int `policy:"Person.age"`
+	}
+	type notSlice struct {
+		Items string `policy:"Order.items[]"`
+	}
+	type clash struct {
+		Person string `policy:"Person"`
+		Age    int    `policy:"Person.age"`
+	}
+
+	for _, v := range []interface{}{duplicate{}, notSlice{}, clash{Person: "x"}, 42, (*order)(nil)} {
+		_, err := Marshal(v)
+		assert.Error(t, err, "%T", v)
+	}
+}
+
+// TestValidateElements tests index-addressed issues for collections
+func TestValidateElements(t *testing.T) {
+	doc := map[string]interface{}{
+		"Order": map[string]interface{}{
+			"items": []interface{}{
+				map[string]interface{}{"sku": "A-1", "price": 20},
+				map[string]interface{}{"sku": "B-2"},
+				"not an item",
+				map[string]interface{}{"sku": "D-4", "price": 1},
+			},
+			"total": 150,
+		},
+	}
+
+	assert.Equal(t, []string{"Order.items[2] is not an object"}, issueStrings(ValidateElements(doc, "Order.items", "sku")))
+	assert.Equal(t, []string{"Order.items[1].price missing", "Order.items[2] is not an object"},
+		issueStrings(ValidateElements(doc, "Order.items", "sku", "price")))
+	assert.Equal(t, []string{"Order.lines missing"}, issueStrings(ValidateElements(doc, "Order.lines", "sku")))
+	assert.Equal(t, []string{"Order.total is int, not an array"}, issueStrings(ValidateElements(doc, "Order.total")))
+}
+
+func issueStrings(issues []ElementIssue) []string {
+	var out []string
+	for _, issue := range issues {
+		out = append(out, issue.String())
+	}
+	return out
+}
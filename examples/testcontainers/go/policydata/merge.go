@@ -0,0 +1,210 @@
+// Package policydata helps build the data documents sent to the Policy Engine.
+package policydata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// SliceMode controls how Merge combines two slices found at the same key
+type SliceMode int
+
+const (
+	// SliceReplace replaces the base slice with the override slice
+	SliceReplace SliceMode = iota
+	// SliceAppend appends the override elements after the base elements
+	SliceAppend
+)
+
+type mergeConfig struct {
+	sliceMode SliceMode
+	keepNulls bool
+}
+
+// MergeOption configures Merge
+type MergeOption func(*mergeConfig)
+
+// WithSliceMode selects how slices present on both sides are combined
+func WithSliceMode(mode SliceMode) MergeOption {
+	return func(c *mergeConfig) {
+		c.sliceMode = mode
+	}
+}
+
+// KeepNulls stores explicit nulls from the overrides instead of deleting the key
+func KeepNulls() MergeOption {
+	return func(c *mergeConfig) {
+		c.keepNulls = true
+	}
+}
+
+// Merge deep-merges overrides on top of base and returns a new document.
+//
+// Neither input is mutated: the result is built from copies, so it is safe to
+// reuse the same base for every evaluation. The merge rules are:
+//   - objects present on both sides are merged key by key
+//   - any other conflict (including object vs scalar) is won by the override
+//   - slices are replaced, or appended with WithSliceMode(SliceAppend)
+//   - an explicit nil in the overrides deletes the key, unless KeepNulls is set,
+//     including one nested in an object the override adds whole
+//
+// Both inputs may be maps or structs; anything that is not a
+// map[string]interface{} is converted through its JSON encoding. Nil inputs
+// are treated as empty objects.
+func Merge(base, overrides interface{}, opts ...MergeOption) (map[string]interface{}, error) {
+	cfg := mergeConfig{sliceMode: SliceReplace}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	result, err := toObject(base)
+	if err != nil {
+		return nil, fmt.Errorf("policydata: invalid base: %w", err)
+	}
+	overrideMap, err := toObject(overrides)
+	if err != nil {
+		return nil, fmt.Errorf("policydata: invalid overrides: %w", err)
+	}
+
+	mergeInto(result, overrideMap, &cfg)
+	return result, nil
+}
+
+// mergeInto applies src onto dst; dst must already be a private copy
+func mergeInto(dst, src map[string]interface{}, cfg *mergeConfig) {
+	for key, srcValue := range src {
+		if srcValue == nil && !cfg.keepNulls {
+			delete(dst, key)
+			continue
+		}
+
+		dstValue, exists := dst[key]
+		if !exists {
+			dst[key] = cfg.inserted(srcValue)
+			continue
+		}
+
+		switch d := dstValue.(type) {
+		case map[string]interface{}:
+			if s, ok := srcValue.(map[string]interface{}); ok {
+				mergeInto(d, s, cfg)
+				continue
+			}
+		case []interface{}:
+			if s, ok := srcValue.([]interface{}); ok && cfg.sliceMode == SliceAppend {
+				dst[key] = append(d, cfg.inserted(s).([]interface{})...)
+				continue
+			}
+		}
+
+		dst[key] = cfg.inserted(srcValue)
+	}
+}
+
+// inserted is an override value taking a key whole, with the nulls in its
+// objects dropped as mergeInto drops them, unless KeepNulls is set. The
+// value is a private copy, so it is changed in place.
+func (cfg *mergeConfig) inserted(v interface{}) interface{} {
+	if cfg.keepNulls {
+		return v
+	}
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if item == nil {
+				delete(value, key)
+				continue
+			}
+			value[key] = cfg.inserted(item)
+		}
+	case []interface{}:
+		// A null element holds its place in the array, but objects within
+		// it lose theirs
+		for i, item := range value {
+			value[i] = cfg.inserted(item)
+		}
+	}
+	return v
+}
+
+// toObject returns a private deep copy of v as a JSON-style object
+func toObject(v interface{}) (map[string]interface{}, error) {
+	if v == nil {
+		return map[string]interface{}{}, nil
+	}
+
+	var copied interface{}
+	var err error
+	if _, ok := v.(map[string]interface{}); ok {
+		copied, err = deepCopy(v)
+	} else {
+		copied, err = normalize(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	object, ok := copied.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an object, got %T", v)
+	}
+	return object, nil
+}
+
+// deepCopy copies generic maps and slices recursively. Values the JSON encoder
+// handles specially (time.Time, json.RawMessage, ...) and plain scalars are kept
+// as they are; other composite values are normalised through JSON.
+func deepCopy(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			c, err := deepCopy(item)
+			if err != nil {
+				return nil, err
+			}
+			copied[key] = c
+		}
+		return copied, nil
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, item := range value {
+			c, err := deepCopy(item)
+			if err != nil {
+				return nil, err
+			}
+			copied[i] = c
+		}
+		return copied, nil
+	case json.Marshaler:
+		return v, nil
+	}
+
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct, reflect.Pointer, reflect.Interface:
+		return normalize(v)
+	default:
+		return v, nil
+	}
+}
+
+// normalize converts v into generic JSON values, keeping numbers as json.Number
+func normalize(v interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
@@ -0,0 +1,221 @@
+package policydata
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func customerSnapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"Customer": map[string]interface{}{
+			"membership_level": "silver",
+			"tags":             []interface{}{"newsletter"},
+			"address": map[string]interface{}{
+				"city":    "Leeds",
+				"country": "GB",
+			},
+		},
+		"Order": map[string]interface{}{
+			"total": 80,
+		},
+	}
+}
+
+// TestMergeNestedOverrides tests that nested objects are merged key by key
+func TestMergeNestedOverrides(t *testing.T) {
+	overrides := map[string]interface{}{
+		"Customer": map[string]interface{}{
+			"membership_level": "gold",
+			"address": map[string]interface{}{
+				"city": "York",
+			},
+		},
+	}
+
+	merged, err := Merge(customerSnapshot(), overrides)
+	require.NoError(t, err)
+
+	customer := merged["Customer"].(map[string]interface{})
+	assert.Equal(t, "gold", customer["membership_level"])
+	assert.Equal(t, map[string]interface{}{"city": "York", "country": "GB"}, customer["address"])
+	assert.Equal(t, map[string]interface{}{"total": 80}, merged["Order"])
+}
+
+// TestMergeTypeConflicts tests that the override wins whenever the types differ
+func TestMergeTypeConflicts(t *testing.T) {
+	overrides := map[string]interface{}{
+		"Customer": map[string]interface{}{
+			"address": "unknown",
+		},
+		"Order": 42,
+	}
+
+	merged, err := Merge(customerSnapshot(), overrides)
+	require.NoError(t, err)
+
+	assert.Equal(t, "unknown", merged["Customer"].(map[string]interface{})["address"])
+	assert.Equal(t, 42, merged["Order"])
+
+	// A scalar in the base is replaced wholesale by an object override
+	merged, err = Merge(map[string]interface{}{"Order": 42}, map[string]interface{}{
+		"Order": map[string]interface{}{"total": 150},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"total": 150}, merged["Order"])
+}
+
+// TestMergeSliceModes tests the replace and append slice strategies
+func TestMergeSliceModes(t *testing.T) {
+	overrides := map[string]interface{}{
+		"Customer": map[string]interface{}{
+			"tags": []interface{}{"vip"},
+		},
+	}
+
+	replaced, err := Merge(customerSnapshot(), overrides)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"vip"}, replaced["Customer"].(map[string]interface{})["tags"])
+
+	appended, err := Merge(customerSnapshot(), overrides, WithSliceMode(SliceAppend))
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"newsletter", "vip"}, appended["Customer"].(map[string]interface{})["tags"])
+}
+
+// TestMergeNullDeletes tests that explicit nulls remove keys unless KeepNulls is set
+func TestMergeNullDeletes(t *testing.T) {
+	overrides := map[string]interface{}{
+		"Customer": map[string]interface{}{
+			"address": nil,
+		},
+		"Order":   nil,
+		"Missing": nil,
+	}
+
+	merged, err := Merge(customerSnapshot(), overrides)
+	require.NoError(t, err)
+
+	assert.NotContains(t, merged, "Order")
+	assert.NotContains(t, merged, "Missing")
+	assert.NotContains(t, merged["Customer"].(map[string]interface{}), "address")
+
+	kept, err := Merge(customerSnapshot(), overrides, KeepNulls())
+	require.NoError(t, err)
+	assert.Contains(t, kept, "Order")
+	assert.Nil(t, kept["Order"])
+}
+
+// TestMergeNullsInNewObjects tests that nulls nested in an object the
+// overrides add whole are dropped too, at any depth and inside arrays, and
+// kept under KeepNulls
+func TestMergeNullsInNewObjects(t *testing.T) {
+	overrides := map[string]interface{}{
+		"Shipment": map[string]interface{}{
+			"carrier":  "DHL",
+			"tracking": nil,
+			"address":  map[string]interface{}{"city": "Oslo", "line2": nil},
+			"parcels":  []interface{}{map[string]interface{}{"weight": 2, "note": nil}, nil},
+		},
+		"Customer": map[string]interface{}{
+			"membership_level": map[string]interface{}{"tier": "gold", "expires": nil},
+		},
+	}
+
+	merged, err := Merge(customerSnapshot(), overrides)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"carrier": "DHL",
+		"address": map[string]interface{}{"city": "Oslo"},
+		"parcels": []interface{}{map[string]interface{}{"weight": 2}, nil},
+	}, merged["Shipment"])
+	assert.Equal(t, map[string]interface{}{"tier": "gold"}, merged["Customer"].(map[string]interface{})["membership_level"],
+		"an object replacing a scalar")
+
+	kept, err := Merge(customerSnapshot(), overrides, KeepNulls())
+	require.NoError(t, err)
+	shipment := kept["Shipment"].(map[string]interface{})
+	assert.Contains(t, shipment, "tracking")
+	assert.Contains(t, shipment["address"], "line2")
+}
+
+// TestMergeDoesNotMutateInputs tests that neither input changes and the result shares no state
+func TestMergeDoesNotMutateInputs(t *testing.T) {
+	base := customerSnapshot()
+	overrides := map[string]interface{}{
+		"Customer": map[string]interface{}{
+			"tags":    []interface{}{"vip"},
+			"address": nil,
+		},
+		"Order": map[string]interface{}{"total": 150},
+	}
+	baseBefore := customerSnapshot()
+	overridesBefore := map[string]interface{}{
+		"Customer": map[string]interface{}{
+			"tags":    []interface{}{"vip"},
+			"address": nil,
+		},
+		"Order": map[string]interface{}{"total": 150},
+	}
+
+	merged, err := Merge(base, overrides, WithSliceMode(SliceAppend))
+	require.NoError(t, err)
+
+	assert.True(t, reflect.DeepEqual(baseBefore, base), "base was mutated")
+	assert.True(t, reflect.DeepEqual(overridesBefore, overrides), "overrides were mutated")
+
+	// Mutating the result must not leak back into either input
+	merged["Customer"].(map[string]interface{})["membership_level"] = "platinum"
+	merged["Customer"].(map[string]interface{})["tags"].([]interface{})[0] = "changed"
+	merged["Order"].(map[string]interface{})["total"] = 1
+
+	assert.True(t, reflect.DeepEqual(baseBefore, base), "base shares state with the result")
+	assert.True(t, reflect.DeepEqual(overridesBefore, overrides), "overrides share state with the result")
+}
+
+// TestMergeStructs tests that struct inputs are merged through their JSON encoding
+func TestMergeStructs(t *testing.T) {
+	type order struct {
+		Total    int    `json:"total"`
+		Currency string `json:"currency"`
+	}
+	type snapshot struct {
+		Order order `json:"Order"`
+	}
+
+	merged, err := Merge(snapshot{Order: order{Total: 80, Currency: "GBP"}}, map[string]interface{}{
+		"Order": map[string]interface{}{"total": 150},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{
+		"total":    150,
+		"currency": "GBP",
+	}, merged["Order"])
+
+	merged, err = Merge(snapshot{Order: order{Total: 80, Currency: "GBP"}}, json.RawMessage(`{"Order":{"total":150}}`))
+	require.NoError(t, err)
+	assert.Equal(t, json.Number("150"), merged["Order"].(map[string]interface{})["total"])
+}
+
+// TestMergeNilInputs tests that nil inputs behave like empty objects
+func TestMergeNilInputs(t *testing.T) {
+	merged, err := Merge(nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, merged)
+
+	merged, err = Merge(nil, map[string]interface{}{"age": 70})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"age": 70}, merged)
+}
+
+// TestMergeRejectsNonObjects tests that arrays and scalars are rejected
+func TestMergeRejectsNonObjects(t *testing.T) {
+	_, err := Merge([]interface{}{1, 2}, nil)
+	assert.Error(t, err)
+
+	_, err = Merge(nil, json.RawMessage(`"text"`))
+	assert.Error(t, err)
+}
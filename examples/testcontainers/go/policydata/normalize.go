@@ -0,0 +1,202 @@
+package policydata
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// KeyStyle is the canonical spelling Normalize rewrites keys into
+type KeyStyle int
+
+const (
+	// SnakeCase spells keys like membership_level
+	SnakeCase KeyStyle = iota
+	// CamelCase spells keys like membershipLevel
+	CamelCase
+)
+
+// NormalizationConfig controls how Normalize rewrites keys
+type NormalizationConfig struct {
+	// Style is the canonical key style
+	Style KeyStyle
+	// Aliases maps irregular keys to their canonical name and takes precedence
+	// over Style. An alias matches either the key as supplied or its
+	// style-converted form.
+	Aliases map[string]string
+	// RewriteEntityKeys also rewrites the top-level keys. They are the
+	// **Entity** names rules refer to, such as Customer, so by default they
+	// are left unchanged.
+	RewriteEntityKeys bool
+}
+
+// KeyCollisionError reports keys in the same object that normalise to one name
+type KeyCollisionError struct {
+	Path    string
+	Key     string
+	Sources []string
+}
+
+func (e *KeyCollisionError) Error() string {
+	location := e.Key
+	if e.Path != "" {
+		location = e.Path + "." + e.Key
+	}
+	return fmt.Sprintf("policydata: keys %s all normalise to %q", strings.Join(quoteAll(e.Sources), ", "), location)
+}
+
+// Normalize returns a copy of data with every object key rewritten into the
+// configured style, e.g. MembershipLevel, membership-level and
+// membership_level all become membership_level. Two keys in one object that
+// end up with the same name produce a *KeyCollisionError rather than one
+// silently overwriting the other.
+func Normalize(data interface{}, cfg NormalizationConfig) (map[string]interface{}, error) {
+	doc, err := toObject(data)
+	if err != nil {
+		return nil, fmt.Errorf("policydata: invalid data: %w", err)
+	}
+
+	normalized, err := normalizeValue(doc, "", cfg.RewriteEntityKeys, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	return normalized.(map[string]interface{}), nil
+}
+
+func normalizeValue(value interface{}, path string, rewriteKeys bool, cfg *NormalizationConfig) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		sources := make(map[string][]string, len(v))
+
+		for key, item := range v {
+			canonical := key
+			if rewriteKeys {
+				canonical = cfg.canonicalKey(key)
+			}
+			sources[canonical] = append(sources[canonical], key)
+
+			child, err := normalizeValue(item, joinPath(path, canonical), true, cfg)
+			if err != nil {
+				return nil, err
+			}
+			normalized[canonical] = child
+		}
+
+		// Report the collision with the smallest key so errors are deterministic
+		var collided []string
+		for canonical, from := range sources {
+			if len(from) > 1 {
+				collided = append(collided, canonical)
+			}
+		}
+		if len(collided) > 0 {
+			sort.Strings(collided)
+			from := sources[collided[0]]
+			sort.Strings(from)
+			return nil, &KeyCollisionError{Path: path, Key: collided[0], Sources: from}
+		}
+
+		return normalized, nil
+	case []interface{}:
+		normalized := make([]interface{}, len(v))
+		for i, item := range v {
+			child, err := normalizeValue(item, fmt.Sprintf("%s[%d]", path, i), true, cfg)
+			if err != nil {
+				return nil, err
+			}
+			normalized[i] = child
+		}
+		return normalized, nil
+	default:
+		return value, nil
+	}
+}
+
+func (cfg *NormalizationConfig) canonicalKey(key string) string {
+	if alias, ok := cfg.Aliases[key]; ok {
+		return alias
+	}
+
+	converted := ConvertKey(key, cfg.Style)
+	if alias, ok := cfg.Aliases[converted]; ok {
+		return alias
+	}
+	return converted
+}
+
+// ConvertKey rewrites a single key into style, splitting words on '_', '-',
+// spaces and case changes (so HTTPStatus becomes http_status)
+func ConvertKey(key string, style KeyStyle) string {
+	words := splitWords(key)
+	if len(words) == 0 {
+		return key
+	}
+
+	switch style {
+	case CamelCase:
+		var b strings.Builder
+		b.WriteString(words[0])
+		for _, word := range words[1:] {
+			runes := []rune(word)
+			b.WriteRune(unicode.ToUpper(runes[0]))
+			b.WriteString(string(runes[1:]))
+		}
+		return b.String()
+	default:
+		return strings.Join(words, "_")
+	}
+}
+
+// splitWords breaks a key into lower-case words
+func splitWords(key string) []string {
+	var words []string
+	var current []rune
+
+	flush := func() {
+		if len(current) > 0 {
+			words = append(words, strings.ToLower(string(current)))
+			current = current[:0]
+		}
+	}
+
+	runes := []rune(key)
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || unicode.IsSpace(r):
+			flush()
+		case unicode.IsUpper(r):
+			// Start a new word on lower→Upper, and at the last capital of an
+			// acronym that is followed by a lower-case letter (HTTPStatus)
+			if i > 0 && len(current) > 0 {
+				prev := runes[i-1]
+				nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+					flush()
+				}
+			}
+			current = append(current, r)
+		default:
+			current = append(current, r)
+		}
+	}
+	flush()
+
+	return words
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return quoted
+}
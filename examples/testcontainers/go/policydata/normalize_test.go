@@ -0,0 +1,119 @@
+package policydata
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConvertKey tests word splitting for every supported input style
+func TestConvertKey(t *testing.T) {
+	cases := []struct {
+		in    string
+		snake string
+		camel string
+	}{
+		{"MembershipLevel", "membership_level", "membershipLevel"},
+		{"membership_level", "membership_level", "membershipLevel"},
+		{"membership-level", "membership_level", "membershipLevel"},
+		{"membershipLevel", "membership_level", "membershipLevel"},
+		{"membership level", "membership_level", "membershipLevel"},
+		{"HTTPStatus", "http_status", "httpStatus"},
+		{"address2Line", "address2_line", "address2Line"},
+		{"age", "age", "age"},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.snake, ConvertKey(tc.in, SnakeCase), tc.in)
+		assert.Equal(t, tc.camel, ConvertKey(tc.in, CamelCase), tc.in)
+	}
+}
+
+// TestNormalizeAllInputStyles tests that all three third-party spellings converge
+func TestNormalizeAllInputStyles(t *testing.T) {
+	for _, key := range []string{"MembershipLevel", "membership_level", "membership-level"} {
+		data := map[string]interface{}{
+			"Customer": map[string]interface{}{
+				key: "gold",
+				"Orders": []interface{}{
+					map[string]interface{}{"OrderTotal": 10},
+				},
+			},
+		}
+
+		normalized, err := Normalize(data, NormalizationConfig{Style: SnakeCase})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"Customer": map[string]interface{}{
+				"membership_level": "gold",
+				"orders": []interface{}{
+					map[string]interface{}{"order_total": 10},
+				},
+			},
+		}, normalized, key)
+	}
+}
+
+// TestNormalizeEntityKeys tests that top-level keys are kept unless rewriting them is asked for
+func TestNormalizeEntityKeys(t *testing.T) {
+	data := map[string]interface{}{"DrivingTest": map[string]interface{}{"TestDate": "2024-01-01"}}
+
+	normalized, err := Normalize(data, NormalizationConfig{Style: SnakeCase})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"test_date": "2024-01-01"}, normalized["DrivingTest"])
+
+	normalized, err = Normalize(data, NormalizationConfig{Style: SnakeCase, RewriteEntityKeys: true})
+	require.NoError(t, err)
+	assert.Contains(t, normalized, "driving_test")
+}
+
+// TestNormalizeAliasPrecedence tests that aliases beat the style conversion
+func TestNormalizeAliasPrecedence(t *testing.T) {
+	cfg := NormalizationConfig{
+		Style: SnakeCase,
+		Aliases: map[string]string{
+			"Tier":          "membership_level",
+			"date_of_birth": "birth_date",
+		},
+	}
+
+	normalized, err := Normalize(map[string]interface{}{
+		"Customer": map[string]interface{}{
+			"Tier":        "gold",
+			"DateOfBirth": "1950-01-01",
+		},
+	}, cfg)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{
+		"membership_level": "gold",
+		"birth_date":       "1950-01-01",
+	}, normalized["Customer"])
+}
+
+// TestNormalizeCollision tests that two keys normalising to one name error
+func TestNormalizeCollision(t *testing.T) {
+	_, err := Normalize(map[string]interface{}{
+		"Customer": map[string]interface{}{
+			"MembershipLevel":  "gold",
+			"membership_level": "silver",
+		},
+	}, NormalizationConfig{Style: SnakeCase})
+
+	var collision *KeyCollisionError
+	require.True(t, errors.As(err, &collision), "expected KeyCollisionError, got %v", err)
+	assert.Equal(t, "Customer", collision.Path)
+	assert.Equal(t, "membership_level", collision.Key)
+	assert.Equal(t, []string{"MembershipLevel", "membership_level"}, collision.Sources)
+
+	// An alias that lands on an existing key is a collision too
+	_, err = Normalize(map[string]interface{}{
+		"Customer": map[string]interface{}{
+			"Tier":            "gold",
+			"MembershipLevel": "silver",
+		},
+	}, NormalizationConfig{Style: SnakeCase, Aliases: map[string]string{"Tier": "membership_level"}})
+	assert.True(t, errors.As(err, &collision))
+}
@@ -0,0 +1,221 @@
+package policydata
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Transform rewrites an outgoing data document in place. Transforms are only
+// ever handed a private copy of the caller's data, so they may mutate freely.
+type Transform interface {
+	Apply(doc map[string]interface{}) error
+}
+
+// TransformFunc adapts a plain function to the Transform interface
+type TransformFunc func(doc map[string]interface{}) error
+
+// Apply calls f(doc)
+func (f TransformFunc) Apply(doc map[string]interface{}) error {
+	return f(doc)
+}
+
+// ApplyTransforms runs the transforms in order over a deep copy of data and
+// returns the transformed copy; data itself is left untouched
+func ApplyTransforms(data interface{}, transforms ...Transform) (map[string]interface{}, error) {
+	doc, err := toObject(data)
+	if err != nil {
+		return nil, fmt.Errorf("policydata: invalid data: %w", err)
+	}
+
+	for i, transform := range transforms {
+		if err := transform.Apply(doc); err != nil {
+			return nil, fmt.Errorf("policydata: transform %d: %w", i, err)
+		}
+	}
+	return doc, nil
+}
+
+// DropFields removes the values at the given dotted paths (e.g. "Person.ssn").
+// Paths that pass through arrays apply to every element; missing paths are ignored.
+func DropFields(paths ...string) Transform {
+	return TransformFunc(func(doc map[string]interface{}) error {
+		for _, path := range paths {
+			visitParents(doc, splitPath(path), func(parent map[string]interface{}, key string) error {
+				delete(parent, key)
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// HashFields replaces the values at the given paths with a salted HMAC-SHA256
+// digest. The digest is stable for a given salt, so equality conditions keep
+// working when the rule compares against HashValue(salt, literal).
+func HashFields(salt string, paths ...string) Transform {
+	return TransformFunc(func(doc map[string]interface{}) error {
+		for _, path := range paths {
+			err := visitParents(doc, splitPath(path), func(parent map[string]interface{}, key string) error {
+				value, ok := parent[key]
+				if !ok || value == nil {
+					return nil
+				}
+				hashed, err := HashValue(salt, value)
+				if err != nil {
+					return fmt.Errorf("hash %s: %w", path, err)
+				}
+				parent[key] = hashed
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// HashValue returns the digest HashFields would produce for value. Strings are
+// hashed as their raw bytes; other values as their JSON encoding.
+func HashValue(salt string, value interface{}) (string, error) {
+	var raw []byte
+	if s, ok := value.(string); ok {
+		raw = []byte(s)
+	} else {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		raw = encoded
+	}
+
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write(raw)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Bucket labels every value strictly below Below that no earlier bucket claimed
+type Bucket struct {
+	Below float64
+	Label string
+}
+
+// BucketNumbers replaces the numeric value at path with a coarse label stored
+// under target in the same object, e.g. Person.age → Person.age_band. Buckets
+// must be sorted by Below; use math.Inf(1) for a catch-all final bucket.
+func BucketNumbers(path, target string, buckets ...Bucket) Transform {
+	return TransformFunc(func(doc map[string]interface{}) error {
+		for i := 1; i < len(buckets); i++ {
+			if buckets[i].Below <= buckets[i-1].Below {
+				return fmt.Errorf("buckets for %s are not sorted by Below", path)
+			}
+		}
+
+		return visitParents(doc, splitPath(path), func(parent map[string]interface{}, key string) error {
+			value, ok := parent[key]
+			if !ok || value == nil {
+				return nil
+			}
+			number, ok := ToFloat(value)
+			if !ok {
+				return fmt.Errorf("bucket %s: %T is not a number", path, value)
+			}
+
+			for _, bucket := range buckets {
+				if number < bucket.Below {
+					delete(parent, key)
+					parent[target] = bucket.Label
+					return nil
+				}
+			}
+			return fmt.Errorf("bucket %s: %v is outside every bucket", path, number)
+		})
+	})
+}
+
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// Lookup finds the value at a dotted path such as "Customer.address.city"
+// through nested objects, reporting whether it is present
+func Lookup(doc map[string]interface{}, path string) (interface{}, bool) {
+	var node interface{} = doc
+	for _, segment := range splitPath(path) {
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if node, ok = object[segment]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// visitParents calls fn with the object holding the last path segment, fanning
+// out over arrays encountered along the way
+func visitParents(node interface{}, segments []string, fn func(parent map[string]interface{}, key string) error) error {
+	switch value := node.(type) {
+	case []interface{}:
+		for _, item := range value {
+			if err := visitParents(item, segments, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		if len(segments) == 1 {
+			return fn(value, segments[0])
+		}
+		child, ok := value[segments[0]]
+		if !ok {
+			return nil
+		}
+		return visitParents(child, segments[1:], fn)
+	default:
+		return nil
+	}
+}
+
+// ToFloat converts the numeric representations found in data documents: Go
+// integer and float types and json.Number
+func ToFloat(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := strconv.ParseFloat(string(n), 64)
+		return f, err == nil && !math.IsNaN(f)
+	default:
+		return 0, false
+	}
+}
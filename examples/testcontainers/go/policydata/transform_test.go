@@ -0,0 +1,128 @@
+package policydata
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func personRecord() map[string]interface{} {
+	return map[string]interface{}{
+		"Person": map[string]interface{}{
+			"age":   70,
+			"ssn":   "123-45-6789",
+			"email": "pat@example.com",
+		},
+		"Order": map[string]interface{}{
+			"items": []interface{}{
+				map[string]interface{}{"sku": "A1", "card": "4111111111111111"},
+				map[string]interface{}{"sku": "B2", "card": "5500000000000004"},
+			},
+		},
+	}
+}
+
+var ageBands = []Bucket{
+	{Below: 18, Label: "under_18"},
+	{Below: 65, Label: "18_to_64"},
+	{Below: math.Inf(1), Label: "65_plus"},
+}
+
+// TestDropFields tests dropping plain, nested-array, and missing paths
+func TestDropFields(t *testing.T) {
+	doc, err := ApplyTransforms(personRecord(), DropFields("Person.ssn", "Order.items.card", "Nobody.here"))
+	require.NoError(t, err)
+
+	assert.NotContains(t, doc["Person"], "ssn")
+	assert.Contains(t, doc["Person"], "email")
+	for _, item := range doc["Order"].(map[string]interface{})["items"].([]interface{}) {
+		assert.NotContains(t, item, "card")
+		assert.Contains(t, item, "sku")
+	}
+}
+
+// TestHashFields tests that hashing is stable per salt and matches HashValue
+func TestHashFields(t *testing.T) {
+	first, err := ApplyTransforms(personRecord(), HashFields("pepper", "Person.email"))
+	require.NoError(t, err)
+	second, err := ApplyTransforms(personRecord(), HashFields("pepper", "Person.email"))
+	require.NoError(t, err)
+	otherSalt, err := ApplyTransforms(personRecord(), HashFields("salt", "Person.email"))
+	require.NoError(t, err)
+
+	hashed := first["Person"].(map[string]interface{})["email"]
+	assert.Equal(t, hashed, second["Person"].(map[string]interface{})["email"])
+	assert.NotEqual(t, hashed, otherSalt["Person"].(map[string]interface{})["email"])
+	assert.NotEqual(t, "pat@example.com", hashed)
+
+	expected, err := HashValue("pepper", "pat@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, expected, hashed)
+}
+
+// TestBucketNumbers tests that a numeric field is replaced by its band
+func TestBucketNumbers(t *testing.T) {
+	cases := map[interface{}]string{
+		10:        "under_18",
+		18:        "18_to_64",
+		64.9:      "18_to_64",
+		65:        "65_plus",
+		int64(90): "65_plus",
+	}
+
+	for age, want := range cases {
+		record := map[string]interface{}{"Person": map[string]interface{}{"age": age}}
+		doc, err := ApplyTransforms(record, BucketNumbers("Person.age", "age_band", ageBands...))
+		require.NoError(t, err)
+
+		person := doc["Person"].(map[string]interface{})
+		assert.NotContains(t, person, "age")
+		assert.Equal(t, want, person["age_band"], "age %v", age)
+	}
+}
+
+// TestBucketNumbersErrors tests non-numeric values, gaps, and unsorted buckets
+func TestBucketNumbersErrors(t *testing.T) {
+	record := map[string]interface{}{"Person": map[string]interface{}{"age": "seventy"}}
+	_, err := ApplyTransforms(record, BucketNumbers("Person.age", "age_band", ageBands...))
+	assert.Error(t, err)
+
+	record = map[string]interface{}{"Person": map[string]interface{}{"age": 200}}
+	_, err = ApplyTransforms(record, BucketNumbers("Person.age", "age_band", Bucket{Below: 100, Label: "any"}))
+	assert.Error(t, err)
+
+	_, err = ApplyTransforms(personRecord(), BucketNumbers("Person.age", "age_band",
+		Bucket{Below: 65, Label: "b"}, Bucket{Below: 18, Label: "a"}))
+	assert.Error(t, err)
+}
+
+// TestApplyTransformsLeavesOriginalUntouched tests that the caller's document is not modified
+func TestApplyTransformsLeavesOriginalUntouched(t *testing.T) {
+	original := personRecord()
+
+	_, err := ApplyTransforms(original,
+		DropFields("Person.ssn", "Order.items.card"),
+		HashFields("pepper", "Person.email"),
+		BucketNumbers("Person.age", "age_band", ageBands...),
+	)
+	require.NoError(t, err)
+
+	assert.True(t, reflect.DeepEqual(personRecord(), original), "original data was mutated")
+}
+
+// TestLookup tests finding values by dotted path
+func TestLookup(t *testing.T) {
+	doc := personRecord()
+
+	value, ok := Lookup(doc, "Person.age")
+	assert.True(t, ok)
+	assert.Equal(t, 70, value)
+
+	_, ok = Lookup(doc, "Person.missing")
+	assert.False(t, ok)
+	_, ok = Lookup(doc, "Person.age.years")
+	assert.False(t, ok)
+}
@@ -0,0 +1,181 @@
+// Package policygrpc authorizes gRPC calls with a policy: a unary server
+// interceptor evaluates each call against the rule its mapper picks before
+// the handler runs.
+//
+//	server := grpc.NewServer(grpc.UnaryInterceptor(policygrpc.UnaryServerInterceptor(pc, mapper,
+//		policygrpc.WithSkip(policygrpc.MethodPrefix("/grpc.health.v1.Health/")))))
+//
+// A call the rule grants reaches the handler. One it denies fails with
+// codes.PermissionDenied, whose details hold an errdetails.ErrorInfo
+// explaining the denial and listing the labels the policy granted and
+// denied. The evaluation runs under the call's context, so the caller's
+// deadline bounds it too.
+package policygrpc
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/policydata"
+	"policy-engine-testcontainer-example/trace"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Domain and Reason are those of the ErrorInfo a denial carries
+const (
+	Domain = "policy-engine"
+	Reason = "POLICY_DENIED"
+)
+
+// Mapper picks the rule a call is evaluated against and builds its data.
+// An error it returns as a status is returned to the caller as it is, any
+// other as codes.InvalidArgument.
+type Mapper func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo) (rule string, data interface{}, err error)
+
+// Option configures UnaryServerInterceptor
+type Option func(*interceptor)
+
+// WithSkip lets calls to the methods match reports true through without an
+// evaluation; match is given the full method name, e.g.
+// "/grpc.health.v1.Health/Check". Several WithSkip options skip what any of
+// them match.
+func WithSkip(match func(fullMethod string) bool) Option {
+	return func(i *interceptor) {
+		i.skips = append(i.skips, match)
+	}
+}
+
+// MethodPrefix matches the methods whose full name starts with one of
+// prefixes, e.g. "/grpc.health.v1.Health/" for a whole service
+func MethodPrefix(prefixes ...string) func(fullMethod string) bool {
+	return func(fullMethod string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(fullMethod, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// interceptor is the configuration of one UnaryServerInterceptor
+type interceptor struct {
+	client *client.PolicyClient
+	mapper Mapper
+	skips  []func(string) bool
+}
+
+// UnaryServerInterceptor returns an interceptor that evaluates each call,
+// except those WithSkip matches, against the rule and data mapper gives for
+// it. A denied call fails with codes.PermissionDenied; one the engine
+// rejects with codes.Internal, and one it cannot answer with
+// codes.Unavailable, or the code of the call's context when that ended
+// first. The client's failure policy decides for an unavailable engine.
+func UnaryServerInterceptor(c *client.PolicyClient, mapper Mapper, opts ...Option) grpc.UnaryServerInterceptor {
+	i := &interceptor{client: c, mapper: mapper}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if i.skipped(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		rule, data, err := i.mapper(ctx, req, info)
+		if err != nil {
+			if _, ok := status.FromError(err); ok {
+				return nil, err
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "failed to map %s: %v", info.FullMethod, err)
+		}
+
+		// A denial is explained from the trace
+		response, err := i.client.Evaluate(ctx, client.PolicyRequest{Rule: rule, Data: data, Trace: true})
+		if err != nil {
+			return nil, evaluationError(ctx, info.FullMethod, response, err)
+		}
+		if !response.Result {
+			return nil, denial(info.FullMethod, response, i.client.Aliases())
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (i *interceptor) skipped(fullMethod string) bool {
+	for _, match := range i.skips {
+		if match(fullMethod) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluationError is the status of an evaluation that failed
+func evaluationError(ctx context.Context, method string, response *client.PolicyResponse, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return status.FromContextError(ctxErr).Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return status.FromContextError(err).Err()
+	}
+	if response != nil && response.EngineError != nil {
+		return status.Errorf(codes.Internal, "failed to evaluate the policy for %s: %v", method, err)
+	}
+	return status.Errorf(codes.Unavailable, "failed to evaluate the policy for %s: %v", method, err)
+}
+
+// denial is the PermissionDenied status of a denied call, with an ErrorInfo
+// whose metadata holds the explanation and, as "label.<name>", whether each
+// label was granted
+func denial(method string, response *client.PolicyResponse, aliases *policydata.AliasRegistry) error {
+	metadata := map[string]string{"explanation": explain(response, aliases)}
+	labels := make([]string, 0, len(response.Labels))
+	for label := range response.Labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		metadata["label."+label] = strconv.FormatBool(response.Labels[label])
+	}
+	if response.DecisionID != "" {
+		metadata["decision_id"] = response.DecisionID
+	}
+
+	st := status.Newf(codes.PermissionDenied, "the policy denied %s", method)
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: Reason, Domain: Domain, Metadata: metadata})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// explain words a denial, naming properties as aliases does, or says the
+// failure policy made it
+func explain(response *client.PolicyResponse, aliases *policydata.AliasRegistry) string {
+	if response.Degraded {
+		return "the policy engine is unavailable, so the call was denied"
+	}
+	return trace.Explain(nil, response, trace.WithAliases(aliases))
+}
+
+// DenialInfo returns the ErrorInfo of a denial err, as a client of the
+// service receives it, or nil if err is not one
+func DenialInfo(err error) *errdetails.ErrorInfo {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.PermissionDenied {
+		return nil
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Domain == Domain && info.Reason == Reason {
+			return info
+		}
+	}
+	return nil
+}
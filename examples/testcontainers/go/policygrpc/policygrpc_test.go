@@ -0,0 +1,158 @@
+package policygrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/enginetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const accessRule = `admin. A **User** gets access if the __role__ of the **User** is equal to "admin".`
+
+// roleMapper evaluates every call against accessRule with the caller's
+// x-role metadata
+func roleMapper(ctx context.Context, _ interface{}, _ *grpc.UnaryServerInfo) (string, interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	roles := md.Get("x-role")
+	if len(roles) == 0 {
+		return "", nil, errors.New("no x-role metadata")
+	}
+	return accessRule, map[string]interface{}{"User": map[string]interface{}{"role": roles[0]}}, nil
+}
+
+// newHealthClient serves the health service on a bufconn listener behind the
+// interceptor, against the engine at url, and returns a client of it
+func newHealthClient(t *testing.T, url string, mapper Mapper, opts ...Option) healthpb.HealthClient {
+	pc, err := client.New(url)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.UnaryInterceptor(UnaryServerInterceptor(pc, mapper, opts...)))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+// check calls Health.Check with role as the caller's x-role metadata
+func check(hc healthpb.HealthClient, role string) (*healthpb.HealthCheckResponse, error) {
+	ctx := context.Background()
+	if role != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-role", role)
+	}
+	return hc.Check(ctx, &healthpb.HealthCheckRequest{})
+}
+
+// TestInterceptorAllow tests that a granted call reaches the handler
+func TestInterceptorAllow(t *testing.T) {
+	server := enginetest.NewFakeServer()
+	defer server.Close()
+	hc := newHealthClient(t, server.URL, roleMapper)
+
+	response, err := check(hc, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, response.Status)
+}
+
+// TestInterceptorDeny tests that a denied call fails with PermissionDenied
+// and the labels in its details
+func TestInterceptorDeny(t *testing.T) {
+	server := enginetest.NewFakeServer()
+	defer server.Close()
+	hc := newHealthClient(t, server.URL, roleMapper)
+
+	_, err := check(hc, "guest")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "/grpc.health.v1.Health/Check")
+	info := DenialInfo(err)
+	require.NotNil(t, info, "the denial carries an ErrorInfo")
+	assert.Equal(t, "false", info.Metadata["label.admin"])
+	assert.Contains(t, info.Metadata["explanation"], "admin was NOT granted")
+}
+
+// TestInterceptorMapperError tests that a mapper's error fails the call as
+// InvalidArgument, or with its own status
+func TestInterceptorMapperError(t *testing.T) {
+	server := enginetest.NewFakeServer()
+	defer server.Close()
+
+	_, err := check(newHealthClient(t, server.URL, roleMapper), "")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "no x-role metadata")
+
+	unauthenticated := func(context.Context, interface{}, *grpc.UnaryServerInfo) (string, interface{}, error) {
+		return "", nil, status.Error(codes.Unauthenticated, "who are you")
+	}
+	_, err = check(newHealthClient(t, server.URL, unauthenticated), "admin")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Nil(t, DenialInfo(err))
+}
+
+// TestInterceptorSkip tests that skipped methods are not evaluated
+func TestInterceptorSkip(t *testing.T) {
+	down := enginetest.NewFakeServer()
+	down.Close()
+	hc := newHealthClient(t, down.URL, roleMapper, WithSkip(MethodPrefix("/grpc.health.v1.Health/")))
+
+	_, err := check(hc, "")
+	assert.NoError(t, err)
+}
+
+// TestInterceptorEngineDown tests that a call the engine cannot answer
+// fails as Unavailable
+func TestInterceptorEngineDown(t *testing.T) {
+	down := enginetest.NewFakeServer()
+	down.Close()
+
+	_, err := check(newHealthClient(t, down.URL, roleMapper), "admin")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+// TestInterceptorDeadline tests that the caller's deadline bounds the
+// evaluation: the engine's request is abandoned when it passes
+func TestInterceptorDeadline(t *testing.T) {
+	abandoned := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server notices the client leaving only once the body is read
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+		close(abandoned)
+	}))
+	defer server.Close()
+	hc := newHealthClient(t, server.URL, roleMapper)
+
+	ctx, cancel := context.WithTimeout(metadata.AppendToOutgoingContext(context.Background(), "x-role", "admin"), 100*time.Millisecond)
+	defer cancel()
+	_, err := hc.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	select {
+	case <-abandoned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the evaluation outlived the call's deadline")
+	}
+}
@@ -0,0 +1,176 @@
+// Package policyhttp guards net/http handlers with a policy: each request is
+// evaluated against a rule before the handler sees it.
+//
+//	guard := policyhttp.Middleware(pc, adminRule, func(r *http.Request) (interface{}, error) {
+//		return map[string]interface{}{"User": map[string]interface{}{"role": r.Header.Get("X-Role")}}, nil
+//	}, policyhttp.WithCacheTTL(time.Minute))
+//	mux.Handle("/admin/", guard(adminHandler))
+//
+// A request the rule grants reaches the handler. One it denies is answered
+// 403 with a JSON body explaining the denial, and one the engine could not
+// decide 500, logged with the reason.
+package policyhttp
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/policydata"
+	"policy-engine-testcontainer-example/trace"
+)
+
+// Denial is the body of a 403
+type Denial struct {
+	Error string `json:"error"`
+	// Explanation says which conditions of the rule did not hold, as
+	// trace.Explain words it
+	Explanation string `json:"explanation"`
+	DecisionID  string `json:"decision_id,omitempty"`
+}
+
+// Failure is the body of a 400 or 500
+type Failure struct {
+	Error string `json:"error"`
+}
+
+// Option configures Middleware
+type Option func(*guard)
+
+// WithFailurePolicy sets what the middleware does when the engine is
+// unavailable: client.FailOpen lets the request through, client.FailClosed
+// denies it and client.FailWithError answers 500. Without it the client's
+// own failure policy applies.
+func WithFailurePolicy(policy client.FailurePolicy) Option {
+	return func(g *guard) {
+		g.failure = &policy
+	}
+}
+
+// defaultCacheSize is how many decisions WithCacheTTL keeps without
+// WithCacheSize
+const defaultCacheSize = 1024
+
+// WithCacheTTL keeps the route's decisions for ttl, keyed by the data
+// extracted from the request, so repeat requests skip the engine. Degraded
+// decisions are not kept. The cache is the route's own, whatever the
+// client caches, and holds the 1024 most recently used decisions unless
+// WithCacheSize says otherwise.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(g *guard) {
+		g.ttl = ttl
+	}
+}
+
+// WithCacheSize sets how many decisions WithCacheTTL keeps, evicting the
+// least recently used beyond that, so varied requests cannot grow the cache
+// without bound
+func WithCacheSize(entries int) Option {
+	return func(g *guard) {
+		g.cacheSize = entries
+	}
+}
+
+// WithLogger sets where 500s are logged; log.Default() by default
+func WithLogger(logger *log.Logger) Option {
+	return func(g *guard) {
+		g.logger = logger
+	}
+}
+
+// guard is the configuration of one Middleware
+type guard struct {
+	client  *client.PolicyClient
+	rule    string
+	extract func(*http.Request) (interface{}, error)
+
+	failure   *client.FailurePolicy
+	cache     *client.MemoryCache
+	cacheSize int
+	ttl       time.Duration
+	logger    *log.Logger
+}
+
+// Middleware returns middleware that evaluates rule against the data extract
+// builds from each request. A granted request is passed to the next handler,
+// a denied one answered 403 with a Denial, a request extract fails on 400,
+// and one the engine cannot decide 500.
+func Middleware(c *client.PolicyClient, rule string, extract func(*http.Request) (interface{}, error), opts ...Option) func(http.Handler) http.Handler {
+	g := &guard{client: c, rule: rule, extract: extract, cacheSize: defaultCacheSize, logger: log.Default()}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.ttl > 0 {
+		g.cache = client.NewLRUCache(max(g.cacheSize, 1))
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, err := g.extract(r)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, Failure{Error: err.Error()})
+				return
+			}
+			response, err := g.decide(r, data)
+			if err != nil {
+				g.logger.Printf("policyhttp: %s %s: %v", r.Method, r.URL.Path, err)
+				writeJSON(w, http.StatusInternalServerError, Failure{Error: "the policy could not be evaluated"})
+				return
+			}
+			if !response.Result {
+				writeJSON(w, http.StatusForbidden, Denial{
+					Error:       "forbidden",
+					Explanation: explain(response, g.client.Aliases()),
+					DecisionID:  response.DecisionID,
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// decide evaluates the rule against data, from the route's cache when it
+// holds a fresh decision
+func (g *guard) decide(r *http.Request, data interface{}) (*client.PolicyResponse, error) {
+	ctx := r.Context()
+	var key string
+	if g.cache != nil {
+		var err error
+		if key, err = policydata.CanonicalHash(data); err != nil {
+			return nil, err
+		}
+		if entry, ok := g.cache.Get(ctx, key); ok && time.Now().Before(entry.Expires) {
+			return entry.Response, nil
+		}
+	}
+
+	if g.failure != nil {
+		ctx = client.ContextWithFailurePolicy(ctx, *g.failure)
+	}
+	// A denial is explained from the trace
+	response, err := g.client.Evaluate(ctx, client.PolicyRequest{Rule: g.rule, Data: data, Trace: true})
+	if err != nil {
+		return nil, err
+	}
+	if g.cache != nil && !response.Degraded {
+		g.cache.Set(ctx, key, client.CacheEntry{Response: response, Expires: time.Now().Add(g.ttl)}, g.ttl)
+	}
+	return response, nil
+}
+
+// explain words a denial, naming properties as aliases does, or says the
+// failure policy made it
+func explain(response *client.PolicyResponse, aliases *policydata.AliasRegistry) string {
+	if response.Degraded {
+		return "the policy engine is unavailable, so the request was denied"
+	}
+	return trace.Explain(nil, response, trace.WithAliases(aliases))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,204 @@
+package policyhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/enginetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const accessRule = `A **User** gets access if the __role__ of the **User** is equal to "admin".`
+
+// roleOf builds the rule's data from the request's X-Role header
+func roleOf(r *http.Request) (interface{}, error) {
+	role := r.Header.Get("X-Role")
+	if role == "" {
+		return nil, errors.New("no X-Role header")
+	}
+	return map[string]interface{}{"User": map[string]interface{}{"role": role}}, nil
+}
+
+// newHandler guards a handler answering "ok" with the middleware, against
+// the engine at url, and returns it with the log it writes
+func newHandler(t *testing.T, url string, opts ...Option) (http.Handler, *bytes.Buffer) {
+	c, err := client.New(url)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	var logs bytes.Buffer
+	opts = append([]Option{WithLogger(log.New(&logs, "", 0))}, opts...)
+	handler := Middleware(c, accessRule, roleOf, opts...)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	return handler, &logs
+}
+
+// serve sends handler a request with role as its X-Role header
+func serve(handler http.Handler, role string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	if role != "" {
+		r.Header.Set("X-Role", role)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// downURL is the address of an engine that is not running
+func downURL() string {
+	server := enginetest.NewFakeServer()
+	server.Close()
+	return server.URL
+}
+
+// TestMiddlewareAllow tests that a granted request reaches the handler
+func TestMiddlewareAllow(t *testing.T) {
+	server := enginetest.NewFakeServer()
+	defer server.Close()
+	handler, _ := newHandler(t, server.URL)
+
+	w := serve(handler, "admin")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+}
+
+// TestMiddlewareDeny tests that a denied request is answered 403 with an
+// explanation from the trace
+func TestMiddlewareDeny(t *testing.T) {
+	trace := map[string]interface{}{"execution": []interface{}{map[string]interface{}{
+		"selector": map[string]interface{}{"value": "User"},
+		"outcome":  map[string]interface{}{"value": "access"},
+		"result":   false,
+		"conditions": []interface{}{map[string]interface{}{
+			"selector": map[string]interface{}{"value": "User"},
+			"property": map[string]interface{}{"value": "guest", "path": "$.User.role"},
+			"operator": "EqualTo",
+			"value":    map[string]interface{}{"value": "admin", "type": "string"},
+			"result":   false,
+		}},
+	}}}
+	server := enginetest.NewFakeServer(enginetest.WithStub(accessRule, client.PolicyResponse{Result: false, Trace: trace}))
+	defer server.Close()
+	handler, _ := newHandler(t, server.URL)
+
+	w := serve(handler, "guest")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var denial Denial
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &denial))
+	assert.Equal(t, "forbidden", denial.Error)
+	assert.Contains(t, denial.Explanation, `access was NOT granted because role of User ("guest") is not equal to "admin"`)
+}
+
+// TestMiddlewareBadRequest tests that a request the data cannot be built
+// from is answered 400 without asking the engine
+func TestMiddlewareBadRequest(t *testing.T) {
+	handler, _ := newHandler(t, downURL())
+
+	w := serve(handler, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"error": "no X-Role header"}`, w.Body.String())
+}
+
+// TestMiddlewareEngineDown tests each failure policy against an engine that
+// is not running
+func TestMiddlewareEngineDown(t *testing.T) {
+	for name, tt := range map[string]struct {
+		opts []Option
+		code int
+	}{
+		"fail open":   {[]Option{WithFailurePolicy(client.FailOpen)}, http.StatusOK},
+		"fail closed": {[]Option{WithFailurePolicy(client.FailClosed)}, http.StatusForbidden},
+		"error":       {nil, http.StatusInternalServerError},
+	} {
+		t.Run(name, func(t *testing.T) {
+			handler, logs := newHandler(t, downURL(), tt.opts...)
+
+			w := serve(handler, "admin")
+			assert.Equal(t, tt.code, w.Code, w.Body.String())
+			switch tt.code {
+			case http.StatusForbidden:
+				assert.Contains(t, w.Body.String(), "the policy engine is unavailable")
+			case http.StatusInternalServerError:
+				assert.JSONEq(t, `{"error": "the policy could not be evaluated"}`, w.Body.String())
+				assert.Contains(t, logs.String(), "policyhttp: GET /admin: ")
+			}
+		})
+	}
+}
+
+// TestMiddlewareEngineError tests that an evaluation the engine rejects is
+// answered 500 and logged
+func TestMiddlewareEngineError(t *testing.T) {
+	server := enginetest.NewFakeServer()
+	defer server.Close()
+	c, err := client.New(server.URL)
+	require.NoError(t, err)
+	defer c.Close()
+	var logs bytes.Buffer
+	handler := Middleware(c, accessRule, func(*http.Request) (interface{}, error) {
+		return map[string]interface{}{"User": map[string]interface{}{}}, nil
+	}, WithLogger(log.New(&logs, "", 0)), WithFailurePolicy(client.FailOpen))(http.NotFoundHandler())
+
+	w := serve(handler, "admin")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, logs.String(), "Property 'role' not found")
+}
+
+// TestMiddlewareCacheTTL tests that the route's decisions are kept for the
+// TTL
+func TestMiddlewareCacheTTL(t *testing.T) {
+	var calls int32
+	engine := enginetest.NewEngine()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			atomic.AddInt32(&calls, 1)
+		}
+		engine.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	handler, _ := newHandler(t, server.URL, WithCacheTTL(50*time.Millisecond))
+
+	assert.Equal(t, http.StatusOK, serve(handler, "admin").Code)
+	assert.Equal(t, http.StatusOK, serve(handler, "admin").Code)
+	assert.Equal(t, http.StatusForbidden, serve(handler, "guest").Code)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "the repeat is answered from the cache")
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, serve(handler, "admin").Code)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "an expired decision is asked again")
+}
+
+// TestMiddlewareCacheSize tests that the route's cache keeps only the most
+// recently used decisions
+func TestMiddlewareCacheSize(t *testing.T) {
+	var calls int32
+	engine := enginetest.NewEngine()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			atomic.AddInt32(&calls, 1)
+		}
+		engine.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	handler, _ := newHandler(t, server.URL, WithCacheTTL(time.Minute), WithCacheSize(2))
+
+	for _, role := range []string{"admin", "guest", "viewer"} {
+		serve(handler, role)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	serve(handler, "viewer")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "a recent decision is kept")
+	assert.Equal(t, http.StatusOK, serve(handler, "admin").Code)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls), "the least recently used was evicted")
+}
@@ -0,0 +1,487 @@
+package policyset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"policy-engine-testcontainer-example/policydata"
+)
+
+var (
+	// conditionsStart matches the "if" between a rule's outcome and its
+	// conditions
+	conditionsStart = regexp.MustCompile(`\sif\s`)
+	property        = regexp.MustCompile(`__(.+?)__`)
+	selector        = regexp.MustCompile(`\*\*(.+?)\*\*`)
+	duration        = regexp.MustCompile(`^(\d+(?:\.\d+)?) (centur(?:y|ies)|decades?|years?|months?|weeks?|days?|hours?|minutes?|seconds?)$`)
+	date            = regexp.MustCompile(`^(?:date\()?(\d{4}-\d{2}-\d{2})\)?$`)
+	number          = regexp.MustCompile(`^\d+(?:\.\d+)?$`)
+	space           = regexp.MustCompile(`\s+`)
+)
+
+// operators maps each comparison the engine understands to the phrase the
+// corpus indexes it under, folding synonyms together
+var operators = map[string]string{
+	"is greater than or equal to": "is greater than or equal to",
+	"is at least":                 "is greater than or equal to",
+	"is less than or equal to":    "is less than or equal to",
+	"is no more than":             "is less than or equal to",
+	"is exactly equal to":         "is exactly equal to",
+	"is equal to":                 "is equal to",
+	"is the same as":              "is equal to",
+	"is not equal to":             "is not equal to",
+	"is not the same as":          "is not equal to",
+	"is later than":               "is later than",
+	"is earlier than":             "is earlier than",
+	"is greater than":             "is greater than",
+	"is less than":                "is less than",
+	"contains":                    "contains",
+	"is within":                   "is within",
+	"is older than":               "is older than",
+	"is younger than":             "is younger than",
+	"is in":                       "is in",
+	"is not in":                   "is not in",
+	"is not empty":                "is not empty",
+	"is empty":                    "is empty",
+}
+
+// operatorSymbols are the shorthands ByOperator also accepts
+var operatorSymbols = map[string]string{
+	">=": "is greater than or equal to",
+	"<=": "is less than or equal to",
+	">":  "is greater than",
+	"<":  "is less than",
+	"==": "is equal to",
+	"!=": "is not equal to",
+}
+
+// operatorPhrases are the keys of operators, longest first, so the longest
+// phrase at a position wins
+var operatorPhrases = func() []string {
+	phrases := make([]string, 0, len(operators))
+	for phrase := range operators {
+		phrases = append(phrases, phrase)
+	}
+	sort.Slice(phrases, func(i, j int) bool {
+		if len(phrases[i]) != len(phrases[j]) {
+			return len(phrases[i]) > len(phrases[j])
+		}
+		return phrases[i] < phrases[j]
+	})
+	return phrases
+}()
+
+// synonyms rewrites operator phrasings in rule text and queries to the ones
+// operators folds them into, for Search
+var synonyms = strings.NewReplacer(
+	"at least", "greater than or equal to",
+	"no more than", "less than or equal to",
+	"the same as", "equal to",
+)
+
+// Match is a rule, or one condition of it, that a corpus query found
+type Match struct {
+	File string `json:"file"`
+	// Line is the line of the condition, or for Search of the rule, from 1
+	Line int `json:"line"`
+	// Rule is the header of the rule, e.g. "A **Person** gets senior_discount"
+	Rule string `json:"rule"`
+	// Condition is the matching condition as written, its whitespace
+	// collapsed; empty for Search
+	Condition string `json:"condition,omitempty"`
+}
+
+type literalKind int
+
+const (
+	numberLiteral literalKind = iota
+	stringLiteral
+	booleanLiteral
+	dateLiteral
+	durationLiteral
+)
+
+// literal is a value a condition compares against, in the form it is
+// indexed under
+type literal struct {
+	kind  literalKind
+	value string
+}
+
+// Corpus is a body of rule files, indexed when it is loaded so the rules
+// reading a property, using an operator or comparing against a value can be
+// found without reading every file
+type Corpus struct {
+	// Files are the paths of the files loaded, in lexical order
+	Files []string
+
+	rules      []corpusRule
+	texts      map[string]string
+	properties map[[2]string][]Match
+	operators  map[string][]Match
+	literals   map[literal][]Match
+}
+
+// corpusRule is a rule as Search sees it
+type corpusRule struct {
+	match     Match
+	canonical string
+}
+
+// LoadCorpus loads every file in fsys, at any depth, whose name matches
+// pattern, e.g. "*.rule". It fails for a bad pattern, a file it cannot read
+// and a file without a rule.
+func LoadCorpus(fsys fs.FS, pattern string) (*Corpus, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("policyset: bad corpus pattern %q: %w", pattern, err)
+	}
+	c := &Corpus{
+		texts:      map[string]string{},
+		properties: map[[2]string][]Match{},
+		operators:  map[string][]Match{},
+		literals:   map[literal][]Match{},
+	}
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if ok, _ := path.Match(pattern, entry.Name()); !ok {
+			return nil
+		}
+		raw, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		return c.add(name, string(raw))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("policyset: failed to load corpus: %w", err)
+	}
+	return c, nil
+}
+
+// add indexes the rules of one file
+func (c *Corpus) add(name, text string) error {
+	rules := splitRules(text)
+	if len(rules) == 0 {
+		return fmt.Errorf("%s holds no rule", name)
+	}
+	c.Files = append(c.Files, name)
+	hash := sha256.Sum256([]byte(text))
+	c.texts[hex.EncodeToString(hash[:])] = text
+
+	var lineStarts []int
+	lineStarts = append(lineStarts, 0)
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	lineAt := func(offset int) int {
+		return sort.Search(len(lineStarts), func(i int) bool { return lineStarts[i] > offset })
+	}
+
+	for _, rule := range rules {
+		start := rule.offset + len(rule.text) - len(strings.TrimLeft(rule.text, " \t\r\n"))
+		c.rules = append(c.rules, corpusRule{
+			match:     Match{File: name, Line: lineAt(start), Rule: rule.header},
+			canonical: canonical(rule.text),
+		})
+		for _, cond := range conditions(rule.text) {
+			match := Match{File: name, Line: lineAt(rule.offset + cond.offset), Rule: rule.header, Condition: cond.text}
+			c.index(match, cond.text)
+		}
+	}
+	return nil
+}
+
+// RuleText returns the text of the file that hashes to hash, the hex SHA-256
+// audit records identify a rule by
+func (c *Corpus) RuleText(hash string) (string, bool) {
+	text, ok := c.texts[hash]
+	return text, ok
+}
+
+// index records what a condition reads, how it compares and what against
+func (c *Corpus) index(match Match, condition string) {
+	start, end, op, ok := findOperator(condition)
+	if !ok {
+		// A label or rule reference: only Search finds it
+		return
+	}
+	c.operators[op] = append(c.operators[op], match)
+
+	seen := map[[2]string]bool{}
+	addAccess := func(access string) {
+		entity := ""
+		if selectors := selector.FindAllStringSubmatch(access, -1); len(selectors) > 0 {
+			entity = selectors[len(selectors)-1][1]
+		}
+		for _, m := range property.FindAllStringSubmatch(access, -1) {
+			key := [2]string{entity, m[1]}
+			if !seen[key] {
+				seen[key] = true
+				c.properties[key] = append(c.properties[key], match)
+			}
+		}
+	}
+	addAccess(condition[:start])
+
+	right := strings.TrimSpace(condition[end:])
+	if strings.Contains(right, "__") || strings.Contains(right, "**") {
+		addAccess(right)
+		return
+	}
+	values := []string{right}
+	if strings.HasPrefix(right, "[") && strings.HasSuffix(right, "]") {
+		values = splitList(right[1 : len(right)-1])
+	}
+	literals := map[literal]bool{}
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		lit := parseLiteral(value)
+		if !literals[lit] {
+			literals[lit] = true
+			c.literals[lit] = append(c.literals[lit], match)
+		}
+	}
+}
+
+// ByProperty returns the conditions reading property of entity, e.g.
+// "membership_level" of "Customer", on either side of the comparison. An
+// empty entity matches the property of any entity.
+func (c *Corpus) ByProperty(entity, property string) []Match {
+	if entity != "" {
+		return slices.Clone(c.properties[[2]string{entity, property}])
+	}
+	var matches []Match
+	for key, found := range c.properties {
+		if key[1] == property {
+			matches = append(matches, found...)
+		}
+	}
+	sortMatches(matches)
+	return matches
+}
+
+// ByOperator returns the conditions comparing with op, given as the
+// engine's phrase, with or without its leading "is", or as a symbol such as
+// ">=". Synonyms are the same operator, so "is at least" also finds "is
+// greater than or equal to".
+func (c *Corpus) ByOperator(op string) []Match {
+	op = strings.ToLower(strings.TrimSpace(space.ReplaceAllString(op, " ")))
+	canonical, ok := operatorSymbols[op]
+	if !ok {
+		if canonical, ok = operators[op]; !ok {
+			canonical = operators["is "+op]
+		}
+	}
+	return slices.Clone(c.operators[canonical])
+}
+
+// ByLiteral returns the conditions comparing against value, alone or in a
+// list. Values match literals of their own kind: a Go number matches 65 and
+// 65.0 but not "65", a string matches a quoted or bare string, date or
+// duration written the same, a bool matches true or false and a time.Time
+// matches the date it falls on. Other types match nothing.
+func (c *Corpus) ByLiteral(value interface{}) []Match {
+	var kinds []literal
+	switch v := value.(type) {
+	case string:
+		for _, kind := range []literalKind{stringLiteral, dateLiteral, durationLiteral} {
+			kinds = append(kinds, literal{kind: kind, value: v})
+		}
+	case bool:
+		kinds = append(kinds, literal{kind: booleanLiteral, value: strconv.FormatBool(v)})
+	case time.Time:
+		kinds = append(kinds, literal{kind: dateLiteral, value: v.Format(time.DateOnly)})
+	default:
+		n, ok := policydata.ToFloat(value)
+		if !ok {
+			return nil
+		}
+		kinds = append(kinds, literal{kind: numberLiteral, value: strconv.FormatFloat(n, 'g', -1, 64)})
+	}
+	var matches []Match
+	for _, kind := range kinds {
+		matches = append(matches, c.literals[kind]...)
+	}
+	sortMatches(matches)
+	return matches
+}
+
+// Search returns the rules whose text contains q, ignoring case, spacing,
+// the ** and __ around entities and properties, and which of an operator's
+// synonyms either is written with
+func (c *Corpus) Search(q string) []Match {
+	q = canonical(q)
+	if q == "" {
+		return nil
+	}
+	var matches []Match
+	for _, rule := range c.rules {
+		if strings.Contains(rule.canonical, q) {
+			matches = append(matches, rule.match)
+		}
+	}
+	return matches
+}
+
+// canonical is text as Search compares it
+func canonical(text string) string {
+	text = strings.NewReplacer("**", "", "__", "", "§", "$").Replace(strings.ToLower(text))
+	return synonyms.Replace(strings.TrimSpace(space.ReplaceAllString(text, " ")))
+}
+
+// condition is one condition of a rule, offset bytes into the rule's text
+type condition struct {
+	offset int
+	text   string
+}
+
+// conditions splits a rule's text into its conditions, at the "and" and
+// "or" outside string literals and lists
+func conditions(text string) []condition {
+	loc := conditionsStart.FindStringIndex(text)
+	if loc == nil {
+		return nil
+	}
+	body := strings.TrimRight(text, " \t\r\n")
+	body = strings.TrimSuffix(body, ".")
+
+	var found []condition
+	add := func(start, end int) {
+		raw := body[start:end]
+		trimmed := strings.TrimLeft(raw, " \t\r\n")
+		start += len(raw) - len(trimmed)
+		if trimmed = strings.TrimSpace(trimmed); trimmed != "" {
+			found = append(found, condition{offset: start, text: space.ReplaceAllString(trimmed, " ")})
+		}
+	}
+	start := loc[1]
+	quoted, depth := false, 0
+	for i := start; i < len(body); i++ {
+		switch ch := body[i]; {
+		case ch == '"':
+			quoted = !quoted
+		case quoted:
+		case ch == '[':
+			depth++
+		case ch == ']':
+			depth--
+		case depth == 0 && isSpace(ch):
+			for _, word := range []string{"and", "or"} {
+				next := i + 1 + len(word)
+				if next >= len(body) || body[i+1:next] != word || !isSpace(body[next]) {
+					continue
+				}
+				// Not the "or" of "is greater than or equal to"
+				if word == "or" && strings.HasSuffix(body[:i], "than") && strings.HasPrefix(strings.TrimLeft(body[next:], " \t\r\n"), "equal to") {
+					continue
+				}
+				add(start, i)
+				start = next
+				i = next - 1
+				break
+			}
+		}
+	}
+	add(start, len(body))
+	return found
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n'
+}
+
+// findOperator finds the comparison in a condition, returning where its
+// phrase starts and ends and the phrase it is indexed under. Operators come
+// before any string or list, so only the text before one is searched.
+func findOperator(cond string) (start, end int, op string, ok bool) {
+	head := cond
+	if i := strings.IndexAny(head, `"[`); i >= 0 {
+		head = head[:i]
+	}
+	start = -1
+	for _, phrase := range operatorPhrases {
+		for from := 0; from < len(head); {
+			i := strings.Index(head[from:], phrase)
+			if i < 0 {
+				break
+			}
+			i += from
+			after := i + len(phrase)
+			if (i == 0 || head[i-1] == ' ') && (after == len(cond) || cond[after] == ' ') {
+				// Longer phrases come first, so a tie keeps the longer one
+				if start < 0 || i < start {
+					start, end, op = i, after, operators[phrase]
+				}
+				break
+			}
+			from = i + 1
+		}
+	}
+	return start, end, op, start >= 0
+}
+
+// splitList splits the inside of a list literal at the commas outside
+// string literals
+func splitList(list string) []string {
+	var items []string
+	quoted, start := false, 0
+	for i := 0; i < len(list); i++ {
+		switch list[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				items = append(items, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, list[start:])
+}
+
+// parseLiteral classifies a value as the engine's grammar would
+func parseLiteral(value string) literal {
+	switch {
+	case len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`):
+		return literal{kind: stringLiteral, value: value[1 : len(value)-1]}
+	case date.MatchString(value):
+		return literal{kind: dateLiteral, value: date.FindStringSubmatch(value)[1]}
+	case duration.MatchString(value):
+		return literal{kind: durationLiteral, value: value}
+	case number.MatchString(value):
+		n, _ := strconv.ParseFloat(value, 64)
+		return literal{kind: numberLiteral, value: strconv.FormatFloat(n, 'g', -1, 64)}
+	case value == "true" || value == "false":
+		return literal{kind: booleanLiteral, value: value}
+	}
+	return literal{kind: stringLiteral, value: value}
+}
+
+// sortMatches orders matches gathered from several index entries by file
+// and line
+func sortMatches(matches []Match) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].File != matches[j].File {
+			return matches[i].File < matches[j].File
+		}
+		return matches[i].Line < matches[j].Line
+	})
+}
@@ -0,0 +1,143 @@
+package policyset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// corpus holds a threshold of 65 in one rule and inside string values in
+// others, spread over two directories
+var corpus = fstest.MapFS{
+	"pricing/senior.rule": {Data: []byte(`# Discounts by age
+senior. A **Person** gets senior_discount
+  if the __age__ of the **Person** is at least 65
+  and the __membership_level__ of the **Customer** is in ["gold", "platinum"].
+`)},
+	"pricing/shipping.rule": {Data: []byte(`An **Order** gets free_shipping if the __total__ of the **Order** is greater than or equal to 100 and the __code__ of the **Order** is equal to "65".
+
+A **Order** gets express if the __membership_level__ of the **Customer** is the same as "gold and silver" and $senior is valid.
+`)},
+	"routes/route.rule": {Data: []byte(`A **Trip** gets scenic if the __road__ of the **Trip** is equal to "Route 65" and the __booked__ of the **Trip** is equal to true and the __date__ of the **Trip** is later than date(2025-05-31).
+`)},
+	"README.md": {Data: []byte("not a rule file")},
+}
+
+func positions(matches []Match) []string {
+	var out []string
+	for _, m := range matches {
+		out = append(out, fmt.Sprintf("%s:%d", m.File, m.Line))
+	}
+	return out
+}
+
+// TestCorpusByProperty tests property lookups, with and without an entity
+func TestCorpusByProperty(t *testing.T) {
+	c, err := LoadCorpus(corpus, "*.rule")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pricing/senior.rule", "pricing/shipping.rule", "routes/route.rule"}, c.Files)
+
+	matches := c.ByProperty("Customer", "membership_level")
+	assert.Equal(t, []string{"pricing/senior.rule:4", "pricing/shipping.rule:3"}, positions(matches))
+	assert.Equal(t, Match{
+		File:      "pricing/senior.rule",
+		Line:      4,
+		Rule:      "senior. A **Person** gets senior_discount",
+		Condition: `the __membership_level__ of the **Customer** is in ["gold", "platinum"]`,
+	}, matches[0])
+
+	assert.Equal(t, []string{"pricing/senior.rule:3"}, positions(c.ByProperty("", "age")))
+	assert.Empty(t, c.ByProperty("Order", "age"))
+}
+
+// TestCorpusByOperator tests that operators are found by phrase, synonym
+// and symbol
+func TestCorpusByOperator(t *testing.T) {
+	c, err := LoadCorpus(corpus, "*.rule")
+	require.NoError(t, err)
+
+	want := []string{"pricing/senior.rule:3", "pricing/shipping.rule:1"}
+	assert.Equal(t, want, positions(c.ByOperator("is at least")))
+	assert.Equal(t, want, positions(c.ByOperator("greater than or equal to")))
+	assert.Equal(t, want, positions(c.ByOperator(">=")))
+	assert.Len(t, c.ByOperator("is equal to"), 4, `"is the same as" is "is equal to"`)
+	assert.Equal(t, []string{"routes/route.rule:1"}, positions(c.ByOperator("is later than")))
+	assert.Empty(t, c.ByOperator("is greater than"), "the longer phrase wins")
+	assert.Empty(t, c.ByOperator("resembles"))
+}
+
+// TestCorpusByLiteral tests that literals match their own kind, not text
+// that happens to contain them
+func TestCorpusByLiteral(t *testing.T) {
+	c, err := LoadCorpus(corpus, "*.rule")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"pricing/senior.rule:3"}, positions(c.ByLiteral(65)), "the threshold, not the strings")
+	assert.Equal(t, positions(c.ByLiteral(65)), positions(c.ByLiteral(65.0)))
+	assert.Equal(t, []string{"pricing/shipping.rule:1"}, positions(c.ByLiteral("65")))
+	assert.Equal(t, []string{"pricing/senior.rule:4"}, positions(c.ByLiteral("gold")), `not "gold and silver"`)
+	assert.Equal(t, []string{"pricing/shipping.rule:3"}, positions(c.ByLiteral("gold and silver")))
+	assert.Equal(t, []string{"routes/route.rule:1"}, positions(c.ByLiteral(true)))
+	assert.Equal(t, []string{"routes/route.rule:1"}, positions(c.ByLiteral(time.Date(2025, 5, 31, 0, 0, 0, 0, time.UTC))))
+	assert.Empty(t, c.ByLiteral(struct{}{}))
+}
+
+// TestCorpusSearch tests free-text search over canonical rule text
+func TestCorpusSearch(t *testing.T) {
+	c, err := LoadCorpus(corpus, "*.rule")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"pricing/senior.rule:2"}, positions(c.Search("AGE of the person is greater  than or equal to 65")))
+	assert.Equal(t, []string{"pricing/senior.rule:2", "pricing/shipping.rule:1", "routes/route.rule:1"}, positions(c.Search("65")))
+	assert.Equal(t, []string{"pricing/shipping.rule:3"}, positions(c.Search("§senior")))
+	assert.Empty(t, c.Search("Discounts by age"), "comments are not rule text")
+	assert.Empty(t, c.Search("  "))
+}
+
+// TestCorpusRuleText tests looking a file up by the hash of its text
+func TestCorpusRuleText(t *testing.T) {
+	c, err := LoadCorpus(corpus, "*.rule")
+	require.NoError(t, err)
+
+	text := string(corpus["routes/route.rule"].Data)
+	hash := sha256.Sum256([]byte(text))
+	got, ok := c.RuleText(hex.EncodeToString(hash[:]))
+	assert.True(t, ok)
+	assert.Equal(t, text, got)
+
+	_, ok = c.RuleText(strings.Repeat("0", 64))
+	assert.False(t, ok)
+}
+
+// TestLoadCorpusErrors tests bad patterns and files without rules
+func TestLoadCorpusErrors(t *testing.T) {
+	_, err := LoadCorpus(corpus, "[")
+	assert.ErrorContains(t, err, `policyset: bad corpus pattern "["`)
+	_, err = LoadCorpus(corpus, "*.md")
+	assert.EqualError(t, err, "policyset: failed to load corpus: README.md holds no rule")
+}
+
+// BenchmarkLoadCorpus measures loading and indexing a 300-file corpus
+func BenchmarkLoadCorpus(b *testing.B) {
+	fsys := fstest.MapFS{}
+	for i := 0; i < 300; i++ {
+		var text string
+		for j := 0; j < 5; j++ {
+			text += fmt.Sprintf("rule%d_%d. A **Customer** gets tier%d if the __spend__ of the **Customer** is at least %d and the __membership_level__ of the **Customer** is in [\"gold\", \"platinum\"] and $rule%d_%d is valid.\n\n", i, j, j, 100*j, i, j+1)
+		}
+		fsys[fmt.Sprintf("policies/%03d.rule", i)] = &fstest.MapFile{Data: []byte(text)}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := LoadCorpus(fsys, "*.rule"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package policyset
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The exports draw rules as boxes and labels as rounded nodes, with a solid
+// edge from each rule to the label it grants and a dashed one from each label
+// to the rules referencing it. Labels no rule grants are outlined in red.
+
+// DOT renders the graph in Graphviz's DOT language
+func (g *DependencyGraph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph policyset {\n\trankdir=LR;\n\tnode [fontname=\"Helvetica\"];\n")
+	for _, rule := range g.Rules {
+		fmt.Fprintf(&b, "\t%s [shape=box, label=%q];\n", ruleID(rule), title(rule))
+	}
+	for i, label := range g.Labels {
+		attrs := ""
+		if _, ok := g.grantedBy[label]; !ok {
+			attrs = ", color=red"
+		}
+		fmt.Fprintf(&b, "\t%s [shape=box, style=rounded, label=%q%s];\n", labelID(i), label, attrs)
+	}
+	g.edges(func(from, to string, reference bool) {
+		if reference {
+			fmt.Fprintf(&b, "\t%s -> %s [style=dashed];\n", from, to)
+		} else {
+			fmt.Fprintf(&b, "\t%s -> %s;\n", from, to)
+		}
+	})
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the graph as a Mermaid flowchart
+func (g *DependencyGraph) Mermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for _, rule := range g.Rules {
+		fmt.Fprintf(&b, "    %s[\"%s\"]\n", ruleID(rule), mermaidText(title(rule)))
+	}
+	var undefined []string
+	for i, label := range g.Labels {
+		fmt.Fprintf(&b, "    %s([\"%s\"])\n", labelID(i), mermaidText(label))
+		if _, ok := g.grantedBy[label]; !ok {
+			undefined = append(undefined, labelID(i))
+		}
+	}
+	g.edges(func(from, to string, reference bool) {
+		arrow := "-->"
+		if reference {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(&b, "    %s %s %s\n", from, arrow, to)
+	})
+	if len(undefined) > 0 {
+		b.WriteString("    classDef undefined stroke:#c00\n")
+		fmt.Fprintf(&b, "    class %s undefined\n", strings.Join(undefined, ","))
+	}
+	return b.String()
+}
+
+// edges calls fn for the grant edge of each rule, then each reference edge,
+// in rule order
+func (g *DependencyGraph) edges(fn func(from, to string, reference bool)) {
+	labelIDs := make(map[string]string, len(g.Labels))
+	for i, label := range g.Labels {
+		labelIDs[label] = labelID(i)
+	}
+	for _, rule := range g.Rules {
+		if rule.Label != "" {
+			fn(ruleID(rule), labelIDs[rule.Label], false)
+		}
+	}
+	for _, rule := range g.Rules {
+		for _, label := range rule.References {
+			fn(labelIDs[label], ruleID(rule), true)
+		}
+	}
+}
+
+func ruleID(rule Rule) string {
+	return fmt.Sprintf("r%d", rule.Position+1)
+}
+
+func labelID(i int) string {
+	return fmt.Sprintf("l%d", i+1)
+}
+
+// title is a rule's header without its label or markup, e.g. "A user is
+// admin"
+func title(rule Rule) string {
+	text := rule.Header
+	if rule.Label != "" {
+		text = strings.TrimPrefix(text, rule.Label+". ")
+	}
+	return strings.NewReplacer("**", "", "__", "").Replace(text)
+}
+
+// mermaidText escapes the quotes Mermaid node text cannot hold
+func mermaidText(s string) string {
+	return strings.ReplaceAll(s, `"`, "#quot;")
+}
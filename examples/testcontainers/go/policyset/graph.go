@@ -0,0 +1,331 @@
+// Package policyset analyses how the rules of a policy set depend on each
+// other through labels: a rule written "admin. A **user** is admin if ..."
+// grants the label admin, and one whose condition reads "$admin is valid" (or
+// "§admin ...") depends on it.
+package policyset
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// header matches the line that starts a rule, with its label if it has one
+	header = regexp.MustCompile(`^(?:(.+?)\. )?An? \*\*`)
+	// reference matches a label reference in a condition
+	reference = regexp.MustCompile(`[§$]([A-Za-z0-9.]+)`)
+	// quoted matches string literals, whose contents are not references
+	quoted = regexp.MustCompile(`"[^"]*"`)
+)
+
+// Rule is one rule of the set
+type Rule struct {
+	// Source is the index into Graph's rules of the text the rule came from,
+	// and Position its place among all the rules, in order
+	Source, Position int
+	// Label is the label the rule grants, if it has one
+	Label string
+	// Header is the rule's first line up to its conditions, e.g.
+	// "admin. A **user** is admin"
+	Header string
+	// References are the labels the rule's conditions refer to, sorted
+	References []string
+}
+
+// Name identifies the rule in graphs and errors: its label, or its position
+func (r Rule) Name() string {
+	if r.Label != "" {
+		return r.Label
+	}
+	return fmt.Sprintf("rule %d", r.Position+1)
+}
+
+// DependencyGraph is the rules of a policy set and the labels linking them
+type DependencyGraph struct {
+	Rules []Rule
+	// Labels are every label granted or referenced, sorted
+	Labels []string
+
+	// grantedBy is the position of the rule granting each label
+	grantedBy map[string]int
+}
+
+// Graph builds the dependency graph of rules, each of which may hold several
+// rules. It fails for text without a rule and for a label granted twice;
+// references to labels no rule grants are kept, see Unreachable.
+func Graph(rules []string) (*DependencyGraph, error) {
+	g := &DependencyGraph{grantedBy: map[string]int{}}
+	for source, text := range rules {
+		found := splitRules(text)
+		if len(found) == 0 {
+			return nil, fmt.Errorf("policyset: rules[%d] holds no rule", source)
+		}
+		for _, rule := range found {
+			g.Rules = append(g.Rules, Rule{
+				Source:     source,
+				Position:   len(g.Rules),
+				Label:      rule.label,
+				Header:     rule.header,
+				References: references(rule.text),
+			})
+		}
+	}
+
+	labels := map[string]bool{}
+	for _, rule := range g.Rules {
+		if rule.Label == "" {
+			continue
+		}
+		if first, dup := g.grantedBy[rule.Label]; dup {
+			return nil, fmt.Errorf("policyset: label %q is granted by both rule %d and rule %d", rule.Label, first+1, rule.Position+1)
+		}
+		g.grantedBy[rule.Label] = rule.Position
+		labels[rule.Label] = true
+	}
+	for _, rule := range g.Rules {
+		for _, label := range rule.References {
+			labels[label] = true
+		}
+	}
+	for label := range labels {
+		g.Labels = append(g.Labels, label)
+	}
+	sort.Strings(g.Labels)
+	return g, nil
+}
+
+// ruleText is one rule of a text: its label and header, and its text up to
+// the next rule, starting offset bytes into the source
+type ruleText struct {
+	offset        int
+	label, header string
+	text          string
+}
+
+// splitRules splits text into its rules. Comment lines are blanked rather
+// than removed, so offsets into a rule's text are offsets into text.
+func splitRules(text string) []ruleText {
+	blanked := []byte(text)
+	var rules []ruleText
+	offset := 0
+	for _, line := range strings.SplitAfter(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "#"):
+			for i := offset; i < offset+len(strings.TrimRight(line, "\n")); i++ {
+				blanked[i] = ' '
+			}
+		case header.MatchString(trimmed):
+			m := header.FindStringSubmatch(trimmed)
+			head, _, _ := strings.Cut(trimmed, " if ")
+			rules = append(rules, ruleText{offset: offset, label: m[1], header: head})
+		}
+		offset += len(line)
+	}
+	for i := range rules {
+		end := len(text)
+		if i+1 < len(rules) {
+			end = rules[i+1].offset
+		}
+		rules[i].text = string(blanked[rules[i].offset:end])
+	}
+	return rules
+}
+
+// references lists the labels referenced in a rule's text
+func references(text string) []string {
+	text = quoted.ReplaceAllString(text, `""`)
+	seen := map[string]bool{}
+	var labels []string
+	for _, m := range reference.FindAllStringSubmatch(text, -1) {
+		// A reference ending a sentence takes its full stop with it
+		label := strings.TrimRight(m[1], ".")
+		if label != "" && !seen[label] {
+			seen[label] = true
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// dependencies returns the positions of the rules granting the labels rule
+// references, skipping labels no rule grants
+func (g *DependencyGraph) dependencies(rule Rule) []int {
+	var deps []int
+	for _, label := range rule.References {
+		if dep, ok := g.grantedBy[label]; ok {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// CycleError is returned by TopoSort for a set whose labels depend on each
+// other in a loop, which the engine cannot evaluate
+type CycleError struct {
+	Cycles [][]string
+}
+
+func (e *CycleError) Error() string {
+	cycles := make([]string, len(e.Cycles))
+	for i, cycle := range e.Cycles {
+		cycles[i] = strings.Join(append(cycle[:len(cycle):len(cycle)], cycle[0]), " -> ")
+	}
+	return "policyset: labels depend on each other: " + strings.Join(cycles, "; ")
+}
+
+// TopoSort orders the rules so each comes after the rules granting the
+// labels it references, keeping the given order where there is a choice. It
+// fails with a *CycleError if the labels form a cycle.
+func (g *DependencyGraph) TopoSort() ([]Rule, error) {
+	if cycles := g.Cycles(); len(cycles) > 0 {
+		return nil, &CycleError{Cycles: cycles}
+	}
+	done := make([]bool, len(g.Rules))
+	sorted := make([]Rule, 0, len(g.Rules))
+	for len(sorted) < len(g.Rules) {
+		for _, rule := range g.Rules {
+			if done[rule.Position] {
+				continue
+			}
+			ready := true
+			for _, dep := range g.dependencies(rule) {
+				ready = ready && done[dep]
+			}
+			if ready {
+				done[rule.Position] = true
+				sorted = append(sorted, rule)
+				break
+			}
+		}
+	}
+	return sorted, nil
+}
+
+// Cycles returns the groups of labels that depend on each other, each in
+// the order the references run starting from its first label alphabetically,
+// and the groups sorted by that label. A rule referencing its own label is a
+// cycle of one.
+func (g *DependencyGraph) Cycles() [][]string {
+	// Tarjan's strongly connected components, over rules
+	var (
+		index   = make([]int, len(g.Rules))
+		low     = make([]int, len(g.Rules))
+		onStack = make([]bool, len(g.Rules))
+		stack   []int
+		next    = 1
+		cycles  [][]string
+	)
+	var connect func(v int)
+	connect = func(v int) {
+		index[v], low[v] = next, next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range g.dependencies(g.Rules[v]) {
+			if index[w] == 0 {
+				connect(w)
+				low[v] = min(low[v], low[w])
+			} else if onStack[w] {
+				low[v] = min(low[v], index[w])
+			}
+		}
+		if low[v] != index[v] {
+			return
+		}
+		var component []int
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			component = append(component, w)
+			if w == v {
+				break
+			}
+		}
+		if len(component) > 1 || g.referencesItself(g.Rules[v]) {
+			cycles = append(cycles, g.cycleOrder(component))
+		}
+	}
+	for v := range g.Rules {
+		if index[v] == 0 {
+			connect(v)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i][0] < cycles[j][0]
+	})
+	return cycles
+}
+
+func (g *DependencyGraph) referencesItself(rule Rule) bool {
+	for _, dep := range g.dependencies(rule) {
+		if dep == rule.Position {
+			return true
+		}
+	}
+	return false
+}
+
+// cycleOrder walks a strongly connected component from its first label,
+// following references within it, and returns the labels in that order. In
+// a component with several loops it lists each label once.
+func (g *DependencyGraph) cycleOrder(component []int) []string {
+	in := map[int]bool{}
+	start := component[0]
+	for _, v := range component {
+		in[v] = true
+		if g.Rules[v].Label < g.Rules[start].Label {
+			start = v
+		}
+	}
+	var order []string
+	visited := map[int]bool{}
+	var walk func(v int)
+	walk = func(v int) {
+		visited[v] = true
+		order = append(order, g.Rules[v].Label)
+		for _, w := range g.dependencies(g.Rules[v]) {
+			if in[w] && !visited[w] {
+				walk(w)
+			}
+		}
+	}
+	walk(start)
+	return order
+}
+
+// Orphans are labels that only one side of a dependency mentions
+type Orphans struct {
+	// Unreferenced labels are granted but no rule refers to them. The label
+	// of the set's top-level rule, if it has one, is always among them.
+	Unreferenced []string
+	// Undefined labels are referenced but no rule grants them, so the
+	// engine rejects the set
+	Undefined []string
+}
+
+// Unreachable returns the labels granted but never referenced, and those
+// referenced but never granted, each sorted
+func (g *DependencyGraph) Unreachable() Orphans {
+	referenced := map[string]bool{}
+	for _, rule := range g.Rules {
+		for _, label := range rule.References {
+			referenced[label] = true
+		}
+	}
+	var orphans Orphans
+	for _, label := range g.Labels {
+		_, granted := g.grantedBy[label]
+		switch {
+		case granted && !referenced[label]:
+			orphans.Unreferenced = append(orphans.Unreferenced, label)
+		case !granted:
+			orphans.Undefined = append(orphans.Undefined, label)
+		}
+	}
+	return orphans
+}
@@ -0,0 +1,124 @@
+package policyset
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// diamond has access depend on two checks that share a third, across two
+// texts, with a quoted "$" that is not a reference
+var diamond = []string{
+	`A **user** gets access
+  if $admin is valid
+  and $manager is valid.
+
+admin. A **user** is admin
+  if $employee is valid
+  and __role__ of **user** is equal to "$root".`,
+	`# managers and employees
+manager. A **user** is manager
+  if §employee is valid.
+
+employee. A **user** is employee
+  if __status__ of **user** is equal to "active".`,
+}
+
+func golden(t *testing.T, name string) string {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return string(raw)
+}
+
+// TestGraphDiamond tests the rules, labels and order of a diamond, and its
+// exports
+func TestGraphDiamond(t *testing.T) {
+	g, err := Graph(diamond)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Rule{
+		{Source: 0, Position: 0, Header: "A **user** gets access", References: []string{"admin", "manager"}},
+		{Source: 0, Position: 1, Label: "admin", Header: "admin. A **user** is admin", References: []string{"employee"}},
+		{Source: 1, Position: 2, Label: "manager", Header: "manager. A **user** is manager", References: []string{"employee"}},
+		{Source: 1, Position: 3, Label: "employee", Header: "employee. A **user** is employee"},
+	}, g.Rules)
+	assert.Equal(t, []string{"admin", "employee", "manager"}, g.Labels)
+
+	sorted, err := g.TopoSort()
+	require.NoError(t, err)
+	var names []string
+	for _, rule := range sorted {
+		names = append(names, rule.Name())
+	}
+	assert.Equal(t, []string{"employee", "admin", "manager", "rule 1"}, names)
+	assert.Empty(t, g.Cycles())
+	assert.Equal(t, Orphans{}, g.Unreachable())
+
+	assert.Equal(t, golden(t, "diamond.dot"), g.DOT())
+	assert.Equal(t, golden(t, "diamond.mmd"), g.Mermaid())
+}
+
+// TestGraphChain tests that a chain sorts dependencies first whatever order
+// it is written in
+func TestGraphChain(t *testing.T) {
+	g, err := Graph([]string{
+		"A **user** gets access if $b is valid.",
+		"b. A **user** gets b if $c is valid.",
+		"c. A **user** gets c if __age__ of **user** is greater than 18.",
+	})
+	require.NoError(t, err)
+	sorted, err := g.TopoSort()
+	require.NoError(t, err)
+	require.Len(t, sorted, 3)
+	assert.Equal(t, []int{2, 1, 0}, []int{sorted[0].Position, sorted[1].Position, sorted[2].Position})
+}
+
+// TestGraphCycles tests that loops are reported rather than sorted
+func TestGraphCycles(t *testing.T) {
+	g, err := Graph([]string{`A **user** gets access if $a is valid.
+
+b. A **user** gets b if $c is valid.
+
+a. A **user** gets a if $b is valid.
+
+c. A **user** gets c if $a is valid.
+
+self. A **user** gets self if $self is valid.`})
+	require.NoError(t, err)
+
+	assert.Equal(t, [][]string{{"a", "b", "c"}, {"self"}}, g.Cycles())
+	_, err = g.TopoSort()
+	var cycleErr *CycleError
+	require.ErrorAs(t, err, &cycleErr)
+	assert.EqualError(t, err, "policyset: labels depend on each other: a -> b -> c -> a; self -> self")
+	assert.Equal(t, golden(t, "cycle.mmd"), g.Mermaid())
+}
+
+// TestGraphOrphans tests labels granted but never used and used but never
+// granted
+func TestGraphOrphans(t *testing.T) {
+	g, err := Graph([]string{
+		"top. A **user** gets access if $ghost is valid and $used is valid.",
+		"used. A **user** gets used if __age__ of **user** is greater than 18.",
+		"spare. A **user** gets spare if __age__ of **user** is less than 5.",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Orphans{Unreferenced: []string{"spare", "top"}, Undefined: []string{"ghost"}}, g.Unreachable())
+	assert.Equal(t, golden(t, "orphans.dot"), g.DOT())
+	assert.Contains(t, g.Mermaid(), "    class l1 undefined\n")
+
+	_, err = g.TopoSort()
+	assert.NoError(t, err)
+}
+
+// TestGraphErrors tests text without rules and labels granted twice
+func TestGraphErrors(t *testing.T) {
+	_, err := Graph([]string{"# just a comment"})
+	assert.EqualError(t, err, "policyset: rules[0] holds no rule")
+	_, err = Graph([]string{"a. A **user** gets x if $b is valid.", "a. A **user** gets y if $b is valid."})
+	assert.EqualError(t, err, `policyset: label "a" is granted by both rule 1 and rule 2`)
+}
@@ -0,0 +1,250 @@
+// Package replay evaluates the decisions in audit records again, against the
+// engine as it is now, to reproduce a disputed decision or to check that a
+// new engine release decides recorded traffic the same way.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/respdiff"
+)
+
+const maxErrors = 10
+
+var (
+	// ErrUnresolvedRule is a record whose rule hash matches neither the rule
+	// text it carries nor any rule the resolvers know
+	ErrUnresolvedRule = errors.New("replay: rule not found for hash")
+	// ErrNoInput is a record without the data the decision was made on, e.g.
+	// one written without client.WithAuditPayloads
+	ErrNoInput = errors.New("replay: record has no input data")
+)
+
+// DecisionRecord is an audit record of one decision
+type DecisionRecord = client.AuditRecord
+
+// Resolver finds a rule's text by the hash audit records identify it by;
+// *policyset.Corpus is one
+type Resolver interface {
+	RuleText(hash string) (string, bool)
+}
+
+// Rules is a Resolver over the rule texts given
+type Rules map[string]string
+
+// NewRules returns the Resolver for texts
+func NewRules(texts ...string) Rules {
+	rules := make(Rules, len(texts))
+	for _, text := range texts {
+		rules[client.RuleHash(text)] = text
+	}
+	return rules
+}
+
+// RuleText returns the text hashing to hash
+func (r Rules) RuleText(hash string) (string, bool) {
+	text, ok := r[hash]
+	return text, ok
+}
+
+// Option configures FromRecord and FromNDJSON
+type Option func(*config)
+
+type config struct {
+	resolvers []Resolver
+	unpinned  bool
+	diff      []respdiff.Option
+}
+
+// WithResolver looks up rules the records do not carry in r; several
+// resolvers are tried in order
+func WithResolver(r Resolver) Option {
+	return func(c *config) {
+		c.resolvers = append(c.resolvers, r)
+	}
+}
+
+// WithCurrentTime evaluates at the current time rather than pinning each
+// evaluation to the time its record was made, see
+// client.ContextWithEvaluationTime
+func WithCurrentTime() Option {
+	return func(c *config) {
+		c.unpinned = true
+	}
+}
+
+// WithDiffOptions passes opts to respdiff.Compare
+func WithDiffOptions(opts ...respdiff.Option) Option {
+	return func(c *config) {
+		c.diff = append(c.diff, opts...)
+	}
+}
+
+// ReplayResult is one record evaluated again
+type ReplayResult struct {
+	Record   DecisionRecord
+	Response *client.PolicyResponse
+	// Err is the replayed evaluation's error, which is compared like any
+	// other outcome
+	Err error
+	// Diff is how the replayed response differs from the recorded one, and
+	// Match is true when it is empty
+	Diff  *respdiff.Diff
+	Match bool
+}
+
+// FromRecord evaluates record's rule against its data with e and compares
+// the response with the recorded decision. The rule is the record's own copy
+// if it hashes to the record's RuleHash, and otherwise the resolvers' text
+// for the hash, failing with ErrUnresolvedRule if none has it. The
+// evaluation is pinned to the record's time unless WithCurrentTime is
+// given. A degraded record is compared like any other, so it will usually
+// mismatch.
+func FromRecord(ctx context.Context, e client.Evaluator, record DecisionRecord, opts ...Option) (*ReplayResult, error) {
+	cfg := &config{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg.replay(ctx, e, record)
+}
+
+func (cfg *config) replay(ctx context.Context, e client.Evaluator, record DecisionRecord) (*ReplayResult, error) {
+	rule, err := cfg.resolve(record)
+	if err != nil {
+		return nil, err
+	}
+	if len(record.Data) == 0 {
+		return nil, fmt.Errorf("%w: decision %s", ErrNoInput, record.DecisionID)
+	}
+	if !cfg.unpinned && !record.Time.IsZero() {
+		ctx = client.ContextWithEvaluationTime(ctx, record.Time)
+	}
+
+	result := &ReplayResult{Record: record}
+	result.Response, result.Err = e.Evaluate(ctx, client.PolicyRequest{Rule: rule, Data: record.Data})
+	replayed := result.Response
+	if result.Err != nil {
+		replayed = outcome(false, nil, result.Err.Error(), errorCode(result.Err))
+	}
+	recorded := outcome(record.Result, record.Labels, record.Error, record.ErrorCode)
+	result.Diff = respdiff.Compare(recorded, replayed, cfg.diff...)
+	result.Match = result.Diff.Empty()
+	return result, nil
+}
+
+// resolve returns the text of record's rule
+func (cfg *config) resolve(record DecisionRecord) (string, error) {
+	if record.Rule != "" && client.RuleHash(record.Rule) == record.RuleHash {
+		return record.Rule, nil
+	}
+	for _, r := range cfg.resolvers {
+		if text, ok := r.RuleText(record.RuleHash); ok {
+			return text, nil
+		}
+	}
+	return "", fmt.Errorf("%w %s in decision %s", ErrUnresolvedRule, record.RuleHash, record.DecisionID)
+}
+
+// outcome is a decision as respdiff compares it
+func outcome(result bool, labels map[string]bool, message, code string) *client.PolicyResponse {
+	response := &client.PolicyResponse{Result: result, Labels: labels}
+	if message != "" {
+		response.Error = &message
+		response.EngineError = &client.EngineError{Code: code, Message: message}
+	}
+	return response
+}
+
+func errorCode(err error) string {
+	var engineErr *client.EngineError
+	if errors.As(err, &engineErr) {
+		return engineErr.Code
+	}
+	return ""
+}
+
+// Summary is what FromNDJSON found
+type Summary struct {
+	// Records counts the lines read, and Matched and Mismatched the records
+	// replayed
+	Records    int `json:"records"`
+	Matched    int `json:"matched"`
+	Mismatched int `json:"mismatched"`
+	// Unresolved counts records whose rule could not be found, and Failed
+	// those that could not be read or replayed for another reason
+	Unresolved int `json:"unresolved"`
+	Failed     int `json:"failed"`
+	// Mismatches are the records that replayed differently, in file order
+	Mismatches []*ReplayResult `json:"-"`
+	// Errors are the first of the unresolved and failed records' errors
+	Errors []error `json:"-"`
+}
+
+func (s *Summary) String() string {
+	return fmt.Sprintf("%d records: %d matched, %d mismatched, %d unresolved, %d failed",
+		s.Records, s.Matched, s.Mismatched, s.Unresolved, s.Failed)
+}
+
+// FromNDJSON replays every record in r, an audit file as
+// client.JSONAuditSink writes it, one at a time. A line that is not a
+// record, or a record that cannot be replayed, is counted and the replay
+// carries on; the error is for reading r and ctx ending.
+func FromNDJSON(ctx context.Context, e client.Evaluator, r io.Reader, opts ...Option) (*Summary, error) {
+	cfg := &config{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	summary := &Summary{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		summary.Records++
+		var record DecisionRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			summary.fail(fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+		result, err := cfg.replay(ctx, e, record)
+		switch {
+		case errors.Is(err, ErrUnresolvedRule):
+			summary.Unresolved++
+			summary.keep(fmt.Errorf("line %d: %w", line, err))
+		case err != nil:
+			summary.fail(fmt.Errorf("line %d: %w", line, err))
+		case result.Match:
+			summary.Matched++
+		default:
+			summary.Mismatched++
+			summary.Mismatches = append(summary.Mismatches, result)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return summary, fmt.Errorf("failed to read audit records: %w", err)
+	}
+	return summary, nil
+}
+
+// fail counts a failed record and keeps its error
+func (s *Summary) fail(err error) {
+	s.Failed++
+	s.keep(err)
+}
+
+// keep keeps err, if there is room
+func (s *Summary) keep(err error) {
+	if len(s.Errors) < maxErrors {
+		s.Errors = append(s.Errors, err)
+	}
+}
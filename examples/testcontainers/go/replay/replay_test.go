@@ -0,0 +1,165 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"policy-engine-testcontainer-example/client"
+)
+
+const adultRule = "# Adult\nA **Person** gets adult\n  if the __age__ of the **Person** is at least 18.\n"
+
+// newAgeEngine starts an engine granting adult to a Person at least as old
+// as the age it holds, echoing the data as the real engine does
+func newAgeEngine(t *testing.T, age *atomic.Int64) *httptest.Server {
+	t.Helper()
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Rule string `json:"rule"`
+			Data struct {
+				Person struct {
+					Age int64 `json:"age"`
+				} `json:"Person"`
+			} `json:"data"`
+		}
+		var raw struct {
+			Data json.RawMessage `json:"data"`
+		}
+		var body bytes.Buffer
+		if _, err := body.ReadFrom(r.Body); err != nil ||
+			json.Unmarshal(body.Bytes(), &req) != nil || json.Unmarshal(body.Bytes(), &raw) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		adult := req.Data.Person.Age >= age.Load()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"result": adult,
+			"labels": map[string]bool{"adult": adult},
+			"rule":   []string{req.Rule},
+			"data":   raw.Data,
+		})
+	}))
+	t.Cleanup(engine.Close)
+	return engine
+}
+
+// recordDecisions evaluates adultRule for each age and returns the audit
+// records, with their payloads
+func recordDecisions(t *testing.T, engineURL string, ages ...int) []DecisionRecord {
+	t.Helper()
+	var out bytes.Buffer
+	sink := client.NewJSONAuditSink(&out)
+	recorder, err := client.New(engineURL, client.WithAuditSink(sink), client.WithAuditPayloads())
+	require.NoError(t, err)
+	for _, age := range ages {
+		_, err := recorder.EvaluatePolicy(context.Background(), adultRule, map[string]interface{}{
+			"Person": map[string]interface{}{"age": age},
+		}, false)
+		require.NoError(t, err)
+	}
+	var records []DecisionRecord
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var record DecisionRecord
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+// TestFromRecord tests that a record replays to a match, that an altered one
+// and one the engine now decides differently mismatch, and that rules are
+// resolved from the record or the resolvers
+func TestFromRecord(t *testing.T) {
+	var age atomic.Int64
+	age.Store(18)
+	engine := newAgeEngine(t, &age)
+	c, err := client.New(engine.URL)
+	require.NoError(t, err)
+	records := recordDecisions(t, engine.URL, 20, 16)
+	require.Len(t, records, 2)
+	require.Equal(t, adultRule, records[0].Rule)
+
+	for _, record := range records {
+		result, err := FromRecord(context.Background(), c, record)
+		require.NoError(t, err)
+		assert.True(t, result.Match, "%s", result.Diff)
+		assert.NoError(t, result.Err)
+	}
+
+	altered := records[1]
+	altered.Result = true
+	altered.Labels = map[string]bool{"adult": true}
+	result, err := FromRecord(context.Background(), c, altered)
+	require.NoError(t, err)
+	assert.False(t, result.Match)
+	assert.False(t, result.Diff.Empty())
+
+	// The engine changing its mind mismatches the same way
+	age.Store(21)
+	result, err = FromRecord(context.Background(), c, records[0])
+	require.NoError(t, err)
+	assert.False(t, result.Match)
+	age.Store(18)
+
+	// A rule text that does not hash to the record's rule is not trusted
+	bare := records[0]
+	bare.Rule = "tampered"
+	_, err = FromRecord(context.Background(), c, bare)
+	assert.ErrorIs(t, err, ErrUnresolvedRule)
+	assert.ErrorContains(t, err, bare.RuleHash)
+
+	result, err = FromRecord(context.Background(), c, bare, WithResolver(NewRules("other rule")), WithResolver(NewRules(adultRule)))
+	require.NoError(t, err)
+	assert.True(t, result.Match)
+
+	noData := records[0]
+	noData.Data = nil
+	_, err = FromRecord(context.Background(), c, noData)
+	assert.ErrorIs(t, err, ErrNoInput)
+}
+
+// TestFromNDJSON tests the summary of a file mixing matching, mismatching,
+// unresolved and malformed records
+func TestFromNDJSON(t *testing.T) {
+	var age atomic.Int64
+	age.Store(18)
+	engine := newAgeEngine(t, &age)
+	c, err := client.New(engine.URL)
+	require.NoError(t, err)
+	records := recordDecisions(t, engine.URL, 20, 16, 30)
+
+	records[1].Result = true
+	unresolved := records[2]
+	unresolved.Rule = ""
+	unresolved.RuleHash = client.RuleHash("gone")
+	var file bytes.Buffer
+	for _, record := range append(records, unresolved) {
+		line, err := json.Marshal(record)
+		require.NoError(t, err)
+		file.Write(append(line, '\n'))
+	}
+	file.WriteString("{not json\n\n")
+
+	summary, err := FromNDJSON(context.Background(), c, &file)
+	require.NoError(t, err)
+	assert.Equal(t, 5, summary.Records)
+	assert.Equal(t, 2, summary.Matched)
+	assert.Equal(t, 1, summary.Mismatched)
+	assert.Equal(t, 1, summary.Unresolved)
+	assert.Equal(t, 1, summary.Failed)
+	require.Len(t, summary.Mismatches, 1)
+	assert.Equal(t, records[1].DecisionID, summary.Mismatches[0].Record.DecisionID)
+	require.Len(t, summary.Errors, 2)
+	assert.ErrorIs(t, summary.Errors[0], ErrUnresolvedRule)
+	assert.Equal(t, "5 records: 2 matched, 1 mismatched, 1 unresolved, 1 failed", summary.String())
+}
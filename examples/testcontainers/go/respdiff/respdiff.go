@@ -0,0 +1,348 @@
+// Package respdiff compares policy responses by what they decide rather than
+// by how they are written, so regression checks see real changes only: the
+// result, the outcome, which labels were granted, and optionally the echoed
+// data and the trace, with numbers compared by value and volatile paths
+// ignored.
+package respdiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"policy-engine-testcontainer-example/client"
+)
+
+// Option configures Compare
+type Option func(*config)
+
+type config struct {
+	data   bool
+	traces bool
+	ignore [][]string
+}
+
+// WithData also compares the data the engine echoed back
+func WithData() Option {
+	return func(c *config) {
+		c.data = true
+	}
+}
+
+// WithTraces also compares the traces; a response without one compares as
+// an empty trace
+func WithTraces() Option {
+	return func(c *config) {
+		c.traces = true
+	}
+}
+
+// IgnorePaths leaves the values at paths, and everything under them, out of
+// the data and trace comparisons. Paths are written as Diff reports them,
+// e.g. "trace.execution[0].selector.pos", and "*" or "[*]" matches any key or
+// index, e.g. "trace.execution[*].conditions[*].evaluation_details".
+func IgnorePaths(paths ...string) Option {
+	return func(c *config) {
+		for _, path := range paths {
+			c.ignore = append(c.ignore, splitPath(path))
+		}
+	}
+}
+
+// Change is a value that differs between the two responses
+type Change struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// LabelChange is a label granted in one response and not the other
+type LabelChange struct {
+	Label string `json:"label"`
+	// Granted is true for a label only the second response grants, and false
+	// for one only the first grants
+	Granted bool `json:"granted"`
+}
+
+// PathKind is what happened to the value at a path
+type PathKind string
+
+const (
+	PathAdded   PathKind = "added"
+	PathRemoved PathKind = "removed"
+	PathChanged PathKind = "changed"
+)
+
+// PathChange is a difference in the data or trace
+type PathChange struct {
+	Path string      `json:"path"`
+	Kind PathKind    `json:"kind"`
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// Diff is what differs from the first response to the second. Its JSON form
+// is meant for tooling.
+type Diff struct {
+	Result *Change `json:"result,omitempty"`
+	// Outcome is how the engine answered: "ok", or its error code, or
+	// "error" for an error without one
+	Outcome *Change       `json:"outcome,omitempty"`
+	Labels  []LabelChange `json:"labels,omitempty"`
+	Data    []PathChange  `json:"data,omitempty"`
+	Trace   []PathChange  `json:"trace,omitempty"`
+}
+
+// Empty reports whether the responses are the same
+func (d *Diff) Empty() bool {
+	return d.Result == nil && d.Outcome == nil && len(d.Labels) == 0 && len(d.Data) == 0 && len(d.Trace) == 0
+}
+
+// String lists the differences one per line, or "no differences"
+func (d *Diff) String() string {
+	if d.Empty() {
+		return "no differences"
+	}
+	var lines []string
+	if d.Result != nil {
+		lines = append(lines, fmt.Sprintf("result: %v -> %v", d.Result.From, d.Result.To))
+	}
+	if d.Outcome != nil {
+		lines = append(lines, fmt.Sprintf("outcome: %v -> %v", d.Outcome.From, d.Outcome.To))
+	}
+	for _, label := range d.Labels {
+		verb := "denied"
+		if label.Granted {
+			verb = "granted"
+		}
+		lines = append(lines, fmt.Sprintf("label %s: %s", label.Label, verb))
+	}
+	for _, change := range append(append([]PathChange(nil), d.Data...), d.Trace...) {
+		switch change.Kind {
+		case PathAdded:
+			lines = append(lines, fmt.Sprintf("%s: added %s", change.Path, format(change.To)))
+		case PathRemoved:
+			lines = append(lines, fmt.Sprintf("%s: removed %s", change.Path, format(change.From)))
+		default:
+			lines = append(lines, fmt.Sprintf("%s: %s -> %s", change.Path, format(change.From), format(change.To)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func format(v interface{}) string {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(encoded)
+}
+
+// Compare returns what differs from a to b. A nil response compares as an
+// empty one.
+func Compare(a, b *client.PolicyResponse, opts ...Option) *Diff {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if a == nil {
+		a = &client.PolicyResponse{}
+	}
+	if b == nil {
+		b = &client.PolicyResponse{}
+	}
+
+	d := &Diff{}
+	if a.Result != b.Result {
+		d.Result = &Change{From: a.Result, To: b.Result}
+	}
+	if from, to := outcome(a), outcome(b); from != to {
+		d.Outcome = &Change{From: from, To: to}
+	}
+	d.Labels = compareLabels(a.Labels, b.Labels)
+	if cfg.data {
+		d.Data = cfg.compareValues("data", normalize(a.Data), normalize(b.Data))
+	}
+	if cfg.traces {
+		d.Trace = cfg.compareValues("trace", normalize(a.Trace), normalize(b.Trace))
+	}
+	return d
+}
+
+func outcome(r *client.PolicyResponse) string {
+	switch {
+	case r.EngineError != nil && r.EngineError.Code != "":
+		return r.EngineError.Code
+	case r.EngineError != nil || r.Error != nil:
+		return "error"
+	default:
+		return "ok"
+	}
+}
+
+// compareLabels compares the sets of granted labels
+func compareLabels(a, b map[string]bool) []LabelChange {
+	var changes []LabelChange
+	for label, granted := range b {
+		if granted && !a[label] {
+			changes = append(changes, LabelChange{Label: label, Granted: true})
+		}
+	}
+	for label, granted := range a {
+		if granted && !b[label] {
+			changes = append(changes, LabelChange{Label: label, Granted: false})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Label < changes[j].Label
+	})
+	return changes
+}
+
+// normalize gives v the form it has as decoded JSON, so numbers are float64
+// whatever type or formatting they arrived in
+func normalize(v interface{}) interface{} {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return v
+	}
+	// An absent trace and an empty one are the same
+	if m, ok := decoded.(map[string]interface{}); ok && len(m) == 0 {
+		return nil
+	}
+	return decoded
+}
+
+// compareValues walks two decoded JSON values, reporting each path at which
+// they differ
+func (c *config) compareValues(root string, a, b interface{}) []PathChange {
+	var changes []PathChange
+	var walk func(path []string, a, b interface{}, aOK, bOK bool)
+	walk = func(path []string, a, b interface{}, aOK, bOK bool) {
+		if c.ignored(path) {
+			return
+		}
+		switch {
+		case !aOK && !bOK:
+			return
+		case !aOK:
+			changes = append(changes, PathChange{Path: joinPath(path), Kind: PathAdded, To: b})
+			return
+		case !bOK:
+			changes = append(changes, PathChange{Path: joinPath(path), Kind: PathRemoved, From: a})
+			return
+		}
+
+		switch a := a.(type) {
+		case map[string]interface{}:
+			if b, ok := b.(map[string]interface{}); ok {
+				keys := map[string]bool{}
+				for key := range a {
+					keys[key] = true
+				}
+				for key := range b {
+					keys[key] = true
+				}
+				sorted := make([]string, 0, len(keys))
+				for key := range keys {
+					sorted = append(sorted, key)
+				}
+				sort.Strings(sorted)
+				for _, key := range sorted {
+					av, aOK := a[key]
+					bv, bOK := b[key]
+					walk(append(path[:len(path):len(path)], key), av, bv, aOK, bOK)
+				}
+				return
+			}
+		case []interface{}:
+			if b, ok := b.([]interface{}); ok {
+				n := len(a)
+				if len(b) > n {
+					n = len(b)
+				}
+				for i := 0; i < n; i++ {
+					var av, bv interface{}
+					if i < len(a) {
+						av = a[i]
+					}
+					if i < len(b) {
+						bv = b[i]
+					}
+					walk(append(path[:len(path):len(path)], "["+strconv.Itoa(i)+"]"), av, bv, i < len(a), i < len(b))
+				}
+				return
+			}
+		}
+		if !reflect.DeepEqual(a, b) {
+			changes = append(changes, PathChange{Path: joinPath(path), Kind: PathChanged, From: a, To: b})
+		}
+	}
+	walk([]string{root}, a, b, a != nil, b != nil)
+	return changes
+}
+
+// ignored reports whether an IgnorePaths pattern matches path
+func (c *config) ignored(path []string) bool {
+	for _, pattern := range c.ignore {
+		if len(pattern) != len(path) {
+			continue
+		}
+		matched := true
+		for i, segment := range pattern {
+			isIndex := strings.HasPrefix(path[i], "[")
+			if segment == path[i] || (segment == "*" && !isIndex) || (segment == "[*]" && isIndex) {
+				continue
+			}
+			matched = false
+			break
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPath splits "trace.execution[0].result" into its keys and indexes
+func splitPath(path string) []string {
+	var segments []string
+	for _, part := range strings.Split(path, ".") {
+		for {
+			open := strings.Index(part, "[")
+			if open < 0 {
+				break
+			}
+			if open > 0 {
+				segments = append(segments, part[:open])
+			}
+			end := strings.Index(part[open:], "]")
+			if end < 0 {
+				break
+			}
+			segments = append(segments, part[open:open+end+1])
+			part = part[open+end+1:]
+		}
+		if part != "" {
+			segments = append(segments, part)
+		}
+	}
+	return segments
+}
+
+func joinPath(path []string) string {
+	var b strings.Builder
+	for i, segment := range path {
+		if i > 0 && !strings.HasPrefix(segment, "[") {
+			b.WriteByte('.')
+		}
+		b.WriteString(segment)
+	}
+	return b.String()
+}
@@ -0,0 +1,122 @@
+package respdiff
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"policy-engine-testcontainer-example/client"
+)
+
+func decode(t *testing.T, raw string) *client.PolicyResponse {
+	t.Helper()
+	var response client.PolicyResponse
+	require.NoError(t, json.Unmarshal([]byte(raw), &response))
+	return &response
+}
+
+// TestCompareIgnoresFormatting tests the differences that should not count:
+// label order, number formatting and ignored trace paths
+func TestCompareIgnoresFormatting(t *testing.T) {
+	a := decode(t, `{"result":true,"rule":["r"],"labels":{"adult":true,"vip":false,"senior":true},
+		"data":{"Person":{"age":65,"spend":1000.50}},
+		"trace":{"execution":[{"result":true,"elapsed_us":120,"outcome":{"value":"adult","pos":{"line":1}}}]}}`)
+	b := decode(t, `{"result":true,"rule":["r"],"labels":{"senior":true,"adult":true},
+		"data":{"Person":{"spend":1.0005e3,"age":65.0}},
+		"trace":{"execution":[{"outcome":{"value":"adult","pos":{"line":3}},"elapsed_us":480,"result":true}]}}`)
+
+	diff := Compare(a, b, WithData(), WithTraces(), IgnorePaths("trace.execution[*].elapsed_us", "trace.execution[*].*.pos"))
+	assert.True(t, diff.Empty(), diff.String())
+	assert.Equal(t, "no differences", diff.String())
+
+	// Go values compare as their JSON would
+	diff = Compare(
+		&client.PolicyResponse{Data: map[string]interface{}{"n": 1, "m": float32(0.5)}},
+		&client.PolicyResponse{Data: map[string]float64{"n": 1.0, "m": 0.5}},
+		WithData(),
+	)
+	assert.True(t, diff.Empty(), diff.String())
+
+	// Without the ignored paths the trace differs
+	diff = Compare(a, b, WithTraces())
+	assert.Equal(t, []PathChange{
+		{Path: "trace.execution[0].elapsed_us", Kind: PathChanged, From: 120.0, To: 480.0},
+		{Path: "trace.execution[0].outcome.pos.line", Kind: PathChanged, From: 1.0, To: 3.0},
+	}, diff.Trace)
+}
+
+// TestCompareReportsChanges tests genuine result, outcome, label, data and
+// trace changes
+func TestCompareReportsChanges(t *testing.T) {
+	a := decode(t, `{"result":true,"rule":["r"],"labels":{"adult":true,"vip":false},
+		"data":{"Person":{"age":65,"tags":["a","b"]}},
+		"trace":{"execution":[{"result":true}]}}`)
+	b := decode(t, `{"result":false,"rule":["r"],"labels":{"vip":true},
+		"data":{"Person":{"age":64,"tags":["a"],"country":"NL"}},
+		"trace":{"execution":[{"result":false}]}}`)
+
+	diff := Compare(a, b, WithData(), WithTraces())
+	require.False(t, diff.Empty())
+	assert.Equal(t, &Change{From: true, To: false}, diff.Result)
+	assert.Nil(t, diff.Outcome)
+	assert.Equal(t, []LabelChange{{Label: "adult", Granted: false}, {Label: "vip", Granted: true}}, diff.Labels)
+	assert.Equal(t, `result: true -> false
+label adult: denied
+label vip: granted
+data.Person.age: 65 -> 64
+data.Person.country: added "NL"
+data.Person.tags[1]: removed "b"
+trace.execution[0].result: true -> false`, diff.String())
+
+	// Data and traces are only compared when asked
+	diff = Compare(a, b)
+	assert.Empty(t, diff.Data)
+	assert.Empty(t, diff.Trace)
+
+	encoded, err := json.Marshal(Compare(a, decode(t, `{"result":true,"rule":["r"],"labels":{"adult":true}}`)))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{}`, string(encoded))
+	encoded, err = json.Marshal(diff)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"result":{"from":true,"to":false},"labels":[{"label":"adult","granted":false},{"label":"vip","granted":true}]}`, string(encoded))
+}
+
+// TestCompareOutcome tests that engine errors are compared by code
+func TestCompareOutcome(t *testing.T) {
+	ok := &client.PolicyResponse{Result: false}
+	failed := &client.PolicyResponse{EngineError: &client.EngineError{Code: "evaluation_error", Message: "Evaluation error: x"}}
+	reworded := &client.PolicyResponse{EngineError: &client.EngineError{Code: "evaluation_error", Message: "Evaluation error: y"}}
+
+	assert.Equal(t, &Change{From: "ok", To: "evaluation_error"}, Compare(ok, failed).Outcome)
+	assert.True(t, Compare(failed, reworded).Empty())
+	assert.True(t, Compare(nil, &client.PolicyResponse{}).Empty())
+}
+
+// TestSplitPath tests reading the paths IgnorePaths is given
+func TestSplitPath(t *testing.T) {
+	assert.Equal(t, []string{"trace", "execution", "[0]", "conditions", "[*]", "pos"}, splitPath("trace.execution[0].conditions[*].pos"))
+	assert.Equal(t, "trace.execution[0].conditions[2].pos", joinPath([]string{"trace", "execution", "[0]", "conditions", "[2]", "pos"}))
+}
+
+// TestShadowSink tests that shadow answers deciding differently, or failing
+// alone, are reported as mismatches
+func TestShadowSink(t *testing.T) {
+	var got []ShadowComparison
+	sink := ShadowSink(func(c ShadowComparison) { got = append(got, c) })
+
+	granted := &client.PolicyResponse{Result: true, Labels: map[string]bool{"adult": true}}
+	sink(client.ShadowComparison{Primary: granted, Shadow: &client.PolicyResponse{Result: true, Labels: map[string]bool{"adult": true}}})
+	sink(client.ShadowComparison{Primary: granted, Shadow: &client.PolicyResponse{Result: true}})
+	sink(client.ShadowComparison{Primary: granted, ShadowErr: errors.New("connection refused")})
+	sink(client.ShadowComparison{PrimaryErr: errors.New("connection refused"), ShadowErr: errors.New("connection refused")})
+
+	require.Len(t, got, 4)
+	assert.False(t, got[0].Mismatch())
+	assert.True(t, got[1].Mismatch())
+	assert.Equal(t, []LabelChange{{Label: "adult", Granted: false}}, got[1].Diff.Labels)
+	assert.True(t, got[2].Mismatch())
+	assert.False(t, got[3].Mismatch())
+}
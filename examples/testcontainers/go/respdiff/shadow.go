@@ -0,0 +1,24 @@
+package respdiff
+
+import "policy-engine-testcontainer-example/client"
+
+// ShadowComparison is a client.ShadowComparison with what the shadow
+// answered differently
+type ShadowComparison struct {
+	client.ShadowComparison
+	Diff *Diff
+}
+
+// Mismatch reports whether the shadow decided differently from the primary,
+// or only one of them failed
+func (c ShadowComparison) Mismatch() bool {
+	return !c.Diff.Empty() || (c.PrimaryErr == nil) != (c.ShadowErr == nil)
+}
+
+// ShadowSink returns a sink for client.WithShadow that compares each pair of
+// answers with opts and passes the result on to sink
+func ShadowSink(sink func(ShadowComparison), opts ...Option) func(client.ShadowComparison) {
+	return func(c client.ShadowComparison) {
+		sink(ShadowComparison{ShadowComparison: c, Diff: Compare(c.Primary, c.Shadow, opts...)})
+	}
+}
@@ -0,0 +1,458 @@
+// Package rulebuilder writes rules in the engine's natural-language DSL from
+// Go, so the **object** and __property__ markers, operators and literals are
+// always spelled the way the engine parses them:
+//
+//	rulebuilder.Object("Person").Gets("senior_discount").
+//		If(rulebuilder.Property("age").Of("Person").GreaterOrEqual(65))
+//
+// renders "A **Person** gets senior_discount if the __age__ of the **Person**
+// is greater than or equal to 65." Names are checked as the rule is built
+// and the first bad one is returned by Build.
+package rulebuilder
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// identifier is an object or property name
+	identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// labelName is a label as references spell it
+	labelName = regexp.MustCompile(`^[A-Za-z0-9]+(\.[A-Za-z0-9]+)*$`)
+)
+
+// Rule is a rule being built. Its methods return the rule itself, so a rule
+// is written as one expression.
+type Rule struct {
+	label      string
+	object     string
+	verb       string
+	outcome    string
+	conditions []string
+	operators  []string
+	err        error
+}
+
+// Object starts a rule about the object name, e.g. "A **Person**"
+func Object(name string) *Rule {
+	r := &Rule{object: name}
+	r.check(objectName(name))
+	return r
+}
+
+// Labelled gives the rule a label, which Label conditions of other rules and
+// the response's Labels refer to
+func (r *Rule) Labelled(label string) *Rule {
+	if !labelName.MatchString(label) {
+		r.fail(fmt.Errorf("invalid label %q: use letters and digits, with dots between parts", label))
+	}
+	r.label = label
+	return r
+}
+
+// Gets sets the outcome the rule grants, as in "gets senior_discount"
+func (r *Rule) Gets(outcome string) *Rule {
+	return r.Outcome("gets", outcome)
+}
+
+// Is sets the outcome the rule grants, as in "is an adult"
+func (r *Rule) Is(outcome string) *Rule {
+	return r.Outcome("is", outcome)
+}
+
+// Outcome sets the outcome the rule grants with a verb of the engine's
+// choice, such as "qualifies for" or "passes"
+func (r *Rule) Outcome(verb, outcome string) *Rule {
+	switch {
+	case verb == "" || strings.TrimSpace(verb) != verb:
+		r.fail(fmt.Errorf("invalid verb %q", verb))
+	case outcome == "" || strings.TrimSpace(outcome) != outcome:
+		r.fail(fmt.Errorf("invalid outcome %q: it must not be empty or start or end with a space", outcome))
+	case strings.Contains(outcome, ".") || strings.Contains(" "+outcome+" ", " if "):
+		r.fail(fmt.Errorf("invalid outcome %q: it must not hold a dot or the word if", outcome))
+	}
+	r.verb, r.outcome = verb, outcome
+	return r
+}
+
+// If sets the rule's first condition
+func (r *Rule) If(c Condition) *Rule {
+	if len(r.conditions) > 0 {
+		r.fail(fmt.Errorf("the rule has a first condition already; use And or Or"))
+	}
+	return r.add("", c)
+}
+
+// And adds a condition that must hold as well
+func (r *Rule) And(c Condition) *Rule {
+	return r.add("and", c)
+}
+
+// Or adds a condition that may hold instead. The engine binds and tighter
+// than or, so a If b Or c And d holds when b does or both c and d do.
+func (r *Rule) Or(c Condition) *Rule {
+	return r.add("or", c)
+}
+
+func (r *Rule) add(operator string, c Condition) *Rule {
+	if operator != "" && len(r.conditions) == 0 {
+		r.fail(fmt.Errorf("%s before the first condition; use If", operator))
+	}
+	if c.err != nil {
+		r.fail(c.err)
+	}
+	r.operators = append(r.operators, operator)
+	r.conditions = append(r.conditions, c.text)
+	return r
+}
+
+// Build returns the rule's text, or the first problem found building it
+func (r *Rule) Build() (string, error) {
+	if r.err != nil {
+		return "", r.err
+	}
+	if r.outcome == "" {
+		return "", fmt.Errorf("rulebuilder: rule about %s has no outcome", r.object)
+	}
+	if len(r.conditions) == 0 {
+		return "", fmt.Errorf("rulebuilder: rule about %s has no condition", r.object)
+	}
+
+	var b strings.Builder
+	if r.label != "" {
+		b.WriteString(r.label + ". ")
+	}
+	b.WriteString(article(r.object) + " **" + r.object + "** " + r.verb + " " + r.outcome + " if ")
+	for i, condition := range r.conditions {
+		if i > 0 {
+			b.WriteString(" " + r.operators[i] + " ")
+		}
+		b.WriteString(condition)
+	}
+	b.WriteString(".")
+	return b.String(), nil
+}
+
+// MustBuild is Build for rules known to be valid, such as those written in
+// tests; it panics on a problem
+func (r *Rule) MustBuild() string {
+	text, err := r.Build()
+	if err != nil {
+		panic(err)
+	}
+	return text
+}
+
+// String is the rule's text, or the problem building it
+func (r *Rule) String() string {
+	text, err := r.Build()
+	if err != nil {
+		return err.Error()
+	}
+	return text
+}
+
+func (r *Rule) check(err error) {
+	if err != nil {
+		r.fail(err)
+	}
+}
+
+// fail keeps the first problem, which is the one Build reports
+func (r *Rule) fail(err error) {
+	if r.err == nil {
+		r.err = fmt.Errorf("rulebuilder: %w", err)
+	}
+}
+
+// Build builds rules, a policy whose rules may refer to each other, in
+// order; the first problem found is returned
+func Build(rules ...*Rule) ([]string, error) {
+	texts := make([]string, len(rules))
+	for i, r := range rules {
+		text, err := r.Build()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		texts[i] = text
+	}
+	return texts, nil
+}
+
+// article opens a rule for object. The engine takes either, so a U reads as
+// in "A **User**" rather than following the sound of each word.
+func article(object string) string {
+	if strings.ContainsRune("AEIOaeio", rune(object[0])) {
+		return "An"
+	}
+	return "A"
+}
+
+// objectName checks an object name, which may be nested as in
+// "Customer.address"
+func objectName(name string) error {
+	for _, part := range strings.Split(name, ".") {
+		if !identifier.MatchString(part) {
+			return fmt.Errorf("invalid object %q: use letters, digits and underscores, with no spaces", name)
+		}
+	}
+	return nil
+}
+
+// Condition is one condition of a rule, made by a comparison on a Value or
+// by Reference or Label
+type Condition struct {
+	text string
+	err  error
+}
+
+// Reference is the condition that object is granted another rule's outcome,
+// written as that rule's verb and outcome, e.g. Reference("Person", "is an
+// adult") for a rule built with Is("an adult")
+func Reference(object, outcome string) Condition {
+	if err := objectName(object); err != nil {
+		return Condition{err: err}
+	}
+	if outcome == "" || strings.ContainsAny(outcome, ".\n") {
+		return Condition{err: fmt.Errorf("invalid reference %q: it must not be empty or hold a dot", outcome)}
+	}
+	return Condition{text: "the **" + object + "** " + outcome}
+}
+
+// Label is the condition that the rule labelled label passed
+func Label(label string) Condition {
+	if !labelName.MatchString(label) {
+		return Condition{err: fmt.Errorf("invalid label %q: use letters and digits, with dots between parts", label)}
+	}
+	return Condition{text: "§" + label + " is valid"}
+}
+
+// Value is what a condition compares: a property of an object, or the
+// number or length of one
+type Value struct {
+	text string
+	err  error
+}
+
+// PropertyPath is a property being named, which Of places on an object
+type PropertyPath struct {
+	prefix string
+	names  []string
+}
+
+// Property names a property, or with several names a property of a
+// property, outermost first: Property("city", "address") is the city of the
+// address
+func Property(names ...string) PropertyPath {
+	return PropertyPath{prefix: "the ", names: names}
+}
+
+// NumberOf counts the items of a collection property
+func NumberOf(names ...string) PropertyPath {
+	return PropertyPath{prefix: "the number of ", names: names}
+}
+
+// LengthOf measures a property's length
+func LengthOf(names ...string) PropertyPath {
+	return PropertyPath{prefix: "the length of ", names: names}
+}
+
+// Of places the property on object
+func (p PropertyPath) Of(object string) Value {
+	if len(p.names) == 0 {
+		return Value{err: fmt.Errorf("property of %s has no name", object)}
+	}
+	if err := objectName(object); err != nil {
+		return Value{err: err}
+	}
+	parts := make([]string, len(p.names))
+	for i, name := range p.names {
+		if !identifier.MatchString(name) {
+			return Value{err: fmt.Errorf("invalid property %q: use letters, digits and underscores, with no spaces", name)}
+		}
+		parts[i] = "__" + name + "__"
+	}
+	return Value{text: p.prefix + strings.Join(parts, " of the ") + " of the **" + object + "**"}
+}
+
+// compare is the condition "v operator literal"
+func (v Value) compare(operator string, literal interface{}) Condition {
+	if v.err != nil {
+		return Condition{err: v.err}
+	}
+	text, err := render(literal)
+	if err != nil {
+		return Condition{err: err}
+	}
+	return Condition{text: v.text + " " + operator + " " + text}
+}
+
+// GreaterOrEqual is v >= literal
+func (v Value) GreaterOrEqual(literal interface{}) Condition {
+	return v.compare("is greater than or equal to", literal)
+}
+
+// Greater is v > literal
+func (v Value) Greater(literal interface{}) Condition {
+	return v.compare("is greater than", literal)
+}
+
+// LessOrEqual is v <= literal
+func (v Value) LessOrEqual(literal interface{}) Condition {
+	return v.compare("is less than or equal to", literal)
+}
+
+// Less is v < literal
+func (v Value) Less(literal interface{}) Condition {
+	return v.compare("is less than", literal)
+}
+
+// AtLeast is GreaterOrEqual, written as "is at least"
+func (v Value) AtLeast(literal interface{}) Condition {
+	return v.compare("is at least", literal)
+}
+
+// Equal is v == literal
+func (v Value) Equal(literal interface{}) Condition {
+	return v.compare("is equal to", literal)
+}
+
+// NotEqual is v != literal
+func (v Value) NotEqual(literal interface{}) Condition {
+	return v.compare("is not equal to", literal)
+}
+
+// Contains is a string or list property holding literal
+func (v Value) Contains(literal interface{}) Condition {
+	return v.compare("contains", literal)
+}
+
+// Later is a date property after literal, a time.Time
+func (v Value) Later(literal time.Time) Condition {
+	return v.compare("is later than", literal)
+}
+
+// Earlier is a date property before literal, a time.Time
+func (v Value) Earlier(literal time.Time) Condition {
+	return v.compare("is earlier than", literal)
+}
+
+// OlderThan is a date property more than d before the engine's today
+func (v Value) OlderThan(d Duration) Condition {
+	return v.compare("is older than", d)
+}
+
+// YoungerThan is a date property less than d before the engine's today
+func (v Value) YoungerThan(d Duration) Condition {
+	return v.compare("is younger than", d)
+}
+
+// Within is a date property within d of the engine's today
+func (v Value) Within(d Duration) Condition {
+	return v.compare("is within", d)
+}
+
+// In is v being one of literals
+func (v Value) In(literals ...interface{}) Condition {
+	return v.list("is in", literals)
+}
+
+// NotIn is v being none of literals
+func (v Value) NotIn(literals ...interface{}) Condition {
+	return v.list("is not in", literals)
+}
+
+func (v Value) list(operator string, literals []interface{}) Condition {
+	if v.err != nil {
+		return Condition{err: v.err}
+	}
+	if len(literals) == 0 {
+		return Condition{err: fmt.Errorf("%s list is empty", operator)}
+	}
+	texts := make([]string, len(literals))
+	for i, literal := range literals {
+		text, err := render(literal)
+		if err != nil {
+			return Condition{err: err}
+		}
+		texts[i] = text
+	}
+	return Condition{text: v.text + " " + operator + " [" + strings.Join(texts, ", ") + "]"}
+}
+
+// Empty is v having no value
+func (v Value) Empty() Condition {
+	return Condition{text: v.text + " is empty", err: v.err}
+}
+
+// NotEmpty is v having a value
+func (v Value) NotEmpty() Condition {
+	return Condition{text: v.text + " is not empty", err: v.err}
+}
+
+// Duration is a length of time as the engine's date conditions write it
+type Duration struct {
+	Amount float64
+	// Unit is singular, e.g. "year", and made plural when Amount is not 1
+	Unit string
+}
+
+// Years, Months, Weeks, Days, Hours and Minutes are durations of n of the unit
+func Years(n float64) Duration   { return Duration{n, "year"} }
+func Months(n float64) Duration  { return Duration{n, "month"} }
+func Weeks(n float64) Duration   { return Duration{n, "week"} }
+func Days(n float64) Duration    { return Duration{n, "day"} }
+func Hours(n float64) Duration   { return Duration{n, "hour"} }
+func Minutes(n float64) Duration { return Duration{n, "minute"} }
+
+var units = map[string]string{
+	"second": "seconds", "minute": "minutes", "hour": "hours", "day": "days", "week": "weeks",
+	"month": "months", "year": "years", "decade": "decades", "century": "centuries",
+}
+
+// render writes a literal as the engine parses it
+func render(literal interface{}) (string, error) {
+	switch v := literal.(type) {
+	case string:
+		if strings.ContainsAny(v, "\"\n") {
+			return "", fmt.Errorf("string %q cannot hold a quote or a newline", v)
+		}
+		return `"` + v + `"`, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return number(float64(v))
+	case int64:
+		return number(float64(v))
+	case float64:
+		return number(v)
+	case time.Time:
+		return v.Format("2006-01-02"), nil
+	case Duration:
+		plural, ok := units[v.Unit]
+		if !ok {
+			return "", fmt.Errorf("unknown duration unit %q", v.Unit)
+		}
+		amount, err := number(v.Amount)
+		if err != nil {
+			return "", err
+		}
+		if v.Amount != 1 {
+			return amount + " " + plural, nil
+		}
+		return amount + " " + v.Unit, nil
+	}
+	return "", fmt.Errorf("unsupported literal %T", literal)
+}
+
+// number writes n in the engine's form, which has no sign or exponent
+func number(n float64) (string, error) {
+	if n < 0 {
+		return "", fmt.Errorf("negative number %v: the engine's numbers have no sign", n)
+	}
+	return strconv.FormatFloat(n, 'f', -1, 64), nil
+}
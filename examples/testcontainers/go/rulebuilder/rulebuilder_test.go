@@ -0,0 +1,116 @@
+package rulebuilder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGolden tests that the builder writes the rules the container tests
+// send, character for character
+func TestGolden(t *testing.T) {
+	for _, tt := range []struct {
+		rule *Rule
+		want string
+	}{
+		{
+			Object("Person").Gets("senior_discount").If(Property("age").Of("Person").GreaterOrEqual(65)),
+			"A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65.",
+		},
+		{
+			Object("User").Gets("access").If(Property("role").Of("User").Equal("admin")),
+			`A **User** gets access if the __role__ of the **User** is equal to "admin".`,
+		},
+		{
+			Object("Order").Gets("expedited_shipping").
+				If(Property("total").Of("Order").Greater(100)).
+				And(Property("membership_level").Of("Customer").In("gold", "platinum")),
+			`An **Order** gets expedited_shipping if the __total__ of the **Order** is greater than 100 and the __membership_level__ of the **Customer** is in ["gold", "platinum"].`,
+		},
+		{
+			Object("Order").Gets("bulk_discount").If(NumberOf("items").Of("Order").AtLeast(2)),
+			"An **Order** gets bulk_discount if the number of __items__ of the **Order** is at least 2.",
+		},
+		{
+			Object("Applicant").Is("an adult").If(Property("birth_date").Of("Applicant").OlderThan(Years(18))),
+			"An **Applicant** is an adult if the __birth_date__ of the **Applicant** is older than 18 years.",
+		},
+		{
+			Object("Person").Labelled("driver").Outcome("can", "drive").
+				If(Reference("Person", "is an adult")).
+				And(Property("driving_hours").Of("Person").AtLeast(20)),
+			"driver. A **Person** can drive if the **Person** is an adult and the __driving_hours__ of the **Person** is at least 20.",
+		},
+	} {
+		got, err := tt.rule.Build()
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got)
+		assert.Equal(t, got, tt.rule.MustBuild(), "building is deterministic")
+	}
+}
+
+// TestLiterals tests how each kind of literal and operator is written
+func TestLiterals(t *testing.T) {
+	v := Property("city", "address").Of("Customer")
+	joined := time.Date(2024, 2, 29, 15, 4, 5, 0, time.UTC)
+	for _, tt := range []struct {
+		condition Condition
+		want      string
+	}{
+		{v.NotEqual("Paris"), `the __city__ of the __address__ of the **Customer** is not equal to "Paris"`},
+		{v.NotIn("Paris", 3, 2.5, true), `the __city__ of the __address__ of the **Customer** is not in ["Paris", 3, 2.5, true]`},
+		{v.Empty(), "the __city__ of the __address__ of the **Customer** is empty"},
+		{Property("joined").Of("Customer.account").Later(joined), "the __joined__ of the **Customer.account** is later than 2024-02-29"},
+		{Property("joined").Of("Customer").Within(Days(1)), "the __joined__ of the **Customer** is within 1 day"},
+		{Property("joined").Of("Customer").YoungerThan(Months(1.5)), "the __joined__ of the **Customer** is younger than 1.5 months"},
+		{LengthOf("name").Of("Customer").LessOrEqual(int64(40)), "the length of __name__ of the **Customer** is less than or equal to 40"},
+		{Label("age.check"), "§age.check is valid"},
+	} {
+		got, err := Object("Customer").Gets("x").If(tt.condition).Build()
+		require.NoError(t, err)
+		assert.Equal(t, "A **Customer** gets x if "+tt.want+".", got)
+	}
+
+	got, err := Object("Customer").Gets("x").If(v.NotEmpty()).Or(Label("vip")).Build()
+	require.NoError(t, err)
+	assert.Equal(t, "A **Customer** gets x if the __city__ of the __address__ of the **Customer** is not empty or §vip is valid.", got)
+}
+
+// TestInvalid tests that the first problem is the one reported
+func TestInvalid(t *testing.T) {
+	age := Property("age").Of("Person")
+	for name, tt := range map[string]struct {
+		rule *Rule
+		want string
+	}{
+		"object space":   {Object("Credit Card").Gets("x").If(age.Less(1)), `invalid object "Credit Card"`},
+		"empty object":   {Object("").Gets("x").If(age.Less(1)), `invalid object ""`},
+		"property space": {Object("Person").Gets("x").If(Property("first name").Of("Person").Equal("Ada")), `invalid property "first name"`},
+		"no property":    {Object("Person").Gets("x").If(Property().Of("Person").Equal("Ada")), "property of Person has no name"},
+		"outcome dot":    {Object("Person").Gets("x.y").If(age.Less(1)), `invalid outcome "x.y"`},
+		"outcome if":     {Object("Person").Gets("x if y").If(age.Less(1)), `invalid outcome "x if y"`},
+		"label":          {Object("Person").Labelled("age check").Gets("x").If(age.Less(1)), `invalid label "age check"`},
+		"negative":       {Object("Person").Gets("x").If(age.Greater(-1)), "negative number -1"},
+		"quote":          {Object("Person").Gets("x").If(age.Equal(`say "hi"`)), "cannot hold a quote"},
+		"literal":        {Object("Person").Gets("x").If(age.Equal([]int{1})), "unsupported literal []int"},
+		"empty list":     {Object("Person").Gets("x").If(age.In()), "is in list is empty"},
+		"no outcome":     {Object("Person").If(age.Less(1)), "has no outcome"},
+		"no condition":   {Object("Person").Gets("x"), "has no condition"},
+		"and first":      {Object("Person").Gets("x").And(age.Less(1)), "and before the first condition"},
+		"two ifs":        {Object("Person").Gets("x").If(age.Less(1)).If(age.Less(2)), "has a first condition already"},
+		"first wins":     {Object("bad name").Gets("x.y").If(age.Less(1)), `invalid object "bad name"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := tt.rule.Build()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+			assert.Contains(t, err.Error(), "rulebuilder: ")
+			assert.Panics(t, func() { tt.rule.MustBuild() })
+		})
+	}
+
+	_, err := Build(Object("Person").Gets("x").If(age.Less(1)), Object("Person").Gets("y"))
+	assert.ErrorContains(t, err, "rule 2: rulebuilder: rule about Person has no condition")
+}
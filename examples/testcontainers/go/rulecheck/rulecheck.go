@@ -0,0 +1,587 @@
+// Package rulecheck finds mistakes in rule text without an engine: markers
+// left open, operators the engine does not know, properties that name no
+// object, and rules missing their parts. It follows the engine's grammar
+// closely enough to catch typos in unit tests and pre-commit hooks; the
+// engine remains the judge of what parses.
+package rulecheck
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// IssueKind classifies a ValidationIssue
+type IssueKind string
+
+const (
+	// IssueNoRule is text holding no rule at all
+	IssueNoRule IssueKind = "no_rule"
+	// IssueHeader is a rule not opening with "A **Object**" or "An **Object**"
+	IssueHeader IssueKind = "header"
+	// IssueUnterminated is a **, __, string or list left open
+	IssueUnterminated IssueKind = "unterminated"
+	// IssueName is an object marker holding something other than a name
+	IssueName IssueKind = "name"
+	// IssueOutcome is a rule without an outcome or without "if"
+	IssueOutcome IssueKind = "outcome"
+	// IssuePeriod is a rule not ending in a period
+	IssuePeriod IssueKind = "period"
+	// IssueCondition is an empty condition, or one that starts with neither a
+	// property, an object nor a label
+	IssueCondition IssueKind = "condition"
+	// IssueOperator is a comparison the engine does not know, or a missing one
+	IssueOperator IssueKind = "operator"
+	// IssueProperty is a __property__ with no "of the **Object**" after it
+	IssueProperty IssueKind = "property"
+	// IssueValue is a missing value, the wrong kind of value for the
+	// operator, or text left over after it
+	IssueValue IssueKind = "value"
+	// IssueLabel is a §label reference the engine cannot read
+	IssueLabel IssueKind = "label"
+)
+
+// ValidationIssue is one problem in a rule's text
+type ValidationIssue struct {
+	Kind IssueKind
+	// Offset is where the problem starts, in bytes into the text given to
+	// ValidateRule
+	Offset  int
+	Message string
+}
+
+func (i ValidationIssue) String() string {
+	return fmt.Sprintf("offset %d: %s", i.Offset, i.Message)
+}
+
+var (
+	// header matches a line that starts a rule, as the engine's rule_header
+	header = regexp.MustCompile(`^(?:.+?\. )?An? \*\*`)
+	// objectName is the inside of a **marker**, possibly nested
+	objectName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_ ]*(\.[A-Za-z_][A-Za-z0-9_ ]*)*$`)
+	// labelName is a label as a reference spells it
+	labelName = regexp.MustCompile(`^[A-Za-z0-9.]+$`)
+	number    = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+)
+
+type operatorKind int
+
+const (
+	comparison operatorKind = iota
+	membership
+	emptiness
+)
+
+// operator is a predicate phrase of the grammar, as words
+type operator struct {
+	words []string
+	kind  operatorKind
+}
+
+// operators are the grammar's predicates, longest first so "is greater than
+// or equal to" is not read as "is greater than"
+var operators = func() []operator {
+	phrases := map[string]operatorKind{
+		"is greater than or equal to": comparison,
+		"is at least":                 comparison,
+		"is less than or equal to":    comparison,
+		"is no more than":             comparison,
+		"is exactly equal to":         comparison,
+		"is equal to":                 comparison,
+		"is not equal to":             comparison,
+		"is the same as":              comparison,
+		"is not the same as":          comparison,
+		"is later than":               comparison,
+		"is earlier than":             comparison,
+		"is greater than":             comparison,
+		"is less than":                comparison,
+		"contains":                    comparison,
+		"is within":                   comparison,
+		"is older than":               comparison,
+		"is younger than":             comparison,
+		"is in":                       membership,
+		"is not in":                   membership,
+		"is not empty":                emptiness,
+		"is empty":                    emptiness,
+	}
+	var ops []operator
+	for phrase, kind := range phrases {
+		ops = append(ops, operator{words: strings.Fields(phrase), kind: kind})
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if len(ops[i].words) != len(ops[j].words) {
+			return len(ops[i].words) > len(ops[j].words)
+		}
+		return strings.Join(ops[i].words, " ") < strings.Join(ops[j].words, " ")
+	})
+	return ops
+}()
+
+// labelPredicates are what may follow a §label reference
+var labelPredicates = []string{
+	"clears", "succeeds", "qualifies", "passes", "meets requirements", "satisfies",
+	"is valid", "is approved", "has passed", "is authorized", "is sanctioned",
+	"is certified", "is permitted", "is legitimate", "is satisfied",
+}
+
+var timeUnits = map[string]bool{
+	"centuries": true, "century": true, "decades": true, "decade": true,
+	"years": true, "year": true, "months": true, "month": true,
+	"weeks": true, "week": true, "days": true, "day": true,
+	"hours": true, "hour": true, "minutes": true, "minute": true,
+	"seconds": true, "second": true,
+}
+
+// ValidateRule checks rule, which may hold several rules, and returns its
+// problems in the order they appear. No issues means the text should parse.
+// A rule with a marker, string or list left open is reported for that
+// alone, as its other problems would only echo it.
+func ValidateRule(rule string) []ValidationIssue {
+	chunks := splitRules(rule)
+	if len(chunks) == 0 {
+		return []ValidationIssue{{Kind: IssueNoRule, Offset: 0, Message: "the text holds no rule"}}
+	}
+	var issues []ValidationIssue
+	for _, c := range chunks {
+		issues = append(issues, checkRule(rule, c.start, c.end)...)
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Offset < issues[j].Offset })
+	return issues
+}
+
+// chunk is one rule's text, text[start:end]
+type chunk struct{ start, end int }
+
+// splitRules finds the rules of text: each starts at a line that opens a
+// rule or follows a blank line. Comment lines are skipped.
+func splitRules(text string) []chunk {
+	var chunks []chunk
+	offset, blank := 0, true
+	for _, line := range strings.SplitAfter(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			blank = true
+		case strings.HasPrefix(trimmed, "#"):
+		default:
+			start := offset + strings.Index(line, trimmed)
+			if blank || header.MatchString(trimmed) || len(chunks) == 0 {
+				chunks = append(chunks, chunk{start: start})
+			}
+			chunks[len(chunks)-1].end = offset + len(strings.TrimRight(line, " \t\r\n"))
+			blank = false
+		}
+		offset += len(line)
+	}
+	return chunks
+}
+
+// checkRule checks the rule text[start:end]
+func checkRule(text string, start, end int) []ValidationIssue {
+	r := &ruleCheck{text: text}
+	body := end
+	if text[end-1] == '.' {
+		body--
+	} else {
+		r.issue(IssuePeriod, end, "the rule does not end with a period")
+	}
+	tokens := r.lex(start, body)
+	if r.unterminated {
+		return r.issues
+	}
+
+	// The header: an optional label, then A or An and the object
+	open := -1
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].kind == wordToken && (tokens[i].raw == "A" || tokens[i].raw == "An") && tokens[i+1].kind == objectToken {
+			open = i
+			break
+		}
+	}
+	if open < 0 {
+		r.issue(IssueHeader, start, `the rule does not start with "A **Object**" or "An **Object**"`)
+		return r.issues
+	}
+	if open > 0 && (!strings.HasSuffix(tokens[open-1].raw, ".") || tokens[open-1].kind != wordToken) {
+		r.issue(IssueHeader, start, fmt.Sprintf("the label %q does not end with a period", r.span(tokens[:open])))
+	}
+
+	rest := tokens[open+2:]
+	ifAt := -1
+	for i, t := range rest {
+		if t.kind == wordToken && t.raw == "if" {
+			ifAt = i
+			break
+		}
+	}
+	after := tokens[open+1].end()
+	switch {
+	case ifAt < 0:
+		r.issue(IssueOutcome, after, `the rule has no "if" before its conditions`)
+		return r.issues
+	case ifAt == 0:
+		r.issue(IssueOutcome, after, "the rule has no outcome before \"if\"")
+	}
+
+	for _, condition := range splitConditions(rest[ifAt+1:], rest[ifAt]) {
+		r.checkCondition(condition.tokens, condition.at)
+	}
+	return r.issues
+}
+
+// ruleCheck collects the issues of one rule
+type ruleCheck struct {
+	text         string
+	issues       []ValidationIssue
+	unterminated bool
+}
+
+func (r *ruleCheck) issue(kind IssueKind, offset int, message string) {
+	r.issues = append(r.issues, ValidationIssue{Kind: kind, Offset: offset, Message: message})
+}
+
+// span is the text tokens cover
+func (r *ruleCheck) span(tokens []token) string {
+	if len(tokens) == 0 {
+		return ""
+	}
+	return r.text[tokens[0].offset:tokens[len(tokens)-1].end()]
+}
+
+type tokenKind int
+
+const (
+	wordToken tokenKind = iota
+	objectToken
+	propertyToken
+	stringToken
+	listToken
+)
+
+// token is one lexed part of a rule: a word, a **object**, a __property__, a
+// "string" or a [list]; raw is its text and offset where it starts
+type token struct {
+	kind   tokenKind
+	offset int
+	raw    string
+}
+
+func (t token) end() int {
+	return t.offset + len(t.raw)
+}
+
+// lex splits text[start:end] into tokens, reporting markers, strings and
+// lists that are not closed
+func (r *ruleCheck) lex(start, end int) []token {
+	var tokens []token
+	text := r.text[:end]
+	for i := start; i < end; {
+		switch {
+		case isSpace(text[i]):
+			i++
+		case strings.HasPrefix(text[i:], "**"), strings.HasPrefix(text[i:], "__"):
+			marker := text[i : i+2]
+			j := strings.Index(text[i+2:], marker)
+			name := ""
+			if j >= 0 {
+				name = text[i+2 : i+2+j]
+			}
+			if j < 0 || strings.ContainsAny(name, "*\"\n") || strings.Contains(name, "__") {
+				r.unterminated = true
+				r.issue(IssueUnterminated, i, fmt.Sprintf("%s is not closed", marker))
+				i += 2
+				continue
+			}
+			kind := propertyToken
+			if marker == "**" {
+				kind = objectToken
+				if !objectName.MatchString(name) {
+					r.issue(IssueName, i, fmt.Sprintf("%q is not an object name: use letters, digits, underscores and dots", name))
+				}
+			} else if strings.TrimSpace(name) != name || name == "" {
+				r.issue(IssueName, i, fmt.Sprintf("%q is not a property name", name))
+			}
+			tokens = append(tokens, token{kind: kind, offset: i, raw: text[i : i+4+j]})
+			i += 4 + j
+		case text[i] == '"':
+			j := strings.IndexByte(text[i+1:], '"')
+			if j < 0 {
+				r.unterminated = true
+				r.issue(IssueUnterminated, i, "the string is not closed")
+				return tokens
+			}
+			tokens = append(tokens, token{kind: stringToken, offset: i, raw: text[i : i+2+j]})
+			i += 2 + j
+		case text[i] == '[':
+			j := closingBracket(text, i)
+			if j < 0 {
+				r.unterminated = true
+				r.issue(IssueUnterminated, i, "the list is not closed")
+				return tokens
+			}
+			tokens = append(tokens, token{kind: listToken, offset: i, raw: text[i : j+1]})
+			i = j + 1
+		default:
+			j := i
+			for j < end && !isSpace(text[j]) && text[j] != '"' && text[j] != '[' {
+				j++
+			}
+			tokens = append(tokens, token{kind: wordToken, offset: i, raw: text[i:j]})
+			i = j
+		}
+	}
+	return tokens
+}
+
+// closingBracket finds the ] closing the list opening at text[open], outside
+// string literals, or -1
+func closingBracket(text string, open int) int {
+	quoted := false
+	for i := open + 1; i < len(text); i++ {
+		switch {
+		case text[i] == '"':
+			quoted = !quoted
+		case !quoted && text[i] == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n'
+}
+
+// condition is one condition's tokens, with where an empty one would be
+type condition struct {
+	tokens []token
+	at     int
+}
+
+// splitConditions splits the tokens after "if" at each "and" and "or", but
+// not the "or" of "is greater than or equal to"
+func splitConditions(tokens []token, ifToken token) []condition {
+	var conditions []condition
+	start, at := 0, ifToken.end()
+	for i, t := range tokens {
+		if t.kind != wordToken || (t.raw != "and" && t.raw != "or") {
+			continue
+		}
+		if t.raw == "or" && i > 0 && tokens[i-1].raw == "than" && i+1 < len(tokens) && tokens[i+1].raw == "equal" {
+			continue
+		}
+		conditions = append(conditions, condition{tokens: tokens[start:i], at: at})
+		start, at = i+1, t.end()
+	}
+	return append(conditions, condition{tokens: tokens[start:], at: at})
+}
+
+// checkCondition checks a condition: a §label reference, a reference to
+// another rule's outcome, or a comparison of a property
+func (r *ruleCheck) checkCondition(tokens []token, at int) {
+	if len(tokens) == 0 {
+		r.issue(IssueCondition, at, "a condition is empty")
+		return
+	}
+	if first := tokens[0]; first.kind == wordToken && (strings.HasPrefix(first.raw, "§") || strings.HasPrefix(first.raw, "$")) {
+		r.checkLabel(tokens)
+		return
+	}
+
+	p := skipWord(tokens, 0, "the")
+	counted := false
+	if p+1 < len(tokens) && (tokens[p].raw == "number" || tokens[p].raw == "length") && tokens[p+1].raw == "of" {
+		counted = true
+		p = skipWord(tokens, p+2, "the")
+	}
+	if p >= len(tokens) || (tokens[p].kind != objectToken && tokens[p].kind != propertyToken) {
+		offset := at
+		if p < len(tokens) {
+			offset = tokens[p].offset
+		}
+		r.issue(IssueCondition, offset, fmt.Sprintf("the condition %q does not start with a __property__, a **Object** or a §label", r.span(tokens)))
+		return
+	}
+
+	chainStart := p
+	p = r.chain(tokens, p)
+	op, n := matchOperator(tokens[p:])
+	if p == chainStart+1 && tokens[chainStart].kind == objectToken && !counted && n == 0 {
+		// A reference to another rule's outcome, "the **Person** is an adult"
+		if p == len(tokens) {
+			r.issue(IssueCondition, tokens[chainStart].end(), fmt.Sprintf("the reference to %s names no outcome", tokens[chainStart].raw))
+		}
+		return
+	}
+
+	if n == 0 {
+		if p == len(tokens) {
+			r.issue(IssueOperator, tokens[p-1].end(), fmt.Sprintf("%q has no operator after it", r.span(tokens)))
+			return
+		}
+		message := fmt.Sprintf("%q is not an operator", r.span(tokens[p:min(p+5, len(tokens))]))
+		if suggestion := suggestOperator(tokens[p:]); suggestion != "" {
+			message += fmt.Sprintf("; did you mean %q?", suggestion)
+		}
+		r.issue(IssueOperator, tokens[p].offset, message)
+		return
+	}
+	phrase := strings.Join(op.words, " ")
+	operatorEnd := tokens[p+n-1].end()
+	p += n
+	if p+1 < len(tokens) && tokens[p].raw == "the" && (tokens[p+1].kind == objectToken || tokens[p+1].kind == propertyToken) {
+		p++
+	}
+
+	switch op.kind {
+	case comparison:
+		switch {
+		case p == len(tokens):
+			r.issue(IssueValue, operatorEnd, fmt.Sprintf("%q has no value after it", phrase))
+			return
+		case tokens[p].kind == objectToken || tokens[p].kind == propertyToken:
+			p = r.chain(tokens, p)
+		case tokens[p].kind == listToken:
+			r.issue(IssueValue, tokens[p].offset, fmt.Sprintf("%q compares with one value, not a list; use \"is in\"", phrase))
+			return
+		default:
+			p++
+			if p < len(tokens) && number.MatchString(tokens[p-1].raw) && timeUnits[tokens[p].raw] {
+				p++
+			}
+		}
+	case membership:
+		switch {
+		case p == len(tokens):
+			r.issue(IssueValue, operatorEnd, fmt.Sprintf("%q has no list after it", phrase))
+			return
+		case tokens[p].kind == listToken:
+			p++
+		case tokens[p].kind == objectToken || tokens[p].kind == propertyToken:
+			p = r.chain(tokens, p)
+		default:
+			r.issue(IssueValue, tokens[p].offset, fmt.Sprintf("%q needs a [list], not %s", phrase, tokens[p].raw))
+			return
+		}
+	}
+	if p < len(tokens) {
+		r.issue(IssueValue, tokens[p].offset, fmt.Sprintf("unexpected %q after %q", r.span(tokens[p:]), r.span(tokens[:p])))
+	}
+}
+
+// chain reads a property access starting at tokens[p], "the __a__ of the
+// __b__ of the **Object**", and returns the index after it. A chain holding a
+// property must end in an object.
+func (r *ruleCheck) chain(tokens []token, p int) int {
+	start, last := p, p
+	for {
+		next := last + 1
+		if next >= len(tokens) || tokens[next].kind != wordToken || (tokens[next].raw != "of" && tokens[next].raw != "in") {
+			break
+		}
+		next = skipWord(tokens, next+1, "the")
+		if next >= len(tokens) || (tokens[next].kind != objectToken && tokens[next].kind != propertyToken) {
+			break
+		}
+		last = next
+	}
+	if tokens[last].kind != objectToken {
+		r.issue(IssueProperty, tokens[start].offset, fmt.Sprintf(`%s is not attached to an object: write "of the **Object**" after it`, r.span(tokens[start:last+1])))
+	}
+	return last + 1
+}
+
+// checkLabel checks a §label reference and the predicate after it
+func (r *ruleCheck) checkLabel(tokens []token) {
+	name := strings.TrimPrefix(strings.TrimPrefix(tokens[0].raw, "§"), "$")
+	if !labelName.MatchString(name) {
+		r.issue(IssueLabel, tokens[0].offset, fmt.Sprintf("%q is not a label: use letters, digits and dots", name))
+		return
+	}
+	if len(tokens) == 1 {
+		return
+	}
+	predicate := joinWords(tokens[1:])
+	for _, known := range labelPredicates {
+		if predicate == known {
+			return
+		}
+	}
+	r.issue(IssueLabel, tokens[1].offset, fmt.Sprintf("%q is not one of the label predicates, such as \"is valid\" or \"passes\"", r.span(tokens[1:])))
+}
+
+// skipWord skips tokens[p] if it is the word
+func skipWord(tokens []token, p int, word string) int {
+	if p < len(tokens) && tokens[p].kind == wordToken && tokens[p].raw == word {
+		return p + 1
+	}
+	return p
+}
+
+func joinWords(tokens []token) string {
+	words := make([]string, len(tokens))
+	for i, t := range tokens {
+		words[i] = t.raw
+	}
+	return strings.Join(words, " ")
+}
+
+// matchOperator finds the operator tokens start with, and how many tokens it
+// spans; n is 0 when there is none
+func matchOperator(tokens []token) (op operator, n int) {
+	for _, op := range operators {
+		if len(op.words) > len(tokens) {
+			continue
+		}
+		matched := true
+		for i, word := range op.words {
+			if tokens[i].kind != wordToken || tokens[i].raw != word {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return op, len(op.words)
+		}
+	}
+	return operator{}, 0
+}
+
+// suggestOperator is the operator closest to the words tokens start with,
+// if one is a likely typo of them
+func suggestOperator(tokens []token) string {
+	best, bestDistance := "", -1
+	for _, op := range operators {
+		if len(op.words) > len(tokens) {
+			continue
+		}
+		phrase := strings.Join(op.words, " ")
+		d := distance(joinWords(tokens[:len(op.words)]), phrase)
+		if d <= max(2, len(phrase)/5) && (bestDistance < 0 || d < bestDistance) {
+			best, bestDistance = phrase, d
+		}
+	}
+	return best
+}
+
+// distance is the Levenshtein distance between a and b
+func distance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
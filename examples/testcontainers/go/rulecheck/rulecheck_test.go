@@ -0,0 +1,176 @@
+package rulecheck
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestValidRules tests that the rules the container tests send, and the rest
+// of the grammar, raise no issues
+func TestValidRules(t *testing.T) {
+	for _, rule := range []string{
+		"A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65.",
+		"driver. A **Person** can drive if the **Person** is an adult and the __driving_hours__ of the **Person** is at least 20.",
+		"adult. A **Person** is an adult if the __age__ of the **Person** is at least 18.",
+		`An **Order** gets expedited_shipping if the __total__ of the **Order** is greater than 100 and the __membership_level__ of the **Customer** is in ["gold", "platinum"].`,
+		`A **User** gets access if the __role__ of the **User** is equal to "admin".`,
+		`A **Context** gets summer_sale if the __now__ of the **Context** is later than date(2025-05-31) and the __environment__ of the **Context** is equal to "prod".`,
+		"An **Order** gets bulk_discount if the number of __items__ of the **Order** is at least 2.",
+		"An **Applicant** is an adult if the __birth_date__ of the **Applicant** is older than 18 years.",
+		"adult. A **Person** passes screening if the __age__ of the **Person** is at least 18.",
+		"fast_track. A **Person** gets fast_track if the __screened__ of the **Person** is equal to 1 and the __income__ of the **Person** is greater than 50000.",
+		"welcome. A **Person** gets a welcome pack if the __fast_tracked__ of the **Person** is equal to 1.",
+		"shipping. A **Order** gets free_shipping if the __total__ of the **Order** is greater than or equal to 50.",
+		"A **Customer** gets x if the __city__ of the __address__ of the **Customer** is not in [\"Paris, France\", 3] or §vip is valid.",
+		"A **Customer** gets x if the length of __name__ of the **Customer** is no more than 40 or $vip.gold.",
+		"A **Customer.account** gets x if the __joined__ of the **Customer.account** is within 1.5 days and the __tags__ of the **Customer** is not empty.",
+		"A **Order** gets x if the __total__ of the **Order** is greater than the __limit__ of the **Customer**.",
+		"# Rules may be commented\nadult. A **Person** is an adult if the __age__ of the **Person** is at least 18.\n\nA **Person** can vote if\n  §adult passes.",
+	} {
+		assert.Empty(t, ValidateRule(rule), rule)
+	}
+}
+
+// TestInvalidRules tests the issue, and the offset of its start, of each
+// kind of malformed rule
+func TestInvalidRules(t *testing.T) {
+	for name, tt := range map[string]struct {
+		rule string
+		kind IssueKind
+		// at is the text the issue's offset points to
+		at      string
+		message string
+	}{
+		"operator typo": {
+			rule: "A **Person** gets senior_discount if the __age__ of the **Person** is greater then 65.",
+			kind: IssueOperator, at: "is greater then",
+			message: `"is greater then 65" is not an operator; did you mean "is greater than"?`,
+		},
+		"unknown operator": {
+			rule: "A **Person** gets x if the __age__ of the **Person** exceeds 65.",
+			kind: IssueOperator, at: "exceeds", message: `"exceeds 65" is not an operator`,
+		},
+		"missing operator": {
+			rule: "A **Person** gets x if the __age__ of the **Person**.",
+			kind: IssueOperator, at: ".", message: "has no operator after it",
+		},
+		"unclosed object": {
+			rule: "A **Person* gets x if the __age__ of the **Person** is at least 18.",
+			kind: IssueUnterminated, at: "**Person*", message: "** is not closed",
+		},
+		"unclosed property": {
+			rule: "A **Person** gets x if the __age of the **Person** is at least 18.",
+			kind: IssueUnterminated, at: "__age", message: "__ is not closed",
+		},
+		"unclosed string": {
+			rule: `A **User** gets access if the __role__ of the **User** is equal to "admin.`,
+			kind: IssueUnterminated, at: `"admin`, message: "the string is not closed",
+		},
+		"unclosed list": {
+			rule: `An **Order** gets x if the __level__ of the **Customer** is in ["gold", "platinum".`,
+			kind: IssueUnterminated, at: "[", message: "the list is not closed",
+		},
+		"detached property": {
+			rule: "A **Person** gets x if the __age__ is at least 18.",
+			kind: IssueProperty, at: "__age__", message: `__age__ is not attached to an object: write "of the **Object**" after it`,
+		},
+		"detached nested property": {
+			rule: "A **Person** gets x if the __city__ of the __address__ is equal to \"Paris\".",
+			kind: IssueProperty, at: "__city__", message: "__city__ of the __address__ is not attached",
+		},
+		"object name": {
+			rule: "A **Credit-Card** gets x if the __limit__ of the **Card** is at least 1.",
+			kind: IssueName, at: "**Credit-Card**", message: `"Credit-Card" is not an object name`,
+		},
+		"no period": {
+			rule: "A **Person** gets x if the __age__ of the **Person** is at least 18",
+			kind: IssuePeriod, at: "", message: "does not end with a period",
+		},
+		"no article": {
+			rule: "**Person** gets x if the __age__ of the **Person** is at least 18.",
+			kind: IssueHeader, at: "**Person** gets", message: `does not start with "A **Object**"`,
+		},
+		"no if": {
+			rule: "A **Person** gets x when the __age__ of the **Person** is at least 18.",
+			kind: IssueOutcome, at: " gets x", message: `no "if"`,
+		},
+		"no outcome": {
+			rule: "A **Person** if the __age__ of the **Person** is at least 18.",
+			kind: IssueOutcome, at: " if", message: "no outcome",
+		},
+		"empty condition": {
+			rule: "A **Person** gets x if the __age__ of the **Person** is at least 18 and.",
+			kind: IssueCondition, at: ".", message: "a condition is empty",
+		},
+		"condition start": {
+			rule: "A **Person** gets x if 18 is at most the __age__ of the **Person**.",
+			kind: IssueCondition, at: "18 is", message: "does not start with a __property__",
+		},
+		"missing value": {
+			rule: "A **Person** gets x if the __age__ of the **Person** is at least.",
+			kind: IssueValue, at: ".", message: `"is at least" has no value after it`,
+		},
+		"list for comparison": {
+			rule: "A **Person** gets x if the __age__ of the **Person** is equal to [1, 2].",
+			kind: IssueValue, at: "[1", message: `use "is in"`,
+		},
+		"value for list": {
+			rule: "A **Person** gets x if the __age__ of the **Person** is in 18.",
+			kind: IssueValue, at: "18.", message: `"is in" needs a [list], not 18`,
+		},
+		"left over": {
+			rule: "A **Person** gets x if the __age__ of the **Person** is older than 18 years old.",
+			kind: IssueValue, at: "old.", message: `unexpected "old"`,
+		},
+		"label name": {
+			rule: "A **Person** gets x if §adult-check is valid.",
+			kind: IssueLabel, at: "§", message: `"adult-check" is not a label`,
+		},
+		"label predicate": {
+			rule: "A **Person** gets x if §adult is good.",
+			kind: IssueLabel, at: "is good", message: `"is good" is not one of the label predicates`,
+		},
+		"reference without outcome": {
+			rule: "A **Person** gets x if the **Person**.",
+			kind: IssueCondition, at: ".", message: "the reference to **Person** names no outcome",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			issues := ValidateRule(tt.rule)
+			require.Len(t, issues, 1, "%v", issues)
+			assert.Equal(t, tt.kind, issues[0].Kind)
+			assert.Contains(t, issues[0].Message, tt.message)
+			want := len(tt.rule)
+			if tt.at != "" {
+				want = strings.Index(tt.rule, tt.at)
+				if tt.at == "." {
+					want = strings.LastIndex(tt.rule, tt.at)
+				}
+			}
+			assert.Equal(t, want, issues[0].Offset, "the issue is at %q", tt.rule[min(issues[0].Offset, len(tt.rule)):])
+		})
+	}
+}
+
+// TestValidateRuleSeveral tests that each rule of a text is checked and
+// offsets count from the start of the whole text
+func TestValidateRuleSeveral(t *testing.T) {
+	text := "adult. A **Person** is an adult if the __age__ of the **Person** is at least 18.\n\n" +
+		"A **Person** can drive if §adult is valid and the __hours__ is at least 20\n" +
+		"A **Person** can vote if the __age__ of the **Person** is greater then 17."
+	issues := ValidateRule(text)
+	require.Len(t, issues, 3)
+	assert.Equal(t, ValidationIssue{
+		Kind: IssueProperty, Offset: strings.Index(text, "__hours__"),
+		Message: `__hours__ is not attached to an object: write "of the **Object**" after it`,
+	}, issues[0])
+	assert.Equal(t, IssuePeriod, issues[1].Kind)
+	assert.Equal(t, strings.Index(text, "\nA **Person** can vote"), issues[1].Offset)
+	assert.Equal(t, strings.Index(text, "is greater then"), issues[2].Offset)
+	assert.Equal(t, `offset 133: __hours__ is not attached to an object: write "of the **Object**" after it`, issues[0].String())
+
+	assert.Equal(t, []ValidationIssue{{Kind: IssueNoRule, Offset: 0, Message: "the text holds no rule"}}, ValidateRule(" \n# only a comment\n"))
+}
@@ -0,0 +1,251 @@
+// Package ruleset loads rule files that carry their own metadata and test
+// cases. A file is a policy, optionally preceded by YAML front matter between
+// two "---" lines:
+//
+//	---
+//	name: senior discount
+//	description: Discounts for customers of 65 and over
+//	tags: [discounts]
+//	tests:
+//	  - name: senior
+//	    data: {Person: {age: 70}}
+//	    expected: true
+//	  - name: adult
+//	    data: {Person: {age: 30}}
+//	    expected: false
+//	---
+//	A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65.
+//
+// and the cases of every file can be run as one table-driven test:
+//
+//	func TestRules(t *testing.T) {
+//		rules, err := ruleset.Load(os.DirFS("rules"), "*.rule")
+//		require.NoError(t, err)
+//		ruleset.Test(t, c, rules)
+//	}
+package ruleset
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"policy-engine-testcontainer-example/client"
+)
+
+// frontMatterDelimiter opens and closes a file's front matter
+const frontMatterDelimiter = "---"
+
+// Rule is one rule file: its policy, which may hold several rules a blank
+// line apart, and what its front matter says about it
+type Rule struct {
+	// Name is the front matter's name, or the file's name without its
+	// extension
+	Name        string
+	Description string
+	Tags        []string
+	// File is the path of the file in the fs.FS it was loaded from
+	File string
+	// Text is the policy the engine is sent
+	Text  string
+	Tests []TestCase
+}
+
+// TestCase is one case of a rule file: data, and the result the policy must
+// give for it
+type TestCase struct {
+	Name     string
+	Data     interface{}
+	Expected bool
+	// Labels, if set, must each have the given result in the response
+	Labels map[string]bool
+}
+
+// frontMatter is the YAML at the top of a rule file
+type frontMatter struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Tags        []string `yaml:"tags"`
+	Tests       []struct {
+		Name     string          `yaml:"name"`
+		Data     interface{}     `yaml:"data"`
+		Expected *bool           `yaml:"expected"`
+		Labels   map[string]bool `yaml:"labels,omitempty"`
+	} `yaml:"tests"`
+}
+
+// Load reads the rule files of fsys matching glob, as fs.Glob matches it, in
+// lexical order. A file whose front matter does not parse, or whose policy is
+// empty, fails the load.
+func Load(fsys fs.FS, glob string) ([]Rule, error) {
+	names, err := fs.Glob(fsys, glob)
+	if err != nil {
+		return nil, fmt.Errorf("ruleset: bad pattern %q: %w", glob, err)
+	}
+	rules := make([]Rule, 0, len(names))
+	for _, name := range names {
+		raw, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("ruleset: failed to read %s: %w", name, err)
+		}
+		rule, err := parse(name, raw)
+		if err != nil {
+			return nil, fmt.Errorf("ruleset: %s: %w", name, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parse splits a rule file into its front matter and policy
+func parse(name string, raw []byte) (Rule, error) {
+	base := path.Base(name)
+	rule := Rule{Name: strings.TrimSuffix(base, path.Ext(base)), File: name}
+	text := strings.ReplaceAll(string(raw), "\r\n", "\n")
+
+	if first, rest, _ := strings.Cut(text, "\n"); strings.TrimSpace(first) == frontMatterDelimiter {
+		header, body, ok := cutFrontMatter(rest)
+		if !ok {
+			return Rule{}, fmt.Errorf("front matter is not closed with %q", frontMatterDelimiter)
+		}
+		var meta frontMatter
+		decoder := yaml.NewDecoder(bytes.NewReader([]byte(header)))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&meta); err != nil && !errors.Is(err, io.EOF) {
+			return Rule{}, fmt.Errorf("failed to decode front matter: %w", err)
+		}
+		if meta.Name != "" {
+			rule.Name = meta.Name
+		}
+		rule.Description = meta.Description
+		rule.Tags = meta.Tags
+		for i, tc := range meta.Tests {
+			if tc.Expected == nil {
+				return Rule{}, fmt.Errorf("test %d has no expected result", i)
+			}
+			if tc.Name == "" {
+				tc.Name = fmt.Sprintf("case %d", i+1)
+			}
+			rule.Tests = append(rule.Tests, TestCase{Name: tc.Name, Data: tc.Data, Expected: *tc.Expected, Labels: tc.Labels})
+		}
+		text = body
+	}
+
+	rule.Text = strings.TrimSpace(text)
+	if rule.Text == "" {
+		return Rule{}, fmt.Errorf("holds no rule")
+	}
+	return rule, nil
+}
+
+// cutFrontMatter splits text, which follows the opening delimiter, at the
+// closing one
+func cutFrontMatter(text string) (header, body string, ok bool) {
+	offset := 0
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if strings.TrimSpace(line) == frontMatterDelimiter {
+			return text[:offset], text[offset+len(line):], true
+		}
+		offset += len(line)
+	}
+	return "", "", false
+}
+
+// Evaluate evaluates the rule's policy against data
+func (r Rule) Evaluate(ctx context.Context, e client.Evaluator, data interface{}, trace bool) (*client.PolicyResponse, error) {
+	return e.Evaluate(ctx, client.PolicyRequest{Rule: r.Text, Data: data, Trace: trace})
+}
+
+// CaseResult is the outcome of one test case
+type CaseResult struct {
+	// Rule and Case name the rule file and the case
+	Rule, Case string
+	Passed     bool
+	Expected   bool
+	// Got is the result the engine gave, if it gave one
+	Got bool
+	// Err says why the case failed: the evaluation's error, the engine's, or
+	// the result or label that differed
+	Err error
+	// Trace is how the engine reached the result of a failed case, when it
+	// sent one
+	Trace *client.Trace
+}
+
+// RunTests evaluates the test cases of rules in order, with a trace, and
+// returns a result per case. Rules without tests have no results.
+func RunTests(ctx context.Context, e client.Evaluator, rules []Rule) []CaseResult {
+	var results []CaseResult
+	for _, rule := range rules {
+		for _, tc := range rule.Tests {
+			result := CaseResult{Rule: rule.Name, Case: tc.Name, Expected: tc.Expected}
+			response, err := rule.Evaluate(ctx, e, tc.Data, true)
+			if err == nil {
+				result.Got = response.Result
+				err = tc.check(response)
+			}
+			result.Err = err
+			result.Passed = err == nil
+			if !result.Passed && response != nil {
+				result.Trace = response.ExecutionTrace
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// check compares a response with the case's expectations
+func (tc TestCase) check(response *client.PolicyResponse) error {
+	if response.EngineError != nil {
+		return response.EngineError
+	}
+	if response.Result != tc.Expected {
+		return fmt.Errorf("result is %t, want %t", response.Result, tc.Expected)
+	}
+	for label, want := range tc.Labels {
+		got, ok := response.Labels[label]
+		if !ok {
+			return fmt.Errorf("label %q is not in the response", label)
+		}
+		if got != want {
+			return fmt.Errorf("label %q is %t, want %t", label, got, want)
+		}
+	}
+	return nil
+}
+
+// Test runs the test cases of rules as subtests of t, named after the rule
+// and the case, failing each that does not pass and logging the conditions
+// its trace shows failed
+func Test(t *testing.T, e client.Evaluator, rules []Rule) {
+	t.Helper()
+	for _, result := range RunTests(context.Background(), e, rules) {
+		result := result
+		t.Run(result.Rule+"/"+result.Case, func(t *testing.T) {
+			if result.Passed {
+				return
+			}
+			t.Error(result.Err)
+			for _, failed := range result.Trace.FailedConditions() {
+				t.Log(describe(failed))
+			}
+		})
+	}
+}
+
+// describe says what a failed condition compared, for a failing case's log
+func describe(failed client.FailedCondition) string {
+	if failed.IsReference() {
+		return fmt.Sprintf("%s: %s does not meet %q", failed.Rule.Outcome.Value, failed.Selector.Value, failed.RuleName)
+	}
+	return fmt.Sprintf("%s: %s is %v, %s %v expected", failed.Rule.Outcome.Value, failed.Property.Path, failed.Actual(), failed.Operator, failed.Expected())
+}
@@ -0,0 +1,153 @@
+package ruleset
+
+import (
+	"context"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/enginetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newClient returns a client for a fake engine configured with opts
+func newClient(t *testing.T, opts ...enginetest.Option) *client.PolicyClient {
+	server := enginetest.NewFakeServer(opts...)
+	t.Cleanup(server.Close)
+	c, err := client.New(server.URL)
+	require.NoError(t, err)
+	return c
+}
+
+// TestLoad tests reading front matter, several rules to a file, and a file
+// with neither front matter nor tests
+func TestLoad(t *testing.T) {
+	rules, err := Load(os.DirFS("testdata"), "*.rule")
+	require.NoError(t, err)
+	require.Len(t, rules, 3)
+
+	senior := rules[0]
+	assert.Equal(t, "senior discount", senior.Name)
+	assert.Equal(t, "Discounts for customers of 65 and over", senior.Description)
+	assert.Equal(t, []string{"discounts", "customers"}, senior.Tags)
+	assert.Equal(t, "senior.rule", senior.File)
+	assert.Equal(t, "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65.", senior.Text)
+	require.Len(t, senior.Tests, 2)
+	assert.Equal(t, "senior", senior.Tests[0].Name)
+	assert.True(t, senior.Tests[0].Expected)
+	assert.Equal(t, map[string]interface{}{"Person": map[string]interface{}{"age": 70}}, senior.Tests[0].Data)
+	assert.False(t, senior.Tests[1].Expected)
+
+	shipping := rules[1]
+	assert.Equal(t, "shipping", shipping.Name, "the name defaults to the file's")
+	assert.Empty(t, shipping.Tests)
+	assert.Contains(t, shipping.Text, "expedited_shipping")
+
+	voting := rules[2]
+	assert.Equal(t, "voting", voting.Name)
+	assert.Contains(t, voting.Text, "\n\nadult. A **Person** is an adult")
+	require.Len(t, voting.Tests, 3)
+	assert.Equal(t, map[string]bool{"adult": true}, voting.Tests[0].Labels)
+	assert.Equal(t, "case 3", voting.Tests[2].Name)
+}
+
+// TestLoadErrors tests the files Load refuses
+func TestLoadErrors(t *testing.T) {
+	for name, tt := range map[string]struct {
+		file string
+		want string
+	}{
+		"unclosed front matter": {"---\nname: x\nA **Person** gets y if the __age__ of the **Person** is at least 1.", "front matter is not closed"},
+		"missing expected":      {"---\ntests:\n  - data: {}\n---\nA **Person** gets y if the __age__ of the **Person** is at least 1.", "test 0 has no expected result"},
+		"unknown field":         {"---\nowner: me\n---\nA **Person** gets y if the __age__ of the **Person** is at least 1.", "failed to decode front matter"},
+		"no rule":               {"---\nname: x\n---\n\n", "holds no rule"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load(fstest.MapFS{"bad.rule": {Data: []byte(tt.file)}}, "*.rule")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "ruleset: bad.rule: "+tt.want)
+		})
+	}
+
+	_, err := Load(fstest.MapFS{}, "[")
+	assert.ErrorContains(t, err, "ruleset: bad pattern")
+}
+
+// TestRunTests tests that every case of the fixtures passes against the fake
+// engine
+func TestRunTests(t *testing.T) {
+	rules, err := Load(os.DirFS("testdata"), "*.rule")
+	require.NoError(t, err)
+	results := RunTests(context.Background(), newClient(t), rules)
+	require.Len(t, results, 5)
+	for _, result := range results {
+		assert.True(t, result.Passed, "%s/%s: %v", result.Rule, result.Case, result.Err)
+		assert.Nil(t, result.Trace, "passing cases keep no trace")
+	}
+	assert.Equal(t, "senior discount", results[0].Rule)
+	assert.Equal(t, "adult", results[1].Case)
+	assert.False(t, results[1].Got)
+}
+
+// TestRunTestsFailure tests that a failing case reports why, with the trace
+func TestRunTestsFailure(t *testing.T) {
+	rules, err := Load(fstest.MapFS{"senior.rule": {Data: []byte(`---
+tests:
+  - name: wrong
+    data: {Person: {age: 30}}
+    expected: true
+  - name: wrong label
+    data: {Person: {age: 30}}
+    expected: false
+    labels: {senior: true}
+---
+A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65.`)}}, "*.rule")
+	require.NoError(t, err)
+	trace := map[string]interface{}{"execution": []interface{}{map[string]interface{}{
+		"selector": map[string]interface{}{"value": "Person"},
+		"outcome":  map[string]interface{}{"value": "senior_discount"},
+		"result":   false,
+		"conditions": []interface{}{map[string]interface{}{
+			"selector": map[string]interface{}{"value": "Person"},
+			"property": map[string]interface{}{"value": 30, "path": "$.Person.age"},
+			"operator": "GreaterThanOrEqual",
+			"value":    map[string]interface{}{"value": 65, "type": "number"},
+			"result":   false,
+		}},
+	}}}
+	c := newClient(t, enginetest.WithStub(rules[0].Text, client.PolicyResponse{Result: false, Trace: trace}))
+
+	results := RunTests(context.Background(), c, rules)
+	require.Len(t, results, 2)
+	assert.False(t, results[0].Passed)
+	assert.EqualError(t, results[0].Err, "result is false, want true")
+	require.NotNil(t, results[0].Trace)
+	failed := results[0].Trace.FailedConditions()
+	require.Len(t, failed, 1)
+	assert.Equal(t, "senior_discount: $.Person.age is 30, GreaterThanOrEqual 65 expected", describe(failed[0]))
+
+	assert.False(t, results[1].Passed)
+	assert.EqualError(t, results[1].Err, `label "senior" is not in the response`)
+}
+
+// TestRunTestsEngineError tests that a rule the engine cannot parse fails its
+// cases
+func TestRunTestsEngineError(t *testing.T) {
+	rules := []Rule{{Name: "broken", Text: "not a rule", Tests: []TestCase{{Name: "any", Data: map[string]interface{}{}}}}}
+	results := RunTests(context.Background(), newClient(t), rules)
+	require.Len(t, results, 1)
+	assert.False(t, results[0].Passed)
+	var engineErr *client.EngineError
+	assert.ErrorAs(t, results[0].Err, &engineErr)
+}
+
+// TestFixtures tests the fixtures through Test, as a caller wires rule files
+// into go test
+func TestFixtures(t *testing.T) {
+	rules, err := Load(os.DirFS("testdata"), "*.rule")
+	require.NoError(t, err)
+	Test(t, newClient(t), rules)
+}
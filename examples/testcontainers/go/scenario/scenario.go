@@ -0,0 +1,339 @@
+// Package scenario runs multi-step evaluations as tests. Each step builds its
+// data from the steps before it, for example from a label an earlier rule
+// decided, and its outcome can be asserted before the next step runs:
+//
+//	scenario.New().
+//		Step("screen", screenRule, scenario.Data(applicant)).
+//		Step("approve", approveRule, func(prev scenario.StepResult) interface{} {
+//			return withScreening(applicant, prev.Response.Result)
+//		}).
+//		ExpectLabel("approve", "fast_track", true).
+//		Run(t, c)
+package scenario
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"policy-engine-testcontainer-example/client"
+)
+
+// DataFunc builds a step's data from the result of the step before it; the
+// first step gets a zero StepResult
+type DataFunc func(prev StepResult) interface{}
+
+// Data is a DataFunc that always returns data
+func Data(data interface{}) DataFunc {
+	return func(StepResult) interface{} { return data }
+}
+
+// StepResult is the outcome of one step
+type StepResult struct {
+	Name     string
+	Response *client.PolicyResponse
+
+	// earlier holds the results of every step run before this one
+	earlier map[string]StepResult
+}
+
+// Step returns the result of the named step, which must have run before
+// this one; it is zero otherwise
+func (r StepResult) Step(name string) StepResult {
+	if r.Name == name {
+		return r
+	}
+	return r.earlier[name]
+}
+
+// Label returns the result the engine reported for label, and whether it
+// reported one
+func (r StepResult) Label(label string) (bool, bool) {
+	if r.Response == nil {
+		return false, false
+	}
+	value, ok := r.Response.Labels[label]
+	return value, ok
+}
+
+// StepOption configures one step
+type StepOption func(*step)
+
+// WithoutTrace stops the step asking for a trace. Traces are requested by
+// default so that a failing step can be explained.
+func WithoutTrace() StepOption {
+	return func(s *step) {
+		s.trace = false
+	}
+}
+
+// WithTimeout bounds the step's evaluation
+func WithTimeout(d time.Duration) StepOption {
+	return func(s *step) {
+		s.timeout = d
+	}
+}
+
+// WithContext derives the step's context from the scenario's, e.g. with
+// client.ContextWithDecisionID
+func WithContext(fn func(context.Context) context.Context) StepOption {
+	return func(s *step) {
+		s.contexts = append(s.contexts, fn)
+	}
+}
+
+// Branch is a step run alongside others by Parallel
+type Branch struct {
+	step *step
+}
+
+// NewBranch returns a branch evaluating rule, for Parallel
+func NewBranch(name, rule string, data DataFunc, opts ...StepOption) Branch {
+	return Branch{step: newStep(name, rule, data, opts)}
+}
+
+type step struct {
+	name     string
+	rule     string
+	data     DataFunc
+	trace    bool
+	timeout  time.Duration
+	contexts []func(context.Context) context.Context
+	expects  []func(StepResult) error
+}
+
+func newStep(name, rule string, data DataFunc, opts []StepOption) *step {
+	s := &step{name: name, rule: rule, data: data, trace: true}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Scenario is a sequence of stages, each a single step or parallel branches
+// that the next stage joins. Build one with New; it is not safe for
+// concurrent use while being built.
+type Scenario struct {
+	stages [][]*step
+	steps  map[string]*step
+	err    error
+}
+
+// New returns an empty scenario
+func New() *Scenario {
+	return &Scenario{steps: map[string]*step{}}
+}
+
+// Step appends a step evaluating rule against the data built by data
+func (s *Scenario) Step(name, rule string, data DataFunc, opts ...StepOption) *Scenario {
+	return s.addStage(newStep(name, rule, data, opts))
+}
+
+// Parallel appends branches that run at the same time, each given the
+// result of the step before them. The step after them joins them: its
+// previous result is the last branch's, and every branch can be looked up
+// with StepResult.Step.
+func (s *Scenario) Parallel(branches ...Branch) *Scenario {
+	steps := make([]*step, len(branches))
+	for i, branch := range branches {
+		steps[i] = branch.step
+	}
+	return s.addStage(steps...)
+}
+
+func (s *Scenario) addStage(steps ...*step) *Scenario {
+	if len(steps) == 0 {
+		s.fail(errors.New("scenario: a stage needs at least one step"))
+		return s
+	}
+	for _, st := range steps {
+		if _, dup := s.steps[st.name]; dup {
+			s.fail(fmt.Errorf("scenario: duplicate step %q", st.name))
+		}
+		s.steps[st.name] = st
+	}
+	s.stages = append(s.stages, steps)
+	return s
+}
+
+func (s *Scenario) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// Expect checks the named step's result with check as soon as the step has
+// run; an error fails the scenario at that step
+func (s *Scenario) Expect(name string, check func(StepResult) error) *Scenario {
+	st, ok := s.steps[name]
+	if !ok {
+		s.fail(fmt.Errorf("scenario: expectation for unknown step %q", name))
+		return s
+	}
+	st.expects = append(st.expects, check)
+	return s
+}
+
+// ExpectResult expects the named step's result to be want
+func (s *Scenario) ExpectResult(name string, want bool) *Scenario {
+	return s.Expect(name, func(r StepResult) error {
+		if r.Response.Result != want {
+			return fmt.Errorf("result is %t, want %t", r.Response.Result, want)
+		}
+		return nil
+	})
+}
+
+// ExpectLabel expects the engine to report want for label in the named
+// step's response
+func (s *Scenario) ExpectLabel(name, label string, want bool) *Scenario {
+	return s.Expect(name, func(r StepResult) error {
+		got, ok := r.Label(label)
+		if !ok {
+			return fmt.Errorf("label %q is not in the response", label)
+		}
+		if got != want {
+			return fmt.Errorf("label %q is %t, want %t", label, got, want)
+		}
+		return nil
+	})
+}
+
+// StepError reports the step a scenario failed at
+type StepError struct {
+	Step string
+	// Response is the step's response, if the engine gave one
+	Response *client.PolicyResponse
+	Err      error
+	// Explanation describes the decisive failed condition in the step's
+	// trace, when it has one
+	Explanation string
+}
+
+func (e *StepError) Error() string {
+	message := fmt.Sprintf("scenario step %q failed: %v", e.Step, e.Err)
+	if e.Explanation != "" {
+		message += "\n" + e.Explanation
+	}
+	return message
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// Execute runs the scenario against e, stopping at the first stage with a
+// failing step. It returns the results of the steps run, in order, and a
+// *StepError for the first failing step.
+func (s *Scenario) Execute(ctx context.Context, e client.Evaluator) ([]StepResult, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	var results []StepResult
+	earlier := map[string]StepResult{}
+	var prev StepResult
+	for _, stage := range s.stages {
+		outcomes := make([]StepResult, len(stage))
+		errs := make([]error, len(stage))
+		// The map is only written once the whole stage has run
+		snapshot := earlier
+		var wg sync.WaitGroup
+		for i, st := range stage {
+			wg.Add(1)
+			go func(i int, st *step) {
+				defer wg.Done()
+				outcomes[i], errs[i] = st.run(ctx, e, prev, snapshot)
+			}(i, st)
+		}
+		wg.Wait()
+
+		next := make(map[string]StepResult, len(earlier)+len(stage))
+		for name, result := range earlier {
+			next[name] = result
+		}
+		for _, outcome := range outcomes {
+			next[outcome.Name] = outcome
+		}
+		earlier = next
+		results = append(results, outcomes...)
+		for _, err := range errs {
+			if err != nil {
+				return results, err
+			}
+		}
+		prev = outcomes[len(outcomes)-1]
+		prev.earlier = earlier
+	}
+	return results, nil
+}
+
+// Run executes the scenario, failing t at the first failing step with its
+// explanation, and returns the results of the steps run
+func (s *Scenario) Run(t testing.TB, e client.Evaluator) []StepResult {
+	t.Helper()
+	results, err := s.Execute(context.Background(), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+// run evaluates one step and checks its expectations
+func (st *step) run(ctx context.Context, e client.Evaluator, prev StepResult, earlier map[string]StepResult) (StepResult, error) {
+	for _, derive := range st.contexts {
+		ctx = derive(ctx)
+	}
+	if st.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, st.timeout)
+		defer cancel()
+	}
+
+	var data interface{}
+	if st.data != nil {
+		data = st.data(prev)
+	}
+	response, err := e.Evaluate(ctx, client.PolicyRequest{Rule: st.rule, Data: data, Trace: st.trace})
+	result := StepResult{Name: st.name, Response: response, earlier: earlier}
+	if err == nil {
+		for _, expect := range st.expects {
+			if err = expect(result); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return result, &StepError{Step: st.name, Response: response, Err: err, Explanation: explain(response)}
+	}
+	return result, nil
+}
+
+// explain describes the decisive failed condition of response's trace
+func explain(response *client.PolicyResponse) string {
+	if response == nil || response.Trace == nil {
+		return ""
+	}
+	raw, err := json.Marshal(response.Trace)
+	if err != nil {
+		return ""
+	}
+	summary, err := client.SummarizeTrace(raw)
+	if err != nil || summary == nil {
+		return ""
+	}
+
+	rule := summary.Rule
+	if len(summary.Via) > 0 {
+		rule = strings.Join(append(append([]string(nil), summary.Via...), summary.Rule), " -> ")
+	}
+	if summary.Reference != "" {
+		return fmt.Sprintf("  %s: %s of %s was not met", rule, summary.Reference, summary.Selector)
+	}
+	return fmt.Sprintf("  %s: %s is %v, want %s %v", rule, summary.Property, summary.Actual, summary.Operator, summary.Expected)
+}
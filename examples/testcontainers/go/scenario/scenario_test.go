@@ -0,0 +1,182 @@
+package scenario
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"policy-engine-testcontainer-example/client"
+)
+
+// engine answers each rule with decide, reporting the result under the
+// rule's name as a label and, when asked, a trace of one comparison of the
+// data's score against 50
+type engine struct {
+	decide map[string]func(data map[string]interface{}) bool
+	calls  atomic.Int32
+}
+
+func (e *engine) Evaluate(ctx context.Context, req client.PolicyRequest) (*client.PolicyResponse, error) {
+	e.calls.Add(1)
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < time.Millisecond {
+		return nil, context.DeadlineExceeded
+	}
+	data, _ := req.Data.(map[string]interface{})
+	result := e.decide[req.Rule](data)
+	response := &client.PolicyResponse{Result: result, Rule: []string{req.Rule}, Labels: map[string]bool{req.Rule: result}}
+	if req.Trace {
+		response.Trace = map[string]interface{}{
+			"execution": []interface{}{map[string]interface{}{
+				"outcome": map[string]interface{}{"value": req.Rule},
+				"result":  result,
+				"conditions": []interface{}{map[string]interface{}{
+					"selector": map[string]interface{}{"value": "Applicant"},
+					"property": map[string]interface{}{"value": data["score"], "path": "$.Applicant.score"},
+					"operator": "GreaterThan",
+					"value":    map[string]interface{}{"value": 50},
+					"result":   result,
+				}},
+			}},
+		}
+	}
+	return response, nil
+}
+
+func (e *engine) Health(context.Context) error {
+	return nil
+}
+
+func newEngine() *engine {
+	return &engine{decide: map[string]func(map[string]interface{}) bool{
+		"screen":  func(data map[string]interface{}) bool { return data["score"].(int) > 50 },
+		"approve": func(data map[string]interface{}) bool { return data["screened"] == true },
+		"notify":  func(data map[string]interface{}) bool { return data["approved"] == true },
+		"audit":   func(map[string]interface{}) bool { return true },
+	}}
+}
+
+// pipeline screens an applicant, approves them if screened, and notifies
+// them if approved
+func pipeline(score int) *Scenario {
+	return New().
+		Step("screen", "screen", Data(map[string]interface{}{"score": score})).
+		Step("approve", "approve", func(prev StepResult) interface{} {
+			return map[string]interface{}{"screened": prev.Response.Result, "score": score}
+		}).
+		ExpectLabel("approve", "approve", true).
+		Step("notify", "notify", func(prev StepResult) interface{} {
+			approved, _ := prev.Label("approve")
+			return map[string]interface{}{"approved": approved, "score": score}
+		}).
+		ExpectResult("notify", true)
+}
+
+// TestScenario tests that each step gets the previous step's result
+func TestScenario(t *testing.T) {
+	e := newEngine()
+	results := pipeline(80).Run(t, e)
+	require.Len(t, results, 3)
+	assert.Equal(t, []string{"screen", "approve", "notify"}, []string{results[0].Name, results[1].Name, results[2].Name})
+	assert.True(t, results[2].Step("screen").Response.Result)
+}
+
+// TestScenarioReportsFailingStep tests that a regression in the middle step
+// stops the scenario there, with the step's trace explained
+func TestScenarioReportsFailingStep(t *testing.T) {
+	e := newEngine()
+	e.decide["approve"] = func(map[string]interface{}) bool { return false }
+
+	results, err := pipeline(80).Execute(context.Background(), e)
+	var stepErr *StepError
+	require.True(t, errors.As(err, &stepErr), "got %v", err)
+	assert.Equal(t, "approve", stepErr.Step)
+	assert.False(t, stepErr.Response.Result)
+	assert.Equal(t, `scenario step "approve" failed: label "approve" is false, want true
+  approve: $.Applicant.score is 80, want GreaterThan 50`, err.Error())
+	assert.Len(t, results, 2)
+	assert.Equal(t, int32(2), e.calls.Load(), "the last step is not run")
+
+	// Run fails the test with the same message
+	tb := &fakeTB{}
+	pipeline(80).Run(tb, e)
+	assert.Equal(t, err.Error(), tb.failure)
+}
+
+// TestScenarioParallel tests that branches run on the same previous result
+// and that the next step sees all of them
+func TestScenarioParallel(t *testing.T) {
+	e := newEngine()
+	var joined []bool
+	results := New().
+		Step("screen", "screen", Data(map[string]interface{}{"score": 80})).
+		Parallel(
+			NewBranch("approve", "approve", func(prev StepResult) interface{} {
+				return map[string]interface{}{"screened": prev.Response.Result, "score": 80}
+			}),
+			NewBranch("audit", "audit", Data(map[string]interface{}{"score": 80}), WithoutTrace()),
+		).
+		Step("notify", "notify", func(prev StepResult) interface{} {
+			for _, name := range []string{"screen", "approve", "audit"} {
+				joined = append(joined, prev.Step(name).Response.Result)
+			}
+			return map[string]interface{}{"approved": prev.Step("approve").Response.Result, "score": 80}
+		}).
+		ExpectResult("notify", true).
+		Run(t, e)
+	assert.Len(t, results, 4)
+	assert.Equal(t, []bool{true, true, true}, joined)
+}
+
+// TestScenarioStepOptions tests that a step's options apply to its call only
+func TestScenarioStepOptions(t *testing.T) {
+	e := newEngine()
+	var ids []string
+	withID := WithContext(func(ctx context.Context) context.Context {
+		return client.ContextWithDecisionID(ctx, "screen-1")
+	})
+	_, err := New().
+		Step("screen", "screen", Data(map[string]interface{}{"score": 80}), withID).
+		Expect("screen", func(StepResult) error {
+			ids = append(ids, "checked")
+			return nil
+		}).
+		Step("approve", "approve", Data(map[string]interface{}{"screened": true}), WithTimeout(time.Nanosecond)).
+		Execute(context.Background(), e)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, `scenario step "approve" failed`)
+	assert.Equal(t, []string{"checked"}, ids)
+}
+
+// TestScenarioInvalid tests that mistakes building a scenario fail it
+func TestScenarioInvalid(t *testing.T) {
+	e := newEngine()
+	_, err := New().Step("a", "screen", nil).Step("a", "screen", nil).Execute(context.Background(), e)
+	assert.EqualError(t, err, `scenario: duplicate step "a"`)
+	_, err = New().Step("a", "screen", nil).ExpectResult("b", true).Execute(context.Background(), e)
+	assert.EqualError(t, err, `scenario: expectation for unknown step "b"`)
+	_, err = New().Parallel().Execute(context.Background(), e)
+	assert.EqualError(t, err, "scenario: a stage needs at least one step")
+	assert.Zero(t, e.calls.Load())
+}
+
+// fakeTB records the failure Run reports
+type fakeTB struct {
+	testing.TB
+	failure string
+}
+
+func (tb *fakeTB) Helper() {}
+
+func (tb *fakeTB) Fatal(args ...interface{}) {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = arg.(error).Error()
+	}
+	tb.failure = strings.Join(parts, " ")
+}
@@ -0,0 +1,181 @@
+package selectors
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"policy-engine-testcontainer-example/rulecheck"
+)
+
+// DataIssueKind classifies a DataIssue
+type DataIssueKind string
+
+const (
+	// DataMissingObject is an **Object** the data has no object for
+	DataMissingObject DataIssueKind = "missing_object"
+	// DataMissingProperty is a __property__ the object lacks, or holds null
+	DataMissingProperty DataIssueKind = "missing_property"
+	// DataTypeMismatch is a value of a type the rule cannot compare the way
+	// it does, such as a string compared with "is at least", or an object
+	// that is not one
+	DataTypeMismatch DataIssueKind = "type_mismatch"
+)
+
+// DataIssue is one way data does not have the shape a rule reads
+type DataIssue struct {
+	Kind     DataIssueKind
+	Selector Selector
+	Message  string
+}
+
+func (i DataIssue) String() string {
+	return i.Message
+}
+
+// CheckData reports where data does not have the shape rule reads: objects
+// or properties missing, and values of the wrong type where the rule
+// compares numbers, dates or lists. An object missing for several
+// properties is reported once. Data may be any value that marshals to JSON,
+// or JSON itself as a json.RawMessage or []byte.
+//
+// A rule that rulecheck finds issues in is not checked, and raises none;
+// the engine reports what is wrong with it.
+func CheckData(rule string, data interface{}) []DataIssue {
+	if issues := rulecheck.ValidateRule(rule); len(issues) > 0 {
+		return nil
+	}
+	root, err := decode(data)
+	if err != nil {
+		return []DataIssue{{Kind: DataTypeMismatch, Message: fmt.Sprintf("the data is not JSON: %v", err)}}
+	}
+	top, _ := root.(map[string]interface{})
+
+	var issues []DataIssue
+	masked := maskText(rule)
+	seen := map[Selector]bool{}
+	missing := map[string]bool{}
+	for _, a := range accesses(rule) {
+		if seen[a.Selector] {
+			continue
+		}
+		seen[a.Selector] = true
+		if missing[a.Object] {
+			continue
+		}
+
+		value, issue, ok := walk(top, a.Selector)
+		switch {
+		case !ok && issue.Kind == DataMissingObject:
+			missing[a.Object] = true
+			if _, flat := top[strings.Split(a.Property, ".")[0]]; flat {
+				issue.Message += fmt.Sprintf(" (%s is at the top level; the rule reads %s)", strings.Split(a.Property, ".")[0], a.Selector)
+			}
+			issues = append(issues, issue)
+		case !ok:
+			issues = append(issues, issue)
+		default:
+			if kind := kindFor(rule, masked, a); !matches(kind, value) {
+				issues = append(issues, DataIssue{
+					Kind:     DataTypeMismatch,
+					Selector: a.Selector,
+					Message:  fmt.Sprintf("%s is %s, but the rule compares it as %s", a.Selector, describe(value), kind),
+				})
+			}
+		}
+	}
+	return issues
+}
+
+// decode is data as json.Unmarshal into an interface{} would give it
+func decode(data interface{}) (interface{}, error) {
+	var raw []byte
+	switch d := data.(type) {
+	case json.RawMessage:
+		raw = d
+	case []byte:
+		raw = d
+	default:
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		raw = b
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// walk finds the value s selects in data, or the issue stopping it
+func walk(data map[string]interface{}, s Selector) (interface{}, DataIssue, bool) {
+	current := data
+	objects := strings.Split(s.Object, ".")
+	for i, name := range objects {
+		value, ok := current[name]
+		if !ok || value == nil {
+			return nil, DataIssue{Kind: DataMissingObject, Selector: s, Message: fmt.Sprintf("the data has no %s object", strings.Join(objects[:i+1], "."))}, false
+		}
+		next, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, DataIssue{Kind: DataTypeMismatch, Selector: s, Message: fmt.Sprintf("%s is %s, not an object", strings.Join(objects[:i+1], "."), describe(value))}, false
+		}
+		current = next
+	}
+
+	properties := strings.Split(s.Property, ".")
+	for i, name := range properties {
+		path := s.Object + "." + strings.Join(properties[:i+1], ".")
+		value, ok := current[name]
+		switch {
+		case !ok:
+			return nil, DataIssue{Kind: DataMissingProperty, Selector: s, Message: fmt.Sprintf("the data has no %s", path)}, false
+		case value == nil:
+			return nil, DataIssue{Kind: DataMissingProperty, Selector: s, Message: fmt.Sprintf("%s is null", path)}, false
+		case i == len(properties)-1:
+			return value, DataIssue{}, true
+		}
+		next, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, DataIssue{Kind: DataTypeMismatch, Selector: s, Message: fmt.Sprintf("%s is %s, not an object", path, describe(value))}, false
+		}
+		current = next
+	}
+	return nil, DataIssue{}, false
+}
+
+// matches reports whether value can be compared as kind. Only the plain
+// mistakes count: strings and booleans are compared with anything.
+func matches(kind valueKind, value interface{}) bool {
+	switch kind {
+	case numberKind:
+		_, ok := value.(float64)
+		return ok
+	case dateKind:
+		_, ok := value.(string)
+		return ok
+	case listKind:
+		_, ok := value.([]interface{})
+		return ok
+	}
+	return true
+}
+
+// describe names the JSON type of value
+func describe(value interface{}) string {
+	switch value.(type) {
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return "null"
+}
@@ -0,0 +1,92 @@
+package selectors
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCheckData tests the issues found in data of the wrong shape
+func TestCheckData(t *testing.T) {
+	senior := "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+	shipping := `An **Order** gets expedited_shipping if the __total__ of the **Order** is greater than 100 and the __membership_level__ of the **Customer** is in ["gold", "platinum"].`
+
+	assert.Equal(t, []DataIssue{{
+		Kind:     DataMissingObject,
+		Selector: Selector{"Person", "age"},
+		Message:  "the data has no Person object (age is at the top level; the rule reads Person.age)",
+	}}, CheckData(senior, map[string]interface{}{"age": 70}), "the flat data of TestPolicyEngineConnection")
+
+	assert.Empty(t, CheckData(senior, map[string]interface{}{"Person": map[string]interface{}{"age": 70}}))
+	assert.Empty(t, CheckData(senior, json.RawMessage(`{"Person": {"age": 70.5}}`)))
+	assert.Empty(t, CheckData(senior, []byte(`{"Person": {"age": 70}}`)))
+	type person struct {
+		Age int `json:"age"`
+	}
+	assert.Empty(t, CheckData(senior, map[string]interface{}{"Person": person{Age: 70}}), "structs are read as they marshal")
+
+	for name, tt := range map[string]struct {
+		rule string
+		data interface{}
+		want []DataIssue
+	}{
+		"string for number": {
+			senior, map[string]interface{}{"Person": map[string]interface{}{"age": "70"}},
+			[]DataIssue{{DataTypeMismatch, Selector{"Person", "age"}, "Person.age is a string, but the rule compares it as a number"}},
+		},
+		"missing property": {
+			senior, map[string]interface{}{"Person": map[string]interface{}{"name": "Ada"}},
+			[]DataIssue{{DataMissingProperty, Selector{"Person", "age"}, "the data has no Person.age"}},
+		},
+		"null property": {
+			senior, map[string]interface{}{"Person": map[string]interface{}{"age": nil}},
+			[]DataIssue{{DataMissingProperty, Selector{"Person", "age"}, "Person.age is null"}},
+		},
+		"object not an object": {
+			senior, map[string]interface{}{"Person": 70},
+			[]DataIssue{{DataTypeMismatch, Selector{"Person", "age"}, "Person is a number, not an object"}},
+		},
+		"null data": {
+			shipping, nil,
+			[]DataIssue{
+				{DataMissingObject, Selector{"Order", "total"}, "the data has no Order object"},
+				{DataMissingObject, Selector{"Customer", "membership_level"}, "the data has no Customer object"},
+			},
+		},
+		"missing object reported once": {
+			"A **Person** gets x if the __age__ of the **Person** is at least 18 and the __hours__ of the **Person** is at least 20.",
+			map[string]interface{}{},
+			[]DataIssue{{DataMissingObject, Selector{"Person", "age"}, "the data has no Person object"}},
+		},
+		"nested": {
+			`A **Customer** gets x if the __city__ of the __address__ of the **Customer** is equal to "Paris" and the __joined__ of the **Customer.account** is later than 2020-01-01.`,
+			map[string]interface{}{"Customer": map[string]interface{}{"address": "Paris", "account": map[string]interface{}{"joined": 2021}}},
+			[]DataIssue{
+				{DataTypeMismatch, Selector{"Customer", "address.city"}, "Customer.address is a string, not an object"},
+				{DataTypeMismatch, Selector{"Customer.account", "joined"}, "Customer.account.joined is a number, but the rule compares it as a date"},
+			},
+		},
+		"counted": {
+			"An **Order** gets bulk_discount if the number of __items__ of the **Order** is at least 2.",
+			map[string]interface{}{"Order": map[string]interface{}{"items": 3}},
+			[]DataIssue{{DataTypeMismatch, Selector{"Order", "items"}, "Order.items is a number, but the rule compares it as a list"}},
+		},
+		"strings compare with anything": {
+			`A **User** gets access if the __role__ of the **User** is equal to "admin".`,
+			map[string]interface{}{"User": map[string]interface{}{"role": 1}},
+			nil,
+		},
+		"invalid rule": {
+			"A **Person** gets x if the __age__ is at least 18.", map[string]interface{}{}, nil,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CheckData(tt.rule, tt.data))
+		})
+	}
+
+	issues := CheckData(senior, json.RawMessage(`{"Person":`))
+	assert.Len(t, issues, 1)
+	assert.Contains(t, issues[0].String(), "the data is not JSON")
+}
@@ -0,0 +1,191 @@
+package selectors
+
+import (
+	"regexp"
+	"strings"
+
+	"policy-engine-testcontainer-example/rulecheck"
+)
+
+// zeroDate is the value ScaffoldData gives a property compared as a date
+const zeroDate = "0001-01-01"
+
+var (
+	operatorPhrase = `is greater than or equal to|is less than or equal to|is not the same as|` +
+		`is exactly equal to|is not equal to|is greater than|is the same as|is earlier than|` +
+		`is younger than|is no more than|is less than|is equal to|is later than|is older than|` +
+		`is not empty|is at least|is within|is not in|is empty|contains|is in`
+	// comparedWith matches the operator after an access and the start of
+	// what it is compared with
+	comparedWith = regexp.MustCompile(`^\s+(` + operatorPhrase + `)(?:\s+(.*))?`)
+	// comparedTo matches the operator before an access on the right of one
+	comparedTo = regexp.MustCompile(`(` + operatorPhrase + `)\s+(?:the\s+)?$`)
+	// object matches an **Object** marker
+	object = regexp.MustCompile(`\*\*([^*\n"]+)\*\*`)
+	// counted matches the "number of" or "length of" before an access
+	counted = regexp.MustCompile(`(number|length)\s+of\s+(?:the\s+)?$`)
+
+	dateValue     = regexp.MustCompile(`^(?:date\()?[0-9]{4}-[0-9]{2}-[0-9]{2}`)
+	durationValue = regexp.MustCompile(`^[0-9]+(?:\.[0-9]+)?\s+(?:centur|decade|year|month|week|day|hour|minute|second)`)
+	numberValue   = regexp.MustCompile(`^[0-9]+(?:\.[0-9]+)?`)
+	booleanValue  = regexp.MustCompile(`^(?:true|false)\b`)
+)
+
+// ScaffoldData returns data with the shape rule reads: a map for each
+// **Object** it names, holding each property it compares set to a zero
+// value of the type it is compared with. A property compared with 65 is 0,
+// with "admin" is "", with a date or a duration is the date 0001-01-01, and
+// with a list, as in is in ["gold", "platinum"], is the zero value of the
+// list's first item. The number of a property is an empty list, and its
+// length "". A property compared only with another property is 0 when the
+// operator orders numbers and "" otherwise.
+//
+// The data marshals as is and evaluates without errors, usually to false;
+// fill it in from there. A rule rulecheck finds issues in fails with a
+// *SyntaxError.
+func ScaffoldData(rule string) (map[string]interface{}, error) {
+	if issues := rulecheck.ValidateRule(rule); len(issues) > 0 {
+		return nil, &SyntaxError{Issues: issues}
+	}
+
+	data := map[string]interface{}{}
+	masked := maskText(rule)
+	for _, a := range accesses(rule) {
+		path := append(strings.Split(a.Object, "."), strings.Split(a.Property, ".")...)
+		set(data, path, kindFor(rule, masked, a).zero())
+	}
+	// Objects read only through references, or whose rules grant outcomes,
+	// are there too
+	for _, m := range object.FindAllStringSubmatch(masked, -1) {
+		set(data, strings.Split(m[1], "."), map[string]interface{}{})
+	}
+	return data, nil
+}
+
+// valueKind is the type of value a rule compares a property with
+type valueKind int
+
+const (
+	unknownKind valueKind = iota
+	numberKind
+	stringKind
+	booleanKind
+	dateKind
+	listKind
+)
+
+// zero is the zero value ScaffoldData gives a property of the kind
+func (k valueKind) zero() interface{} {
+	switch k {
+	case numberKind:
+		return 0
+	case booleanKind:
+		return false
+	case dateKind:
+		return zeroDate
+	case listKind:
+		return []interface{}{}
+	}
+	return ""
+}
+
+func (k valueKind) String() string {
+	switch k {
+	case numberKind:
+		return "a number"
+	case stringKind:
+		return "a string"
+	case booleanKind:
+		return "a boolean"
+	case dateKind:
+		return "a date"
+	case listKind:
+		return "a list"
+	}
+	return "a value"
+}
+
+// kindFor is the kind of value the access a is compared with
+func kindFor(rule, masked string, a accessAt) valueKind {
+	before := masked[:a.start]
+	if m := counted.FindStringSubmatch(before); m != nil {
+		if m[1] == "number" {
+			return listKind
+		}
+		return stringKind
+	}
+	if m := comparedWith.FindStringSubmatch(rule[a.end:]); m != nil {
+		operator, value := m[1], m[2]
+		switch {
+		case operator == "contains":
+			// A property that contains a value is a list of them
+			return listKind
+		case strings.HasPrefix(value, "["):
+			return kindOf(strings.TrimSpace(strings.TrimPrefix(value, "[")))
+		case value != "" && !strings.HasPrefix(value, "the ") && !strings.HasPrefix(value, "__") && !strings.HasPrefix(value, "**"):
+			return kindOf(value)
+		}
+		return kindOfOperator(operator, false)
+	}
+	if m := comparedTo.FindStringSubmatch(before); m != nil {
+		return kindOfOperator(m[1], true)
+	}
+	return unknownKind
+}
+
+// kindOf is the kind of the literal value starts with
+func kindOf(value string) valueKind {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		return stringKind
+	case dateValue.MatchString(value), durationValue.MatchString(value):
+		return dateKind
+	case numberValue.MatchString(value):
+		return numberKind
+	case booleanValue.MatchString(value):
+		return booleanKind
+	}
+	// A bare word is a string literal
+	return stringKind
+}
+
+// kindOfOperator is the kind of what operator compares when the other side
+// is a property; right is the access being on the operator's right, where is
+// in reads a list
+func kindOfOperator(operator string, right bool) valueKind {
+	switch operator {
+	case "is greater than or equal to", "is at least", "is less than or equal to", "is no more than",
+		"is greater than", "is less than":
+		return numberKind
+	case "is later than", "is earlier than", "is within", "is older than", "is younger than":
+		return dateKind
+	case "is in", "is not in":
+		if right {
+			return listKind
+		}
+	}
+	return unknownKind
+}
+
+// set sets the value at path in data, making the maps on the way. A value
+// already there is kept, and a map wins over any other value.
+func set(data map[string]interface{}, path []string, value interface{}) {
+	for _, name := range path[:len(path)-1] {
+		next, ok := data[name].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			data[name] = next
+		}
+		data = next
+	}
+	leaf := path[len(path)-1]
+	if existing, ok := data[leaf]; ok {
+		if _, isMap := value.(map[string]interface{}); !isMap {
+			return
+		}
+		if _, wasMap := existing.(map[string]interface{}); wasMap {
+			return
+		}
+	}
+	data[leaf] = value
+}
@@ -0,0 +1,73 @@
+package selectors
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestScaffoldData tests the shape and zero values scaffolded for each kind
+// of comparison
+func TestScaffoldData(t *testing.T) {
+	for name, tt := range map[string]struct {
+		rule string
+		want map[string]interface{}
+	}{
+		"number": {
+			"A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65.",
+			map[string]interface{}{"Person": map[string]interface{}{"age": 0}},
+		},
+		"string": {
+			`A **User** gets access if the __role__ of the **User** is equal to "admin".`,
+			map[string]interface{}{"User": map[string]interface{}{"role": ""}},
+		},
+		"list members": {
+			`An **Order** gets expedited_shipping if the __total__ of the **Order** is greater than 100 and the __membership_level__ of the **Customer** is in ["gold", "platinum"] and the __tier__ of the **Customer** is not in [1, 2].`,
+			map[string]interface{}{
+				"Order":    map[string]interface{}{"total": 0},
+				"Customer": map[string]interface{}{"membership_level": "", "tier": 0},
+			},
+		},
+		"dates and booleans": {
+			"An **Applicant** is an adult if the __birth_date__ of the **Applicant** is older than 18 years and the __joined__ of the **Applicant** is later than date(2024-01-01) and the __verified__ of the **Applicant** is equal to true.",
+			map[string]interface{}{"Applicant": map[string]interface{}{"birth_date": "0001-01-01", "joined": "0001-01-01", "verified": false}},
+		},
+		"counted and contained": {
+			`An **Order** gets x if the number of __items__ of the **Order** is at least 2 and the length of __code__ of the **Order** is less than 8 and the __tags__ of the **Order** contains "gift" and the __note__ of the **Order** is not empty.`,
+			map[string]interface{}{"Order": map[string]interface{}{"items": []interface{}{}, "code": "", "tags": []interface{}{}, "note": ""}},
+		},
+		"nested": {
+			`A **Customer** gets x if the __city__ of the __address__ of the **Customer** is equal to "Paris" and the __joined__ of the **Customer.account** is earlier than 2020-01-01.`,
+			map[string]interface{}{"Customer": map[string]interface{}{
+				"address": map[string]interface{}{"city": ""},
+				"account": map[string]interface{}{"joined": "0001-01-01"},
+			}},
+		},
+		"property against property": {
+			"An **Order** gets x if the __total__ of the **Order** is greater than the __limit__ of the **Customer** and the __code__ of the **Order** is in the __codes__ of the **Customer**.",
+			map[string]interface{}{
+				"Order":    map[string]interface{}{"total": 0, "code": ""},
+				"Customer": map[string]interface{}{"limit": 0, "codes": []interface{}{}},
+			},
+		},
+		"references and first use": {
+			"driver. A **Person** can drive if the **Person** is an adult and the __hours__ of the **Person** is at least 20 and the __hours__ of the **Person** is equal to \"many\".\n\n" +
+				"adult. An **Applicant** is an adult if §checks.passed is valid.",
+			map[string]interface{}{"Person": map[string]interface{}{"hours": 0}, "Applicant": map[string]interface{}{}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := ScaffoldData(tt.rule)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			_, err = json.Marshal(got)
+			assert.NoError(t, err)
+		})
+	}
+
+	_, err := ScaffoldData("A **Person** gets x if the __age__ is at least 18.")
+	var syntaxErr *SyntaxError
+	assert.ErrorAs(t, err, &syntaxErr)
+}
@@ -0,0 +1,115 @@
+// Package selectors finds the data a rule reads, without an engine: each
+// "the __property__ of the **Object**" of its conditions, on either side of
+// the operator.
+package selectors
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"policy-engine-testcontainer-example/rulecheck"
+)
+
+var (
+	// access matches a property access ending in an object; its first group
+	// is the properties, outermost first, and its second the object
+	access = regexp.MustCompile(`((?:__(?:[^_\n"*]|_[^_\n"*])+__\s+(?:of|in)\s+(?:the\s+)?)+)\*\*([^*\n"]+)\*\*`)
+	// property matches one property of an access
+	property = regexp.MustCompile(`__((?:[^_\n"*]|_[^_\n"*])+)__`)
+	// literal matches the string literals, whose text is not read
+	literal = regexp.MustCompile(`"[^"\n]*"`)
+)
+
+// Selector is a property a rule reads
+type Selector struct {
+	// Object is the object's name as the rule writes it, e.g. "Customer" or
+	// "Customer.account"
+	Object string
+	// Property is the path to the property from the object, innermost
+	// first: "the __city__ of the __address__ of the **Customer**" is
+	// "address.city"
+	Property string
+}
+
+// String is the selector as a path into the data, e.g. "Customer.address.city"
+func (s Selector) String() string {
+	return s.Object + "." + s.Property
+}
+
+// SyntaxError is a rule ExtractSelectors could not read
+type SyntaxError struct {
+	Issues []rulecheck.ValidationIssue
+}
+
+func (e *SyntaxError) Error() string {
+	if len(e.Issues) == 1 {
+		return fmt.Sprintf("selectors: invalid rule: %s", e.Issues[0])
+	}
+	return fmt.Sprintf("selectors: invalid rule: %s (and %d more issues)", e.Issues[0], len(e.Issues)-1)
+}
+
+// ExtractSelectors returns the selectors of rule, which may hold several
+// rules, in the order they first appear; a selector read twice is listed
+// once. References to other rules and labels read no data of their own and
+// add nothing. A rule rulecheck finds issues in fails with a *SyntaxError.
+func ExtractSelectors(rule string) ([]Selector, error) {
+	if issues := rulecheck.ValidateRule(rule); len(issues) > 0 {
+		return nil, &SyntaxError{Issues: issues}
+	}
+
+	var found []Selector
+	seen := map[Selector]bool{}
+	for _, a := range accesses(rule) {
+		if !seen[a.Selector] {
+			seen[a.Selector] = true
+			found = append(found, a.Selector)
+		}
+	}
+	return found, nil
+}
+
+// accessAt is a property access of a rule, at text[start:end]
+type accessAt struct {
+	Selector
+	start, end int
+}
+
+// accesses finds the property accesses of rule, outside comments and string
+// literals
+func accesses(rule string) []accessAt {
+	var found []accessAt
+	for _, m := range access.FindAllStringSubmatchIndex(maskText(rule), -1) {
+		properties := property.FindAllStringSubmatch(rule[m[2]:m[3]], -1)
+		path := make([]string, len(properties))
+		for i, p := range properties {
+			path[len(properties)-1-i] = p[1]
+		}
+		found = append(found, accessAt{
+			Selector: Selector{Object: rule[m[4]:m[5]], Property: strings.Join(path, ".")},
+			start:    m[0],
+			end:      m[1],
+		})
+	}
+	return found
+}
+
+// maskText blanks the comments and string literals of text, keeping its
+// offsets
+func maskText(text string) string {
+	return literal.ReplaceAllStringFunc(stripComments(text), func(s string) string {
+		return strings.Repeat(" ", len(s))
+	})
+}
+
+// stripComments blanks the comment lines of text
+func stripComments(text string) string {
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			blank := strings.Repeat(" ", len(strings.TrimRight(line, "\n")))
+			lines[i] = blank + line[len(blank):]
+		}
+	}
+	return strings.Join(lines, "")
+}
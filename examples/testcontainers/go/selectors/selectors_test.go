@@ -0,0 +1,97 @@
+package selectors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestExtractSelectors tests the selectors found in each shape of rule
+func TestExtractSelectors(t *testing.T) {
+	for name, tt := range map[string]struct {
+		rule string
+		want []Selector
+	}{
+		"expedited shipping": {
+			`An **Order** gets expedited_shipping if the __total__ of the **Order** is greater than 100 and the __membership_level__ of the **Customer** is in ["gold", "platinum"].`,
+			[]Selector{{"Order", "total"}, {"Customer", "membership_level"}},
+		},
+		"single condition": {
+			"A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65.",
+			[]Selector{{"Person", "age"}},
+		},
+		"and and or": {
+			"A **User** gets access if the __role__ of the **User** is equal to \"admin\" or the __level__ of the **User** is at least 3 and the __active__ of the **Account** is equal to true.",
+			[]Selector{{"User", "role"}, {"User", "level"}, {"Account", "active"}},
+		},
+		"duplicates": {
+			"A **Person** gets x if the __age__ of the **Person** is at least 18 and the __age__ of the **Person** is less than 65 or the __age__ of the **Person** is equal to 99.",
+			[]Selector{{"Person", "age"}},
+		},
+		"label": {
+			"driver. A **Person** can drive if the **Person** is an adult and the __driving_hours__ of the **Person** is at least 20.",
+			[]Selector{{"Person", "driving_hours"}},
+		},
+		"dotted label and reference": {
+			"checks.adult. A **Person** is an adult if §age.verified is valid and the __age__ of the **Person** is at least 18.",
+			[]Selector{{"Person", "age"}},
+		},
+		"nested property": {
+			`A **Customer** gets x if the __city__ of the __address__ of the **Customer** is equal to "Paris".`,
+			[]Selector{{"Customer", "address.city"}},
+		},
+		"nested object": {
+			"A **Customer** gets x if the __joined__ of the **Customer.account** is later than 2024-01-01.",
+			[]Selector{{"Customer.account", "joined"}},
+		},
+		"in for of": {
+			"A **Customer** gets x if the __city__ in the **Customer** is not empty.",
+			[]Selector{{"Customer", "city"}},
+		},
+		"counted": {
+			"An **Order** gets bulk_discount if the number of __items__ of the **Order** is at least 2 and the length of __code__ of the **Order** is less than 8.",
+			[]Selector{{"Order", "items"}, {"Order", "code"}},
+		},
+		"property on both sides": {
+			"An **Order** gets x if the __total__ of the **Order** is greater than the __limit__ of the **Customer**.",
+			[]Selector{{"Order", "total"}, {"Customer", "limit"}},
+		},
+		"marker in a string": {
+			`A **User** gets x if the __note__ of the **User** is equal to "the __secret__ of the **Vault**".`,
+			[]Selector{{"User", "note"}},
+		},
+		"reference only": {
+			"A **Person** can vote if the **Person** is an adult.",
+			nil,
+		},
+		"several rules": {
+			"# Voting age\nadult. A **Person** is an adult if the __age__ of the **Person** is at least 18.\n\n" +
+				"A **Person** can vote if §adult is valid and the __country__ of the\n  **Person** is equal to \"NL\".",
+			[]Selector{{"Person", "age"}, {"Person", "country"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := ExtractSelectors(tt.rule)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestSelectorString tests the selector as a data path
+func TestSelectorString(t *testing.T) {
+	assert.Equal(t, "Customer.address.city", Selector{"Customer", "address.city"}.String())
+}
+
+// TestExtractSelectorsInvalid tests that a rule with issues is not read
+func TestExtractSelectorsInvalid(t *testing.T) {
+	_, err := ExtractSelectors("A **Person** gets x if the __age__ is at least 18.")
+	var syntaxErr *SyntaxError
+	require.ErrorAs(t, err, &syntaxErr)
+	require.Len(t, syntaxErr.Issues, 1)
+	assert.Equal(t, `selectors: invalid rule: offset 27: __age__ is not attached to an object: write "of the **Object**" after it`, err.Error())
+
+	_, err = ExtractSelectors("A **Person* gets x if the __age is greater then 18")
+	assert.ErrorContains(t, err, "selectors: invalid rule: offset 2: ** is not closed (and ")
+}
@@ -0,0 +1,359 @@
+// Package simulate replays recorded inputs through a current and a proposed
+// version of a policy, to see which decisions a change would flip before it
+// ships.
+package simulate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/policydata"
+	"policy-engine-testcontainer-example/respdiff"
+)
+
+const (
+	defaultConcurrency = 8
+	defaultSamples     = 10
+	maxErrors          = 10
+)
+
+// InputSource yields the data documents to replay, such as the records of
+// policydata.LoadNDJSON or policydata.LoadCSV. An error in place of a
+// document is counted and the simulation carries on.
+type InputSource = iter.Seq2[interface{}, error]
+
+// Option configures Compare
+type Option func(*config)
+
+type config struct {
+	concurrency int
+	samples     int
+	anonymizer  []policydata.Transform
+}
+
+// WithConcurrency sets how many inputs are evaluated at once; the default
+// is 8
+func WithConcurrency(n int) Option {
+	return func(c *config) {
+		c.concurrency = n
+	}
+}
+
+// WithSamples sets how many flipped inputs the report keeps as examples; the
+// default is 10
+func WithSamples(n int) Option {
+	return func(c *config) {
+		c.samples = n
+	}
+}
+
+// WithAnonymizer applies transforms, such as policydata.HashFields and
+// policydata.DropFields, to the data of the examples the report keeps.
+// Without one, examples hold the recorded data as it is.
+func WithAnonymizer(transforms ...policydata.Transform) Option {
+	return func(c *config) {
+		c.anonymizer = transforms
+	}
+}
+
+// LabelDelta counts the inputs for which the proposed rules changed a label
+type LabelDelta struct {
+	Granted int `json:"granted"`
+	Revoked int `json:"revoked"`
+}
+
+// Example is one input whose decision the proposed rules change
+type Example struct {
+	// Index is the input's position in the source
+	Index int            `json:"index"`
+	Data  interface{}    `json:"data,omitempty"`
+	Diff  *respdiff.Diff `json:"diff"`
+}
+
+// LatencyStats summarizes how long one rule version took per input
+type LatencyStats struct {
+	P50 time.Duration `json:"p50"`
+	P95 time.Duration `json:"p95"`
+	Max time.Duration `json:"max"`
+}
+
+// SimulationReport is what Compare found
+type SimulationReport struct {
+	// Inputs counts what the source yielded, malformed inputs included
+	Inputs int `json:"inputs"`
+	// Evaluated counts the inputs both versions answered
+	Evaluated int `json:"evaluated"`
+	// InputErrors and EvalErrors count the inputs the source could not give
+	// and those either version failed to evaluate
+	InputErrors int `json:"input_errors"`
+	EvalErrors  int `json:"eval_errors"`
+	// Errors are the first of those errors, each naming its input
+	Errors []error `json:"-"`
+
+	// Granted and Revoked count the inputs whose result flipped to true and
+	// to false
+	Granted int                   `json:"granted"`
+	Revoked int                   `json:"revoked"`
+	Labels  map[string]LabelDelta `json:"labels,omitempty"`
+	// Examples are the first flipped inputs, in input order
+	Examples []Example `json:"examples,omitempty"`
+
+	Old      LatencyStats  `json:"old_latency"`
+	New      LatencyStats  `json:"new_latency"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Flipped counts the inputs whose result the proposed rules change
+func (r *SimulationReport) Flipped() int {
+	return r.Granted + r.Revoked
+}
+
+func (r *SimulationReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d inputs, %d evaluated: %d flipped (%d granted, %d revoked)", r.Inputs, r.Evaluated, r.Flipped(), r.Granted, r.Revoked)
+	if r.InputErrors > 0 || r.EvalErrors > 0 {
+		fmt.Fprintf(&b, "; %d input errors, %d evaluation errors", r.InputErrors, r.EvalErrors)
+	}
+	labels := make([]string, 0, len(r.Labels))
+	for label := range r.Labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		delta := r.Labels[label]
+		fmt.Fprintf(&b, "\nlabel %s: %d granted, %d revoked", label, delta.Granted, delta.Revoked)
+	}
+	fmt.Fprintf(&b, "\nlatency p50/p95/max: old %s/%s/%s, new %s/%s/%s", r.Old.P50, r.Old.P95, r.Old.Max, r.New.P50, r.New.P95, r.New.Max)
+	return b.String()
+}
+
+// outcome is the result of replaying one input
+type outcome struct {
+	index    int
+	data     interface{}
+	old, new *client.PolicyResponse
+	oldTook  time.Duration
+	newTook  time.Duration
+	// malformed is set for an input the source could not give
+	malformed bool
+	err       error
+}
+
+// Compare evaluates every input against oldRules and newRules, each joined
+// into one rule set, and reports the decisions that differ. Inputs are
+// evaluated with bounded concurrency as the source yields them. Input and
+// evaluation errors are tallied in the report; the error is only for rule
+// sets that are empty or a ctx that ended, in which case the report covers
+// the inputs evaluated before it did.
+func Compare(ctx context.Context, e client.Evaluator, oldRules, newRules []string, inputs InputSource, opts ...Option) (*SimulationReport, error) {
+	cfg := config{concurrency: defaultConcurrency, samples: defaultSamples}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.concurrency <= 0 {
+		cfg.concurrency = 1
+	}
+	if len(oldRules) == 0 || len(newRules) == 0 {
+		return nil, errors.New("simulate: both rule versions need at least one rule")
+	}
+	oldRule := strings.Join(oldRules, "\n\n")
+	newRule := strings.Join(newRules, "\n\n")
+
+	start := time.Now()
+	report := &SimulationReport{Labels: map[string]LabelDelta{}}
+	var oldLatencies, newLatencies []time.Duration
+
+	jobs := make(chan outcome)
+	results := make(chan outcome)
+	var workers sync.WaitGroup
+	for i := 0; i < cfg.concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				results <- replay(ctx, e, oldRule, newRule, job)
+			}
+		}()
+	}
+
+	// Feed the workers from the source, counting what it could not give
+	go func() {
+		defer close(jobs)
+		index := -1
+		for data, err := range inputs {
+			index++
+			if err != nil {
+				results <- outcome{index: index, malformed: true, err: &inputError{index: index, err: err}}
+				continue
+			}
+			select {
+			case jobs <- outcome{index: index, data: data}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		report.Inputs++
+		if result.malformed {
+			report.InputErrors++
+			report.addError(result.err)
+			continue
+		}
+		if result.err != nil {
+			if ctx.Err() != nil {
+				// Cancelled mid-flight; not the input's fault
+				report.Inputs--
+				continue
+			}
+			report.EvalErrors++
+			report.addError(result.err)
+			continue
+		}
+		report.Evaluated++
+		oldLatencies = append(oldLatencies, result.oldTook)
+		newLatencies = append(newLatencies, result.newTook)
+		diff := respdiff.Compare(result.old, result.new)
+		if diff.Result == nil && len(diff.Labels) == 0 {
+			continue
+		}
+		if diff.Result != nil {
+			if result.new.Result {
+				report.Granted++
+			} else {
+				report.Revoked++
+			}
+		}
+		for _, change := range diff.Labels {
+			delta := report.Labels[change.Label]
+			if change.Granted {
+				delta.Granted++
+			} else {
+				delta.Revoked++
+			}
+			report.Labels[change.Label] = delta
+		}
+		report.addExample(cfg, result, diff)
+	}
+
+	// Errors arrive as inputs finish; report the earliest
+	sort.SliceStable(report.Errors, func(i, j int) bool {
+		return errorIndex(report.Errors[i]) < errorIndex(report.Errors[j])
+	})
+	report.Old = latencyStats(oldLatencies)
+	report.New = latencyStats(newLatencies)
+	report.Duration = time.Since(start)
+	if err := ctx.Err(); err != nil {
+		return report, fmt.Errorf("simulate: stopped after %d inputs: %w", report.Inputs, err)
+	}
+	return report, nil
+}
+
+// replay evaluates one input against both rule versions
+func replay(ctx context.Context, e client.Evaluator, oldRule, newRule string, job outcome) outcome {
+	var err error
+	began := time.Now()
+	job.old, err = e.Evaluate(ctx, client.PolicyRequest{Rule: oldRule, Data: job.data})
+	job.oldTook = time.Since(began)
+	if err != nil {
+		job.err = &inputError{index: job.index, version: "current", err: err}
+		return job
+	}
+	began = time.Now()
+	job.new, err = e.Evaluate(ctx, client.PolicyRequest{Rule: newRule, Data: job.data})
+	job.newTook = time.Since(began)
+	if err != nil {
+		job.err = &inputError{index: job.index, version: "proposed", err: err}
+	}
+	return job
+}
+
+// inputError is an input or evaluation error, naming the input and, for an
+// evaluation, the rule version
+type inputError struct {
+	index   int
+	version string
+	err     error
+}
+
+func (e *inputError) Error() string {
+	if e.version == "" {
+		return fmt.Sprintf("input %d: %v", e.index, e.err)
+	}
+	return fmt.Sprintf("input %d: %s rules: %v", e.index, e.version, e.err)
+}
+
+func (e *inputError) Unwrap() error {
+	return e.err
+}
+
+func errorIndex(err error) int {
+	var inputErr *inputError
+	if errors.As(err, &inputErr) {
+		return inputErr.index
+	}
+	return -1
+}
+
+func (r *SimulationReport) addError(err error) {
+	r.Errors = append(r.Errors, err)
+	// Keep the earliest few once there are more than enough to sort
+	if len(r.Errors) > 2*maxErrors {
+		sort.SliceStable(r.Errors, func(i, j int) bool {
+			return errorIndex(r.Errors[i]) < errorIndex(r.Errors[j])
+		})
+		r.Errors = r.Errors[:maxErrors]
+	}
+}
+
+// addExample keeps result as an example if it is among the first flipped
+// inputs
+func (r *SimulationReport) addExample(cfg config, result outcome, diff *respdiff.Diff) {
+	if cfg.samples <= 0 {
+		return
+	}
+	position := sort.Search(len(r.Examples), func(i int) bool {
+		return r.Examples[i].Index > result.index
+	})
+	if position >= cfg.samples {
+		return
+	}
+	data := result.data
+	if len(cfg.anonymizer) > 0 {
+		anonymized, err := policydata.ApplyTransforms(data, cfg.anonymizer...)
+		data = anonymized
+		if err != nil {
+			// Never keep data the anonymizer could not handle
+			data = nil
+		}
+	}
+	r.Examples = append(r.Examples, Example{})
+	copy(r.Examples[position+1:], r.Examples[position:])
+	r.Examples[position] = Example{Index: result.index, Data: data, Diff: diff}
+	if len(r.Examples) > cfg.samples {
+		r.Examples = r.Examples[:cfg.samples]
+	}
+}
+
+func latencyStats(durations []time.Duration) LatencyStats {
+	if len(durations) == 0 {
+		return LatencyStats{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	at := func(q float64) time.Duration {
+		return durations[int(q*float64(len(durations)-1))]
+	}
+	return LatencyStats{P50: at(0.5), P95: at(0.95), Max: durations[len(durations)-1]}
+}
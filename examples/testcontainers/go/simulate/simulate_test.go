@@ -0,0 +1,99 @@
+package simulate
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/policydata"
+)
+
+// thresholdEvaluator grants free_shipping to orders at or over the threshold
+// the rule names, "old" being 100 and anything else 120, and fails orders
+// with a negative total, or once ctx ends
+type thresholdEvaluator struct{}
+
+func (thresholdEvaluator) Evaluate(ctx context.Context, req client.PolicyRequest) (*client.PolicyResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	threshold := 120.0
+	if strings.HasPrefix(req.Rule, "old") {
+		threshold = 100
+	}
+	order := req.Data.(map[string]interface{})["Order"].(map[string]interface{})
+	total, _ := policydata.ToFloat(order["total"])
+	if total < 0 {
+		return nil, errors.New("evaluation failed")
+	}
+	granted := total >= threshold
+	return &client.PolicyResponse{Result: granted, Labels: map[string]bool{"free_shipping": granted}}, nil
+}
+
+func (thresholdEvaluator) Health(context.Context) error {
+	return nil
+}
+
+// TestCompare tests flip counts, label deltas, tallied errors and examples
+func TestCompare(t *testing.T) {
+	var lines []string
+	for i := 0; i < 200; i++ {
+		lines = append(lines, `{"Order":{"id":"o`+strconv.Itoa(i)+`","total":`+strconv.Itoa(i)+`}}`)
+	}
+	lines = append(lines, `{not json`, `{"Order":{"total":-1}}`)
+	inputs := policydata.LoadNDJSON(strings.NewReader(strings.Join(lines, "\n")), policydata.SkipMalformed())
+
+	report, err := Compare(context.Background(), thresholdEvaluator{}, []string{"old rules"}, []string{"new rules"}, inputs,
+		WithConcurrency(4), WithSamples(3), WithAnonymizer(policydata.DropFields("Order.id")))
+	require.NoError(t, err)
+
+	assert.Equal(t, 202, report.Inputs)
+	assert.Equal(t, 200, report.Evaluated)
+	assert.Equal(t, 1, report.InputErrors)
+	assert.Equal(t, 1, report.EvalErrors)
+	require.Len(t, report.Errors, 2)
+	assert.Contains(t, report.Errors[0].Error(), "input 200: line 201")
+	assert.EqualError(t, report.Errors[1], "input 201: current rules: evaluation failed")
+
+	// Totals 100 to 119 lose free shipping
+	assert.Equal(t, 20, report.Flipped())
+	assert.Equal(t, 20, report.Revoked)
+	assert.Equal(t, map[string]LabelDelta{"free_shipping": {Revoked: 20}}, report.Labels)
+	require.Len(t, report.Examples, 3)
+	for i, example := range report.Examples {
+		assert.Equal(t, 100+i, example.Index)
+		order := example.Data.(map[string]interface{})["Order"].(map[string]interface{})
+		assert.NotContains(t, order, "id")
+		assert.Equal(t, "result: true -> false\nlabel free_shipping: denied", example.Diff.String())
+	}
+	assert.Contains(t, report.String(), "202 inputs, 200 evaluated: 20 flipped (0 granted, 20 revoked); 1 input errors, 1 evaluation errors\nlabel free_shipping: 0 granted, 20 revoked")
+}
+
+// TestCompareCancelled tests that a cancelled simulation reports what it got
+// through
+func TestCompareCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	inputs := func(yield func(interface{}, error) bool) {
+		for i := 0; ; i++ {
+			if i == 50 {
+				cancel()
+			}
+			if !yield(map[string]interface{}{"Order": map[string]interface{}{"total": float64(i)}}, nil) {
+				return
+			}
+		}
+	}
+	report, err := Compare(ctx, thresholdEvaluator{}, []string{"old"}, []string{"new"}, inputs)
+	assert.ErrorIs(t, err, context.Canceled)
+	require.NotNil(t, report)
+	assert.Zero(t, report.EvalErrors)
+
+	_, err = Compare(context.Background(), thresholdEvaluator{}, nil, []string{"new"}, inputs)
+	assert.EqualError(t, err, "simulate: both rule versions need at least one rule")
+}
@@ -0,0 +1,287 @@
+// Package spectest runs policy scenarios written in YAML as Go tests, so the
+// scenarios QA writes are the tests, with nothing translated by hand. A spec
+// is a list of scenarios:
+//
+//	scenarios:
+//	  - name: senior gets the discount
+//	    rule: A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65.
+//	    data: {Person: {age: 70}}
+//	    want: {result: true}
+//	  - name: gold member ships expedited
+//	    ruleFile: rules/shipping.rule
+//	    data: {Order: {total: 150}, Customer: {membership_level: "${LEVEL:-gold}"}}
+//	    want: {result: true}
+//	  - name: unknown operator
+//	    rule: A **Person** gets x if the __age__ of the **Person** is bigger than 1.
+//	    data: {Person: {age: 1}}
+//	    want: {error: bigger than}
+//	    skip: the engine's message differs between releases
+//
+// Each scenario names the rule it evaluates, inline with rule or as a file
+// relative to the spec with ruleFile, and the data to evaluate it against.
+// want.result is the result the rule must give, want.labels the labels it
+// must grant or deny, and want.error text the evaluation's error must
+// contain; a scenario expecting an error expects nothing else. skip: skips a
+// scenario with the reason given, and only: true on any scenario skips every
+// scenario without it.
+//
+// A string in data may refer to environment variables as ${NAME}, or
+// ${NAME:-default} for a variable that may be unset. A string that is a
+// single reference takes the variable's value as YAML would read it, so
+// "${AGE}" with AGE=70 is the number 70.
+package spectest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"policy-engine-testcontainer-example/client"
+)
+
+// reference matches an environment variable reference in a data string
+var reference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// Spec is a parsed spec file
+type Spec struct {
+	Scenarios []Scenario `yaml:"scenarios"`
+}
+
+// Scenario is one evaluation and what it must give
+type Scenario struct {
+	Name string `yaml:"name"`
+	// Rule is the policy evaluated; when the spec names a RuleFile, Load
+	// reads the file into Rule
+	Rule     string      `yaml:"rule"`
+	RuleFile string      `yaml:"ruleFile"`
+	Data     interface{} `yaml:"data"`
+	Want     Want        `yaml:"want"`
+	// Skip, if set, is why the scenario is skipped
+	Skip string `yaml:"skip"`
+	Only bool   `yaml:"only"`
+}
+
+// Want is what a scenario expects of its evaluation
+type Want struct {
+	Result *bool           `yaml:"result"`
+	Labels map[string]bool `yaml:"labels"`
+	// Error is text the evaluation's error, or the engine's, must contain
+	Error string `yaml:"error"`
+}
+
+// Load reads the spec at specPath, reading each scenario's ruleFile relative
+// to the spec's directory
+func Load(specPath string) (*Spec, error) {
+	raw, err := os.ReadFile(specPath)
+	if err != nil {
+		return nil, fmt.Errorf("spectest: failed to read spec: %w", err)
+	}
+	var spec Spec
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("spectest: failed to decode %s: %w", specPath, err)
+	}
+
+	seen := map[string]bool{}
+	for i := range spec.Scenarios {
+		sc := &spec.Scenarios[i]
+		switch {
+		case sc.Name == "":
+			return nil, fmt.Errorf("spectest: scenario %d has no name", i)
+		case seen[sc.Name]:
+			return nil, fmt.Errorf("spectest: duplicate scenario %q", sc.Name)
+		case (sc.Rule == "") == (sc.RuleFile == ""):
+			return nil, fmt.Errorf("spectest: scenario %q needs one of rule and ruleFile", sc.Name)
+		case sc.Want.Result == nil && sc.Want.Labels == nil && sc.Want.Error == "":
+			return nil, fmt.Errorf("spectest: scenario %q expects nothing", sc.Name)
+		}
+		seen[sc.Name] = true
+		if sc.RuleFile != "" {
+			rule, err := os.ReadFile(filepath.Join(filepath.Dir(specPath), filepath.FromSlash(sc.RuleFile)))
+			if err != nil {
+				return nil, fmt.Errorf("spectest: scenario %q: failed to read rule file: %w", sc.Name, err)
+			}
+			sc.Rule = strings.TrimSpace(string(rule))
+		}
+	}
+	return &spec, nil
+}
+
+// Run loads the spec at specPath and runs each scenario as a subtest of t,
+// logging the decoded trace of each that fails
+func Run(t *testing.T, e client.Evaluator, specPath string) {
+	t.Helper()
+	spec, err := Load(specPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	only := false
+	for _, sc := range spec.Scenarios {
+		only = only || sc.Only
+	}
+	for _, sc := range spec.Scenarios {
+		sc := sc
+		t.Run(sc.Name, func(t *testing.T) {
+			switch {
+			case sc.Skip != "":
+				t.Skip(sc.Skip)
+			case only && !sc.Only:
+				t.Skip("another scenario is marked only")
+			}
+			response, err := sc.Check(context.Background(), e)
+			if err == nil {
+				return
+			}
+			t.Error(err)
+			if response != nil && response.ExecutionTrace != nil {
+				t.Log("trace:\n" + dumpTrace(response.ExecutionTrace))
+			}
+		})
+	}
+}
+
+// Check evaluates the scenario with a trace and returns the response and
+// the first expectation it did not meet
+func (sc Scenario) Check(ctx context.Context, e client.Evaluator) (*client.PolicyResponse, error) {
+	data, err := expand(sc.Data)
+	if err != nil {
+		return nil, err
+	}
+	response, err := e.Evaluate(ctx, client.PolicyRequest{Rule: sc.Rule, Data: data, Trace: true})
+	if err == nil && response.EngineError != nil {
+		err = response.EngineError
+	}
+	if sc.Want.Error != "" {
+		switch {
+		case err == nil:
+			return response, fmt.Errorf("evaluation succeeded, want an error containing %q", sc.Want.Error)
+		case !strings.Contains(err.Error(), sc.Want.Error):
+			return response, fmt.Errorf("error is %q, want it to contain %q", err, sc.Want.Error)
+		}
+		return response, nil
+	}
+	if err != nil {
+		return response, err
+	}
+	if sc.Want.Result != nil && response.Result != *sc.Want.Result {
+		return response, fmt.Errorf("result is %t, want %t", response.Result, *sc.Want.Result)
+	}
+	for _, label := range sortedLabels(sc.Want.Labels) {
+		want := sc.Want.Labels[label]
+		got, ok := response.Labels[label]
+		if !ok {
+			return response, fmt.Errorf("label %q is not in the response", label)
+		}
+		if got != want {
+			return response, fmt.Errorf("label %q is %t, want %t", label, got, want)
+		}
+	}
+	return response, nil
+}
+
+// expand returns a copy of data with the environment variables its strings
+// refer to substituted
+func expand(data interface{}) (interface{}, error) {
+	switch data := data.(type) {
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(data))
+		for key, value := range data {
+			value, err := expand(value)
+			if err != nil {
+				return nil, err
+			}
+			expanded[key] = value
+		}
+		return expanded, nil
+	case []interface{}:
+		expanded := make([]interface{}, len(data))
+		for i, value := range data {
+			value, err := expand(value)
+			if err != nil {
+				return nil, err
+			}
+			expanded[i] = value
+		}
+		return expanded, nil
+	case string:
+		return expandString(data)
+	}
+	return data, nil
+}
+
+// expandString substitutes the environment variables s refers to
+func expandString(s string) (interface{}, error) {
+	var missing string
+	lookup := func(m []string) string {
+		if value, ok := os.LookupEnv(m[1]); ok {
+			return value
+		}
+		if strings.Contains(m[0], ":-") {
+			return m[2]
+		}
+		if missing == "" {
+			missing = m[1]
+		}
+		return ""
+	}
+
+	if m := reference.FindStringSubmatch(s); m != nil && m[0] == s {
+		value := lookup(m)
+		if missing != "" {
+			return nil, fmt.Errorf("spectest: environment variable %s is not set", missing)
+		}
+		var typed interface{}
+		if err := yaml.Unmarshal([]byte(value), &typed); err != nil || typed == nil {
+			return value, nil
+		}
+		switch typed.(type) {
+		case map[string]interface{}, []interface{}:
+			return value, nil
+		}
+		return typed, nil
+	}
+	expanded := reference.ReplaceAllStringFunc(s, func(ref string) string {
+		return lookup(reference.FindStringSubmatch(ref))
+	})
+	if missing != "" {
+		return nil, fmt.Errorf("spectest: environment variable %s is not set", missing)
+	}
+	return expanded, nil
+}
+
+// dumpTrace writes out every rule and condition of a trace, one per line
+func dumpTrace(trace *client.Trace) string {
+	var b strings.Builder
+	for _, rule := range trace.Execution {
+		fmt.Fprintf(&b, "  %s of %s: %t\n", rule.Outcome.Value, rule.Selector.Value, rule.Result)
+		for i := range rule.Conditions {
+			condition := &rule.Conditions[i]
+			if condition.IsReference() {
+				fmt.Fprintf(&b, "    %s %s: %t\n", condition.Selector.Value, condition.RuleName, condition.Result)
+				continue
+			}
+			fmt.Fprintf(&b, "    %s is %v, %s %v: %t\n", condition.Property.Path, condition.Actual(), condition.Operator, condition.Expected(), condition.Result)
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// sortedLabels orders the labels a scenario expects, so the first unmet one
+// is the same every run
+func sortedLabels(labels map[string]bool) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,206 @@
+package spectest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/enginetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const seniorRule = "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+
+// countingEvaluator counts the evaluations it passes on
+type countingEvaluator struct {
+	client.Evaluator
+	calls atomic.Int64
+}
+
+func (e *countingEvaluator) Evaluate(ctx context.Context, req client.PolicyRequest) (*client.PolicyResponse, error) {
+	e.calls.Add(1)
+	return e.Evaluator.Evaluate(ctx, req)
+}
+
+// newClient returns a client for a fake engine configured with opts
+func newClient(t *testing.T, opts ...enginetest.Option) *client.PolicyClient {
+	server := enginetest.NewFakeServer(opts...)
+	t.Cleanup(server.Close)
+	c, err := client.New(server.URL)
+	require.NoError(t, err)
+	return c
+}
+
+// writeSpec writes a spec to a temporary directory and returns its path
+func writeSpec(t *testing.T, spec string) string {
+	path := filepath.Join(t.TempDir(), "spec.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(spec), 0o600))
+	return path
+}
+
+// TestRun tests the fixture spec against the fake engine
+func TestRun(t *testing.T) {
+	t.Setenv("SPECTEST_SENIOR_AGE", "66")
+	e := &countingEvaluator{Evaluator: newClient(t)}
+	Run(t, e, "testdata/discounts.yaml")
+	assert.Equal(t, int64(6), e.calls.Load(), "the skipped scenario is not evaluated")
+}
+
+// TestRunOnly tests that only: runs the scenarios marked with it alone
+func TestRunOnly(t *testing.T) {
+	path := writeSpec(t, `scenarios:
+  - name: a
+    rule: "`+seniorRule+`"
+    data: {Person: {age: 70}}
+    want: {result: true}
+    only: true
+  - name: b
+    rule: "`+seniorRule+`"
+    data: {Person: {age: 70}}
+    want: {result: false}
+`)
+	e := &countingEvaluator{Evaluator: newClient(t)}
+	Run(t, e, path)
+	assert.Equal(t, int64(1), e.calls.Load())
+}
+
+// TestLoad tests reading a rule file relative to the spec
+func TestLoad(t *testing.T) {
+	spec, err := Load("testdata/discounts.yaml")
+	require.NoError(t, err)
+	require.Len(t, spec.Scenarios, 7)
+	shipping := spec.Scenarios[2]
+	assert.Equal(t, "rules/shipping.rule", shipping.RuleFile)
+	assert.Contains(t, shipping.Rule, "gets expedited_shipping")
+	assert.Equal(t, "the age limit is being lowered to 60", spec.Scenarios[6].Skip)
+}
+
+// TestLoadErrors tests the specs Load refuses
+func TestLoadErrors(t *testing.T) {
+	for name, tt := range map[string]struct {
+		spec string
+		want string
+	}{
+		"unknown field": {"scenarios:\n  - name: a\n    rules: x\n", "spectest: failed to decode"},
+		"no name":       {"scenarios:\n  - rule: x\n    want: {result: true}\n", "spectest: scenario 0 has no name"},
+		"duplicate":     {"scenarios:\n  - {name: a, rule: x, want: {result: true}}\n  - {name: a, rule: x, want: {result: true}}\n", `spectest: duplicate scenario "a"`},
+		"both rules":    {"scenarios:\n  - {name: a, rule: x, ruleFile: y, want: {result: true}}\n", `spectest: scenario "a" needs one of rule and ruleFile`},
+		"no rule":       {"scenarios:\n  - {name: a, want: {result: true}}\n", `spectest: scenario "a" needs one of rule and ruleFile`},
+		"no want":       {"scenarios:\n  - {name: a, rule: x}\n", `spectest: scenario "a" expects nothing`},
+		"no rule file":  {"scenarios:\n  - {name: a, ruleFile: missing.rule, want: {result: true}}\n", `spectest: scenario "a": failed to read rule file`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load(writeSpec(t, tt.spec))
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
+
+// TestCheck tests each way a scenario fails its expectations
+func TestCheck(t *testing.T) {
+	c := newClient(t)
+	yes, no := true, false
+	adult := "adult. A **Person** is an adult if the __age__ of the **Person** is at least 18."
+	for name, tt := range map[string]struct {
+		scenario Scenario
+		want     string
+	}{
+		"result": {
+			Scenario{Rule: seniorRule, Data: map[string]interface{}{"Person": map[string]interface{}{"age": 30}}, Want: Want{Result: &yes}},
+			"result is false, want true",
+		},
+		"label": {
+			Scenario{Rule: adult, Data: map[string]interface{}{"Person": map[string]interface{}{"age": 30}}, Want: Want{Result: &yes, Labels: map[string]bool{"adult": false}}},
+			`label "adult" is true, want false`,
+		},
+		"missing label": {
+			Scenario{Rule: seniorRule, Data: map[string]interface{}{"Person": map[string]interface{}{"age": 30}}, Want: Want{Result: &no, Labels: map[string]bool{"adult": true}}},
+			`label "adult" is not in the response`,
+		},
+		"no error": {
+			Scenario{Rule: seniorRule, Data: map[string]interface{}{"Person": map[string]interface{}{"age": 30}}, Want: Want{Error: "not found"}},
+			`evaluation succeeded, want an error containing "not found"`,
+		},
+		"other error": {
+			Scenario{Rule: seniorRule, Data: map[string]interface{}{"Person": map[string]interface{}{}}, Want: Want{Error: "Type error"}},
+			`want it to contain "Type error"`,
+		},
+		"unexpected error": {
+			Scenario{Rule: seniorRule, Data: map[string]interface{}{"Person": map[string]interface{}{}}, Want: Want{Result: &no}},
+			"Property 'age' not found",
+		},
+		"unset variable": {
+			Scenario{Rule: seniorRule, Data: map[string]interface{}{"Person": map[string]interface{}{"age": "${SPECTEST_UNSET}"}}, Want: Want{Result: &no}},
+			"spectest: environment variable SPECTEST_UNSET is not set",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := tt.scenario.Check(context.Background(), c)
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
+
+// TestExpand tests environment variable substitution in data
+func TestExpand(t *testing.T) {
+	t.Setenv("SPECTEST_AGE", "70")
+	t.Setenv("SPECTEST_CITY", "Utrecht")
+	t.Setenv("SPECTEST_LIST", "[1, 2]")
+	got, err := expand(map[string]interface{}{
+		"age":       "${SPECTEST_AGE}",
+		"address":   "${SPECTEST_CITY}, ${SPECTEST_COUNTRY:-NL}",
+		"level":     "${SPECTEST_LEVEL:-gold}",
+		"active":    "${SPECTEST_ACTIVE:-true}",
+		"list":      "${SPECTEST_LIST}",
+		"tags":      []interface{}{"${SPECTEST_CITY}", 1},
+		"price":     "$5",
+		"untouched": 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"age":       70,
+		"address":   "Utrecht, NL",
+		"level":     "gold",
+		"active":    true,
+		"list":      "[1, 2]",
+		"tags":      []interface{}{"Utrecht", 1},
+		"price":     "$5",
+		"untouched": 3,
+	}, got)
+}
+
+// TestDumpTrace tests the trace a failing scenario logs
+func TestDumpTrace(t *testing.T) {
+	trace := map[string]interface{}{"execution": []interface{}{map[string]interface{}{
+		"selector": map[string]interface{}{"value": "Person"},
+		"outcome":  map[string]interface{}{"value": "senior_discount"},
+		"result":   false,
+		"conditions": []interface{}{
+			map[string]interface{}{
+				"selector": map[string]interface{}{"value": "Person"},
+				"property": map[string]interface{}{"value": 30, "path": "$.Person.age"},
+				"operator": "GreaterThanOrEqual",
+				"value":    map[string]interface{}{"value": 65, "type": "number"},
+				"result":   false,
+			},
+			map[string]interface{}{
+				"selector":  map[string]interface{}{"value": "Person"},
+				"rule_name": "is a member",
+				"result":    true,
+			},
+		},
+	}}}
+	c := newClient(t, enginetest.WithStub(seniorRule, client.PolicyResponse{Trace: trace}))
+	yes := true
+	response, err := Scenario{Rule: seniorRule, Data: map[string]interface{}{}, Want: Want{Result: &yes}}.Check(context.Background(), c)
+	require.EqualError(t, err, "result is false, want true")
+	require.NotNil(t, response.ExecutionTrace)
+	assert.Equal(t, `  senior_discount of Person: false
+    $.Person.age is 30, GreaterThanOrEqual 65: false
+    Person is a member: true`, dumpTrace(response.ExecutionTrace))
+}
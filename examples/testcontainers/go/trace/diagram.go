@@ -0,0 +1,191 @@
+// Package trace presents an evaluation's trace to people who do not read
+// JSON: as a diagram of which conditions passed and failed, for policy
+// reviews, or as a plain-English explanation, for support teams.
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"policy-engine-testcontainer-example/client"
+)
+
+// The diagrams draw each rule the engine evaluated as a box, with an edge to
+// each of its conditions labelled with the values the condition compared.
+// Passed rules and conditions are green and failed ones red. A condition
+// referencing another rule of the policy has a dashed edge to that rule.
+// Nodes and edges follow trace order, so a trace always draws the same.
+
+const (
+	passFill   = "#d4edda"
+	passStroke = "#28a745"
+	failFill   = "#f8d7da"
+	failStroke = "#dc3545"
+)
+
+// ToMermaid renders tr as a Mermaid flowchart
+func ToMermaid(tr *client.Trace) string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	var passed, failed []string
+	class := func(id string, ok bool) {
+		if ok {
+			passed = append(passed, id)
+		} else {
+			failed = append(failed, id)
+		}
+	}
+	walk(tr, func(n node) {
+		fmt.Fprintf(&b, "    %s[\"%s\"]\n", n.id, mermaidText(n.text))
+		class(n.id, n.passed)
+	}, func(e edge) {
+		switch {
+		case e.reference:
+			fmt.Fprintf(&b, "    %s -.-> %s\n", e.from, e.to)
+		case e.text != "":
+			fmt.Fprintf(&b, "    %s -->|\"%s\"| %s\n", e.from, mermaidText(e.text), e.to)
+		default:
+			fmt.Fprintf(&b, "    %s --> %s\n", e.from, e.to)
+		}
+	})
+	fmt.Fprintf(&b, "    classDef pass fill:%s,stroke:%s\n", passFill, passStroke)
+	fmt.Fprintf(&b, "    classDef fail fill:%s,stroke:%s\n", failFill, failStroke)
+	if len(passed) > 0 {
+		fmt.Fprintf(&b, "    class %s pass\n", strings.Join(passed, ","))
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(&b, "    class %s fail\n", strings.Join(failed, ","))
+	}
+	return b.String()
+}
+
+// ToDOT renders tr in Graphviz's DOT language
+func ToDOT(tr *client.Trace) string {
+	var b strings.Builder
+	b.WriteString("digraph trace {\n\trankdir=LR;\n\tnode [fontname=\"Helvetica\"];\n")
+	walk(tr, func(n node) {
+		fill, stroke := failFill, failStroke
+		if n.passed {
+			fill, stroke = passFill, passStroke
+		}
+		style := "filled"
+		if n.condition {
+			style = "rounded,filled"
+		}
+		fmt.Fprintf(&b, "\t%s [shape=box, style=%q, fillcolor=%q, color=%q, label=%q];\n", n.id, style, fill, stroke, n.text)
+	}, func(e edge) {
+		switch {
+		case e.reference:
+			fmt.Fprintf(&b, "\t%s -> %s [style=dashed];\n", e.from, e.to)
+		case e.text != "":
+			fmt.Fprintf(&b, "\t%s -> %s [label=%q];\n", e.from, e.to, e.text)
+		default:
+			fmt.Fprintf(&b, "\t%s -> %s;\n", e.from, e.to)
+		}
+	})
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// node is a rule or a condition of the diagram
+type node struct {
+	id, text  string
+	passed    bool
+	condition bool
+}
+
+// edge joins a rule to a condition, or a reference to the rule it names
+type edge struct {
+	from, to  string
+	text      string
+	reference bool
+}
+
+// walk calls nodeFn for every rule and condition of tr, then edgeFn for
+// every edge, in trace order
+func walk(tr *client.Trace, nodeFn func(node), edgeFn func(edge)) {
+	if tr == nil {
+		return
+	}
+	var edges []edge
+	for r, rule := range tr.Execution {
+		ruleID := fmt.Sprintf("r%d", r+1)
+		nodeFn(node{id: ruleID, text: ruleTitle(rule), passed: rule.Result})
+		for c := range rule.Conditions {
+			condition := &rule.Conditions[c]
+			conditionID := fmt.Sprintf("%sc%d", ruleID, c+1)
+			nodeFn(node{id: conditionID, text: conditionTitle(condition), passed: condition.Result, condition: true})
+			if condition.IsReference() {
+				edges = append(edges, edge{from: ruleID, to: conditionID})
+				if target := referenced(tr, condition); target >= 0 {
+					edges = append(edges, edge{from: conditionID, to: fmt.Sprintf("r%d", target+1), reference: true})
+				}
+				continue
+			}
+			edges = append(edges, edge{from: ruleID, to: conditionID, text: comparedValues(condition)})
+		}
+	}
+	for _, e := range edges {
+		edgeFn(e)
+	}
+}
+
+// referenced returns the index of the rule of tr a reference condition
+// names, by outcome or label, or -1 if the trace holds no evaluation of it
+func referenced(tr *client.Trace, condition *client.ConditionTrace) int {
+	names := []string{condition.ReferencedRuleOutcome, condition.RuleName}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		for i, rule := range tr.Execution {
+			if rule.Outcome.Value == name || rule.Label == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// ruleTitle is a rule's selector and outcome, with its label, e.g.
+// "adult. Person is an adult"
+func ruleTitle(rule client.RuleTrace) string {
+	title := rule.Selector.Value + " " + rule.Outcome.Value
+	if rule.Label != "" {
+		title = rule.Label + ". " + title
+	}
+	return title
+}
+
+// conditionTitle is what a condition compares, e.g. "$.Order.total
+// GreaterThan", or the rule it references
+func conditionTitle(condition *client.ConditionTrace) string {
+	if condition.IsReference() {
+		return condition.Selector.Value + " " + condition.RuleName
+	}
+	return condition.Property.Path + " " + condition.Operator
+}
+
+// comparedValues is the data's value and the rule's, e.g. "150 vs 100"
+func comparedValues(condition *client.ConditionTrace) string {
+	return formatValue(condition.Actual()) + " vs " + formatValue(condition.Expected())
+}
+
+// formatValue writes a compared value as JSON would, so strings are quoted
+// and lists bracketed
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "missing"
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(raw)
+}
+
+// mermaidText escapes the quotes Mermaid text cannot hold
+func mermaidText(s string) string {
+	return strings.ReplaceAll(s, `"`, "#quot;")
+}
@@ -0,0 +1,70 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"policy-engine-testcontainer-example/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// update rewrites the golden files with the output of the tests that read
+// them: go test ./trace -update
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// loadTrace reads a trace from testdata, with numbers as the client decodes
+// them
+func loadTrace(t *testing.T, name string) *client.Trace {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var tr client.Trace
+	require.NoError(t, decoder.Decode(&tr))
+	return &tr
+}
+
+// golden compares got with the golden file name, writing it instead with
+// -update
+func golden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		require.NoError(t, os.WriteFile(path, []byte(got), 0o644))
+	}
+	want, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), got)
+}
+
+// TestDiagrams tests the diagrams of a single rule and of a rule referencing
+// another against their golden files
+func TestDiagrams(t *testing.T) {
+	for _, name := range []string{"expedited_shipping", "driver"} {
+		t.Run(name, func(t *testing.T) {
+			tr := loadTrace(t, name+".json")
+			golden(t, name+".mmd", ToMermaid(tr))
+			golden(t, name+".dot", ToDOT(tr))
+			assert.Equal(t, ToMermaid(tr), ToMermaid(loadTrace(t, name+".json")), "the output is deterministic")
+		})
+	}
+}
+
+// TestDiagramsEmpty tests a missing trace and one with nothing passed
+func TestDiagramsEmpty(t *testing.T) {
+	assert.Equal(t, "flowchart LR\n    classDef pass fill:#d4edda,stroke:#28a745\n    classDef fail fill:#f8d7da,stroke:#dc3545\n", ToMermaid(nil))
+	assert.Equal(t, "digraph trace {\n\trankdir=LR;\n\tnode [fontname=\"Helvetica\"];\n}\n", ToDOT(&client.Trace{}))
+
+	tr := &client.Trace{Execution: []client.RuleTrace{{Selector: client.TraceName{Value: "User"}, Outcome: client.TraceName{Value: "gets \"access\""}}}}
+	assert.Contains(t, ToMermaid(tr), `r1["User gets #quot;access#quot;"]`)
+	assert.Contains(t, ToMermaid(tr), "class r1 fail\n")
+	assert.NotContains(t, ToMermaid(tr), "pass\n    class")
+	assert.Contains(t, ToDOT(tr), `label="User gets \"access\""`)
+}
@@ -0,0 +1,342 @@
+package trace
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/policydata"
+)
+
+var (
+	// ruleHeader matches the start of a rule, its label and selector
+	ruleHeader = regexp.MustCompile(`^(?:(.+?)\. )?An? \*\*(.+?)\*\*`)
+	// connective matches the and or or joining two conditions
+	connective = regexp.MustCompile(`\s(and|or)\s`)
+	// notFound matches the engine's error for a property the data lacks
+	notFound = regexp.MustCompile(`Property '([^']+)' not found in selector '([^']+)'`)
+)
+
+// phrases are how each operator reads, and how it reads when it did not
+// hold
+var phrases = map[string][2]string{
+	"GreaterThan":        {"is greater than", "is not greater than"},
+	"GreaterThanOrEqual": {"is greater than or equal to", "is not greater than or equal to"},
+	"LessThan":           {"is less than", "is not less than"},
+	"LessThanOrEqual":    {"is less than or equal to", "is not less than or equal to"},
+	"EqualTo":            {"is equal to", "is not equal to"},
+	"ExactlyEqualTo":     {"is exactly equal to", "is not exactly equal to"},
+	"NotEqualTo":         {"is not equal to", "is equal to"},
+	"In":                 {"is in", "is not in"},
+	"NotIn":              {"is not in", "is in"},
+	"Contains":           {"contains", "does not contain"},
+	"IsEmpty":            {"is empty", "is not empty"},
+	"IsNotEmpty":         {"is not empty", "is empty"},
+	"OlderThan":          {"is older than", "is not older than"},
+	"YoungerThan":        {"is younger than", "is not younger than"},
+	"Within":             {"is within", "is not within"},
+	"LaterThan":          {"is later than", "is not later than"},
+	"EarlierThan":        {"is earlier than", "is not earlier than"},
+}
+
+// ExplainOption configures Explain
+type ExplainOption func(*explainer)
+
+// WithAliases names the properties aliases registers by their Go-side names
+// as well, as AliasRegistry.DescribePath does, so "membership_level of
+// Customer" reads "Tier (membership_level) of Customer"
+func WithAliases(aliases *policydata.AliasRegistry) ExplainOption {
+	return func(e *explainer) {
+		e.aliases = aliases
+	}
+}
+
+// explainer is how Explain names what it explains
+type explainer struct {
+	aliases *policydata.AliasRegistry
+}
+
+// Explain describes in plain English how an evaluation came out: a line per
+// rule of the trace saying whether its outcome was granted and why, then a
+// line per condition with the values it compared, joined by the and or or
+// of the rule text, then a line per label of resp the trace has no rule
+// for. A property the data lacked reads "age of Person was not present in
+// the supplied data", whether the trace or the engine's error says so. tr
+// defaults to resp's trace; either may be nil.
+func Explain(tr *client.Trace, resp *client.PolicyResponse, opts ...ExplainOption) string {
+	e := &explainer{}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if tr == nil && resp != nil {
+		tr = resp.ExecutionTrace
+	}
+	var lines []string
+	if resp != nil && resp.Error != nil {
+		reason := *resp.Error
+		if m := notFound.FindStringSubmatch(reason); m != nil {
+			reason = missing(e.property("$."+m[2]+"."+m[1], m[2]), m[2])
+		}
+		lines = append(lines, "The policy could not be decided because "+reason+".")
+	}
+
+	explained := map[string]bool{}
+	if tr != nil {
+		texts := ruleTexts(resp)
+		for i, rule := range tr.Execution {
+			lines = append(lines, e.explainRule(rule, joins(rule, i, texts))...)
+			if rule.Label != "" {
+				explained[rule.Label] = true
+			}
+		}
+	}
+	if resp != nil {
+		labels := make([]string, 0, len(resp.Labels))
+		for label := range resp.Labels {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			if !explained[label] {
+				lines = append(lines, fmt.Sprintf("label %s was %s.", label, granted(resp.Labels[label])))
+			}
+		}
+		if len(lines) == 0 {
+			lines = append(lines, fmt.Sprintf("The result was %t; the engine sent no trace.", resp.Result))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// explainRule is the summary line of a traced rule and the line of each of
+// its conditions; joins[i] is the and or or before condition i
+func (e *explainer) explainRule(rule client.RuleTrace, joins []string) []string {
+	subject := rule.Outcome.Value
+	if rule.Label != "" {
+		subject = rule.Label
+	}
+	if len(rule.Conditions) == 0 {
+		return []string{fmt.Sprintf("%s was %s.", subject, granted(rule.Result))}
+	}
+
+	// and binds tighter than or: the rule holds if every condition of one
+	// of the groups or separates does
+	var groups [][]int
+	for i := range rule.Conditions {
+		if i == 0 || joins[i] == "or" {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], i)
+	}
+	var reasons []string
+	if rule.Result {
+		for _, group := range groups {
+			held := true
+			for _, i := range group {
+				held = held && rule.Conditions[i].Result
+			}
+			if held {
+				for _, i := range group {
+					reasons = append(reasons, e.describeCondition(&rule.Conditions[i]))
+				}
+				break
+			}
+		}
+	} else {
+		for _, group := range groups {
+			for _, i := range group {
+				if !rule.Conditions[i].Result {
+					reasons = append(reasons, e.describeCondition(&rule.Conditions[i]))
+				}
+			}
+		}
+	}
+
+	summary := fmt.Sprintf("%s was %s", subject, granted(rule.Result))
+	if len(reasons) > 0 {
+		summary += " because " + strings.Join(reasons, " and ")
+	}
+	lines := []string{summary + "."}
+	for i := range rule.Conditions {
+		line := "  " + mark(rule.Conditions[i].Result) + " "
+		if i > 0 {
+			line += joins[i] + " "
+		}
+		lines = append(lines, line+e.describeCondition(&rule.Conditions[i]))
+	}
+	return lines
+}
+
+// describeCondition states what a condition found, e.g. "age of Person (30)
+// is not greater than or equal to 65"
+func (e *explainer) describeCondition(condition *client.ConditionTrace) string {
+	if condition.IsReference() {
+		if condition.Result {
+			return fmt.Sprintf("%s meets %q", condition.Selector.Value, condition.RuleName)
+		}
+		return fmt.Sprintf("%s does not meet %q", condition.Selector.Value, condition.RuleName)
+	}
+	property := e.property(condition.Property.Path, condition.Selector.Value)
+	actual := condition.Actual()
+	if actual == nil {
+		return missing(property, condition.Selector.Value)
+	}
+	phrase, ok := phrases[condition.Operator]
+	if !ok {
+		phrase = [2]string{condition.Operator, "not " + condition.Operator}
+	}
+	operator := phrase[0]
+	if !condition.Result {
+		operator = phrase[1]
+	}
+	text := fmt.Sprintf("%s of %s (%s) %s", property, condition.Selector.Value, explainValue(actual), operator)
+	if expected := condition.Expected(); expected != nil {
+		text += " " + explainValue(expected)
+	}
+	return text
+}
+
+// missing says the data lacked property of object
+func missing(property, object string) string {
+	return fmt.Sprintf("%s of %s was not present in the supplied data", property, object)
+}
+
+// property names the property at path of object, e.g. "age" for
+// "$.Person.age", with its Go-side name under WithAliases
+func (e *explainer) property(path, object string) string {
+	if e.aliases != nil {
+		if described := e.aliases.DescribePath(path); described != path {
+			return described
+		}
+	}
+	return propertyName(path, object)
+}
+
+// propertyName is a property's path without the object, e.g. "age" for
+// "$.Person.age"
+func propertyName(path, object string) string {
+	path = strings.TrimPrefix(path, "$.")
+	return strings.TrimPrefix(path, object+".")
+}
+
+// explainValue writes a compared value, quoting strings and listing lists
+// as ["gold", "platinum"]
+func explainValue(v interface{}) string {
+	if list, ok := v.([]interface{}); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = explainValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return formatValue(v)
+}
+
+func mark(ok bool) string {
+	if ok {
+		return "[pass]"
+	}
+	return "[fail]"
+}
+
+func granted(ok bool) string {
+	if ok {
+		return "granted"
+	}
+	return "NOT granted"
+}
+
+// ruleText is a rule of the evaluated policy and the connectives between
+// its conditions
+type ruleText struct {
+	label, selector, header string
+	joins                   []string
+}
+
+// ruleTexts splits the policy resp evaluated into its rules
+func ruleTexts(resp *client.PolicyResponse) []ruleText {
+	if resp == nil {
+		return nil
+	}
+	var rules []ruleText
+	var current []string
+	flush := func() {
+		text := strings.Join(current, " ")
+		current = nil
+		m := ruleHeader.FindStringSubmatch(text)
+		if m == nil {
+			return
+		}
+		header, conditions, _ := strings.Cut(text, " if ")
+		rules = append(rules, ruleText{label: m[1], selector: m[2], header: header, joins: joinsOf(conditions)})
+	}
+	for _, line := range resp.Rule {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flush()
+		case !strings.HasPrefix(trimmed, "#"):
+			current = append(current, trimmed)
+		}
+	}
+	flush()
+	return rules
+}
+
+// joinsOf lists the and or or before each condition of a rule's conditions,
+// skipping those inside quotes or lists; the first condition has none
+func joinsOf(conditions string) []string {
+	joins := []string{""}
+	depth, quoted := 0, false
+	for i := 0; i < len(conditions); i++ {
+		switch c := conditions[i]; {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth == 0:
+			m := connective.FindStringSubmatchIndex(conditions[i:])
+			if m == nil || m[0] != 0 {
+				continue
+			}
+			join := conditions[i+m[2] : i+m[3]]
+			// "is greater than or equal to" is one operator
+			if join == "or" && strings.HasSuffix(conditions[:i], "than") {
+				continue
+			}
+			joins = append(joins, join)
+			i += m[1] - 1
+		}
+	}
+	return joins
+}
+
+// joins returns the connective before each condition of rule, the i-th of
+// the trace, from the rule text it came from; without one that matches,
+// conditions are taken to be joined by and
+func joins(rule client.RuleTrace, i int, texts []ruleText) []string {
+	var match *ruleText
+	for j := range texts {
+		text := &texts[j]
+		if text.selector == rule.Selector.Value && (rule.Label != "" && text.label == rule.Label || rule.Label == "" && strings.Contains(text.header, rule.Outcome.Value)) {
+			match = text
+			break
+		}
+	}
+	if match == nil && i < len(texts) && texts[i].selector == rule.Selector.Value {
+		match = &texts[i]
+	}
+	if match != nil && len(match.joins) == len(rule.Conditions) {
+		return match.joins
+	}
+	joins := make([]string, len(rule.Conditions))
+	for j := 1; j < len(joins); j++ {
+		joins[j] = "and"
+	}
+	return joins
+}
@@ -0,0 +1,112 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"policy-engine-testcontainer-example/client"
+	"policy-engine-testcontainer-example/policydata"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	seniorRule   = "A **Person** gets senior_discount if the __age__ of the **Person** is greater than or equal to 65."
+	shippingRule = `An **Order** gets expedited_shipping if the __total__ of the **Order** is greater than 100 and the __membership_level__ of the **Customer** is in ["gold", "platinum"].`
+	accessRule   = `A **User** gets access if the __role__ of the **User** is equal to "admin".`
+	eitherRule   = `A **User** gets access if the __role__ of the **User** is equal to "admin" or the __role__ of the **User** is equal to "owner".`
+	driverRules  = "driver. A **Person** can drive if the **Person** is an adult and the __driving_hours__ of the **Person** is at least 20.\n\n" +
+		"adult. A **Person** is an adult if the __age__ of the **Person** is at least 18."
+)
+
+// recordedEngine answers every evaluation with the response recorded in
+// testdata/explain/name.json, as the engine sends it: with the rule lines it
+// evaluated, and status 400 for an error
+func recordedEngine(t *testing.T, name string) *client.PolicyClient {
+	raw, err := os.ReadFile(filepath.Join("testdata", "explain", name+".json"))
+	require.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req client.PolicyRequest
+		var response map[string]interface{}
+		if json.NewDecoder(r.Body).Decode(&req) != nil || json.Unmarshal(raw, &response) != nil {
+			http.Error(w, "bad fixture", http.StatusInternalServerError)
+			return
+		}
+		response["rule"] = strings.Split(req.Rule, "\n")
+		w.Header().Set("Content-Type", "application/json")
+		if _, failed := response["error"]; failed {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	c, err := client.New(server.URL)
+	require.NoError(t, err)
+	return c
+}
+
+// TestExplain tests the explanations of the example rules, passing and
+// failing, against their golden files
+func TestExplain(t *testing.T) {
+	for name, rule := range map[string]string{
+		"senior_granted":   seniorRule,
+		"senior_denied":    seniorRule,
+		"senior_missing":   seniorRule,
+		"shipping_granted": shippingRule,
+		"shipping_denied":  shippingRule,
+		"shipping_missing": shippingRule,
+		"access_granted":   accessRule,
+		"access_denied":    accessRule,
+		"access_either":    eitherRule,
+		"driver_denied":    driverRules,
+	} {
+		t.Run(name, func(t *testing.T) {
+			response, _ := recordedEngine(t, name).EvaluatePolicy(context.Background(), rule, map[string]interface{}{}, true)
+			require.NotNil(t, response)
+			golden(t, filepath.Join("explain", name+".txt"), Explain(nil, response)+"\n")
+		})
+	}
+}
+
+// TestExplainWithoutResponse tests explaining a trace alone, whose
+// conditions are taken to be joined by and, and a response without a trace
+func TestExplainWithoutResponse(t *testing.T) {
+	tr := loadTrace(t, "expedited_shipping.json")
+	assert.Equal(t, `expedited_shipping was NOT granted because membership_level of Customer ("silver") is not in ["gold", "platinum"].
+  [pass] total of Order (150) is greater than 100
+  [fail] and membership_level of Customer ("silver") is not in ["gold", "platinum"]`, Explain(tr, nil))
+
+	assert.Equal(t, "The result was true; the engine sent no trace.", Explain(nil, &client.PolicyResponse{Result: true}))
+	assert.Empty(t, Explain(nil, nil))
+}
+
+// TestExplainWithAliases tests that aliased properties are named by their
+// Go-side names too, in conditions and in the engine's error
+func TestExplainWithAliases(t *testing.T) {
+	aliases, err := policydata.NewAliasRegistry(policydata.Aliases{"Customer": {"Tier": "membership_level"}})
+	require.NoError(t, err)
+
+	tr := loadTrace(t, "expedited_shipping.json")
+	assert.Equal(t, `expedited_shipping was NOT granted because Tier (membership_level) of Customer ("silver") is not in ["gold", "platinum"].
+  [pass] total of Order (150) is greater than 100
+  [fail] and Tier (membership_level) of Customer ("silver") is not in ["gold", "platinum"]`, Explain(tr, nil, WithAliases(aliases)))
+
+	reason := "Property 'membership_level' not found in selector 'Customer'"
+	assert.Equal(t, "The policy could not be decided because Tier (membership_level) of Customer was not present in the supplied data.",
+		Explain(nil, &client.PolicyResponse{Error: &reason}, WithAliases(aliases)))
+	assert.Contains(t, Explain(tr, nil, WithAliases(nil)), " membership_level of Customer")
+}
+
+// TestJoinsOf tests reading the connectives of a rule's conditions
+func TestJoinsOf(t *testing.T) {
+	assert.Equal(t, []string{""}, joinsOf(`the __age__ of the **Person** is greater than or equal to 65.`))
+	assert.Equal(t, []string{"", "and"}, joinsOf(`the __total__ of the **Order** is greater than 100 and the __level__ of the **Customer** is in ["gold", "and or"].`))
+	assert.Equal(t, []string{"", "or", "and"}, joinsOf(`the __a__ of the **X** is equal to "b and c" or the __b__ of the **X** is less than or equal to 1 and the __c__ of the **X** is empty.`))
+}